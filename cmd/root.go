@@ -24,11 +24,16 @@ var cfgFile string
 
 var globalConfig *config.Config
 
-var errSIGHUPReloadUnsupported = errors.New("SIGHUP reload is unsupported")
+// readReloadConfig re-reads the merged configuration for a SIGHUP reload
+// without publishing it; the server publishes it once the reload commits.
+var readReloadConfig = func() (*config.Config, error) {
+	return config.Read(cfgFile)
+}
 
 type serverLifecycle interface {
 	Start(context.Context) error
 	Shutdown(context.Context) error
+	ReloadConfig(context.Context, *config.Config) error
 }
 
 func initConfig() error {
@@ -86,9 +91,10 @@ func Start() error {
 	return runServer(srv)
 }
 
-// runServer owns the process shutdown path: a signal triggers a graceful
-// shutdown, and a serving error cancels the root context and enters the
-// normal shutdown path. main remains the only process-exit boundary.
+// runServer owns the process shutdown path: SIGHUP reloads the configuration
+// in process, any other signal triggers a graceful shutdown, and a serving
+// error cancels the root context and enters the normal shutdown path. main
+// remains the only process-exit boundary.
 func runServer(srv *server.Server) error {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
@@ -105,24 +111,47 @@ func runServerWithSignals(srv serverLifecycle, signals <-chan os.Signal) error {
 		serveErr <- srv.Start(ctx)
 	}()
 
-	select {
-	case err := <-serveErr:
-		if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, http.ErrServerClosed) {
+	for {
+		select {
+		case err := <-serveErr:
+			if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return fmt.Errorf("server stopped: %w", err)
+		case received := <-signals:
+			if received == syscall.SIGHUP {
+				reloadServerConfig(ctx, srv)
+				continue
+			}
+			logger.Infof("received signal %s, shutting down", received)
+			shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 30*time.Second)
+			shutdownErr := srv.Shutdown(shutdownCtx)
+			shutdownCancel()
+			cancel()
+			if shutdownErr != nil {
+				return fmt.Errorf("graceful shutdown: %w", shutdownErr)
+			}
 			return nil
 		}
-		return fmt.Errorf("server stopped: %w", err)
-	case received := <-signals:
-		logger.Infof("received signal %s, shutting down", received)
-		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 30*time.Second)
-		defer shutdownCancel()
-		shutdownErr := srv.Shutdown(shutdownCtx)
-		cancel()
-		if shutdownErr != nil {
-			return fmt.Errorf("graceful shutdown: %w", shutdownErr)
-		}
-		if received == syscall.SIGHUP {
-			return errSIGHUPReloadUnsupported
-		}
-		return nil
 	}
 }
+
+// reloadServerConfig applies a SIGHUP reload. A configuration that fails to
+// load or apply is logged and the running configuration keeps serving.
+func reloadServerConfig(ctx context.Context, srv serverLifecycle) {
+	logger.Info("received signal hangup, reloading configuration")
+	cfg, err := readReloadConfig()
+	if err != nil {
+		logger.Errorf("reload configuration, keeping the running configuration: %s", err)
+		return
+	}
+	if err := srv.ReloadConfig(ctx, cfg); err != nil {
+		logger.Errorf("reload configuration, keeping the running configuration: %s", err)
+		return
+	}
+	globalConfig = cfg
+	if err := configureLogger(cfg); err != nil {
+		logger.Errorf("configure logger after reload: %s", err)
+	}
+	logger.Info("configuration reloaded")
+}
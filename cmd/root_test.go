@@ -20,6 +20,7 @@ import (
 type fakeServerLifecycle struct {
 	start        func(context.Context) error
 	shutdown     func(context.Context) error
+	reload       func(context.Context, *config.Config) error
 	startDone    chan struct{}
 	shutdownDone chan struct{}
 }
@@ -38,6 +39,10 @@ func (f *fakeServerLifecycle) Shutdown(ctx context.Context) error {
 	return f.shutdown(ctx)
 }
 
+func (f *fakeServerLifecycle) ReloadConfig(ctx context.Context, cfg *config.Config) error {
+	return f.reload(ctx, cfg)
+}
+
 func TestStartHasNoDebugBannerPrint(t *testing.T) {
 	source, err := os.ReadFile("root.go")
 	if err != nil {
@@ -165,17 +170,43 @@ func TestRunServerReturnsNilForTerminationSignals(t *testing.T) {
 	}
 }
 
-func TestRunServerReturnsUnsupportedReloadErrorAfterSIGHUP(t *testing.T) {
+func TestRunServerReloadsConfigOnSIGHUPAndKeepsServing(t *testing.T) {
+	previousRead, previousConfig := readReloadConfig, globalConfig
+	t.Cleanup(func() {
+		readReloadConfig, globalConfig = previousRead, previousConfig
+		_ = logger.ConfigureLevel("info")
+	})
+	rejected := errors.New("invalid merged config")
+	candidates := []struct {
+		cfg *config.Config
+		err error
+	}{
+		{err: rejected},
+		{cfg: &config.Config{NginxConfig: config.NginxConfig{ErrorLogLevel: "bad-apply"}}},
+		{cfg: &config.Config{NginxConfig: config.NginxConfig{ErrorLogLevel: "debug"}}},
+	}
+	readReloadConfig = func() (*config.Config, error) {
+		candidate := candidates[0]
+		candidates = candidates[1:]
+		return candidate.cfg, candidate.err
+	}
+
 	started := make(chan struct{})
-	shutdown := make(chan struct{})
+	reloaded := make(chan *config.Config, 3)
 	lifecycle := &fakeServerLifecycle{
-		startDone:    started,
-		shutdownDone: shutdown,
+		startDone: started,
 		start: func(ctx context.Context) error {
 			<-ctx.Done()
 			return ctx.Err()
 		},
 		shutdown: func(context.Context) error { return nil },
+		reload: func(_ context.Context, cfg *config.Config) error {
+			reloaded <- cfg
+			if cfg.NginxConfig.ErrorLogLevel == "bad-apply" {
+				return errors.New("bind failed")
+			}
+			return nil
+		},
 	}
 	signals := make(chan os.Signal, 1)
 	result := make(chan error, 1)
@@ -185,20 +216,40 @@ func TestRunServerReturnsUnsupportedReloadErrorAfterSIGHUP(t *testing.T) {
 	case <-time.After(time.Second):
 		t.Fatal("runServerWithSignals() did not start the server")
 	}
-	signals <- syscall.SIGHUP
 
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
 	select {
-	case err := <-result:
-		if !errors.Is(err, errSIGHUPReloadUnsupported) {
-			t.Fatalf("runServerWithSignals() error = %v, want %v", err, errSIGHUPReloadUnsupported)
+	case cfg := <-reloaded:
+		if cfg.NginxConfig.ErrorLogLevel != "bad-apply" {
+			t.Fatalf("ReloadConfig() received %#v, want the second candidate", cfg)
 		}
 	case <-time.After(time.Second):
-		t.Fatal("runServerWithSignals() did not return after SIGHUP")
+		t.Fatal("ReloadConfig() was not called for SIGHUP")
 	}
+	if globalConfig != previousConfig {
+		t.Fatal("globalConfig changed after a rejected reload")
+	}
+	signals <- syscall.SIGHUP
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("ReloadConfig() was not called for the third SIGHUP")
+	}
+	signals <- syscall.SIGTERM
 	select {
-	case <-shutdown:
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("runServerWithSignals() error = %v, want nil", err)
+		}
 	case <-time.After(time.Second):
-		t.Fatal("Shutdown() was not called for SIGHUP")
+		t.Fatal("runServerWithSignals() did not return after SIGTERM")
+	}
+	if globalConfig == nil || globalConfig.NginxConfig.ErrorLogLevel != "debug" {
+		t.Fatalf("globalConfig = %#v, want the applied reload", globalConfig)
+	}
+	if !logger.DebugEnabled() {
+		t.Fatal("logger level was not reconfigured from the reloaded config")
 	}
 }
 
@@ -221,16 +272,13 @@ func TestRunServerReturnsShutdownError(t *testing.T) {
 	case <-time.After(time.Second):
 		t.Fatal("runServerWithSignals() did not start the server")
 	}
-	signals <- syscall.SIGHUP
+	signals <- syscall.SIGTERM
 
 	select {
 	case err := <-result:
 		if !errors.Is(err, shutdownErr) {
 			t.Fatalf("runServerWithSignals() error = %v, want shutdown error %v", err, shutdownErr)
 		}
-		if errors.Is(err, errSIGHUPReloadUnsupported) {
-			t.Fatalf("runServerWithSignals() error = %v, want shutdown failure instead of reload sentinel", err)
-		}
 	case <-time.After(time.Second):
 		t.Fatal("runServerWithSignals() did not return after shutdown failure")
 	}
@@ -0,0 +1,526 @@
+// Package admin serves the APISIX v3 compatible Admin API under
+// /apisix/admin. Resources are checked with the Store decoders and the
+// registered plugin schemas before they are written to a Backend, so a write
+// the API accepts is one the runtime will build.
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/store"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+// Prefix is the path every Admin API resource is served under.
+const Prefix = "/apisix/admin"
+
+const (
+	defaultListenIP = "0.0.0.0"
+	maxBodySize     = 1 << 20
+	requestTimeout  = 10 * time.Second
+)
+
+// Handler serves the Admin API resource endpoints over a Backend.
+type Handler struct {
+	backend Backend
+	keys    []config.AdminKey
+	// keyRequired mirrors deployment.admin.admin_key_required.
+	keyRequired bool
+	allow       []*net.IPNet
+	cors        bool
+	// plugins limits plugin references to the enabled HTTP plugins; nil
+	// accepts every registered plugin.
+	plugins *plugin.EnabledSet
+	router  chi.Router
+	now     func() time.Time
+
+	// writeMu serializes read-modify-write sequences such as PATCH and the
+	// delete reference checks within this process.
+	writeMu sync.Mutex
+	idMu    sync.Mutex
+	lastID  int64
+}
+
+// NewHandler returns an Admin API handler configured from the deployment
+// admin section. enabledPlugins is the HTTP plugin allowlist; an empty list
+// accepts every registered plugin.
+func NewHandler(backend Backend, cfg config.Admin, enabledPlugins []string) (*Handler, error) {
+	if backend == nil {
+		return nil, errors.New("admin backend must not be nil")
+	}
+	h := &Handler{
+		backend:     backend,
+		keys:        cfg.AdminKey,
+		keyRequired: cfg.AdminKeyRequired,
+		cors:        cfg.EnableAdminCORS,
+		now:         time.Now,
+	}
+	for index, address := range cfg.AllowAdmin {
+		network, err := parseAllowAddress(strings.TrimSpace(address))
+		if err != nil {
+			return nil, fmt.Errorf("deployment.admin.allow_admin[%d]: %w", index, err)
+		}
+		h.allow = append(h.allow, network)
+	}
+	if len(enabledPlugins) > 0 {
+		enabled := plugin.NewEnabledSet(enabledPlugins)
+		h.plugins = &enabled
+	}
+
+	router := chi.NewRouter()
+	router.Use(h.restrictAddress, h.withCORS, h.authenticate)
+	router.Get(Prefix+"/{resource}", h.list)
+	router.Put(Prefix+"/{resource}", h.put)
+	router.Post(Prefix+"/{resource}", h.post)
+	router.Get(Prefix+"/{resource}/*", h.get)
+	router.Put(Prefix+"/{resource}/*", h.put)
+	router.Patch(Prefix+"/{resource}/*", h.patch)
+	router.Delete(Prefix+"/{resource}/*", h.delete)
+	router.NotFound(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	h.router = router
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
+// ListenAddress returns the address of deployment.admin.admin_listen.
+func ListenAddress(cfg config.Admin) string {
+	ip := cfg.AdminListen.IP
+	if ip == "" {
+		ip = defaultListenIP
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(cfg.AdminListen.Port))
+}
+
+// TLSConfig returns the listener TLS config for https_admin, or nil when the
+// Admin API is served over plain HTTP. A configured CA requires and verifies
+// client certificates.
+func TLSConfig(cfg config.Admin) (*tls.Config, error) {
+	if !cfg.HTTPSAdmin {
+		return nil, nil
+	}
+	mtls := cfg.AdminAPIMTLS
+	certificate, err := tls.LoadX509KeyPair(mtls.AdminSSLCert, mtls.AdminSSLCertKey)
+	if err != nil {
+		return nil, fmt.Errorf("load admin certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if mtls.AdminSSLCA != "" {
+		pem, err := os.ReadFile(mtls.AdminSSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("read admin CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("parse admin CA certificate %q", mtls.AdminSSLCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+type entryResponse struct {
+	Key           string          `json:"key"`
+	Value         json.RawMessage `json:"value"`
+	CreatedIndex  int64           `json:"createdIndex,omitempty"`
+	ModifiedIndex int64           `json:"modifiedIndex,omitempty"`
+}
+
+type listResponse struct {
+	Total int             `json:"total"`
+	List  []entryResponse `json:"list"`
+}
+
+type deleteResponse struct {
+	Key     string `json:"key"`
+	Deleted string `json:"deleted"`
+}
+
+func newEntryResponse(entry Entry) entryResponse {
+	return entryResponse{
+		Key:           entry.Key,
+		Value:         json.RawMessage(entry.Value),
+		CreatedIndex:  entry.CreatedIndex,
+		ModifiedIndex: entry.ModifiedIndex,
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	_ = util.WriteJSON(w, status, map[string]string{"error_msg": message})
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	_ = util.WriteJSONMessage(w, http.StatusNotFound, "Key not found")
+}
+
+// writeBackendError maps a Backend failure onto the Admin API status codes:
+// Store validation rejections are client errors, everything else is ours.
+func writeBackendError(w http.ResponseWriter, err error) {
+	var validationErr *store.ResourceValidationError
+	var batchErr *store.BatchValidationError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeNotFound(w)
+	case errors.As(err, &validationErr), errors.As(err, &batchErr):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		logger.Errorf("admin API backend error: %s", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// target resolves the bucket, resource ID and optional sub path of a
+// request. Secret IDs span two path segments: /secrets/{manager}/{id}.
+func target(r *http.Request) (bucket, id, subPath string, ok bool) {
+	bucket = chi.URLParam(r, "resource")
+	if _, known := kinds[bucket]; !known {
+		return "", "", "", false
+	}
+	rest := strings.Trim(chi.URLParam(r, "*"), "/")
+	if rest == "" {
+		return bucket, "", "", true
+	}
+	segments := strings.Split(rest, "/")
+	idSegments := 1
+	if bucket == "secrets" {
+		idSegments = 2
+		if len(segments) < idSegments {
+			return bucket, rest, "", true
+		}
+	}
+	return bucket, strings.Join(segments[:idSegments], "/"), strings.Join(segments[idSegments:], "/"), true
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	bucket, _, _, ok := target(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	entries, err := h.backend.List(r.Context(), bucket)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	total := len(entries)
+	if pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size")); pageSize > 0 {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		start := min((page-1)*pageSize, len(entries))
+		entries = entries[start:min(start+pageSize, len(entries))]
+	}
+	response := listResponse{Total: total, List: make([]entryResponse, 0, len(entries))}
+	for _, entry := range entries {
+		response.List = append(response.List, newEntryResponse(entry))
+	}
+	_ = util.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	bucket, id, subPath, ok := target(r)
+	if !ok || subPath != "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	entry, err := h.backend.Get(r.Context(), bucket, id)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, newEntryResponse(entry))
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	bucket, id, subPath, ok := target(r)
+	if !ok || subPath != "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	object, err := readObject(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if kind := kinds[bucket]; kind.idField != "" {
+		bodyID, _ := object[kind.idField].(string)
+		switch {
+		case id == "":
+			id = bodyID
+		case bodyID != "" && bodyID != id:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("wrong %s %s", kind.name, kind.idField))
+			return
+		}
+	}
+	if id == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("missing %s %s", kinds[bucket].name, kinds[bucket].idField))
+		return
+	}
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	h.store(w, r, bucket, id, object)
+}
+
+func (h *Handler) post(w http.ResponseWriter, r *http.Request) {
+	bucket, _, _, ok := target(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	kind := kinds[bucket]
+	if !kind.allowPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("POST is not supported for %s", bucket))
+		return
+	}
+	object, err := readObject(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := object[kind.idField]; ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("wrong %s id, do not need it", kind.name))
+		return
+	}
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	h.store(w, r, bucket, h.generateID(), object)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request) {
+	bucket, id, subPath, ok := target(r)
+	if !ok || id == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil || len(body) > maxBodySize {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	var patch any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %s", err))
+		return
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	current, err := h.backend.Get(r.Context(), bucket, id)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	var object map[string]any
+	if err := json.Unmarshal(current.Value, &object); err != nil || object == nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("decode stored %s %q", kinds[bucket].name, id))
+		return
+	}
+	if subPath == "" {
+		patchObject, ok := patch.(map[string]any)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid request body: expected JSON object")
+			return
+		}
+		object = mergePatch(object, patchObject).(map[string]any)
+	} else if err := setSubPath(object, subPath, patch); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.store(w, r, bucket, id, object)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	bucket, id, subPath, ok := target(r)
+	if !ok || id == "" || subPath != "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	for _, reference := range references[bucket] {
+		entries, err := h.backend.List(r.Context(), reference.bucket)
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+		if owner, ok := referencedBy(entries, reference.field, id); ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf(
+				"can not delete this %s, %s [%s] is still using it now",
+				kinds[bucket].name, kinds[reference.bucket].name, owner,
+			))
+			return
+		}
+	}
+	entry, err := h.backend.Get(r.Context(), bucket, id)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if err := h.backend.Delete(r.Context(), bucket, id); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, deleteResponse{Key: entry.Key, Deleted: "1"})
+}
+
+// store validates object as the full value of bucket/id and writes it. The
+// caller holds writeMu.
+func (h *Handler) store(w http.ResponseWriter, r *http.Request, bucket, id string, object map[string]any) {
+	if err := validateID(bucket, id); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+
+	status := http.StatusCreated
+	existing, err := h.backend.Get(ctx, bucket, id)
+	switch {
+	case err == nil:
+		status = http.StatusOK
+	case !errors.Is(err, ErrNotFound):
+		writeBackendError(w, err)
+		return
+	}
+	kind := kinds[bucket]
+	if kind.idField != "" {
+		object[kind.idField] = id
+	}
+	if kind.timestamps {
+		now := h.now().Unix()
+		object["create_time"] = now
+		if status == http.StatusOK {
+			var previous map[string]any
+			if json.Unmarshal(existing.Value, &previous) == nil && previous["create_time"] != nil {
+				object["create_time"] = previous["create_time"]
+			}
+		}
+		object["update_time"] = now
+	}
+	value, err := json.Marshal(object)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Round-trip so schema validation sees JSON types, not the int64 stamps.
+	var decoded map[string]any
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.validate(bucket, id, decoded, value); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entry, err := h.backend.Put(ctx, bucket, id, value)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	_ = util.WriteJSON(w, status, newEntryResponse(entry))
+}
+
+// generateID returns a zero padded, strictly increasing ID in the format
+// APISIX uses for POSTed resources.
+func (h *Handler) generateID() string {
+	h.idMu.Lock()
+	defer h.idMu.Unlock()
+	next := h.now().UnixNano()
+	if next <= h.lastID {
+		next = h.lastID + 1
+	}
+	h.lastID = next
+	return fmt.Sprintf("%020d", next)
+}
+
+func readObject(r *http.Request) (map[string]any, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if len(body) > maxBodySize {
+		return nil, errors.New("invalid request body: too large")
+	}
+	var object map[string]any
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if object == nil {
+		return nil, errors.New("invalid request body: expected JSON object")
+	}
+	return object, nil
+}
+
+// mergePatch applies an RFC 7396 JSON merge patch.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// setSubPath replaces the value at a slash separated path, as APISIX does
+// for PATCH /apisix/admin/{resource}/{id}/{path}.
+func setSubPath(object map[string]any, subPath string, value any) error {
+	segments := strings.Split(subPath, "/")
+	current := object
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]any)
+		if !ok {
+			if current[segment] != nil {
+				return fmt.Errorf("invalid sub path %q: %q is not an object", subPath, segment)
+			}
+			next = map[string]any{}
+			current[segment] = next
+		}
+		current = next
+	}
+	last := segments[len(segments)-1]
+	if value == nil {
+		delete(current, last)
+		return nil
+	}
+	current[last] = value
+	return nil
+}
@@ -0,0 +1,306 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+const testAdminKey = "edd1c9f034335f136f87ad84b625c8f1"
+
+type testAdmin struct {
+	t       *testing.T
+	handler *Handler
+	storage *store.Store
+	writes  []string
+}
+
+func newTestAdmin(t *testing.T, cfg config.Admin, plugins ...string) *testAdmin {
+	t.Helper()
+	events := make(chan *store.Event)
+	storage, err := store.Open(filepath.Join(t.TempDir(), "admin.db"), events)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	storage.Start()
+	t.Cleanup(func() { _ = storage.Stop() })
+
+	admin := &testAdmin{t: t, storage: storage}
+	backend := NewStoreBackend(storage, events, func(bucket string) error {
+		admin.writes = append(admin.writes, bucket)
+		return nil
+	})
+	if cfg.AdminKey == nil {
+		cfg.AdminKeyRequired = true
+		cfg.AdminKey = []config.AdminKey{{Name: "admin", Key: testAdminKey, Role: "admin"}}
+	}
+	handler, err := NewHandler(backend, cfg, plugins)
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	handler.now = func() time.Time { return time.Unix(1700000000, 0) }
+	admin.handler = handler
+	return admin
+}
+
+func (a *testAdmin) do(method, path, body string) (int, map[string]any) {
+	a.t.Helper()
+	request := httptest.NewRequest(method, "http://admin.test"+path, strings.NewReader(body))
+	request.Header.Set("X-API-KEY", testAdminKey)
+	recorder := httptest.NewRecorder()
+	a.handler.ServeHTTP(recorder, request)
+	var response map[string]any
+	if recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			a.t.Fatalf("%s %s response %q is not JSON: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code, response
+}
+
+func TestRouteLifecycle(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	status, response := admin.do(http.MethodPut, "/apisix/admin/routes/r1",
+		`{"uri": "/orders", "upstream": {"type": "roundrobin", "nodes": {"127.0.0.1:8080": 1}}}`)
+	if status != http.StatusCreated {
+		t.Fatalf("PUT status = %d, body %v", status, response)
+	}
+	value := response["value"].(map[string]any)
+	if response["key"] != "/apisix/routes/r1" || value["id"] != "r1" || value["create_time"] != float64(1700000000) {
+		t.Fatalf("PUT response = %v, want stored route with id and timestamps", response)
+	}
+	stored, err := admin.storage.GetFromBucket("routes", []byte("r1"))
+	if err != nil || !strings.Contains(string(stored), `"/orders"`) {
+		t.Fatalf("stored route = (%s, %v), want acknowledged write", stored, err)
+	}
+	if len(admin.writes) != 1 || admin.writes[0] != "routes" {
+		t.Fatalf("afterWrite buckets = %v, want [routes]", admin.writes)
+	}
+
+	admin.handler.now = func() time.Time { return time.Unix(1700000100, 0) }
+	status, response = admin.do(http.MethodPatch, "/apisix/admin/routes/r1", `{"uri": "/payments", "desc": "moved"}`)
+	value = response["value"].(map[string]any)
+	if status != http.StatusOK || value["uri"] != "/payments" || value["desc"] != "moved" ||
+		value["create_time"] != float64(1700000000) || value["update_time"] != float64(1700000100) {
+		t.Fatalf("PATCH = (%d, %v), want merged route with preserved create_time", status, response)
+	}
+	status, response = admin.do(http.MethodPatch, "/apisix/admin/routes/r1/upstream/nodes",
+		`{"127.0.0.1:9090": 2}`)
+	nodes := response["value"].(map[string]any)["upstream"].(map[string]any)["nodes"].(map[string]any)
+	if status != http.StatusOK || len(nodes) != 1 || nodes["127.0.0.1:9090"] != float64(2) {
+		t.Fatalf("PATCH sub path = (%d, %v), want replaced nodes", status, response)
+	}
+
+	status, response = admin.do(http.MethodGet, "/apisix/admin/routes", "")
+	if status != http.StatusOK || response["total"] != float64(1) {
+		t.Fatalf("GET list = (%d, %v), want one route", status, response)
+	}
+	status, response = admin.do(http.MethodDelete, "/apisix/admin/routes/r1", "")
+	if status != http.StatusOK || response["deleted"] != "1" || response["key"] != "/apisix/routes/r1" {
+		t.Fatalf("DELETE = (%d, %v)", status, response)
+	}
+	if status, response = admin.do(http.MethodGet, "/apisix/admin/routes/r1", ""); status != http.StatusNotFound ||
+		response["message"] != "Key not found" {
+		t.Fatalf("GET deleted route = (%d, %v), want 404", status, response)
+	}
+	if status, _ = admin.do(http.MethodDelete, "/apisix/admin/routes/r1", ""); status != http.StatusNotFound {
+		t.Fatalf("DELETE missing route status = %d, want 404", status)
+	}
+}
+
+func TestPutTakesIDFromBodyAndRejectsMismatch(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	status, response := admin.do(http.MethodPut, "/apisix/admin/consumers", `{"username": "jack"}`)
+	if status != http.StatusCreated || response["key"] != "/apisix/consumers/jack" {
+		t.Fatalf("PUT consumer = (%d, %v), want username as id", status, response)
+	}
+	status, response = admin.do(http.MethodPut, "/apisix/admin/upstreams/u1", `{"id": "u2", "nodes": []}`)
+	if status != http.StatusBadRequest || !strings.Contains(response["error_msg"].(string), "wrong upstream id") {
+		t.Fatalf("PUT mismatched id = (%d, %v), want 400", status, response)
+	}
+	status, _ = admin.do(http.MethodPut, "/apisix/admin/upstreams", `{"nodes": []}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("PUT without id status = %d, want 400", status)
+	}
+	status, _ = admin.do(http.MethodPut, "/apisix/admin/upstreams/bad%20id", `{"nodes": []}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("PUT invalid id status = %d, want 400", status)
+	}
+}
+
+func TestPostGeneratesIncreasingIDs(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	var keys []string
+	for range 2 {
+		status, response := admin.do(http.MethodPost, "/apisix/admin/upstreams",
+			`{"type": "roundrobin", "nodes": {"127.0.0.1:8080": 1}}`)
+		if status != http.StatusCreated {
+			t.Fatalf("POST status = %d, body %v", status, response)
+		}
+		keys = append(keys, response["key"].(string))
+	}
+	if keys[0] == keys[1] || len(strings.TrimPrefix(keys[0], "/apisix/upstreams/")) != 20 {
+		t.Fatalf("POST keys = %v, want distinct 20 digit ids", keys)
+	}
+	if status, _ := admin.do(http.MethodPost, "/apisix/admin/upstreams", `{"id": "u1"}`); status != http.StatusBadRequest {
+		t.Fatalf("POST with id status = %d, want 400", status)
+	}
+	if status, _ := admin.do(http.MethodPost, "/apisix/admin/consumers", `{}`); status != http.StatusMethodNotAllowed {
+		t.Fatalf("POST consumer status = %d, want 405", status)
+	}
+}
+
+func TestPutValidatesPluginsAgainstSchemas(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{}, "proxy-rewrite", "key-auth")
+
+	for _, test := range []struct {
+		name    string
+		path    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "schema violation",
+			path:    "/apisix/admin/routes/r1",
+			body:    `{"uri": "/", "plugins": {"proxy-rewrite": {"uri": 1}}}`,
+			wantErr: "failed to check the configuration of plugin proxy-rewrite",
+		},
+		{
+			name:    "unregistered plugin",
+			path:    "/apisix/admin/global_rules/g1",
+			body:    `{"plugins": {"no-such-plugin": {}}}`,
+			wantErr: "unknown plugin [no-such-plugin]",
+		},
+		{
+			name:    "disabled plugin",
+			path:    "/apisix/admin/plugin_configs/p1",
+			body:    `{"plugins": {"cors": {}}}`,
+			wantErr: "unknown plugin [cors]",
+		},
+		{
+			name:    "store decoder",
+			path:    "/apisix/admin/services/s1",
+			body:    `{"plugins": []}`,
+			wantErr: `decode service "s1"`,
+		},
+		{
+			name:    "consumer credential schema",
+			path:    "/apisix/admin/consumers/jack",
+			body:    `{"plugins": {"key-auth": {}}}`,
+			wantErr: "key-auth consumer configuration",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			status, response := admin.do(http.MethodPut, test.path, test.body)
+			message, _ := response["error_msg"].(string)
+			if status != http.StatusBadRequest || !strings.Contains(message, test.wantErr) {
+				t.Fatalf("PUT = (%d, %v), want 400 containing %q", status, response, test.wantErr)
+			}
+		})
+	}
+	if len(admin.writes) != 0 {
+		t.Fatalf("rejected writes reached the store: %v", admin.writes)
+	}
+
+	status, response := admin.do(http.MethodPut, "/apisix/admin/routes/r1",
+		`{"uri": "/", "plugins": {"proxy-rewrite": {"uri": "/v2", "_meta": {"disable": true}}}}`)
+	if status != http.StatusCreated {
+		t.Fatalf("PUT valid plugin with _meta = (%d, %v), want 201", status, response)
+	}
+	status, response = admin.do(http.MethodPut, "/apisix/admin/consumers/jack",
+		`{"plugins": {"key-auth": {"key": "auth-jack"}}}`)
+	if status != http.StatusCreated {
+		t.Fatalf("PUT consumer credential = (%d, %v), want 201", status, response)
+	}
+}
+
+func TestPluginMetadataUsesMetadataSchema(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	status, response := admin.do(http.MethodPut, "/apisix/admin/plugin_metadata/http-logger",
+		`{"log_format": "not-an-object"}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("PUT invalid metadata = (%d, %v), want 400", status, response)
+	}
+	status, response = admin.do(http.MethodPut, "/apisix/admin/plugin_metadata/http-logger",
+		`{"log_format": {"host": "$host"}}`)
+	if status != http.StatusCreated {
+		t.Fatalf("PUT metadata = (%d, %v), want 201", status, response)
+	}
+	if _, hasID := response["value"].(map[string]any)["id"]; hasID {
+		t.Fatalf("plugin metadata value = %v, want the body as stored", response["value"])
+	}
+	if status, _ = admin.do(http.MethodPut, "/apisix/admin/plugin_metadata/no-such-plugin", `{}`); status != http.StatusBadRequest {
+		t.Fatalf("PUT metadata for unknown plugin status = %d, want 400", status)
+	}
+}
+
+func TestDeleteRejectsReferencedResources(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	if status, response := admin.do(http.MethodPut, "/apisix/admin/upstreams/u1",
+		`{"type": "roundrobin", "nodes": {"127.0.0.1:8080": 1}}`); status != http.StatusCreated {
+		t.Fatalf("PUT upstream = (%d, %v)", status, response)
+	}
+	if status, response := admin.do(http.MethodPut, "/apisix/admin/routes/r1",
+		`{"uri": "/", "upstream_id": "u1"}`); status != http.StatusCreated {
+		t.Fatalf("PUT route = (%d, %v)", status, response)
+	}
+	status, response := admin.do(http.MethodDelete, "/apisix/admin/upstreams/u1", "")
+	if status != http.StatusBadRequest ||
+		response["error_msg"] != "can not delete this upstream, route [r1] is still using it now" {
+		t.Fatalf("DELETE referenced upstream = (%d, %v), want 400", status, response)
+	}
+	admin.do(http.MethodDelete, "/apisix/admin/routes/r1", "")
+	if status, response = admin.do(http.MethodDelete, "/apisix/admin/upstreams/u1", ""); status != http.StatusOK {
+		t.Fatalf("DELETE released upstream = (%d, %v), want 200", status, response)
+	}
+}
+
+func TestSecretsUseManagerScopedIDs(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+
+	status, response := admin.do(http.MethodPut, "/apisix/admin/secrets/vault/team",
+		`{"uri": "http://127.0.0.1:8200", "prefix": "kv/apisix", "token": "root"}`)
+	if status != http.StatusCreated || response["key"] != "/apisix/secrets/vault/team" {
+		t.Fatalf("PUT secret = (%d, %v)", status, response)
+	}
+	if stored, _ := admin.storage.GetFromBucket("secrets", []byte("vault/team")); stored == nil {
+		t.Fatal("secret was not stored under its manager scoped id")
+	}
+	if status, response = admin.do(http.MethodGet, "/apisix/admin/secrets/vault/team", ""); status != http.StatusOK {
+		t.Fatalf("GET secret = (%d, %v)", status, response)
+	}
+	if status, _ = admin.do(http.MethodPut, "/apisix/admin/secrets/aws/team", `{}`); status != http.StatusBadRequest {
+		t.Fatalf("PUT unsupported manager status = %d, want 400", status)
+	}
+}
+
+func TestListPaginatesSortedEntries(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{})
+	for _, id := range []string{"c", "a", "b"} {
+		if status, response := admin.do(http.MethodPut, "/apisix/admin/protos/"+id,
+			`{"content": "syntax = \"proto3\";"}`); status != http.StatusCreated {
+			t.Fatalf("PUT proto %s = (%d, %v)", id, status, response)
+		}
+	}
+	status, response := admin.do(http.MethodGet, "/apisix/admin/protos?page=2&page_size=2", "")
+	list := response["list"].([]any)
+	if status != http.StatusOK || response["total"] != float64(3) || len(list) != 1 ||
+		list[0].(map[string]any)["key"] != "/apisix/protos/c" {
+		t.Fatalf("GET page 2 = (%d, %v), want the last sorted proto", status, response)
+	}
+	if status, _ = admin.do(http.MethodGet, "/apisix/admin/unknown", ""); status != http.StatusNotFound {
+		t.Fatalf("GET unknown resource status = %d, want 404", status)
+	}
+}
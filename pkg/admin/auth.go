@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const apiKeyHeader = "X-API-KEY"
+
+func parseAllowAddress(address string) (*net.IPNet, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return nil, fmt.Errorf("must be a valid CIDR or IP address")
+	}
+	return network, nil
+}
+
+// restrictAddress enforces allow_admin against the connection peer. An empty
+// list allows every address.
+func (h *Handler) restrictAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.allow) > 0 && !h.allowed(r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.cors {
+			next.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", "*")
+		header.Set("Access-Control-Allow-Credentials", "true")
+		header.Set("Access-Control-Expose-Headers", "*")
+		header.Set("Access-Control-Max-Age", "3600")
+		if r.Method == http.MethodOptions {
+			header.Set("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE, OPTIONS")
+			header.Set("Access-Control-Allow-Headers", "*")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the admin key from the X-API-KEY header, the api_key
+// query argument or the X-API-KEY cookie, in that order. Viewer keys may only
+// read.
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.keyRequired {
+			next.ServeHTTP(w, r)
+			return
+		}
+		role, ok := h.role(requestKey(r))
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "failed to check token")
+			return
+		}
+		if role == "viewer" && r.Method != http.MethodGet {
+			writeError(w, http.StatusUnauthorized, "invalid method for role viewer")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		return key
+	}
+	if cookie, err := r.Cookie(apiKeyHeader); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (h *Handler) role(key string) (string, bool) {
+	if strings.TrimSpace(key) == "" {
+		return "", false
+	}
+	for _, configured := range h.keys {
+		if subtle.ConstantTimeCompare([]byte(configured.Key), []byte(key)) == 1 {
+			return configured.Role, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+)
+
+func TestAuthenticateChecksKeySourcesAndRoles(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{
+		AdminKeyRequired: true,
+		AdminKey: []config.AdminKey{
+			{Name: "admin", Key: "admin-key", Role: "admin"},
+			{Name: "viewer", Key: "viewer-key", Role: "viewer"},
+		},
+	})
+	serve := func(method string, prepare func(*http.Request)) int {
+		request := httptest.NewRequest(method, "http://admin.test/apisix/admin/routes", nil)
+		prepare(request)
+		recorder := httptest.NewRecorder()
+		admin.handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for _, test := range []struct {
+		name    string
+		method  string
+		prepare func(*http.Request)
+		want    int
+	}{
+		{name: "missing key", method: http.MethodGet, prepare: func(*http.Request) {}, want: http.StatusUnauthorized},
+		{
+			name:    "wrong key",
+			method:  http.MethodGet,
+			prepare: func(r *http.Request) { r.Header.Set("X-API-KEY", "guess") },
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "header",
+			method:  http.MethodGet,
+			prepare: func(r *http.Request) { r.Header.Set("X-API-KEY", "admin-key") },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "query",
+			method:  http.MethodGet,
+			prepare: func(r *http.Request) { r.URL.RawQuery = "api_key=admin-key" },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "cookie",
+			method:  http.MethodGet,
+			prepare: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "X-API-KEY", Value: "viewer-key"}) },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "viewer write",
+			method:  http.MethodPost,
+			prepare: func(r *http.Request) { r.Header.Set("X-API-KEY", "viewer-key") },
+			want:    http.StatusUnauthorized,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := serve(test.method, test.prepare); got != test.want {
+				t.Fatalf("status = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSkippedWhenKeyNotRequired(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{AdminKey: []config.AdminKey{}})
+	recorder := httptest.NewRecorder()
+	admin.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://admin.test/apisix/admin/routes", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 without admin_key_required", recorder.Code)
+	}
+}
+
+func TestAllowAdminRestrictsPeersAndCORSAnswersPreflight(t *testing.T) {
+	admin := newTestAdmin(t, config.Admin{
+		AdminKey:        []config.AdminKey{},
+		AllowAdmin:      []string{"127.0.0.0/24", "::1"},
+		EnableAdminCORS: true,
+	})
+	serve := func(method, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "http://admin.test/apisix/admin/routes", nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		admin.handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if got := serve(http.MethodGet, "10.0.0.1:4000").Code; got != http.StatusForbidden {
+		t.Fatalf("status from a denied peer = %d, want 403", got)
+	}
+	if got := serve(http.MethodGet, "[::1]:4000").Code; got != http.StatusOK {
+		t.Fatalf("status from an allowed IPv6 peer = %d, want 200", got)
+	}
+	preflight := serve(http.MethodOptions, "127.0.0.9:4000")
+	if preflight.Code != http.StatusOK || preflight.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		preflight.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Fatalf("preflight = (%d, %v), want CORS headers", preflight.Code, preflight.Header())
+	}
+
+	if _, err := NewHandler(admin.handler.backend, config.Admin{AllowAdmin: []string{"10.0.0.0/33"}}, nil); err == nil {
+		t.Fatal("NewHandler() with an invalid allow_admin entry error = nil")
+	}
+}
@@ -0,0 +1,191 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/wklken/apisix-go/pkg/store"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ErrNotFound is returned by a Backend when the requested resource does not
+// exist.
+var ErrNotFound = errors.New("key not found")
+
+// Entry is one stored resource as the Admin API reports it.
+type Entry struct {
+	ID            string
+	Key           string
+	Value         []byte
+	CreatedIndex  int64
+	ModifiedIndex int64
+}
+
+// Backend persists Admin API resources. Bucket names match the Store buckets
+// and secret IDs carry their manager, e.g. "vault/1".
+type Backend interface {
+	List(ctx context.Context, bucket string) ([]Entry, error)
+	Get(ctx context.Context, bucket, id string) (Entry, error)
+	Put(ctx context.Context, bucket, id string, value []byte) (Entry, error)
+	Delete(ctx context.Context, bucket, id string) error
+}
+
+// StoreBackend writes acknowledged batches into a local Store. It serves the
+// standalone providers, where no etcd watcher feeds the Store.
+type StoreBackend struct {
+	storage    *store.Store
+	events     chan *store.Event
+	afterWrite func(bucket string) error
+}
+
+// NewStoreBackend returns a backend over storage. afterWrite runs after each
+// acknowledged write so the caller can republish the affected runtime.
+func NewStoreBackend(storage *store.Store, events chan *store.Event, afterWrite func(bucket string) error) *StoreBackend {
+	return &StoreBackend{storage: storage, events: events, afterWrite: afterWrite}
+}
+
+func (b *StoreBackend) List(_ context.Context, bucket string) ([]Entry, error) {
+	snapshot, err := b.storage.SnapshotBuckets([]string{bucket})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(snapshot[bucket]))
+	for id, value := range snapshot[bucket] {
+		entries = append(entries, Entry{ID: id, Key: storeKey(bucket, id), Value: value})
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (b *StoreBackend) Get(_ context.Context, bucket, id string) (Entry, error) {
+	value, err := b.storage.GetFromBucket(bucket, []byte(id))
+	if err != nil {
+		return Entry{}, err
+	}
+	if value == nil {
+		return Entry{}, ErrNotFound
+	}
+	return Entry{ID: id, Key: storeKey(bucket, id), Value: value}, nil
+}
+
+func (b *StoreBackend) Put(ctx context.Context, bucket, id string, value []byte) (Entry, error) {
+	err := b.apply(ctx, bucket, store.Mutation{Type: store.EventTypePut, Key: []byte(storeKey(bucket, id)), Value: value})
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: id, Key: storeKey(bucket, id), Value: value}, nil
+}
+
+func (b *StoreBackend) Delete(ctx context.Context, bucket, id string) error {
+	if _, err := b.Get(ctx, bucket, id); err != nil {
+		return err
+	}
+	return b.apply(ctx, bucket, store.Mutation{Type: store.EventTypeDelete, Key: []byte(storeKey(bucket, id))})
+}
+
+func (b *StoreBackend) apply(ctx context.Context, bucket string, mutation store.Mutation) error {
+	event := store.NewAcknowledgedBatch([]store.Mutation{mutation}, store.BatchOptions{})
+	select {
+	case b.events <- event:
+	case <-ctx.Done():
+		store.PutBack(event)
+		return ctx.Err()
+	}
+	if err := event.Wait(ctx); err != nil {
+		return err
+	}
+	if b.afterWrite != nil {
+		if err := b.afterWrite(bucket); err != nil {
+			return fmt.Errorf("publish %s change: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+func storeKey(bucket, id string) string {
+	return "/apisix/" + bucket + "/" + id
+}
+
+// EtcdBackend writes resources to etcd under the configured prefix. The
+// etcd watcher applies the change to the Store like any other writer's.
+type EtcdBackend struct {
+	kv     clientv3.KV
+	prefix string
+}
+
+// NewEtcdBackend returns a backend over kv. prefix is the canonical etcd
+// configuration prefix and must end in a slash.
+func NewEtcdBackend(kv clientv3.KV, prefix string) *EtcdBackend {
+	return &EtcdBackend{kv: kv, prefix: prefix}
+}
+
+func (b *EtcdBackend) List(ctx context.Context, bucket string) ([]Entry, error) {
+	bucketPrefix := b.prefix + bucket + "/"
+	resp, err := b.kv.Get(ctx, bucketPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		id := strings.TrimPrefix(string(kv.Key), bucketPrefix)
+		depth := strings.Count(id, "/")
+		if id == "" || (bucket == "secrets" && depth != 1) || (bucket != "secrets" && depth != 0) {
+			continue
+		}
+		entries = append(entries, Entry{
+			ID:            id,
+			Key:           string(kv.Key),
+			Value:         kv.Value,
+			CreatedIndex:  kv.CreateRevision,
+			ModifiedIndex: kv.ModRevision,
+		})
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+func (b *EtcdBackend) Get(ctx context.Context, bucket, id string) (Entry, error) {
+	key := b.prefix + bucket + "/" + id
+	resp, err := b.kv.Get(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return Entry{}, ErrNotFound
+	}
+	kv := resp.Kvs[0]
+	return Entry{ID: id, Key: key, Value: kv.Value, CreatedIndex: kv.CreateRevision, ModifiedIndex: kv.ModRevision}, nil
+}
+
+func (b *EtcdBackend) Put(ctx context.Context, bucket, id string, value []byte) (Entry, error) {
+	key := b.prefix + bucket + "/" + id
+	resp, err := b.kv.Put(ctx, key, string(value), clientv3.WithPrevKV())
+	if err != nil {
+		return Entry{}, err
+	}
+	entry := Entry{ID: id, Key: key, Value: value, CreatedIndex: resp.Header.Revision, ModifiedIndex: resp.Header.Revision}
+	if resp.PrevKv != nil {
+		entry.CreatedIndex = resp.PrevKv.CreateRevision
+	}
+	return entry, nil
+}
+
+func (b *EtcdBackend) Delete(ctx context.Context, bucket, id string) error {
+	resp, err := b.kv.Delete(ctx, b.prefix+bucket+"/"+id)
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func sortEntries(entries []Entry) {
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// memoryKV implements the clientv3.KV calls the etcd backend uses.
+type memoryKV struct {
+	clientv3.KV
+	revision int64
+	values   map[string]*mvccpb.KeyValue
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{values: map[string]*mvccpb.KeyValue{}}
+}
+
+func (m *memoryKV) Get(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: m.revision}}
+	for stored, kv := range m.values {
+		if stored == key || (len(op.RangeBytes()) > 0 && strings.HasPrefix(stored, key)) {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+	}
+	return resp, nil
+}
+
+func (m *memoryKV) Put(_ context.Context, key, value string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	m.revision++
+	resp := &clientv3.PutResponse{Header: &etcdserverpb.ResponseHeader{Revision: m.revision}}
+	created := m.revision
+	if previous, ok := m.values[key]; ok {
+		resp.PrevKv = previous
+		created = previous.CreateRevision
+	}
+	m.values[key] = &mvccpb.KeyValue{
+		Key:            []byte(key),
+		Value:          []byte(value),
+		CreateRevision: created,
+		ModRevision:    m.revision,
+	}
+	return resp, nil
+}
+
+func (m *memoryKV) Delete(_ context.Context, key string, _ ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp := &clientv3.DeleteResponse{Header: &etcdserverpb.ResponseHeader{Revision: m.revision}}
+	if _, ok := m.values[key]; ok {
+		m.revision++
+		delete(m.values, key)
+		resp.Deleted = 1
+	}
+	return resp, nil
+}
+
+func TestEtcdBackendUsesPrefixAndRevisions(t *testing.T) {
+	kv := newMemoryKV()
+	backend := NewEtcdBackend(kv, "/custom/")
+	ctx := context.Background()
+
+	created, err := backend.Put(ctx, "routes", "r1", []byte(`{"id":"r1"}`))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	updated, err := backend.Put(ctx, "routes", "r1", []byte(`{"id":"r1","uri":"/"}`))
+	if err != nil {
+		t.Fatalf("Put() update error = %v", err)
+	}
+	if created.Key != "/custom/routes/r1" || updated.CreatedIndex != created.CreatedIndex ||
+		updated.ModifiedIndex <= created.ModifiedIndex {
+		t.Fatalf("Put() entries = %#v then %#v, want stable create and advancing modify revisions", created, updated)
+	}
+
+	if _, err := backend.Put(ctx, "secrets", "vault/team", []byte(`{}`)); err != nil {
+		t.Fatalf("Put() secret error = %v", err)
+	}
+	if _, err := backend.Put(ctx, "routes", "r1/nested", []byte(`{}`)); err != nil {
+		t.Fatalf("Put() nested error = %v", err)
+	}
+	routes, err := backend.List(ctx, "routes")
+	if err != nil || len(routes) != 1 || routes[0].ID != "r1" {
+		t.Fatalf("List(routes) = (%#v, %v), want only direct children", routes, err)
+	}
+	secrets, err := backend.List(ctx, "secrets")
+	if err != nil || len(secrets) != 1 || secrets[0].ID != "vault/team" {
+		t.Fatalf("List(secrets) = (%#v, %v), want manager scoped id", secrets, err)
+	}
+
+	if err := backend.Delete(ctx, "routes", "r1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, "routes", "r1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() deleted error = %v, want ErrNotFound", err)
+	}
+	if err := backend.Delete(ctx, "routes", "r1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete() missing error = %v, want ErrNotFound", err)
+	}
+}
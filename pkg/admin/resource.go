@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/store"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+// kind describes how one Admin API resource maps onto its Store bucket.
+type kind struct {
+	// name is the singular form used in error messages.
+	name string
+	// idField is the value field that carries the resource ID. Plugin
+	// metadata has none: its value is validated against the metadata schema
+	// as stored.
+	idField string
+	// allowPost permits server-generated IDs.
+	allowPost bool
+	// timestamps maintains create_time and update_time.
+	timestamps bool
+	// plugins validates the value's plugins against the HTTP plugin schemas.
+	plugins bool
+}
+
+var kinds = map[string]kind{
+	"routes":          {name: "route", idField: "id", allowPost: true, timestamps: true, plugins: true},
+	"services":        {name: "service", idField: "id", allowPost: true, timestamps: true, plugins: true},
+	"upstreams":       {name: "upstream", idField: "id", allowPost: true, timestamps: true},
+	"consumers":       {name: "consumer", idField: "username", timestamps: true, plugins: true},
+	"consumer_groups": {name: "consumer group", idField: "id", timestamps: true, plugins: true},
+	"ssls":            {name: "ssl", idField: "id", allowPost: true, timestamps: true},
+	"global_rules":    {name: "global rule", idField: "id", timestamps: true, plugins: true},
+	"plugin_configs":  {name: "plugin config", idField: "id", timestamps: true, plugins: true},
+	"plugin_metadata": {name: "plugin metadata"},
+	"stream_routes":   {name: "stream route", idField: "id", allowPost: true, timestamps: true},
+	"secrets":         {name: "secret", idField: "id", timestamps: true},
+	"protos":          {name: "proto", idField: "id", allowPost: true, timestamps: true},
+}
+
+// references lists the resources that must be deleted before the keyed
+// resource, matching the APISIX delete checks.
+var references = map[string][]struct {
+	bucket string
+	field  string
+}{
+	"upstreams": {
+		{bucket: "routes", field: "upstream_id"},
+		{bucket: "services", field: "upstream_id"},
+		{bucket: "stream_routes", field: "upstream_id"},
+	},
+	"services": {
+		{bucket: "routes", field: "service_id"},
+		{bucket: "stream_routes", field: "service_id"},
+	},
+	"plugin_configs":  {{bucket: "routes", field: "plugin_config_id"}},
+	"consumer_groups": {{bucket: "consumers", field: "group_id"}},
+}
+
+// secretManagers lists the managers the runtime resolves $secret:// against.
+var secretManagers = []string{"vault"}
+
+// consumerCredentialPlugins carry consumer-side credential configs, which the
+// Store validates against their consumer schemas instead of the route schema.
+var consumerCredentialPlugins = []string{
+	"key-auth", "basic-auth", "jwt-auth", "hmac-auth", "ldap-auth", "wolf-rbac", "jwe-decrypt",
+}
+
+var (
+	idPattern       = regexp.MustCompile(`^[a-zA-Z0-9-_.]{1,64}$`)
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,100}$`)
+)
+
+func validateID(bucket, id string) error {
+	switch bucket {
+	case "consumers":
+		if !usernamePattern.MatchString(id) {
+			return fmt.Errorf("invalid consumer username %q", id)
+		}
+	case "plugin_metadata":
+		if plugin.New(id) == nil {
+			return fmt.Errorf("unknown plugin [%s]", id)
+		}
+	case "secrets":
+		manager, secretID, ok := strings.Cut(id, "/")
+		if !ok || !slices.Contains(secretManagers, manager) {
+			return fmt.Errorf("unsupported secret manager %q", manager)
+		}
+		if !idPattern.MatchString(secretID) {
+			return fmt.Errorf("invalid secret id %q", secretID)
+		}
+	default:
+		if !idPattern.MatchString(id) {
+			return fmt.Errorf("invalid %s id %q", kinds[bucket].name, id)
+		}
+	}
+	return nil
+}
+
+// validate checks a resource value the way the runtime will decode it: the
+// Store decoders first, then every referenced plugin's schema.
+func (h *Handler) validate(bucket, id string, object map[string]any, value []byte) error {
+	if err := store.ValidateResource(bucket, id, value); err != nil {
+		return err
+	}
+	if bucket == "plugin_metadata" {
+		return validatePluginMetadata(id, object)
+	}
+	if !kinds[bucket].plugins {
+		return nil
+	}
+	rawPlugins, ok := object["plugins"]
+	if !ok || rawPlugins == nil {
+		return nil
+	}
+	plugins, ok := rawPlugins.(map[string]any)
+	if !ok {
+		return fmt.Errorf("plugins must be an object")
+	}
+	for name, config := range plugins {
+		if bucket == "consumers" && slices.Contains(consumerCredentialPlugins, name) {
+			continue
+		}
+		if err := h.validatePlugin(name, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) validatePlugin(name string, config any) error {
+	if h.plugins != nil && !h.plugins.Contains(name) {
+		return fmt.Errorf("unknown plugin [%s]", name)
+	}
+	p := plugin.New(name)
+	if p == nil {
+		return fmt.Errorf("unknown plugin [%s]", name)
+	}
+	if err := p.Init(); err != nil {
+		return fmt.Errorf("initialize plugin %s: %w", name, err)
+	}
+	if values, ok := config.(map[string]any); ok {
+		if _, hasMeta := values["_meta"]; hasMeta {
+			stripped := make(map[string]any, len(values)-1)
+			for key, value := range values {
+				if key != "_meta" {
+					stripped[key] = value
+				}
+			}
+			config = stripped
+		}
+	}
+	compiled, err := util.CompileSchema(p.GetSchema())
+	if err != nil {
+		return fmt.Errorf("failed to check the configuration of plugin %s: %w", name, err)
+	}
+	if err := compiled.Validate(config); err != nil {
+		return fmt.Errorf("failed to check the configuration of plugin %s err: %w", name, err)
+	}
+	return nil
+}
+
+func validatePluginMetadata(name string, object map[string]any) error {
+	p := plugin.New(name)
+	if err := p.Init(); err != nil {
+		return fmt.Errorf("initialize plugin %s: %w", name, err)
+	}
+	schema := p.GetMetadataSchema()
+	if schema == "" {
+		return fmt.Errorf("no metadata schema for plugin %s", name)
+	}
+	compiled, err := util.CompileSchema(schema)
+	if err != nil {
+		return fmt.Errorf("failed to check the metadata of plugin %s: %w", name, err)
+	}
+	if err := compiled.Validate(object); err != nil {
+		return fmt.Errorf("failed to check the metadata of plugin %s err: %w", name, err)
+	}
+	return nil
+}
+
+// referencedBy returns the first resource that still points at id.
+func referencedBy(entries []Entry, field, id string) (string, bool) {
+	for _, entry := range entries {
+		var object map[string]any
+		if err := json.Unmarshal(entry.Value, &object); err != nil {
+			continue
+		}
+		if ref, ok := object[field]; ok && fmt.Sprint(ref) == id {
+			return entry.ID, true
+		}
+	}
+	return "", false
+}
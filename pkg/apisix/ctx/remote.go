@@ -1,6 +1,7 @@
 package ctx
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
@@ -34,3 +35,23 @@ func normalizeRemoteIP(value string) string {
 	}
 	return strings.Trim(value, "[]")
 }
+
+type clientIPKey struct{}
+
+// WithClientIP records the client address recovered from a trusted proxy's
+// X-Forwarded-For chain before routing.
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP returns the address route `remote_addrs` match against: the
+// forwarded client behind a trusted proxy, otherwise the socket peer.
+func ClientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if ip, _ := r.Context().Value(clientIPKey{}).(string); ip != "" {
+		return ip
+	}
+	return PeerRemoteIP(r)
+}
@@ -33,3 +33,17 @@ func TestEffectiveRemoteIPFallsBackToSocketPeer(t *testing.T) {
 		t.Fatalf("EffectiveRemoteIP() = %q, want 2001:db8::20", got)
 	}
 }
+
+func TestClientIPPrefersForwardedClientOverPeer(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "http://example.test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.RemoteAddr = "192.0.2.10:4321"
+	if got := ClientIP(request); got != "192.0.2.10" {
+		t.Fatalf("ClientIP() = %q, want the socket peer", got)
+	}
+	if got := ClientIP(WithClientIP(request, "198.51.100.7")); got != "198.51.100.7" {
+		t.Fatalf("ClientIP() = %q, want the forwarded client", got)
+	}
+}
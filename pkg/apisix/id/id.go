@@ -18,8 +18,8 @@ var (
 )
 
 func Get() string {
-	if config.GlobalConfig != nil && config.GlobalConfig.Apisix.ID != "" {
-		return config.GlobalConfig.Apisix.ID
+	if cfg := config.Global(); cfg != nil && cfg.Apisix.ID != "" {
+		return cfg.Apisix.ID
 	}
 
 	generatedOnce.Do(func() {
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRuntimeConfigAcceptsControlPlaneProvider(t *testing.T) {
+	cfg := validControlPlaneProviderConfig()
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want control_plane provider accepted", err)
+	}
+}
+
+func TestValidateRuntimeConfigRejectsInvalidControlPlaneProvider(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		want   string
+	}{
+		{
+			name:   "no host",
+			mutate: func(cfg *Config) { cfg.Deployment.RoleDataPlane.ControlPlane.Host = nil },
+			want:   "deployment.role_data_plane.control_plane.host must contain",
+		},
+		{
+			name: "plain http host",
+			mutate: func(cfg *Config) {
+				cfg.Deployment.RoleDataPlane.ControlPlane.Host = []string{"http://cp.example:9280"}
+			},
+			want: "control_plane.host[0] must be an https:// endpoint",
+		},
+		{
+			name:   "negative timeout",
+			mutate: func(cfg *Config) { cfg.Deployment.RoleDataPlane.ControlPlane.Timeout = -1 },
+			want:   "control_plane.timeout must not be negative",
+		},
+		{
+			name:   "no client certificate",
+			mutate: func(cfg *Config) { cfg.Deployment.Certs.CertKey = "" },
+			want:   "deployment.certs.cert and cert_key are required",
+		},
+		{
+			name:   "admin API enabled",
+			mutate: func(cfg *Config) { cfg.Apisix.EnableAdmin = true },
+			want:   "apisix.enable_admin must be false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validControlPlaneProviderConfig()
+			tt.mutate(cfg)
+			if err := validateRuntimeConfig(cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("validateRuntimeConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRuntimeConfigChecksConfServer(t *testing.T) {
+	cfg := validCompatibilityConfigForTrustedAddresses()
+	cfg.Deployment.Role = "control_plane"
+	cfg.Deployment.RoleControlPlane = RoleControlPlaneConfig{
+		ConfigProvider: "etcd",
+		ConfServer: ConfServer{
+			Listen:       "0.0.0.0:9280",
+			Cert:         "/etc/apisix/cp.crt",
+			CertKey:      "/etc/apisix/cp.key",
+			ClientCACert: "/etc/apisix/dp-ca.crt",
+		},
+	}
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want conf_server accepted", err)
+	}
+
+	cfg.Deployment.RoleControlPlane.ConfServer.ClientCACert = ""
+	if err := validateRuntimeConfig(cfg); err == nil || !strings.Contains(err.Error(), "client_ca_cert") {
+		t.Fatalf("validateRuntimeConfig() error = %v, want client_ca_cert required", err)
+	}
+
+	cfg.Deployment.RoleControlPlane.ConfServer.ClientCACert = "/etc/apisix/dp-ca.crt"
+	cfg.Deployment.RoleControlPlane.ConfServer.Listen = "9280"
+	if err := validateRuntimeConfig(cfg); err == nil || !strings.Contains(err.Error(), "host:port") {
+		t.Fatalf("validateRuntimeConfig() error = %v, want listen address rejection", err)
+	}
+
+	cfg.Deployment.RoleControlPlane.ConfServer.Listen = "0.0.0.0:9280"
+	cfg.Deployment.Role = "traditional"
+	if err := validateRuntimeConfig(cfg); err == nil || !strings.Contains(err.Error(), "requires deployment.role") {
+		t.Fatalf("validateRuntimeConfig() error = %v, want conf_server role rejection", err)
+	}
+}
+
+func validControlPlaneProviderConfig() *Config {
+	cfg := validCompatibilityConfigForTrustedAddresses()
+	cfg.Deployment.Role = "data_plane"
+	cfg.Deployment.RoleDataPlane = RoleConfig{
+		ConfigProvider: "control_plane",
+		ControlPlane:   ControlPlaneClient{Host: []string{"https://cp.example:9280"}},
+	}
+	cfg.Deployment.Certs = Certs{Cert: "/etc/apisix/dp.crt", CertKey: "/etc/apisix/dp.key"}
+	return cfg
+}
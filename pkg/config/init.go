@@ -9,14 +9,40 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
 	"github.com/wklken/apisix-go/pkg/data_encryption"
 )
 
+// GlobalConfig is the configuration the process runs with.
+//
+// Deprecated: use Global and SetGlobal. Request handlers read the
+// configuration while a reload publishes the next one, and only those two
+// functions synchronize with each other; reading or assigning GlobalConfig
+// directly races with a reload.
 var GlobalConfig *Config
 
+// globalMu guards GlobalConfig for Global and SetGlobal.
+var globalMu sync.RWMutex
+
+// Global returns the published configuration, or nil before one is loaded.
+// Callers that read several fields should load it once into a local, so
+// they do not mix two generations across a reload.
+func Global() *Config {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return GlobalConfig
+}
+
+// SetGlobal publishes cfg as the configuration the process runs with.
+func SetGlobal(cfg *Config) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	GlobalConfig = cfg
+}
+
 const (
 	DefaultConfigFile        = "conf/config-default.yaml"
 	defaultClientMaxBodySize = int64(10 * 1024 * 1024)
@@ -27,7 +53,23 @@ func Load(overridePath string) (*Config, error) {
 	return loadConfigFiles(DefaultConfigFile, overridePath)
 }
 
+// Read merges and validates the configuration like Load without publishing it
+// with SetGlobal, so a running server can reject a reload candidate.
+func Read(overridePath string) (*Config, error) {
+	return readConfigFiles(DefaultConfigFile, overridePath)
+}
+
 func loadConfigFiles(defaultPath, overridePath string) (*Config, error) {
+	cfg, err := readConfigFiles(defaultPath, overridePath)
+	if err != nil {
+		return nil, err
+	}
+	SetGlobal(cfg)
+	data_encryption.Configure(cfg.Apisix.DataEncryption.EnableEncryptFields, cfg.Apisix.DataEncryption.Keyring)
+	return cfg, nil
+}
+
+func readConfigFiles(defaultPath, overridePath string) (*Config, error) {
 	v := viper.NewWithOptions(viper.ExperimentalBindStruct())
 	v.SetConfigFile(defaultPath)
 	if err := v.ReadInConfig(); err != nil {
@@ -52,9 +94,6 @@ func loadConfigFiles(defaultPath, overridePath string) (*Config, error) {
 	if err := validateRuntimeConfig(cfg); err != nil {
 		return nil, err
 	}
-
-	GlobalConfig = cfg
-	data_encryption.Configure(cfg.Apisix.DataEncryption.EnableEncryptFields, cfg.Apisix.DataEncryption.Keyring)
 	return cfg, nil
 }
 
@@ -85,6 +124,13 @@ func load(v *viper.Viper) (*Config, error) {
 	if err := validateHTTPPluginAllowlist(cfg.Plugins); err != nil {
 		return nil, err
 	}
+	// As in APISIX, every wasm.plugins entry is enabled without being listed
+	// in plugins.
+	for _, wasm := range cfg.Wasm.Plugins {
+		if wasm.Name != "" && !slices.Contains(cfg.Plugins, wasm.Name) {
+			cfg.Plugins = append(cfg.Plugins, wasm.Name)
+		}
+	}
 	if sendTimeout := cfg.NginxConfig.HTTP.SendTimeout; sendTimeout != 0 {
 		return nil, fmt.Errorf(
 			"nginx_config.http.send_timeout must be zero because Go cannot implement NGINX write-idle semantics, got %s",
@@ -129,6 +175,23 @@ func validateRuntimeConfig(cfg *Config) error {
 				fmt.Errorf("apisix.node_listen[%d].ip must be a valid IP address, got %q", index, listener.Ip),
 			)
 		}
+		if listener.ProxyProtocolToUpstream {
+			return profileAwareRuntimeError(
+				cfg,
+				fmt.Errorf("apisix.node_listen[%d].proxy_protocol_to_upstream is not supported for HTTP listeners", index),
+			)
+		}
+	}
+	for _, port := range []struct {
+		field string
+		value int
+	}{
+		{field: "apisix.proxy_protocol.listen_http_port", value: cfg.Apisix.ProxyProtocol.ListenHTTPPort},
+		{field: "apisix.proxy_protocol.listen_https_port", value: cfg.Apisix.ProxyProtocol.ListenHTTPSPort},
+	} {
+		if port.value < 0 || port.value > 65535 {
+			return profileAwareRuntimeError(cfg, fmt.Errorf("%s must be between 0 and 65535, got %d", port.field, port.value))
+		}
 	}
 	for _, limit := range []struct {
 		field string
@@ -161,6 +224,9 @@ func validateRuntimeConfig(cfg *Config) error {
 			)
 		}
 	}
+	if err := validateProxyProtocolTrust(cfg); err != nil {
+		return profileAwareRuntimeError(cfg, err)
+	}
 	if cfg.NginxConfig.HTTP.ClientMaxBodySize <= 0 {
 		return profileAwareRuntimeError(cfg, fmt.Errorf(
 			"nginx_config.http.client_max_body_size must be positive, got %d",
@@ -176,6 +242,12 @@ func validateRuntimeConfig(cfg *Config) error {
 	if err := validateProcessAccessLogs(cfg); err != nil {
 		return profileAwareRuntimeError(cfg, err)
 	}
+	if err := validateLogSpool(cfg.Apisix.LogSpool); err != nil {
+		return profileAwareRuntimeError(cfg, err)
+	}
+	if err := validateWasmPlugins(cfg); err != nil {
+		return profileAwareRuntimeError(cfg, err)
+	}
 
 	provider, err := EffectiveConfigProvider(cfg)
 	if err != nil {
@@ -203,18 +275,68 @@ func validateRuntimeConfig(cfg *Config) error {
 			)
 		}
 	}
+	if provider == "control_plane" {
+		if err := validateControlPlaneProvider(cfg); err != nil {
+			return profileAwareRuntimeError(cfg, err)
+		}
+	}
+	if err := validateConfServer(cfg); err != nil {
+		return profileAwareRuntimeError(cfg, err)
+	}
 	if err := validateUnsupportedRuntimeConfig(cfg); err != nil {
 		return err
 	}
 
 	switch cfg.Deployment.Profile {
 	case "":
-		return nil
 	case HTTPDataPlaneV1Profile:
-		return validateHTTPDataPlaneV1Profile(cfg)
+		if err := validateHTTPDataPlaneV1Profile(cfg); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("deployment.profile must be empty or %s", HTTPDataPlaneV1Profile)
 	}
+	return validateAdminConfig(cfg)
+}
+
+// validateProxyProtocolTrust refuses PROXY protocol listeners without
+// apisix.trusted_addresses. The header replaces the client address, so any
+// peer allowed to send one chooses its own remote_addr.
+func validateProxyProtocolTrust(cfg *Config) error {
+	for _, address := range cfg.Apisix.TrustedAddresses {
+		if strings.TrimSpace(address) != "" {
+			return nil
+		}
+	}
+	if field := proxyProtocolListenerField(cfg); field != "" {
+		return fmt.Errorf("%s requires apisix.trusted_addresses to list the peers allowed to send PROXY headers", field)
+	}
+	return nil
+}
+
+// proxyProtocolListenerField names the first setting that opens a PROXY
+// protocol listener, or returns "" when there is none.
+func proxyProtocolListenerField(cfg *Config) string {
+	for index, listener := range cfg.Apisix.NodeListen {
+		if listener.ProxyProtocol {
+			return fmt.Sprintf("apisix.node_listen[%d].proxy_protocol", index)
+		}
+	}
+	if cfg.Apisix.ProxyProtocol.ListenHTTPPort > 0 {
+		return "apisix.proxy_protocol.listen_http_port"
+	}
+	if cfg.Apisix.ProxyProtocol.ListenHTTPSPort > 0 {
+		return "apisix.proxy_protocol.listen_https_port"
+	}
+	for index, listener := range cfg.Apisix.StreamProxy.Tcp {
+		if listener.ProxyProtocol {
+			return fmt.Sprintf("apisix.stream_proxy.tcp[%d].proxy_protocol", index)
+		}
+		if cfg.Apisix.ProxyProtocol.EnableTCPPP {
+			return "apisix.proxy_protocol.enable_tcp_pp"
+		}
+	}
+	return ""
 }
 
 func profileAwareRuntimeError(cfg *Config, err error) error {
@@ -224,15 +346,65 @@ func profileAwareRuntimeError(cfg *Config, err error) error {
 	return err
 }
 
+// validateControlPlaneProvider checks a data plane that streams its
+// configuration from control planes: it must reach them over HTTPS with a
+// client certificate, and has no Admin API since the control plane owns writes.
+func validateControlPlaneProvider(cfg *Config) error {
+	controlPlane := cfg.Deployment.RoleDataPlane.ControlPlane
+	if len(controlPlane.Host) == 0 {
+		return fmt.Errorf(
+			"deployment.role_data_plane.control_plane.host must contain at least one endpoint " +
+				"for the control_plane provider",
+		)
+	}
+	for index, endpoint := range controlPlane.Host {
+		parsed, err := url.Parse(strings.TrimSpace(endpoint))
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("deployment.role_data_plane.control_plane.host[%d] must be an https:// endpoint", index)
+		}
+	}
+	if controlPlane.Timeout < 0 {
+		return fmt.Errorf(
+			"deployment.role_data_plane.control_plane.timeout must not be negative, got %d",
+			controlPlane.Timeout,
+		)
+	}
+	if cfg.Deployment.Certs.Cert == "" || cfg.Deployment.Certs.CertKey == "" {
+		return fmt.Errorf("deployment.certs.cert and cert_key are required for the control_plane provider")
+	}
+	if cfg.Apisix.EnableAdmin {
+		return fmt.Errorf("apisix.enable_admin must be false for the control_plane provider")
+	}
+	return nil
+}
+
+func validateConfServer(cfg *Config) error {
+	confServer := cfg.Deployment.RoleControlPlane.ConfServer
+	if confServer.Listen == "" {
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSpace(cfg.Deployment.Role), "control_plane") {
+		return fmt.Errorf("deployment.role_control_plane.conf_server requires deployment.role control_plane")
+	}
+	if _, port, err := net.SplitHostPort(confServer.Listen); err != nil || port == "" {
+		return fmt.Errorf(
+			"deployment.role_control_plane.conf_server.listen must be a host:port address, got %q",
+			confServer.Listen,
+		)
+	}
+	if confServer.Cert == "" || confServer.CertKey == "" || confServer.ClientCACert == "" {
+		return fmt.Errorf(
+			"deployment.role_control_plane.conf_server.cert, cert_key and client_ca_cert are required",
+		)
+	}
+	return nil
+}
+
 func validateUnsupportedRuntimeConfig(cfg *Config) error {
 	for _, unsupported := range []struct {
 		field    string
 		isActive bool
 	}{
-		{field: "apisix.enable_admin", isActive: cfg.Apisix.EnableAdmin},
-		{field: "discovery", isActive: len(cfg.Discovery) > 0},
-		{field: "ext-plugin.cmd", isActive: len(cfg.ExtPlugin.Cmd) > 0},
-		{field: "wasm.plugins", isActive: len(cfg.Wasm.Plugins) > 0},
 		{field: "xrpc.protocols", isActive: len(cfg.XRPC.Protocols) > 0},
 	} {
 		if unsupported.isActive {
@@ -243,22 +415,6 @@ func validateUnsupportedRuntimeConfig(cfg *Config) error {
 			)
 		}
 	}
-	for index, listener := range cfg.Apisix.Ssl.Listen {
-		if listener.EnableQuic {
-			return fmt.Errorf(
-				"apisix.ssl.listen[%d].enable_quic is unsupported; %s does not permit this runtime feature",
-				index,
-				HTTPDataPlaneV1Profile,
-			)
-		}
-		if listener.EnableHttp3 {
-			return fmt.Errorf(
-				"apisix.ssl.listen[%d].enable_http3 is unsupported; %s does not permit this runtime feature",
-				index,
-				HTTPDataPlaneV1Profile,
-			)
-		}
-	}
 	return nil
 }
 
@@ -308,6 +464,23 @@ func validateHTTPDataPlaneV1Profile(cfg *Config) error {
 	if len(cfg.StreamPlugins) > 0 {
 		return profileFieldError(profile, "stream_plugins", "must be empty")
 	}
+	if len(cfg.Discovery) > 0 {
+		return profileFieldError(profile, "discovery", "must be empty")
+	}
+	if len(cfg.ExtPlugin.Cmd) > 0 || cfg.ExtPlugin.PathForTest != "" {
+		return profileFieldError(profile, "ext-plugin.cmd", "must be empty")
+	}
+	if len(cfg.Wasm.Plugins) > 0 {
+		return profileFieldError(profile, "wasm.plugins", "must be empty")
+	}
+	for index, listener := range cfg.Apisix.Ssl.Listen {
+		if listener.EnableQuic {
+			return profileFieldError(profile, fmt.Sprintf("apisix.ssl.listen[%d].enable_quic", index), "must be false")
+		}
+		if listener.EnableHttp3 {
+			return profileFieldError(profile, fmt.Sprintf("apisix.ssl.listen[%d].enable_http3", index), "must be false")
+		}
+	}
 	if len(cfg.Apisix.TrustedAddresses) == 0 {
 		return profileFieldError(profile, "apisix.trusted_addresses", "must contain at least one CIDR")
 	}
@@ -351,6 +524,87 @@ func validateProcessAccessLogs(cfg *Config) error {
 	return nil
 }
 
+// validateWasmPlugins checks the wasm.plugins entries; the files are read
+// when the server starts.
+func validateLogSpool(spool LogSpool) error {
+	if spool.MaxBytes < 0 {
+		return fmt.Errorf("apisix.log_spool.max_bytes must not be negative, got %d", spool.MaxBytes)
+	}
+	if spool.SegmentBytes < 0 {
+		return fmt.Errorf("apisix.log_spool.segment_bytes must not be negative, got %d", spool.SegmentBytes)
+	}
+	switch spool.Fsync {
+	case "", "always", "batch", "never":
+		return nil
+	default:
+		return fmt.Errorf("apisix.log_spool.fsync must be always, batch or never, got %q", spool.Fsync)
+	}
+}
+
+func validateWasmPlugins(cfg *Config) error {
+	seen := make(map[string]int, len(cfg.Wasm.Plugins))
+	for index, wasm := range cfg.Wasm.Plugins {
+		if wasm.Name == "" {
+			return fmt.Errorf("wasm.plugins[%d].name must not be empty", index)
+		}
+		if previous, ok := seen[wasm.Name]; ok {
+			return fmt.Errorf("wasm.plugins[%d].name duplicates wasm.plugins[%d]", index, previous)
+		}
+		seen[wasm.Name] = index
+		if wasm.File == "" {
+			return fmt.Errorf("wasm.plugins[%d].file must not be empty", index)
+		}
+		switch wasm.HTTPRequestPhase {
+		case "", "access", "rewrite":
+		default:
+			return fmt.Errorf("wasm.plugins[%d].http_request_phase must be access or rewrite", index)
+		}
+	}
+	return nil
+}
+
+func validateAdminConfig(cfg *Config) error {
+	if !cfg.Apisix.EnableAdmin {
+		return nil
+	}
+	admin := cfg.Deployment.Admin
+	if admin.AdminListen.Port < 1 || admin.AdminListen.Port > 65535 {
+		return fmt.Errorf(
+			"deployment.admin.admin_listen.port must be between 1 and 65535, got %d",
+			admin.AdminListen.Port,
+		)
+	}
+	if admin.AdminListen.IP != "" && net.ParseIP(admin.AdminListen.IP) == nil {
+		return fmt.Errorf("deployment.admin.admin_listen.ip must be a valid IP address, got %q", admin.AdminListen.IP)
+	}
+	for index, key := range admin.AdminKey {
+		if strings.TrimSpace(key.Key) == "" {
+			return fmt.Errorf("deployment.admin.admin_key[%d].key must not be empty", index)
+		}
+		if key.Role != "admin" && key.Role != "viewer" {
+			return fmt.Errorf("deployment.admin.admin_key[%d].role must be admin or viewer", index)
+		}
+	}
+	if admin.AdminKeyRequired && len(admin.AdminKey) == 0 {
+		return fmt.Errorf("deployment.admin.admin_key must contain at least one key when admin_key_required is true")
+	}
+	for index, address := range admin.AllowAdmin {
+		address = strings.TrimSpace(address)
+		if net.ParseIP(address) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("deployment.admin.allow_admin[%d] must be a valid CIDR or IP address", index)
+		}
+	}
+	if admin.HTTPSAdmin && (admin.AdminAPIMTLS.AdminSSLCert == "" || admin.AdminAPIMTLS.AdminSSLCertKey == "") {
+		return fmt.Errorf(
+			"deployment.admin.admin_api_mtls.admin_ssl_cert and admin_ssl_cert_key are required when https_admin is true",
+		)
+	}
+	return nil
+}
+
 func profileFieldError(profile, field, requirement string) error {
 	return fmt.Errorf("%s: %s %s", profile, field, requirement)
 }
@@ -373,7 +627,7 @@ func EffectiveConfigProvider(cfg *Config) (string, error) {
 	}
 	provider = strings.ToLower(strings.TrimSpace(provider))
 	if role == "data_plane" {
-		if slices.Contains([]string{"etcd", "yaml", "json"}, provider) {
+		if slices.Contains([]string{"etcd", "yaml", "json", "control_plane"}, provider) {
 			return provider, nil
 		}
 		return "", fmt.Errorf("deployment.role_data_plane.config_provider %q is unsupported", provider)
@@ -400,7 +654,7 @@ func CapabilitySummary(cfg *Config) map[string]any {
 	return map[string]any{
 		"debug":                 cfg.Debug,
 		"role":                  boundedSummaryValue(cfg.Deployment.Role, "traditional", "data_plane", "control_plane"),
-		"config_provider":       boundedSummaryValue(provider, "etcd", "yaml", "json"),
+		"config_provider":       boundedSummaryValue(provider, "etcd", "yaml", "json", "control_plane"),
 		"http_listener_count":   len(cfg.Apisix.NodeListen),
 		"https_listener_count":  len(cfg.Apisix.Ssl.Listen),
 		"stream_listener_count": streamListeners,
@@ -130,6 +130,9 @@ deployment:
 	if got, want := cfg.NginxConfig.HTTP.Upstream.Keepalive, 320; got != want {
 		t.Fatalf("upstream.keepalive = %d, want %d", got, want)
 	}
+	if got, want := cfg.Plugins, []string{"request-id", "gzip", "wasm_log"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("plugins = %#v, want wasm plugins enabled after %#v", got, want[:2])
+	}
 	if got, want := cfg.Proxy.MaxIdleConns, 2048; got != want {
 		t.Fatalf("proxy.max_idle_conns = %d, want %d", got, want)
 	}
@@ -215,6 +218,24 @@ func TestLoadConfigFilesClientMaxBodySizeValidation(t *testing.T) {
 	}
 }
 
+func TestReadConfigFilesDoesNotPublishGlobalConfig(t *testing.T) {
+	previous := GlobalConfig
+	t.Cleanup(func() { GlobalConfig = previous })
+	GlobalConfig = nil
+
+	base := writeConfigFile(t, "base.yaml", validRuntimeConfig)
+	cfg, err := readConfigFiles(base, "")
+	if err != nil {
+		t.Fatalf("readConfigFiles() error = %v", err)
+	}
+	if cfg == nil {
+		t.Fatal("readConfigFiles() config = nil")
+	}
+	if GlobalConfig != nil {
+		t.Fatal("readConfigFiles() published the reload candidate as GlobalConfig")
+	}
+}
+
 func TestLoadAppliesSafeRequestBodyDefaultsWhenOmitted(t *testing.T) {
 	v := viper.New()
 	v.SetConfigType("yaml")
@@ -259,6 +280,31 @@ func TestLoadConfigFilesRejectsNonPositiveClientBodyTimeout(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFilesRejectsInvalidLogSpool(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		spool string
+		want  string
+	}{
+		{name: "negative max_bytes", spool: "max_bytes: -1", want: "apisix.log_spool.max_bytes"},
+		{name: "negative segment_bytes", spool: "segment_bytes: -1", want: "apisix.log_spool.segment_bytes"},
+		{name: "unknown fsync", spool: "fsync: sometimes", want: "apisix.log_spool.fsync"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			base := writeConfigFile(t, "base.yaml", validRuntimeConfig)
+			override := writeConfigFile(
+				t,
+				"override.yaml",
+				"apisix:\n  log_spool:\n    dir: /var/spool/apisix\n    "+test.spool+"\n",
+			)
+			_, err := loadConfigFiles(base, override)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("loadConfigFiles() error = %v, want %s rejection", err, test.want)
+			}
+		})
+	}
+}
+
 func TestLoadConfigFilesRejectsProcessAccessLogFields(t *testing.T) {
 	previous := &Config{Debug: true}
 	GlobalConfig = previous
@@ -413,6 +459,66 @@ func TestLoadSupportsScalarNodeListen(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFilesValidatesProxyProtocolSettings(t *testing.T) {
+	previous := GlobalConfig
+	t.Cleanup(func() { GlobalConfig = previous })
+
+	for _, test := range []struct {
+		name     string
+		override string
+		field    string
+	}{
+		{
+			name:     "http upstream header",
+			override: "apisix:\n  node_listen:\n    - port: 9080\n      proxy_protocol_to_upstream: true\n",
+			field:    "apisix.node_listen[0].proxy_protocol_to_upstream",
+		},
+		{
+			name:     "http port",
+			override: "apisix:\n  proxy_protocol:\n    listen_http_port: 70000\n",
+			field:    "apisix.proxy_protocol.listen_http_port",
+		},
+		{
+			name:     "https port",
+			override: "apisix:\n  proxy_protocol:\n    listen_https_port: -1\n",
+			field:    "apisix.proxy_protocol.listen_https_port",
+		},
+		{
+			name:     "untrusted listener",
+			override: "apisix:\n  node_listen:\n    - port: 9080\n      proxy_protocol: true\n",
+			field:    "apisix.node_listen[0].proxy_protocol requires apisix.trusted_addresses",
+		},
+		{
+			name:     "untrusted port",
+			override: "apisix:\n  proxy_protocol:\n    listen_https_port: 9182\n",
+			field:    "apisix.proxy_protocol.listen_https_port requires apisix.trusted_addresses",
+		},
+		{
+			name: "listeners",
+			override: "apisix:\n  node_listen:\n    - port: 9080\n      proxy_protocol: true\n" +
+				"  proxy_protocol:\n    listen_http_port: 9181\n    enable_tcp_pp: true\n" +
+				"  trusted_addresses: [10.0.0.0/8]\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			GlobalConfig = previous
+			base := writeConfigFile(t, "base.yaml", validRuntimeConfig)
+			override := writeConfigFile(t, "override.yaml", test.override)
+
+			_, err := loadConfigFiles(base, override)
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("loadConfigFiles() error = %v, want PROXY protocol listeners accepted", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.field) {
+				t.Fatalf("loadConfigFiles() error = %v, want %q rejection", err, test.field)
+			}
+		})
+	}
+}
+
 func TestLoadRejectsInvalidHTTPPluginAllowlist(t *testing.T) {
 	previous := GlobalConfig
 	t.Cleanup(func() { GlobalConfig = previous })
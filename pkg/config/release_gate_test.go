@@ -400,34 +400,6 @@ func TestUnsupportedRuntimeConfigRejectsEveryMode(t *testing.T) {
 		field  string
 		mutate func(*Config)
 	}{
-		{
-			name:  "admin API",
-			field: "apisix.enable_admin",
-			mutate: func(cfg *Config) {
-				cfg.Apisix.EnableAdmin = true
-			},
-		},
-		{
-			name:  "service discovery",
-			field: "discovery",
-			mutate: func(cfg *Config) {
-				cfg.Discovery = Discovery{"dns": map[string]any{"servers": []string{"127.0.0.1:53"}}}
-			},
-		},
-		{
-			name:  "external plugin command",
-			field: "ext-plugin.cmd",
-			mutate: func(cfg *Config) {
-				cfg.ExtPlugin.Cmd = []string{"/usr/local/bin/plugin"}
-			},
-		},
-		{
-			name:  "WASM plugin",
-			field: "wasm.plugins",
-			mutate: func(cfg *Config) {
-				cfg.Wasm.Plugins = []WasmPlugin{{Name: "logger", File: "logger.wasm"}}
-			},
-		},
 		{
 			name:  "XRPC protocol",
 			field: "xrpc.protocols",
@@ -435,20 +407,6 @@ func TestUnsupportedRuntimeConfigRejectsEveryMode(t *testing.T) {
 				cfg.XRPC.Protocols = []XRPCProtocol{{Name: "pingpong"}}
 			},
 		},
-		{
-			name:  "QUIC",
-			field: "apisix.ssl.listen[0].enable_quic",
-			mutate: func(cfg *Config) {
-				cfg.Apisix.Ssl.Listen[0].EnableQuic = true
-			},
-		},
-		{
-			name:  "HTTP3",
-			field: "apisix.ssl.listen[0].enable_http3",
-			mutate: func(cfg *Config) {
-				cfg.Apisix.Ssl.Listen[0].EnableHttp3 = true
-			},
-		},
 	}
 
 	for _, profile := range []struct {
@@ -553,6 +511,13 @@ func TestProductionProfileRejectsOneMutatedFieldPerRow(t *testing.T) {
 				cfg.NginxConfig.HTTP.ClientBodyTimeout = 0
 			},
 		},
+		{
+			name:  "admin API",
+			field: "apisix.enable_admin",
+			mutate: func(cfg *Config) {
+				cfg.Apisix.EnableAdmin = true
+			},
+		},
 		{
 			name:  "proxy mode",
 			field: "apisix.proxy_mode",
@@ -581,6 +546,41 @@ func TestProductionProfileRejectsOneMutatedFieldPerRow(t *testing.T) {
 				cfg.StreamPlugins = []string{"mqtt-proxy"}
 			},
 		},
+		{
+			name:  "service discovery",
+			field: "discovery",
+			mutate: func(cfg *Config) {
+				cfg.Discovery = Discovery{"dns": map[string]any{"servers": []string{"127.0.0.1:53"}}}
+			},
+		},
+		{
+			name:  "external plugin command",
+			field: "ext-plugin.cmd",
+			mutate: func(cfg *Config) {
+				cfg.ExtPlugin.Cmd = []string{"/usr/local/bin/plugin"}
+			},
+		},
+		{
+			name:  "WASM plugin",
+			field: "wasm.plugins",
+			mutate: func(cfg *Config) {
+				cfg.Wasm.Plugins = []WasmPlugin{{Name: "logger", File: "logger.wasm"}}
+			},
+		},
+		{
+			name:  "QUIC",
+			field: "apisix.ssl.listen[0].enable_quic",
+			mutate: func(cfg *Config) {
+				cfg.Apisix.Ssl.Listen[0].EnableQuic = true
+			},
+		},
+		{
+			name:  "HTTP3",
+			field: "apisix.ssl.listen[0].enable_http3",
+			mutate: func(cfg *Config) {
+				cfg.Apisix.Ssl.Listen[0].EnableHttp3 = true
+			},
+		},
 		{
 			name:  "trusted addresses empty",
 			field: "apisix.trusted_addresses",
@@ -726,7 +726,7 @@ func validHTTPDataPlaneV1Config() *Config {
 			Profile:          HTTPDataPlaneV1Profile,
 			Role:             "data_plane",
 			RoleDataPlane:    RoleConfig{ConfigProvider: "etcd"},
-			RoleControlPlane: RoleConfig{ConfigProvider: "etcd"},
+			RoleControlPlane: RoleControlPlaneConfig{ConfigProvider: "etcd"},
 			Etcd: Etcd{
 				Host:   []string{"https://etcd.example:2379"},
 				Prefix: "/apisix",
@@ -779,3 +779,107 @@ func writeConfigFile(t *testing.T, name, contents string) string {
 	}
 	return path
 }
+
+func TestCompatibilityConfigAcceptsDiscovery(t *testing.T) {
+	cfg := validHTTPDataPlaneV1Config()
+	cfg.Deployment.Profile = ""
+	cfg.Discovery = Discovery{"dns": map[string]any{"servers": []string{"127.0.0.1:53"}}}
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want discovery accepted outside the profile", err)
+	}
+}
+
+func TestCompatibilityConfigAcceptsExtPlugin(t *testing.T) {
+	cfg := validHTTPDataPlaneV1Config()
+	cfg.Deployment.Profile = ""
+	cfg.ExtPlugin.Cmd = []string{"/usr/local/bin/plugin"}
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want ext-plugin.cmd accepted outside the profile", err)
+	}
+}
+
+func TestCompatibilityConfigAcceptsWasm(t *testing.T) {
+	cfg := validHTTPDataPlaneV1Config()
+	cfg.Deployment.Profile = ""
+	cfg.Wasm.Plugins = []WasmPlugin{{Name: "wasm_log", Priority: 7999, File: "log.wasm"}}
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want wasm.plugins accepted outside the profile", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		field   string
+		plugins []WasmPlugin
+	}{
+		{name: "empty name", field: "wasm.plugins[0].name", plugins: []WasmPlugin{{File: "log.wasm"}}},
+		{name: "empty file", field: "wasm.plugins[0].file", plugins: []WasmPlugin{{Name: "wasm_log"}}},
+		{
+			name:    "duplicate name",
+			field:   "wasm.plugins[1].name",
+			plugins: []WasmPlugin{{Name: "wasm_log", File: "log.wasm"}, {Name: "wasm_log", File: "other.wasm"}},
+		},
+		{
+			name:    "request phase",
+			field:   "wasm.plugins[0].http_request_phase",
+			plugins: []WasmPlugin{{Name: "wasm_log", File: "log.wasm", HTTPRequestPhase: "header_filter"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			invalid := *cfg
+			invalid.Wasm.Plugins = test.plugins
+			err := validateRuntimeConfig(&invalid)
+			if err == nil || !strings.Contains(err.Error(), test.field) {
+				t.Fatalf("validateRuntimeConfig() error = %v, want %s rejection", err, test.field)
+			}
+		})
+	}
+}
+
+func TestCompatibilityConfigAcceptsHTTP3(t *testing.T) {
+	cfg := validHTTPDataPlaneV1Config()
+	cfg.Deployment.Profile = ""
+	cfg.Apisix.Ssl.Listen[0].EnableQuic = true
+	cfg.Apisix.Ssl.Listen[0].EnableHttp3 = true
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want HTTP/3 listeners accepted outside the profile", err)
+	}
+}
+
+func TestCompatibilityConfigAcceptsAdmin(t *testing.T) {
+	cfg := validHTTPDataPlaneV1Config()
+	cfg.Deployment.Profile = ""
+	cfg.Apisix.EnableAdmin = true
+	cfg.Deployment.Admin = Admin{
+		AdminKeyRequired: true,
+		AdminKey:         []AdminKey{{Name: "admin", Key: "edd1c9f034335f136f87ad84b625c8f1", Role: "admin"}},
+		AllowAdmin:       []string{"127.0.0.0/24"},
+		AdminListen:      AdminListen{IP: "127.0.0.1", Port: 9180},
+	}
+	if err := validateRuntimeConfig(cfg); err != nil {
+		t.Fatalf("validateRuntimeConfig() error = %v, want admin API accepted outside the profile", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		field  string
+		mutate func(*Admin)
+	}{
+		{name: "listen port", field: "admin_listen.port", mutate: func(admin *Admin) { admin.AdminListen.Port = 0 }},
+		{name: "listen ip", field: "admin_listen.ip", mutate: func(admin *Admin) { admin.AdminListen.IP = "any" }},
+		{name: "missing keys", field: "admin_key must contain", mutate: func(admin *Admin) { admin.AdminKey = nil }},
+		{name: "empty key", field: "admin_key[0].key", mutate: func(admin *Admin) { admin.AdminKey[0].Key = "" }},
+		{name: "role", field: "admin_key[0].role", mutate: func(admin *Admin) { admin.AdminKey[0].Role = "root" }},
+		{name: "allow list", field: "allow_admin[0]", mutate: func(admin *Admin) { admin.AllowAdmin = []string{"10.0.0.0/33"} }},
+		{name: "https without cert", field: "admin_ssl_cert", mutate: func(admin *Admin) { admin.HTTPSAdmin = true }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			invalid := *cfg
+			invalid.Deployment.Admin.AdminKey = append([]AdminKey(nil), cfg.Deployment.Admin.AdminKey...)
+			test.mutate(&invalid.Deployment.Admin)
+			err := validateRuntimeConfig(&invalid)
+			if err == nil || !strings.Contains(err.Error(), test.field) {
+				t.Fatalf("validateRuntimeConfig() error = %v, want %q rejection", err, test.field)
+			}
+		})
+	}
+}
@@ -48,6 +48,7 @@ type Apisix struct {
 	LuaModuleHook                      string        `mapstructure:"lua_module_hook"`
 	ProxyProtocol                      ProxyProtocol `mapstructure:"proxy_protocol"`
 	ProxyCache                         ProxyCache    `mapstructure:"proxy_cache"`
+	LogSpool                           LogSpool      `mapstructure:"log_spool"`
 	DeleteURITailSlash                 bool          `mapstructure:"delete_uri_tail_slash"`
 	NormalizeURILikeServlet            bool          `mapstructure:"normalize_uri_like_servlet"`
 	MatchURIEncodedSlash               bool          `mapstructure:"match_uri_encoded_slash"`
@@ -85,6 +86,16 @@ type ProxyCache struct {
 	Zones    []Zone        `mapstructure:"zones"`
 }
 
+// LogSpool is the on-disk spool of the batch loggers whose route config sets
+// `spool: true`. Every plugin and route spools under Dir, and MaxBytes bounds
+// the bytes of all their spools together.
+type LogSpool struct {
+	Dir          string `mapstructure:"dir"`
+	MaxBytes     int64  `mapstructure:"max_bytes"`
+	SegmentBytes int64  `mapstructure:"segment_bytes"`
+	Fsync        string `mapstructure:"fsync"`
+}
+
 type Zone struct {
 	Name        string `mapstructure:"name"`
 	MemorySize  string `mapstructure:"memory_size"`
@@ -109,6 +120,10 @@ type Router struct {
 type StreamProxy struct {
 	Tcp []TcpListen `mapstructure:"tcp"`
 	Udp []string    `mapstructure:"udp"`
+	// TrustedAddresses is not read from stream_proxy; the server copies
+	// apisix.trusted_addresses here so a listener set carries the PROXY
+	// protocol peers of the configuration it was built from.
+	TrustedAddresses []string `mapstructure:"-"`
 }
 
 type TcpListen struct {
@@ -263,7 +278,8 @@ type NginxUpstream struct {
 }
 
 type ExtPlugin struct {
-	Cmd []string `mapstructure:"cmd"`
+	Cmd         []string `mapstructure:"cmd"`
+	PathForTest string   `mapstructure:"path_for_test"`
 }
 
 type Wasm struct {
@@ -271,9 +287,10 @@ type Wasm struct {
 }
 
 type WasmPlugin struct {
-	Name     string `mapstructure:"name"`
-	Priority int    `mapstructure:"priority"`
-	File     string `mapstructure:"file"`
+	Name             string `mapstructure:"name"`
+	Priority         int    `mapstructure:"priority"`
+	File             string `mapstructure:"file"`
+	HTTPRequestPhase string `mapstructure:"http_request_phase"`
 }
 
 type XRPC struct {
@@ -296,17 +313,49 @@ type PluginAttr map[string]any
 
 type Deployment struct {
 	// TODO: add validation here
-	Profile          string                `mapstructure:"profile"`
-	Role             string                `mapstructure:"role"`
-	RoleTraditional  RoleTraditionalConfig `mapstructure:"role_traditional"`
-	RoleDataPlane    RoleConfig            `mapstructure:"role_data_plane"`
-	RoleControlPlane RoleConfig            `mapstructure:"role_control_plane"`
-	Admin            Admin                 `mapstructure:"admin"`
-	Etcd             Etcd                  `mapstructure:"etcd"`
+	Profile          string                 `mapstructure:"profile"`
+	Role             string                 `mapstructure:"role"`
+	RoleTraditional  RoleTraditionalConfig  `mapstructure:"role_traditional"`
+	RoleDataPlane    RoleConfig             `mapstructure:"role_data_plane"`
+	RoleControlPlane RoleControlPlaneConfig `mapstructure:"role_control_plane"`
+	Admin            Admin                  `mapstructure:"admin"`
+	Etcd             Etcd                   `mapstructure:"etcd"`
+	Certs            Certs                  `mapstructure:"certs"`
 }
 
 type RoleConfig struct {
-	ConfigProvider string `mapstructure:"config_provider"`
+	ConfigProvider string             `mapstructure:"config_provider"`
+	ControlPlane   ControlPlaneClient `mapstructure:"control_plane"`
+}
+
+// ControlPlaneClient locates the control planes a data plane with the
+// control_plane config provider streams its configuration from.
+type ControlPlaneClient struct {
+	Host    []string `mapstructure:"host"`
+	SNI     string   `mapstructure:"sni"`
+	Timeout int      `mapstructure:"timeout"`
+}
+
+type RoleControlPlaneConfig struct {
+	ConfigProvider string     `mapstructure:"config_provider"`
+	ConfServer     ConfServer `mapstructure:"conf_server"`
+}
+
+// ConfServer is the mTLS listener a control plane serves its configuration
+// to data planes on.
+type ConfServer struct {
+	Listen       string `mapstructure:"listen"`
+	Cert         string `mapstructure:"cert"`
+	CertKey      string `mapstructure:"cert_key"`
+	ClientCACert string `mapstructure:"client_ca_cert"`
+}
+
+// Certs is the client certificate a data plane presents to the control plane
+// and the CA it verifies the control plane with.
+type Certs struct {
+	Cert          string `mapstructure:"cert"`
+	CertKey       string `mapstructure:"cert_key"`
+	TrustedCACert string `mapstructure:"trusted_ca_cert"`
 }
 
 type RoleTraditionalConfig struct {
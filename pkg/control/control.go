@@ -0,0 +1,430 @@
+// Package control serves the APISIX compatible Control API under /v1. It is
+// a read-mostly view of the running node: upstream health comes from the
+// live cluster registry and route, upstream and plugin metadata output from
+// the route generation that is serving traffic, so it reports what the node
+// does rather than what the configuration store holds.
+package control
+
+import (
+	"encoding/hex"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/route"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+const (
+	defaultListenIP   = "127.0.0.1"
+	defaultListenPort = 9090
+)
+
+// Source supplies the runtime state the Control API reports.
+type Source interface {
+	// RouteGeneration returns the route generation serving traffic, or nil
+	// before the first one is installed.
+	RouteGeneration() *route.Generation
+	// ClusterHealth returns the health of every live health-checked cluster.
+	ClusterHealth() []proxy.ClusterHealth
+}
+
+// Handler serves the Control API endpoints over a Source.
+type Handler struct {
+	source Source
+	router chi.Router
+}
+
+// NewHandler returns a Control API handler reading from source.
+func NewHandler(source Source) *Handler {
+	h := &Handler{source: source}
+	router := chi.NewRouter()
+	router.Get("/v1/healthcheck", h.healthcheck)
+	router.Get("/v1/healthcheck/{kind}/{id}", h.healthcheckFor)
+	router.Get("/v1/routes", h.routes)
+	router.Get("/v1/route/{id}", h.route)
+	router.Get("/v1/services", h.services)
+	router.Get("/v1/service/{id}", h.service)
+	router.Get("/v1/upstreams", h.upstreams)
+	router.Get("/v1/upstream/{id}", h.upstream)
+	router.Get("/v1/schema", h.schema)
+	router.Get("/v1/plugin_metadatas", h.pluginMetadatas)
+	router.Get("/v1/plugin_metadata/{name}", h.pluginMetadata)
+	router.Post("/v1/gc", h.gc)
+	router.NotFound(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, "not found")
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+	h.router = router
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
+// ListenAddress returns the address of apisix.control, defaulting to the
+// loopback 127.0.0.1:9090 APISIX uses.
+func ListenAddress(cfg config.Control) string {
+	ip := cfg.Ip
+	if ip == "" {
+		ip = defaultListenIP
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultListenPort
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	_ = util.WriteJSON(w, status, map[string]string{"error_msg": message})
+}
+
+type counterResponse struct {
+	Success        int `json:"success"`
+	HTTPFailure    int `json:"http_failure"`
+	TCPFailure     int `json:"tcp_failure"`
+	TimeoutFailure int `json:"timeout_failure"`
+}
+
+type nodeResponse struct {
+	IP        string          `json:"ip"`
+	Port      int             `json:"port,omitempty"`
+	Target    string          `json:"target"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason,omitempty"`
+	EjectedAt string          `json:"ejected_at,omitempty"`
+	Counter   counterResponse `json:"counter"`
+	// Active carries the active probe counters next to the passive ones in
+	// counter; APISIX reports a single merged counter.
+	Active    *counterResponse `json:"active_counter,omitempty"`
+	LastProbe *probeResponse   `json:"last_probe,omitempty"`
+}
+
+type probeResponse struct {
+	Result string `json:"result"`
+	At     string `json:"at"`
+}
+
+type checkerResponse struct {
+	Name   string         `json:"name"`
+	Key    string         `json:"key"`
+	Type   string         `json:"type"`
+	Routes []string       `json:"routes"`
+	Nodes  []nodeResponse `json:"nodes"`
+}
+
+func newCounterResponse(counters proxy.HealthCounters) counterResponse {
+	return counterResponse{
+		Success:        counters.Successes,
+		HTTPFailure:    counters.HTTPFailures,
+		TCPFailure:     counters.TCPFailures,
+		TimeoutFailure: counters.Timeouts,
+	}
+}
+
+func newCheckerResponse(cluster proxy.ClusterHealth, generation *route.Generation) checkerResponse {
+	response := checkerResponse{
+		Name:   cluster.Name,
+		Key:    hex.EncodeToString(cluster.Key[:]),
+		Type:   cluster.Type,
+		Routes: []string{},
+		Nodes:  make([]nodeResponse, 0, len(cluster.Targets)),
+	}
+	if generation != nil {
+		for _, state := range generation.Routes {
+			if slices.Contains(state.Clusters, cluster.Key) {
+				response.Routes = append(response.Routes, state.Route.ID)
+			}
+		}
+	}
+	for _, target := range cluster.Targets {
+		node := nodeResponse{
+			Target:  target.Target,
+			Status:  "healthy",
+			Reason:  target.Reason,
+			Counter: newCounterResponse(target.Passive),
+		}
+		if parsed, err := url.Parse(target.Target); err == nil && parsed.Host != "" {
+			node.IP = parsed.Hostname()
+			node.Port, _ = strconv.Atoi(parsed.Port())
+		} else {
+			node.IP = target.Target
+		}
+		if !target.Healthy {
+			node.Status = "unhealthy"
+			node.EjectedAt = target.EjectedAt.UTC().Format(time.RFC3339)
+		}
+		if target.Active != nil {
+			active := newCounterResponse(*target.Active)
+			node.Active = &active
+		}
+		if target.LastProbe != nil {
+			node.LastProbe = &probeResponse{
+				Result: target.LastProbe.Result,
+				At:     target.LastProbe.At.UTC().Format(time.RFC3339),
+			}
+		}
+		response.Nodes = append(response.Nodes, node)
+	}
+	return response
+}
+
+func (h *Handler) healthcheck(w http.ResponseWriter, _ *http.Request) {
+	generation := h.source.RouteGeneration()
+	clusters := h.source.ClusterHealth()
+	response := make([]checkerResponse, 0, len(clusters))
+	for _, cluster := range clusters {
+		response = append(response, newCheckerResponse(cluster, generation))
+	}
+	_ = util.WriteJSON(w, http.StatusOK, response)
+}
+
+// healthcheckFor reports the checkers of the clusters one route, service or
+// upstream resource leased in the serving generation.
+func (h *Handler) healthcheckFor(w http.ResponseWriter, r *http.Request) {
+	kind, id := chi.URLParam(r, "kind"), chi.URLParam(r, "id")
+	var matches func(route.RouteState) bool
+	switch kind {
+	case "routes":
+		matches = func(state route.RouteState) bool { return state.Route.ID == id }
+	case "services":
+		matches = func(state route.RouteState) bool { return state.Route.ServiceID == id }
+	case "upstreams":
+		matches = func(state route.RouteState) bool { return state.UpstreamID == id }
+	default:
+		writeError(w, http.StatusBadRequest, "invalid src type "+kind)
+		return
+	}
+	generation := h.source.RouteGeneration()
+	var keys []proxy.ClusterKey
+	if generation != nil {
+		for _, state := range generation.Routes {
+			if matches(state) {
+				keys = append(keys, state.Clusters...)
+			}
+		}
+	}
+	response := []checkerResponse{}
+	for _, cluster := range h.source.ClusterHealth() {
+		if slices.Contains(keys, cluster.Key) {
+			response = append(response, newCheckerResponse(cluster, generation))
+		}
+	}
+	if len(response) == 0 {
+		writeError(w, http.StatusNotFound, "no checker for "+kind+"["+id+"]")
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, response)
+}
+
+type routeResponse struct {
+	Key        string   `json:"key"`
+	Value      any      `json:"value"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	UpstreamID string   `json:"upstream_id,omitempty"`
+	Clusters   []string `json:"clusters,omitempty"`
+}
+
+func newRouteResponse(state route.RouteState) routeResponse {
+	response := routeResponse{
+		Key:        "/apisix/routes/" + state.Route.ID,
+		Value:      state.Route,
+		Status:     "published",
+		Error:      state.Error,
+		UpstreamID: state.UpstreamID,
+	}
+	switch {
+	case state.Error != "":
+		response.Status = "quarantined"
+	case state.Route.Disabled():
+		response.Status = "disabled"
+	}
+	for _, key := range state.Clusters {
+		response.Clusters = append(response.Clusters, hex.EncodeToString(key[:]))
+	}
+	return response
+}
+
+func (h *Handler) routes(w http.ResponseWriter, _ *http.Request) {
+	generation := h.source.RouteGeneration()
+	response := []routeResponse{}
+	if generation != nil {
+		for _, state := range generation.Routes {
+			response = append(response, newRouteResponse(state))
+		}
+	}
+	_ = util.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	state, ok := h.source.RouteGeneration().Route(chi.URLParam(r, "id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "route not found")
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, newRouteResponse(state))
+}
+
+type entryResponse struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+// entries lists values sorted by ID as APISIX config entries under prefix.
+func entries[T any](prefix string, values map[string]T) []entryResponse {
+	response := make([]entryResponse, 0, len(values))
+	for _, id := range slices.Sorted(maps.Keys(values)) {
+		response = append(response, entryResponse{Key: prefix + id, Value: values[id]})
+	}
+	return response
+}
+
+func (h *Handler) services(w http.ResponseWriter, _ *http.Request) {
+	generation := h.source.RouteGeneration()
+	if generation == nil {
+		_ = util.WriteJSON(w, http.StatusOK, []entryResponse{})
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, entries("/apisix/services/", generation.Snapshot.Services()))
+}
+
+func (h *Handler) service(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	generation := h.source.RouteGeneration()
+	if generation == nil {
+		writeError(w, http.StatusNotFound, "service not found")
+		return
+	}
+	service, err := generation.Snapshot.GetService(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "service not found")
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, entryResponse{Key: "/apisix/services/" + id, Value: service})
+}
+
+func (h *Handler) upstreams(w http.ResponseWriter, _ *http.Request) {
+	generation := h.source.RouteGeneration()
+	if generation == nil {
+		_ = util.WriteJSON(w, http.StatusOK, []entryResponse{})
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, entries("/apisix/upstreams/", generation.Snapshot.Upstreams()))
+}
+
+func (h *Handler) upstream(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	generation := h.source.RouteGeneration()
+	if generation == nil {
+		writeError(w, http.StatusNotFound, "upstream not found")
+		return
+	}
+	upstream, err := generation.Snapshot.GetUpstream(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "upstream not found")
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, entryResponse{Key: "/apisix/upstreams/" + id, Value: upstream})
+}
+
+type pluginSchemaResponse struct {
+	Schema         json.RawMessage `json:"schema,omitempty"`
+	MetadataSchema json.RawMessage `json:"metadata_schema,omitempty"`
+	Priority       int             `json:"priority"`
+}
+
+// schema reports the schemas of the HTTP plugins the serving generation was
+// built against.
+func (h *Handler) schema(w http.ResponseWriter, _ *http.Request) {
+	var names []string
+	if generation := h.source.RouteGeneration(); generation != nil {
+		names = generation.Plugins
+	} else if cfg := config.Global(); cfg != nil {
+		names = cfg.Plugins
+	}
+	plugins := make(map[string]pluginSchemaResponse, len(names))
+	for _, name := range names {
+		p := plugin.New(name)
+		if p == nil {
+			continue
+		}
+		if err := p.Init(); err != nil {
+			logger.Errorf("control API: initialize plugin %s: %s", name, err)
+			continue
+		}
+		response := pluginSchemaResponse{Priority: p.GetPriority()}
+		if schema := p.GetSchema(); json.Valid([]byte(schema)) {
+			response.Schema = json.RawMessage(schema)
+		}
+		if schema := p.GetMetadataSchema(); json.Valid([]byte(schema)) {
+			response.MetadataSchema = json.RawMessage(schema)
+		}
+		plugins[name] = response
+	}
+	_ = util.WriteJSON(w, http.StatusOK, map[string]any{"plugins": plugins})
+}
+
+func (h *Handler) pluginMetadatas(w http.ResponseWriter, _ *http.Request) {
+	response := []map[string]any{}
+	if generation := h.source.RouteGeneration(); generation != nil {
+		for _, name := range generation.Snapshot.PluginMetadataIDs() {
+			if metadata, ok := generation.Snapshot.PluginMetadata(name); ok {
+				response = append(response, withID(metadata, name))
+			}
+		}
+	}
+	_ = util.WriteJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) pluginMetadata(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	generation := h.source.RouteGeneration()
+	if generation == nil {
+		writeError(w, http.StatusNotFound, "plugin metadata["+name+"] not found")
+		return
+	}
+	metadata, ok := generation.Snapshot.PluginMetadata(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "plugin metadata["+name+"] not found")
+		return
+	}
+	_ = util.WriteJSON(w, http.StatusOK, withID(metadata, name))
+}
+
+func withID(metadata map[string]any, id string) map[string]any {
+	if metadata == nil {
+		metadata = make(map[string]any, 1)
+	}
+	metadata["id"] = id
+	return metadata
+}
+
+// gc is the Go equivalent of APISIX's full Lua GC: it forces a collection
+// and returns freed memory to the operating system.
+func (h *Handler) gc(w http.ResponseWriter, _ *http.Request) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	_ = util.WriteJSON(w, http.StatusOK, map[string]uint64{
+		"heap_alloc_before": before.HeapAlloc,
+		"heap_alloc_after":  after.HeapAlloc,
+	})
+}
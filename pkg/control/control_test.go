@@ -0,0 +1,290 @@
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/route"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+type fakeSource struct {
+	generation *route.Generation
+	health     []proxy.ClusterHealth
+}
+
+func (s *fakeSource) RouteGeneration() *route.Generation {
+	return s.generation
+}
+
+func (s *fakeSource) ClusterHealth() []proxy.ClusterHealth {
+	return s.health
+}
+
+// buildTestGeneration builds resources into a route generation the way the
+// server does.
+func buildTestGeneration(t *testing.T, resources map[string]string) *route.Generation {
+	t.Helper()
+	previous := config.GlobalConfig
+	config.GlobalConfig = &config.Config{Plugins: []string{"cors"}}
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	events := make(chan *store.Event)
+	storage, err := store.Open(filepath.Join(t.TempDir(), "control.db"), events)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	storage.Start()
+	t.Cleanup(func() { _ = storage.Stop() })
+	for key, value := range resources {
+		event := store.NewEvent()
+		event.Type = store.EventTypePut
+		event.Key = []byte(key)
+		event.Value = []byte(value)
+		events <- event
+	}
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	registry := proxy.NewClusterRegistry(proxy.NopClusterObserver{})
+	t.Cleanup(registry.Close)
+	builder := route.NewBuilderWithClusterRegistry(storage, "", registry)
+	t.Cleanup(builder.Stop)
+	if _, err := builder.BuildWithRouteQuarantine(); err != nil {
+		t.Fatalf("BuildWithRouteQuarantine() error = %v", err)
+	}
+	return builder.Generation()
+}
+
+func controlRequest(t *testing.T, handler http.Handler, method, path string, response any) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, "http://127.0.0.1:9090"+path, nil))
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s response %q is not JSON: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestHealthcheckReportsEjectedNodesWithTheirRoutes(t *testing.T) {
+	generation := buildTestGeneration(t, map[string]string{
+		"/apisix/upstreams/u1": `{"id":"u1","nodes":{"10.0.0.1:8080":1,"10.0.0.2:8080":1}}`,
+		"/apisix/routes/r1":    `{"id":"r1","uri":"/r1","upstream_id":"u1"}`,
+		"/apisix/routes/r2":    `{"id":"r2","uri":"/r2","upstream":{"nodes":{"10.0.0.3:8080":1}}}`,
+	})
+	live, _ := generation.Route("r1")
+	ejectedAt := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+	source := &fakeSource{generation: generation, health: []proxy.ClusterHealth{{
+		Key:    live.Clusters[0],
+		Name:   "u1",
+		Type:   "http",
+		Active: true,
+		Targets: []proxy.TargetHealth{
+			{
+				Target:    "http://10.0.0.1:8080",
+				Reason:    "active timeouts",
+				EjectedAt: ejectedAt,
+				Passive:   proxy.HealthCounters{HTTPFailures: 1},
+				Active:    &proxy.HealthCounters{},
+				LastProbe: &proxy.ActiveProbe{Result: "timeout", At: ejectedAt},
+			},
+			{Target: "http://10.0.0.2:8080", Healthy: true, Active: &proxy.HealthCounters{Successes: 2}},
+		},
+	}}}
+	handler := NewHandler(source)
+
+	var checkers []map[string]any
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/healthcheck", &checkers); status != http.StatusOK {
+		t.Fatalf("GET /v1/healthcheck status = %d", status)
+	}
+	if len(checkers) != 1 || checkers[0]["name"] != "u1" || checkers[0]["type"] != "http" {
+		t.Fatalf("checkers = %v, want the u1 checker", checkers)
+	}
+	if routes := checkers[0]["routes"].([]any); len(routes) != 1 || routes[0] != "r1" {
+		t.Fatalf("checker routes = %v, want r1", routes)
+	}
+	nodes := checkers[0]["nodes"].([]any)
+	ejected := nodes[0].(map[string]any)
+	if ejected["ip"] != "10.0.0.1" || ejected["port"] != float64(8080) || ejected["status"] != "unhealthy" ||
+		ejected["reason"] != "active timeouts" || ejected["ejected_at"] != "2026-10-18T08:00:00Z" {
+		t.Fatalf("ejected node = %v, want unhealthy with its ejection reason", ejected)
+	}
+	if counter := ejected["counter"].(map[string]any); counter["http_failure"] != float64(1) {
+		t.Fatalf("ejected node counter = %v, want the passive HTTP failure", counter)
+	}
+	if probe := ejected["last_probe"].(map[string]any); probe["result"] != "timeout" ||
+		probe["at"] != "2026-10-18T08:00:00Z" {
+		t.Fatalf("ejected node last_probe = %v, want the timed out probe", probe)
+	}
+	healthy := nodes[1].(map[string]any)
+	if healthy["status"] != "healthy" || healthy["active_counter"].(map[string]any)["success"] != float64(2) {
+		t.Fatalf("healthy node = %v, want its active success counter", healthy)
+	}
+	if _, ok := healthy["last_probe"]; ok {
+		t.Fatalf("healthy node = %v, want no last_probe before the first probe", healthy)
+	}
+
+	for _, path := range []string{"/v1/healthcheck/routes/r1", "/v1/healthcheck/upstreams/u1"} {
+		var scoped []map[string]any
+		if status := controlRequest(t, handler, http.MethodGet, path, &scoped); status != http.StatusOK ||
+			len(scoped) != 1 {
+			t.Fatalf("GET %s = %d %v, want the u1 checker", path, status, scoped)
+		}
+	}
+	var missing map[string]any
+	status := controlRequest(t, handler, http.MethodGet, "/v1/healthcheck/routes/r2", &missing)
+	if status != http.StatusNotFound || missing["error_msg"] != "no checker for routes[r2]" {
+		t.Fatalf("GET unchecked route = %d %v, want 404", status, missing)
+	}
+	status = controlRequest(t, handler, http.MethodGet, "/v1/healthcheck/consumers/c1", &missing)
+	if status != http.StatusBadRequest {
+		t.Fatalf("GET unknown source type status = %d, want 400", status)
+	}
+}
+
+func TestRoutesReportTheServingGeneration(t *testing.T) {
+	generation := buildTestGeneration(t, map[string]string{
+		"/apisix/routes/live":     `{"id":"live","uri":"/live","upstream":{"nodes":{"10.0.0.1:8080":1}}}`,
+		"/apisix/routes/broken":   `{"id":"broken","uri":"/broken","plugins":{"not-a-plugin":{}}}`,
+		"/apisix/routes/disabled": `{"id":"disabled","uri":"/disabled","status":0}`,
+	})
+	handler := NewHandler(&fakeSource{generation: generation})
+
+	var routes []map[string]any
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/routes", &routes); status != http.StatusOK {
+		t.Fatalf("GET /v1/routes status = %d", status)
+	}
+	statuses := make(map[string]string, len(routes))
+	for _, item := range routes {
+		statuses[item["key"].(string)] = item["status"].(string)
+	}
+	want := map[string]string{
+		"/apisix/routes/live":     "published",
+		"/apisix/routes/broken":   "quarantined",
+		"/apisix/routes/disabled": "disabled",
+	}
+	for key, status := range want {
+		if statuses[key] != status {
+			t.Fatalf("route statuses = %v, want %v", statuses, want)
+		}
+	}
+
+	var broken map[string]any
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/route/broken", &broken); status != http.StatusOK {
+		t.Fatalf("GET /v1/route/broken status = %d", status)
+	}
+	if !strings.Contains(broken["error"].(string), "not-a-plugin") || broken["value"].(map[string]any)["uri"] != "/broken" {
+		t.Fatalf("quarantined route = %v, want its config and build error", broken)
+	}
+	var live map[string]any
+	controlRequest(t, handler, http.MethodGet, "/v1/route/live", &live)
+	if clusters, _ := live["clusters"].([]any); len(clusters) != 1 {
+		t.Fatalf("published route = %v, want its leased cluster", live)
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/route/missing", nil); status != http.StatusNotFound {
+		t.Fatalf("GET missing route status = %d, want 404", status)
+	}
+}
+
+func TestUpstreamsServicesAndPluginMetadataComeFromTheGeneration(t *testing.T) {
+	generation := buildTestGeneration(t, map[string]string{
+		"/apisix/upstreams/u1":         `{"id":"u1","nodes":{"10.0.0.1:8080":1}}`,
+		"/apisix/services/s1":          `{"id":"s1","upstream_id":"u1"}`,
+		"/apisix/plugin_metadata/cors": `{"id":"cors","allow_origins":"*"}`,
+	})
+	handler := NewHandler(&fakeSource{generation: generation})
+
+	var upstreams []map[string]any
+	controlRequest(t, handler, http.MethodGet, "/v1/upstreams", &upstreams)
+	if len(upstreams) != 1 || upstreams[0]["key"] != "/apisix/upstreams/u1" {
+		t.Fatalf("upstreams = %v, want u1", upstreams)
+	}
+	var upstream map[string]any
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/upstream/u1", &upstream); status != http.StatusOK {
+		t.Fatalf("GET /v1/upstream/u1 status = %d", status)
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/upstream/u2", nil); status != http.StatusNotFound {
+		t.Fatalf("GET missing upstream status = %d, want 404", status)
+	}
+	var services []map[string]any
+	controlRequest(t, handler, http.MethodGet, "/v1/services", &services)
+	if len(services) != 1 || services[0]["value"].(map[string]any)["upstream_id"] != "u1" {
+		t.Fatalf("services = %v, want s1", services)
+	}
+
+	var metadatas []map[string]any
+	controlRequest(t, handler, http.MethodGet, "/v1/plugin_metadatas", &metadatas)
+	if len(metadatas) != 1 || metadatas[0]["id"] != "cors" {
+		t.Fatalf("plugin metadatas = %v, want cors", metadatas)
+	}
+	var metadata map[string]any
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/plugin_metadata/cors", &metadata); status != http.StatusOK ||
+		metadata["allow_origins"] != "*" {
+		t.Fatalf("GET /v1/plugin_metadata/cors = %d %v", status, metadata)
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/plugin_metadata/zipkin", nil); status != http.StatusNotFound {
+		t.Fatalf("GET missing plugin metadata status = %d, want 404", status)
+	}
+}
+
+func TestSchemaListsTheGenerationPlugins(t *testing.T) {
+	generation := buildTestGeneration(t, nil)
+	handler := NewHandler(&fakeSource{generation: generation})
+
+	var schema struct {
+		Plugins map[string]struct {
+			Schema   map[string]any `json:"schema"`
+			Priority int            `json:"priority"`
+		} `json:"plugins"`
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/schema", &schema); status != http.StatusOK {
+		t.Fatalf("GET /v1/schema status = %d", status)
+	}
+	cors, ok := schema.Plugins["cors"]
+	if len(schema.Plugins) != 1 || !ok || cors.Schema["type"] != "object" || cors.Priority == 0 {
+		t.Fatalf("schema plugins = %+v, want the cors schema", schema.Plugins)
+	}
+}
+
+func TestGCAndEmptyGeneration(t *testing.T) {
+	handler := NewHandler(&fakeSource{})
+
+	var gc map[string]any
+	if status := controlRequest(t, handler, http.MethodPost, "/v1/gc", &gc); status != http.StatusOK {
+		t.Fatalf("POST /v1/gc status = %d", status)
+	}
+	if _, ok := gc["heap_alloc_after"]; !ok {
+		t.Fatalf("POST /v1/gc = %v, want heap statistics", gc)
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/gc", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /v1/gc status = %d, want 405", status)
+	}
+	for _, path := range []string{"/v1/routes", "/v1/upstreams", "/v1/plugin_metadatas", "/v1/healthcheck"} {
+		var items []any
+		if status := controlRequest(t, handler, http.MethodGet, path, &items); status != http.StatusOK || len(items) != 0 {
+			t.Fatalf("GET %s before the first generation = %d %v, want an empty list", path, status, items)
+		}
+	}
+	if status := controlRequest(t, handler, http.MethodGet, "/v1/route/r1", nil); status != http.StatusNotFound {
+		t.Fatalf("GET route before the first generation status = %d, want 404", status)
+	}
+}
+
+func TestListenAddressDefaultsToLoopback(t *testing.T) {
+	if got := ListenAddress(config.Control{}); got != "127.0.0.1:9090" {
+		t.Fatalf("ListenAddress(default) = %q", got)
+	}
+	if got := ListenAddress(config.Control{Ip: "0.0.0.0", Port: 19090}); got != "0.0.0.0:19090" {
+		t.Fatalf("ListenAddress(configured) = %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package controlplane
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/observability/metrics"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Client streams configuration from control planes into a Store on a data
+// plane. It applies every batch as one acknowledged Store batch.
+type Client struct {
+	hosts   []string
+	nodeID  string
+	events  chan *store.Event
+	timeout time.Duration
+	dialer  *websocket.Dialer
+
+	// epoch and version locate the last applied batch; only Watch uses them.
+	epoch   string
+	version uint64
+
+	readyOnce sync.Once
+	ready     chan struct{}
+}
+
+// NewClient returns a Client for the https:// control plane endpoints in
+// hosts. timeout bounds connecting and applying one batch.
+func NewClient(
+	hosts []string,
+	nodeID string,
+	tlsConfig *tls.Config,
+	timeout time.Duration,
+	events chan *store.Event,
+) *Client {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{
+		hosts:   hosts,
+		nodeID:  nodeID,
+		events:  events,
+		timeout: timeout,
+		dialer: &websocket.Dialer{
+			TLSClientConfig:  tlsConfig,
+			HandshakeTimeout: timeout,
+		},
+		ready: make(chan struct{}),
+	}
+}
+
+// Watch keeps a stream open until ctx is done, moving to the next host after
+// a failure.
+func (c *Client) Watch(ctx context.Context) {
+	if len(c.hosts) == 0 {
+		return
+	}
+	retry := 0
+	for host := 0; ctx.Err() == nil; host = (host + 1) % len(c.hosts) {
+		applied, err := c.stream(ctx, c.hosts[host])
+		if ctx.Err() != nil {
+			return
+		}
+		metrics.RecordEtcdReachable(false)
+		logger.Errorf("control plane stream %s: %s", c.hosts[host], err)
+		if applied {
+			retry = 0
+		}
+		if !waitForRetry(ctx, retry) {
+			return
+		}
+		retry++
+	}
+}
+
+// WaitReady returns once the first batch has been applied.
+func (c *Client) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close implements the producer contract; Watch owns the connection.
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) streamURL(host string) (string, error) {
+	endpoint, err := url.Parse(strings.TrimSpace(host))
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Scheme != "https" {
+		return "", fmt.Errorf("control plane endpoint %q is not https", host)
+	}
+	endpoint.Scheme = "wss"
+	endpoint.Path = StreamPath
+	endpoint.RawQuery = url.Values{
+		"node_id": {c.nodeID},
+		"epoch":   {c.epoch},
+		"version": {strconv.FormatUint(c.version, 10)},
+	}.Encode()
+	return endpoint.String(), nil
+}
+
+// stream applies batches from one host until the stream fails. It reports
+// whether any batch was applied, so a working host resets the backoff.
+func (c *Client) stream(ctx context.Context, host string) (bool, error) {
+	address, err := c.streamURL(host)
+	if err != nil {
+		return false, err
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	conn, response, err := c.dialer.DialContext(dialCtx, address, nil)
+	cancel()
+	if response != nil && response.Body != nil {
+		_ = response.Body.Close()
+	}
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	metrics.RecordEtcdReachable(true)
+
+	_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+	})
+	applied := false
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return applied, err
+		}
+		var batch Batch
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return applied, fmt.Errorf("decode batch: %w", err)
+		}
+		ack := Ack{Version: batch.Version}
+		rejected, err := c.apply(ctx, batch)
+		if err != nil {
+			metrics.RecordConfigApplyStageFailure(metrics.ConfigApplyStageProvider)
+			// The Store may hold part of what this stream sent before; start
+			// over from a snapshot.
+			c.epoch, c.version = "", 0
+			ack.Error = err.Error()
+			_ = c.writeAck(conn, ack)
+			return applied, fmt.Errorf("apply batch %d: %w", batch.Version, err)
+		}
+		metrics.RecordConfigApplyStageSuccess(metrics.ConfigApplyStageProvider)
+		c.epoch, c.version = batch.Epoch, batch.Version
+		applied = true
+		c.readyOnce.Do(func() { close(c.ready) })
+		ack.Rejected = rejected
+		if err := c.writeAck(conn, ack); err != nil {
+			return applied, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+}
+
+func (c *Client) writeAck(conn *websocket.Conn, ack Ack) error {
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+// apply sends a batch to the Store. Mutations the Store rejects are dropped
+// and their current rows preserved, the same way the etcd watcher quarantines
+// them, and the rest is applied again.
+func (c *Client) apply(ctx context.Context, batch Batch) (int, error) {
+	applyCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	mutations, options := batch.storeBatch()
+	err := c.sendBatch(applyCtx, mutations, options)
+	var validationErr *store.BatchValidationError
+	if !errors.As(err, &validationErr) {
+		return 0, err
+	}
+	rejected := make(map[int]struct{}, len(validationErr.Rejected))
+	for _, rejection := range validationErr.Rejected {
+		if rejection.Index < 0 || rejection.Index >= len(mutations) || rejection.Err == nil {
+			return 0, fmt.Errorf("batch validation returned invalid rejection for mutation %d", rejection.Index)
+		}
+		rejected[rejection.Index] = struct{}{}
+		options.Preserve = append(options.Preserve, store.ResourceKey{
+			Bucket: rejection.Err.Bucket,
+			ID:     rejection.Err.ID,
+		})
+		logger.Errorf("quarantine invalid control plane resource key=%q: %s", mutations[rejection.Index].Key, rejection.Err)
+	}
+	pruned := make([]store.Mutation, 0, len(mutations)-len(rejected))
+	for index, mutation := range mutations {
+		if _, isRejected := rejected[index]; !isRejected {
+			pruned = append(pruned, mutation)
+		}
+	}
+	if err := c.sendBatch(applyCtx, pruned, options); err != nil {
+		return 0, fmt.Errorf("batch validation retry failed: %w", err)
+	}
+	return len(rejected), nil
+}
+
+func (c *Client) sendBatch(ctx context.Context, mutations []store.Mutation, options store.BatchOptions) error {
+	event := store.NewAcknowledgedBatch(mutations, options)
+	select {
+	case c.events <- event:
+		return event.Wait(ctx)
+	case <-ctx.Done():
+		store.PutBack(event)
+		return ctx.Err()
+	}
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := 100 * time.Millisecond
+	for range min(attempt, 6) {
+		delay *= 2
+	}
+	return min(delay, 5*time.Second)
+}
+
+func waitForRetry(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(retryDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,296 @@
+package controlplane
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+const (
+	// maxLogBatches bounds the batches kept for resuming streams; a data plane
+	// further behind than this receives a snapshot instead.
+	maxLogBatches = 256
+	ackTimeout    = 30 * time.Second
+)
+
+var errHubClosed = errors.New("control plane hub closed")
+
+// Hub numbers the batches a control plane applies and serves them to data
+// plane streams.
+type Hub struct {
+	snapshot func() ([]store.Mutation, error)
+	upgrader websocket.Upgrader
+
+	// mu serializes Commit with building the batch a stream sends next, so a
+	// snapshot always matches the version it is sent with.
+	mu      sync.Mutex
+	epoch   string
+	version uint64
+	log     []Batch
+	changed chan struct{}
+	closed  bool
+
+	done    chan struct{}
+	streams sync.WaitGroup
+}
+
+// NewHub returns a Hub that sends the result of snapshot to data planes it
+// cannot resume. The epoch is random, so data planes resnapshot when they move
+// to a restarted or different control plane.
+func NewHub(snapshot func() ([]store.Mutation, error)) *Hub {
+	epoch := make([]byte, 16)
+	_, _ = rand.Read(epoch)
+	return &Hub{
+		snapshot: snapshot,
+		epoch:    hex.EncodeToString(epoch),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Commit applies one batch to the control plane and, when apply succeeds,
+// publishes it to the streams under the next version.
+func (h *Hub) Commit(mutations []store.Mutation, options store.BatchOptions, apply func() error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := apply(); err != nil {
+		return err
+	}
+	h.version++
+	h.log = append(h.log, newBatch(h.epoch, h.version, mutations, options))
+	if len(h.log) > maxLogBatches {
+		h.log = append([]Batch(nil), h.log[len(h.log)-maxLogBatches:]...)
+	}
+	close(h.changed)
+	h.changed = make(chan struct{})
+	return nil
+}
+
+// Relay moves acknowledged batches from source to sink through Commit until
+// ctx is done, acknowledging each one to its producer with the sink result.
+func (h *Hub) Relay(ctx context.Context, source <-chan *store.Event, sink chan<- *store.Event) {
+	for {
+		var event *store.Event
+		select {
+		case event = <-source:
+		case <-ctx.Done():
+			return
+		}
+		mutations, options, ok := event.Batch()
+		if !ok {
+			select {
+			case sink <- event:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		err := h.Commit(mutations, options, func() error {
+			forwarded := store.NewAcknowledgedBatch(mutations, options)
+			select {
+			case sink <- forwarded:
+				return forwarded.Wait(ctx)
+			case <-ctx.Done():
+				store.PutBack(forwarded)
+				return ctx.Err()
+			}
+		})
+		event.Acknowledge(err)
+	}
+}
+
+// next returns the batch that follows version in epoch. When the stream is
+// current it returns false and a channel closed by the next Commit.
+func (h *Hub) next(epoch string, version uint64) (Batch, bool, <-chan struct{}, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return Batch{}, false, nil, errHubClosed
+	}
+	if epoch == h.epoch {
+		if version == h.version {
+			return Batch{}, false, h.changed, nil
+		}
+		if len(h.log) > 0 && version < h.version && version+1 >= h.log[0].Version {
+			return h.log[version+1-h.log[0].Version], true, nil, nil
+		}
+	}
+	mutations, err := h.snapshot()
+	if err != nil {
+		return Batch{}, false, nil, fmt.Errorf("snapshot control plane store: %w", err)
+	}
+	return newBatch(h.epoch, h.version, mutations, store.BatchOptions{ReplaceManaged: true}), true, nil, nil
+}
+
+// Handler serves data plane streams. The conf_server TLS listener has already
+// verified the client certificate.
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != StreamPath {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		var version uint64
+		if raw := query.Get("version"); raw != "" {
+			parsed, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid version", http.StatusBadRequest)
+				return
+			}
+			version = parsed
+		}
+		if !h.join() {
+			http.Error(w, errHubClosed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer h.streams.Done()
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		nodeID := query.Get("node_id")
+		logger.Infof("data plane %s connected from %s", nodeID, r.RemoteAddr)
+		if err := h.serve(conn, query.Get("epoch"), version); err != nil && !errors.Is(err, errHubClosed) {
+			logger.Warnf("data plane %s stream closed: %s", nodeID, err)
+		}
+	})
+}
+
+func (h *Hub) join() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.streams.Add(1)
+	return true
+}
+
+func (h *Hub) serve(conn *websocket.Conn, epoch string, version uint64) error {
+	acks := make(chan Ack)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		})
+		for {
+			_, payload, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var ack Ack
+			if err := json.Unmarshal(payload, &ack); err != nil {
+				return
+			}
+			select {
+			case acks <- ack:
+			case <-h.done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		_ = conn.Close()
+		<-readerDone
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		batch, ok, changed, err := h.next(epoch, version)
+		if err != nil {
+			closeStream(conn)
+			return err
+		}
+		if !ok {
+			select {
+			case <-changed:
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					return err
+				}
+			case <-readerDone:
+				return errors.New("stream closed by data plane")
+			case <-h.done:
+				closeStream(conn)
+				return errHubClosed
+			}
+			continue
+		}
+
+		payload, err := json.Marshal(batch)
+		if err != nil {
+			return fmt.Errorf("encode batch %d: %w", batch.Version, err)
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			return fmt.Errorf("send batch %d: %w", batch.Version, err)
+		}
+		ack, err := h.waitAck(acks, readerDone)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", batch.Version, err)
+		}
+		if ack.Version != batch.Version {
+			return fmt.Errorf("acknowledged version %d, want %d", ack.Version, batch.Version)
+		}
+		if ack.Error != "" {
+			return fmt.Errorf("data plane failed to apply batch %d: %s", batch.Version, ack.Error)
+		}
+		if ack.Rejected > 0 {
+			logger.Warnf("data plane quarantined %d mutation(s) of batch %d", ack.Rejected, batch.Version)
+		}
+		epoch, version = batch.Epoch, batch.Version
+	}
+}
+
+func (h *Hub) waitAck(acks <-chan Ack, readerDone <-chan struct{}) (Ack, error) {
+	timer := time.NewTimer(ackTimeout)
+	defer timer.Stop()
+	select {
+	case ack := <-acks:
+		return ack, nil
+	case <-timer.C:
+		return Ack{}, errors.New("timed out waiting for acknowledgement")
+	case <-readerDone:
+		return Ack{}, errors.New("stream closed before acknowledgement")
+	case <-h.done:
+		return Ack{}, errHubClosed
+	}
+}
+
+func closeStream(conn *websocket.Conn) {
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+		time.Now().Add(writeTimeout),
+	)
+}
+
+// Close ends every stream and waits for their handlers to return. Call it
+// before shutting down the conf_server: http.Server.Shutdown does not wait
+// for hijacked WebSocket connections.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.done)
+	h.mu.Unlock()
+	h.streams.Wait()
+}
@@ -0,0 +1,271 @@
+package controlplane
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+type testPKI struct {
+	serverCert, serverKey string
+	clientCert, clientKey string
+	caCert                string
+}
+
+func writeTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(name+".crt", "CERTIFICATE", der), write(name+".key", "EC PRIVATE KEY", keyDER)
+	}
+	pki := testPKI{caCert: write("ca.crt", "CERTIFICATE", caDER)}
+	pki.serverCert, pki.serverKey = issue("control-plane", 2, x509.ExtKeyUsageServerAuth)
+	pki.clientCert, pki.clientKey = issue("data-plane", 3, x509.ExtKeyUsageClientAuth)
+	return pki
+}
+
+func openTestStore(t *testing.T, name string) (*store.Store, chan *store.Event) {
+	t.Helper()
+	events := make(chan *store.Event)
+	storage, err := store.Open(filepath.Join(t.TempDir(), name), events)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	storage.Start()
+	t.Cleanup(func() { _ = storage.Stop() })
+	return storage, events
+}
+
+// startControlPlane relays batches sent to the returned channel into a
+// control plane Store and serves them on an mTLS conf_server.
+func startControlPlane(t *testing.T, pki testPKI) (chan *store.Event, *httptest.Server) {
+	t.Helper()
+	storage, events := openTestStore(t, "control-plane.db")
+	hub := NewHub(storage.ManagedSnapshot)
+	source := make(chan *store.Event)
+	ctx, cancel := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		hub.Relay(ctx, source, events)
+	}()
+
+	tlsConfig, err := NewServerTLSConfig(pki.serverCert, pki.serverKey, pki.caCert)
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig() error = %v", err)
+	}
+	server := httptest.NewUnstartedServer(hub.Handler())
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(func() {
+		hub.Close()
+		server.Close()
+		cancel()
+		<-relayDone
+	})
+	return source, server
+}
+
+func produce(t *testing.T, source chan *store.Event, mutations []store.Mutation, options store.BatchOptions) {
+	t.Helper()
+	event := store.NewAcknowledgedBatch(mutations, options)
+	source <- event
+	if err := event.Wait(context.Background()); err != nil {
+		t.Fatalf("apply control plane batch: %v", err)
+	}
+}
+
+func put(key, value string) store.Mutation {
+	return store.Mutation{Type: store.EventTypePut, Key: []byte(key), Value: []byte(value)}
+}
+
+func waitForRoutes(t *testing.T, storage *store.Store, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, err := storage.SnapshotBuckets([]string{"routes"})
+		if err != nil {
+			t.Fatalf("SnapshotBuckets() error = %v", err)
+		}
+		ids := make([]string, 0, len(snapshot["routes"]))
+		for id := range snapshot["routes"] {
+			ids = append(ids, id)
+		}
+		if len(ids) == 1 && ids[0] == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("data plane routes = %v, want [%s]", ids, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientStreamsSnapshotThenBatches(t *testing.T) {
+	pki := writeTestPKI(t)
+	source, server := startControlPlane(t, pki)
+	produce(t, source, []store.Mutation{put("/apisix/routes/r1", `{"uri":"/r1"}`)}, store.BatchOptions{})
+
+	dataPlane, events := openTestStore(t, "data-plane.db")
+	tlsConfig, err := NewClientTLSConfig(pki.clientCert, pki.clientKey, pki.caCert, "")
+	if err != nil {
+		t.Fatalf("NewClientTLSConfig() error = %v", err)
+	}
+	client := NewClient([]string{server.URL}, "dp-1", tlsConfig, time.Second, events)
+	ctx, cancel := context.WithCancel(context.Background())
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		client.Watch(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-watchDone
+	})
+
+	readyCtx, readyCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readyCancel()
+	if err := client.WaitReady(readyCtx); err != nil {
+		t.Fatalf("WaitReady() error = %v", err)
+	}
+	waitForRoutes(t, dataPlane, "r1")
+
+	produce(t, source, []store.Mutation{
+		put("/apisix/routes/r2", `{"uri":"/r2"}`),
+		{Type: store.EventTypeDelete, Key: []byte("/apisix/routes/r1")},
+	}, store.BatchOptions{})
+	waitForRoutes(t, dataPlane, "r2")
+}
+
+func TestHubResumesFromLogAndSnapshotsOtherwise(t *testing.T) {
+	snapshots := 0
+	hub := NewHub(func() ([]store.Mutation, error) {
+		snapshots++
+		return []store.Mutation{put("/apisix/routes/r1", `{}`)}, nil
+	})
+	commit := func(key string) {
+		t.Helper()
+		err := hub.Commit([]store.Mutation{put(key, `{}`)}, store.BatchOptions{}, func() error { return nil })
+		if err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+	commit("/apisix/routes/r1")
+	commit("/apisix/routes/r2")
+
+	batch, ok, _, err := hub.next(hub.epoch, 1)
+	if err != nil || !ok || batch.Replace || batch.Version != 2 || batch.Mutations[0].Key != "/apisix/routes/r2" {
+		t.Fatalf("next(epoch, 1) = %+v, %t, %v; want logged batch 2", batch, ok, err)
+	}
+	if _, ok, changed, err := hub.next(hub.epoch, 2); err != nil || ok || changed == nil {
+		t.Fatalf("next(epoch, 2) = %t, %v; want wait for change", ok, err)
+	}
+	batch, ok, _, err = hub.next("previous-control-plane", 2)
+	if err != nil || !ok || !batch.Replace || batch.Version != 2 || snapshots != 1 {
+		t.Fatalf("next(other epoch) = %+v, %t, %v; want snapshot at version 2", batch, ok, err)
+	}
+
+	for range maxLogBatches {
+		commit("/apisix/routes/r3")
+	}
+	batch, ok, _, err = hub.next(hub.epoch, 1)
+	if err != nil || !ok || !batch.Replace || snapshots != 2 {
+		t.Fatalf("next() past the log = %+v, %t, %v; want snapshot", batch, ok, err)
+	}
+}
+
+func TestHubDoesNotPublishFailedCommit(t *testing.T) {
+	hub := NewHub(func() ([]store.Mutation, error) { return nil, nil })
+	err := hub.Commit([]store.Mutation{put("/apisix/routes/r1", `{}`)}, store.BatchOptions{}, func() error {
+		return context.DeadlineExceeded
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Commit() error = %v, want apply error", err)
+	}
+	if hub.version != 0 || len(hub.log) != 0 {
+		t.Fatalf("failed commit published version %d with %d logged batches", hub.version, len(hub.log))
+	}
+}
+
+func TestConfServerRejectsDataPlaneWithoutCertificate(t *testing.T) {
+	pki := writeTestPKI(t)
+	_, server := startControlPlane(t, pki)
+	trusted, err := loadCertPool(pki.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: trusted}}
+	conn, response, err := dialer.Dial(strings.Replace(server.URL, "https://", "wss://", 1)+StreamPath, nil)
+	if response != nil {
+		_ = response.Body.Close()
+	}
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("stream opened without a client certificate")
+	}
+}
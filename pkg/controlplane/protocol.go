@@ -0,0 +1,92 @@
+// Package controlplane streams the managed configuration of a control plane
+// to its data planes.
+//
+// A control plane relays every batch its etcd watcher applies to the local
+// Store through a Hub, which numbers the batches and keeps the latest of them.
+// A data plane holds one WebSocket stream open and resumes it with the epoch
+// and version of the last batch it applied: the Hub replays the batches it
+// missed, or sends a full snapshot when the epoch changed or the gap has left
+// the log. Each batch is acknowledged before the next one is sent.
+package controlplane
+
+import (
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+// StreamPath is the conf_server path data planes open their stream on.
+const StreamPath = "/apisix/control_plane/v1/stream"
+
+const (
+	// pingInterval is how often the Hub pings an idle stream; both ends drop a
+	// stream that has been silent for idleTimeout.
+	pingInterval = 10 * time.Second
+	idleTimeout  = 3 * pingInterval
+	writeTimeout = 10 * time.Second
+)
+
+// Batch is one versioned set of Store mutations. A Replace batch carries the
+// whole managed configuration at Version.
+type Batch struct {
+	Epoch     string        `json:"epoch"`
+	Version   uint64        `json:"version"`
+	Replace   bool          `json:"replace,omitempty"`
+	Preserve  []ResourceKey `json:"preserve,omitempty"`
+	Mutations []Mutation    `json:"mutations"`
+}
+
+type ResourceKey struct {
+	Bucket string `json:"bucket"`
+	ID     string `json:"id"`
+}
+
+type Mutation struct {
+	Delete bool   `json:"delete,omitempty"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// Ack reports the result of applying a batch. Rejected counts the mutations
+// the data plane quarantined; Error is set when the batch was not applied.
+type Ack struct {
+	Version  uint64 `json:"version"`
+	Rejected int    `json:"rejected,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newBatch(epoch string, version uint64, mutations []store.Mutation, options store.BatchOptions) Batch {
+	batch := Batch{
+		Epoch:     epoch,
+		Version:   version,
+		Replace:   options.ReplaceManaged,
+		Mutations: make([]Mutation, len(mutations)),
+	}
+	for _, key := range options.Preserve {
+		batch.Preserve = append(batch.Preserve, ResourceKey{Bucket: key.Bucket, ID: key.ID})
+	}
+	for index, mutation := range mutations {
+		batch.Mutations[index] = Mutation{
+			Delete: mutation.Type == store.EventTypeDelete,
+			Key:    string(mutation.Key),
+			Value:  mutation.Value,
+		}
+	}
+	return batch
+}
+
+func (b Batch) storeBatch() ([]store.Mutation, store.BatchOptions) {
+	mutations := make([]store.Mutation, len(b.Mutations))
+	for index, mutation := range b.Mutations {
+		eventType := store.EventTypePut
+		if mutation.Delete {
+			eventType = store.EventTypeDelete
+		}
+		mutations[index] = store.Mutation{Type: eventType, Key: []byte(mutation.Key), Value: mutation.Value}
+	}
+	options := store.BatchOptions{ReplaceManaged: b.Replace}
+	for _, key := range b.Preserve {
+		options.Preserve = append(options.Preserve, store.ResourceKey{Bucket: key.Bucket, ID: key.ID})
+	}
+	return mutations, options
+}
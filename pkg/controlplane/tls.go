@@ -0,0 +1,60 @@
+package controlplane
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// NewServerTLSConfig returns the conf_server TLS config: it serves cert and
+// only accepts data planes presenting a certificate signed by clientCA.
+func NewServerTLSConfig(certPath, keyPath, clientCAPath string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load conf_server certificate: %w", err)
+	}
+	clientCAs, err := loadCertPool(clientCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("load conf_server client CA: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}, nil
+}
+
+// NewClientTLSConfig returns the data plane TLS config: it presents cert and
+// verifies control planes against trustedCA, or the system roots when empty.
+func NewClientTLSConfig(certPath, keyPath, trustedCAPath, serverName string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load data plane certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		ServerName:   serverName,
+	}
+	if trustedCAPath != "" {
+		config.RootCAs, err = loadCertPool(trustedCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("load trusted CA: %w", err)
+		}
+	}
+	return config, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type consulConfig struct {
+	Servers []string `json:"servers"`
+	Token   string   `json:"token"`
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+		Weights struct {
+			Passing int `json:"Passing"`
+		} `json:"Weights"`
+	} `json:"Service"`
+}
+
+// consulProvider resolves services through the Consul health API, returning
+// only instances whose checks are passing.
+type consulProvider struct {
+	client *registryClient
+}
+
+func newConsulProviderFromConfig(raw map[string]any) (Provider, error) {
+	var conf consulConfig
+	if err := decodeConfig(raw, &conf); err != nil {
+		return nil, err
+	}
+	client, err := newRegistryClient(conf.Servers, "servers")
+	if err != nil {
+		return nil, err
+	}
+	if token := strings.TrimSpace(conf.Token); token != "" {
+		client.headers = func() (http.Header, error) {
+			return http.Header{"X-Consul-Token": []string{token}}, nil
+		}
+	}
+	return &consulProvider{client: client}, nil
+}
+
+func (p *consulProvider) Nodes(ctx context.Context, service string) ([]Node, error) {
+	var entries []consulServiceEntry
+	if err := p.client.getJSON(ctx, "/v1/health/service/"+url.PathEscape(service)+"?passing=true", &entries); err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		weight := entry.Service.Weights.Passing
+		if weight <= 0 {
+			weight = 1
+		}
+		nodes = append(nodes, Node{Host: host, Port: entry.Service.Port, Weight: weight})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,436 @@
+// Package discovery resolves upstream `discovery_type` / `service_name`
+// references into node snapshots.
+//
+// A Registry owns one Provider per configured discovery type. Route builds
+// lease the services they reference and read the latest snapshot without
+// touching the provider; a background refresher seeds newly leased services,
+// polls every leased service and notifies the owner when any node set changes
+// so the routes are rebuilt. A service is forgotten once no route generation
+// holds a lease on it. Because cluster identity is derived from the resolved
+// targets, a membership change produces a new proxy cluster (with fresh health
+// and in-flight state) while unchanged upstreams keep their existing one.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	defaultFetchInterval = 30 * time.Second
+	defaultFetchTimeout  = 5 * time.Second
+)
+
+// ErrServiceNotFound reports that a provider has no record of the service.
+var ErrServiceNotFound = errors.New("service not found")
+
+// ErrServicePending reports that a leased service has not resolved yet. The
+// refresher seeds it in the background and notifies the owner once it does.
+var ErrServicePending = errors.New("service has not resolved yet")
+
+// Node is one discovered upstream endpoint. A zero Port lets the route use
+// the scheme default; Priority follows upstream node semantics where higher
+// values are tried first.
+type Node struct {
+	Host     string
+	Port     int
+	Weight   int
+	Priority int
+}
+
+// Provider resolves a service name into its current nodes. Implementations
+// must be safe for concurrent use and honor ctx cancellation.
+type Provider interface {
+	Nodes(ctx context.Context, service string) ([]Node, error)
+}
+
+type providerFactory struct {
+	create   func(raw map[string]any) (Provider, error)
+	interval time.Duration
+}
+
+var providerFactories = map[string]providerFactory{
+	"dns":        {create: newDNSProviderFromConfig, interval: defaultFetchInterval},
+	"file":       {create: newFileProviderFromConfig, interval: time.Second},
+	"consul":     {create: newConsulProviderFromConfig, interval: 3 * time.Second},
+	"nacos":      {create: newNacosProviderFromConfig, interval: defaultFetchInterval},
+	"kubernetes": {create: newKubernetesProviderFromConfig, interval: defaultFetchInterval},
+}
+
+type serviceKey struct {
+	discoveryType string
+	service       string
+}
+
+type snapshot struct {
+	nodes    []Node
+	resolved bool
+	// err is the last failed fetch of a service that has not resolved yet.
+	err error
+	// refs counts the leases held on the service.
+	refs int
+}
+
+type registeredProvider struct {
+	provider Provider
+	interval time.Duration
+	// seed wakes the refresher when a build leases a new service.
+	seed chan struct{}
+}
+
+func newRegisteredProvider(provider Provider, interval time.Duration) registeredProvider {
+	return registeredProvider{provider: provider, interval: interval, seed: make(chan struct{}, 1)}
+}
+
+// Registry caches node snapshots for every service a route generation has
+// leased.
+type Registry struct {
+	providers map[string]registeredProvider
+	timeout   time.Duration
+
+	mu        sync.RWMutex
+	snapshots map[serviceKey]snapshot
+
+	lifecycleMu sync.Mutex
+	cancel      context.CancelFunc
+	done        sync.WaitGroup
+}
+
+// NewRegistry builds providers from the top-level `discovery` config section.
+// An empty section yields an empty registry; unknown discovery types and
+// invalid provider settings fail so startup never silently drops a section.
+func NewRegistry(conf map[string]any) (*Registry, error) {
+	registry := &Registry{
+		providers: make(map[string]registeredProvider, len(conf)),
+		timeout:   defaultFetchTimeout,
+		snapshots: make(map[serviceKey]snapshot),
+	}
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		factory, ok := providerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("discovery.%s is unsupported", name)
+		}
+		raw, ok := conf[name].(map[string]any)
+		if !ok && conf[name] != nil {
+			return nil, fmt.Errorf("discovery.%s must be an object", name)
+		}
+		provider, err := factory.create(raw)
+		if err != nil {
+			return nil, fmt.Errorf("discovery.%s: %w", name, err)
+		}
+		interval, err := fetchInterval(raw, factory.interval)
+		if err != nil {
+			return nil, fmt.Errorf("discovery.%s: %w", name, err)
+		}
+		registry.providers[name] = newRegisteredProvider(provider, interval)
+	}
+	return registry, nil
+}
+
+// Register installs provider under discoveryType, replacing any existing
+// provider. It must be called before Start.
+func (r *Registry) Register(discoveryType string, provider Provider, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultFetchInterval
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[discoveryType] = newRegisteredProvider(provider, interval)
+}
+
+// Has reports whether discoveryType is configured.
+func (r *Registry) Has(discoveryType string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.providers[discoveryType]
+	return ok
+}
+
+// ServiceLease pins one service for the route generation that acquired it.
+// The registry keeps refreshing a service while any lease on it is held and
+// drops its snapshot once the last lease stops.
+type ServiceLease struct {
+	registry *Registry
+	key      serviceKey
+	once     sync.Once
+}
+
+// Nodes returns the current snapshot of the leased service. A service that
+// has not resolved yet reports ErrServicePending, wrapping the last fetch
+// failure when there was one.
+func (l *ServiceLease) Nodes() ([]Node, error) {
+	l.registry.mu.RLock()
+	defer l.registry.mu.RUnlock()
+	current := l.registry.snapshots[l.key]
+	if !current.resolved {
+		if current.err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrServicePending, current.err)
+		}
+		return nil, ErrServicePending
+	}
+	return slices.Clone(current.nodes), nil
+}
+
+// Stop releases the lease. It is safe to call more than once.
+func (l *ServiceLease) Stop() {
+	l.once.Do(func() { l.registry.release(l.key) })
+}
+
+// Acquire leases service for a route build. The first lease on a service
+// enrolls it and wakes the refresher to seed it, so a build never waits on
+// the provider; until the seed lands the lease reports ErrServicePending.
+func (r *Registry) Acquire(discoveryType, service string) (*ServiceLease, error) {
+	key := serviceKey{discoveryType: discoveryType, service: service}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	registered, ok := r.providers[discoveryType]
+	if !ok {
+		return nil, fmt.Errorf("discovery %q is not configured", discoveryType)
+	}
+	current, enrolled := r.snapshots[key]
+	current.refs++
+	r.snapshots[key] = current
+	if !enrolled {
+		select {
+		case registered.seed <- struct{}{}:
+		default:
+		}
+	}
+	return &ServiceLease{registry: r, key: key}, nil
+}
+
+func (r *Registry) release(key serviceKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.snapshots[key]
+	if !ok {
+		return
+	}
+	current.refs--
+	if current.refs <= 0 {
+		delete(r.snapshots, key)
+		return
+	}
+	r.snapshots[key] = current
+}
+
+// Start launches one refresher per provider. Each refresher seeds newly
+// leased services as soon as they are enrolled, including those leased before
+// Start, and re-resolves every leased service on its interval. onChange runs
+// after a pass publishes at least one changed snapshot.
+func (r *Registry) Start(ctx context.Context, onChange func()) {
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, registered := range r.providers {
+		r.done.Add(1)
+		go func() {
+			defer r.done.Done()
+			ticker := time.NewTicker(registered.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-registered.seed:
+					if r.refresh(ctx, name, true) && onChange != nil {
+						onChange()
+					}
+				case <-ticker.C:
+					if r.Refresh(ctx, name) && onChange != nil {
+						onChange()
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Refresh re-resolves every leased service of discoveryType and reports
+// whether any snapshot changed. A failed fetch keeps the last good snapshot.
+func (r *Registry) Refresh(ctx context.Context, discoveryType string) bool {
+	return r.refresh(ctx, discoveryType, false)
+}
+
+// refresh re-resolves the leased services of discoveryType, or only those that
+// have not resolved yet when pendingOnly is set.
+func (r *Registry) refresh(ctx context.Context, discoveryType string, pendingOnly bool) bool {
+	r.mu.RLock()
+	registered, ok := r.providers[discoveryType]
+	var services []string
+	for key, current := range r.snapshots {
+		if key.discoveryType == discoveryType && (!pendingOnly || !current.resolved) {
+			services = append(services, key.service)
+		}
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	sort.Strings(services)
+
+	changed := false
+	for _, service := range services {
+		if ctx.Err() != nil {
+			return changed
+		}
+		nodes, err := r.fetch(ctx, registered.provider, service)
+		key := serviceKey{discoveryType: discoveryType, service: service}
+		r.mu.Lock()
+		previous, leased := r.snapshots[key]
+		switch {
+		case !leased:
+			// The last lease stopped while the fetch was in flight.
+		case err != nil:
+			logger.Warnf("discovery %s: refresh service %q fail: %s", discoveryType, service, err)
+			if !previous.resolved {
+				previous.err = err
+				r.snapshots[key] = previous
+			}
+		case !previous.resolved || !slices.Equal(previous.nodes, nodes):
+			r.snapshots[key] = snapshot{nodes: nodes, resolved: true, refs: previous.refs}
+			changed = true
+			logger.Infof("discovery %s: service %q now has %d nodes", discoveryType, service, len(nodes))
+		}
+		r.mu.Unlock()
+	}
+	return changed
+}
+
+// Len returns the number of leased services.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.snapshots)
+}
+
+// Close stops the background refreshers. It is safe to call more than once.
+func (r *Registry) Close() {
+	if r == nil {
+		return
+	}
+	r.lifecycleMu.Lock()
+	cancel := r.cancel
+	r.lifecycleMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	r.done.Wait()
+}
+
+func (r *Registry) fetch(ctx context.Context, provider Provider, service string) ([]Node, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	nodes, err := provider.Nodes(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeNodes(nodes), nil
+}
+
+// normalizeNodes drops unusable entries and sorts the rest so snapshots can
+// be compared for equality regardless of provider ordering.
+func normalizeNodes(nodes []Node) []Node {
+	result := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		node.Host = strings.TrimSpace(node.Host)
+		if node.Host == "" || node.Port < 0 || node.Port > 65535 || node.Weight <= 0 {
+			continue
+		}
+		result = append(result, node)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Host != result[j].Host {
+			return result[i].Host < result[j].Host
+		}
+		if result[i].Port != result[j].Port {
+			return result[i].Port < result[j].Port
+		}
+		if result[i].Priority != result[j].Priority {
+			return result[i].Priority < result[j].Priority
+		}
+		return result[i].Weight < result[j].Weight
+	})
+	return slices.Compact(result)
+}
+
+func fetchInterval(raw map[string]any, fallback time.Duration) (time.Duration, error) {
+	value, ok := raw["fetch_interval"]
+	if !ok {
+		return fallback, nil
+	}
+	var seconds float64
+	switch typed := value.(type) {
+	case int:
+		seconds = float64(typed)
+	case int64:
+		seconds = float64(typed)
+	case float64:
+		seconds = typed
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		if err != nil {
+			return 0, fmt.Errorf("fetch_interval must be a number of seconds")
+		}
+		seconds = parsed
+	default:
+		return 0, fmt.Errorf("fetch_interval must be a number of seconds")
+	}
+	if seconds <= 0 {
+		return 0, fmt.Errorf("fetch_interval must be positive")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// decodeConfig converts a loosely typed config section into dest through the
+// project JSON codec, matching how plugin configs are materialized.
+func decodeConfig(raw map[string]any, dest any) error {
+	if raw == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, dest)
+}
+
+// splitHostPort parses `host[:port]`, leaving port zero when absent.
+func splitHostPort(address string) (string, int, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return strings.Trim(address, "[]"), 0, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in %q", address)
+	}
+	return host, port, nil
+}
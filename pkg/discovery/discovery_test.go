@@ -0,0 +1,256 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubProvider struct {
+	mu    sync.Mutex
+	nodes map[string][]Node
+	err   error
+	calls int
+}
+
+func (p *stubProvider) Nodes(_ context.Context, service string) ([]Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return append([]Node(nil), p.nodes[service]...), nil
+}
+
+func (p *stubProvider) set(service string, nodes []Node, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.nodes == nil {
+		p.nodes = map[string][]Node{}
+	}
+	p.nodes[service] = nodes
+	p.err = err
+}
+
+func TestNewRegistryRejectsUnknownAndInvalidSections(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		conf    map[string]any
+		wantErr string
+	}{
+		{name: "unknown type", conf: map[string]any{"eureka": map[string]any{}}, wantErr: "discovery.eureka is unsupported"},
+		{name: "not an object", conf: map[string]any{"dns": "127.0.0.1"}, wantErr: "must be an object"},
+		{name: "file without path", conf: map[string]any{"file": map[string]any{}}, wantErr: "path is required"},
+		{
+			name:    "consul without servers",
+			conf:    map[string]any{"consul": map[string]any{"servers": []any{}}},
+			wantErr: "servers must contain",
+		},
+		{
+			name:    "bad fetch interval",
+			conf:    map[string]any{"file": map[string]any{"path": "x", "fetch_interval": -1}},
+			wantErr: "fetch_interval must be positive",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewRegistry(test.conf)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("NewRegistry() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+
+	registry, err := NewRegistry(nil)
+	if err != nil {
+		t.Fatalf("NewRegistry(nil) error = %v", err)
+	}
+	if registry.Has("dns") {
+		t.Fatal("empty registry reports dns as configured")
+	}
+}
+
+func TestRegistryCachesSnapshotAndNormalizesNodes(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", []Node{
+		{Host: "10.0.0.2", Port: 80, Weight: 1},
+		{Host: "10.0.0.1", Port: 80, Weight: 1},
+		{Host: "10.0.0.1", Port: 80, Weight: 1},
+		{Host: "10.0.0.3", Port: 80, Weight: 0},
+		{Host: " ", Port: 80, Weight: 1},
+	}, nil)
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, time.Hour)
+	lease := acquire(t, registry, "stub", "orders")
+
+	nodes, err := lease.Nodes()
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	want := []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}, {Host: "10.0.0.2", Port: 80, Weight: 1}}
+	if len(nodes) != len(want) || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Fatalf("Nodes() = %#v, want %#v", nodes, want)
+	}
+	nodes[0].Host = "mutated"
+	acquire(t, registry, "stub", "orders")
+	if provider.calls != 1 {
+		t.Fatalf("provider calls = %d, want cached snapshot", provider.calls)
+	}
+	again, _ := lease.Nodes()
+	if again[0].Host != "10.0.0.1" {
+		t.Fatalf("cached snapshot was mutated through a returned slice: %#v", again)
+	}
+
+	if _, err := registry.Acquire("missing", "orders"); err == nil {
+		t.Fatal("Acquire() for an unconfigured discovery type error = nil")
+	}
+}
+
+func TestRegistryAcquireDoesNotFetchAndSeedsInBackground(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}}, nil)
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, time.Hour)
+
+	lease, err := registry.Acquire("stub", "orders")
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	t.Cleanup(lease.Stop)
+	if _, err := lease.Nodes(); !errors.Is(err, ErrServicePending) {
+		t.Fatalf("Nodes() before the seed error = %v, want ErrServicePending", err)
+	}
+	if provider.calls != 0 {
+		t.Fatalf("provider calls = %d, want Acquire to leave the fetch to the refresher", provider.calls)
+	}
+
+	changed := make(chan struct{}, 1)
+	registry.Start(context.Background(), func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	t.Cleanup(registry.Close)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called after the seed resolved")
+	}
+	if nodes, err := lease.Nodes(); err != nil || len(nodes) != 1 {
+		t.Fatalf("Nodes() after the seed = (%#v, %v), want seeded snapshot", nodes, err)
+	}
+}
+
+func TestRegistryDropsServiceAfterLastLeaseStops(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}}, nil)
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, time.Hour)
+
+	first, _ := registry.Acquire("stub", "orders")
+	second, _ := registry.Acquire("stub", "orders")
+	registry.Refresh(context.Background(), "stub")
+	first.Stop()
+	first.Stop()
+	if registry.Len() != 1 {
+		t.Fatalf("leased services after one of two leases stopped = %d, want 1", registry.Len())
+	}
+	if _, err := second.Nodes(); err != nil {
+		t.Fatalf("Nodes() through the remaining lease error = %v", err)
+	}
+	second.Stop()
+	if registry.Len() != 0 {
+		t.Fatalf("leased services after the last lease stopped = %d, want 0", registry.Len())
+	}
+	calls := provider.calls
+	if registry.Refresh(context.Background(), "stub") || provider.calls != calls {
+		t.Fatal("Refresh() still polled a service no lease references")
+	}
+}
+
+func TestRegistryRefreshReportsMembershipChangesAndKeepsLastGoodSnapshot(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}}, nil)
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, time.Hour)
+	lease := acquire(t, registry, "stub", "orders")
+
+	if registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() reported a change for an identical node set")
+	}
+	provider.set("orders", []Node{{Host: "10.0.0.2", Port: 80, Weight: 1}}, nil)
+	if !registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() did not report a membership change")
+	}
+	provider.set("orders", nil, errors.New("registry unavailable"))
+	if registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() reported a change after a failed fetch")
+	}
+	nodes, err := lease.Nodes()
+	if err != nil || len(nodes) != 1 || nodes[0].Host != "10.0.0.2" {
+		t.Fatalf("Nodes() after failed refresh = (%#v, %v), want last good snapshot", nodes, err)
+	}
+}
+
+func TestRegistryKeepsFailedSeedEnrolledForRefresh(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", nil, errors.New("registry unavailable"))
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, time.Hour)
+	lease, _ := registry.Acquire("stub", "orders")
+	t.Cleanup(lease.Stop)
+	if registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() reported a change after a failed seed")
+	}
+	_, err := lease.Nodes()
+	if !errors.Is(err, ErrServicePending) || !strings.Contains(err.Error(), "registry unavailable") {
+		t.Fatalf("Nodes() after a failed seed error = %v, want pending with the fetch failure", err)
+	}
+
+	provider.set("orders", []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}}, nil)
+	if !registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() did not publish the first successful resolution")
+	}
+}
+
+func TestRegistryStartNotifiesOnChange(t *testing.T) {
+	provider := &stubProvider{}
+	provider.set("orders", []Node{{Host: "10.0.0.1", Port: 80, Weight: 1}}, nil)
+	registry, _ := NewRegistry(nil)
+	registry.Register("stub", provider, 10*time.Millisecond)
+	acquire(t, registry, "stub", "orders")
+
+	changed := make(chan struct{}, 1)
+	registry.Start(context.Background(), func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	t.Cleanup(registry.Close)
+	provider.set("orders", []Node{{Host: "10.0.0.9", Port: 80, Weight: 1}}, nil)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called after a membership change")
+	}
+	registry.Close()
+	registry.Close()
+}
+
+// acquire leases service and seeds it the way the refresher would, so the
+// lease reads a resolved snapshot.
+func acquire(t *testing.T, registry *Registry, discoveryType, service string) *ServiceLease {
+	t.Helper()
+	lease, err := registry.Acquire(discoveryType, service)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	t.Cleanup(lease.Stop)
+	registry.refresh(context.Background(), discoveryType, true)
+	return lease
+}
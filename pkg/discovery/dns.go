@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// dnsResolver is the subset of *net.Resolver used by the DNS provider.
+type dnsResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type dnsConfig struct {
+	Servers []string `json:"servers"`
+}
+
+// dnsProvider resolves `host:port` service names through A/AAAA records and
+// bare names through SRV records, falling back to A/AAAA without a port.
+type dnsProvider struct {
+	resolver dnsResolver
+}
+
+func newDNSProviderFromConfig(raw map[string]any) (Provider, error) {
+	var conf dnsConfig
+	if err := decodeConfig(raw, &conf); err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(conf.Servers))
+	for index, server := range conf.Servers {
+		server = strings.TrimSpace(server)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		host, _, _ := net.SplitHostPort(server)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("servers[%d] must be an IP address with optional port", index)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return &dnsProvider{resolver: net.DefaultResolver}, nil
+	}
+	var next atomic.Uint64
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	return &dnsProvider{resolver: &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[int(next.Add(1)-1)%len(servers)]
+			return dialer.DialContext(ctx, network, server)
+		},
+	}}, nil
+}
+
+func (p *dnsProvider) Nodes(ctx context.Context, service string) ([]Node, error) {
+	host, port, err := splitHostPort(strings.TrimSpace(service))
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		return nil, fmt.Errorf("dns service name must not be empty")
+	}
+	if port == 0 {
+		if _, records, srvErr := p.resolver.LookupSRV(ctx, "", "", host); srvErr == nil && len(records) > 0 {
+			return srvNodes(records), nil
+		}
+	}
+	addresses, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %q: %w", host, err)
+	}
+	nodes := make([]Node, 0, len(addresses))
+	for _, address := range addresses {
+		nodes = append(nodes, Node{Host: address.IP.String(), Port: port, Weight: 1})
+	}
+	return nodes, nil
+}
+
+// srvNodes maps SRV records to nodes. SRV prefers the lowest priority value
+// while upstream nodes prefer the highest, so the priority is negated. A zero
+// SRV weight still keeps the target selectable within its priority group.
+func srvNodes(records []*net.SRV) []Node {
+	nodes := make([]Node, 0, len(records))
+	for _, record := range records {
+		weight := int(record.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		nodes = append(nodes, Node{
+			Host:     strings.TrimSuffix(record.Target, "."),
+			Port:     int(record.Port),
+			Weight:   weight,
+			Priority: -int(record.Priority),
+		})
+	}
+	return nodes
+}
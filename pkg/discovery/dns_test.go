@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type stubDNSResolver struct {
+	srv       map[string][]*net.SRV
+	addresses map[string][]net.IPAddr
+	srvLookup []string
+}
+
+func (r *stubDNSResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.srvLookup = append(r.srvLookup, name)
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (r *stubDNSResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	addresses, ok := r.addresses[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addresses, nil
+}
+
+func TestDNSProviderResolvesSRVRecords(t *testing.T) {
+	resolver := &stubDNSResolver{srv: map[string][]*net.SRV{
+		"_http._tcp.orders.local": {
+			{Target: "a.orders.local.", Port: 8080, Priority: 10, Weight: 5},
+			{Target: "b.orders.local.", Port: 8081, Priority: 20, Weight: 0},
+		},
+	}}
+	nodes, err := (&dnsProvider{resolver: resolver}).Nodes(context.Background(), "_http._tcp.orders.local")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	want := []Node{
+		{Host: "a.orders.local", Port: 8080, Weight: 5, Priority: -10},
+		{Host: "b.orders.local", Port: 8081, Weight: 1, Priority: -20},
+	}
+	if len(nodes) != len(want) || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Fatalf("Nodes() = %#v, want %#v", nodes, want)
+	}
+}
+
+func TestDNSProviderResolvesAddressRecords(t *testing.T) {
+	resolver := &stubDNSResolver{addresses: map[string][]net.IPAddr{
+		"orders.local": {{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("::1")}},
+	}}
+	provider := &dnsProvider{resolver: resolver}
+
+	nodes, err := provider.Nodes(context.Background(), "orders.local:9000")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	if len(nodes) != 2 || nodes[0] != (Node{Host: "10.0.0.1", Port: 9000, Weight: 1}) ||
+		nodes[1] != (Node{Host: "::1", Port: 9000, Weight: 1}) {
+		t.Fatalf("Nodes() = %#v, want A and AAAA nodes on port 9000", nodes)
+	}
+	if len(resolver.srvLookup) != 0 {
+		t.Fatalf("SRV lookups = %v, want none when the service name has a port", resolver.srvLookup)
+	}
+
+	nodes, err = provider.Nodes(context.Background(), "orders.local")
+	if err != nil || len(nodes) != 2 || nodes[0].Port != 0 {
+		t.Fatalf("Nodes() without port = (%#v, %v), want SRV fallback to address records", nodes, err)
+	}
+	if _, err := provider.Nodes(context.Background(), "missing.local"); err == nil {
+		t.Fatal("Nodes() for an unknown name error = nil")
+	}
+}
+
+func TestNewDNSProviderValidatesServers(t *testing.T) {
+	if _, err := newDNSProviderFromConfig(map[string]any{"servers": []any{"127.0.0.1", "[::1]:5353"}}); err != nil {
+		t.Fatalf("newDNSProviderFromConfig() error = %v", err)
+	}
+	_, err := newDNSProviderFromConfig(map[string]any{"servers": []any{"dns.example"}})
+	if err == nil || !strings.Contains(err.Error(), "servers[0]") {
+		t.Fatalf("newDNSProviderFromConfig() error = %v, want servers[0] rejection", err)
+	}
+}
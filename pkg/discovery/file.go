@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+type fileConfig struct {
+	Path string `json:"path"`
+}
+
+// fileNode is one entry of the static registry file. Weight defaults to 1.
+type fileNode struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Weight   *int   `yaml:"weight"`
+	Priority int    `yaml:"priority"`
+}
+
+// fileProvider serves nodes from a YAML or JSON document that maps service
+// names to node lists. The file is re-read on every fetch so edits are picked
+// up by the next refresh without a restart.
+type fileProvider struct {
+	path string
+}
+
+func newFileProviderFromConfig(raw map[string]any) (Provider, error) {
+	var conf fileConfig
+	if err := decodeConfig(raw, &conf); err != nil {
+		return nil, err
+	}
+	conf.Path = strings.TrimSpace(conf.Path)
+	if conf.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	return &fileProvider{path: conf.Path}, nil
+}
+
+func (p *fileProvider) Nodes(_ context.Context, service string) ([]Node, error) {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var services map[string][]fileNode
+	if err := yaml.Unmarshal(content, &services); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", p.path, err)
+	}
+	entries, ok := services[service]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrServiceNotFound, service)
+	}
+	nodes := make([]Node, 0, len(entries))
+	for _, entry := range entries {
+		weight := 1
+		if entry.Weight != nil {
+			weight = *entry.Weight
+		}
+		nodes = append(nodes, Node{Host: entry.Host, Port: entry.Port, Weight: weight, Priority: entry.Priority})
+	}
+	return nodes, nil
+}
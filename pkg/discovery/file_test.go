@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileProviderReadsCurrentFileContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	writeFile := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	writeFile(`
+orders:
+  - host: 10.0.0.1
+    port: 8080
+  - host: 10.0.0.2
+    port: 8080
+    weight: 3
+    priority: -1
+`)
+	provider, err := newFileProviderFromConfig(map[string]any{"path": path})
+	if err != nil {
+		t.Fatalf("newFileProviderFromConfig() error = %v", err)
+	}
+
+	nodes, err := provider.Nodes(context.Background(), "orders")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	if len(nodes) != 2 || nodes[0] != (Node{Host: "10.0.0.1", Port: 8080, Weight: 1}) ||
+		nodes[1] != (Node{Host: "10.0.0.2", Port: 8080, Weight: 3, Priority: -1}) {
+		t.Fatalf("Nodes() = %#v, want default and explicit weights", nodes)
+	}
+
+	writeFile(`{"orders": [{"host": "10.0.0.9", "port": 9090}]}`)
+	nodes, err = provider.Nodes(context.Background(), "orders")
+	if err != nil || len(nodes) != 1 || nodes[0].Host != "10.0.0.9" {
+		t.Fatalf("Nodes() after rewrite = (%#v, %v), want JSON file contents", nodes, err)
+	}
+	if _, err := provider.Nodes(context.Background(), "payments"); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("Nodes() for a missing service error = %v, want ErrServiceNotFound", err)
+	}
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wklken/apisix-go/pkg/json"
+)
+
+// maxRegistryResponseBytes bounds one registry response body.
+const maxRegistryResponseBytes = 8 << 20
+
+// registryClient queries an HTTP service registry (Consul, Nacos, Kubernetes
+// API server). Requests try each configured server in order and return the
+// first successful response.
+type registryClient struct {
+	client  *http.Client
+	servers []string
+	headers func() (http.Header, error)
+}
+
+func newRegistryClient(servers []string, field string) (*registryClient, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s must contain at least one address", field)
+	}
+	normalized := make([]string, 0, len(servers))
+	for index, server := range servers {
+		server = strings.TrimRight(strings.TrimSpace(server), "/")
+		parsed, err := url.Parse(server)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s[%d] must be an http or https URL", field, index)
+		}
+		normalized = append(normalized, server)
+	}
+	return &registryClient{
+		client:  &http.Client{Timeout: defaultFetchTimeout},
+		servers: normalized,
+	}, nil
+}
+
+// getJSON decodes the response for requestPath into dest. A 404 from every
+// server is reported as ErrServiceNotFound.
+func (c *registryClient) getJSON(ctx context.Context, requestPath string, dest any) error {
+	var errs []error
+	notFound := 0
+	for _, server := range c.servers {
+		err := c.getJSONFrom(ctx, server+requestPath, dest)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, ErrServiceNotFound) {
+			notFound++
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if notFound == len(c.servers) {
+		return ErrServiceNotFound
+	}
+	return errors.Join(errs...)
+}
+
+func (c *registryClient) getJSONFrom(ctx context.Context, target string, dest any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if c.headers != nil {
+		headers, err := c.headers()
+		if err != nil {
+			return err
+		}
+		for name, values := range headers {
+			request.Header[name] = values
+		}
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxRegistryResponseBytes))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return ErrServiceNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", request.URL.Redacted(), response.StatusCode)
+	}
+	return json.Unmarshal(body, dest)
+}
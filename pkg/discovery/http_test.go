@@ -0,0 +1,131 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsulProviderReturnsPassingInstances(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/orders" || r.URL.Query().Get("passing") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080, "Weights": {"Passing": 3}}},
+			{"Node": {"Address": "10.0.0.9"}, "Service": {"Address": "10.0.0.2", "Port": 8081}}
+		]`))
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := newConsulProviderFromConfig(map[string]any{
+		"servers": []any{"http://127.0.0.1:1", server.URL},
+		"token":   "secret",
+	})
+	if err != nil {
+		t.Fatalf("newConsulProviderFromConfig() error = %v", err)
+	}
+	nodes, err := provider.Nodes(context.Background(), "orders")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	want := []Node{{Host: "10.0.0.1", Port: 8080, Weight: 3}, {Host: "10.0.0.2", Port: 8081, Weight: 1}}
+	if len(nodes) != len(want) || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Fatalf("Nodes() = %#v, want %#v", nodes, want)
+	}
+}
+
+func TestNacosProviderSkipsUnhealthyAndDisabledInstances(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nacos/v1/ns/instance/list" || r.URL.Query().Get("serviceName") != "DEFAULT_GROUP@@orders" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"hosts": [
+			{"ip": "10.0.0.1", "port": 8080, "weight": 0.4, "healthy": true, "enabled": true},
+			{"ip": "10.0.0.2", "port": 8080, "weight": 2, "healthy": false},
+			{"ip": "10.0.0.3", "port": 8080, "weight": 2, "healthy": true, "enabled": false},
+			{"ip": "10.0.0.4", "port": 8080, "weight": 2.6, "healthy": true}
+		]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := newNacosProviderFromConfig(map[string]any{"host": []any{server.URL}})
+	if err != nil {
+		t.Fatalf("newNacosProviderFromConfig() error = %v", err)
+	}
+	nodes, err := provider.Nodes(context.Background(), "DEFAULT_GROUP@@orders")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	want := []Node{{Host: "10.0.0.1", Port: 8080, Weight: 1}, {Host: "10.0.0.4", Port: 8080, Weight: 3}}
+	if len(nodes) != len(want) || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Fatalf("Nodes() = %#v, want %#v", nodes, want)
+	}
+}
+
+func TestKubernetesProviderResolvesNamedPortAndRereadsToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		if r.URL.Path != "/api/v1/namespaces/shop/endpoints/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"subsets": [{
+			"addresses": [{"ip": "10.1.0.1"}, {"ip": "10.1.0.2"}],
+			"notReadyAddresses": [{"ip": "10.1.0.3"}],
+			"ports": [{"name": "http", "port": 8080}, {"name": "metrics", "port": 9090}]
+		}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, _ := strings.Cut(strings.TrimPrefix(server.URL, "http://"), ":")
+	provider, err := newKubernetesProviderFromConfig(map[string]any{
+		"service": map[string]any{"schema": "http", "host": host, "port": port},
+		"client":  map[string]any{"token_file": tokenFile},
+	})
+	if err != nil {
+		t.Fatalf("newKubernetesProviderFromConfig() error = %v", err)
+	}
+
+	nodes, err := provider.Nodes(context.Background(), "shop/orders:http")
+	if err != nil {
+		t.Fatalf("Nodes() error = %v", err)
+	}
+	want := []Node{{Host: "10.1.0.1", Port: 8080, Weight: 1}, {Host: "10.1.0.2", Port: 8080, Weight: 1}}
+	if len(nodes) != len(want) || nodes[0] != want[0] || nodes[1] != want[1] {
+		t.Fatalf("Nodes() = %#v, want %#v", nodes, want)
+	}
+	if err := os.WriteFile(tokenFile, []byte("second"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	nodes, err = provider.Nodes(context.Background(), "shop/orders")
+	if err != nil || len(nodes) != 4 {
+		t.Fatalf("Nodes() without port name = (%#v, %v), want every endpoint port", nodes, err)
+	}
+	if len(tokens) != 2 || tokens[0] != "Bearer first" || tokens[1] != "Bearer second" {
+		t.Fatalf("Authorization headers = %v, want rotated token", tokens)
+	}
+
+	if _, err := provider.Nodes(context.Background(), "shop/missing"); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("Nodes() for a missing service error = %v, want ErrServiceNotFound", err)
+	}
+	if _, err := provider.Nodes(context.Background(), "orders"); err == nil {
+		t.Fatal("Nodes() without a namespace error = nil")
+	}
+}
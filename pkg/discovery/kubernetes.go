@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+type kubernetesConfig struct {
+	Service struct {
+		Schema string `json:"schema"`
+		Host   string `json:"host"`
+		Port   any    `json:"port"`
+	} `json:"service"`
+	Client struct {
+		Token     string `json:"token"`
+		TokenFile string `json:"token_file"`
+	} `json:"client"`
+}
+
+type kubernetesEndpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// kubernetesProvider resolves `namespace/name[:port_name]` service names
+// through the Endpoints API. Only ready addresses are returned; without a
+// port name every endpoint port is used.
+type kubernetesProvider struct {
+	client *registryClient
+}
+
+func newKubernetesProviderFromConfig(raw map[string]any) (Provider, error) {
+	var conf kubernetesConfig
+	if err := decodeConfig(raw, &conf); err != nil {
+		return nil, err
+	}
+	schema := strings.TrimSpace(conf.Service.Schema)
+	if schema == "" {
+		schema = "https"
+	}
+	host := strings.TrimSpace(conf.Service.Host)
+	if host == "" {
+		host = os.Getenv("KUBERNETES_SERVICE_HOST")
+	}
+	port := strings.TrimSpace(fmt.Sprint(conf.Service.Port))
+	if conf.Service.Port == nil || port == "" {
+		port = os.Getenv("KUBERNETES_SERVICE_PORT")
+	}
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("service.host and service.port are required outside a cluster")
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("service.port must be a number")
+	}
+	client, err := newRegistryClient([]string{schema + "://" + net.JoinHostPort(host, port)}, "service")
+	if err != nil {
+		return nil, err
+	}
+
+	token := strings.TrimSpace(conf.Client.Token)
+	tokenFile := strings.TrimSpace(conf.Client.TokenFile)
+	if token == "" && tokenFile == "" {
+		if _, err := os.Stat(defaultKubernetesTokenFile); err == nil {
+			tokenFile = defaultKubernetesTokenFile
+		}
+	}
+	if token != "" || tokenFile != "" {
+		// Projected service-account tokens rotate, so the file is re-read on
+		// every request.
+		client.headers = func() (http.Header, error) {
+			value := token
+			if tokenFile != "" {
+				content, err := os.ReadFile(tokenFile)
+				if err != nil {
+					return nil, fmt.Errorf("read kubernetes token: %w", err)
+				}
+				value = strings.TrimSpace(string(content))
+			}
+			return http.Header{"Authorization": []string{"Bearer " + value}}, nil
+		}
+	}
+	return &kubernetesProvider{client: client}, nil
+}
+
+func (p *kubernetesProvider) Nodes(ctx context.Context, service string) ([]Node, error) {
+	namespace, name, found := strings.Cut(service, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, fmt.Errorf("kubernetes service name %q must be namespace/name[:port_name]", service)
+	}
+	name, portName, _ := strings.Cut(name, ":")
+	var endpoints kubernetesEndpoints
+	requestPath := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/endpoints/" + url.PathEscape(name)
+	if err := p.client.getJSON(ctx, requestPath, &endpoints); err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if portName != "" && port.Name != portName {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				nodes = append(nodes, Node{Host: address.IP, Port: port.Port, Weight: 1})
+			}
+		}
+	}
+	return nodes, nil
+}
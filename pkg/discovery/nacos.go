@@ -0,0 +1,67 @@
+package discovery
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"strings"
+)
+
+const defaultNacosPrefix = "/nacos/v1/"
+
+type nacosConfig struct {
+	Host   []string `json:"host"`
+	Prefix string   `json:"prefix"`
+}
+
+type nacosInstanceList struct {
+	Hosts []struct {
+		IP      string  `json:"ip"`
+		Port    int     `json:"port"`
+		Weight  float64 `json:"weight"`
+		Healthy bool    `json:"healthy"`
+		Enabled *bool   `json:"enabled"`
+	} `json:"hosts"`
+}
+
+// nacosProvider resolves services through the Nacos v1 instance list API.
+// Group-qualified names use the Nacos `group@@service` form.
+type nacosProvider struct {
+	client *registryClient
+	prefix string
+}
+
+func newNacosProviderFromConfig(raw map[string]any) (Provider, error) {
+	var conf nacosConfig
+	if err := decodeConfig(raw, &conf); err != nil {
+		return nil, err
+	}
+	client, err := newRegistryClient(conf.Host, "host")
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSpace(conf.Prefix)
+	if prefix == "" {
+		prefix = defaultNacosPrefix
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	return &nacosProvider{client: client, prefix: prefix}, nil
+}
+
+func (p *nacosProvider) Nodes(ctx context.Context, service string) ([]Node, error) {
+	query := url.Values{"serviceName": []string{service}, "healthyOnly": []string{"true"}}
+	var list nacosInstanceList
+	if err := p.client.getJSON(ctx, p.prefix+"ns/instance/list?"+query.Encode(), &list); err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(list.Hosts))
+	for _, instance := range list.Hosts {
+		if !instance.Healthy || (instance.Enabled != nil && !*instance.Enabled) || instance.Weight <= 0 {
+			continue
+		}
+		// Nacos weights are fractional; keep every positive weight selectable.
+		weight := max(int(math.Round(instance.Weight)), 1)
+		nodes = append(nodes, Node{Host: instance.IP, Port: instance.Port, Weight: weight})
+	}
+	return nodes, nil
+}
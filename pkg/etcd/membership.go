@@ -0,0 +1,123 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const membershipKeyPrefix = "data_plane/members"
+
+func membershipGroupPrefix(prefix string, group string) string {
+	base := "/" + strings.Trim(prefix, "/")
+	if base == "/" {
+		base = ""
+	}
+	return base + "/" + membershipKeyPrefix + "/" + strings.Trim(group, "/") + "/"
+}
+
+// applyMemberEvents updates members, keyed by node ID, with the events of a
+// watch on the group prefix. It reports whether any member changed.
+func applyMemberEvents(members map[string]string, groupPrefix string, events []*clientv3.Event) bool {
+	changed := false
+	for _, event := range events {
+		if event == nil || event.Kv == nil {
+			continue
+		}
+		nodeID, ok := strings.CutPrefix(string(event.Kv.Key), groupPrefix)
+		if !ok || nodeID == "" || strings.Contains(nodeID, "/") {
+			continue
+		}
+		switch event.Type {
+		case clientv3.EventTypePut:
+			if current, ok := members[nodeID]; !ok || current != string(event.Kv.Value) {
+				members[nodeID] = string(event.Kv.Value)
+				changed = true
+			}
+		case clientv3.EventTypeDelete:
+			if _, ok := members[nodeID]; ok {
+				delete(members, nodeID)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// JoinMembership registers value for nodeID in a group under this config
+// client's prefix, on a lease renewed the same way as the server-info record,
+// and calls update with the values of every member, this node included, each
+// time the group changes. Both run until ctx is canceled, when the record is
+// left to expire with its lease.
+func (c *ConfigClient) JoinMembership(
+	ctx context.Context,
+	group string,
+	nodeID string,
+	value string,
+	ttl time.Duration,
+	update func(map[string]string),
+) error {
+	if c == nil || c.client == nil {
+		return errors.New("etcd config client is not initialized")
+	}
+	if strings.Trim(group, "/") == "" || strings.Trim(nodeID, "/") == "" {
+		return errors.New("membership group and node ID must not be empty")
+	}
+	if update == nil {
+		return errors.New("membership update callback is nil")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	groupPrefix := membershipGroupPrefix(c.prefix, group)
+	reporter := newServerInfoReporter(c.client, groupPrefix+strings.Trim(nodeID, "/"), ttl)
+	if err := reporter.Start(ctx, func() ([]byte, error) { return []byte(value), nil }); err != nil {
+		return err
+	}
+	go c.watchMembership(ctx, groupPrefix, update)
+	return nil
+}
+
+func (c *ConfigClient) watchMembership(ctx context.Context, groupPrefix string, update func(map[string]string)) {
+	retry := 0
+	for ctx.Err() == nil {
+		getCtx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+		response, err := c.client.Get(getCtx, groupPrefix, clientv3.WithPrefix())
+		cancel()
+		if err != nil {
+			logger.Errorf("list etcd members under %s: %v", groupPrefix, err)
+			if !waitForWatchRetry(ctx, retry) {
+				return
+			}
+			retry++
+			continue
+		}
+		retry = 0
+		members := make(map[string]string, len(response.Kvs))
+		for _, kv := range response.Kvs {
+			applyMemberEvents(members, groupPrefix, []*clientv3.Event{{Type: clientv3.EventTypePut, Kv: kv}})
+		}
+		update(maps.Clone(members))
+
+		watchCtx, cancelWatch := context.WithCancel(ctx)
+		stream := c.client.Watch(watchCtx, groupPrefix, clientv3.WithPrefix(), clientv3.WithRev(response.Header.Revision+1))
+		for watchResponse := range stream {
+			if err := watchResponse.Err(); err != nil {
+				logger.Errorf("watch etcd members under %s: %v", groupPrefix, err)
+				break
+			}
+			if applyMemberEvents(members, groupPrefix, watchResponse.Events) {
+				update(maps.Clone(members))
+			}
+		}
+		cancelWatch()
+		if !waitForWatchRetry(ctx, 0) {
+			return
+		}
+	}
+}
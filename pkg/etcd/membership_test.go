@@ -0,0 +1,38 @@
+package etcd
+
+import (
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestApplyMemberEventsTracksGroupMembers(t *testing.T) {
+	prefix := membershipGroupPrefix("/apisix/", "limit-count")
+	if prefix != "/apisix/data_plane/members/limit-count/" {
+		t.Fatalf("membershipGroupPrefix() = %q, want prefixed group", prefix)
+	}
+	put := func(key, value string) *clientv3.Event {
+		return &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value)}}
+	}
+
+	members := map[string]string{}
+	if !applyMemberEvents(members, prefix, []*clientv3.Event{
+		put(prefix+"node-a", "10.0.0.1:7946"),
+		put(prefix+"node-b", "10.0.0.2:7946"),
+		put(prefix+"node-c/extra", "ignored"),
+		put("/apisix/routes/1", "ignored"),
+	}) {
+		t.Fatal("applyMemberEvents() = false, want members added")
+	}
+	if applyMemberEvents(members, prefix, []*clientv3.Event{put(prefix+"node-a", "10.0.0.1:7946")}) {
+		t.Fatal("applyMemberEvents() = true for an unchanged member")
+	}
+	deleted := &clientv3.Event{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte(prefix + "node-b")}}
+	if !applyMemberEvents(members, prefix, []*clientv3.Event{deleted}) {
+		t.Fatal("applyMemberEvents() = false, want the expired member removed")
+	}
+	if len(members) != 1 || members["node-a"] != "10.0.0.1:7946" {
+		t.Fatalf("members = %v, want only node-a", members)
+	}
+}
@@ -147,6 +147,17 @@ func NewConfigClientWithOptions(
 	return configClient, nil
 }
 
+// KV returns the key-value API of the underlying etcd client. The Admin API
+// writes through it and relies on Watch to apply the result to the Store.
+func (c *ConfigClient) KV() clientv3.KV {
+	return c.client
+}
+
+// Prefix returns the canonical configuration prefix, ending in a slash.
+func (c *ConfigClient) Prefix() string {
+	return c.prefix
+}
+
 func newHealthCheck(get getFunc, prefix string) healthCheckFunc {
 	return func(ctx context.Context) error {
 		_, err := get(ctx, prefix)
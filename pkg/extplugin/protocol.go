@@ -0,0 +1,373 @@
+package extplugin
+
+import (
+	"fmt"
+	"io"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Message types of the runner protocol. Every frame is a one-byte type, a
+// three-byte big-endian payload length, and a flatbuffers payload from the
+// A6 schema.
+const (
+	typeRPCError     byte = 0
+	typePrepareConf  byte = 1
+	typeHTTPReqCall  byte = 2
+	typeExtraInfo    byte = 3
+	typeHTTPRespCall byte = 4
+)
+
+const maxPayloadSize = 1<<24 - 1
+
+// Error codes of A6.Err.Resp.
+const (
+	ErrCodeBadRequest         uint32 = 0
+	ErrCodeServiceUnavailable uint32 = 1
+	ErrCodeConfTokenNotFound  uint32 = 2
+)
+
+// methods is A6.Method in declaration order; a request method is encoded as
+// its index.
+var methods = []string{
+	"GET", "HEAD", "POST", "PUT", "DELETE", "MKCOL", "COPY", "MOVE", "OPTIONS",
+	"PROPFIND", "PROPPATCH", "LOCK", "UNLOCK", "PATCH", "TRACE",
+}
+
+// Union discriminators of A6.HTTPReqCall.Action and A6.ExtraInfo.Info.
+const (
+	actionNone    byte = 0
+	actionStop    byte = 1
+	actionRewrite byte = 2
+
+	infoVar      byte = 1
+	infoReqBody  byte = 2
+	infoRespBody byte = 3
+)
+
+// TextEntry is one A6.TextEntry name/value pair. Remove reports a runner
+// reply entry without a value, which deletes the name.
+type TextEntry struct {
+	Name   string
+	Value  string
+	Remove bool
+}
+
+// RPCError is an A6.Err reply from the runner.
+type RPCError struct {
+	Code uint32
+}
+
+func (e *RPCError) Error() string {
+	switch e.Code {
+	case ErrCodeBadRequest:
+		return "runner replied BAD_REQUEST"
+	case ErrCodeServiceUnavailable:
+		return "runner replied SERVICE_UNAVAILABLE"
+	case ErrCodeConfTokenNotFound:
+		return "runner replied CONF_TOKEN_NOT_FOUND"
+	default:
+		return fmt.Sprintf("runner replied error code %d", e.Code)
+	}
+}
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	if len(payload) > maxPayloadSize {
+		return fmt.Errorf("payload of %d bytes exceeds the %d byte frame limit", len(payload), maxPayloadSize)
+	}
+	frame := make([]byte, 4+len(payload))
+	frame[0] = typ
+	frame[1] = byte(len(payload) >> 16)
+	frame[2] = byte(len(payload) >> 8)
+	frame[3] = byte(len(payload))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// table reads one flatbuffers table. Accessors index the buffer directly, so
+// decoders run under decode, which turns an out-of-range read from a
+// malformed payload into an error.
+type table struct {
+	flatbuffers.Table
+}
+
+func decode(payload []byte, read func(table) error) (err error) {
+	if len(payload) < flatbuffers.SizeUOffsetT {
+		return fmt.Errorf("payload of %d bytes is not a flatbuffer", len(payload))
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("malformed payload: %v", recovered)
+		}
+	}()
+	root := flatbuffers.GetUOffsetT(payload)
+	return read(table{flatbuffers.Table{Bytes: payload, Pos: root}})
+}
+
+func (t table) field(slot int) flatbuffers.UOffsetT {
+	return flatbuffers.UOffsetT(t.Offset(flatbuffers.VOffsetT(4 + 2*slot)))
+}
+
+func (t table) uint32(slot int) uint32 {
+	if o := t.field(slot); o != 0 {
+		return t.GetUint32(t.Pos + o)
+	}
+	return 0
+}
+
+func (t table) uint16(slot int) uint16 {
+	if o := t.field(slot); o != 0 {
+		return t.GetUint16(t.Pos + o)
+	}
+	return 0
+}
+
+func (t table) byte(slot int) byte {
+	if o := t.field(slot); o != 0 {
+		return t.GetByte(t.Pos + o)
+	}
+	return 0
+}
+
+// bytes reads a string or [ubyte] field; ok is false when it is absent.
+func (t table) bytes(slot int) ([]byte, bool) {
+	if o := t.field(slot); o != 0 {
+		return append([]byte(nil), t.ByteVector(t.Pos+o)...), true
+	}
+	return nil, false
+}
+
+func (t table) string(slot int) string {
+	value, _ := t.bytes(slot)
+	return string(value)
+}
+
+func (t table) tables(slot int) []table {
+	o := t.field(slot)
+	if o == 0 {
+		return nil
+	}
+	start := t.Vector(o)
+	result := make([]table, t.VectorLen(o))
+	for i := range result {
+		element := start + flatbuffers.UOffsetT(i)*flatbuffers.SizeUOffsetT
+		result[i] = table{flatbuffers.Table{Bytes: t.Bytes, Pos: t.Indirect(element)}}
+	}
+	return result
+}
+
+func (t table) union(slot int) (table, bool) {
+	o := t.field(slot)
+	if o == 0 {
+		return table{}, false
+	}
+	var member flatbuffers.Table
+	t.Union(&member, o)
+	return table{member}, true
+}
+
+func (t table) textEntries(slot int) []TextEntry {
+	tables := t.tables(slot)
+	if len(tables) == 0 {
+		return nil
+	}
+	entries := make([]TextEntry, len(tables))
+	for i, entry := range tables {
+		value, ok := entry.bytes(1)
+		entries[i] = TextEntry{Name: entry.string(0), Value: string(value), Remove: !ok}
+	}
+	return entries
+}
+
+func buildTextEntries(b *flatbuffers.Builder, entries []TextEntry) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(entries))
+	for i, entry := range entries {
+		name := b.CreateString(entry.Name)
+		var value flatbuffers.UOffsetT
+		if !entry.Remove {
+			value = b.CreateString(entry.Value)
+		}
+		b.StartObject(2)
+		b.PrependUOffsetTSlot(0, name, 0)
+		if !entry.Remove {
+			b.PrependUOffsetTSlot(1, value, 0)
+		}
+		offsets[i] = b.EndObject()
+	}
+	return buildOffsetVector(b, offsets)
+}
+
+func buildOffsetVector(b *flatbuffers.Builder, offsets []flatbuffers.UOffsetT) flatbuffers.UOffsetT {
+	b.StartVector(flatbuffers.SizeUOffsetT, len(offsets), flatbuffers.SizeUOffsetT)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func encodePrepareConf(conf Conf) []byte {
+	b := flatbuffers.NewBuilder(256)
+	entries := buildTextEntries(b, conf.Entries)
+	key := b.CreateString(conf.Key)
+	b.StartObject(2)
+	b.PrependUOffsetTSlot(0, entries, 0)
+	b.PrependUOffsetTSlot(1, key, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func decodePrepareConf(payload []byte) (uint32, error) {
+	var token uint32
+	err := decode(payload, func(t table) error {
+		token = t.uint32(0)
+		return nil
+	})
+	return token, err
+}
+
+func encodeHTTPReqCall(id, token uint32, req HTTPRequest) ([]byte, error) {
+	method := -1
+	for index, name := range methods {
+		if name == req.Method {
+			method = index
+			break
+		}
+	}
+	if method < 0 {
+		return nil, fmt.Errorf("method %q is not representable in the runner protocol", req.Method)
+	}
+	b := flatbuffers.NewBuilder(1024)
+	var srcIP flatbuffers.UOffsetT
+	if req.SrcIP.IsValid() {
+		srcIP = b.CreateByteVector(req.SrcIP.Unmap().AsSlice())
+	}
+	path := b.CreateString(req.Path)
+	args := buildTextEntries(b, req.Args)
+	headers := buildTextEntries(b, req.Headers)
+	b.StartObject(7)
+	b.PrependUint32Slot(0, id, 0)
+	if srcIP != 0 {
+		b.PrependUOffsetTSlot(1, srcIP, 0)
+	}
+	b.PrependByteSlot(2, byte(method), 0)
+	b.PrependUOffsetTSlot(3, path, 0)
+	b.PrependUOffsetTSlot(4, args, 0)
+	b.PrependUOffsetTSlot(5, headers, 0)
+	b.PrependUint32Slot(6, token, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes(), nil
+}
+
+func decodeHTTPReqCall(payload []byte) (HTTPReqResult, error) {
+	var result HTTPReqResult
+	err := decode(payload, func(t table) error {
+		action, ok := t.union(2)
+		switch kind := t.byte(1); {
+		case kind == actionNone:
+		case !ok:
+			return fmt.Errorf("action type %d without action", kind)
+		case kind == actionStop:
+			body, _ := action.bytes(2)
+			result.Stop = &StopAction{
+				Status:  int(action.uint16(0)),
+				Headers: action.textEntries(1),
+				Body:    body,
+			}
+		case kind == actionRewrite:
+			path, hasPath := action.bytes(0)
+			result.Rewrite = &RewriteAction{
+				Path:        string(path),
+				HasPath:     hasPath,
+				Headers:     action.textEntries(1),
+				Args:        action.textEntries(2),
+				RespHeaders: action.textEntries(3),
+			}
+		default:
+			return fmt.Errorf("unknown action type %d", kind)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func encodeHTTPRespCall(id, token uint32, resp HTTPResponse) []byte {
+	b := flatbuffers.NewBuilder(1024)
+	headers := buildTextEntries(b, resp.Headers)
+	b.StartObject(4)
+	b.PrependUint32Slot(0, id, 0)
+	b.PrependUint16Slot(1, uint16(resp.Status), 0)
+	b.PrependUOffsetTSlot(2, headers, 0)
+	b.PrependUint32Slot(3, token, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func decodeHTTPRespCall(payload []byte) (HTTPRespResult, error) {
+	var result HTTPRespResult
+	err := decode(payload, func(t table) error {
+		body, _ := t.bytes(3)
+		result = HTTPRespResult{Status: int(t.uint16(1)), Headers: t.textEntries(2), Body: body}
+		return nil
+	})
+	return result, err
+}
+
+func decodeExtraInfo(payload []byte) (ExtraInfo, error) {
+	var info ExtraInfo
+	err := decode(payload, func(t table) error {
+		member, ok := t.union(1)
+		switch kind := t.byte(0); {
+		case !ok:
+			return fmt.Errorf("info type %d without info", kind)
+		case kind == infoVar:
+			info = ExtraInfo{Kind: ExtraInfoVar, Name: member.string(0)}
+		case kind == infoReqBody:
+			info = ExtraInfo{Kind: ExtraInfoReqBody}
+		case kind == infoRespBody:
+			info = ExtraInfo{Kind: ExtraInfoRespBody}
+		default:
+			return fmt.Errorf("unknown info type %d", kind)
+		}
+		return nil
+	})
+	return info, err
+}
+
+func encodeExtraInfoResult(result []byte) []byte {
+	b := flatbuffers.NewBuilder(len(result) + 64)
+	var vector flatbuffers.UOffsetT
+	if result != nil {
+		vector = b.CreateByteVector(result)
+	}
+	b.StartObject(1)
+	if result != nil {
+		b.PrependUOffsetTSlot(0, vector, 0)
+	}
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func decodeRPCError(payload []byte) error {
+	rpcErr := &RPCError{}
+	if err := decode(payload, func(t table) error {
+		rpcErr.Code = t.uint32(0)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return rpcErr
+}
@@ -0,0 +1,582 @@
+// Package extplugin supervises an APISIX external plugin runner and speaks
+// its flatbuffers-over-Unix-socket protocol.
+//
+// The Runner spawns the `ext-plugin.cmd` process with APISIX_LISTEN_ADDRESS
+// pointing at a socket in a private directory, restarts it with backoff when
+// it exits, and dials that socket for every call. Plugin configurations are
+// prepared once per runner generation: the returned conf token is cached for
+// nine tenths of APISIX_CONF_EXPIRE_TIME, dropped when the runner restarts,
+// and re-prepared once when the runner answers CONF_TOKEN_NOT_FOUND. Runners
+// written for APISIX (Go, Java, Python) work unchanged.
+package extplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	// DefaultConfExpireTime is APISIX_CONF_EXPIRE_TIME when unset.
+	DefaultConfExpireTime = time.Hour
+
+	// callTimeout bounds one call whose context carries no deadline.
+	callTimeout = 60 * time.Second
+	dialTimeout = time.Second
+
+	readyPollInterval = 50 * time.Millisecond
+	minRestartDelay   = 100 * time.Millisecond
+	maxRestartDelay   = 10 * time.Second
+	// stableRunTime resets the restart backoff for a runner that stayed up.
+	stableRunTime = 10 * time.Second
+	stopTimeout   = 5 * time.Second
+
+	maxIdleConns = 64
+	// maxLogLine flushes runner output that carries no newline.
+	maxLogLine = 4096
+)
+
+// ErrUnavailable reports that no runner is configured or its socket is not
+// accepting connections.
+var ErrUnavailable = errors.New("ext-plugin runner is unavailable")
+
+// Options configures a Runner.
+type Options struct {
+	// Cmd is the runner command line; Cmd[0] is looked up in PATH.
+	Cmd []string
+	// PathForTest connects to an already running runner at this socket path
+	// instead of spawning Cmd.
+	PathForTest string
+	// ConfExpireTime is passed to the runner as APISIX_CONF_EXPIRE_TIME.
+	ConfExpireTime time.Duration
+}
+
+// Conf is one plugin configuration sent with PrepareConf. Key identifies the
+// configuration owner to the runner.
+type Conf struct {
+	Key     string
+	Entries []TextEntry
+}
+
+func (c Conf) cacheKey() string {
+	var key strings.Builder
+	key.WriteString(c.Key)
+	for _, entry := range c.Entries {
+		key.WriteByte(0)
+		key.WriteString(entry.Name)
+		key.WriteByte('=')
+		key.WriteString(entry.Value)
+	}
+	return key.String()
+}
+
+// HTTPRequest is the request view sent with HTTPReqCall.
+type HTTPRequest struct {
+	SrcIP   netip.Addr
+	Method  string
+	Path    string
+	Args    []TextEntry
+	Headers []TextEntry
+}
+
+// HTTPReqResult is the runner decision for a request. Both actions are nil
+// when the runner lets the request continue unchanged.
+type HTTPReqResult struct {
+	Stop    *StopAction
+	Rewrite *RewriteAction
+}
+
+// StopAction answers the request without proxying it. A zero Status means
+// 200.
+type StopAction struct {
+	Status  int
+	Headers []TextEntry
+	Body    []byte
+}
+
+// RewriteAction changes the request before it is proxied. RespHeaders are
+// applied to the response once it arrives.
+type RewriteAction struct {
+	Path        string
+	HasPath     bool
+	Headers     []TextEntry
+	Args        []TextEntry
+	RespHeaders []TextEntry
+}
+
+// HTTPResponse is the upstream response view sent with HTTPRespCall.
+type HTTPResponse struct {
+	Status  int
+	Headers []TextEntry
+}
+
+// HTTPRespResult is the runner rewrite of a response. A zero Status keeps
+// the upstream status and an empty Body keeps the upstream body.
+type HTTPRespResult struct {
+	Status  int
+	Headers []TextEntry
+	Body    []byte
+}
+
+// ExtraInfoKind is the A6.ExtraInfo.Info member a runner asks for.
+type ExtraInfoKind uint8
+
+const (
+	ExtraInfoVar ExtraInfoKind = iota + 1
+	ExtraInfoReqBody
+	ExtraInfoRespBody
+)
+
+// ExtraInfo is a runner request for data not sent with the call. Name is the
+// variable name of an ExtraInfoVar request.
+type ExtraInfo struct {
+	Kind ExtraInfoKind
+	Name string
+}
+
+// ExtraInfoFunc answers ExtraInfo requests while a call is in flight. A nil
+// result is sent as an absent value.
+type ExtraInfoFunc func(ExtraInfo) ([]byte, error)
+
+type cachedToken struct {
+	token   uint32
+	expires time.Time
+}
+
+// Runner supervises one runner process. It is safe for concurrent use; a nil
+// Runner fails every call with ErrUnavailable.
+type Runner struct {
+	cmd        []string
+	socketDir  string
+	socketPath string
+	expireTime time.Duration
+	tokenTTL   time.Duration
+	now        func() time.Time
+
+	nextID atomic.Uint32
+	ready  atomic.Bool
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	idle   []net.Conn
+
+	lifecycleMu sync.Mutex
+	cancel      context.CancelFunc
+	done        sync.WaitGroup
+	closed      bool
+}
+
+// New validates options and prepares the socket directory of a spawned
+// runner. The process starts with Start.
+func New(options Options) (*Runner, error) {
+	expireTime := options.ConfExpireTime
+	if expireTime <= 0 {
+		expireTime = DefaultConfExpireTime
+	}
+	r := &Runner{
+		expireTime: expireTime,
+		tokenTTL:   expireTime * 9 / 10,
+		now:        time.Now,
+		tokens:     map[string]cachedToken{},
+	}
+	if options.PathForTest != "" {
+		r.socketPath = options.PathForTest
+		r.ready.Store(true)
+		return r, nil
+	}
+	if len(options.Cmd) == 0 || options.Cmd[0] == "" {
+		return nil, fmt.Errorf("ext-plugin.cmd must name the runner executable")
+	}
+	dir, err := os.MkdirTemp("", "apisix-go-ext-plugin-")
+	if err != nil {
+		return nil, fmt.Errorf("create runner socket directory: %w", err)
+	}
+	r.cmd = append([]string(nil), options.Cmd...)
+	r.socketDir = dir
+	r.socketPath = filepath.Join(dir, "apisix-"+strconv.Itoa(os.Getpid())+".sock")
+	return r, nil
+}
+
+// SocketPath is the Unix socket the runner listens on.
+func (r *Runner) SocketPath() string {
+	return r.socketPath
+}
+
+// Ready reports whether the runner socket has accepted a connection since
+// the process last started.
+func (r *Runner) Ready() bool {
+	return r != nil && r.ready.Load()
+}
+
+// Start spawns the runner and restarts it whenever it exits until ctx is
+// cancelled or Close is called. It does nothing for a PathForTest runner.
+func (r *Runner) Start(ctx context.Context) {
+	if r == nil || len(r.cmd) == 0 {
+		return
+	}
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	if r.cancel != nil || r.closed {
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		r.supervise(ctx)
+	}()
+}
+
+// Close stops the runner process, drops idle connections, and removes the
+// socket directory. It is safe to call more than once.
+func (r *Runner) Close() {
+	if r == nil {
+		return
+	}
+	r.lifecycleMu.Lock()
+	cancel := r.cancel
+	alreadyClosed := r.closed
+	r.closed = true
+	r.lifecycleMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	r.done.Wait()
+	if alreadyClosed {
+		return
+	}
+	r.ready.Store(false)
+	r.reset()
+	if r.socketDir != "" {
+		if err := os.RemoveAll(r.socketDir); err != nil {
+			logger.Warnf("ext-plugin: remove runner socket directory: %s", err)
+		}
+	}
+}
+
+func (r *Runner) supervise(ctx context.Context) {
+	delay := minRestartDelay
+	for {
+		started := r.now()
+		err := r.run(ctx)
+		r.ready.Store(false)
+		r.reset()
+		if ctx.Err() != nil {
+			return
+		}
+		if r.now().Sub(started) >= stableRunTime {
+			delay = minRestartDelay
+		}
+		logger.Errorf("ext-plugin: runner exited: %v; restarting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+// run starts one runner process and waits for it to exit or for ctx to be
+// cancelled, in which case the process gets SIGTERM and then SIGKILL.
+func (r *Runner) run(ctx context.Context) error {
+	if err := os.Remove(r.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	cmd := exec.Command(r.cmd[0], r.cmd[1:]...)
+	cmd.Env = append(
+		os.Environ(),
+		"APISIX_LISTEN_ADDRESS=unix:"+r.socketPath,
+		"APISIX_CONF_EXPIRE_TIME="+strconv.Itoa(int(r.expireTime/time.Second)),
+	)
+	cmd.Stdout = &runnerLogWriter{logf: logger.Infof}
+	cmd.Stderr = &runnerLogWriter{logf: logger.Warnf}
+	cmd.WaitDelay = stopTimeout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	logger.Infof("ext-plugin: started runner %s (pid %d)", r.cmd[0], cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	readyCtx, stopReady := context.WithCancel(ctx)
+	defer stopReady()
+	go r.waitReady(readyCtx)
+
+	select {
+	case err := <-exited:
+		return err
+	case <-ctx.Done():
+	}
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+	return ctx.Err()
+}
+
+// waitReady polls the socket until the freshly started runner accepts a
+// connection.
+func (r *Runner) waitReady(ctx context.Context) {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialTimeout("unix", r.socketPath, dialTimeout)
+		if err == nil {
+			_ = conn.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			r.ready.Store(true)
+			logger.Infof("ext-plugin: runner listening on %s", r.socketPath)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reset forgets conf tokens and idle connections of the previous runner
+// process.
+func (r *Runner) reset() {
+	r.mu.Lock()
+	idle := r.idle
+	r.idle = nil
+	clear(r.tokens)
+	r.mu.Unlock()
+	for _, conn := range idle {
+		_ = conn.Close()
+	}
+}
+
+// HTTPReqCall asks the runner to filter a request.
+func (r *Runner) HTTPReqCall(
+	ctx context.Context,
+	conf Conf,
+	req HTTPRequest,
+	extra ExtraInfoFunc,
+) (HTTPReqResult, error) {
+	var result HTTPReqResult
+	err := r.callWithToken(ctx, conf, func(conn net.Conn, token uint32) error {
+		id := r.nextID.Add(1)
+		payload, err := encodeHTTPReqCall(id, token, req)
+		if err != nil {
+			return err
+		}
+		reply, err := exchange(conn, typeHTTPReqCall, payload, extra)
+		if err != nil {
+			return err
+		}
+		result, err = decodeHTTPReqCall(reply)
+		return err
+	})
+	return result, err
+}
+
+// HTTPRespCall asks the runner to filter an upstream response.
+func (r *Runner) HTTPRespCall(
+	ctx context.Context,
+	conf Conf,
+	resp HTTPResponse,
+	extra ExtraInfoFunc,
+) (HTTPRespResult, error) {
+	var result HTTPRespResult
+	err := r.callWithToken(ctx, conf, func(conn net.Conn, token uint32) error {
+		reply, err := exchange(conn, typeHTTPRespCall, encodeHTTPRespCall(r.nextID.Add(1), token, resp), extra)
+		if err != nil {
+			return err
+		}
+		result, err = decodeHTTPRespCall(reply)
+		return err
+	})
+	return result, err
+}
+
+// callWithToken runs call with the conf token of conf, preparing it first
+// when it is not cached and once more when the runner no longer knows it.
+func (r *Runner) callWithToken(
+	ctx context.Context,
+	conf Conf,
+	call func(net.Conn, uint32) error,
+) error {
+	if !r.Ready() {
+		return ErrUnavailable
+	}
+	key := conf.cacheKey()
+	for attempt := 0; ; attempt++ {
+		token, err := r.confToken(ctx, key, conf)
+		if err != nil {
+			return err
+		}
+		err = r.withConn(ctx, func(conn net.Conn) error { return call(conn, token) })
+		var rpcErr *RPCError
+		if attempt == 0 && errors.As(err, &rpcErr) && rpcErr.Code == ErrCodeConfTokenNotFound {
+			r.mu.Lock()
+			delete(r.tokens, key)
+			r.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+func (r *Runner) confToken(ctx context.Context, key string, conf Conf) (uint32, error) {
+	r.mu.Lock()
+	cached, ok := r.tokens[key]
+	r.mu.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		return cached.token, nil
+	}
+	var token uint32
+	err := r.withConn(ctx, func(conn net.Conn) error {
+		reply, err := exchange(conn, typePrepareConf, encodePrepareConf(conf), nil)
+		if err != nil {
+			return err
+		}
+		token, err = decodePrepareConf(reply)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("prepare conf: %w", err)
+	}
+	r.mu.Lock()
+	r.tokens[key] = cachedToken{token: token, expires: r.now().Add(r.tokenTTL)}
+	r.mu.Unlock()
+	return token, nil
+}
+
+// withConn runs fn on an idle or new connection and keeps the connection for
+// reuse only when fn succeeds, so a failed exchange never leaves unread
+// frames for the next call.
+func (r *Runner) withConn(ctx context.Context, fn func(net.Conn) error) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(callTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	err = fn(conn)
+	if !stop() || err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	r.mu.Lock()
+	if len(r.idle) < maxIdleConns {
+		r.idle = append(r.idle, conn)
+		conn = nil
+	}
+	r.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+func (r *Runner) conn(ctx context.Context) (net.Conn, error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		conn := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return conn, nil
+	}
+	r.mu.Unlock()
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", r.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return conn, nil
+}
+
+// exchange sends one call and waits for its reply, answering the ExtraInfo
+// requests the runner sends in between.
+func exchange(conn net.Conn, typ byte, payload []byte, extra ExtraInfoFunc) ([]byte, error) {
+	if err := writeFrame(conn, typ, payload); err != nil {
+		return nil, err
+	}
+	for {
+		replyType, reply, err := readFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch replyType {
+		case typ:
+			return reply, nil
+		case typeRPCError:
+			return nil, decodeRPCError(reply)
+		case typeExtraInfo:
+			info, err := decodeExtraInfo(reply)
+			if err != nil {
+				return nil, fmt.Errorf("extra info: %w", err)
+			}
+			var result []byte
+			if extra != nil {
+				if result, err = extra(info); err != nil {
+					return nil, fmt.Errorf("extra info: %w", err)
+				}
+			}
+			if err := writeFrame(conn, typeExtraInfo, encodeExtraInfoResult(result)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected reply type %d to type %d", replyType, typ)
+		}
+	}
+}
+
+// runnerLogWriter forwards the runner's output to the gateway log line by
+// line.
+type runnerLogWriter struct {
+	logf    func(string, ...any)
+	pending []byte
+}
+
+func (w *runnerLogWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		index := bytes.IndexByte(w.pending, '\n')
+		if index < 0 {
+			break
+		}
+		if line := strings.TrimRight(string(w.pending[:index]), "\r"); line != "" {
+			w.logf("ext-plugin runner: %s", line)
+		}
+		w.pending = w.pending[index+1:]
+	}
+	if len(w.pending) > maxLogLine {
+		w.logf("ext-plugin runner: %s", w.pending)
+		w.pending = nil
+	}
+	return len(p), nil
+}
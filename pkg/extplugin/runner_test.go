@@ -0,0 +1,515 @@
+package extplugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+const stubRunnerEnv = "APISIX_GO_EXT_PLUGIN_STUB_RUNNER"
+
+// stubCall is a decoded HTTPReqCall or HTTPRespCall as the runner sees it.
+type stubCall struct {
+	ID      uint32
+	Method  string
+	Path    string
+	SrcIP   netip.Addr
+	Status  int
+	Args    []TextEntry
+	Headers []TextEntry
+	Conf    []TextEntry
+}
+
+// stubRunner implements the runner side of the protocol over a Unix socket.
+// reqCall and respCall build the reply payload of a call, or nil for a
+// SERVICE_UNAVAILABLE error; conn lets them ask for extra info first.
+type stubRunner struct {
+	listener net.Listener
+	prepares atomic.Int32
+	// forgetTokens makes the next call answer CONF_TOKEN_NOT_FOUND and drop
+	// every prepared conf.
+	forgetTokens atomic.Bool
+
+	mu    sync.Mutex
+	confs map[uint32][]TextEntry
+
+	reqCall  func(conn net.Conn, call stubCall) []byte
+	respCall func(conn net.Conn, call stubCall) []byte
+}
+
+func startStubRunner(t *testing.T, path string) *stubRunner {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubRunner{listener: listener, confs: map[uint32][]TextEntry{}}
+	go stub.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+	return stub
+}
+
+func (s *stubRunner) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *stubRunner) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		typ, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		replyType, reply := s.handle(conn, typ, payload)
+		if err := writeFrame(conn, replyType, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *stubRunner) handle(conn net.Conn, typ byte, payload []byte) (byte, []byte) {
+	if typ == typePrepareConf {
+		var entries []TextEntry
+		if err := decode(payload, func(t table) error {
+			entries = t.textEntries(0)
+			return nil
+		}); err != nil {
+			return typeRPCError, encodeStubError(ErrCodeBadRequest)
+		}
+		token := uint32(s.prepares.Add(1))
+		s.mu.Lock()
+		s.confs[token] = entries
+		s.mu.Unlock()
+		b := flatbuffers.NewBuilder(32)
+		b.StartObject(1)
+		b.PrependUint32Slot(0, token, 0)
+		b.Finish(b.EndObject())
+		return typePrepareConf, b.FinishedBytes()
+	}
+
+	var call stubCall
+	var token uint32
+	if err := decode(payload, func(t table) error {
+		call.ID = t.uint32(0)
+		switch typ {
+		case typeHTTPReqCall:
+			raw, _ := t.bytes(1)
+			call.SrcIP, _ = netip.AddrFromSlice(raw)
+			call.Method = methods[t.byte(2)]
+			call.Path = t.string(3)
+			call.Args = t.textEntries(4)
+			call.Headers = t.textEntries(5)
+			token = t.uint32(6)
+		case typeHTTPRespCall:
+			call.Status = int(t.uint16(1))
+			call.Headers = t.textEntries(2)
+			token = t.uint32(3)
+		}
+		return nil
+	}); err != nil {
+		return typeRPCError, encodeStubError(ErrCodeBadRequest)
+	}
+	s.mu.Lock()
+	if s.forgetTokens.CompareAndSwap(true, false) {
+		clear(s.confs)
+	}
+	conf, ok := s.confs[token]
+	s.mu.Unlock()
+	if !ok {
+		return typeRPCError, encodeStubError(ErrCodeConfTokenNotFound)
+	}
+	call.Conf = conf
+	switch typ {
+	case typeHTTPReqCall:
+		if s.reqCall == nil {
+			return typ, encodeStubReqResp(call.ID, actionNone, nil)
+		}
+		payload = s.reqCall(conn, call)
+	case typeHTTPRespCall:
+		if s.respCall == nil {
+			return typ, encodeStubRespResp(call.ID, 0, nil, nil)
+		}
+		payload = s.respCall(conn, call)
+	default:
+		return typeRPCError, encodeStubError(ErrCodeBadRequest)
+	}
+	if payload == nil {
+		return typeRPCError, encodeStubError(ErrCodeServiceUnavailable)
+	}
+	return typ, payload
+}
+
+func encodeStubError(code uint32) []byte {
+	b := flatbuffers.NewBuilder(32)
+	b.StartObject(1)
+	b.PrependUint32Slot(0, code, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+// encodeStubReqResp builds HTTPReqCall.Resp; action builds the Stop or
+// Rewrite table in b.
+func encodeStubReqResp(id uint32, kind byte, action func(*flatbuffers.Builder) flatbuffers.UOffsetT) []byte {
+	b := flatbuffers.NewBuilder(256)
+	var offset flatbuffers.UOffsetT
+	if action != nil {
+		offset = action(b)
+	}
+	b.StartObject(3)
+	b.PrependUint32Slot(0, id, 0)
+	b.PrependByteSlot(1, kind, 0)
+	if action != nil {
+		b.PrependUOffsetTSlot(2, offset, 0)
+	}
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func stubStop(status uint16, headers []TextEntry, body string) func(*flatbuffers.Builder) flatbuffers.UOffsetT {
+	return func(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+		headerVector := buildTextEntries(b, headers)
+		bodyVector := b.CreateByteVector([]byte(body))
+		b.StartObject(3)
+		b.PrependUint16Slot(0, status, 0)
+		b.PrependUOffsetTSlot(1, headerVector, 0)
+		b.PrependUOffsetTSlot(2, bodyVector, 0)
+		return b.EndObject()
+	}
+}
+
+func stubRewrite(path string, headers, args, respHeaders []TextEntry) func(*flatbuffers.Builder) flatbuffers.UOffsetT {
+	return func(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+		var pathString flatbuffers.UOffsetT
+		if path != "" {
+			pathString = b.CreateString(path)
+		}
+		headerVector := buildTextEntries(b, headers)
+		argVector := buildTextEntries(b, args)
+		respHeaderVector := buildTextEntries(b, respHeaders)
+		b.StartObject(4)
+		if path != "" {
+			b.PrependUOffsetTSlot(0, pathString, 0)
+		}
+		b.PrependUOffsetTSlot(1, headerVector, 0)
+		b.PrependUOffsetTSlot(2, argVector, 0)
+		b.PrependUOffsetTSlot(3, respHeaderVector, 0)
+		return b.EndObject()
+	}
+}
+
+func encodeStubRespResp(id uint32, status uint16, headers []TextEntry, body []byte) []byte {
+	b := flatbuffers.NewBuilder(256)
+	headerVector := buildTextEntries(b, headers)
+	var bodyVector flatbuffers.UOffsetT
+	if body != nil {
+		bodyVector = b.CreateByteVector(body)
+	}
+	b.StartObject(4)
+	b.PrependUint32Slot(0, id, 0)
+	b.PrependUint16Slot(1, status, 0)
+	b.PrependUOffsetTSlot(2, headerVector, 0)
+	if body != nil {
+		b.PrependUOffsetTSlot(3, bodyVector, 0)
+	}
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+// askExtraInfo sends one ExtraInfo request on conn and returns the answer.
+func askExtraInfo(conn net.Conn, kind byte, name string) ([]byte, error) {
+	b := flatbuffers.NewBuilder(64)
+	var nameString flatbuffers.UOffsetT
+	fields := 0
+	if kind == infoVar {
+		nameString = b.CreateString(name)
+		fields = 1
+	}
+	b.StartObject(fields)
+	if kind == infoVar {
+		b.PrependUOffsetTSlot(0, nameString, 0)
+	}
+	member := b.EndObject()
+	b.StartObject(2)
+	b.PrependByteSlot(0, kind, 0)
+	b.PrependUOffsetTSlot(1, member, 0)
+	b.Finish(b.EndObject())
+	if err := writeFrame(conn, typeExtraInfo, b.FinishedBytes()); err != nil {
+		return nil, err
+	}
+	typ, payload, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeExtraInfo {
+		return nil, errors.New("unexpected extra info reply type")
+	}
+	var result []byte
+	err = decode(payload, func(t table) error {
+		result, _ = t.bytes(0)
+		return nil
+	})
+	return result, err
+}
+
+func newStubRunnerPair(t *testing.T) (*Runner, *stubRunner) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runner.sock")
+	stub := startStubRunner(t, path)
+	runner, err := New(Options{PathForTest: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(runner.Close)
+	return runner, stub
+}
+
+var testConf = Conf{Key: "route-1", Entries: []TextEntry{{Name: "stop", Value: "true"}}}
+
+func TestRunnerHTTPReqCallSendsRequestAndDecodesActions(t *testing.T) {
+	runner, stub := newStubRunnerPair(t)
+	calls := make(chan stubCall, 2)
+	stub.reqCall = func(_ net.Conn, call stubCall) []byte {
+		calls <- call
+		if call.Path == "/stop" {
+			return encodeStubReqResp(call.ID, actionStop, stubStop(
+				403, []TextEntry{{Name: "X-Reason", Value: "denied"}}, "forbidden",
+			))
+		}
+		return encodeStubReqResp(call.ID, actionRewrite, stubRewrite(
+			"/rewritten",
+			[]TextEntry{{Name: "X-Added", Value: "1"}, {Name: "X-Removed", Remove: true}},
+			[]TextEntry{{Name: "page", Value: "2"}},
+			[]TextEntry{{Name: "X-Resp", Value: "yes"}},
+		))
+	}
+
+	req := HTTPRequest{
+		SrcIP:   netip.MustParseAddr("10.1.2.3"),
+		Method:  "POST",
+		Path:    "/stop",
+		Args:    []TextEntry{{Name: "q", Value: "1"}},
+		Headers: []TextEntry{{Name: "Host", Value: "example.com"}},
+	}
+	result, err := runner.HTTPReqCall(context.Background(), testConf, req, nil)
+	if err != nil {
+		t.Fatalf("HTTPReqCall() error = %v", err)
+	}
+	call := <-calls
+	if call.Method != "POST" || call.SrcIP != req.SrcIP || call.Args[0] != req.Args[0] ||
+		call.Headers[0] != req.Headers[0] || call.Conf[0] != testConf.Entries[0] {
+		t.Fatalf("runner saw %#v", call)
+	}
+	if result.Stop == nil || result.Stop.Status != 403 || string(result.Stop.Body) != "forbidden" ||
+		result.Stop.Headers[0].Value != "denied" {
+		t.Fatalf("stop action = %#v", result.Stop)
+	}
+
+	req.Path = "/rewrite"
+	result, err = runner.HTTPReqCall(context.Background(), testConf, req, nil)
+	if err != nil {
+		t.Fatalf("HTTPReqCall() error = %v", err)
+	}
+	<-calls
+	rewrite := result.Rewrite
+	if rewrite == nil || !rewrite.HasPath || rewrite.Path != "/rewritten" ||
+		rewrite.Headers[0] != (TextEntry{Name: "X-Added", Value: "1"}) ||
+		rewrite.Headers[1] != (TextEntry{Name: "X-Removed", Remove: true}) ||
+		rewrite.Args[0].Value != "2" || rewrite.RespHeaders[0].Value != "yes" {
+		t.Fatalf("rewrite action = %#v", rewrite)
+	}
+	if got := stub.prepares.Load(); got != 1 {
+		t.Fatalf("prepares = %d, want the cached token reused", got)
+	}
+}
+
+func TestRunnerAnswersExtraInfoDuringCall(t *testing.T) {
+	runner, stub := newStubRunnerPair(t)
+	stub.respCall = func(conn net.Conn, call stubCall) []byte {
+		host, err := askExtraInfo(conn, infoVar, "host")
+		if err != nil {
+			return nil
+		}
+		body, err := askExtraInfo(conn, infoRespBody, "")
+		if err != nil {
+			return nil
+		}
+		return encodeStubRespResp(call.ID, 201,
+			[]TextEntry{{Name: "X-Host", Value: string(host)}}, append([]byte("seen:"), body...))
+	}
+
+	var asked []ExtraInfo
+	result, err := runner.HTTPRespCall(
+		context.Background(),
+		testConf,
+		HTTPResponse{Status: 200, Headers: []TextEntry{{Name: "Content-Type", Value: "text/plain"}}},
+		func(info ExtraInfo) ([]byte, error) {
+			asked = append(asked, info)
+			if info.Kind == ExtraInfoVar {
+				return []byte("example.com"), nil
+			}
+			return []byte("upstream"), nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("HTTPRespCall() error = %v", err)
+	}
+	if len(asked) != 2 || asked[0] != (ExtraInfo{Kind: ExtraInfoVar, Name: "host"}) ||
+		asked[1].Kind != ExtraInfoRespBody {
+		t.Fatalf("extra info requests = %#v", asked)
+	}
+	if result.Status != 201 || result.Headers[0].Value != "example.com" || string(result.Body) != "seen:upstream" {
+		t.Fatalf("HTTPRespCall() = %#v", result)
+	}
+}
+
+func TestRunnerReprepareOnConfTokenNotFound(t *testing.T) {
+	runner, stub := newStubRunnerPair(t)
+	if _, err := runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	stub.forgetTokens.Store(true)
+	if _, err := runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil); err != nil {
+		t.Fatalf("HTTPReqCall() after the runner forgot the token error = %v", err)
+	}
+	if got := stub.prepares.Load(); got != 2 {
+		t.Fatalf("prepares = %d, want one re-prepare", got)
+	}
+}
+
+func TestRunnerTokenCacheExpires(t *testing.T) {
+	runner, stub := newStubRunnerPair(t)
+	now := time.Now()
+	runner.now = func() time.Time { return now }
+	for range 2 {
+		if _, err := runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(DefaultConfExpireTime * 9 / 10)
+	if _, err := runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.prepares.Load(); got != 2 {
+		t.Fatalf("prepares = %d, want a re-prepare after nine tenths of the expire time", got)
+	}
+}
+
+func TestRunnerReportsRPCErrorsAndUnavailability(t *testing.T) {
+	runner, stub := newStubRunnerPair(t)
+	stub.reqCall = func(net.Conn, stubCall) []byte { return nil }
+	_, err := runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != ErrCodeServiceUnavailable {
+		t.Fatalf("HTTPReqCall() error = %v, want SERVICE_UNAVAILABLE", err)
+	}
+	_, err = runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "CONNECT", Path: "/"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "not representable") {
+		t.Fatalf("HTTPReqCall(CONNECT) error = %v", err)
+	}
+
+	_ = stub.listener.Close()
+	runner.reset()
+	_, err = runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: "/"}, nil)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("HTTPReqCall() with no listener error = %v, want ErrUnavailable", err)
+	}
+
+	var nilRunner *Runner
+	if _, err := nilRunner.HTTPReqCall(context.Background(), testConf, HTTPRequest{}, nil); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("nil runner error = %v, want ErrUnavailable", err)
+	}
+}
+
+// TestStubRunnerProcess is the runner process spawned by
+// TestRunnerSpawnsAndRestartsRunner; it is a no-op in a normal test run.
+func TestStubRunnerProcess(t *testing.T) {
+	if os.Getenv(stubRunnerEnv) != "1" {
+		t.Skip("stub runner process")
+	}
+	address := os.Getenv("APISIX_LISTEN_ADDRESS")
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok || os.Getenv("APISIX_CONF_EXPIRE_TIME") != "120" {
+		t.Fatalf("runner environment = %q/%q", address, os.Getenv("APISIX_CONF_EXPIRE_TIME"))
+	}
+	stub := startStubRunner(t, path)
+	stub.reqCall = func(_ net.Conn, call stubCall) []byte {
+		if call.Path == "/exit" {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				os.Exit(0)
+			}()
+		}
+		return encodeStubReqResp(call.ID, actionStop, stubStop(200, nil, "pid"))
+	}
+	select {}
+}
+
+func TestRunnerSpawnsAndRestartsRunner(t *testing.T) {
+	t.Setenv(stubRunnerEnv, "1")
+	runner, err := New(Options{
+		Cmd:            []string{os.Args[0], "-test.run", "^TestStubRunnerProcess$"},
+		ConfExpireTime: 2 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketDir := filepath.Dir(runner.SocketPath())
+	runner.Start(context.Background())
+	defer runner.Close()
+
+	waitReady := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !runner.Ready() {
+			if time.Now().After(deadline) {
+				t.Fatal("runner did not become ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	call := func(path string) (HTTPReqResult, error) {
+		return runner.HTTPReqCall(context.Background(), testConf, HTTPRequest{Method: "GET", Path: path}, nil)
+	}
+
+	waitReady()
+	if result, err := call("/"); err != nil || result.Stop == nil {
+		t.Fatalf("HTTPReqCall() = %#v, %v", result, err)
+	}
+	if _, err := call("/exit"); err != nil {
+		t.Fatalf("HTTPReqCall(/exit) error = %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if result, err := call("/"); err == nil && result.Stop != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("runner was not restarted")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	runner.Close()
+	if runner.Ready() {
+		t.Fatal("closed runner reports ready")
+	}
+	if _, err := os.Stat(socketDir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket directory after Close: %v", err)
+	}
+}
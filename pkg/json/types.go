@@ -43,3 +43,7 @@ func NewDecoder(reader io.Reader) Decoder {
 func NewEncoder(writer io.Writer) Encoder {
 	return gojson.NewEncoder(writer)
 }
+
+func Valid(data []byte) bool {
+	return gojson.Valid(data)
+}
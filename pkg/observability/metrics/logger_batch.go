@@ -12,6 +12,7 @@ const (
 	LoggerBatchOutcomeDeliveryFailed  = "delivery_failed"
 	LoggerBatchOutcomeDeliveryTimeout = "delivery_timeout"
 	LoggerBatchOutcomeShutdownTimeout = "shutdown_timeout"
+	LoggerBatchOutcomeSpoolEvicted    = "spool_evicted"
 )
 
 var (
@@ -26,6 +27,7 @@ type LoggerBatchObserver interface {
 	SetBuffered(int)
 	AddPending(int)
 	AddEvent(string) bool
+	AddEvents(string, int) bool
 	Close()
 }
 
@@ -163,7 +165,13 @@ func (o *loggerBatchObserver) AddPending(delta int) {
 }
 
 func (o *loggerBatchObserver) AddEvent(outcome string) bool {
-	if o == nil || !o.valid() || !validLoggerBatchOutcome(outcome) {
+	return o.AddEvents(outcome, 1)
+}
+
+// AddEvents counts one outcome for several entries at once, as when a spool
+// segment holding many undelivered entries is evicted.
+func (o *loggerBatchObserver) AddEvents(outcome string, count int) bool {
+	if o == nil || !o.valid() || !validLoggerBatchOutcome(outcome) || count <= 0 {
 		return false
 	}
 	o.mu.Lock()
@@ -172,7 +180,7 @@ func (o *loggerBatchObserver) AddEvent(outcome string) bool {
 		return false
 	}
 	if LoggerBatchEvents != nil {
-		LoggerBatchEvents.WithLabelValues(o.pluginID, outcome).Inc()
+		LoggerBatchEvents.WithLabelValues(o.pluginID, outcome).Add(float64(count))
 	}
 	return true
 }
@@ -251,7 +259,8 @@ func validLoggerBatchOutcome(outcome string) bool {
 		LoggerBatchOutcomeStoppedDropped,
 		LoggerBatchOutcomeDeliveryFailed,
 		LoggerBatchOutcomeDeliveryTimeout,
-		LoggerBatchOutcomeShutdownTimeout:
+		LoggerBatchOutcomeShutdownTimeout,
+		LoggerBatchOutcomeSpoolEvicted:
 		return true
 	default:
 		return false
@@ -191,10 +191,11 @@ func StartExportServer(cfg ExportServerConfig) (*http.Server, net.Addr, error) {
 }
 
 func prometheusPluginAttributes() map[string]any {
-	if config.GlobalConfig == nil || config.GlobalConfig.PluginAttr == nil {
+	cfg := config.Global()
+	if cfg == nil || cfg.PluginAttr == nil {
 		return nil
 	}
-	return config.GlobalConfig.PluginAttr["prometheus"]
+	return cfg.PluginAttr["prometheus"]
 }
 
 func configuredPrometheusEndpoint(attr map[string]any) (prometheusEndpointConfig, error) {
@@ -356,8 +357,8 @@ func StartExpiration(ctx context.Context) (func(context.Context) error, error) {
 
 func initMetrics() error {
 	var attr map[string]any
-	if config.GlobalConfig != nil {
-		attr = config.GlobalConfig.PluginAttr["prometheus"]
+	if cfg := config.Global(); cfg != nil {
+		attr = cfg.PluginAttr["prometheus"]
 	}
 	metricConfig, err := newPrometheusMetricConfig(attr)
 	if err != nil {
@@ -681,6 +682,7 @@ func initMetrics() error {
 		ConfigApplyFailures,
 		ConfigApplyReady,
 		ConfigApplyQuarantined,
+		newSharedDictCollector(metricConfig.MetricPrefix),
 	} {
 		if err := prometheus.Register(collector); err != nil {
 			return fmt.Errorf("register prometheus collector: %w", err)
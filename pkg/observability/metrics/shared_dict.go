@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wklken/apisix-go/pkg/shared"
+)
+
+const (
+	sharedDictCapacityMetric  = "shared_dict_capacity_bytes"
+	sharedDictFreeSpaceMetric = "shared_dict_free_space_bytes"
+)
+
+// sharedDictCollector reports the size of every shared dict zone at scrape
+// time, so zones that plugins create on first use appear without
+// registration.
+type sharedDictCollector struct {
+	capacity  *prometheus.Desc
+	freeSpace *prometheus.Desc
+}
+
+func newSharedDictCollector(prefix string) *sharedDictCollector {
+	return &sharedDictCollector{
+		capacity: prometheus.NewDesc(
+			prefix+sharedDictCapacityMetric,
+			"The capacity of each nginx shared DICT since APISIX start",
+			[]string{"name"}, nil,
+		),
+		freeSpace: prometheus.NewDesc(
+			prefix+sharedDictFreeSpaceMetric,
+			"The free space of each nginx shared DICT since APISIX start",
+			[]string{"name"}, nil,
+		),
+	}
+}
+
+func (c *sharedDictCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.capacity
+	ch <- c.freeSpace
+}
+
+func (c *sharedDictCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range shared.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(stats.Capacity), stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.freeSpace, prometheus.GaugeValue, float64(stats.FreeSpace), stats.Name)
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wklken/apisix-go/pkg/shared"
+)
+
+func TestSharedDictCollectorReportsZones(t *testing.T) {
+	if err := shared.ConfigureZones(map[string]string{"metrics-test": "1k"}); err != nil {
+		t.Fatalf("ConfigureZones() error = %v", err)
+	}
+	t.Cleanup(func() { _ = shared.ConfigureZones() })
+	if err := shared.LookupZone("metrics-test").Set("key", "value", 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newSharedDictCollector("apisix_"))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == "metrics-test" {
+				got[family.GetName()] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	want := map[string]float64{
+		"apisix_shared_dict_capacity_bytes":   1024,
+		"apisix_shared_dict_free_space_bytes": 1024 - float64(len("key")+len("value")+64),
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("shared dict metrics = %v, want %v", got, want)
+	}
+}
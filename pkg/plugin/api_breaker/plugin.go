@@ -4,13 +4,13 @@ import (
 	"context"
 	"net/http"
 	"slices"
-	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/shared"
 )
 
 type finalizerRegistrationsKey struct{}
@@ -19,18 +19,19 @@ type Plugin struct {
 	base.BasePlugin
 	config Config
 
-	// APISIX shares breaker counters by host and URI; this Go implementation keeps route-local state.
-	mu                sync.Mutex
-	unhealthyCount    int
-	healthyCount      int
-	lastUnhealthyTime time.Time
-	now               func() time.Time
+	// Breaker counters live in the plugin-api-breaker zone keyed by host and
+	// URI, as in APISIX, so every route serving the URI shares one breaker
+	// and route reloads keep its state.
+	zone *shared.Dict
+	now  func() time.Time
 }
 
 const (
 	// version  = "0.1"
 	priority = 1005
 	name     = "api-breaker"
+
+	zoneName = "plugin-api-breaker"
 )
 
 const schema = `
@@ -157,6 +158,9 @@ func (p *Plugin) PostInit() error {
 	if p.now == nil {
 		p.now = time.Now
 	}
+	if p.zone == nil {
+		p.zone = shared.Zone(zoneName)
+	}
 	if p.config.MaxBreakerSec == 0 {
 		p.config.MaxBreakerSec = 300
 	}
@@ -187,7 +191,8 @@ func (p *Plugin) Config() any {
 // registers one request-local outcome observer. The observer updates breaker
 // counters only for completed, committed, non-hijacked HTTP outcomes.
 func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.RequestPhaseResult {
-	if p.shouldBreak() {
+	key := breakerKey(r)
+	if p.shouldBreak(key) {
 		if p.config.BreakResponseBody != nil && p.config.BreakResponseHeaders != nil {
 			for _, header := range p.config.BreakResponseHeaders {
 				w.Header().Set(header.Key, resolveHeaderValue(r, header.Value))
@@ -218,7 +223,7 @@ func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.Re
 		if outcome.Kind != apisixctx.RequestOutcomeCompleted || !outcome.Committed || outcome.Hijacked {
 			return nil
 		}
-		p.observeStatus(outcome.Status)
+		p.observeStatus(key, outcome.Status)
 		return nil
 	}) {
 		r = r.WithContext(context.WithValue(r.Context(), finalizerRegistrationsKey{}, registrations))
@@ -240,7 +245,8 @@ func (p *Plugin) Handler(next http.Handler) http.Handler {
 			base.AdaptRequestPhase(p, next).ServeHTTP(w, r)
 			return
 		}
-		if p.shouldBreak() {
+		key := breakerKey(r)
+		if p.shouldBreak(key) {
 			if p.config.BreakResponseBody != nil && p.config.BreakResponseHeaders != nil {
 				for _, header := range p.config.BreakResponseHeaders {
 					w.Header().Set(header.Key, resolveHeaderValue(r, header.Value))
@@ -256,41 +262,64 @@ func (p *Plugin) Handler(next http.Handler) http.Handler {
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
 		next.ServeHTTP(ww, r)
-		p.observeStatus(ww.Status())
+		p.observeStatus(key, ww.Status())
 	}
 	return http.HandlerFunc(fn)
 }
 
-func (p *Plugin) shouldBreak() bool {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.unhealthyCount == 0 || p.lastUnhealthyTime.IsZero() {
+// breakerKey identifies the breaker of the request's host and URI.
+func breakerKey(r *http.Request) string {
+	return base.RequestVarFromNginx(r, "host") + base.RequestVarFromNginx(r, "uri")
+}
+
+func (p *Plugin) shouldBreak(key string) bool {
+	unhealthyCount, ok := p.zone.Get("unhealthy-" + key)
+	if !ok {
+		return false
+	}
+	lastUnhealthyTime, ok := p.zone.Get("unhealthy-lasttime" + key)
+	if !ok {
 		return false
 	}
-	seconds := breakerSeconds(p.unhealthyCount, *p.config.Unhealthy.Failures, p.config.MaxBreakerSec)
+	seconds := breakerSeconds(int(unhealthyCount.(float64)), *p.config.Unhealthy.Failures, p.config.MaxBreakerSec)
 	logger.Debugf("breaker_time: %d", seconds)
-	return !p.now().After(p.lastUnhealthyTime.Add(time.Duration(seconds) * time.Second))
+	tripped := time.UnixMilli(int64(lastUnhealthyTime.(float64)))
+	return !p.now().After(tripped.Add(time.Duration(seconds) * time.Second))
 }
 
-func (p *Plugin) observeStatus(status int) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+func (p *Plugin) observeStatus(key string, status int) {
+	unhealthyKey := "unhealthy-" + key
+	healthyKey := "healthy-" + key
+	lasttimeKey := "unhealthy-lasttime" + key
 	switch {
 	case slices.Contains(p.config.Unhealthy.HTTPStatuses, status):
-		p.unhealthyCount++
-		p.healthyCount = 0
-		if p.unhealthyCount%*p.config.Unhealthy.Failures == 0 {
-			p.lastUnhealthyTime = p.now()
+		var zero float64
+		unhealthyCount, err := p.zone.Incr(unhealthyKey, 1, &zero, 0)
+		if err != nil {
+			logger.Errorf("failed to incr unhealthy_key: %s", err)
+			return
+		}
+		p.zone.Delete(healthyKey)
+		if int(unhealthyCount)%*p.config.Unhealthy.Failures == 0 {
+			maxBreaker := time.Duration(p.config.MaxBreakerSec) * time.Second
+			if err := p.zone.Set(lasttimeKey, float64(p.now().UnixMilli()), maxBreaker); err != nil {
+				logger.Errorf("failed to set lasttime_key: %s", err)
+			}
 		}
 	case slices.Contains(p.config.Healthy.HTTPStatuses, status):
-		if p.unhealthyCount == 0 {
+		if _, ok := p.zone.Get(unhealthyKey); !ok {
+			return
+		}
+		var zero float64
+		healthyCount, err := p.zone.Incr(healthyKey, 1, &zero, 0)
+		if err != nil {
+			logger.Errorf("failed to incr healthy_key: %s", err)
 			return
 		}
-		p.healthyCount++
-		if p.healthyCount >= *p.config.Healthy.Successes {
-			p.unhealthyCount = 0
-			p.healthyCount = 0
-			p.lastUnhealthyTime = time.Time{}
+		if int(healthyCount) >= *p.config.Healthy.Successes {
+			p.zone.Delete(lasttimeKey)
+			p.zone.Delete(unhealthyKey)
+			p.zone.Delete(healthyKey)
 		}
 	}
 }
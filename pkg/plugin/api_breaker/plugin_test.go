@@ -10,6 +10,7 @@ import (
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/shared"
 )
 
 func TestAPIBreakerFinalizerObservesOnlyCompletedCommittedNonHijacked(t *testing.T) {
@@ -32,8 +33,8 @@ func TestAPIBreakerFinalizerObservesOnlyCompletedCommittedNonHijacked(t *testing
 		Committed: true,
 	})
 	lifecycle.Finalize()
-	if p.unhealthyCount != 1 {
-		t.Fatalf("unhealthyCount = %d, want 1 for completed committed response", p.unhealthyCount)
+	if got := unhealthyCount(p, "example.com/anything"); got != 1 {
+		t.Fatalf("unhealthyCount = %v, want 1 for completed committed response", got)
 	}
 
 	for name, outcome := range map[string]apisixctx.ResponseOutcome{
@@ -56,8 +57,8 @@ func TestAPIBreakerFinalizerObservesOnlyCompletedCommittedNonHijacked(t *testing
 			}
 			lc.SetOutcome(outcome)
 			lc.Finalize()
-			if plugin.unhealthyCount != 0 {
-				t.Fatalf("unhealthyCount = %d, want 0 for %s", plugin.unhealthyCount, name)
+			if got := unhealthyCount(plugin, "example.com/anything"); got != 0 {
+				t.Fatalf("unhealthyCount = %v, want 0 for %s", got, name)
 			}
 		})
 	}
@@ -66,7 +67,7 @@ func TestAPIBreakerFinalizerObservesOnlyCompletedCommittedNonHijacked(t *testing
 func newTestPlugin(t *testing.T, cfg Config) *Plugin {
 	t.Helper()
 
-	p := &Plugin{config: cfg}
+	p := &Plugin{config: cfg, zone: shared.NewDict(1 << 20)}
 	if err := p.Init(); err != nil {
 		t.Fatalf("Init() error = %v", err)
 	}
@@ -77,6 +78,12 @@ func newTestPlugin(t *testing.T, cfg Config) *Plugin {
 	return p
 }
 
+func unhealthyCount(p *Plugin, key string) float64 {
+	count, _ := p.zone.Get("unhealthy-" + key)
+	value, _ := count.(float64)
+	return value
+}
+
 func TestHandlerResolvesBreakResponseHeaders(t *testing.T) {
 	p := newTestPlugin(t, Config{
 		BreakResponseCode: http.StatusTooManyRequests,
@@ -99,7 +106,7 @@ func TestHandlerResolvesBreakResponseHeaders(t *testing.T) {
 	}))
 
 	first := httptest.NewRecorder()
-	firstReq := httptest.NewRequest(http.MethodGet, "/first", nil)
+	firstReq := httptest.NewRequest(http.MethodGet, "/blocked", nil)
 	handler.ServeHTTP(first, firstReq)
 	if first.Code != http.StatusInternalServerError {
 		t.Fatalf("first response code = %d, want %d", first.Code, http.StatusInternalServerError)
@@ -225,10 +232,11 @@ func TestBreakerTimeLogIsDebugLevel(t *testing.T) {
 		Unhealthy:     UnHealthCheck{Failures: &failures},
 		MaxBreakerSec: 300,
 	})
-	p.unhealthyCount = 2
-	p.lastUnhealthyTime = time.Now()
+	key := "example.com/api"
+	_ = p.zone.Set("unhealthy-"+key, 2.0, 0)
+	_ = p.zone.Set("unhealthy-lasttime"+key, float64(time.Now().UnixMilli()), 0)
 
-	_ = p.shouldBreak()
+	_ = p.shouldBreak(key)
 	select {
 	case entry := <-entries:
 		t.Fatalf("breaker_time logged at info level: %q", entry.Message)
@@ -238,7 +246,7 @@ func TestBreakerTimeLogIsDebugLevel(t *testing.T) {
 	if err := logger.ConfigureLevel("debug"); err != nil {
 		t.Fatalf("configure debug level: %v", err)
 	}
-	_ = p.shouldBreak()
+	_ = p.shouldBreak(key)
 	select {
 	case entry := <-entries:
 		if !strings.Contains(entry.Message, "breaker_time") {
@@ -248,3 +256,39 @@ func TestBreakerTimeLogIsDebugLevel(t *testing.T) {
 		t.Fatal("breaker_time not logged at debug level")
 	}
 }
+
+func TestBreakerStateIsSharedByHostAndURIAcrossInstances(t *testing.T) {
+	cfg := Config{
+		BreakResponseCode: http.StatusServiceUnavailable,
+		Unhealthy: UnHealthCheck{
+			HTTPStatuses: []int{http.StatusInternalServerError},
+			Failures:     new(1),
+		},
+	}
+	zone := shared.NewDict(1 << 20)
+	failing := newTestPlugin(t, cfg)
+	failing.zone = zone
+	handler := failing.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://a.example/api", nil))
+
+	// A plugin instance of another route, or of the reloaded route, sees the
+	// same breaker for the same host and URI only.
+	reloaded := newTestPlugin(t, cfg)
+	reloaded.zone = zone
+	upstream := reloaded.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for target, want := range map[string]int{
+		"http://a.example/api":   http.StatusServiceUnavailable,
+		"http://a.example/other": http.StatusOK,
+		"http://b.example/api":   http.StatusOK,
+	} {
+		response := httptest.NewRecorder()
+		upstream.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+		if response.Code != want {
+			t.Fatalf("%s response code = %d, want %d", target, response.Code, want)
+		}
+	}
+}
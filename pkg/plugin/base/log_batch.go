@@ -1,6 +1,11 @@
 package base
 
-import "github.com/wklken/apisix-go/pkg/json"
+import (
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/logger_batch"
+)
 
 // EncodeLogBatch encodes either a single entry or an entry array according to
 // the logger batch boundary. When originKey is set and every entry contains a
@@ -36,3 +41,51 @@ func OriginLogEntries(entries []map[string]any, originKey string) ([]string, boo
 	}
 	return originEntries, true
 }
+
+// BatchSpoolSchema is the common "spool" flag accepted by every logger built
+// on logger_batch. A route only opts in; the directory, the byte budget and
+// the fsync policy come from `apisix.log_spool`, see BatchSpool.
+const BatchSpoolSchema = `{"type": "boolean", "default": false}`
+
+// BatchSpool returns the spool of a logger whose route config set
+// `spool: true`, or nil when it did not or cfg configures no
+// `apisix.log_spool.dir`.
+func BatchSpool(cfg *config.Config, enabled bool) *logger_batch.SpoolConfig {
+	if !enabled {
+		return nil
+	}
+	if cfg == nil || cfg.Apisix.LogSpool.Dir == "" {
+		logger.Warnf("logger spool requested but apisix.log_spool.dir is not configured, spool disabled")
+		return nil
+	}
+	spool := cfg.Apisix.LogSpool
+	return &logger_batch.SpoolConfig{
+		Dir:          spool.Dir,
+		MaxBytes:     spool.MaxBytes,
+		SegmentBytes: spool.SegmentBytes,
+		Fsync:        spool.Fsync,
+	}
+}
+
+// WithBatchSpoolSchema adds the spool flag to a batch logger's schema. The
+// schema is returned unchanged when it is not a JSON object, so that schema
+// registration reports the original error.
+func WithBatchSpoolSchema(schema string) string {
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		return schema
+	}
+	properties, _ := parsed["properties"].(map[string]any)
+	if properties == nil {
+		properties = map[string]any{}
+		parsed["properties"] = properties
+	}
+	var spool any
+	_ = json.Unmarshal([]byte(BatchSpoolSchema), &spool)
+	properties["spool"] = spool
+	encoded, err := json.Marshal(parsed)
+	if err != nil {
+		return schema
+	}
+	return string(encoded)
+}
@@ -3,6 +3,8 @@ package base
 import (
 	"strings"
 	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
 )
 
 func TestEncodeLogBatchPreservesBatchBoundaries(t *testing.T) {
@@ -82,3 +84,18 @@ func TestOriginLogEntriesRequiresEveryEntryToContainAString(t *testing.T) {
 		t.Fatalf("OriginLogEntries(mixed) = %v, %t; want nil, false", got, ok)
 	}
 }
+
+func TestBatchSpoolTakesDirectoryAndBudgetFromConfig(t *testing.T) {
+	cfg := &config.Config{}
+	if spool := BatchSpool(cfg, true); spool != nil {
+		t.Fatalf("BatchSpool() without apisix.log_spool.dir = %+v, want nil", spool)
+	}
+	cfg.Apisix.LogSpool = config.LogSpool{Dir: "/var/spool/apisix", MaxBytes: 1 << 20, Fsync: "always"}
+	if spool := BatchSpool(cfg, false); spool != nil {
+		t.Fatalf("BatchSpool() for a route that did not opt in = %+v, want nil", spool)
+	}
+	spool := BatchSpool(cfg, true)
+	if spool == nil || spool.Dir != "/var/spool/apisix" || spool.MaxBytes != 1<<20 || spool.Fsync != "always" {
+		t.Fatalf("BatchSpool() = %+v, want apisix.log_spool", spool)
+	}
+}
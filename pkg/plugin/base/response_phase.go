@@ -89,6 +89,13 @@ type FinalResponseStorePlugin interface {
 	RunFinalResponseStore(*http.Request, ResponseState) error
 }
 
+// ErrorResponseFallbackPlugin lets a final response store replace an error
+// response with a cached one before any filter runs. It publishes the cached
+// response to the request's CacheHitResponseHolder and reports whether it did.
+type ErrorResponseFallbackPlugin interface {
+	RunErrorResponseFallback(*http.Request, int) bool
+}
+
 type ResponseEligibility interface {
 	AppliesToResponseSource(apisixctx.ResponseSource) bool
 }
@@ -15,6 +15,7 @@ import (
 	brotlidec "github.com/andybalholm/brotli"
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/apisix/log"
+	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/logger_batch"
 )
@@ -24,6 +25,8 @@ type BasePlugin struct {
 	Priority       int
 	Schema         string
 	MetadataSchema string
+
+	appConfig *config.Config
 }
 
 func (p *BasePlugin) GetName() string {
@@ -38,6 +41,22 @@ func (p *BasePlugin) SetPriority(priority int) {
 	p.Priority = priority
 }
 
+// SetAppConfig hands the plugin the configuration its route generation is
+// built against. A config reload builds against a candidate that is published
+// only once the new generation is installed.
+func (p *BasePlugin) SetAppConfig(cfg *config.Config) {
+	p.appConfig = cfg
+}
+
+// AppConfig returns the configuration set by SetAppConfig, or the published
+// one for a plugin built outside the route builder.
+func (p *BasePlugin) AppConfig() *config.Config {
+	if p.appConfig != nil {
+		return p.appConfig
+	}
+	return config.Global()
+}
+
 func (p *BasePlugin) GetSchema() string {
 	return p.Schema
 }
@@ -377,6 +396,9 @@ type BatchDefaults struct {
 	MaxConcurrentDeliveries int
 	DeliveryTimeoutSec      int
 	ShutdownTimeoutSec      int
+
+	// Spool is the logger's optional "spool" block, see BatchSpoolSchema.
+	Spool *logger_batch.SpoolConfig
 }
 
 // ApplyBatchDefaults fills zero batch values with logger_batch defaults.
@@ -435,6 +457,7 @@ func NewBatchProcessor(
 		ShutdownTimeout:         time.Duration(d.ShutdownTimeoutSec) * time.Second,
 		RouteID:                 routeID,
 		ServerAddr:              serverAddr,
+		Spool:                   d.Spool,
 	}, deliver)
 }
 
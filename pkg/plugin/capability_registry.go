@@ -160,79 +160,86 @@ func buildCapabilityRegistry() map[string]CapabilitySpec {
 	}
 
 	for identity, spec := range registry {
-		if stage, ok := RequestStageFor(identity); ok {
-			spec.Capabilities |= capabilityForRequestStage(stage.Stage)
-			if stage.Stage != RequestStageLegacy && stage.Stage != RequestStageNone {
-				spec.RequestOwners = append(spec.RequestOwners, RequestOwnerInheritedStage)
-			}
-		}
-		if capability, ok := ResponseCapabilityFor(identity); ok {
-			spec.Capabilities |= capabilityBits(capability)
-			spec.ResponseOwners = append(spec.ResponseOwners, responseOwnerKinds(capability)...)
-		}
-		if responseSpec, ok := responseFactoryRegistry[identity]; ok {
-			if responseSpec.mask&ResponsePhaseHeader != 0 {
-				spec.Capabilities |= CapabilityHeaderFilter
-				spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerHeaderFilter)
-			}
-			if responseSpec.mask&ResponsePhaseBufferedBody != 0 {
-				spec.Capabilities |= CapabilityBufferedBodyFilter
-				spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerBufferedBodyFilter)
-			}
-			if responseSpec.mask&ResponsePhaseFinalStore != 0 {
-				spec.Capabilities |= CapabilityFinalResponseStore
-				spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerFinalStore)
-			}
-		}
-		if isLogIdentity(identity) {
-			spec.Capabilities |= CapabilityLog
-		}
-		if kind, ok := finalizerForIdentity(identity); ok {
-			spec.Capabilities |= CapabilityFinalizer
-			spec.Finalizer = kind
-		}
-		if generation, ok := generationOwnerForIdentity(identity); ok {
-			spec.Capabilities |= CapabilityGenerationOwner
-			spec.GenerationOwner = generation
-		}
-		if isConditionalTerminalIdentity(identity) {
-			spec.Capabilities |= CapabilityConditionalTerminal
+		registry[identity] = deriveCapabilitySpec(identity, spec)
+	}
+	return registry
+}
+
+// deriveCapabilitySpec adds the capabilities and owners implied by the
+// request-stage, response, log, finalizer, and subsystem tables to the
+// manifest entry of one identity.
+func deriveCapabilitySpec(identity string, spec CapabilitySpec) CapabilitySpec {
+	if stage, ok := RequestStageFor(identity); ok {
+		spec.Capabilities |= capabilityForRequestStage(stage.Stage)
+		if stage.Stage != RequestStageLegacy && stage.Stage != RequestStageNone {
+			spec.RequestOwners = append(spec.RequestOwners, RequestOwnerInheritedStage)
 		}
-		if identity == "proxy-mirror" {
-			spec.Capabilities |= CapabilityBeforeProxy
-			spec.RequestOwners = append(spec.RequestOwners, RequestOwnerBeforeProxyHookRegistration)
+	}
+	if capability, ok := ResponseCapabilityFor(identity); ok {
+		spec.Capabilities |= capabilityBits(capability)
+		spec.ResponseOwners = append(spec.ResponseOwners, responseOwnerKinds(capability)...)
+	}
+	if responseSpec, ok := responseFactoryRegistry[identity]; ok {
+		if responseSpec.mask&ResponsePhaseHeader != 0 {
+			spec.Capabilities |= CapabilityHeaderFilter
+			spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerHeaderFilter)
 		}
-		if beforeProxyOwnerForFactory(identity) == RequestOwnerBeforeProxyConsumer {
-			spec.Capabilities |= CapabilityBeforeProxy
-			spec.RequestOwners = append(spec.RequestOwners, RequestOwnerBeforeProxyConsumer)
+		if responseSpec.mask&ResponsePhaseBufferedBody != 0 {
+			spec.Capabilities |= CapabilityBufferedBodyFilter
+			spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerBufferedBodyFilter)
 		}
-		if isSeparateSubsystemIdentity(identity) {
-			spec.Capabilities |= CapabilitySeparateSubsystem
-			if identity == "mqtt-proxy" {
-				spec.Capabilities |= CapabilityProtocolOwner
-			}
-			spec.RequestOwners = append(spec.RequestOwners, RequestOwnerSeparateSubsystem)
+		if responseSpec.mask&ResponsePhaseFinalStore != 0 {
+			spec.Capabilities |= CapabilityFinalResponseStore
+			spec.ResponseOwners = append(spec.ResponseOwners, ResponseOwnerFinalStore)
 		}
-		if isServerlessIdentity(identity) {
-			spec.Capabilities |= CapabilityRequestRewrite | CapabilityRequestAccess |
-				CapabilityBeforeProxy | CapabilityHeaderFilter | CapabilityBufferedBodyFilter |
-				CapabilityConditionalTerminal
-			spec.RequestOwners = append(
-				spec.RequestOwners,
-				RequestOwnerInheritedStage,
-				RequestOwnerBeforeProxyConsumer,
-			)
-			spec.ResponseOwners = append(
-				spec.ResponseOwners,
-				ResponseOwnerHeaderFilter,
-				ResponseOwnerBufferedBodyFilter,
-			)
+	}
+	if isLogIdentity(identity) {
+		spec.Capabilities |= CapabilityLog
+	}
+	if kind, ok := finalizerForIdentity(identity); ok {
+		spec.Capabilities |= CapabilityFinalizer
+		spec.Finalizer = kind
+	}
+	if generation, ok := generationOwnerForIdentity(identity); ok {
+		spec.Capabilities |= CapabilityGenerationOwner
+		spec.GenerationOwner = generation
+	}
+	if isConditionalTerminalIdentity(identity) {
+		spec.Capabilities |= CapabilityConditionalTerminal
+	}
+	if identity == "proxy-mirror" {
+		spec.Capabilities |= CapabilityBeforeProxy
+		spec.RequestOwners = append(spec.RequestOwners, RequestOwnerBeforeProxyHookRegistration)
+	}
+	if beforeProxyOwnerForFactory(identity) == RequestOwnerBeforeProxyConsumer {
+		spec.Capabilities |= CapabilityBeforeProxy
+		spec.RequestOwners = append(spec.RequestOwners, RequestOwnerBeforeProxyConsumer)
+	}
+	if isSeparateSubsystemIdentity(identity) {
+		spec.Capabilities |= CapabilitySeparateSubsystem
+		if identity == "mqtt-proxy" {
+			spec.Capabilities |= CapabilityProtocolOwner
 		}
-		spec.RequestOwners = uniqueRequestOwners(spec.RequestOwners)
-		spec.ResponseOwners = uniqueResponseOwners(spec.ResponseOwners)
-		registry[identity] = spec
+		spec.RequestOwners = append(spec.RequestOwners, RequestOwnerSeparateSubsystem)
 	}
-	return registry
+	if isServerlessIdentity(identity) {
+		spec.Capabilities |= CapabilityRequestRewrite | CapabilityRequestAccess |
+			CapabilityBeforeProxy | CapabilityHeaderFilter | CapabilityBufferedBodyFilter |
+			CapabilityConditionalTerminal
+		spec.RequestOwners = append(
+			spec.RequestOwners,
+			RequestOwnerInheritedStage,
+			RequestOwnerBeforeProxyConsumer,
+		)
+		spec.ResponseOwners = append(
+			spec.ResponseOwners,
+			ResponseOwnerHeaderFilter,
+			ResponseOwnerBufferedBodyFilter,
+		)
+	}
+	spec.RequestOwners = uniqueRequestOwners(spec.RequestOwners)
+	spec.ResponseOwners = uniqueResponseOwners(spec.ResponseOwners)
+	return spec
 }
 
 // capabilityManifestEntries is the production copy of the capability
@@ -240,7 +247,7 @@ func buildCapabilityRegistry() map[string]CapabilitySpec {
 // group; an unknown identity remains unclassified so completeness checks fail
 // instead of silently assigning the system capability.
 func capabilityManifestEntries() map[string]capabilityManifestEntry {
-	entries := make(map[string]capabilityManifestEntry, 114)
+	entries := make(map[string]capabilityManifestEntry, 117)
 	add := func(plan string, capabilities Capability, identities ...string) {
 		for _, identity := range identities {
 			entries[identity] = capabilityManifestEntry{
@@ -336,6 +343,7 @@ func capabilityManifestEntries() map[string]capabilityManifestEntry {
 		CapabilityBufferedBodyFilter,
 		"error-page",
 		"exit-transformer",
+		"ext-plugin-post-resp",
 	)
 	add("Plan 15", CapabilityHeaderFilter|CapabilityBufferedBodyFilter, "response-rewrite")
 	add(
@@ -384,6 +392,12 @@ func capabilityManifestEntries() map[string]capabilityManifestEntry {
 		"Plan 16",
 		CapabilityRequestRewrite|CapabilityConditionalTerminal|CapabilityHeaderFilter,
 		"cors",
+		"ext-plugin-pre-req",
+	)
+	add(
+		"Plan 16",
+		CapabilityRequestAccess|CapabilityConditionalTerminal|CapabilityHeaderFilter,
+		"ext-plugin-post-req",
 	)
 	add(
 		"Plan 16",
@@ -596,6 +610,9 @@ func uniqueResponseOwners(owners []ResponseOwnerKind) []ResponseOwnerKind {
 }
 
 func isLogIdentity(identity string) bool {
+	if registration, ok := registeredPlugins[identity]; ok {
+		return registration.Log
+	}
 	if identity == "serverless-pre-function" || identity == "serverless-post-function" {
 		return true
 	}
@@ -628,6 +645,9 @@ func generationOwnerForIdentity(identity string) (GenerationOwnerKind, bool) {
 }
 
 func isConditionalTerminalIdentity(identity string) bool {
+	if registration, ok := registeredPlugins[identity]; ok {
+		return registration.ConditionalTerminal
+	}
 	return slices.Contains([]string{
 		"limit-conn", "ai-aliyun-content-moderation", "ai-prompt-decorator", "ai-prompt-template", "ai-rag",
 		"ai-request-rewrite", "ai-proxy", "ai-proxy-multi", "ai-rate-limiting", "aws-lambda",
@@ -640,7 +660,7 @@ func isConditionalTerminalIdentity(identity string) bool {
 		"wolf-rbac", "workflow", "api-breaker", "graphql-proxy-cache", "proxy-cache", "serverless-pre-function",
 		"serverless-post-function", "cors", "fault-injection", "grpc-transcode", "grpc-web", "jwe-decrypt",
 		"mcp-bridge", "mocking", "openfunction", "openwhisk", "public-api", "redirect", "request-id",
-		"degraphql", "traffic-split",
+		"degraphql", "traffic-split", "ext-plugin-pre-req", "ext-plugin-post-req",
 	}, identity)
 }
 
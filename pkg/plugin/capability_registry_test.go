@@ -19,16 +19,16 @@ func (f capabilityPhaseFixture) DescribeBindingPhases() (base.BindingPhaseDescri
 	return f.descriptor, nil
 }
 
-func TestCapabilityRegistryCompleteness115Factories114Identities(t *testing.T) {
-	if len(pluginRegistry) != 115 {
-		t.Fatalf("factory count = %d, want 115", len(pluginRegistry))
+func TestCapabilityRegistryCompleteness118Factories117Identities(t *testing.T) {
+	if len(pluginRegistry) != 118 {
+		t.Fatalf("factory count = %d, want 118", len(pluginRegistry))
 	}
-	if len(capabilityRegistry) != 114 {
-		t.Fatalf("identity count = %d, want 114", len(capabilityRegistry))
+	if len(capabilityRegistry) != 117 {
+		t.Fatalf("identity count = %d, want 117", len(capabilityRegistry))
 	}
 	manifest := capabilityManifestEntries()
-	if len(manifest) != 114 {
-		t.Fatalf("manifest identity count = %d, want 114", len(manifest))
+	if len(manifest) != 117 {
+		t.Fatalf("manifest identity count = %d, want 117", len(manifest))
 	}
 	for factory := range pluginRegistry {
 		spec, ok := CapabilitySpecForFactory(factory)
@@ -155,6 +155,7 @@ type Config struct {
 	RetryDelay      int      `json:"retry_delay,omitempty"`
 	BufferDuration  int      `json:"buffer_duration,omitempty"`
 	InactiveTimeout int      `json:"inactive_timeout,omitempty"`
+	Spool           bool     `json:"spool,omitempty"`
 	preferNameSet   bool
 	retryDelaySet   bool
 }
@@ -173,6 +174,8 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		RetryDelay      *int     `json:"retry_delay,omitempty"`
 		BufferDuration  int      `json:"buffer_duration,omitempty"`
 		InactiveTimeout int      `json:"inactive_timeout,omitempty"`
+
+		Spool bool `json:"spool,omitempty"`
 	}
 
 	var decoded configJSON
@@ -198,6 +201,7 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 	}
 	c.BufferDuration = decoded.BufferDuration
 	c.InactiveTimeout = decoded.InactiveTimeout
+	c.Spool = decoded.Spool
 	return nil
 }
 
@@ -234,7 +238,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 	p.MetadataSchema = metadataSchema
 	return nil
 }
@@ -273,6 +277,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		PluginID:           name,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.deliver)
 	return nil
 }
@@ -77,7 +77,7 @@ func (p *Plugin) Init() error {
 }
 
 func (p *Plugin) PostInit() error {
-	multiplexCount, err := loadMultiplexCount()
+	multiplexCount, err := loadMultiplexCount(p.AppConfig())
 	if err != nil {
 		return err
 	}
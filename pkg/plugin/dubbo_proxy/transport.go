@@ -38,11 +38,11 @@ const (
 
 var nextDubboRequestID atomic.Uint64
 
-func loadMultiplexCount() (int, error) {
-	if appconfig.GlobalConfig == nil || appconfig.GlobalConfig.PluginAttr == nil {
+func loadMultiplexCount(cfg *appconfig.Config) (int, error) {
+	if cfg == nil || cfg.PluginAttr == nil {
 		return defaultMultiplexCount, nil
 	}
-	attr := appconfig.GlobalConfig.PluginAttr[name]
+	attr := cfg.PluginAttr[name]
 	if attr == nil {
 		return defaultMultiplexCount, nil
 	}
@@ -211,12 +211,13 @@ type Config struct {
 	MaxReqBodyBytes     int     `json:"max_req_body_bytes,omitempty"`
 	MaxRespBodyBytes    int     `json:"max_resp_body_bytes,omitempty"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 }
 
 type FieldConfig struct {
@@ -236,7 +237,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	p.InitLogger(p.Send)
 
@@ -313,6 +314,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 
 	// Version detection runs once per stable config at initialization, reusing
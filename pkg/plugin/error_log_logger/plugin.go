@@ -169,6 +169,7 @@ type Config struct {
 	BufferDuration    int    `json:"buffer_duration,omitempty"`
 	InactiveTimeout   int    `json:"inactive_timeout,omitempty"`
 	MaxPendingEntries int    `json:"max_pending_entries,omitempty"`
+	Spool             bool   `json:"spool,omitempty"`
 }
 
 type TCPConfig struct {
@@ -247,7 +248,7 @@ var levelOrder = map[string]int{
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	return nil
 }
@@ -273,6 +274,7 @@ func (p *Plugin) PostInit() error {
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
 		PluginID:           name,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, "", "", p.SendBatch)
 
 	return nil
@@ -0,0 +1,73 @@
+package expr
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var hashVariablePattern = regexp.MustCompile(`\$\{([^}]*)\}|\$([A-Za-z0-9_.]+)`)
+
+// HashValue resolves the APISIX chash key for one request. hashOn selects the
+// key source (vars, header, cookie, consumer, or vars_combinations); an empty
+// result lets the caller apply APISIX's remote_addr fallback.
+func HashValue(r *http.Request, hashOn string, key string) string {
+	switch hashOn {
+	case "header":
+		return r.Header.Get(key)
+	case "cookie":
+		cookie, err := r.Cookie(key)
+		if err == nil {
+			return cookie.Value
+		}
+		return ""
+	case "consumer":
+		return String(RequestValue(r, "consumer_name"))
+	case "vars_combinations":
+		return hashVariableCombination(r, key)
+	default:
+		return String(RequestValue(r, key))
+	}
+}
+
+func hashVariableCombination(r *http.Request, template string) string {
+	matches := hashVariablePattern.FindAllStringSubmatchIndex(template, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	var value strings.Builder
+	resolved := false
+	position := 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if start > 0 && template[start-1] == '\\' {
+			value.WriteString(template[position:end])
+			position = end
+			continue
+		}
+
+		value.WriteString(template[position:start])
+		variableStart, variableEnd := match[2], match[3]
+		if variableStart < 0 {
+			variableStart, variableEnd = match[4], match[5]
+		}
+		variable := strings.TrimSpace(template[variableStart:variableEnd])
+		name, fallback, hasFallback := strings.Cut(variable, "??")
+		name = strings.TrimSpace(name)
+		resolvedValue := String(RequestValue(r, name))
+		if resolvedValue == "" && hasFallback {
+			resolvedValue = strings.TrimSpace(fallback)
+		}
+		if resolvedValue != "" {
+			resolved = true
+		}
+		value.WriteString(resolvedValue)
+		position = end
+	}
+	value.WriteString(template[position:])
+	if !resolved {
+		return ""
+	}
+	return value.String()
+}
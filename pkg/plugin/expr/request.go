@@ -72,6 +72,25 @@ func RequestValue(r *http.Request, name string) any {
 	return ""
 }
 
+// BuiltinVariable reports whether RequestValue resolves name from the request
+// or the nginx and APISIX variable catalogs before any request variable, so a
+// request variable registered under that name would never be read.
+func BuiltinVariable(name string) bool {
+	name = strings.TrimPrefix(name, "$")
+	switch name {
+	case "is_args", "method", "remote_port":
+		return true
+	}
+	if strings.HasPrefix(name, "arg_") || strings.HasPrefix(name, "cookie_") || strings.HasPrefix(name, "http_") {
+		return true
+	}
+	if _, ok := apisixvar.NginxVars["$"+name]; ok {
+		return true
+	}
+	_, ok := apisixvar.ApisixVars["$"+name]
+	return ok
+}
+
 // SnapshotValue preserves RequestValue semantics after the live request has
 // been detached for log and finalizer callbacks.
 func SnapshotValue(snapshot base.LogSnapshot, name string) any {
@@ -0,0 +1,360 @@
+// Package ext_plugin implements ext-plugin-pre-req, ext-plugin-post-req, and
+// ext-plugin-post-resp, which hand requests and upstream responses to the
+// external plugin runner supervised by pkg/extplugin.
+package ext_plugin
+
+import (
+	"maps"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/extplugin"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+)
+
+const (
+	preReqName      = "ext-plugin-pre-req"
+	preReqPriority  = 12000
+	postReqName     = "ext-plugin-post-req"
+	postReqPriority = -3000
+	postRespName    = "ext-plugin-post-resp"
+	postRespPrio    = -4000
+
+	// respHeadersVarPrefix keys the response headers a Rewrite action asked
+	// for, per plugin, until the response header filter applies them.
+	respHeadersVarPrefix = "$ext_plugin_resp_headers."
+)
+
+const schema = `
+{
+  "type": "object",
+  "properties": {
+    "conf": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string", "minLength": 1, "maxLength": 128},
+          "value": {"type": "string"}
+        },
+        "required": ["name", "value"]
+      }
+    },
+    "allow_degradation": {"type": "boolean", "default": false}
+  }
+}
+`
+
+// excludedRespHeaders are response headers a runner cannot set, as in
+// APISIX: the gateway owns framing, redirects, and the representation.
+var excludedRespHeaders = []string{
+	"connection", "content-length", "transfer-encoding", "location", "server", "www-authenticate",
+	"content-encoding", "content-type", "content-location", "content-language",
+}
+
+type ConfEntry struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Config struct {
+	Conf             []ConfEntry `json:"conf,omitempty"`
+	AllowDegradation bool        `json:"allow_degradation,omitempty"`
+}
+
+// runnerClient is the configuration and runner shared by the request and
+// response plugins.
+type runnerClient struct {
+	config Config
+	runner *extplugin.Runner
+}
+
+// SetExtPluginRunner injects the runner owned by the server.
+func (c *runnerClient) SetExtPluginRunner(runner *extplugin.Runner) {
+	c.runner = runner
+}
+
+func (c *runnerClient) conf(name string) extplugin.Conf {
+	entries := make([]extplugin.TextEntry, len(c.config.Conf))
+	for i, entry := range c.config.Conf {
+		entries[i] = extplugin.TextEntry{Name: entry.Name, Value: entry.Value}
+	}
+	return extplugin.Conf{Key: name, Entries: entries}
+}
+
+// Plugin is ext-plugin-pre-req or ext-plugin-post-req; they differ only in
+// the request stage they run in.
+type Plugin struct {
+	base.BasePlugin
+	runnerClient
+}
+
+func NewPreReq() *Plugin {
+	return &Plugin{BasePlugin: base.BasePlugin{Name: preReqName, Priority: preReqPriority}}
+}
+
+func NewPostReq() *Plugin {
+	return &Plugin{BasePlugin: base.BasePlugin{Name: postReqName, Priority: postReqPriority}}
+}
+
+func (p *Plugin) Init() error {
+	if p.Name == "" {
+		p.Name = preReqName
+	}
+	if p.Priority == 0 {
+		p.Priority = preReqPriority
+	}
+	p.Schema = schema
+	return nil
+}
+
+func (p *Plugin) PostInit() error {
+	return nil
+}
+
+func (p *Plugin) Config() any {
+	return &p.config
+}
+
+func (p *Plugin) Handler(next http.Handler) http.Handler {
+	return base.AdaptRequestPhase(p, next)
+}
+
+// RunRequestPhase sends the request to the runner and applies its Stop or
+// Rewrite action. A runner failure answers 503 unless allow_degradation lets
+// the request continue untouched.
+func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.RequestPhaseResult {
+	result, err := p.runner.HTTPReqCall(r.Context(), p.conf(p.Name), runnerRequest(r), requestExtraInfo(r))
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		if p.config.AllowDegradation {
+			return base.ContinueRequest(r)
+		}
+		apisixctx.SetRequestResponseSource(r, apisixctx.ResponseSourceEarlyStop)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return base.StopRequestWithSource(r, apisixctx.ResponseSourceEarlyStop)
+	}
+	if stop := result.Stop; stop != nil {
+		apisixctx.SetRequestResponseSource(r, apisixctx.ResponseSourceEarlyStop)
+		applyTextEntries(w.Header(), stop.Headers, nil)
+		status := stop.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(stop.Body)
+		return base.StopRequestWithSource(r, apisixctx.ResponseSourceEarlyStop)
+	}
+	if rewrite := result.Rewrite; rewrite != nil {
+		applyRewrite(r, rewrite)
+		if len(rewrite.RespHeaders) > 0 {
+			apisixctx.RegisterRequestVar(r, respHeadersVarPrefix+p.Name, rewrite.RespHeaders)
+		}
+	}
+	return base.ContinueRequest(r)
+}
+
+// RunStreamingHeaderFilter applies the response headers of a Rewrite action.
+func (p *Plugin) RunStreamingHeaderFilter(r *http.Request, state *base.StreamingResponseState) error {
+	if state == nil || r == nil {
+		return nil
+	}
+	headers, _ := apisixctx.GetRequestVar(r, respHeadersVarPrefix+p.Name).([]extplugin.TextEntry)
+	if len(headers) == 0 {
+		return nil
+	}
+	if state.Header == nil {
+		state.Header = make(http.Header)
+	}
+	applyTextEntries(state.Header, headers, excludedRespHeaders)
+	return nil
+}
+
+// RespPlugin is ext-plugin-post-resp. It hands the buffered upstream response
+// to the runner, which may replace its status, headers, and body.
+type RespPlugin struct {
+	base.BasePlugin
+	runnerClient
+}
+
+func NewPostResp() *RespPlugin {
+	return &RespPlugin{BasePlugin: base.BasePlugin{Name: postRespName, Priority: postRespPrio}}
+}
+
+func (p *RespPlugin) Init() error {
+	p.Name = postRespName
+	p.Priority = postRespPrio
+	p.Schema = schema
+	return nil
+}
+
+func (p *RespPlugin) PostInit() error {
+	return nil
+}
+
+func (p *RespPlugin) Config() any {
+	return &p.config
+}
+
+func (p *RespPlugin) Handler(next http.Handler) http.Handler {
+	return next
+}
+
+// RunBufferedBodyFilter sends the upstream status and headers to the runner
+// and serves the body on request. A zero status keeps the upstream status and
+// an empty body keeps the upstream body.
+func (p *RespPlugin) RunBufferedBodyFilter(r *http.Request, state *base.ResponseState) error {
+	if state == nil || !p.AppliesToResponseSource(responseSource(r)) {
+		return nil
+	}
+	requestInfo := requestExtraInfo(r)
+	body := state.Body
+	result, err := p.runner.HTTPRespCall(
+		r.Context(),
+		p.conf(p.Name),
+		extplugin.HTTPResponse{Status: state.Status, Headers: headerEntries(state.Header, "")},
+		func(info extplugin.ExtraInfo) ([]byte, error) {
+			if info.Kind == extplugin.ExtraInfoRespBody {
+				return body, nil
+			}
+			return requestInfo(info)
+		},
+	)
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		if p.config.AllowDegradation {
+			return nil
+		}
+		*state = base.ResponseState{Status: http.StatusServiceUnavailable, Header: make(http.Header)}
+		return nil
+	}
+	if result.Status != 0 {
+		state.Status = result.Status
+	}
+	if state.Header == nil {
+		state.Header = make(http.Header)
+	}
+	applyTextEntries(state.Header, result.Headers, excludedRespHeaders)
+	if len(result.Body) > 0 {
+		state.Body = result.Body
+		base.InvalidateBodyDerivedHeaders(state.Header)
+	}
+	return nil
+}
+
+// AppliesToResponseSource limits the runner to proxied responses, as APISIX
+// only calls it with the upstream response.
+func (p *RespPlugin) AppliesToResponseSource(source apisixctx.ResponseSource) bool {
+	return source == apisixctx.ResponseSourceUpstream
+}
+
+func responseSource(r *http.Request) apisixctx.ResponseSource {
+	if lifecycle := apisixctx.GetRequestLifecycle(r); lifecycle != nil {
+		return lifecycle.ResponseSource()
+	}
+	if source, _ := apisixctx.GetRequestVar(r, "$response_source").(string); source != "" {
+		return apisixctx.ResponseSource(source)
+	}
+	return apisixctx.ResponseSourceUnknown
+}
+
+func runnerRequest(r *http.Request) extplugin.HTTPRequest {
+	srcIP, _ := netip.ParseAddr(apisixctx.EffectiveRemoteIP(r))
+	query := r.URL.Query()
+	args := make([]extplugin.TextEntry, 0, len(query))
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		for _, value := range query[name] {
+			args = append(args, extplugin.TextEntry{Name: name, Value: value})
+		}
+	}
+	return extplugin.HTTPRequest{
+		SrcIP:   srcIP,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Args:    args,
+		Headers: headerEntries(r.Header, r.Host),
+	}
+}
+
+// headerEntries flattens header in name order; host, when set, is sent as
+// the Host header Go keeps outside the map.
+func headerEntries(header http.Header, host string) []extplugin.TextEntry {
+	entries := make([]extplugin.TextEntry, 0, len(header)+1)
+	if host != "" && header.Get("Host") == "" {
+		entries = append(entries, extplugin.TextEntry{Name: "Host", Value: host})
+	}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[name] {
+			entries = append(entries, extplugin.TextEntry{Name: name, Value: value})
+		}
+	}
+	return entries
+}
+
+// requestExtraInfo answers runner requests for variables and the request
+// body.
+func requestExtraInfo(r *http.Request) extplugin.ExtraInfoFunc {
+	return func(info extplugin.ExtraInfo) ([]byte, error) {
+		switch info.Kind {
+		case extplugin.ExtraInfoVar:
+			return []byte(base.RequestVarFromNginx(r, info.Name)), nil
+		case extplugin.ExtraInfoReqBody:
+			return base.ReadRequestBodyLimited(r, base.DefaultRequestBodyMaxBytes)
+		default:
+			return nil, nil
+		}
+	}
+}
+
+func applyRewrite(r *http.Request, rewrite *extplugin.RewriteAction) {
+	if rewrite.HasPath {
+		r.URL.Path = rewrite.Path
+		r.URL.RawPath = ""
+	}
+	for _, entry := range rewrite.Headers {
+		if strings.EqualFold(entry.Name, "Host") {
+			if entry.Remove {
+				r.Host = ""
+			} else {
+				r.Host = entry.Value
+			}
+			continue
+		}
+		if entry.Remove {
+			r.Header.Del(entry.Name)
+			continue
+		}
+		r.Header.Set(entry.Name, entry.Value)
+	}
+	if len(rewrite.Args) == 0 {
+		return
+	}
+	query := r.URL.Query()
+	for _, entry := range rewrite.Args {
+		if entry.Remove {
+			query.Del(entry.Name)
+			continue
+		}
+		query.Set(entry.Name, entry.Value)
+	}
+	r.URL.RawQuery = query.Encode()
+}
+
+// applyTextEntries sets or removes each named header, skipping excluded
+// names.
+func applyTextEntries(header http.Header, entries []extplugin.TextEntry, excluded []string) {
+	for _, entry := range entries {
+		if slices.Contains(excluded, strings.ToLower(entry.Name)) {
+			continue
+		}
+		if entry.Remove {
+			header.Del(entry.Name)
+			continue
+		}
+		header.Set(entry.Name, entry.Value)
+	}
+}
@@ -0,0 +1,340 @@
+package ext_plugin
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/extplugin"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+)
+
+// Frame types and union discriminators of the A6 protocol.
+const (
+	typePrepareConf  = 1
+	typeHTTPReqCall  = 2
+	typeHTTPRespCall = 4
+
+	actionStop    = 1
+	actionRewrite = 2
+)
+
+type textEntry struct {
+	name, value string
+}
+
+// startRunner serves a runner that prepares any conf and answers every
+// HTTPReqCall with reqReply and every HTTPRespCall with respReply.
+func startRunner(t *testing.T, reqReply, respReply []byte) *extplugin.Runner {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "runner.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveRunnerConn(conn, reqReply, respReply)
+		}
+	}()
+	runner, err := extplugin.New(extplugin.Options{PathForTest: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(runner.Close)
+	return runner
+}
+
+func serveRunnerConn(conn net.Conn, reqReply, respReply []byte) {
+	defer conn.Close()
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if _, err := io.CopyN(io.Discard, conn, int64(size)); err != nil {
+			return
+		}
+		var reply []byte
+		switch header[0] {
+		case typePrepareConf:
+			b := flatbuffers.NewBuilder(32)
+			b.StartObject(1)
+			b.PrependUint32Slot(0, 1, 0)
+			b.Finish(b.EndObject())
+			reply = b.FinishedBytes()
+		case typeHTTPReqCall:
+			reply = reqReply
+		case typeHTTPRespCall:
+			reply = respReply
+		}
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(reply)))
+		frame[0] = header[0]
+		if _, err := conn.Write(append(frame, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+func buildEntries(b *flatbuffers.Builder, entries []textEntry) flatbuffers.UOffsetT {
+	offsets := make([]flatbuffers.UOffsetT, len(entries))
+	for i, entry := range entries {
+		name := b.CreateString(entry.name)
+		value := b.CreateString(entry.value)
+		b.StartObject(2)
+		b.PrependUOffsetTSlot(0, name, 0)
+		b.PrependUOffsetTSlot(1, value, 0)
+		offsets[i] = b.EndObject()
+	}
+	b.StartVector(flatbuffers.SizeUOffsetT, len(offsets), flatbuffers.SizeUOffsetT)
+	for i := len(offsets) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(offsets[i])
+	}
+	return b.EndVector(len(offsets))
+}
+
+func stopReply(status uint16, headers []textEntry, body string) []byte {
+	b := flatbuffers.NewBuilder(256)
+	headerVector := buildEntries(b, headers)
+	bodyVector := b.CreateByteVector([]byte(body))
+	b.StartObject(3)
+	b.PrependUint16Slot(0, status, 0)
+	b.PrependUOffsetTSlot(1, headerVector, 0)
+	b.PrependUOffsetTSlot(2, bodyVector, 0)
+	stop := b.EndObject()
+	b.StartObject(3)
+	b.PrependByteSlot(1, actionStop, 0)
+	b.PrependUOffsetTSlot(2, stop, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func rewriteReply(path string, headers, args, respHeaders []textEntry) []byte {
+	b := flatbuffers.NewBuilder(256)
+	pathString := b.CreateString(path)
+	headerVector := buildEntries(b, headers)
+	argVector := buildEntries(b, args)
+	respHeaderVector := buildEntries(b, respHeaders)
+	b.StartObject(4)
+	b.PrependUOffsetTSlot(0, pathString, 0)
+	b.PrependUOffsetTSlot(1, headerVector, 0)
+	b.PrependUOffsetTSlot(2, argVector, 0)
+	b.PrependUOffsetTSlot(3, respHeaderVector, 0)
+	rewrite := b.EndObject()
+	b.StartObject(3)
+	b.PrependByteSlot(1, actionRewrite, 0)
+	b.PrependUOffsetTSlot(2, rewrite, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func respReply(status uint16, headers []textEntry, body string) []byte {
+	b := flatbuffers.NewBuilder(256)
+	headerVector := buildEntries(b, headers)
+	var bodyVector flatbuffers.UOffsetT
+	if body != "" {
+		bodyVector = b.CreateByteVector([]byte(body))
+	}
+	b.StartObject(4)
+	b.PrependUint16Slot(1, status, 0)
+	b.PrependUOffsetTSlot(2, headerVector, 0)
+	if body != "" {
+		b.PrependUOffsetTSlot(3, bodyVector, 0)
+	}
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func newRequestPlugin(t *testing.T, runner *extplugin.Runner, cfg Config) *Plugin {
+	t.Helper()
+	p := NewPreReq()
+	p.config = cfg
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	p.SetExtPluginRunner(runner)
+	return p
+}
+
+func TestRequestPluginStopWritesRunnerResponse(t *testing.T) {
+	runner := startRunner(t, stopReply(403, []textEntry{{"X-Reason", "denied"}}, "blocked"), nil)
+	p := newRequestPlugin(t, runner, Config{Conf: []ConfEntry{{Name: "rule", Value: "deny"}}})
+
+	called := false
+	handler := p.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+	recorder := httptest.NewRecorder()
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/hello", nil))
+	handler.ServeHTTP(recorder, req)
+
+	if called {
+		t.Fatal("next handler ran after a Stop action")
+	}
+	if recorder.Code != http.StatusForbidden || recorder.Body.String() != "blocked" {
+		t.Fatalf("response = %d %q, want 403 blocked", recorder.Code, recorder.Body.String())
+	}
+	if got := recorder.Header().Get("X-Reason"); got != "denied" {
+		t.Fatalf("X-Reason = %q, want denied", got)
+	}
+}
+
+func TestRequestPluginRewriteAppliesRequestAndResponseHeaders(t *testing.T) {
+	reply := rewriteReply(
+		"/rewritten",
+		[]textEntry{{"X-Runner", "yes"}, {"Host", "backend.example"}},
+		[]textEntry{{"added", "1"}},
+		[]textEntry{{"X-Resp", "from-runner"}, {"Content-Type", "text/evil"}},
+	)
+	p := newRequestPlugin(t, startRunner(t, reply, nil), Config{})
+
+	var seen *http.Request
+	handler := p.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { seen = r }))
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/hello?kept=1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen == nil {
+		t.Fatal("next handler did not run after a Rewrite action")
+	}
+	if seen.URL.Path != "/rewritten" || seen.Host != "backend.example" || seen.Header.Get("X-Runner") != "yes" {
+		t.Fatalf("rewritten request = %s %s %v", seen.Host, seen.URL.Path, seen.Header)
+	}
+	if seen.URL.RawQuery != "added=1&kept=1" {
+		t.Fatalf("query = %q, want added=1&kept=1", seen.URL.RawQuery)
+	}
+
+	state := &base.StreamingResponseState{Header: http.Header{"Content-Type": {"text/plain"}}}
+	if err := p.RunStreamingHeaderFilter(seen, state); err != nil {
+		t.Fatalf("RunStreamingHeaderFilter() error = %v", err)
+	}
+	if got := state.Header.Get("X-Resp"); got != "from-runner" {
+		t.Fatalf("X-Resp = %q, want from-runner", got)
+	}
+	if got := state.Header.Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("Content-Type = %q, want the excluded header untouched", got)
+	}
+}
+
+func TestRequestPluginRunnerFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		degradation bool
+		wantStatus  int
+		wantNext    bool
+	}{
+		{name: "fails closed", wantStatus: http.StatusServiceUnavailable},
+		{name: "allow degradation", degradation: true, wantStatus: http.StatusOK, wantNext: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newRequestPlugin(t, nil, Config{AllowDegradation: tc.degradation})
+			called := false
+			handler := p.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "/", nil)))
+			if recorder.Code != tc.wantStatus || called != tc.wantNext {
+				t.Fatalf("status = %d next = %v, want %d %v", recorder.Code, called, tc.wantStatus, tc.wantNext)
+			}
+		})
+	}
+}
+
+func newUpstreamRequest() *http.Request {
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/hello", nil))
+	apisixctx.SetRequestResponseSource(req, apisixctx.ResponseSourceUpstream)
+	return req
+}
+
+func TestRespPluginReplacesUpstreamResponse(t *testing.T) {
+	reply := respReply(201, []textEntry{{"X-Resp", "runner"}, {"Server", "runner"}}, "replaced")
+	p := NewPostResp()
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	p.SetExtPluginRunner(startRunner(t, nil, reply))
+
+	state := &base.ResponseState{
+		Status: http.StatusOK,
+		Header: http.Header{"Content-Length": {"8"}, "Server": {"upstream"}},
+		Body:   []byte("original"),
+	}
+	if err := p.RunBufferedBodyFilter(newUpstreamRequest(), state); err != nil {
+		t.Fatalf("RunBufferedBodyFilter() error = %v", err)
+	}
+	if state.Status != http.StatusCreated || string(state.Body) != "replaced" {
+		t.Fatalf("response = %d %q, want 201 replaced", state.Status, state.Body)
+	}
+	if state.Header.Get("X-Resp") != "runner" || state.Header.Get("Server") != "upstream" {
+		t.Fatalf("headers = %v, want X-Resp set and Server kept", state.Header)
+	}
+	if state.Header.Get("Content-Length") != "" {
+		t.Fatalf("Content-Length = %q, want it dropped with the replaced body", state.Header.Get("Content-Length"))
+	}
+}
+
+func TestRespPluginKeepsResponseWhenRunnerLeavesItUnchanged(t *testing.T) {
+	p := NewPostResp()
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	p.SetExtPluginRunner(startRunner(t, nil, respReply(0, nil, "")))
+
+	state := &base.ResponseState{Status: http.StatusOK, Header: http.Header{}, Body: []byte("original")}
+	if err := p.RunBufferedBodyFilter(newUpstreamRequest(), state); err != nil {
+		t.Fatalf("RunBufferedBodyFilter() error = %v", err)
+	}
+	if state.Status != http.StatusOK || string(state.Body) != "original" {
+		t.Fatalf("response = %d %q, want the upstream response", state.Status, state.Body)
+	}
+}
+
+func TestRespPluginRunnerFailure(t *testing.T) {
+	p := NewPostResp()
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	state := &base.ResponseState{Status: http.StatusOK, Header: http.Header{}, Body: []byte("original")}
+	if err := p.RunBufferedBodyFilter(newUpstreamRequest(), state); err != nil {
+		t.Fatalf("RunBufferedBodyFilter() error = %v", err)
+	}
+	if state.Status != http.StatusServiceUnavailable || len(state.Body) != 0 {
+		t.Fatalf("response = %d %q, want an empty 503", state.Status, state.Body)
+	}
+
+	p.config.AllowDegradation = true
+	state = &base.ResponseState{Status: http.StatusOK, Header: http.Header{}, Body: []byte("original")}
+	if err := p.RunBufferedBodyFilter(newUpstreamRequest(), state); err != nil {
+		t.Fatalf("RunBufferedBodyFilter() error = %v", err)
+	}
+	if state.Status != http.StatusOK || string(state.Body) != "original" {
+		t.Fatalf("degraded response = %d %q, want the upstream response", state.Status, state.Body)
+	}
+}
+
+func TestRespPluginSkipsGatewayResponses(t *testing.T) {
+	p := NewPostResp()
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "/", nil))
+	apisixctx.SetRequestResponseSource(req, apisixctx.ResponseSourceAPISIX)
+	state := &base.ResponseState{Status: http.StatusNotFound, Header: http.Header{}}
+	if err := p.RunBufferedBodyFilter(req, state); err != nil {
+		t.Fatalf("RunBufferedBodyFilter() error = %v", err)
+	}
+	if state.Status != http.StatusNotFound {
+		t.Fatalf("status = %d, want the gateway response untouched", state.Status)
+	}
+}
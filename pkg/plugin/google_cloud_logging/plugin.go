@@ -234,12 +234,13 @@ type Config struct {
 	LogID      string            `json:"log_id,omitempty"`
 	LogFormat  map[string]string `json:"log_format,omitempty"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 }
 
 type googleLogEntry struct {
@@ -300,7 +301,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 	p.MetadataSchema = metadataSchema
 
 	p.InitLogger(p.Send)
@@ -473,6 +474,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 	return nil
 }
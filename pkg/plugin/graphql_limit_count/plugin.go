@@ -15,7 +15,6 @@ import (
 	"github.com/redis/go-redis/v9"
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/apisix/variable"
-	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
 	"github.com/wklken/apisix-go/pkg/plugin/cacheutil"
@@ -420,8 +419,8 @@ func (p *Plugin) PostInit() error {
 		p.now = time.Now
 	}
 	p.maxSize = 1048576
-	if config.GlobalConfig != nil && config.GlobalConfig.GraphQL.MaxSize > 0 {
-		p.maxSize = config.GlobalConfig.GraphQL.MaxSize
+	if cfg := p.AppConfig(); cfg != nil && cfg.GraphQL.MaxSize > 0 {
+		p.maxSize = cfg.GraphQL.MaxSize
 	}
 	if p.metadata == (Metadata{}) {
 		p.metadata = base.LoadPluginMetadata[Metadata]("limit-count")
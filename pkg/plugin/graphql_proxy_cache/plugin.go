@@ -14,7 +14,6 @@ import (
 	"time"
 
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
-	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
 	"github.com/wklken/apisix-go/pkg/plugin/cacheutil"
@@ -149,7 +148,8 @@ func (p *Plugin) PostInit() error {
 		value := true
 		p.config.ConsumerIsolation = &value
 	}
-	if err := proxy_cache.ValidateCacheZoneStrategy(p.config.CacheZone, p.config.CacheStrategy); err != nil {
+	cfg := p.AppConfig()
+	if err := proxy_cache.ValidateCacheZoneStrategy(cfg, p.config.CacheZone, p.config.CacheStrategy); err != nil {
 		return err
 	}
 	p.configFingerprintValue = p.buildConfigFingerprint()
@@ -159,14 +159,14 @@ func (p *Plugin) PostInit() error {
 		p.now = time.Now
 	}
 	p.maxSize = defaultMaxSize
-	if config.GlobalConfig != nil && config.GlobalConfig.GraphQL.MaxSize > 0 {
-		p.maxSize = config.GlobalConfig.GraphQL.MaxSize
+	if cfg != nil && cfg.GraphQL.MaxSize > 0 {
+		p.maxSize = cfg.GraphQL.MaxSize
 	}
-	if p.config.CacheStrategy == "memory" && proxy_cache.CacheZoneDeclared(p.config.CacheZone) {
-		p.memoryStore = proxy_cache.AcquireMemoryZoneStore(p.config.CacheZone)
+	if p.config.CacheStrategy == "memory" && proxy_cache.CacheZoneDeclared(cfg, p.config.CacheZone) {
+		p.memoryStore = proxy_cache.AcquireMemoryZoneStore(cfg, p.config.CacheZone)
 	}
 	if p.config.CacheStrategy == "disk" {
-		store, configured, err := proxy_cache.NewDiskZoneStore(p.config.CacheZone)
+		store, configured, err := proxy_cache.NewDiskZoneStore(cfg, p.config.CacheZone)
 		if err != nil {
 			return err
 		}
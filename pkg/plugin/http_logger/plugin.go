@@ -167,12 +167,13 @@ type Config struct {
 	// NOTE: not needed
 	ConcatMethod string `json:"concat_method"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 
 	retryDelaySet bool
 }
@@ -210,7 +211,7 @@ func (p *Plugin) SetResourceContext(route resource.Route, _ resource.Service) {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 	p.MetadataSchema = metadataSchema
 
 	p.InitLogger(p.Send)
@@ -334,6 +335,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 
 	return nil
@@ -974,3 +974,30 @@ func TestSchemaAcceptsOfficialBatchFields(t *testing.T) {
 		t.Fatalf("schema rejected official batch fields: %v", err)
 	}
 }
+
+func TestSchemaAcceptsCommonSpoolFlag(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	config := map[string]any{"uri": "http://127.0.0.1/logs", "spool": true}
+	if err := util.Validate(config, p.GetSchema()); err != nil {
+		t.Fatalf("schema rejected spool flag: %v", err)
+	}
+	config["spool"] = map[string]any{"dir": "/tmp"}
+	if err := util.Validate(config, p.GetSchema()); err == nil {
+		t.Fatal("schema accepted a route-level spool directory")
+	}
+	if err := util.Validate(map[string]any{"spool": true}, p.GetSchema()); err == nil {
+		t.Fatal("schema with spool flag no longer requires uri")
+	}
+
+	var decoded Config
+	if err := json.Unmarshal([]byte(`{"uri":"http://127.0.0.1/logs","spool":true}`), &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !decoded.Spool {
+		t.Fatal("spool flag was not decoded")
+	}
+}
@@ -47,6 +47,7 @@ import (
 	"github.com/wklken/apisix-go/pkg/plugin/error_page"
 	"github.com/wklken/apisix-go/pkg/plugin/example_plugin"
 	"github.com/wklken/apisix-go/pkg/plugin/exit_transformer"
+	"github.com/wklken/apisix-go/pkg/plugin/ext_plugin"
 	"github.com/wklken/apisix-go/pkg/plugin/fault_injection"
 	"github.com/wklken/apisix-go/pkg/plugin/feishu_auth"
 	"github.com/wklken/apisix-go/pkg/plugin/file_logger"
@@ -180,6 +181,9 @@ var pluginRegistry = map[string]func() Plugin{
 	"server-info":                  func() Plugin { return &server_info.Plugin{} },
 	"serverless-pre-function":      func() Plugin { return serverless.NewPreFunction() },
 	"serverless-post-function":     func() Plugin { return serverless.NewPostFunction() },
+	"ext-plugin-pre-req":           func() Plugin { return ext_plugin.NewPreReq() },
+	"ext-plugin-post-req":          func() Plugin { return ext_plugin.NewPostReq() },
+	"ext-plugin-post-resp":         func() Plugin { return ext_plugin.NewPostResp() },
 	"opentelemetry":                func() Plugin { return &otel.Plugin{} },
 	"prometheus":                   func() Plugin { return &prometheus.Plugin{} },
 	"client-control":               func() Plugin { return &client_control.Plugin{} },
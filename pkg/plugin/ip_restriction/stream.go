@@ -0,0 +1,17 @@
+package ip_restriction
+
+import "net"
+
+// AllowStream reports whether the stream client at remoteAddr, a host or
+// host:port, passes the configured whitelist or blacklist. A stream has no
+// response, so a rejected client is simply disconnected by the caller.
+func (p *Plugin) AllowStream(remoteAddr string) bool {
+	if p.filter == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return p.filter.Allowed(host)
+}
@@ -0,0 +1,18 @@
+package ip_restriction
+
+import "testing"
+
+func TestAllowStreamMatchesPeerHost(t *testing.T) {
+	p := newTestPlugin(t, Config{Whitelist: []string{"10.0.0.0/8", "::1"}})
+	for remote, want := range map[string]bool{
+		"10.1.2.3:5353":  true,
+		"[::1]:53":       true,
+		"10.1.2.3":       true,
+		"192.0.2.1:5353": false,
+		"":               false,
+	} {
+		if got := p.AllowStream(remote); got != want {
+			t.Fatalf("AllowStream(%q) = %v, want %v", remote, got, want)
+		}
+	}
+}
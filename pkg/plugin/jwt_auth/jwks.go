@@ -0,0 +1,249 @@
+package jwt_auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	defaultJWKSCacheTTL           = 300
+	defaultJWKSMinRefreshInterval = 10
+	defaultJWKSTimeout            = 3
+	defaultJWKSConsumerClaim      = "sub"
+
+	// jwksMaxBodySize bounds a JWKS response; real key sets are a few KB.
+	jwksMaxBodySize = 1 << 20
+)
+
+var defaultJWKSAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// JWKSConfig verifies tokens against the keys an identity provider publishes
+// instead of keys stored on consumers.
+type JWKSConfig struct {
+	URI                 string   `json:"uri"`
+	Algorithms          []string `json:"algorithms,omitempty"`
+	Issuer              string   `json:"issuer,omitempty"`
+	Audience            []string `json:"audience,omitempty"`
+	ConsumerClaim       string   `json:"consumer_claim,omitempty"`
+	SyntheticConsumer   *bool    `json:"synthetic_consumer,omitempty"`
+	LabelClaims         []string `json:"label_claims,omitempty"`
+	LifetimeGracePeriod int64    `json:"lifetime_grace_period,omitempty"`
+	CacheTTL            int      `json:"cache_ttl,omitempty"`
+	MinRefreshInterval  int      `json:"min_refresh_interval,omitempty"`
+	Timeout             int      `json:"timeout,omitempty"`
+	SSLVerify           *bool    `json:"ssl_verify,omitempty"`
+}
+
+func (c *JWKSConfig) setDefaults() {
+	if len(c.Algorithms) == 0 {
+		c.Algorithms = defaultJWKSAlgorithms
+	}
+	if c.ConsumerClaim == "" {
+		c.ConsumerClaim = defaultJWKSConsumerClaim
+	}
+	if c.SyntheticConsumer == nil {
+		b := false
+		c.SyntheticConsumer = &b
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = defaultJWKSCacheTTL
+	}
+	if c.MinRefreshInterval == 0 {
+		c.MinRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultJWKSTimeout
+	}
+	if c.SSLVerify == nil {
+		b := true
+		c.SSLVerify = &b
+	}
+}
+
+// jwksKeySet caches the keys of a JWKS endpoint. The set is refetched once it
+// is older than ttl, or when a token names a key it does not hold, but never
+// more often than minRefresh, so tokens with made-up kids cannot turn into a
+// flood of requests against the identity provider. A failed fetch keeps the
+// keys from the last good one.
+type jwksKeySet struct {
+	uri        string
+	client     *http.Client
+	ttl        time.Duration
+	minRefresh time.Duration
+	now        func() time.Time
+
+	// refreshMu serializes fetches; mu guards the fields below it.
+	refreshMu   sync.Mutex
+	mu          sync.RWMutex
+	keys        []jose.JSONWebKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func newJWKSKeySet(config JWKSConfig, now func() time.Time) *jwksKeySet {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: !*config.SSLVerify}
+	return &jwksKeySet{
+		uri: config.URI,
+		client: &http.Client{
+			Timeout:   time.Duration(config.Timeout) * time.Second,
+			Transport: transport,
+		},
+		ttl:        time.Duration(config.CacheTTL) * time.Second,
+		minRefresh: time.Duration(config.MinRefreshInterval) * time.Second,
+		now:        now,
+	}
+}
+
+// verificationKey returns the keys that may have signed a token with the
+// given kid and algorithm, refreshing the set when it is stale or holds none.
+func (s *jwksKeySet) verificationKey(kid string, algorithm string) (any, error) {
+	s.mu.RLock()
+	keys := s.lookup(kid, algorithm)
+	fresh := !s.fetchedAt.IsZero() && s.now().Sub(s.fetchedAt) < s.ttl
+	s.mu.RUnlock()
+
+	if len(keys) == 0 || !fresh {
+		s.refresh()
+		s.mu.RLock()
+		keys = s.lookup(kid, algorithm)
+		s.mu.RUnlock()
+	}
+
+	switch len(keys) {
+	case 0:
+		return nil, fmt.Errorf("no JWKS key with kid %q for %s", kid, algorithm)
+	case 1:
+		return keys[0], nil
+	default:
+		// A token without a kid is tried against every key that fits.
+		return jwt.VerificationKeySet{Keys: keys}, nil
+	}
+}
+
+func (s *jwksKeySet) lookup(kid string, algorithm string) []jwt.VerificationKey {
+	var keys []jwt.VerificationKey
+	for _, key := range s.keys {
+		if kid != "" && key.KeyID != kid {
+			continue
+		}
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != algorithm {
+			continue
+		}
+		if !jwksKeyFitsAlgorithm(key.Key, algorithm) {
+			continue
+		}
+		keys = append(keys, key.Key)
+	}
+	return keys
+}
+
+func (s *jwksKeySet) refresh() {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	now := s.now()
+	s.mu.RLock()
+	attemptedAt := s.attemptedAt
+	s.mu.RUnlock()
+	// Also covers a concurrent caller that refreshed while this one waited.
+	if !attemptedAt.IsZero() && now.Sub(attemptedAt) < s.minRefresh {
+		return
+	}
+
+	keys, err := s.fetch()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = now
+	if err != nil {
+		logger.Warnf("failed to fetch jwt-auth JWKS from %s, err: %v", s.uri, err)
+		return
+	}
+	s.keys = keys
+	s.fetchedAt = now
+}
+
+func (s *jwksKeySet) fetch() ([]jose.JSONWebKey, error) {
+	resp, err := s.client.Get(s.uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, jwksMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(body)
+}
+
+// parseJWKS returns the public signing keys of a JWKS document. Keys that do
+// not parse, or are symmetric, are skipped rather than failing the whole set,
+// so one unsupported key does not lock every token out.
+func parseJWKS(body []byte) ([]jose.JSONWebKey, error) {
+	var document struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make([]jose.JSONWebKey, 0, len(document.Keys))
+	for _, raw := range document.Keys {
+		var key jose.JSONWebKey
+		if err := key.UnmarshalJSON(raw); err != nil {
+			continue
+		}
+		public := key.Public()
+		if !public.Valid() {
+			continue
+		}
+		keys = append(keys, public)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable public keys")
+	}
+	return keys, nil
+}
+
+func jwksKeyFitsAlgorithm(key any, algorithm string) bool {
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case strings.HasPrefix(algorithm, "ES"):
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	case algorithm == "EdDSA":
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
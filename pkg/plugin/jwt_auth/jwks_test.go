@@ -0,0 +1,333 @@
+package jwt_auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+type jwksTestServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetches atomic.Int32
+}
+
+func newJWKSTestServer(t *testing.T, keys ...jose.JSONWebKey) *jwksTestServer {
+	t.Helper()
+
+	server := &jwksTestServer{}
+	server.setKeys(keys...)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.fetches.Add(1)
+		server.mu.Lock()
+		body, err := json.Marshal(server.keys)
+		server.mu.Unlock()
+		if err != nil {
+			t.Errorf("marshal JWKS: %v", err)
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *jwksTestServer) setKeys(keys ...jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = jose.JSONWebKeySet{Keys: keys}
+}
+
+func newRSAJWK(t *testing.T, kid string) (*rsa.PrivateKey, jose.JSONWebKey) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return privateKey, jose.JSONWebKey{Key: &privateKey.PublicKey, KeyID: kid, Use: "sig"}
+}
+
+func signWithKid(t *testing.T, algorithm string, kid string, key any, payload map[string]any) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), jwt.MapClaims(payload))
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign %s token: %v", algorithm, err)
+	}
+	return raw
+}
+
+// newJWKSTestPlugin builds a plugin whose clock the test advances, so cache
+// expiry and refresh rate limiting can be checked without sleeping.
+func newJWKSTestPlugin(t *testing.T, jwks JWKSConfig, now *time.Time) *Plugin {
+	t.Helper()
+
+	p := &Plugin{config: Config{JWKS: &jwks}, now: func() time.Time { return *now }}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := p.PostInit(); err != nil {
+		t.Fatalf("PostInit() error = %v", err)
+	}
+	return p
+}
+
+func TestHandlerVerifiesJWKSTokenAndMapsClaimOntoConsumer(t *testing.T) {
+	addConsumer(t, "jwks-user")
+	privateKey, jwk := newRSAJWK(t, "key-1")
+	server := newJWKSTestServer(t, jwk)
+	now := time.Now()
+	p := newJWKSTestPlugin(t, JWKSConfig{URI: server.URL}, &now)
+
+	token := signWithKid(t, "RS256", "key-1", privateKey, map[string]any{
+		"sub": "jwks-user",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	res := performRequest(p.Handler(assertConsumer(t, "jwks-user")), token)
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d; body=%s", res.Code, http.StatusNoContent, res.Body.String())
+	}
+
+	unknown := signWithKid(t, "RS256", "key-1", privateKey, map[string]any{
+		"sub": "jwks-nobody",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	res = performRequest(p.Handler(assertConsumer(t, "jwks-nobody")), unknown)
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown consumer response code = %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandlerRefreshesJWKSOnUnknownKidWithRateLimit(t *testing.T) {
+	addConsumer(t, "jwks-rotated-user")
+	oldKey, oldJWK := newRSAJWK(t, "old")
+	newKey, newJWK := newRSAJWK(t, "new")
+	server := newJWKSTestServer(t, oldJWK)
+	now := time.Now()
+	p := newJWKSTestPlugin(t, JWKSConfig{URI: server.URL, MinRefreshInterval: 30}, &now)
+	handler := p.Handler(assertConsumer(t, "jwks-rotated-user"))
+	sign := func(kid string, key *rsa.PrivateKey) string {
+		return signWithKid(t, "RS256", kid, key, map[string]any{
+			"sub": "jwks-rotated-user",
+			"exp": now.Add(time.Hour).Unix(),
+		})
+	}
+
+	if res := performRequest(handler, sign("old", oldKey)); res.Code != http.StatusNoContent {
+		t.Fatalf("old key response code = %d, want %d", res.Code, http.StatusNoContent)
+	}
+	server.setKeys(oldJWK, newJWK)
+	for range 5 {
+		if res := performRequest(handler, sign("new", newKey)); res.Code != http.StatusUnauthorized {
+			t.Fatalf("rotated key response code = %d, want rejected until a refresh is allowed", res.Code)
+		}
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Fatalf("JWKS fetches = %d, want unknown kids rate limited to the first fetch", got)
+	}
+
+	now = now.Add(31 * time.Second)
+	if res := performRequest(handler, sign("new", newKey)); res.Code != http.StatusNoContent {
+		t.Fatalf("rotated key response code = %d, want %d after refresh", res.Code, http.StatusNoContent)
+	}
+	if got := server.fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetches = %d, want one refresh for the unknown kid", got)
+	}
+}
+
+func TestHandlerKeepsJWKSKeysWhenRefreshFails(t *testing.T) {
+	addConsumer(t, "jwks-outage-user")
+	privateKey, jwk := newRSAJWK(t, "key-1")
+	server := newJWKSTestServer(t, jwk)
+	now := time.Now()
+	p := newJWKSTestPlugin(t, JWKSConfig{URI: server.URL, CacheTTL: 60}, &now)
+	handler := p.Handler(assertConsumer(t, "jwks-outage-user"))
+	token := signWithKid(t, "RS256", "key-1", privateKey, map[string]any{
+		"sub": "jwks-outage-user",
+		"exp": now.Add(time.Hour).Unix(),
+	})
+
+	if res := performRequest(handler, token); res.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d", res.Code, http.StatusNoContent)
+	}
+	server.Close()
+	now = now.Add(2 * time.Minute)
+	if res := performRequest(handler, token); res.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want the cached key used while the endpoint is down", res.Code)
+	}
+}
+
+func TestHandlerValidatesJWKSIssuerAudienceAndExpiry(t *testing.T) {
+	addConsumer(t, "jwks-claims-user")
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ECDSA key: %v", err)
+	}
+	server := newJWKSTestServer(t, jose.JSONWebKey{Key: &privateKey.PublicKey, KeyID: "ec", Algorithm: "ES256"})
+	now := time.Now()
+	p := newJWKSTestPlugin(t, JWKSConfig{
+		URI:      server.URL,
+		Issuer:   "https://idp.example.com/realms/apisix",
+		Audience: []string{"gateway"},
+	}, &now)
+	handler := p.Handler(assertConsumer(t, "jwks-claims-user"))
+	claims := func(overrides map[string]any) map[string]any {
+		payload := map[string]any{
+			"sub": "jwks-claims-user",
+			"iss": "https://idp.example.com/realms/apisix",
+			"aud": []string{"account", "gateway"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for key, value := range overrides {
+			if value == nil {
+				delete(payload, key)
+				continue
+			}
+			payload[key] = value
+		}
+		return payload
+	}
+
+	res := performRequest(handler, signWithKid(t, "ES256", "ec", privateKey, claims(nil)))
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d; body=%s", res.Code, http.StatusNoContent, res.Body.String())
+	}
+	for name, overrides := range map[string]map[string]any{
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"wrong audience": {"aud": "account"},
+		"missing exp":    {"exp": nil},
+		"expired":        {"exp": now.Add(-time.Minute).Unix()},
+	} {
+		res := performRequest(handler, signWithKid(t, "ES256", "ec", privateKey, claims(overrides)))
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("%s response code = %d, want %d", name, res.Code, http.StatusUnauthorized)
+		}
+	}
+
+	hsToken := signHS256(t, "secret", claims(nil))
+	if res := performRequest(handler, hsToken); res.Code != http.StatusUnauthorized {
+		t.Fatalf("HS256 response code = %d, want symmetric tokens rejected", res.Code)
+	}
+}
+
+func TestHandlerAttachesSyntheticJWKSConsumerWithLabelClaims(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	server := newJWKSTestServer(t, jose.JSONWebKey{Key: publicKey, KeyID: "ed"})
+	now := time.Now()
+	synthetic := true
+	p := newJWKSTestPlugin(t, JWKSConfig{
+		URI:               server.URL,
+		ConsumerClaim:     "preferred_username",
+		SyntheticConsumer: &synthetic,
+		LabelClaims:       []string{"groups", "tenant"},
+	}, &now)
+	token := signWithKid(t, "EdDSA", "ed", privateKey, map[string]any{
+		"preferred_username": "idp-user",
+		"groups":             []string{"admins"},
+		"exp":                now.Add(time.Hour).Unix(),
+	})
+
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		consumer, ok := ctx.GetApisixVar(r, "$consumer").(resource.Consumer)
+		if !ok || consumer.Username != "idp-user" {
+			t.Fatalf("consumer = %#v, want synthetic idp-user", ctx.GetApisixVar(r, "$consumer"))
+		}
+		groups, ok := consumer.Labels["groups"].([]any)
+		if !ok || len(groups) != 1 || groups[0] != "admins" || len(consumer.Labels) != 1 {
+			t.Fatalf("consumer labels = %#v, want the groups claim", consumer.Labels)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	if res := performRequest(handler, token); res.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d; body=%s", res.Code, http.StatusNoContent, res.Body.String())
+	}
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	_, rsaJWK := newRSAJWK(t, "rsa")
+	rsaKey, err := json.Marshal(rsaJWK)
+	if err != nil {
+		t.Fatalf("marshal JWK: %v", err)
+	}
+	body := `{"keys":[{"kty":"oct","k":"c2VjcmV0","kid":"hmac"},{"kty":"unknown"},` + string(rsaKey) + `]}`
+
+	keys, err := parseJWKS([]byte(body))
+	if err != nil {
+		t.Fatalf("parseJWKS() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0].KeyID != "rsa" {
+		t.Fatalf("keys = %+v, want only the RSA key", keys)
+	}
+	if _, err := parseJWKS([]byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`)); err == nil {
+		t.Fatal("parseJWKS() error = nil, want a set without public keys rejected")
+	}
+}
+
+func TestSchemaValidatesJWKSConfig(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	valid := map[string]any{"jwks": map[string]any{
+		"uri":                "https://idp.example.com/certs",
+		"algorithms":         []any{"RS256", "EdDSA"},
+		"issuer":             "https://idp.example.com",
+		"audience":           []any{"gateway"},
+		"synthetic_consumer": true,
+		"label_claims":       []any{"groups"},
+	}}
+	if err := util.Validate(valid, p.GetSchema()); err != nil {
+		t.Fatalf("schema rejected jwks config: %v", err)
+	}
+	for name, jwks := range map[string]map[string]any{
+		"missing uri": {"issuer": "https://idp.example.com", "audience": []any{"gateway"}},
+		"invalid uri": {"uri": "file:///etc/keys", "issuer": "https://idp.example.com", "audience": []any{"gateway"}},
+		"plain http uri": {
+			"uri":      "http://idp.example.com/certs",
+			"issuer":   "https://idp.example.com",
+			"audience": []any{"gateway"},
+		},
+		"missing issuer":   {"uri": "https://idp.example.com/certs", "audience": []any{"gateway"}},
+		"missing audience": {"uri": "https://idp.example.com/certs", "issuer": "https://idp.example.com"},
+		"symmetric alg": {
+			"uri":        "https://idp.example.com/certs",
+			"issuer":     "https://idp.example.com",
+			"audience":   []any{"gateway"},
+			"algorithms": []any{"HS256"},
+		},
+		"zero refresh gap": {
+			"uri":                  "https://idp.example.com/certs",
+			"issuer":               "https://idp.example.com",
+			"audience":             []any{"gateway"},
+			"min_refresh_interval": 0,
+		},
+	} {
+		if err := util.Validate(map[string]any{"jwks": jwks}, p.GetSchema()); err == nil {
+			t.Fatalf("schema accepted jwks config with %s", name)
+		}
+	}
+}
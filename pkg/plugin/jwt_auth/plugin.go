@@ -20,6 +20,7 @@ type Plugin struct {
 	base.BasePlugin
 	config Config
 	now    func() time.Time
+	jwks   *jwksKeySet
 }
 
 const (
@@ -71,21 +72,95 @@ const schema = `
         "enum": ["exp", "nbf"]
       },
       "uniqueItems": true
+    },
+    "jwks": {
+      "type": "object",
+      "properties": {
+        "uri": {
+          "type": "string",
+          "pattern": "^https://"
+        },
+        "algorithms": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "enum": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"]
+          },
+          "minItems": 1,
+          "uniqueItems": true
+        },
+        "issuer": {
+          "type": "string",
+          "minLength": 1
+        },
+        "audience": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "minLength": 1
+          },
+          "minItems": 1,
+          "uniqueItems": true
+        },
+        "consumer_claim": {
+          "type": "string",
+          "default": "sub",
+          "minLength": 1
+        },
+        "synthetic_consumer": {
+          "type": "boolean",
+          "default": false
+        },
+        "label_claims": {
+          "type": "array",
+          "items": {
+            "type": "string",
+            "minLength": 1
+          },
+          "uniqueItems": true
+        },
+        "lifetime_grace_period": {
+          "type": "integer",
+          "minimum": 0,
+          "default": 0
+        },
+        "cache_ttl": {
+          "type": "integer",
+          "minimum": 1,
+          "default": 300
+        },
+        "min_refresh_interval": {
+          "type": "integer",
+          "minimum": 1,
+          "default": 10
+        },
+        "timeout": {
+          "type": "integer",
+          "minimum": 1,
+          "default": 3
+        },
+        "ssl_verify": {
+          "type": "boolean",
+          "default": true
+        }
+      },
+      "required": ["uri", "issuer", "audience"]
     }
   }
 }
 `
 
 type Config struct {
-	Header            string   `json:"header,omitempty"`
-	Query             string   `json:"query,omitempty"`
-	Cookie            string   `json:"cookie,omitempty"`
-	HideCredentials   *bool    `json:"hide_credentials,omitempty"`
-	KeyClaimName      string   `json:"key_claim_name,omitempty"`
-	StoreInCtx        *bool    `json:"store_in_ctx,omitempty"`
-	Realm             string   `json:"realm,omitempty"`
-	AnonymousConsumer string   `json:"anonymous_consumer,omitempty"`
-	ClaimsToVerify    []string `json:"claims_to_verify,omitempty"`
+	Header            string      `json:"header,omitempty"`
+	Query             string      `json:"query,omitempty"`
+	Cookie            string      `json:"cookie,omitempty"`
+	HideCredentials   *bool       `json:"hide_credentials,omitempty"`
+	KeyClaimName      string      `json:"key_claim_name,omitempty"`
+	StoreInCtx        *bool       `json:"store_in_ctx,omitempty"`
+	Realm             string      `json:"realm,omitempty"`
+	AnonymousConsumer string      `json:"anonymous_consumer,omitempty"`
+	ClaimsToVerify    []string    `json:"claims_to_verify,omitempty"`
+	JWKS              *JWKSConfig `json:"jwks,omitempty"`
 }
 
 type consumerConfig struct {
@@ -134,6 +209,10 @@ func (p *Plugin) PostInit() error {
 	if p.now == nil {
 		p.now = time.Now
 	}
+	if p.config.JWKS != nil {
+		p.config.JWKS.setDefaults()
+		p.jwks = newJWKSKeySet(*p.config.JWKS, p.now)
+	}
 
 	return nil
 }
@@ -194,6 +273,10 @@ func (p *Plugin) findConsumer(r *http.Request) (resource.Consumer, jwtToken, str
 		return resource.Consumer{}, jwtToken{}, "JWT token invalid"
 	}
 
+	if p.jwks != nil {
+		return p.findJWKSConsumer(rawToken, token)
+	}
+
 	userKey, ok := token.Payload[p.config.KeyClaimName].(string)
 	if !ok || userKey == "" {
 		return resource.Consumer{}, token, "missing user key in JWT token"
@@ -237,6 +320,40 @@ func (p *Plugin) findConsumer(r *http.Request) (resource.Consumer, jwtToken, str
 	}, ""
 }
 
+// findJWKSConsumer verifies the token against the JWKS endpoint and maps the
+// configured claim onto the consumer with that username. Without one, a
+// synthetic consumer named after the claim is used when enabled, carrying the
+// label claims so that acl and consumer-restriction can still match it.
+func (p *Plugin) findJWKSConsumer(rawToken string, token jwtToken) (resource.Consumer, jwtToken, string) {
+	claims, err := verifyJWKSToken(rawToken, p.jwks, *p.config.JWKS, p.now(), p.config.ClaimsToVerify)
+	if err != nil {
+		return resource.Consumer{}, token, "failed to verify jwt"
+	}
+	token.Payload = claims
+
+	username, ok := claims[p.config.JWKS.ConsumerClaim].(string)
+	if !ok || username == "" {
+		return resource.Consumer{}, token, "missing user key in JWT token"
+	}
+	if consumer, err := store.GetConsumer(username); err == nil {
+		return consumer, token, ""
+	}
+	if !*p.config.JWKS.SyntheticConsumer {
+		return resource.Consumer{}, token, "Invalid user key in JWT token"
+	}
+
+	consumer := resource.Consumer{Username: username}
+	for _, claim := range p.config.JWKS.LabelClaims {
+		if value, ok := claims[claim]; ok {
+			if consumer.Labels == nil {
+				consumer.Labels = make(map[string]any, len(p.config.JWKS.LabelClaims))
+			}
+			consumer.Labels[claim] = value
+		}
+	}
+	return consumer, token, ""
+}
+
 // verifyToken parses and verifies a raw JWT against a consumer configuration.
 // The token algorithm must match the consumer algorithm, signatures are
 // checked with the consumer secret or public key, and exp/nbf claims follow
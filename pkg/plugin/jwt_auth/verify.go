@@ -2,6 +2,7 @@ package jwt_auth
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -90,3 +91,50 @@ func verifyAPISIXTimeClaims(claims jwt.MapClaims, now time.Time, leeway time.Dur
 
 	return nil
 }
+
+// verifyJWKSToken verifies a raw JWT against the keys of a JWKS endpoint.
+// Unlike consumer keys, tokens minted by an identity provider must carry exp,
+// and iss/aud must match when the route configures them.
+func verifyJWKSToken(
+	raw string,
+	keySet *jwksKeySet,
+	config JWKSConfig,
+	now time.Time,
+	requiredClaims []string,
+) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(config.Algorithms),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+		jwt.WithoutClaimsValidation(),
+	)
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keySet.verificationKey(kid, token.Method.Alg())
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("failed to verify jwt: %w", err)
+	}
+
+	leeway := time.Duration(config.LifetimeGracePeriod) * time.Second
+	if err := verifyAPISIXTimeClaims(claims, now, leeway, requiredClaims); err != nil {
+		return nil, err
+	}
+	if err := verifyAPISIXTimeClaims(claims, now, leeway, []string{"exp"}); err != nil {
+		return nil, err
+	}
+	if config.Issuer != "" {
+		if issuer, err := claims.GetIssuer(); err != nil || issuer != config.Issuer {
+			return nil, fmt.Errorf("claim iss does not match")
+		}
+	}
+	if len(config.Audience) > 0 {
+		audience, err := claims.GetAudience()
+		if err != nil || !slices.ContainsFunc(audience, func(aud string) bool {
+			return slices.Contains(config.Audience, aud)
+		}) {
+			return nil, fmt.Errorf("claim aud does not match")
+		}
+	}
+	return claims, nil
+}
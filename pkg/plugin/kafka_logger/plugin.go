@@ -260,12 +260,13 @@ type Config struct {
 	MetaRefreshInterval  int `json:"meta_refresh_interval,omitempty"`
 	APIVersion           int `json:"api_version,omitempty"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 }
 
 type pluginMetadata struct {
@@ -280,7 +281,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	p.InitLogger(p.Send)
 
@@ -338,6 +339,7 @@ func (p *Plugin) PostInit() error {
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
 		PluginID:           name,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 	return nil
 }
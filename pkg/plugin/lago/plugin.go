@@ -165,11 +165,12 @@ type Config struct {
 	MaxReqBodyBytes     int               `json:"max_req_body_bytes,omitempty"`
 	MaxRespBodyBytes    int               `json:"max_resp_body_bytes,omitempty"`
 
-	BatchMaxSize    int `json:"batch_max_size,omitempty"`
-	InactiveTimeout int `json:"inactive_timeout,omitempty"`
-	BufferDuration  int `json:"buffer_duration,omitempty"`
-	RetryDelay      int `json:"retry_delay,omitempty"`
-	MaxRetryCount   int `json:"max_retry_count,omitempty"`
+	BatchMaxSize    int  `json:"batch_max_size,omitempty"`
+	InactiveTimeout int  `json:"inactive_timeout,omitempty"`
+	BufferDuration  int  `json:"buffer_duration,omitempty"`
+	RetryDelay      int  `json:"retry_delay,omitempty"`
+	MaxRetryCount   int  `json:"max_retry_count,omitempty"`
+	Spool           bool `json:"spool,omitempty"`
 }
 
 type lagoPayload struct {
@@ -228,7 +229,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	p.InitLogger(p.Send)
 
@@ -318,6 +319,7 @@ func (p *Plugin) PostInit() error {
 		RetryDelaySec:      p.config.RetryDelay,
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 	return nil
 }
@@ -1,6 +1,7 @@
 package limit_conn
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -24,20 +25,29 @@ type Plugin struct {
 	base.BasePlugin
 	config Config
 
+	// Local connection counts live in the plugin-limit-conn zone, so
+	// requests admitted before a route reload are still counted, and
+	// released, after it. The zone never evicts: a full zone rejects new
+	// keys, as NGINX limit_conn does, instead of dropping live counts.
+	zone      *shared.Dict
 	mu        sync.Mutex
-	conns     map[string]int
 	unitDelay float64
 
 	redisLimiter connLimiter
 	routeID      string
 	limitScope   string
 
+	streamConn  int
+	streamBurst int
+
 	clientRelease func()
 }
 
 const (
 	priority = 1003
 	name     = "limit-conn"
+
+	zoneName = "plugin-limit-conn"
 )
 
 const schema = `
@@ -429,9 +439,10 @@ func (p *Plugin) PostInit() error {
 		p.config.rejectBody = util.BytesToString(body)
 	}
 
-	if p.conns == nil {
-		p.conns = make(map[string]int)
+	if p.zone == nil {
+		p.zone = shared.Zone(zoneName)
 	}
+	p.zone.DisableEviction()
 	p.unitDelay = p.config.DefaultConnDelay
 
 	if len(p.config.Rules) > 0 {
@@ -825,7 +836,11 @@ func (p *Plugin) increase(
 	conn int,
 	burst int,
 ) (time.Duration, bool, func(*time.Duration), error) {
-	key = p.applyLimitKey(r, key)
+	return p.increaseKey(p.applyLimitKey(r, key), conn, burst)
+}
+
+// increaseKey counts one admission against an already scoped key.
+func (p *Plugin) increaseKey(key string, conn int, burst int) (time.Duration, bool, func(*time.Duration), error) {
 	if p.config.Policy == "redis" || p.config.Policy == "redis-cluster" {
 		limiter := p.redisLimiter
 		delay, member, allowed, err := limiter.incoming(key, conn, burst)
@@ -837,19 +852,27 @@ func (p *Plugin) increase(
 		}, nil
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	current := p.conns[key] + 1
 	limit := conn + burst
-	if current > limit {
+	var current int
+	err := p.zone.Update(key, 0, func(value any, _ bool) (any, bool) {
+		count, _ := value.(float64)
+		current = int(count) + 1
+		return float64(current), current <= limit
+	})
+	if errors.Is(err, shared.ErrNoMemory) {
+		logger.Warnf("limit-conn zone %s is full, rejecting the request", zoneName)
 		return 0, false, nil, nil
 	}
+	if err != nil || current > limit {
+		return 0, false, nil, err
+	}
 
-	p.conns[key] = current
 	release := func(latency *time.Duration) { p.decreaseLocal(key, latency) }
 	if current > conn {
-		return connectionDelay(current, conn, p.unitDelay), true, release, nil
+		p.mu.Lock()
+		unitDelay := p.unitDelay
+		p.mu.Unlock()
+		return connectionDelay(current, conn, unitDelay), true, release, nil
 	}
 
 	return 0, true, release, nil
@@ -862,7 +885,6 @@ func connectionDelay(current int, conn int, unitDelay float64) time.Duration {
 
 func (p *Plugin) decreaseLocal(key string, latency *time.Duration) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	if p.config.OnlyUseDefaultDelay {
 		logger.Debug("request latency is nil")
 	} else if latency != nil {
@@ -871,13 +893,15 @@ func (p *Plugin) decreaseLocal(key string, latency *time.Duration) {
 		}
 		p.unitDelay = (p.unitDelay + latency.Seconds()) / 2
 	}
+	p.mu.Unlock()
 
-	current := p.conns[key]
-	if current <= 1 {
-		delete(p.conns, key)
-		return
-	}
-	p.conns[key] = current - 1
+	_ = p.zone.Update(key, 0, func(value any, ok bool) (any, bool) {
+		count, _ := value.(float64)
+		if count <= 1 {
+			return nil, ok
+		}
+		return count - 1, true
+	})
 }
 
 func (p *Plugin) resolveKey(r *http.Request) string {
@@ -19,13 +19,14 @@ import (
 	"github.com/wklken/apisix-go/pkg/plugin/base"
 	"github.com/wklken/apisix-go/pkg/plugin/limitbase"
 	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/shared"
 	"github.com/wklken/apisix-go/pkg/util"
 )
 
 func newTestPlugin(t *testing.T, cfg Config) *Plugin {
 	t.Helper()
 
-	p := &Plugin{config: cfg}
+	p := &Plugin{config: cfg, zone: shared.NewDict(1 << 20)}
 	if err := p.Init(); err != nil {
 		t.Fatalf("Init() error = %v", err)
 	}
@@ -341,6 +342,63 @@ func TestHandlerRejectsConcurrentRequestsAboveConnAndBurst(t *testing.T) {
 	}
 }
 
+func TestHandlerRejectsNewKeysWhenZoneIsFull(t *testing.T) {
+	p := &Plugin{
+		config: Config{Conn: 1, Burst: 0, DefaultConnDelay: 0.1, Key: "remote_addr"},
+		zone:   shared.NewDict(4 << 10),
+	}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := p.PostInit(); err != nil {
+		t.Fatalf("PostInit() error = %v", err)
+	}
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	var startedOnce sync.Once
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first := false
+		startedOnce.Do(func() {
+			first = true
+			close(started)
+		})
+		if first {
+			<-block
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		performRequest(handler, "192.0.2.10:12345")
+	})
+	<-started
+
+	full := false
+	for i := 0; i < 100; i++ {
+		err := p.zone.Set(fmt.Sprintf("client-%d", i), 1.0, 0)
+		if errors.Is(err, shared.ErrNoMemory) {
+			full = true
+		} else if err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if !full {
+		t.Fatal("filling the zone evicted entries instead of failing with ErrNoMemory")
+	}
+
+	if code := performRequest(handler, "192.0.2.20:12345").Code; code != http.StatusServiceUnavailable {
+		t.Fatalf("new key in full zone response code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := performRequest(handler, "192.0.2.10:23456").Code; code != http.StatusServiceUnavailable {
+		t.Fatalf("hot key after zone filled response code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	close(block)
+	wg.Wait()
+}
+
 func TestHandlerUsesRejectedMessage(t *testing.T) {
 	p := newTestPlugin(t, Config{
 		Conn:             1,
@@ -1085,7 +1143,7 @@ func TestDecreaseLogsMeasuredAndDefaultRequestLatency(t *testing.T) {
 				Key:                 "remote_addr",
 				OnlyUseDefaultDelay: test.onlyUseDefaultDelay,
 			})
-			p.conns["client"] = 1
+			_ = p.zone.Set("client", 1.0, 0)
 
 			entries := make(chan logger.Entry, 1)
 			stop := logger.ReplaceObserver("limit-conn-latency-test", func(entry logger.Entry) {
@@ -1394,8 +1452,8 @@ func TestRequestPhaseLimitConnReleasesAfterNormalCompletion(t *testing.T) {
 	if !called {
 		t.Fatal("request phase did not reach terminal")
 	}
-	if len(p.conns) != 1 {
-		t.Fatalf("connections before finalization = %d, want 1", len(p.conns))
+	if p.zone.Len() != 1 {
+		t.Fatalf("connections before finalization = %d, want 1", p.zone.Len())
 	}
 	lifecycle.SetOutcome(apisixctx.ResponseOutcome{
 		Kind:   apisixctx.RequestOutcomeCompleted,
@@ -1404,8 +1462,8 @@ func TestRequestPhaseLimitConnReleasesAfterNormalCompletion(t *testing.T) {
 	if failures := lifecycle.Finalize(); len(failures) != 0 {
 		t.Fatalf("lifecycle finalizer failures = %#v", failures)
 	}
-	if len(p.conns) != 0 {
-		t.Fatalf("connections after finalization = %d, want 0", len(p.conns))
+	if p.zone.Len() != 0 {
+		t.Fatalf("connections after finalization = %d, want 0", p.zone.Len())
 	}
 	apisixctx.RecycleVars(request)
 }
@@ -1428,8 +1486,8 @@ func TestRequestPhaseLimitConnReleasesAfterDownstreamPanic(t *testing.T) {
 		})).ServeHTTP(httptest.NewRecorder(), request)
 	}()
 	lifecycle.Finalize()
-	if len(p.conns) != 0 {
-		t.Fatalf("connections after panic finalization = %d, want 0", len(p.conns))
+	if p.zone.Len() != 0 {
+		t.Fatalf("connections after panic finalization = %d, want 0", p.zone.Len())
 	}
 	apisixctx.RecycleVars(request)
 }
@@ -1531,8 +1589,8 @@ func TestRequestPhaseLimitConnDegradationDoesNotRegisterRelease(t *testing.T) {
 		t.Fatal("degraded request did not reach terminal")
 	}
 	lifecycle.Finalize()
-	if len(p.conns) != 0 {
-		t.Fatalf("connections after degraded request = %d, want 0", len(p.conns))
+	if p.zone.Len() != 0 {
+		t.Fatalf("connections after degraded request = %d, want 0", p.zone.Len())
 	}
 	apisixctx.RecycleVars(request)
 }
@@ -1589,8 +1647,8 @@ func TestRequestPhaseLimitConnReleaseUsesAdmissionScope(t *testing.T) {
 		})).ServeHTTP(httptest.NewRecorder(), request)
 		p.routeID = "route-after"
 		lifecycle.Finalize()
-		if len(p.conns) != 0 {
-			t.Fatalf("connections after route scope changed = %d, want 0", len(p.conns))
+		if p.zone.Len() != 0 {
+			t.Fatalf("connections after route scope changed = %d, want 0", p.zone.Len())
 		}
 		apisixctx.RecycleVars(request)
 	})
@@ -1647,8 +1705,8 @@ func TestRequestPhaseLimitConnAddFinalizerFailureRollsBackAdmission(t *testing.T
 	if rr.Code != http.StatusInternalServerError {
 		t.Fatalf("status = %d, want 500", rr.Code)
 	}
-	if len(p.conns) != 0 {
-		t.Fatalf("connections after failed registration = %d, want 0", len(p.conns))
+	if p.zone.Len() != 0 {
+		t.Fatalf("connections after failed registration = %d, want 0", p.zone.Len())
 	}
 	if p.unitDelay != baselineDelay {
 		t.Fatalf("unit delay after rollback = %v, want unchanged %v", p.unitDelay, baselineDelay)
@@ -1713,3 +1771,30 @@ func TestLimitConnHandlerUsesOnlyLifecycleReleaseOwner(t *testing.T) {
 	}
 	apisixctx.RecycleVars(request)
 }
+
+func TestLocalConnectionsSurviveRouteReload(t *testing.T) {
+	config := Config{Conn: 1, Burst: 0, DefaultConnDelay: 0.1, Key: "remote_addr"}
+	previous := newTestPlugin(t, config)
+	previous.SetResourceContext(resource.Route{ID: "route-1"}, resource.Service{})
+	_, allowed, release, err := previous.increaseKey(previous.scopedKey("client"), 1, 0)
+	if err != nil || !allowed {
+		t.Fatalf("first admission = allowed %t, error %v; want true, nil", allowed, err)
+	}
+
+	reloaded := newTestPlugin(t, config)
+	reloaded.SetResourceContext(resource.Route{ID: "route-1"}, resource.Service{})
+	reloaded.zone = previous.zone
+	if _, allowed, _, err := reloaded.increaseKey(reloaded.scopedKey("client"), 1, 0); err != nil || allowed {
+		t.Fatalf("admission after reload = allowed %t, error %v; want the in-flight request counted", allowed, err)
+	}
+
+	release(nil)
+	_, allowed, release, err = reloaded.increaseKey(reloaded.scopedKey("client"), 1, 0)
+	if err != nil || !allowed {
+		t.Fatalf("admission after release = allowed %t, error %v; want true, nil", allowed, err)
+	}
+	release(nil)
+	if previous.zone.Len() != 0 {
+		t.Fatalf("zone entries after release = %d, want 0", previous.zone.Len())
+	}
+}
@@ -0,0 +1,84 @@
+package limit_conn
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/limitbase"
+)
+
+// StreamVars resolves a stream variable such as remote_addr or server_port
+// for the current connection, returning "" when it is unknown.
+type StreamVars func(name string) string
+
+// PrepareStream checks that the configuration can limit stream connections.
+// Streams have no request variables, so conn and burst must be static, and
+// only the local policy counts connections.
+func (p *Plugin) PrepareStream() error {
+	if len(p.config.Rules) > 0 {
+		return fmt.Errorf("limit-conn rules are not supported on stream routes")
+	}
+	if p.config.Policy != "local" {
+		return fmt.Errorf("limit-conn policy %q is not supported on stream routes", p.config.Policy)
+	}
+	conn, static, err := staticLimitValue(p.config.Conn, "conn", false)
+	if err != nil {
+		return err
+	}
+	if !static {
+		return fmt.Errorf("limit-conn conn must be static on stream routes")
+	}
+	burst, static, err := staticLimitValue(p.config.Burst, "burst", true)
+	if err != nil {
+		return err
+	}
+	if !static {
+		return fmt.Errorf("limit-conn burst must be static on stream routes")
+	}
+	p.streamConn = conn
+	p.streamBurst = burst
+	return nil
+}
+
+// AdmitStream counts one stream connection or UDP session. When admitted it
+// returns the delay to wait before dialing the upstream and the release to
+// call when the connection ends.
+func (p *Plugin) AdmitStream(vars StreamVars) (time.Duration, func(), bool) {
+	key := p.resolveStreamKey(vars)
+	delay, allowed, release, _ := p.increaseKey(p.scopedKey(key), p.streamConn, p.streamBurst)
+	if !allowed {
+		return 0, nil, false
+	}
+	started := time.Now()
+	return delay, func() {
+		latency := time.Since(started)
+		release(&latency)
+	}, true
+}
+
+func (p *Plugin) resolveStreamKey(vars StreamVars) string {
+	var key string
+	if p.config.KeyType == "var_combination" {
+		resolved := 0
+		key = limitbase.VarPattern.ReplaceAllStringFunc(p.config.Key, func(match string) string {
+			name := strings.TrimPrefix(strings.TrimPrefix(match, "${"), "$")
+			value := vars(strings.TrimSuffix(name, "}"))
+			if value != "" {
+				resolved++
+			}
+			return value
+		})
+		if resolved == 0 {
+			key = ""
+		}
+	} else {
+		key = vars(strings.TrimPrefix(p.config.Key, "$"))
+	}
+	if key == "" {
+		logger.Warn("The value of the configured key is empty, use client IP instead")
+		key = vars("remote_addr")
+	}
+	return key
+}
@@ -0,0 +1,95 @@
+package limit_conn
+
+import (
+	"strings"
+	"testing"
+)
+
+func streamVars(values map[string]string) StreamVars {
+	return func(name string) string { return values[name] }
+}
+
+func TestAdmitStreamCountsConnectionsPerKey(t *testing.T) {
+	p := newTestPlugin(t, Config{Conn: 1, Burst: 1, DefaultConnDelay: 0.5, Key: "$remote_addr"})
+	if err := p.PrepareStream(); err != nil {
+		t.Fatalf("PrepareStream() error = %v", err)
+	}
+	client := streamVars(map[string]string{"remote_addr": "192.0.2.1"})
+
+	delay, releaseFirst, ok := p.AdmitStream(client)
+	if !ok || delay != 0 {
+		t.Fatalf("first AdmitStream() = %v/%v, want admitted without delay", delay, ok)
+	}
+	delay, releaseBurst, ok := p.AdmitStream(client)
+	if !ok || delay <= 0 {
+		t.Fatalf("burst AdmitStream() = %v/%v, want admitted with delay", delay, ok)
+	}
+	if _, _, ok := p.AdmitStream(client); ok {
+		t.Fatal("AdmitStream() admitted a connection over conn+burst")
+	}
+	if _, release, ok := p.AdmitStream(streamVars(map[string]string{"remote_addr": "192.0.2.2"})); !ok {
+		t.Fatal("AdmitStream() rejected another client key")
+	} else {
+		release()
+	}
+
+	releaseBurst()
+	releaseFirst()
+	if _, release, ok := p.AdmitStream(client); !ok {
+		t.Fatal("AdmitStream() rejected after every connection was released")
+	} else {
+		release()
+	}
+}
+
+func TestAdmitStreamCombinesVariablesAndFallsBackToClientIP(t *testing.T) {
+	p := newTestPlugin(t, Config{
+		Conn:             1,
+		Burst:            0,
+		DefaultConnDelay: 0.1,
+		Key:              "$server_port $missing",
+		KeyType:          "var_combination",
+	})
+	if got := p.resolveStreamKey(streamVars(map[string]string{"server_port": "9100"})); got != "9100 " {
+		t.Fatalf("combined key = %q, want the server port", got)
+	}
+	p.config.Key = "$missing"
+	if got := p.resolveStreamKey(streamVars(map[string]string{"remote_addr": "192.0.2.1"})); got != "192.0.2.1" {
+		t.Fatalf("fallback key = %q, want the client IP", got)
+	}
+}
+
+func TestPrepareStreamRejectsRequestScopedConfiguration(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "rules",
+			cfg:  Config{DefaultConnDelay: 0.1, Rules: []Rule{{Conn: 1, Burst: 0, Key: "$remote_addr"}}},
+			want: "rules are not supported",
+		},
+		{
+			name: "variable conn",
+			cfg:  Config{Conn: "$http_conn", Burst: 0, DefaultConnDelay: 0.1, Key: "remote_addr"},
+			want: "conn must be static",
+		},
+		{
+			name: "redis policy",
+			cfg: Config{
+				Conn: 1, Burst: 0, DefaultConnDelay: 0.1, Key: "remote_addr",
+				Policy: "redis", RedisHost: "127.0.0.1",
+			},
+			want: `policy "redis" is not supported`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPlugin(t, test.cfg)
+			if err := p.PrepareStream(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("PrepareStream() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
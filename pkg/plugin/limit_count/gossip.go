@@ -0,0 +1,633 @@
+package limit_count
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cast"
+	limiter "github.com/ulule/limiter/v3"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	gossipProtocolVersion     = 1
+	defaultGossipSyncInterval = 100 * time.Millisecond
+	minGossipSyncInterval     = 10 * time.Millisecond
+	// gossipFullSyncRounds is how often a node resends every counter it holds
+	// a count for, even an unchanged one, so that a peer that lost a packet
+	// or joined in the middle of a window catches up. The full round is also
+	// the heartbeat that keeps an idle node counted among the live nodes.
+	gossipFullSyncRounds = 10
+	// gossipPeerTimeoutRounds is how many rounds a peer stays live after its
+	// last packet.
+	gossipPeerTimeoutRounds = 3 * gossipFullSyncRounds
+	// gossipMaxPacketSize keeps a packet inside one Ethernet frame.
+	gossipMaxPacketSize = 1400
+	gossipMACSize       = sha256.Size
+	// gossipMaxPeers bounds the nodes a node tracks. Packets from further
+	// node IDs are dropped until a tracked peer times out, so a flood of
+	// node IDs cannot shrink every share to nothing.
+	gossipMaxPeers = 256
+
+	// GossipMembershipGroup is the etcd membership group the gossip nodes
+	// register their advertised address in.
+	GossipMembershipGroup = "limit-count"
+)
+
+// GossipConfig is the plugin_attr.limit-count.gossip section.
+type GossipConfig struct {
+	// NodeID identifies this node to its peers. It defaults to the APISIX
+	// instance ID.
+	NodeID string
+	// Listen is the UDP address the node receives counters on.
+	Listen string
+	// Advertise is the address this node registers for etcd discovery. It
+	// defaults to Listen.
+	Advertise string
+	// Peers are the UDP addresses of the other nodes.
+	Peers []string
+	// EtcdDiscovery adds the nodes registered under the etcd prefix to Peers.
+	EtcdDiscovery bool
+	// SyncInterval is how often the node sends its counters to its peers.
+	SyncInterval time.Duration
+	// Secret authenticates every packet with HMAC-SHA256. It is required
+	// unless Listen is a loopback address.
+	Secret string
+}
+
+// GossipConfigFromAttr reads the gossip section of plugin_attr.limit-count.
+// ok is false when the section is absent.
+func GossipConfigFromAttr(attr map[string]any) (GossipConfig, bool, error) {
+	raw, ok := attr["gossip"]
+	if !ok || raw == nil {
+		return GossipConfig{}, false, nil
+	}
+	section, err := cast.ToStringMapE(raw)
+	if err != nil {
+		return GossipConfig{}, false, fmt.Errorf("plugin_attr.limit-count.gossip must be an object, got %T", raw)
+	}
+
+	config := GossipConfig{SyncInterval: defaultGossipSyncInterval}
+	config.NodeID = cast.ToString(section["node_id"])
+	config.Listen = cast.ToString(section["listen"])
+	if config.Listen == "" {
+		return GossipConfig{}, false, errors.New("plugin_attr.limit-count.gossip.listen is required")
+	}
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		return GossipConfig{}, false, fmt.Errorf(
+			"plugin_attr.limit-count.gossip.listen %q is invalid: %w",
+			config.Listen,
+			err,
+		)
+	}
+	config.Advertise = cast.ToString(section["advertise"])
+	if config.Advertise == "" {
+		config.Advertise = config.Listen
+	}
+	if value, ok := section["peers"]; ok {
+		config.Peers, err = cast.ToStringSliceE(value)
+		if err != nil {
+			return GossipConfig{}, false, fmt.Errorf(
+				"plugin_attr.limit-count.gossip.peers must be a list of addresses, got %T",
+				value,
+			)
+		}
+	}
+	if value, ok := section["etcd_discovery"]; ok {
+		config.EtcdDiscovery, err = cast.ToBoolE(value)
+		if err != nil {
+			return GossipConfig{}, false, fmt.Errorf(
+				"plugin_attr.limit-count.gossip.etcd_discovery must be a boolean, got %T",
+				value,
+			)
+		}
+	}
+	if config.EtcdDiscovery {
+		host, _, err := net.SplitHostPort(config.Advertise)
+		if ip := net.ParseIP(host); err != nil || host == "" || ip != nil && ip.IsUnspecified() {
+			return GossipConfig{}, false, fmt.Errorf(
+				"plugin_attr.limit-count.gossip.advertise must be a reachable address for etcd discovery, got %q",
+				config.Advertise,
+			)
+		}
+	}
+	if value, ok := section["sync_interval"]; ok {
+		seconds, err := cast.ToFloat64E(value)
+		interval := time.Duration(seconds * float64(time.Second))
+		if err != nil || interval < minGossipSyncInterval {
+			return GossipConfig{}, false, fmt.Errorf(
+				"plugin_attr.limit-count.gossip.sync_interval must be at least %v seconds, got %v",
+				minGossipSyncInterval.Seconds(),
+				value,
+			)
+		}
+		config.SyncInterval = interval
+	}
+	config.Secret = cast.ToString(section["secret"])
+	if config.Secret == "" && !loopbackGossipAddress(config.Listen) {
+		return GossipConfig{}, false, fmt.Errorf(
+			"plugin_attr.limit-count.gossip.secret is required when listen %q is not a loopback address",
+			config.Listen,
+		)
+	}
+	return config, true, nil
+}
+
+// loopbackGossipAddress reports whether address only accepts packets from
+// this host. An empty or unspecified host listens on every interface.
+func loopbackGossipAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// gossipCounterKey is one fixed window of one key. Windows are aligned to
+// the Unix epoch so that every node agrees on which window a count is for.
+type gossipCounterKey struct {
+	key    string
+	period int64
+	window int64
+}
+
+func (k gossipCounterKey) end() int64 {
+	return k.window + k.period
+}
+
+// gossipCounter is a grow-only counter: each node only adds to its own count
+// and the others keep the highest count they have heard from it, so packets
+// may be lost, duplicated or reordered without counting a request twice.
+type gossipCounter struct {
+	local int64
+	peers map[string]int64
+	// roundLocal and roundTotal are the local and total counts when the
+	// current sync round began.
+	roundLocal int64
+	roundTotal int64
+	dirty      bool
+}
+
+func (c *gossipCounter) total() int64 {
+	total := c.local
+	for _, count := range c.peers {
+		total += count
+	}
+	return total
+}
+
+type gossipEntry struct {
+	Key    string `json:"k"`
+	Period int64  `json:"p"`
+	Window int64  `json:"w"`
+	Count  int64  `json:"c"`
+}
+
+type gossipMessage struct {
+	Version int           `json:"v"`
+	Node    string        `json:"node"`
+	Entries []gossipEntry `json:"entries,omitempty"`
+}
+
+// GossipNode shares the gossip policy counters of this process with the
+// other gateway nodes over UDP. Every sync interval it sends the counts this
+// node added since the last round, and every few rounds all of them.
+//
+// The overshoot of the global quota is bounded: in one round a node admits at
+// most its share, the quota left at the start of the round divided by the
+// live nodes, so the nodes together admit at most what was left. Only counts
+// a node has not heard of by the next round, from lost or late packets, can
+// take the total past the limit.
+type GossipNode struct {
+	id       string
+	conn     net.PacketConn
+	interval time.Duration
+	secret   []byte
+	now      func() time.Time
+
+	resolveMu   sync.Mutex
+	mu          sync.Mutex
+	counters    map[gossipCounterKey]*gossipCounter
+	capacity    int
+	staticPeers []string
+	peers       []string
+	addrs       []net.Addr
+	seen        map[string]time.Time
+	live        int64
+	round       int
+
+	stop      chan struct{}
+	done      sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// gossipNode is the node started from plugin_attr, which the gossip policy
+// counts through unless a plugin is given its own.
+var gossipNode atomic.Pointer[GossipNode]
+
+var errGossipResetUnsupported = errors.New("limit-count gossip policy does not support resetting a counter")
+
+// StartGossip starts the gossip node of this process.
+func StartGossip(config GossipConfig) (*GossipNode, error) {
+	node, err := NewGossipNode(config)
+	if err != nil {
+		return nil, err
+	}
+	gossipNode.Store(node)
+	return node, nil
+}
+
+// NewGossipNode binds the node's UDP address and starts syncing with the
+// configured peers.
+func NewGossipNode(config GossipConfig) (*GossipNode, error) {
+	if config.NodeID == "" {
+		return nil, errors.New("limit-count gossip node ID is empty")
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = defaultGossipSyncInterval
+	}
+	if config.Secret == "" && !loopbackGossipAddress(config.Listen) {
+		return nil, fmt.Errorf("limit-count gossip on %s requires a secret", config.Listen)
+	}
+	conn, err := net.ListenPacket("udp", config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen limit-count gossip on %s: %w", config.Listen, err)
+	}
+	node := &GossipNode{
+		id:          config.NodeID,
+		conn:        conn,
+		interval:    config.SyncInterval,
+		now:         time.Now,
+		counters:    map[gossipCounterKey]*gossipCounter{},
+		capacity:    defaultLocalStoreCapacity,
+		staticPeers: append([]string(nil), config.Peers...),
+		seen:        map[string]time.Time{},
+		live:        1,
+		stop:        make(chan struct{}),
+	}
+	if config.Secret != "" {
+		node.secret = []byte(config.Secret)
+	}
+	node.SetPeers(nil)
+	node.done.Add(2)
+	go node.receive()
+	go node.syncLoop()
+	return node, nil
+}
+
+// ID returns the node ID.
+func (n *GossipNode) ID() string {
+	return n.id
+}
+
+// Addr returns the UDP address the node listens on.
+func (n *GossipNode) Addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+// SetPeers replaces the discovered peers; the configured peers are kept. A
+// node drops its own packets, so the peers may include this node.
+func (n *GossipNode) SetPeers(discovered []string) {
+	n.mu.Lock()
+	peers := append([]string(nil), n.staticPeers...)
+	for _, peer := range discovered {
+		if peer != "" && !slices.Contains(peers, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	n.peers = peers
+	n.mu.Unlock()
+	n.resolvePeers()
+}
+
+// resolvePeers resolves the peer addresses again, so that a peer named by a
+// host name follows DNS changes.
+func (n *GossipNode) resolvePeers() {
+	n.resolveMu.Lock()
+	defer n.resolveMu.Unlock()
+	n.mu.Lock()
+	peers := append([]string(nil), n.peers...)
+	n.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(peers))
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			logger.Warnf("resolve limit-count gossip peer %s: %v", peer, err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	n.mu.Lock()
+	n.addrs = addrs
+	n.mu.Unlock()
+}
+
+// Close stops the node. A node started by StartGossip stops being the node
+// of this process.
+func (n *GossipNode) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		gossipNode.CompareAndSwap(n, nil)
+		close(n.stop)
+		err = n.conn.Close()
+		n.done.Wait()
+	})
+	return err
+}
+
+// count adds cost to the current window of the key when the request fits in
+// both the global quota and this node's share of it. It returns the total
+// count of the window, when the window ends and whether the request was
+// rejected; a rejected request is not counted.
+func (n *GossipNode) count(key string, cost int64, limit int64, period time.Duration) (int64, int64, bool) {
+	now := n.now()
+	counterKey := gossipCounterKey{key: key, period: max(int64(period/time.Second), 1)}
+	counterKey.window = now.Unix() / counterKey.period * counterKey.period
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	counter := n.counterLocked(counterKey, now)
+	if counter == nil {
+		return 0, counterKey.end(), true
+	}
+	total := counter.total()
+	if cost == 0 {
+		return total, counterKey.end(), total > limit
+	}
+	share := ceilDiv(limit-counter.roundTotal, n.live)
+	if total+cost > limit || counter.local-counter.roundLocal+cost > share {
+		return total, counterKey.end(), true
+	}
+	counter.local += cost
+	counter.dirty = true
+	return total + cost, counterKey.end(), false
+}
+
+func ceilDiv(value int64, divisor int64) int64 {
+	if value <= 0 {
+		return 0
+	}
+	return (value + divisor - 1) / divisor
+}
+
+// counterLocked returns the counter of the window, creating it when it has
+// not ended. At capacity, ended windows are dropped first and then another
+// counter, as the local store evicts its counters.
+func (n *GossipNode) counterLocked(key gossipCounterKey, now time.Time) *gossipCounter {
+	if counter := n.counters[key]; counter != nil {
+		return counter
+	}
+	if key.end() <= now.Unix() {
+		return nil
+	}
+	if len(n.counters) >= n.capacity {
+		n.expireLocked(now)
+	}
+	if len(n.counters) >= n.capacity {
+		for evicted := range n.counters {
+			delete(n.counters, evicted)
+			break
+		}
+	}
+	counter := &gossipCounter{}
+	n.counters[key] = counter
+	return counter
+}
+
+func (n *GossipNode) expireLocked(now time.Time) {
+	for key := range n.counters {
+		if key.end() <= now.Unix() {
+			delete(n.counters, key)
+		}
+	}
+}
+
+func (n *GossipNode) syncLoop() {
+	defer n.done.Done()
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.sync()
+		}
+	}
+}
+
+// sync starts a new round: it drops ended windows, counts the live nodes,
+// takes the round snapshot each share is computed from and sends the counts
+// the peers have not seen.
+func (n *GossipNode) sync() {
+	now := n.now()
+	n.mu.Lock()
+	n.round++
+	full := n.round%gossipFullSyncRounds == 0
+	n.expireLocked(now)
+	for peer, seen := range n.seen {
+		if now.Sub(seen) > gossipPeerTimeoutRounds*n.interval {
+			delete(n.seen, peer)
+		}
+	}
+	n.live = int64(len(n.seen)) + 1
+	var entries []gossipEntry
+	for key, counter := range n.counters {
+		counter.roundLocal = counter.local
+		counter.roundTotal = counter.total()
+		if counter.dirty || full && counter.local > 0 {
+			entries = append(entries, gossipEntry{Key: key.key, Period: key.period, Window: key.window, Count: counter.local})
+			counter.dirty = false
+		}
+	}
+	n.mu.Unlock()
+
+	if full {
+		n.resolvePeers()
+	}
+	if len(entries) == 0 && !full {
+		return
+	}
+	n.send(entries)
+}
+
+func (n *GossipNode) send(entries []gossipEntry) {
+	packets, err := n.encode(entries)
+	if err != nil {
+		logger.Errorf("encode limit-count gossip: %v", err)
+		return
+	}
+	n.mu.Lock()
+	addrs := n.addrs
+	n.mu.Unlock()
+	for _, addr := range addrs {
+		for _, packet := range packets {
+			if _, err := n.conn.WriteTo(packet, addr); err != nil {
+				logger.Debugf("send limit-count gossip to %s: %v", addr, err)
+			}
+		}
+	}
+}
+
+// encode splits the entries into packets that fit gossipMaxPacketSize. A
+// round without entries still sends one packet as a heartbeat.
+func (n *GossipNode) encode(entries []gossipEntry) ([][]byte, error) {
+	var packets [][]byte
+	for first := true; first || len(entries) > 0; first = false {
+		batch := len(entries)
+		for {
+			packet, err := n.seal(gossipMessage{Version: gossipProtocolVersion, Node: n.id, Entries: entries[:batch]})
+			if err != nil {
+				return nil, err
+			}
+			if len(packet) <= gossipMaxPacketSize || batch <= 1 {
+				packets = append(packets, packet)
+				break
+			}
+			batch /= 2
+		}
+		entries = entries[batch:]
+	}
+	return packets, nil
+}
+
+func (n *GossipNode) seal(message gossipMessage) ([]byte, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if n.secret == nil {
+		return body, nil
+	}
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(body)
+	return append(mac.Sum(nil), body...), nil
+}
+
+func (n *GossipNode) open(packet []byte) (gossipMessage, error) {
+	var message gossipMessage
+	if n.secret != nil {
+		if len(packet) < gossipMACSize {
+			return message, errors.New("packet is shorter than its MAC")
+		}
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(packet[gossipMACSize:])
+		if !hmac.Equal(mac.Sum(nil), packet[:gossipMACSize]) {
+			return message, errors.New("packet MAC does not match")
+		}
+		packet = packet[gossipMACSize:]
+	}
+	if err := json.Unmarshal(packet, &message); err != nil {
+		return message, err
+	}
+	if message.Version != gossipProtocolVersion {
+		return message, fmt.Errorf("unsupported protocol version %d", message.Version)
+	}
+	if message.Node == "" {
+		return message, errors.New("packet has no node ID")
+	}
+	return message, nil
+}
+
+func (n *GossipNode) receive() {
+	defer n.done.Done()
+	buffer := make([]byte, 64<<10)
+	for {
+		size, addr, err := n.conn.ReadFrom(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Debugf("read limit-count gossip: %v", err)
+			continue
+		}
+		message, err := n.open(buffer[:size])
+		if err != nil {
+			logger.Debugf("drop limit-count gossip from %s: %v", addr, err)
+			continue
+		}
+		if message.Node != n.id {
+			n.merge(message)
+		}
+	}
+}
+
+// merge keeps the highest count heard from the sender for every window that
+// has not ended. A sender beyond gossipMaxPeers is ignored.
+func (n *GossipNode) merge(message gossipMessage) {
+	now := n.now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.seen[message.Node]; !ok && len(n.seen) >= gossipMaxPeers {
+		logger.Debugf("drop limit-count gossip from node %s: %d peers are already tracked", message.Node, gossipMaxPeers)
+		return
+	}
+	n.seen[message.Node] = now
+	for _, entry := range message.Entries {
+		if entry.Period <= 0 || entry.Count < 0 {
+			continue
+		}
+		counter := n.counterLocked(gossipCounterKey{key: entry.Key, period: entry.Period, window: entry.Window}, now)
+		if counter == nil {
+			continue
+		}
+		if counter.peers == nil {
+			counter.peers = map[string]int64{}
+		}
+		if entry.Count > counter.peers[message.Node] {
+			counter.peers[message.Node] = entry.Count
+		}
+	}
+}
+
+// gossipStore is the limiter store of the gossip policy.
+type gossipStore struct {
+	node *GossipNode
+}
+
+func (s gossipStore) Get(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return s.Increment(ctx, key, 1, rate)
+}
+
+func (s gossipStore) Increment(
+	ctx context.Context,
+	key string,
+	count int64,
+	rate limiter.Rate,
+) (limiter.Context, error) {
+	total, reset, reached := s.node.count(key, count, rate.Limit, rate.Period)
+	return gossipContext(rate, total, reset, reached), nil
+}
+
+func (s gossipStore) Peek(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	total, reset, reached := s.node.count(key, 0, rate.Limit, rate.Period)
+	return gossipContext(rate, total, reset, reached), nil
+}
+
+// Reset is not supported: the peers keep the highest count they heard from
+// each node, so a lowered count would never reach them.
+func (s gossipStore) Reset(ctx context.Context, key string, rate limiter.Rate) (limiter.Context, error) {
+	return limiter.Context{}, errGossipResetUnsupported
+}
+
+func gossipContext(rate limiter.Rate, total int64, reset int64, reached bool) limiter.Context {
+	remaining := int64(0)
+	if !reached {
+		remaining = max(rate.Limit-total, 0)
+	}
+	return limiter.Context{Limit: rate.Limit, Remaining: remaining, Reset: reset, Reached: reached}
+}
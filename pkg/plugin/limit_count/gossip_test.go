@@ -0,0 +1,309 @@
+package limit_count
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	limiter "github.com/ulule/limiter/v3"
+)
+
+// gossipTestWindow is a day, so that a test does not cross an epoch-aligned
+// window unless it runs at midnight UTC.
+const gossipTestWindow = 86400
+
+func newGossipTestNodes(t *testing.T, count int, secrets ...string) []*GossipNode {
+	t.Helper()
+
+	nodes := make([]*GossipNode, count)
+	addrs := make([]string, count)
+	for i := range nodes {
+		config := GossipConfig{NodeID: fmt.Sprintf("node-%d", i), Listen: "127.0.0.1:0", SyncInterval: time.Hour}
+		if i < len(secrets) {
+			config.Secret = secrets[i]
+		}
+		node, err := NewGossipNode(config)
+		if err != nil {
+			t.Fatalf("NewGossipNode() error = %v", err)
+		}
+		t.Cleanup(func() { _ = node.Close() })
+		nodes[i] = node
+		addrs[i] = node.Addr()
+	}
+	for _, node := range nodes {
+		node.SetPeers(addrs)
+	}
+	return nodes
+}
+
+// syncUntil runs sync rounds on every node, as their sync loops would, until
+// done holds.
+func syncUntil(t *testing.T, nodes []*GossipNode, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("gossip nodes did not converge")
+		}
+		for _, node := range nodes {
+			node.sync()
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func allNodesLive(nodes []*GossipNode) func() bool {
+	return func() bool {
+		for _, node := range nodes {
+			node.mu.Lock()
+			live := node.live
+			node.mu.Unlock()
+			if live != int64(len(nodes)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func gossipRemaining(t *testing.T, node *GossipNode, key string, count int64) int64 {
+	t.Helper()
+
+	rate := limiter.Rate{Limit: count, Period: gossipTestWindow * time.Second}
+	state, err := gossipStore{node: node}.Peek(context.Background(), key, rate)
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	return state.Remaining
+}
+
+func newGossipTestHandler(t *testing.T, node *GossipNode, count int) http.Handler {
+	t.Helper()
+
+	p := newTestPlugin(t, Config{
+		Count:        count,
+		TimeWindow:   gossipTestWindow,
+		Key:          "shared",
+		KeyType:      "constant",
+		Policy:       "gossip",
+		RejectedCode: http.StatusTooManyRequests,
+	})
+	p.gossip = node
+	return p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func serveGossipRequest(handler http.Handler) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	return response
+}
+
+func TestGossipPolicyBoundsABurstOnEveryNodeToTheGlobalQuota(t *testing.T) {
+	nodes := newGossipTestNodes(t, 3)
+	syncUntil(t, nodes, allNodesLive(nodes))
+
+	admitted := make([]int, len(nodes))
+	handlers := make([]http.Handler, len(nodes))
+	for i, node := range nodes {
+		handlers[i] = newGossipTestHandler(t, node, 30)
+	}
+	for i, handler := range handlers {
+		for range 30 {
+			if serveGossipRequest(handler).Code == http.StatusNoContent {
+				admitted[i]++
+			}
+		}
+	}
+	for i, count := range admitted {
+		if count != 10 {
+			t.Fatalf("node %d admitted %d requests in one round, want its share of 10", i, count)
+		}
+	}
+
+	syncUntil(t, nodes, func() bool {
+		for _, node := range nodes {
+			if gossipRemaining(t, node, "route:unknown:shared", 30) != 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for i, handler := range handlers {
+		response := serveGossipRequest(handler)
+		if response.Code != http.StatusTooManyRequests ||
+			response.Header().Get("X-RateLimit-Limit") != "30" ||
+			response.Header().Get("X-RateLimit-Remaining") != "0" {
+			t.Fatalf("node %d response = %d %v, want the exhausted global quota", i, response.Code, response.Header())
+		}
+	}
+}
+
+func TestGossipPolicyConvergesAndReportsQuotaHeaders(t *testing.T) {
+	nodes := newGossipTestNodes(t, 3)
+	syncUntil(t, nodes, allNodesLive(nodes))
+	first := newGossipTestHandler(t, nodes[0], 30)
+	second := newGossipTestHandler(t, nodes[1], 30)
+
+	for range 5 {
+		if response := serveGossipRequest(first); response.Code != http.StatusNoContent {
+			t.Fatalf("first node response = %d, want admitted", response.Code)
+		}
+	}
+	syncUntil(t, nodes, func() bool {
+		return gossipRemaining(t, nodes[1], "route:unknown:shared", 30) == 25
+	})
+
+	response := serveGossipRequest(second)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("second node response = %d, want admitted", response.Code)
+	}
+	if got := response.Header().Get("X-RateLimit-Limit"); got != "30" {
+		t.Fatalf("X-RateLimit-Limit = %q, want 30", got)
+	}
+	if got := response.Header().Get("X-RateLimit-Remaining"); got != "24" {
+		t.Fatalf("X-RateLimit-Remaining = %q, want the quota left across nodes", got)
+	}
+	reset, err := strconv.ParseInt(response.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || reset <= 0 || reset > gossipTestWindow {
+		t.Fatalf("X-RateLimit-Reset = %q, want seconds left in the window", response.Header().Get("X-RateLimit-Reset"))
+	}
+}
+
+func TestGossipNodeDropsPacketsWithAnotherSecret(t *testing.T) {
+	nodes := newGossipTestNodes(t, 3, "secret", "secret", "other")
+	handler := newGossipTestHandler(t, nodes[0], 30)
+	for range 3 {
+		_ = serveGossipRequest(handler)
+	}
+
+	syncUntil(t, nodes, func() bool {
+		return gossipRemaining(t, nodes[1], "route:unknown:shared", 30) == 27
+	})
+	for range gossipFullSyncRounds {
+		nodes[0].sync()
+	}
+	if got := gossipRemaining(t, nodes[2], "route:unknown:shared", 30); got != 30 {
+		t.Fatalf("node with another secret remaining = %d, want its packets dropped", got)
+	}
+}
+
+func TestGossipNodeBoundsTrackedPeers(t *testing.T) {
+	node := &GossipNode{
+		id:       "node-0",
+		now:      time.Now,
+		counters: map[gossipCounterKey]*gossipCounter{},
+		capacity: defaultLocalStoreCapacity,
+		seen:     map[string]time.Time{},
+	}
+	window := time.Now().Unix() / 60 * 60
+	for i := range gossipMaxPeers + 1 {
+		node.merge(gossipMessage{
+			Version: gossipProtocolVersion,
+			Node:    "peer-" + strconv.Itoa(i),
+			Entries: []gossipEntry{{Key: "shared", Period: 60, Window: window, Count: 1}},
+		})
+	}
+	if len(node.seen) != gossipMaxPeers {
+		t.Fatalf("tracked peers = %d, want %d", len(node.seen), gossipMaxPeers)
+	}
+	counter := node.counters[gossipCounterKey{key: "shared", period: 60, window: window}]
+	if got := counter.total(); got != gossipMaxPeers {
+		t.Fatalf("total = %d, want the counts of the tracked peers only", got)
+	}
+}
+
+func TestGossipStoreRejectsReset(t *testing.T) {
+	nodes := newGossipTestNodes(t, 1)
+	rate := limiter.Rate{Period: gossipTestWindow * time.Second, Limit: 30}
+	if _, err := (gossipStore{node: nodes[0]}).Reset(context.Background(), "shared", rate); err == nil {
+		t.Fatal("Reset() error = nil, want reset unsupported by the gossip policy")
+	}
+}
+
+func TestNewGossipNodeRequiresSecretOffLoopback(t *testing.T) {
+	if _, err := NewGossipNode(GossipConfig{NodeID: "node-0", Listen: "0.0.0.0:0"}); err == nil {
+		t.Fatal("NewGossipNode() error = nil, want a secret required on a non-loopback address")
+	}
+}
+
+func TestGossipNodeSplitsLargeRoundsIntoPackets(t *testing.T) {
+	node := &GossipNode{id: "node-0", secret: []byte("secret")}
+	entries := make([]gossipEntry, 200)
+	for i := range entries {
+		entries[i] = gossipEntry{Key: "route:1:" + strings.Repeat("k", 20) + strconv.Itoa(i), Period: 60, Window: 120, Count: 1}
+	}
+
+	packets, err := node.encode(entries)
+	if err != nil {
+		t.Fatalf("encode() error = %v", err)
+	}
+	decoded := 0
+	for _, packet := range packets {
+		if len(packet) > gossipMaxPacketSize {
+			t.Fatalf("packet size = %d, want at most %d", len(packet), gossipMaxPacketSize)
+		}
+		message, err := node.open(packet)
+		if err != nil {
+			t.Fatalf("open() error = %v", err)
+		}
+		decoded += len(message.Entries)
+	}
+	if len(packets) < 2 || decoded != len(entries) {
+		t.Fatalf("packets = %d with %d entries, want %d entries split across packets", len(packets), decoded, len(entries))
+	}
+}
+
+func TestGossipConfigFromAttr(t *testing.T) {
+	config, ok, err := GossipConfigFromAttr(map[string]any{
+		"gossip": map[string]any{
+			"listen":         "0.0.0.0:7946",
+			"advertise":      "10.0.0.1:7946",
+			"peers":          []any{"10.0.0.2:7946"},
+			"etcd_discovery": true,
+			"sync_interval":  0.5,
+			"secret":         "shared",
+		},
+	})
+	if err != nil || !ok {
+		t.Fatalf("GossipConfigFromAttr() = %v, %v, want a config", ok, err)
+	}
+	if config.Advertise != "10.0.0.1:7946" || len(config.Peers) != 1 || config.SyncInterval != 500*time.Millisecond {
+		t.Fatalf("config = %+v", config)
+	}
+
+	if _, ok, err := GossipConfigFromAttr(map[string]any{"interval": 1}); ok || err != nil {
+		t.Fatalf("GossipConfigFromAttr() without gossip = %v, %v, want not configured", ok, err)
+	}
+	for name, section := range map[string]map[string]any{
+		"missing listen":       {"peers": []any{"10.0.0.2:7946"}},
+		"wildcard advertise":   {"listen": "0.0.0.0:7946", "etcd_discovery": true},
+		"short sync interval":  {"listen": "127.0.0.1:7946", "sync_interval": 0.001},
+		"invalid listen":       {"listen": "7946"},
+		"invalid etcd toggle":  {"listen": "127.0.0.1:7946", "etcd_discovery": "sometimes"},
+		"invalid sync setting": {"listen": "127.0.0.1:7946", "sync_interval": "often"},
+		"no secret off host":   {"listen": "0.0.0.0:7946"},
+		"no secret on port":    {"listen": ":7946"},
+	} {
+		if _, _, err := GossipConfigFromAttr(map[string]any{"gossip": section}); err == nil {
+			t.Fatalf("GossipConfigFromAttr(%s) error = nil, want rejected", name)
+		}
+	}
+}
+
+func TestPostInitRejectsSlidingGossipPolicy(t *testing.T) {
+	p := &Plugin{config: Config{Count: 1, TimeWindow: 60, Policy: "gossip", WindowType: "sliding"}}
+	if err := p.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := p.PostInit(); err == nil {
+		t.Fatal("PostInit() error = nil, want the sliding window rejected")
+	}
+}
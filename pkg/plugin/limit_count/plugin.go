@@ -42,6 +42,7 @@ type Plugin struct {
 	routeID           string
 	localLimiterStore limiter.Store
 	fixedStore        limiter.Store
+	gossip            *GossipNode
 	dynamicLimits     bool
 	groupRegistered   bool
 
@@ -172,7 +173,7 @@ const schema = `
 	  },
 	  "policy": {
 		"type": "string",
-		"enum": ["local", "redis", "redis-cluster", "redis-sentinel"],
+		"enum": ["local", "redis", "redis-cluster", "redis-sentinel", "gossip"],
 		"default": "local"
 	  },
 	  "window_type": {
@@ -311,6 +312,13 @@ const schema = `
 		  "required": ["policy"]
 		},
 		"then": {"required": ["redis_sentinels", "redis_master_name"]}
+	  },
+	  {
+		"if": {
+		  "properties": {"policy": {"const": "gossip"}},
+		  "required": ["policy"]
+		},
+		"then": {"properties": {"window_type": {"const": "fixed"}}}
 	  }
 	],
 	"definitions": {
@@ -547,6 +555,10 @@ func (p *Plugin) PostInit() error {
 		if p.config.RedisTimeout == 0 {
 			p.config.RedisTimeout = 1000
 		}
+	case "gossip":
+		if p.config.WindowType != "fixed" {
+			return fmt.Errorf("the gossip policy supports only the fixed window_type")
+		}
 	}
 
 	if p.config.AllowDegradation == nil {
@@ -595,6 +607,7 @@ func (p *Plugin) PostInit() error {
 	if countStatic && timeWindowStatic {
 		if p.config.SyncInterval > 0 &&
 			p.config.Policy != "local" &&
+			p.config.Policy != "gossip" &&
 			p.config.SyncInterval >= float64(timeWindow) {
 			p.releaseGroup()
 			return fmt.Errorf("sync_interval should be smaller than time_window")
@@ -851,6 +864,15 @@ func (p *Plugin) newLimiter(count int64, timeWindow int64) (*limiter.Limiter, er
 		if err != nil {
 			return nil, err
 		}
+	case "gossip":
+		node := p.gossip
+		if node == nil {
+			node = gossipNode.Load()
+		}
+		if node == nil {
+			return nil, errors.New("the gossip policy requires plugin_attr.limit-count.gossip")
+		}
+		store = gossipStore{node: node}
 	}
 
 	return limiter.New(store, rate, limiter.WithTrustForwardHeader(true)), nil
@@ -1252,7 +1274,10 @@ func (p *Plugin) Handler(next http.Handler) http.Handler {
 }
 
 func (p *Plugin) delayedSyncEnabled() bool {
-	return !p.dynamicLimits && p.config.Policy != "local" && p.config.SyncInterval > 0
+	return !p.dynamicLimits &&
+		p.config.Policy != "local" &&
+		p.config.Policy != "gossip" &&
+		p.config.SyncInterval > 0
 }
 
 func (p *Plugin) delayedSyncEnabledFor(timeWindow int64) bool {
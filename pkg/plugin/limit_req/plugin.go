@@ -5,14 +5,12 @@ import (
 	"math"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
-	"github.com/wklken/apisix-go/pkg/plugin/cacheutil"
 	"github.com/wklken/apisix-go/pkg/plugin/limitbase"
 	"github.com/wklken/apisix-go/pkg/resource"
 	"github.com/wklken/apisix-go/pkg/shared"
@@ -23,28 +21,26 @@ type Plugin struct {
 	base.BasePlugin
 	config Config
 
-	mu      sync.Mutex
-	buckets *cacheutil.BoundedTTLMap[*bucket]
-	now     func() time.Time
+	// Local buckets live in the plugin-limit-req zone, so a route keeps its
+	// quota across route reloads and consumers with the same limits share one
+	// bucket across routes.
+	zone *shared.Dict
+	now  func() time.Time
 
-	bucketTTL time.Duration
+	bucketTTL     time.Duration
+	consumerScope string
 
 	redisLimiter reqLimiter
 	routeID      string
 
-	clientRelease    func()
-	consumerStoreMu  sync.Mutex
-	consumerStoreKey string
-	consumerStore    *bucketStore
+	clientRelease func()
 }
 
-// defaultLocalBucketsCapacity bounds the number of in-memory local-policy
-// buckets; the earliest expiring buckets are evicted once the bound is hit.
-var defaultLocalBucketsCapacity = 100000
-
 const (
 	priority = 1001
 	name     = "limit-req"
+
+	zoneName = "plugin-limit-req"
 )
 
 const schema = `
@@ -210,25 +206,10 @@ type bucket struct {
 	last   time.Time
 }
 
-type bucketStore struct {
-	mu      sync.Mutex
-	buckets *cacheutil.BoundedTTLMap[*bucket]
-}
-
 type reqLimiter interface {
 	incoming(key string, rate float64, burst float64) (time.Duration, bool, error)
 }
 
-type consumerBucketEntry struct {
-	store *bucketStore
-	refs  int
-}
-
-var consumerBucketStores = struct {
-	sync.Mutex
-	entries map[string]consumerBucketEntry
-}{entries: map[string]consumerBucketEntry{}}
-
 const redisLimitReqScript = `
 local state = redis.call("HMGET", KEYS[1], "excess", "last")
 local excess = tonumber(state[1]) or 0
@@ -366,19 +347,18 @@ func (p *Plugin) PostInit() error {
 	if p.now == nil {
 		p.now = time.Now
 	}
-	if p.buckets == nil {
-		p.buckets = cacheutil.NewBoundedTTLMap[*bucket](
-			defaultLocalBucketsCapacity,
-			func() time.Time { return p.now() },
-		)
+	if p.zone == nil {
+		p.zone = shared.Zone(zoneName)
 	}
+	uid := shared.NewConfigUID()
+	uid.Add(p.config.Rate, p.config.Burst, p.config.Key, p.config.KeyType)
+	p.consumerScope = uid.String()
 	p.bucketTTL = max(time.Duration(math.Ceil((p.config.Burst+1)/p.config.Rate))*time.Second, time.Second)
 
 	return nil
 }
 
 func (p *Plugin) Stop() {
-	p.releaseConsumerBucketStore()
 	if p.clientRelease != nil {
 		p.clientRelease()
 		p.clientRelease = nil
@@ -438,46 +418,43 @@ func (p *Plugin) Handler(next http.Handler) http.Handler {
 }
 
 func (p *Plugin) incomingWithConsumer(key string, consumerName string) (time.Duration, bool, error) {
-	if consumerName == "" {
-		key = p.scopedKey(key)
-	} else {
-		key = "consumer:" + consumerName + ":" + key
-	}
 	if p.config.Policy == "redis" || p.config.Policy == "redis-cluster" {
+		if consumerName == "" {
+			key = p.scopedKey(key)
+		} else {
+			key = "consumer:" + consumerName + ":" + key
+		}
 		return p.redisLimiter.incoming(key, p.config.Rate, p.config.Burst)
 	}
 
-	mu := &p.mu
-	var buckets *cacheutil.BoundedTTLMap[*bucket]
-	if consumerName != "" {
-		store := p.consumerBucketStore()
-		mu = &store.mu
-		buckets = store.buckets
+	// Consumer buckets are shared by every route whose limits match.
+	if consumerName == "" {
+		key = p.scopedKey(key)
 	} else {
-		buckets = p.buckets
+		key = "consumer:" + consumerName + ":" + p.consumerScope + ":" + key
 	}
-	mu.Lock()
-	defer mu.Unlock()
 
 	now := p.now()
-	b, ok := buckets.Get(key)
-	if !ok {
-		b = &bucket{last: now}
-	}
-
-	elapsed := now.Sub(b.last).Seconds()
-	next := &bucket{
-		excess: math.Max(0, b.excess-elapsed*p.config.Rate) + 1,
-		last:   now,
-	}
-
-	maxExcess := p.config.Burst + 1
-	if next.excess > maxExcess {
-		return 0, false, nil
+	var excess float64
+	allowed := true
+	err := p.zone.Update(key, p.bucketTTL, func(value any, ok bool) (any, bool) {
+		b, _ := value.(bucket)
+		if !ok {
+			b.last = now
+		}
+		elapsed := now.Sub(b.last).Seconds()
+		excess = math.Max(0, b.excess-elapsed*p.config.Rate) + 1
+		if excess > p.config.Burst+1 {
+			allowed = false
+			return nil, false
+		}
+		return bucket{excess: excess, last: now}, true
+	})
+	if err != nil || !allowed {
+		return 0, false, err
 	}
-	buckets.Set(key, next, p.bucketTTL)
 
-	delaySeconds := (next.excess - 1) / p.config.Rate
+	delaySeconds := (excess - 1) / p.config.Rate
 	if delaySeconds <= 0 {
 		return 0, true, nil
 	}
@@ -485,60 +462,6 @@ func (p *Plugin) incomingWithConsumer(key string, consumerName string) (time.Dur
 	return time.Duration(delaySeconds * float64(time.Second)), true, nil
 }
 
-func (p *Plugin) consumerBucketStore() *bucketStore {
-	p.consumerStoreMu.Lock()
-	defer p.consumerStoreMu.Unlock()
-	if p.consumerStore != nil {
-		return p.consumerStore
-	}
-
-	uid := shared.NewConfigUID()
-	uid.Add(p.config.Rate, p.config.Burst, p.config.Key, p.config.KeyType)
-	key := uid.String()
-	consumerBucketStores.Lock()
-	entry, ok := consumerBucketStores.entries[key]
-	if !ok {
-		entry = consumerBucketEntry{
-			store: &bucketStore{
-				buckets: cacheutil.NewBoundedTTLMap[*bucket](
-					defaultLocalBucketsCapacity,
-					func() time.Time { return p.now() },
-				),
-			},
-		}
-	}
-	entry.refs++
-	consumerBucketStores.entries[key] = entry
-	consumerBucketStores.Unlock()
-
-	p.consumerStoreKey = key
-	p.consumerStore = entry.store
-	return p.consumerStore
-}
-
-func (p *Plugin) releaseConsumerBucketStore() {
-	p.consumerStoreMu.Lock()
-	key, store := p.consumerStoreKey, p.consumerStore
-	p.consumerStoreKey = ""
-	p.consumerStore = nil
-	p.consumerStoreMu.Unlock()
-	if key == "" || store == nil {
-		return
-	}
-
-	consumerBucketStores.Lock()
-	entry, ok := consumerBucketStores.entries[key]
-	if ok && entry.store == store {
-		entry.refs--
-		if entry.refs <= 0 {
-			delete(consumerBucketStores.entries, key)
-		} else {
-			consumerBucketStores.entries[key] = entry
-		}
-	}
-	consumerBucketStores.Unlock()
-}
-
 func (p *Plugin) resolveKey(r *http.Request) string {
 	var key string
 	if p.config.KeyType == "var_combination" {
@@ -13,13 +13,14 @@ import (
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/shared"
 	"github.com/wklken/apisix-go/pkg/util"
 )
 
 func newTestPlugin(t *testing.T, cfg Config) *Plugin {
 	t.Helper()
 
-	p := &Plugin{config: cfg}
+	p := &Plugin{config: cfg, zone: shared.NewDict(1 << 20)}
 	if err := p.Init(); err != nil {
 		t.Fatalf("Init() error = %v", err)
 	}
@@ -228,6 +229,15 @@ func TestConsumerLimiterSharesQuotaAcrossRouteInstancesAndIsolatesConsumers(t *t
 	first.SetResourceContext(resource.Route{ID: "route-1"}, resource.Service{})
 	second := newTestPlugin(t, config)
 	second.SetResourceContext(resource.Route{ID: "route-2"}, resource.Service{})
+	second.zone = first.zone
+	other := newTestPlugin(t, Config{
+		Rate:         1,
+		Burst:        3,
+		Key:          "remote_addr",
+		RejectedCode: http.StatusTooManyRequests,
+		Nodelay:      new(true),
+	})
+	other.zone = first.zone
 	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	})
@@ -255,54 +265,42 @@ func TestConsumerLimiterSharesQuotaAcrossRouteInstancesAndIsolatesConsumers(t *t
 	if got := request(second, "isolated-limit-req-consumer").Code; got != http.StatusNoContent {
 		t.Fatalf("different consumer response = %d, want isolated quota %d", got, http.StatusNoContent)
 	}
-}
-
-func TestConsumerBucketStoreReleasesLastOwner(t *testing.T) {
-	resetConsumerBucketStoresForTest()
-	t.Cleanup(resetConsumerBucketStoresForTest)
-
-	config := Config{Rate: 1, Burst: 1, Key: "remote_addr"}
-	first := newTestPlugin(t, config)
-	second := newTestPlugin(t, config)
-	if store := first.consumerBucketStore(); store == nil {
-		t.Fatal("first consumer bucket store is nil")
-	} else if store != second.consumerBucketStore() {
-		t.Fatal("identical consumer configurations did not share a bucket store")
+	if got := request(other, "shared-limit-req-consumer").Code; got != http.StatusNoContent {
+		t.Fatalf("different limits response = %d, want isolated quota %d", got, http.StatusNoContent)
 	}
+}
 
-	key := first.consumerStoreKey
-	consumerBucketStores.Lock()
-	entry, ok := consumerBucketStores.entries[key]
-	consumerBucketStores.Unlock()
-	if !ok || entry.refs != 2 {
-		t.Fatalf("consumer bucket entry = %#v/%t, want refs=2", entry, ok)
+func TestLocalBucketsSurviveRouteReload(t *testing.T) {
+	config := Config{
+		Rate:         1,
+		Burst:        0,
+		Key:          "remote_addr",
+		RejectedCode: http.StatusTooManyRequests,
+		Nodelay:      new(true),
 	}
-
-	neverUsed := newTestPlugin(t, config)
-	neverUsed.Stop()
-	consumerBucketStores.Lock()
-	entry, ok = consumerBucketStores.entries[key]
-	consumerBucketStores.Unlock()
-	if !ok || entry.refs != 2 {
-		t.Fatalf("entry after unused owner Stop = %#v/%t, want refs=2", entry, ok)
+	previous := newTestPlugin(t, config)
+	previous.SetResourceContext(resource.Route{ID: "route-1"}, resource.Service{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if got := performRequest(previous.Handler(next), "192.0.2.50:1234").Code; got != http.StatusNoContent {
+		t.Fatalf("first response = %d, want %d", got, http.StatusNoContent)
 	}
+	previous.Stop()
 
-	first.Stop()
-	consumerBucketStores.Lock()
-	entry, ok = consumerBucketStores.entries[key]
-	consumerBucketStores.Unlock()
-	if !ok || entry.refs != 1 {
-		t.Fatalf("entry after first Stop = %#v/%t, want refs=1", entry, ok)
+	reloaded := newTestPlugin(t, config)
+	reloaded.SetResourceContext(resource.Route{ID: "route-1"}, resource.Service{})
+	reloaded.zone = previous.zone
+	if got := performRequest(reloaded.Handler(next), "192.0.2.50:1234").Code; got != http.StatusTooManyRequests {
+		t.Fatalf("reloaded route response = %d, want kept quota rejection %d", got, http.StatusTooManyRequests)
 	}
 
-	second.Stop()
-	consumerBucketStores.Lock()
-	_, ok = consumerBucketStores.entries[key]
-	consumerBucketStores.Unlock()
-	if ok {
-		t.Fatal("entry remains after final owner Stop")
+	otherRoute := newTestPlugin(t, config)
+	otherRoute.SetResourceContext(resource.Route{ID: "route-2"}, resource.Service{})
+	otherRoute.zone = previous.zone
+	if got := performRequest(otherRoute.Handler(next), "192.0.2.50:1234").Code; got != http.StatusNoContent {
+		t.Fatalf("other route response = %d, want route-scoped quota %d", got, http.StatusNoContent)
 	}
-	second.Stop()
 }
 
 func TestConsumerRedisLimiterUsesConsumerScopeInsteadOfRouteScope(t *testing.T) {
@@ -336,12 +334,6 @@ func TestConsumerRedisLimiterUsesConsumerScopeInsteadOfRouteScope(t *testing.T)
 	}
 }
 
-func resetConsumerBucketStoresForTest() {
-	consumerBucketStores.Lock()
-	consumerBucketStores.entries = map[string]consumerBucketEntry{}
-	consumerBucketStores.Unlock()
-}
-
 func TestResolveKeyLogsFallbackToClientIP(t *testing.T) {
 	p := &Plugin{config: Config{
 		Key:     "$http_a $http_b",
@@ -591,14 +583,12 @@ func (f *fakeRedisLimiter) incoming(key string, rate float64, burst float64) (ti
 	return f.delay, f.allowed, f.err
 }
 
-func TestLimitReqLocalBucketsEvictOldestAndExpired(t *testing.T) {
-	original := defaultLocalBucketsCapacity
-	defaultLocalBucketsCapacity = 4
-	t.Cleanup(func() { defaultLocalBucketsCapacity = original })
-
+func TestLimitReqLocalBucketsEvictLeastRecentlyUsedAndDrain(t *testing.T) {
 	base := time.Date(2026, 7, 6, 1, 2, 3, 0, time.UTC)
 	p := newTestPlugin(t, Config{Rate: 10, Burst: 20, Policy: "local"})
 	p.now = func() time.Time { return base }
+	// The zone fits four buckets keyed "user-N".
+	p.zone = shared.NewDict(4 * (int64(len("user-0")) + 16 + 64))
 
 	for i := range 6 {
 		_, allowed, err := p.incomingWithConsumer("user-"+strconv.Itoa(i), "")
@@ -611,8 +601,8 @@ func TestLimitReqLocalBucketsEvictOldestAndExpired(t *testing.T) {
 	}
 
 	// Active keys preserve their counters: user-2 already consumed once.
-	// Checked before touching user-0, whose re-insertion would evict the
-	// oldest remaining live bucket (user-2) at capacity.
+	// Checked before touching user-0, whose re-insertion evicts the least
+	// recently used bucket.
 	delay, _, err := p.incomingWithConsumer("user-2", "")
 	if err != nil {
 		t.Fatalf("incoming user-2: %v", err)
@@ -621,8 +611,8 @@ func TestLimitReqLocalBucketsEvictOldestAndExpired(t *testing.T) {
 		t.Fatalf("active key user-2 lost its counter, delay = %v", delay)
 	}
 
-	// Capacity 4 was exceeded, so the two oldest buckets were evicted and
-	// user-0 restarts from a fresh counter.
+	// The zone was full, so the two least recently used buckets were evicted
+	// and user-0 restarts from a fresh counter.
 	delay, _, err = p.incomingWithConsumer("user-0", "")
 	if err != nil {
 		t.Fatalf("incoming user-0 after eviction: %v", err)
@@ -631,14 +621,14 @@ func TestLimitReqLocalBucketsEvictOldestAndExpired(t *testing.T) {
 		t.Fatalf("evicted key user-0 delay = %v, want 0", delay)
 	}
 
-	// Advancing past the bucket TTL expires user-5 and resets its counter.
+	// An idle bucket drains at the configured rate, so user-5 starts over.
 	p.now = func() time.Time { return base.Add(time.Hour) }
 	delay, _, err = p.incomingWithConsumer("user-5", "")
 	if err != nil {
-		t.Fatalf("incoming user-5 after expiry: %v", err)
+		t.Fatalf("incoming user-5 after an idle hour: %v", err)
 	}
 	if delay != 0 {
-		t.Fatalf("expired key user-5 delay = %v, want 0", delay)
+		t.Fatalf("idle key user-5 delay = %v, want 0", delay)
 	}
 }
 
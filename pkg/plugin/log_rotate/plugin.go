@@ -15,7 +15,6 @@ import (
 	"time"
 
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
-	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
@@ -228,10 +227,11 @@ func (p *Plugin) reopenLogFiles() error {
 }
 
 func (p *Plugin) loadPluginAttr() {
-	if config.GlobalConfig == nil || config.GlobalConfig.PluginAttr == nil {
+	cfg := p.AppConfig()
+	if cfg == nil || cfg.PluginAttr == nil {
 		return
 	}
-	attr, ok := config.GlobalConfig.PluginAttr[name]
+	attr, ok := cfg.PluginAttr[name]
 	if !ok {
 		return
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -48,10 +49,19 @@ type Config struct {
 
 	RouteID    string
 	ServerAddr string
+
+	// Spool, when set with a directory, writes every accepted entry through an
+	// on-disk spool under Dir/<plugin>/<route>, within the MaxBytes budget
+	// shared by every spool under Dir. Entries beyond
+	// MaxPendingEntries wait on disk instead of being dropped, and a batch that
+	// exhausts MaxRetryCount stays spooled and is retried until it is
+	// delivered or evicted.
+	Spool *SpoolConfig
 }
 
 type workBatch struct {
 	entries  []map[string]any
+	refs     []spoolRef
 	terminal bool
 }
 
@@ -62,6 +72,7 @@ type Processor struct {
 	deliveryCtx context.Context
 	cancel      context.CancelFunc
 	observer    metrics.LoggerBatchObserver
+	spool       *spool
 
 	mu          sync.Mutex
 	cond        *sync.Cond
@@ -83,6 +94,8 @@ type Processor struct {
 	shutdownErr      error
 
 	buffer      []map[string]any
+	bufferRefs  []spoolRef
+	replaying   bool
 	firstEntry  time.Time
 	lastEntry   time.Time
 	pending     int
@@ -90,6 +103,8 @@ type Processor struct {
 	dropped     int
 	delivered   int
 	failedDrops int
+
+	spoolEvicted int
 }
 
 func New(config Config, deliver DeliveryFunc) *Processor {
@@ -122,6 +137,19 @@ func NewWithContext(config Config, deliver ContextDeliveryFunc) *Processor {
 		workersDone:  make(chan struct{}),
 	}
 	p.cond = sync.NewCond(&p.mu)
+	if config.Spool != nil && config.Spool.Dir != "" {
+		pluginID := config.PluginID
+		if pluginID == "" {
+			pluginID = config.Name
+		}
+		dir := spoolDir(config.Spool.Dir, pluginID, config.RouteID)
+		opened, err := acquireSpool(config.Spool.Dir, dir, *config.Spool)
+		if err != nil {
+			logger.Errorf("logger batch processor [%s] spool disabled: %s", config.Name, err)
+		} else {
+			p.spool = opened
+		}
+	}
 	p.wg.Add(p.workerCount)
 	for i := 0; i < p.workerCount; i++ {
 		go p.worker()
@@ -138,6 +166,23 @@ func (p *Processor) Push(entry map[string]any) bool {
 		p.observer.AddEvent(metrics.LoggerBatchOutcomeStoppedDropped)
 		return false
 	}
+
+	var ref spoolRef
+	if p.spool != nil {
+		var claimed bool
+		var evicted int
+		var err error
+		ref, claimed, evicted, err = p.spool.append(entry, p.pending < p.config.MaxPendingEntries)
+		p.addEvictedLocked(evicted)
+		switch {
+		case err != nil:
+			logger.Errorf("logger batch processor [%s] spool append: %s", p.config.Name, err)
+		case !claimed:
+			// The entry waits on disk behind older records; a worker replays it.
+			p.cond.Broadcast()
+			return true
+		}
+	}
 	if p.pending >= p.config.MaxPendingEntries {
 		p.dropped++
 		p.observer.AddEvent(metrics.LoggerBatchOutcomeCapacityDropped)
@@ -156,6 +201,7 @@ func (p *Processor) Push(entry map[string]any) bool {
 	}
 	p.lastEntry = now
 	p.buffer = append(p.buffer, entry)
+	p.bufferRefs = append(p.bufferRefs, ref)
 	p.pending++
 	p.observer.AddPending(1)
 	p.setBufferedMetricLocked()
@@ -247,6 +293,9 @@ func (p *Processor) startShutdown() {
 		p.waitOnce.Do(func() {
 			go func() {
 				p.wg.Wait()
+				if p.spool != nil {
+					p.spool.release()
+				}
 				close(p.workersDone)
 				p.finishShutdown(nil)
 			}()
@@ -295,7 +344,13 @@ func (p *Processor) finishShutdown(err error) {
 func (p *Processor) worker() {
 	defer p.wg.Done()
 	for {
-		batch := p.nextBatch()
+		batch, replay := p.nextBatch()
+		if replay {
+			batch = p.replay()
+			if batch == nil {
+				continue
+			}
+		}
 		if batch == nil {
 			return
 		}
@@ -303,19 +358,62 @@ func (p *Processor) worker() {
 	}
 }
 
-func (p *Processor) nextBatch() *workBatch {
+func (p *Processor) nextBatch() (*workBatch, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for len(p.ready) == 0 && !p.stopped {
+		if p.canReplayLocked() {
+			p.replaying = true
+			return nil, true
+		}
 		p.cond.Wait()
 	}
 	if len(p.ready) == 0 {
-		return nil
+		return nil, false
 	}
 	batch := p.ready[0]
 	p.ready[0] = nil
 	p.ready = p.ready[1:]
 	p.active[batch] = struct{}{}
+	return batch, false
+}
+
+func (p *Processor) canReplayLocked() bool {
+	return p.spool != nil && !p.replaying && p.pending < p.config.MaxPendingEntries && p.spool.backlog() > 0
+}
+
+// replay claims the oldest records waiting in the spool as the next batch.
+// Only one worker replays at a time so that batches keep the spool order.
+func (p *Processor) replay() *workBatch {
+	p.mu.Lock()
+	limit := min(p.config.BatchMaxSize, p.config.MaxPendingEntries-p.pending)
+	p.mu.Unlock()
+
+	records := p.spool.claim(limit)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replaying = false
+	p.cond.Broadcast()
+	if len(records) == 0 {
+		return nil
+	}
+	batch := &workBatch{
+		entries: make([]map[string]any, len(records)),
+		refs:    make([]spoolRef, len(records)),
+	}
+	for i, record := range records {
+		batch.entries[i] = record.entry
+		batch.refs[i] = record.ref
+	}
+	if p.stopped && p.shutdownAborted {
+		p.spool.giveBack(records)
+		return nil
+	}
+	p.pending += len(records)
+	p.processing += len(records)
+	p.observer.AddPending(len(records))
+	p.active[batch] = struct{}{}
 	return batch
 }
 
@@ -329,6 +427,9 @@ func (p *Processor) process(batch *workBatch) {
 		entries := append([]map[string]any(nil), batch.entries...)
 		p.mu.Unlock()
 
+		if attempt == 0 && p.spool != nil {
+			p.spool.sync()
+		}
 		attemptCtx, cancel := context.WithTimeout(p.deliveryCtx, p.config.DeliveryTimeout)
 		var firstFail int
 		var err error
@@ -364,6 +465,27 @@ func (p *Processor) process(batch *workBatch) {
 			timedOut := errors.Is(err, context.DeadlineExceeded) ||
 				errors.Is(err, os.ErrDeadlineExceeded) ||
 				errors.Is(attemptErr, context.DeadlineExceeded)
+			if p.spool != nil {
+				if timedOut {
+					p.observer.AddEvent(metrics.LoggerBatchOutcomeDeliveryTimeout)
+				} else {
+					p.observer.AddEvent(metrics.LoggerBatchOutcomeDeliveryFailed)
+				}
+				logger.Errorf(
+					"logger batch processor [%s] exceeded max_retry_count [%d], keeping %d entries in spool: %s",
+					p.config.Name,
+					p.config.MaxRetryCount,
+					len(entries),
+					err,
+				)
+				retry := p.retainLocked(batch)
+				p.mu.Unlock()
+				if !retry || !p.waitRetry() {
+					return
+				}
+				attempt = -1
+				continue
+			}
 			p.finishBatchLocked(batch, len(entries), false)
 			if timedOut {
 				p.observer.AddEvent(metrics.LoggerBatchOutcomeDeliveryTimeout)
@@ -407,6 +529,69 @@ func (p *Processor) waitRetry() bool {
 	}
 }
 
+// retainLocked keeps a batch that exhausted its retries in the spool. Entries
+// that were never spooled or whose segment was evicted are dropped. While the
+// processor runs, the rest is retried after retry_delay; once it is stopped,
+// the records are handed back to the spool for the next processor.
+func (p *Processor) retainLocked(batch *workBatch) bool {
+	kept := 0
+	for i, ref := range batch.refs {
+		if ref.segment != 0 && p.spool.retained(ref) {
+			batch.entries[kept], batch.refs[kept] = batch.entries[i], ref
+			kept++
+		}
+	}
+	if dropped := len(batch.entries) - kept; dropped > 0 {
+		clear(batch.entries[kept:])
+		p.decrementPendingLocked(dropped)
+		p.processing = max(p.processing-dropped, 0)
+		p.failedDrops += dropped
+	}
+	batch.entries, batch.refs = batch.entries[:kept], batch.refs[:kept]
+	if kept > 0 && !p.stopped {
+		return true
+	}
+	p.giveBackLocked(batch.entries, batch.refs)
+	p.decrementPendingLocked(kept)
+	p.processing = max(p.processing-kept, 0)
+	batch.entries, batch.refs = nil, nil
+	batch.terminal = true
+	delete(p.active, batch)
+	return false
+}
+
+// giveBackLocked returns the spooled entries to the spool and reports how many
+// entries were not spooled.
+func (p *Processor) giveBackLocked(entries []map[string]any, refs []spoolRef) int {
+	if p.spool == nil || len(refs) != len(entries) {
+		return len(entries)
+	}
+	records := make([]spoolRecord, 0, len(entries))
+	for i, ref := range refs {
+		if ref.segment != 0 {
+			records = append(records, spoolRecord{ref: ref, entry: entries[i]})
+		}
+	}
+	p.spool.giveBack(records)
+	return len(entries) - len(records)
+}
+
+func (p *Processor) ackLocked(refs []spoolRef) {
+	if p.spool == nil || len(refs) == 0 {
+		return
+	}
+	p.spool.ack(slices.DeleteFunc(slices.Clone(refs), func(ref spoolRef) bool { return ref.segment == 0 }))
+}
+
+func (p *Processor) addEvictedLocked(count int) {
+	if count <= 0 {
+		return
+	}
+	p.spoolEvicted += count
+	p.observer.AddEvents(metrics.LoggerBatchOutcomeSpoolEvicted, count)
+	logger.Errorf("logger batch processor [%s] spool is full, evicted %d undelivered entries", p.config.Name, count)
+}
+
 func (p *Processor) finishBatchLocked(batch *workBatch, count int, delivered bool) {
 	if batch.terminal {
 		return
@@ -417,6 +602,7 @@ func (p *Processor) finishBatchLocked(batch *workBatch, count int, delivered boo
 		delete(p.active, batch)
 		return
 	}
+	p.ackLocked(batchRefs(batch, count))
 	p.decrementPendingLocked(count)
 	p.processing -= count
 	if p.processing < 0 {
@@ -428,6 +614,9 @@ func (p *Processor) finishBatchLocked(batch *workBatch, count int, delivered boo
 		p.failedDrops += count
 	}
 	batch.entries = batch.entries[count:]
+	if batch.refs != nil {
+		batch.refs = batch.refs[count:]
+	}
 	if len(batch.entries) == 0 {
 		batch.terminal = true
 		delete(p.active, batch)
@@ -439,6 +628,7 @@ func (p *Processor) completePrefixLocked(batch *workBatch, count int) {
 	if count <= 0 {
 		return
 	}
+	p.ackLocked(batchRefs(batch, count))
 	p.decrementPendingLocked(count)
 	p.processing -= count
 	if p.processing < 0 {
@@ -446,6 +636,16 @@ func (p *Processor) completePrefixLocked(batch *workBatch, count int) {
 	}
 	p.delivered += count
 	batch.entries = batch.entries[count:]
+	if batch.refs != nil {
+		batch.refs = batch.refs[count:]
+	}
+}
+
+func batchRefs(batch *workBatch, count int) []spoolRef {
+	if len(batch.refs) < count {
+		return nil
+	}
+	return batch.refs[:count]
 }
 
 func (p *Processor) decrementPendingLocked(count int) {
@@ -455,33 +655,43 @@ func (p *Processor) decrementPendingLocked(count int) {
 	p.pending -= count
 	if count > 0 {
 		p.observer.AddPending(-count)
+		if p.spool != nil {
+			p.cond.Broadcast()
+		}
 	}
 }
 
+// dropUndispatchedLocked drops every entry that was not delivered yet. Spooled
+// entries stay on disk and are handed back to the spool; only the others
+// count as dropped.
 func (p *Processor) dropUndispatchedLocked() int {
-	dropped := len(p.buffer)
+	released := len(p.buffer)
+	dropped := p.giveBackLocked(p.buffer, p.bufferRefs)
 	p.buffer = p.buffer[:0]
+	p.bufferRefs = p.bufferRefs[:0]
 	p.firstEntry = time.Time{}
 	p.lastEntry = time.Time{}
 	p.setBufferedMetricLocked()
 	for _, batch := range p.ready {
 		if batch != nil && !batch.terminal {
 			batch.terminal = true
-			dropped += len(batch.entries)
-			batch.entries = nil
+			released += len(batch.entries)
+			dropped += p.giveBackLocked(batch.entries, batch.refs)
+			batch.entries, batch.refs = nil, nil
 		}
 	}
 	p.ready = nil
 	for batch := range p.active {
 		if !batch.terminal {
 			batch.terminal = true
-			dropped += len(batch.entries)
-			batch.entries = nil
+			released += len(batch.entries)
+			dropped += p.giveBackLocked(batch.entries, batch.refs)
+			batch.entries, batch.refs = nil, nil
 		}
 		delete(p.active, batch)
 	}
-	if dropped > 0 {
-		p.decrementPendingLocked(dropped)
+	if released > 0 {
+		p.decrementPendingLocked(released)
 		p.processing = 0
 		p.failedDrops += dropped
 	}
@@ -534,7 +744,11 @@ func (p *Processor) flushLocked() {
 		return
 	}
 	batch := &workBatch{entries: append([]map[string]any(nil), p.buffer...)}
+	if p.spool != nil {
+		batch.refs = append([]spoolRef(nil), p.bufferRefs...)
+	}
 	p.buffer = p.buffer[:0]
+	p.bufferRefs = p.bufferRefs[:0]
 	p.firstEntry = time.Time{}
 	p.lastEntry = time.Time{}
 	p.setBufferedMetricLocked()
@@ -609,17 +823,28 @@ type Stats struct {
 	Dropped     int
 	Delivered   int
 	FailedDrops int
+
+	// Spooled is the number of entries waiting on disk to be replayed and
+	// SpoolEvicted the number of undelivered entries dropped with evicted
+	// spool segments.
+	Spooled      int
+	SpoolEvicted int
 }
 
 func (p *Processor) Stats() Stats {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return Stats{
-		Pending:     p.pending,
-		Processing:  p.processing,
-		Buffered:    len(p.buffer),
-		Dropped:     p.dropped,
-		Delivered:   p.delivered,
-		FailedDrops: p.failedDrops,
-	}
+	stats := Stats{
+		Pending:      p.pending,
+		Processing:   p.processing,
+		Buffered:     len(p.buffer),
+		Dropped:      p.dropped,
+		Delivered:    p.delivered,
+		FailedDrops:  p.failedDrops,
+		SpoolEvicted: p.spoolEvicted,
+	}
+	if p.spool != nil {
+		stats.Spooled = p.spool.backlog()
+	}
+	return stats
 }
@@ -0,0 +1,709 @@
+package logger_batch
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	SpoolFsyncAlways = "always"
+	SpoolFsyncBatch  = "batch"
+	SpoolFsyncNever  = "never"
+
+	DefaultSpoolMaxBytes     = 256 << 20
+	DefaultSpoolSegmentBytes = 8 << 20
+
+	spoolSegmentSuffix = ".log"
+	spoolHeaderSize    = 8
+)
+
+// SpoolConfig is the optional on-disk write-ahead spool of a processor. Every
+// accepted entry is appended to a segment file before it is buffered, and
+// entries that were not delivered when the process stopped are replayed in
+// order by the next processor that opens the same directory. MaxBytes bounds
+// every spool under Dir together, including those left on disk by routes that
+// no processor has open.
+type SpoolConfig struct {
+	Dir          string `json:"dir"`
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	SegmentBytes int64  `json:"segment_bytes,omitempty"`
+	Fsync        string `json:"fsync,omitempty"`
+}
+
+func (c *SpoolConfig) applyDefaults() {
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultSpoolMaxBytes
+	}
+	if c.SegmentBytes <= 0 {
+		c.SegmentBytes = DefaultSpoolSegmentBytes
+	}
+	// Eviction works on whole segments, so keep at least two per spool.
+	c.SegmentBytes = min(c.SegmentBytes, max(c.MaxBytes/2, 1))
+	switch c.Fsync {
+	case SpoolFsyncAlways, SpoolFsyncBatch, SpoolFsyncNever:
+	default:
+		c.Fsync = SpoolFsyncBatch
+	}
+}
+
+// spoolRef locates one record; segment zero means the entry was not spooled.
+type spoolRef struct {
+	segment uint64
+	index   int
+}
+
+type spoolRecord struct {
+	ref   spoolRef
+	entry map[string]any
+}
+
+type spoolSegment struct {
+	id      uint64
+	path    string
+	size    int64
+	records int
+	acked   int
+	claimed int
+	sealed  bool
+}
+
+// spool is a directory of append-only segment files. Records are claimed in
+// append order, either directly by Push or by a replaying worker, and a sealed
+// segment is removed once every record in it is acknowledged. A segment that
+// was only partly acknowledged when the process stopped is replayed in full,
+// so delivery through the spool is at least once.
+type spool struct {
+	dir  string
+	refs int
+	// root is the budget the spool shares with the other spools under its
+	// root directory; it is nil for a spool opened outside acquireSpool.
+	root *spoolRoot
+
+	mu       sync.Mutex
+	config   SpoolConfig
+	closed   bool
+	segments []*spoolSegment
+	nextID   uint64
+	bytes    int64
+	writer   *os.File
+	dirty    bool
+
+	// The read cursor points at the first record no processor has claimed;
+	// released records wait in requeue and are claimed before the cursor.
+	cursorSegment uint64
+	cursorOffset  int64
+	cursorIndex   int
+	reader        *os.File
+	unread        int
+	requeue       []spoolRecord
+	evicted       int
+}
+
+// spoolRoot is the byte budget shared by every spool under one root
+// directory.
+type spoolRoot struct {
+	// bytes counts the segments of every spool under the root, open or idle.
+	bytes atomic.Int64
+	// idle is the bytes each spool directory that no processor has open
+	// keeps on disk. It is guarded by the spools mutex.
+	idle map[string]int64
+}
+
+var spools = struct {
+	sync.Mutex
+	open  map[string]*spool
+	roots map[string]*spoolRoot
+}{open: map[string]*spool{}, roots: map[string]*spoolRoot{}}
+
+// acquireSpool opens the spool in dir under root or joins the processor that
+// already has it open, so a route reload hands undelivered records to the new
+// processor.
+func acquireSpool(root, dir string, config SpoolConfig) (*spool, error) {
+	config.applyDefaults()
+	root = filepath.Clean(root)
+	dir = filepath.Clean(dir)
+
+	spools.Lock()
+	defer spools.Unlock()
+	if s := spools.open[dir]; s != nil {
+		s.mu.Lock()
+		s.config = config
+		s.mu.Unlock()
+		s.refs++
+		return s, nil
+	}
+	budget := spools.roots[root]
+	if budget == nil {
+		budget = scanSpoolRoot(root)
+		spools.roots[root] = budget
+	}
+	s, err := openSpool(dir, config)
+	if err != nil {
+		return nil, err
+	}
+	budget.bytes.Add(s.bytes - budget.idle[dir])
+	delete(budget.idle, dir)
+	s.root = budget
+	s.refs = 1
+	spools.open[dir] = s
+	return s, nil
+}
+
+// scanSpoolRoot counts the segments already under root, so spools left by an
+// earlier process or by routes that are gone keep counting against max_bytes
+// until they are opened and drained.
+func scanSpoolRoot(root string) *spoolRoot {
+	budget := &spoolRoot{idle: map[string]int64{}}
+	_ = filepath.WalkDir(root, func(path string, item fs.DirEntry, err error) error {
+		if err != nil || item.IsDir() {
+			return nil
+		}
+		if _, ok := parseSegmentName(item.Name()); !ok {
+			return nil
+		}
+		info, err := item.Info()
+		if err != nil {
+			return nil
+		}
+		budget.idle[filepath.Dir(path)] += info.Size()
+		budget.bytes.Add(info.Size())
+		return nil
+	})
+	return budget
+}
+
+func (s *spool) release() {
+	spools.Lock()
+	defer spools.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	if spools.open[s.dir] == s {
+		delete(spools.open, s.dir)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.closeFilesLocked()
+	if s.root != nil && s.bytes > 0 {
+		// The undelivered segments stay on disk and in the root budget.
+		s.root.idle[s.dir] = s.bytes
+	}
+}
+
+// addBytesLocked records a change of the spool's size in its root budget.
+func (s *spool) addBytesLocked(delta int64) {
+	s.bytes += delta
+	if s.root != nil {
+		s.root.bytes.Add(delta)
+	}
+}
+
+// overBudgetLocked reports whether appending size bytes would exceed
+// max_bytes across the spool's root.
+func (s *spool) overBudgetLocked(size int64) bool {
+	used := s.bytes
+	if s.root != nil {
+		used = s.root.bytes.Load()
+	}
+	return used+size > s.config.MaxBytes
+}
+
+func openSpool(dir string, config SpoolConfig) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create spool directory: %w", err)
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool directory: %w", err)
+	}
+	s := &spool{dir: dir, config: config, nextID: 1}
+	for _, item := range names {
+		id, ok := parseSegmentName(item.Name())
+		if !ok || item.IsDir() {
+			continue
+		}
+		segment, err := recoverSegment(filepath.Join(dir, item.Name()), id, config.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		s.nextID = max(s.nextID, id+1)
+		if segment == nil {
+			continue
+		}
+		s.segments = append(s.segments, segment)
+		s.bytes += segment.size
+		s.unread += segment.records
+	}
+	slices.SortFunc(s.segments, func(a, b *spoolSegment) int { return cmp.Compare(a.id, b.id) })
+	if len(s.segments) > 0 {
+		s.cursorSegment = s.segments[0].id
+	}
+	return s, nil
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	base, ok := strings.CutSuffix(name, spoolSegmentSuffix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(base, 10, 64)
+	return id, err == nil && id > 0
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%020d%s", id, spoolSegmentSuffix)
+}
+
+// recoverSegment counts the intact records of a segment left by an earlier
+// process and truncates a torn tail. Empty segments are removed.
+func recoverSegment(path string, id uint64, limit int64) (*spoolSegment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spool segment: %w", err)
+	}
+	segment := &spoolSegment{id: id, path: path, sealed: true}
+	reader := bufio.NewReader(file)
+	for {
+		payload, err := readRecord(reader, limit)
+		if err != nil {
+			break
+		}
+		segment.size += spoolHeaderSize + int64(len(payload))
+		segment.records++
+	}
+	stat, err := file.Stat()
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("stat spool segment: %w", err)
+	}
+	if segment.records == 0 {
+		return nil, os.Remove(path)
+	}
+	if stat.Size() > segment.size {
+		logger.Warnf("spool segment %s has a torn tail, truncating to %d bytes", path, segment.size)
+		if err := os.Truncate(path, segment.size); err != nil {
+			return nil, fmt.Errorf("truncate spool segment: %w", err)
+		}
+	}
+	return segment, nil
+}
+
+func readRecord(reader io.Reader, limit int64) ([]byte, error) {
+	var header [spoolHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if int64(length) > limit {
+		return nil, errors.New("spool record length exceeds max_bytes")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("spool record checksum mismatch")
+	}
+	return payload, nil
+}
+
+// append writes the entry to the active segment, evicting the oldest segments
+// when the spool would exceed max_bytes. The record is claimed by the caller
+// only when admit is set and no older record is still waiting on disk;
+// otherwise a worker replays it later.
+func (s *spool) append(entry map[string]any, admit bool) (ref spoolRef, claimed bool, evicted int, err error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return spoolRef{}, false, 0, err
+	}
+	size := spoolHeaderSize + int64(len(payload))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return spoolRef{}, false, 0, errors.New("spool is closed")
+	}
+	if size > s.config.MaxBytes {
+		return spoolRef{}, false, 0, fmt.Errorf("entry of %d bytes exceeds spool max_bytes", size)
+	}
+	for s.overBudgetLocked(size) && len(s.segments) > 0 {
+		evicted += s.evictOldestLocked()
+	}
+	if s.overBudgetLocked(size) {
+		return spoolRef{}, false, evicted, errors.New("spool max_bytes is used up by other spools")
+	}
+
+	active := s.activeLocked()
+	if active != nil && active.size > 0 && active.size+size > s.config.SegmentBytes {
+		s.sealLocked(active)
+		active = nil
+	}
+	if active == nil {
+		if active, err = s.createSegmentLocked(); err != nil {
+			return spoolRef{}, false, evicted, err
+		}
+	}
+
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:spoolHeaderSize], crc32.ChecksumIEEE(payload))
+	copy(record[spoolHeaderSize:], payload)
+	if _, err := s.writer.Write(record); err != nil {
+		// A partial write leaves a torn tail; start over in a new segment.
+		s.sealLocked(active)
+		return spoolRef{}, false, evicted, fmt.Errorf("write spool segment: %w", err)
+	}
+	if s.config.Fsync == SpoolFsyncAlways {
+		if err := s.writer.Sync(); err != nil {
+			logger.Errorf("sync spool segment %s: %s", active.path, err)
+		}
+	} else {
+		s.dirty = true
+	}
+
+	ref = spoolRef{segment: active.id, index: active.records}
+	active.size += size
+	active.records++
+	s.addBytesLocked(size)
+
+	if admit && s.unread == 0 && len(s.requeue) == 0 {
+		active.claimed++
+		s.cursorSegment, s.cursorOffset, s.cursorIndex = active.id, active.size, active.records
+		return ref, true, evicted, nil
+	}
+	s.unread++
+	return ref, false, evicted, nil
+}
+
+func (s *spool) activeLocked() *spoolSegment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	last := s.segments[len(s.segments)-1]
+	if last.sealed {
+		return nil
+	}
+	return last
+}
+
+func (s *spool) createSegmentLocked() (*spoolSegment, error) {
+	segment := &spoolSegment{id: s.nextID, path: filepath.Join(s.dir, segmentName(s.nextID))}
+	writer, err := os.OpenFile(segment.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("create spool segment: %w", err)
+	}
+	s.nextID++
+	s.writer = writer
+	s.segments = append(s.segments, segment)
+	if s.unread == 0 && len(s.requeue) == 0 {
+		s.cursorSegment, s.cursorOffset, s.cursorIndex = segment.id, 0, 0
+	}
+	return segment, nil
+}
+
+func (s *spool) sealLocked(segment *spoolSegment) {
+	if segment.sealed {
+		return
+	}
+	segment.sealed = true
+	if s.writer != nil {
+		if s.config.Fsync != SpoolFsyncNever {
+			if err := s.writer.Sync(); err != nil {
+				logger.Errorf("sync spool segment %s: %s", segment.path, err)
+			}
+		}
+		_ = s.writer.Close()
+		s.writer = nil
+		s.dirty = false
+	}
+	s.removeIfDoneLocked(segment)
+}
+
+// evictOldestLocked drops the oldest segment and returns how many of its
+// records were neither delivered nor held in memory by a processor.
+func (s *spool) evictOldestLocked() int {
+	segment := s.segments[0]
+	if !segment.sealed {
+		s.sealLocked(segment)
+		if len(s.segments) == 0 || s.segments[0] != segment {
+			return 0
+		}
+	}
+	s.segments = s.segments[1:]
+	s.addBytesLocked(-segment.size)
+	_ = os.Remove(segment.path)
+
+	if s.cursorSegment == segment.id {
+		s.unread -= segment.records - s.cursorIndex
+		s.closeReaderLocked()
+		if len(s.segments) > 0 {
+			s.cursorSegment, s.cursorOffset, s.cursorIndex = s.segments[0].id, 0, 0
+		} else {
+			s.cursorSegment, s.cursorOffset, s.cursorIndex = 0, 0, 0
+		}
+	}
+	s.requeue = slices.DeleteFunc(s.requeue, func(record spoolRecord) bool {
+		return record.ref.segment == segment.id
+	})
+	dropped := segment.records - segment.acked - segment.claimed
+	s.evicted += dropped
+	return dropped
+}
+
+// claim returns up to limit records that no processor holds, released records
+// first and then the records after the read cursor, in append order.
+func (s *spool) claim(limit int) []spoolRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || limit <= 0 {
+		return nil
+	}
+	records := make([]spoolRecord, 0, min(limit, len(s.requeue)+s.unread))
+	for len(records) < limit && len(s.requeue) > 0 {
+		record := s.requeue[0]
+		s.requeue = s.requeue[1:]
+		if segment := s.segmentLocked(record.ref.segment); segment != nil {
+			segment.claimed++
+			records = append(records, record)
+		}
+	}
+	for len(records) < limit && s.unread > 0 {
+		record, ok := s.readNextLocked()
+		if !ok {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func (s *spool) readNextLocked() (spoolRecord, bool) {
+	for {
+		segment := s.segmentLocked(s.cursorSegment)
+		if segment == nil {
+			s.unread = 0
+			return spoolRecord{}, false
+		}
+		if s.cursorIndex < segment.records {
+			break
+		}
+		next := s.segmentAfterLocked(segment.id)
+		if next == nil {
+			return spoolRecord{}, false
+		}
+		s.closeReaderLocked()
+		s.cursorSegment, s.cursorOffset, s.cursorIndex = next.id, 0, 0
+	}
+	segment := s.segmentLocked(s.cursorSegment)
+	if s.reader == nil {
+		reader, err := os.Open(segment.path)
+		if err == nil {
+			_, err = reader.Seek(s.cursorOffset, io.SeekStart)
+		}
+		if err != nil {
+			logger.Errorf("open spool segment %s: %s", segment.path, err)
+			s.skipCursorSegmentLocked(segment)
+			return spoolRecord{}, false
+		}
+		s.reader = reader
+	}
+	payload, err := readRecord(s.reader, s.config.MaxBytes)
+	var entry map[string]any
+	if err == nil {
+		err = json.Unmarshal(payload, &entry)
+	}
+	if err != nil {
+		logger.Errorf("read spool segment %s: %s", segment.path, err)
+		s.skipCursorSegmentLocked(segment)
+		return spoolRecord{}, false
+	}
+	record := spoolRecord{ref: spoolRef{segment: segment.id, index: s.cursorIndex}, entry: entry}
+	s.cursorOffset += spoolHeaderSize + int64(len(payload))
+	s.cursorIndex++
+	s.unread--
+	segment.claimed++
+	return record, true
+}
+
+// skipCursorSegmentLocked gives up on the unread records of a segment that can
+// no longer be read and treats them as evicted.
+func (s *spool) skipCursorSegmentLocked(segment *spoolSegment) {
+	skipped := segment.records - s.cursorIndex
+	s.unread -= skipped
+	s.evicted += skipped
+	segment.acked += skipped
+	s.cursorIndex = segment.records
+	s.closeReaderLocked()
+	s.removeIfDoneLocked(segment)
+}
+
+// ack marks delivered records; a sealed segment whose records are all
+// delivered is removed.
+func (s *spool) ack(refs []spoolRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, ref := range refs {
+		segment := s.segmentLocked(ref.segment)
+		if segment == nil {
+			continue
+		}
+		segment.acked++
+		segment.claimed--
+		s.removeIfDoneLocked(segment)
+	}
+}
+
+// giveBack returns claimed records that a processor can no longer deliver so
+// that another processor on the same directory replays them.
+func (s *spool) giveBack(records []spoolRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for _, record := range records {
+		segment := s.segmentLocked(record.ref.segment)
+		if segment == nil {
+			continue
+		}
+		segment.claimed--
+		s.requeue = append(s.requeue, record)
+	}
+	slices.SortFunc(s.requeue, func(a, b spoolRecord) int {
+		if a.ref.segment != b.ref.segment {
+			return cmp.Compare(a.ref.segment, b.ref.segment)
+		}
+		return cmp.Compare(a.ref.index, b.ref.index)
+	})
+}
+
+// retained reports whether the record's segment is still on disk.
+func (s *spool) retained(ref spoolRef) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.segmentLocked(ref.segment) != nil
+}
+
+func (s *spool) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.dirty || s.writer == nil || s.config.Fsync != SpoolFsyncBatch {
+		return
+	}
+	if err := s.writer.Sync(); err != nil {
+		logger.Errorf("sync spool directory %s: %s", s.dir, err)
+		return
+	}
+	s.dirty = false
+}
+
+func (s *spool) backlog() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unread + len(s.requeue)
+}
+
+func (s *spool) evictedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evicted
+}
+
+func (s *spool) removeIfDoneLocked(segment *spoolSegment) {
+	if !segment.sealed || segment.acked < segment.records {
+		return
+	}
+	index := slices.Index(s.segments, segment)
+	if index < 0 {
+		return
+	}
+	if s.cursorSegment == segment.id {
+		s.closeReaderLocked()
+		if next := s.segmentAfterLocked(segment.id); next != nil {
+			s.cursorSegment, s.cursorOffset, s.cursorIndex = next.id, 0, 0
+		} else {
+			s.cursorSegment, s.cursorOffset, s.cursorIndex = 0, 0, 0
+		}
+	}
+	s.segments = slices.Delete(s.segments, index, index+1)
+	s.addBytesLocked(-segment.size)
+	if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Errorf("remove spool segment %s: %s", segment.path, err)
+	}
+}
+
+func (s *spool) segmentLocked(id uint64) *spoolSegment {
+	for _, segment := range s.segments {
+		if segment.id == id {
+			return segment
+		}
+	}
+	return nil
+}
+
+func (s *spool) segmentAfterLocked(id uint64) *spoolSegment {
+	for _, segment := range s.segments {
+		if segment.id > id {
+			return segment
+		}
+	}
+	return nil
+}
+
+func (s *spool) closeReaderLocked() {
+	if s.reader != nil {
+		_ = s.reader.Close()
+		s.reader = nil
+	}
+}
+
+func (s *spool) closeFilesLocked() {
+	s.closeReaderLocked()
+	if s.writer != nil {
+		if s.config.Fsync != SpoolFsyncNever {
+			_ = s.writer.Sync()
+		}
+		_ = s.writer.Close()
+		s.writer = nil
+	}
+}
+
+// spoolDir keeps the spools of different plugins and routes apart so a
+// replayed record is only delivered to the sink it was written for.
+func spoolDir(root, pluginID, routeID string) string {
+	return filepath.Join(root, spoolPathElement(pluginID), spoolPathElement(routeID))
+}
+
+func spoolPathElement(value string) string {
+	if value == "" || value == "." || value == ".." {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, value)
+}
@@ -0,0 +1,240 @@
+package logger_batch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func spoolTestConfig(dir string) Config {
+	return Config{
+		Name:            "spool logger",
+		PluginID:        "http-logger",
+		RouteID:         "route-1",
+		BatchMaxSize:    10,
+		RetryDelaySet:   true,
+		InactiveTimeout: time.Hour,
+		BufferDuration:  time.Hour,
+		ShutdownTimeout: time.Second,
+		Spool:           &SpoolConfig{Dir: dir, Fsync: SpoolFsyncAlways},
+	}
+}
+
+func entryIDs(entries []map[string]any) []any {
+	ids := make([]any, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry["id"]
+	}
+	return ids
+}
+
+func TestSpoolReplaysUndeliveredEntriesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	failing := NewWithContext(spoolTestConfig(dir), func(context.Context, []map[string]any, int) (int, error) {
+		return 0, errors.New("sink is down")
+	})
+	for id := 1; id <= 3; id++ {
+		if !failing.Push(map[string]any{"id": id}) {
+			t.Fatalf("push %d was rejected", id)
+		}
+	}
+	failing.Stop()
+	if stats := failing.Stats(); stats.FailedDrops != 0 {
+		t.Fatalf("failed drops = %d, want spooled entries to be kept", stats.FailedDrops)
+	}
+
+	delivered := make(chan []map[string]any, 1)
+	restarted := NewWithContext(spoolTestConfig(dir), func(_ context.Context, entries []map[string]any, _ int) (int, error) {
+		delivered <- entries
+		return 0, nil
+	})
+	t.Cleanup(restarted.Stop)
+
+	batch := waitBatch(t, delivered)
+	// Replayed entries are decoded from JSON, so numbers come back as float64.
+	if got := entryIDs(batch); len(got) != 3 || got[0] != 1.0 || got[1] != 2.0 || got[2] != 3.0 {
+		t.Fatalf("replayed ids = %v, want [1 2 3]", got)
+	}
+	waitFor(t, func() bool {
+		segments, _ := filepath.Glob(filepath.Join(dir, "http-logger", "route-1", "*"+spoolSegmentSuffix))
+		return restarted.Stats().Delivered == 3 && len(segments) <= 1
+	})
+}
+
+func TestSpoolHoldsEntriesBeyondMaxPendingEntriesOnDisk(t *testing.T) {
+	config := spoolTestConfig(t.TempDir())
+	config.BatchMaxSize = 1
+	config.MaxPendingEntries = 1
+	release := make(chan struct{})
+	delivered := make(chan []map[string]any, 10)
+	p := NewWithContext(config, func(_ context.Context, entries []map[string]any, _ int) (int, error) {
+		<-release
+		delivered <- entries
+		return 0, nil
+	})
+	t.Cleanup(p.Stop)
+
+	for id := 1; id <= 4; id++ {
+		if !p.Push(map[string]any{"id": id}) {
+			t.Fatalf("push %d was rejected", id)
+		}
+	}
+	if stats := p.Stats(); stats.Spooled != 3 || stats.Dropped != 0 {
+		t.Fatalf("stats = %+v, want three spooled entries and no drops", stats)
+	}
+
+	close(release)
+	var ids []any
+	for range 4 {
+		ids = append(ids, entryIDs(waitBatch(t, delivered))...)
+	}
+	if ids[0] != 1 || ids[1] != 2.0 || ids[2] != 3.0 || ids[3] != 4.0 {
+		t.Fatalf("delivered ids = %v, want [1 2 3 4] in order", ids)
+	}
+}
+
+func TestSpoolKeepsRetryingBatchPastMaxRetryCount(t *testing.T) {
+	config := spoolTestConfig(t.TempDir())
+	config.BatchMaxSize = 1
+	attempts := make(chan struct{}, 10)
+	p := NewWithContext(config, func(context.Context, []map[string]any, int) (int, error) {
+		attempts <- struct{}{}
+		if len(attempts) < 3 {
+			return 0, errors.New("sink is down")
+		}
+		return 0, nil
+	})
+	t.Cleanup(p.Stop)
+
+	p.Push(map[string]any{"id": 1})
+	waitFor(t, func() bool { return p.Stats().Delivered == 1 })
+	if stats := p.Stats(); stats.FailedDrops != 0 || stats.Pending != 0 {
+		t.Fatalf("stats = %+v, want the batch delivered after retrying", stats)
+	}
+}
+
+func TestSpoolEvictsOldestSegmentsWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	config := spoolTestConfig(dir)
+	config.MaxPendingEntries = 1
+	config.Spool.MaxBytes = 256
+	config.Spool.SegmentBytes = 64
+	block := make(chan struct{})
+	p := NewWithContext(config, func(ctx context.Context, _ []map[string]any, _ int) (int, error) {
+		select {
+		case <-block:
+		case <-ctx.Done():
+		}
+		return 0, errors.New("sink is down")
+	})
+	for id := 1; id <= 20; id++ {
+		if !p.Push(map[string]any{"id": id, "pad": "0123456789"}) {
+			t.Fatalf("push %d was rejected", id)
+		}
+	}
+	stats := p.Stats()
+	if stats.SpoolEvicted == 0 {
+		t.Fatalf("stats = %+v, want evicted entries", stats)
+	}
+	close(block)
+	p.Stop()
+
+	s, err := openSpool(spoolDir(dir, "http-logger", "route-1"), *config.Spool)
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	if s.bytes > config.Spool.MaxBytes {
+		t.Fatalf("spool bytes = %d, want at most %d", s.bytes, config.Spool.MaxBytes)
+	}
+	records := s.claim(100)
+	if len(records) == 0 || records[len(records)-1].entry["id"] != 20.0 {
+		t.Fatalf("spooled records = %d, want the newest entries kept", len(records))
+	}
+}
+
+func TestSpoolMaxBytesBoundsEverySpoolUnderTheRoot(t *testing.T) {
+	dir := t.TempDir()
+	fill := func(routeID string) *Processor {
+		config := spoolTestConfig(dir)
+		config.RouteID = routeID
+		config.MaxPendingEntries = 1
+		config.Spool.MaxBytes = 256
+		config.Spool.SegmentBytes = 64
+		p := NewWithContext(config, func(context.Context, []map[string]any, int) (int, error) {
+			return 0, errors.New("sink is down")
+		})
+		for id := 1; id <= 20; id++ {
+			p.Push(map[string]any{"id": id, "pad": "0123456789"})
+		}
+		return p
+	}
+	used := func() int64 { return spools.roots[filepath.Clean(dir)].bytes.Load() }
+
+	first := fill("route-1")
+	first.Stop()
+	if used() == 0 || used() > 256 {
+		t.Fatalf("root bytes after the first route = %d, want within max_bytes", used())
+	}
+	second := fill("route-2")
+	t.Cleanup(second.Stop)
+	if used() > 256 {
+		t.Fatalf("root bytes with two routes = %d, want the budget shared", used())
+	}
+	if stats := second.Stats(); stats.Spooled != 0 {
+		t.Fatalf("second route stats = %+v, want no room left by the first route's leftover spool", stats)
+	}
+}
+
+func TestSpoolTruncatesTornTailOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	config := SpoolConfig{Dir: dir}
+	config.applyDefaults()
+	s, err := openSpool(dir, config)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	for id := 1; id <= 2; id++ {
+		if _, _, _, err := s.append(map[string]any{"id": id}, false); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	s.closeFilesLocked()
+
+	path := filepath.Join(dir, segmentName(1))
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	_, _ = file.Write([]byte{0, 0, 0, 9, 1, 2})
+	_ = file.Close()
+
+	reopened, err := openSpool(dir, config)
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	records := reopened.claim(10)
+	if got := len(records); got != 2 {
+		t.Fatalf("recovered records = %d, want 2", got)
+	}
+	if stat, _ := os.Stat(path); stat.Size() != reopened.bytes {
+		t.Fatalf("segment size = %d, want torn tail truncated to %d", stat.Size(), reopened.bytes)
+	}
+	if reopened.nextID != 2 {
+		t.Fatalf("next segment = %d, want appends to start a new segment", reopened.nextID)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -176,7 +176,7 @@ func (p *Plugin) PostInit() error {
 	if p.config.Sampler.Options.Root.Name == "" {
 		p.config.Sampler.Options.Root.Name = "always_off"
 	}
-	metadata, configured := loadMetadata()
+	metadata, configured := loadMetadata(p.AppConfig())
 	p.metadata = metadata
 
 	var err error
@@ -397,7 +397,7 @@ func TestLoadMetadataUsesOfficialPluginAttributes(t *testing.T) {
 		},
 	}
 
-	metadata, configured := loadMetadata()
+	metadata, configured := loadMetadata(config.GlobalConfig)
 	if !configured {
 		t.Fatal("metadata configured = false, want true")
 	}
@@ -56,9 +56,9 @@ func buildRootSampler(conf RootSamplerConfig) sdktrace.Sampler {
 	}
 }
 
-func loadMetadata() (metadata Metadata, configured bool) {
-	if config.GlobalConfig != nil {
-		if attr := config.GlobalConfig.PluginAttr[name]; attr != nil {
+func loadMetadata(cfg *config.Config) (metadata Metadata, configured bool) {
+	if cfg != nil {
+		if attr := cfg.PluginAttr[name]; attr != nil {
 			if err := util.Parse(attr, &metadata); err == nil {
 				configured = true
 			}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	appconfig "github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/plugin/cacheutil"
 )
@@ -55,8 +56,8 @@ func (p *Plugin) cleanupPeriod() time.Duration {
 	return diskCleanupPeriod
 }
 
-func diskZonePath(name string) (string, int64, bool, error) {
-	for _, zone := range configuredZones() {
+func diskZonePath(cfg *appconfig.Config, name string) (string, int64, bool, error) {
+	for _, zone := range configuredZones(cfg) {
 		if zone.Name != name {
 			continue
 		}
@@ -144,6 +145,9 @@ func (p *Plugin) persistEntryLocked(storageKey string, entry cacheEntry) error {
 		StoredAt:  entry.storedAt,
 		TTL:       int64(entry.ttl),
 		ExpiresAt: entry.expiresAt,
+
+		StaleWhileRevalidate: int64(entry.staleWhileRevalidate),
+		StaleIfError:         int64(entry.staleIfError),
 	}); err != nil {
 		return err
 	}
@@ -203,6 +207,9 @@ func (p *Plugin) loadEntryLocked(storageKey string) (cacheEntry, bool) {
 		storedAt:  persisted.StoredAt,
 		ttl:       time.Duration(persisted.TTL),
 		expiresAt: persisted.ExpiresAt,
+
+		staleWhileRevalidate: time.Duration(persisted.StaleWhileRevalidate),
+		staleIfError:         time.Duration(persisted.StaleIfError),
 	}, true
 }
 
@@ -334,7 +341,7 @@ func diskEntryExpired(path string, now time.Time) bool {
 	if err := json.Unmarshal(data, &persisted); err != nil {
 		return true
 	}
-	return !persisted.ExpiresAt.IsZero() && now.After(persisted.ExpiresAt)
+	return !persisted.ExpiresAt.IsZero() && now.After(persisted.retainUntil())
 }
 
 func (p *Plugin) forgetDiskEntryLocked(path string) {
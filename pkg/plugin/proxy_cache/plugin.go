@@ -34,6 +34,12 @@ type Plugin struct {
 
 	memoryZone *memoryZone
 
+	// fills tracks the one request per cache key that is filling or
+	// revalidating the entry, so concurrent misses wait for it instead of
+	// going upstream.
+	fillMu sync.Mutex
+	fills  map[string]*cacheFill
+
 	diskRoot    string
 	diskEnabled bool
 	diskSize    int64
@@ -144,6 +150,14 @@ const schema = `
     "cache_set_cookie": {
       "type": "boolean",
       "default": false
+    },
+    "stale_while_revalidate": {
+      "type": "integer",
+      "minimum": 0
+    },
+    "stale_if_error": {
+      "type": "integer",
+      "minimum": 0
     }
   }
 }
@@ -162,23 +176,27 @@ type Config struct {
 	CacheTTL             int      `json:"cache_ttl,omitempty"`
 	ConsumerIsolation    bool     `json:"consumer_isolation,omitempty"`
 	CacheSetCookie       bool     `json:"cache_set_cookie,omitempty"`
+	StaleWhileRevalidate *int     `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         *int     `json:"stale_if_error,omitempty"`
 	consumerIsolationSet bool
 }
 
 func (c *Config) UnmarshalJSON(data []byte) error {
 	type configJSON struct {
-		CacheZone         string   `json:"cache_zone,omitempty"`
-		CacheStrategy     string   `json:"cache_strategy,omitempty"`
-		CacheKey          []string `json:"cache_key,omitempty"`
-		CacheBypass       []string `json:"cache_bypass,omitempty"`
-		CacheMethod       []string `json:"cache_method,omitempty"`
-		CacheHTTPStatus   []int    `json:"cache_http_status,omitempty"`
-		HideCacheHeaders  bool     `json:"hide_cache_headers,omitempty"`
-		CacheControl      bool     `json:"cache_control,omitempty"`
-		NoCache           []string `json:"no_cache,omitempty"`
-		CacheTTL          int      `json:"cache_ttl,omitempty"`
-		ConsumerIsolation *bool    `json:"consumer_isolation,omitempty"`
-		CacheSetCookie    bool     `json:"cache_set_cookie,omitempty"`
+		CacheZone            string   `json:"cache_zone,omitempty"`
+		CacheStrategy        string   `json:"cache_strategy,omitempty"`
+		CacheKey             []string `json:"cache_key,omitempty"`
+		CacheBypass          []string `json:"cache_bypass,omitempty"`
+		CacheMethod          []string `json:"cache_method,omitempty"`
+		CacheHTTPStatus      []int    `json:"cache_http_status,omitempty"`
+		HideCacheHeaders     bool     `json:"hide_cache_headers,omitempty"`
+		CacheControl         bool     `json:"cache_control,omitempty"`
+		NoCache              []string `json:"no_cache,omitempty"`
+		CacheTTL             int      `json:"cache_ttl,omitempty"`
+		ConsumerIsolation    *bool    `json:"consumer_isolation,omitempty"`
+		CacheSetCookie       bool     `json:"cache_set_cookie,omitempty"`
+		StaleWhileRevalidate *int     `json:"stale_while_revalidate,omitempty"`
+		StaleIfError         *int     `json:"stale_if_error,omitempty"`
 	}
 
 	var decoded configJSON
@@ -201,6 +219,8 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		c.consumerIsolationSet = true
 	}
 	c.CacheSetCookie = decoded.CacheSetCookie
+	c.StaleWhileRevalidate = decoded.StaleWhileRevalidate
+	c.StaleIfError = decoded.StaleIfError
 	return nil
 }
 
@@ -247,7 +267,7 @@ func (p *Plugin) PostInit() error {
 	if err := validateCacheStatuses(p.config.CacheHTTPStatus); err != nil {
 		return err
 	}
-	if err := ValidateCacheZoneStrategy(p.config.CacheZone, p.config.CacheStrategy); err != nil {
+	if err := ValidateCacheZoneStrategy(p.AppConfig(), p.config.CacheZone, p.config.CacheStrategy); err != nil {
 		return err
 	}
 	if err := validateCacheKey(p.config.CacheKey); err != nil {
@@ -263,20 +283,21 @@ func (p *Plugin) PostInit() error {
 	p.vary = map[string]varyIndex{}
 	p.loaded = map[string]bool{}
 	p.diskEntryKeys = map[string]string{}
+	p.fills = map[string]*cacheFill{}
 	p.lock = &sync.RWMutex{}
 	p.diskRoot = ""
 	p.diskEnabled = false
 	p.diskSize = 0
 	p.lastCleanup = time.Time{}
-	if p.config.CacheStrategy == "memory" && declaredCacheZone(p.config.CacheZone) {
-		p.memoryZone = acquireMemoryZone(p.config.CacheZone)
+	if p.config.CacheStrategy == "memory" && declaredCacheZone(p.AppConfig(), p.config.CacheZone) {
+		p.memoryZone = acquireMemoryZone(p.AppConfig(), p.config.CacheZone)
 		p.lock = &p.memoryZone.lock
 		p.entries = p.memoryZone.entries
 		p.vary = p.memoryZone.vary
 		p.loaded = p.memoryZone.loaded
 	}
 	if p.config.CacheStrategy == "disk" {
-		root, diskSize, configured, err := diskZonePath(p.config.CacheZone)
+		root, diskSize, configured, err := diskZonePath(p.AppConfig(), p.config.CacheZone)
 		if err != nil {
 			return err
 		}
@@ -425,35 +446,54 @@ func (p *Plugin) Handler(next http.Handler) http.Handler {
 			return
 		}
 
+		if isRevalidation(r) {
+			w.Header().Set(cacheStatusHeader, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		key := p.cacheKey(r)
 		if p.hasTruthyValue(r, p.config.CacheBypass) {
-			p.fetchAndMaybeStore(w, r, next, key, "BYPASS", false)
+			p.fetchAndMaybeStore(w, r, next, key, "BYPASS", false, cacheEntry{})
 			return
 		}
 		if p.requestCacheControlBypass(r) {
-			p.fetchAndMaybeStore(w, r, next, key, "BYPASS", false)
+			p.fetchAndMaybeStore(w, r, next, key, "BYPASS", false, cacheEntry{})
 			return
 		}
 
-		if entry, status := p.lookup(r, key); status == "HIT" {
+		entry, status := p.lookup(r, key)
+		now := time.Now()
+		shouldStore := r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache)
+		switch {
+		case status == "HIT":
 			writeCachedResponse(w, entry, status)
 			return
-		} else if status == "EXPIRED" {
-			shouldStore := r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache)
-			p.fetchAndMaybeStore(w, r, next, key, status, shouldStore)
+		case status == "EXPIRED" && entry.servableWhileRevalidating(now) && p.revalidate(r, key, entry):
+			writeCachedResponse(w, entry, "UPDATING")
 			return
-		} else if status == "STALE" {
-			shouldStore := r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache)
-			p.fetchAndMaybeStore(w, r, next, key, status, shouldStore)
+		case status == "STALE":
+			p.fetchAndMaybeStore(w, r, next, key, status, shouldStore, cacheEntry{})
 			return
-		} else if p.onlyIfCachedMiss(r) {
+		case status == "MISS" && p.onlyIfCachedMiss(r):
 			w.Header().Set(cacheStatusHeader, "MISS")
 			w.WriteHeader(http.StatusGatewayTimeout)
 			return
 		}
 
-		shouldStore := r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache)
-		p.fetchAndMaybeStore(w, r, next, key, "MISS", shouldStore)
+		if shouldStore {
+			filled, hit, fill := p.collapseMiss(r, key)
+			if hit {
+				writeCachedResponse(w, filled, "HIT")
+				return
+			}
+			defer fill.release()
+		}
+		var fallback cacheEntry
+		if entry.servableOnError(now) {
+			fallback = entry
+		}
+		p.fetchAndMaybeStore(w, r, next, key, status, shouldStore, fallback)
 	}
 	return http.HandlerFunc(fn)
 }
@@ -465,10 +505,18 @@ func (p *Plugin) fetchAndMaybeStore(
 	key string,
 	cacheStatus string,
 	shouldStore bool,
+	fallback cacheEntry,
 ) {
 	recorder := base.GetOrCreateTransformResponseWriter(r)
 	next.ServeHTTP(recorder, r)
 
+	if fallback.status != 0 && upstreamError(recorder.StatusCode()) {
+		recorder.Reset()
+		writeCachedResponse(recorder, fallback, "STALE")
+		recorder.Commit(w)
+		return
+	}
+
 	if p.hasTruthyValue(r, p.config.NoCache) {
 		shouldStore = false
 	}
@@ -514,6 +562,12 @@ func (p *Plugin) lookup(r *http.Request, key string) (cacheEntry, string) {
 		return cacheEntry{}, "MISS"
 	}
 	if now.After(entry.expiresAt) {
+		// An expired entry stays while a stale window is open, so the caller
+		// can serve it during revalidation or in place of an upstream error.
+		if now.Before(entry.retainUntil()) {
+			p.lock.Unlock()
+			return entry, "EXPIRED"
+		}
 		if p.memoryZone != nil {
 			p.memoryZone.deleteEntryLocked(storageKey)
 		} else {
@@ -969,14 +969,16 @@ func TestRefreshConfiguredZonesRejectsInvalidSnapshotWithoutReplacingCurrent(t *
 	if err := RefreshConfiguredZones([]appconfig.Zone{{Name: "refresh-next", MemorySize: "2M"}}); err != nil {
 		t.Fatalf("RefreshConfiguredZones(valid) error = %v", err)
 	}
-	if !CacheZoneDeclared("refresh-next") || CacheZoneDeclared("refresh-valid") {
+	cfg := appconfig.GlobalConfig
+	if !CacheZoneDeclared(cfg, "refresh-next") || CacheZoneDeclared(cfg, "refresh-valid") {
 		t.Fatal("valid refresh did not replace the configured zone snapshot")
 	}
 
 	if err := RefreshConfiguredZones([]appconfig.Zone{{Name: "refresh-invalid", MemorySize: "zero"}}); err == nil {
 		t.Fatal("RefreshConfiguredZones(invalid) error = nil, want rejection")
 	}
-	if !CacheZoneDeclared("refresh-next") || CacheZoneDeclared("refresh-invalid") {
+	cfg = appconfig.GlobalConfig
+	if !CacheZoneDeclared(cfg, "refresh-next") || CacheZoneDeclared(cfg, "refresh-invalid") {
 		t.Fatal("invalid refresh replaced the last valid configured zone snapshot")
 	}
 }
@@ -1111,7 +1113,7 @@ func TestValidateConfiguredZonesRejectsUnusedInvalidZone(t *testing.T) {
 	}}}
 	t.Cleanup(func() { appconfig.GlobalConfig = oldConfig })
 
-	if err := ValidateConfiguredZones(); err == nil {
+	if err := ValidateConfiguredZones(appconfig.GlobalConfig); err == nil {
 		t.Fatal("ValidateConfiguredZones() error = nil, want invalid unused zone rejection")
 	}
 }
@@ -1942,12 +1944,12 @@ func TestInitRegistersPurgeHTTPMethod(t *testing.T) {
 }
 
 func TestMemoryZoneStoreSharesClonedEntriesAndReleasesLastReference(t *testing.T) {
-	if store := AcquireMemoryZoneStore(""); store != nil {
+	if store := AcquireMemoryZoneStore(appconfig.GlobalConfig, ""); store != nil {
 		t.Fatal("AcquireMemoryZoneStore(empty) returned a store")
 	}
 
-	first := AcquireMemoryZoneStore("shared-store-contract")
-	second := AcquireMemoryZoneStore("shared-store-contract")
+	first := AcquireMemoryZoneStore(appconfig.GlobalConfig, "shared-store-contract")
+	second := AcquireMemoryZoneStore(appconfig.GlobalConfig, "shared-store-contract")
 	t.Cleanup(first.Close)
 	t.Cleanup(second.Close)
 
@@ -1995,7 +1997,7 @@ func TestMemoryZoneStoreSharesClonedEntriesAndReleasesLastReference(t *testing.T
 	first.Close()
 	first.Close()
 	second.Close()
-	reopened := AcquireMemoryZoneStore("shared-store-contract")
+	reopened := AcquireMemoryZoneStore(appconfig.GlobalConfig, "shared-store-contract")
 	t.Cleanup(reopened.Close)
 	if _, ok := reopened.Load("cache-key"); ok {
 		t.Fatal("last Close() retained the prior memory-zone generation")
@@ -2016,7 +2018,7 @@ func TestMemoryZoneStoreEnforcesConfiguredCapacity(t *testing.T) {
 	}}}
 	t.Cleanup(func() { appconfig.GlobalConfig = oldConfig })
 
-	store := AcquireMemoryZoneStore("bounded-memory-store")
+	store := AcquireMemoryZoneStore(appconfig.GlobalConfig, "bounded-memory-store")
 	t.Cleanup(store.Close)
 	now := time.Now()
 	entry := SharedCacheEntry{
@@ -2068,7 +2070,7 @@ func TestMemoryZoneStoreRejectsOversizedOverwriteWithoutMutatingExistingEntry(t
 	}}}
 	t.Cleanup(func() { appconfig.GlobalConfig = oldConfig })
 
-	store := AcquireMemoryZoneStore("bounded-memory-overwrite")
+	store := AcquireMemoryZoneStore(appconfig.GlobalConfig, "bounded-memory-overwrite")
 	t.Cleanup(store.Close)
 	now := time.Now()
 	original := SharedCacheEntry{
@@ -2100,7 +2102,7 @@ func TestDiskZoneStoreLifecycleRejectsCorruptAndExpiredEntries(t *testing.T) {
 	}}}
 	t.Cleanup(func() { appconfig.GlobalConfig = oldConfig })
 
-	store, configured, err := NewDiskZoneStore("shared-disk-contract")
+	store, configured, err := NewDiskZoneStore(appconfig.GlobalConfig, "shared-disk-contract")
 	if err != nil || !configured {
 		t.Fatalf("NewDiskZoneStore() = configured %t, error %v", configured, err)
 	}
@@ -2186,7 +2188,7 @@ func TestNewDiskZoneStorePreservesUnconfiguredFallback(t *testing.T) {
 	appconfig.GlobalConfig = &appconfig.Config{}
 	t.Cleanup(func() { appconfig.GlobalConfig = oldConfig })
 
-	store, configured, err := NewDiskZoneStore("undeclared")
+	store, configured, err := NewDiskZoneStore(appconfig.GlobalConfig, "undeclared")
 	if err != nil || configured || store != nil {
 		t.Fatalf("NewDiskZoneStore(unconfigured) = %#v, %t, %v", store, configured, err)
 	}
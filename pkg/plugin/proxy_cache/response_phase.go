@@ -25,12 +25,17 @@ type storeIntent struct {
 	cacheSetCookie    bool
 	hideCacheHeaders  bool
 	cacheHTTPStatuses []int
+	// fill is the miss collapsing slot the request owns, released once the
+	// response is stored or discarded.
+	fill *cacheFill
 }
 
 type storeIntentHolder struct {
 	mu       sync.Mutex
 	intents  map[*Plugin]storeIntent
 	consumed map[*Plugin]bool
+	// stale holds the expired entry each plugin may serve by stale-if-error.
+	stale map[*Plugin]cacheEntry
 }
 
 type storeIntentHolderKey struct{}
@@ -94,6 +99,42 @@ func (h *storeIntentHolder) consume(plugin *Plugin) (storeIntent, bool, error) {
 	return intent, ok, nil
 }
 
+// discard drops the intent without consuming it, so the final store finds
+// nothing to store.
+func (h *storeIntentHolder) discard(plugin *Plugin) storeIntent {
+	if h == nil || plugin == nil {
+		return storeIntent{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	intent := h.intents[plugin]
+	delete(h.intents, plugin)
+	return intent
+}
+
+func (h *storeIntentHolder) publishStale(plugin *Plugin, entry cacheEntry) {
+	if h == nil || plugin == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stale == nil {
+		h.stale = make(map[*Plugin]cacheEntry)
+	}
+	h.stale[plugin] = entry
+}
+
+func (h *storeIntentHolder) takeStale(plugin *Plugin) (cacheEntry, bool) {
+	if h == nil || plugin == nil {
+		return cacheEntry{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.stale[plugin]
+	delete(h.stale, plugin)
+	return entry, ok
+}
+
 func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.RequestPhaseResult {
 	r, intents := ensureStoreIntentHolder(r)
 	if r == nil {
@@ -120,22 +161,34 @@ func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.Re
 		return base.ContinueRequest(r)
 	}
 
+	if isRevalidation(r) {
+		setCacheHeader(w, cacheStatusHeader, "BYPASS")
+		return base.ContinueRequest(r)
+	}
+
 	key := p.cacheKey(r)
 	if p.hasTruthyValue(r, p.config.CacheBypass) || p.requestCacheControlBypass(r) {
 		setCacheHeader(w, cacheStatusHeader, "BYPASS")
 		return base.ContinueRequest(r)
 	}
 
-	if entry, status := p.lookup(r, key); status == "HIT" {
+	entry, status := p.lookup(r, key)
+	now := time.Now()
+	shouldStore := r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache)
+	switch {
+	case status == "HIT":
 		r = publishCacheHit(r, entry, "HIT")
 		return base.StopRequestWithSource(r, apisixctx.ResponseSourceCacheHit)
-	} else if status == "EXPIRED" || status == "STALE" {
+	case status == "EXPIRED" && entry.servableWhileRevalidating(now) && p.revalidate(r, key, entry):
+		r = publishCacheHit(r, entry, "UPDATING")
+		return base.StopRequestWithSource(r, apisixctx.ResponseSourceCacheHit)
+	case status == "STALE":
 		setCacheHeader(w, cacheStatusHeader, status)
-		if r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache) {
+		if shouldStore {
 			intents.publish(p, p.newStoreIntent(key, r))
 		}
 		return base.ContinueRequest(r)
-	} else if p.onlyIfCachedMiss(r) {
+	case status == "MISS" && p.onlyIfCachedMiss(r):
 		setCacheHeader(w, cacheStatusHeader, "MISS")
 		if w != nil {
 			w.WriteHeader(http.StatusGatewayTimeout)
@@ -143,10 +196,23 @@ func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.Re
 		return base.StopRequestWithSource(r, apisixctx.ResponseSourceEarlyStop)
 	}
 
-	setCacheHeader(w, cacheStatusHeader, "MISS")
-	if r.Method != http.MethodHead && !p.hasTruthyValue(r, p.config.NoCache) {
-		intents.publish(p, p.newStoreIntent(key, r))
+	if shouldStore {
+		filled, hit, fill := p.collapseMiss(r, key)
+		if hit {
+			r = publishCacheHit(r, filled, "HIT")
+			return base.StopRequestWithSource(r, apisixctx.ResponseSourceCacheHit)
+		}
+		if fill != nil {
+			context.AfterFunc(r.Context(), fill.release)
+		}
+		intent := p.newStoreIntent(key, r)
+		intent.fill = fill
+		intents.publish(p, intent)
+	}
+	if entry.servableOnError(now) {
+		intents.publishStale(p, entry)
 	}
+	setCacheHeader(w, cacheStatusHeader, status)
 	return base.ContinueRequest(r)
 }
 
@@ -178,17 +244,42 @@ func publishCacheHit(r *http.Request, entry cacheEntry, status string) *http.Req
 		holder = base.NewCacheHitResponseHolder()
 		r = base.WithCacheHitResponseHolder(r, holder)
 	}
+	holder.Publish(base.CachedResponseState(cachedResponseState(entry, status)))
+	return r
+}
+
+func cachedResponseState(entry cacheEntry, status string) base.ResponseState {
 	header := cacheutil.CloneHeader(entry.header)
 	removeDerivedCacheHeaders(header)
 	age := max(time.Since(entry.storedAt)/time.Second, 0)
 	header.Set("Age", strconv.FormatInt(int64(age), 10))
 	header.Set(cacheStatusHeader, status)
-	holder.Publish(base.CachedResponseState{
+	return base.ResponseState{
 		Status: entry.status,
 		Header: header,
 		Body:   slices.Clone(entry.body),
-	})
-	return r
+	}
+}
+
+// RunErrorResponseFallback serves the expired entry kept by the request phase
+// in place of an upstream error while its stale-if-error window lasts. The
+// entry is published as a cache hit, so it is not filtered a second time.
+func (p *Plugin) RunErrorResponseFallback(r *http.Request, status int) bool {
+	holder := storeIntentHolderFromRequest(r)
+	entry, ok := holder.takeStale(p)
+	if !ok || !upstreamError(status) {
+		return false
+	}
+	holder.discard(p).fill.release()
+	publishCacheHit(r, entry, "STALE")
+	return true
+}
+
+// AppliesToResponseSource lets stale-if-error also replace the errors APISIX
+// generates when the upstream cannot be reached. Such responses are never
+// stored.
+func (p *Plugin) AppliesToResponseSource(source apisixctx.ResponseSource) bool {
+	return source == apisixctx.ResponseSourceUpstream || source == apisixctx.ResponseSourceAPISIX
 }
 
 func (p *Plugin) RunFinalResponseStore(r *http.Request, state base.ResponseState) error {
@@ -200,13 +291,23 @@ func (p *Plugin) RunFinalResponseStore(r *http.Request, state base.ResponseState
 	if err != nil || !ok {
 		return err
 	}
+	defer intent.fill.release()
 	if len(state.Trailer) > 0 {
 		return nil
 	}
 	if p.hasTruthyValue(r, p.config.NoCache) {
 		return nil
 	}
+	if lifecycle := apisixctx.GetRequestLifecycle(r); lifecycle != nil &&
+		lifecycle.ResponseSource() != apisixctx.ResponseSourceUpstream {
+		return nil
+	}
+	return p.storeResponse(intent, state)
+}
 
+// storeResponse stores a final response when the policy captured by the
+// intent finds it cacheable.
+func (p *Plugin) storeResponse(intent storeIntent, state base.ResponseState) error {
 	canonical := base.CloneResponseState(state)
 	removeDerivedCacheHeaders(canonical.Header)
 	if !slices.Contains(intent.cacheHTTPStatuses, canonical.Status) ||
@@ -278,6 +379,7 @@ func (p *Plugin) storeStateWithHeader(
 		ttl:       ttl,
 		expiresAt: now.Add(ttl),
 	}
+	entry.staleWhileRevalidate, entry.staleIfError = p.staleWindows(state.Header)
 	removeDerivedCacheHeaders(entry.header)
 	if hideCacheHeaders {
 		deleteHeaderFold(entry.header, "Expires")
@@ -306,7 +408,7 @@ func (p *Plugin) storeStateWithHeader(
 		p.loadVaryIndexLocked(key)
 	}
 	if len(varyHeaders) > 0 {
-		p.updateVaryIndexLocked(key, varyHeaders, storageSignature, entry.expiresAt)
+		p.updateVaryIndexLocked(key, varyHeaders, storageSignature, entry.retainUntil())
 		delete(p.entries, key)
 	} else if _, ok := p.vary[key]; ok {
 		p.purgeAllLocked(key)
@@ -0,0 +1,245 @@
+package proxy_cache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/plugin/batch_requests"
+	"github.com/wklken/apisix-go/pkg/plugin/cacheutil"
+)
+
+const (
+	// fillWaitTimeout bounds how long a miss waits for the request filling the
+	// same key before going upstream itself, like proxy_cache_lock_timeout.
+	fillWaitTimeout = 5 * time.Second
+	// revalidateTimeout bounds one background revalidation subrequest.
+	revalidateTimeout = time.Minute
+)
+
+// cacheFill is the one request per key that is fetching the entry from the
+// upstream, either as a cache miss or as a background revalidation.
+type cacheFill struct {
+	plugin *Plugin
+	key    string
+	done   chan struct{}
+	once   sync.Once
+}
+
+// joinFill returns the fill in progress for the key, starting one when there
+// is none. owner reports whether the caller started it and must release it.
+func (p *Plugin) joinFill(key string) (fill *cacheFill, owner bool) {
+	p.fillMu.Lock()
+	defer p.fillMu.Unlock()
+	if fill := p.fills[key]; fill != nil {
+		return fill, false
+	}
+	if p.fills == nil {
+		p.fills = map[string]*cacheFill{}
+	}
+	fill = &cacheFill{plugin: p, key: key, done: make(chan struct{})}
+	p.fills[key] = fill
+	return fill, true
+}
+
+// release ends the fill and wakes the requests waiting on it. It may be called
+// more than once, and on a nil fill.
+func (f *cacheFill) release() {
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		f.plugin.fillMu.Lock()
+		if f.plugin.fills[f.key] == f {
+			delete(f.plugin.fills, f.key)
+		}
+		f.plugin.fillMu.Unlock()
+		close(f.done)
+	})
+}
+
+func (f *cacheFill) wait(ctx context.Context) {
+	timer := time.NewTimer(fillWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// collapseMiss makes the request the filler of the key, or waits for the
+// request already filling it and returns the entry that request stored. The
+// returned fill is nil unless the caller owns it.
+func (p *Plugin) collapseMiss(r *http.Request, key string) (cacheEntry, bool, *cacheFill) {
+	fill, owner := p.joinFill(key)
+	if owner {
+		return cacheEntry{}, false, fill
+	}
+	fill.wait(r.Context())
+	if entry, status := p.lookup(r, key); status == "HIT" {
+		return entry, true, nil
+	}
+	return cacheEntry{}, false, nil
+}
+
+// retainUntil is when the entry stops being servable, stale or not.
+func (e cacheEntry) retainUntil() time.Time {
+	return e.expiresAt.Add(max(e.staleWhileRevalidate, e.staleIfError))
+}
+
+func (e cacheEntry) servableWhileRevalidating(now time.Time) bool {
+	return e.status != 0 && now.Before(e.expiresAt.Add(e.staleWhileRevalidate))
+}
+
+func (e cacheEntry) servableOnError(now time.Time) bool {
+	return e.status != 0 && now.Before(e.expiresAt.Add(e.staleIfError))
+}
+
+// staleWindows returns how long a response may be served stale while it is
+// revalidated and in place of an upstream error. The plugin configuration
+// overrides the stale-while-revalidate and stale-if-error directives, and a
+// must-revalidate or proxy-revalidate response is never served stale.
+func (p *Plugin) staleWindows(header http.Header) (time.Duration, time.Duration) {
+	if headerHasCacheControlDirective(header, "must-revalidate", "proxy-revalidate") {
+		return 0, 0
+	}
+	return staleWindow(header, "stale-while-revalidate", p.config.StaleWhileRevalidate),
+		staleWindow(header, "stale-if-error", p.config.StaleIfError)
+}
+
+func staleWindow(header http.Header, directive string, override *int) time.Duration {
+	if override != nil {
+		return time.Duration(*override) * time.Second
+	}
+	value, ok := headerCacheControlDirectiveValue(header, directive)
+	if !ok {
+		return 0
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// upstreamError reports the statuses RFC 5861 lets stale-if-error replace.
+func upstreamError(status int) bool {
+	switch status {
+	case http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+type revalidationKey struct{}
+
+// isRevalidation reports whether r is a background revalidation subrequest,
+// which proxy-cache passes straight to the upstream.
+func isRevalidation(r *http.Request) bool {
+	_, ok := r.Context().Value(revalidationKey{}).(struct{})
+	return ok
+}
+
+// revalidate refreshes an expired entry in the background unless a request
+// is already filling its key. The conditional subrequest is dispatched through
+// the route generation that served r, so it passes the same plugins and
+// upstream as a miss would. It reports false when r cannot be revalidated
+// that way and has to go upstream itself.
+func (p *Plugin) revalidate(r *http.Request, key string, entry cacheEntry) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	factory := batch_requests.DispatchLeaseFactoryFromRequest(r)
+	if factory == nil {
+		return false
+	}
+	fill, owner := p.joinFill(key)
+	if !owner {
+		return true
+	}
+	lease, ok := factory()
+	if !ok || lease.Handler == nil {
+		if lease.Release != nil {
+			lease.Release()
+		}
+		fill.release()
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.WithValue(context.Background(), revalidationKey{}, struct{}{}),
+		revalidateTimeout,
+	)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL.RequestURI(), nil)
+	if err != nil {
+		cancel()
+		if lease.Release != nil {
+			lease.Release()
+		}
+		fill.release()
+		return false
+	}
+	request.Host = r.Host
+	request.RemoteAddr = r.RemoteAddr
+	request.TLS = r.TLS
+	request.Header = apisixctx.TrustedRequestHeaders(r)
+	for _, field := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		request.Header.Del(field)
+	}
+	if etag := entry.header.Get("ETag"); etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if modified := entry.header.Get("Last-Modified"); modified != "" {
+		request.Header.Set("If-Modified-Since", modified)
+	}
+	intent := p.newStoreIntent(key, r)
+	intent.requestHeader = cacheutil.CloneHeader(intent.requestHeader)
+
+	go func() {
+		defer fill.release()
+		defer cancel()
+		if lease.Release != nil {
+			defer lease.Release()
+		}
+		recorder := base.NewBufferedResponseWriter()
+		lease.Handler.ServeHTTP(recorder, request)
+		state := base.ResponseState{
+			Status: recorder.StatusCode(),
+			Header: recorder.Header().Clone(),
+			Body:   slices.Clone(recorder.Body()),
+		}
+		if state.Status == http.StatusNotModified {
+			state = refreshedState(entry, state.Header)
+		}
+		if err := p.storeResponse(intent, state); err != nil {
+			logger.Errorf("proxy-cache revalidation store failed: %v", err)
+		}
+	}()
+	return true
+}
+
+// refreshedState is the stored response updated with the header fields of a
+// 304 Not Modified, as a cache does when it validates a stored response.
+func refreshedState(entry cacheEntry, header http.Header) base.ResponseState {
+	refreshed := cacheutil.CloneHeader(entry.header)
+	for field, values := range header {
+		switch http.CanonicalHeaderKey(field) {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Content-Type", "Transfer-Encoding":
+			continue
+		}
+		deleteHeaderFold(refreshed, field)
+		refreshed[field] = slices.Clone(values)
+	}
+	return base.ResponseState{Status: entry.status, Header: refreshed, Body: slices.Clone(entry.body)}
+}
@@ -0,0 +1,217 @@
+package proxy_cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/plugin/batch_requests"
+)
+
+func expireEntry(t *testing.T, p *Plugin, target string, age time.Duration) {
+	t.Helper()
+
+	key := p.cacheKey(httptest.NewRequest(http.MethodGet, target, nil))
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, ok := p.entries[key]
+	if !ok {
+		t.Fatalf("no cache entry for %s", target)
+	}
+	entry.storedAt = entry.storedAt.Add(-age)
+	entry.expiresAt = entry.expiresAt.Add(-age)
+	p.entries[key] = entry
+}
+
+func TestStaleWindowsHonorDirectivesAndOverrides(t *testing.T) {
+	zero, ten := 0, 10
+	tests := []struct {
+		name         string
+		cacheControl string
+		revalidate   *int
+		onError      *int
+		want         [2]time.Duration
+	}{
+		{name: "directives", cacheControl: "max-age=5, stale-while-revalidate=30, stale-if-error=60", want: [2]time.Duration{30 * time.Second, time.Minute}},
+		{name: "overrides", cacheControl: "stale-while-revalidate=30", revalidate: &ten, onError: &ten, want: [2]time.Duration{10 * time.Second, 10 * time.Second}},
+		{name: "override disables", cacheControl: "stale-if-error=60", onError: &zero},
+		{name: "must revalidate", cacheControl: "stale-if-error=60, must-revalidate", onError: &ten},
+		{name: "invalid", cacheControl: "stale-while-revalidate=soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plugin{config: Config{StaleWhileRevalidate: tt.revalidate, StaleIfError: tt.onError}}
+			revalidate, onError := p.staleWindows(http.Header{"Cache-Control": {tt.cacheControl}})
+			if got := [2]time.Duration{revalidate, onError}; got != tt.want {
+				t.Fatalf("stale windows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerServesStaleEntryOnUpstreamError(t *testing.T) {
+	p := newTestPlugin(t, Config{CacheStrategy: "memory", CacheTTL: 60})
+	status := http.StatusOK
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "stale-if-error=60")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(http.StatusText(status)))
+	}))
+
+	_ = performRequest(t, handler, http.MethodGet, "/stale-if-error", nil)
+	expireEntry(t, p, "/stale-if-error", 90*time.Second)
+	status = http.StatusBadGateway
+	res := performRequest(t, handler, http.MethodGet, "/stale-if-error", nil)
+	if res.Code != http.StatusOK || res.Body.String() != "OK" || res.Header().Get(cacheStatusHeader) != "STALE" {
+		t.Fatalf("response = %d/%q status %q, want stale 200/OK", res.Code, res.Body.String(), res.Header().Get(cacheStatusHeader))
+	}
+
+	expireEntry(t, p, "/stale-if-error", time.Minute)
+	res = performRequest(t, handler, http.MethodGet, "/stale-if-error", nil)
+	if res.Code != http.StatusBadGateway || res.Header().Get(cacheStatusHeader) != "EXPIRED" {
+		t.Fatalf("response = %d status %q, want the upstream error once stale-if-error ends", res.Code, res.Header().Get(cacheStatusHeader))
+	}
+}
+
+func TestProxyCacheFallbackPublishesStaleEntryAsCacheHit(t *testing.T) {
+	p := newTestPlugin(t, Config{CacheStrategy: "memory", CacheTTL: 60})
+	holder := base.NewCacheHitResponseHolder()
+	request := base.WithCacheHitResponseHolder(httptest.NewRequest(http.MethodGet, "/stale-phase", nil), holder)
+	key := p.cacheKey(request)
+	p.lock.Lock()
+	p.entries[key] = cacheEntry{
+		header:       http.Header{"X-Cached": {"yes"}},
+		body:         []byte("cached"),
+		status:       http.StatusOK,
+		storedAt:     time.Now().Add(-2 * time.Minute),
+		ttl:          time.Minute,
+		expiresAt:    time.Now().Add(-time.Minute),
+		staleIfError: 5 * time.Minute,
+	}
+	p.lock.Unlock()
+
+	result := p.RunRequestPhase(httptest.NewRecorder(), request)
+	if result.Decision != base.RequestContinue {
+		t.Fatalf("RunRequestPhase() decision = %v, want continue upstream", result.Decision)
+	}
+	if p.RunErrorResponseFallback(result.Request, http.StatusOK) {
+		t.Fatal("RunErrorResponseFallback() replaced a successful response")
+	}
+
+	result = p.RunRequestPhase(httptest.NewRecorder(), request)
+	if !p.RunErrorResponseFallback(result.Request, http.StatusServiceUnavailable) {
+		t.Fatal("RunErrorResponseFallback() = false, want the stale entry served")
+	}
+	cached, published, err := holder.ConsumePublished()
+	state := base.ResponseState(cached)
+	if err != nil || !published || state.Status != http.StatusOK || string(state.Body) != "cached" ||
+		state.Header.Get(cacheStatusHeader) != "STALE" {
+		t.Fatalf("published = %d/%q %v, %t/%v; want the stale entry", state.Status, state.Body, state.Header, published, err)
+	}
+	if err := p.RunFinalResponseStore(result.Request, state); err != nil {
+		t.Fatalf("RunFinalResponseStore() error = %v", err)
+	}
+	p.lock.RLock()
+	stored := p.entries[key]
+	p.lock.RUnlock()
+	if !stored.expiresAt.Before(time.Now()) {
+		t.Fatal("stale response was stored as a fresh entry")
+	}
+}
+
+func TestHandlerRevalidatesStaleEntryInBackground(t *testing.T) {
+	p := newTestPlugin(t, Config{CacheStrategy: "memory", CacheTTL: 60})
+	var conditional atomic.Value
+	var calls atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		conditional.Store(r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "stale-while-revalidate=60")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("v1"))
+	})
+	handler := p.Handler(upstream)
+	revalidated := make(chan struct{})
+	leases := batch_requests.DispatchLeaseFactory(func() (batch_requests.DispatchLease, bool) {
+		return batch_requests.DispatchLease{Handler: handler, Release: func() { close(revalidated) }}, true
+	})
+	serve := func() *httptest.ResponseRecorder {
+		request := batch_requests.WithDispatchLeaseFactory(httptest.NewRequest(http.MethodGet, "/swr", nil), leases)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	_ = serve()
+	expireEntry(t, p, "/swr", 90*time.Second)
+	res := serve()
+	if res.Body.String() != "v1" || res.Header().Get(cacheStatusHeader) != "UPDATING" {
+		t.Fatalf("response = %q status %q, want stale v1 while updating", res.Body.String(), res.Header().Get(cacheStatusHeader))
+	}
+	select {
+	case <-revalidated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("background revalidation did not finish")
+	}
+	if got := conditional.Load(); got != `"v1"` {
+		t.Fatalf("revalidation If-None-Match = %v, want the stored ETag", got)
+	}
+	res = serve()
+	if res.Body.String() != "v1" || res.Header().Get(cacheStatusHeader) != "HIT" {
+		t.Fatalf("response = %q status %q, want a hit on the refreshed entry", res.Body.String(), res.Header().Get(cacheStatusHeader))
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("upstream calls = %d, want the miss and one revalidation", got)
+	}
+}
+
+func TestHandlerCollapsesConcurrentMisses(t *testing.T) {
+	p := newTestPlugin(t, Config{CacheStrategy: "memory", CacheTTL: 60})
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = w.Write([]byte("filled"))
+	}))
+
+	first := make(chan *httptest.ResponseRecorder, 1)
+	go func() { first <- performRequest(t, handler, http.MethodGet, "/collapse", nil) }()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 4)
+	for i := range responses {
+		wg.Go(func() { responses[i] = performRequest(t, handler, http.MethodGet, "/collapse", nil) })
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if res := <-first; res.Header().Get(cacheStatusHeader) != "MISS" {
+		t.Fatalf("leader cache status = %q, want MISS", res.Header().Get(cacheStatusHeader))
+	}
+	for i, res := range responses {
+		if res.Body.String() != "filled" || res.Header().Get(cacheStatusHeader) != "HIT" {
+			t.Fatalf("follower %d = %q status %q, want the filled hit", i, res.Body.String(), res.Header().Get(cacheStatusHeader))
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("upstream calls = %d, want 1", got)
+	}
+	p.fillMu.Lock()
+	defer p.fillMu.Unlock()
+	if len(p.fills) != 0 {
+		t.Fatalf("fills left = %d, want 0", len(p.fills))
+	}
+}
@@ -24,6 +24,12 @@ type cacheEntry struct {
 	storedAt  time.Time
 	ttl       time.Duration
 	expiresAt time.Time
+
+	// staleWhileRevalidate and staleIfError extend the life of an expired
+	// entry: it is served while a background revalidation runs, or in place
+	// of an upstream error, until the matching window past expiresAt ends.
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
 }
 
 type memoryZone struct {
@@ -61,21 +67,21 @@ type MemoryZoneStore struct {
 // CacheZoneDeclared reports whether a zone is present in the configured
 // proxy-cache registry. An empty registry intentionally retains compatibility
 // with local, process-only cache fallbacks.
-func CacheZoneDeclared(name string) bool {
-	return declaredCacheZone(name)
+func CacheZoneDeclared(cfg *appconfig.Config, name string) bool {
+	return declaredCacheZone(cfg, name)
 }
 
 // ValidateCacheZone validates a plugin cache_zone against the configured
 // registry when one is present.
-func ValidateCacheZone(name string) error {
-	return validateCacheZoneRegistry(name)
+func ValidateCacheZone(cfg *appconfig.Config, name string) error {
+	return validateCacheZoneRegistry(cfg, name)
 }
 
 // ValidateConfiguredZones validates the complete static proxy-cache zone
 // registry before a route replacement starts. An empty registry preserves the
 // compatibility fallback used when no zones are declared.
-func ValidateConfiguredZones() error {
-	return validateCacheZoneRegistry("")
+func ValidateConfiguredZones(cfg *appconfig.Config) error {
+	return validateCacheZoneRegistry(cfg, "")
 }
 
 // RefreshConfiguredZones validates and atomically publishes a complete
@@ -92,19 +98,19 @@ func RefreshConfiguredZones(zones []appconfig.Zone) error {
 	defer configuredZoneRefreshMu.Unlock()
 
 	var next appconfig.Config
-	if appconfig.GlobalConfig != nil {
-		next = *appconfig.GlobalConfig
+	if cfg := appconfig.Global(); cfg != nil {
+		next = *cfg
 	}
 	next.Apisix.ProxyCache.Zones = cloned
-	appconfig.GlobalConfig = &next
+	appconfig.SetGlobal(&next)
 	return nil
 }
 
 // ValidateCacheZoneStrategy validates a plugin cache_zone against the
 // configured zone's storage strategy. A configured disk_path makes a zone
 // disk-backed; a zone without one is memory-backed.
-func ValidateCacheZoneStrategy(name, strategy string) error {
-	zones := configuredZones()
+func ValidateCacheZoneStrategy(cfg *appconfig.Config, name, strategy string) error {
+	zones := configuredZones(cfg)
 	seen, err := validateZoneDefinitions(zones)
 	if err != nil {
 		return err
@@ -130,11 +136,11 @@ func ValidateCacheZoneStrategy(name, strategy string) error {
 
 // AcquireMemoryZoneStore acquires a reference to a named shared memory zone.
 // Call Close when the owning plugin instance stops.
-func AcquireMemoryZoneStore(name string) *MemoryZoneStore {
+func AcquireMemoryZoneStore(cfg *appconfig.Config, name string) *MemoryZoneStore {
 	if name == "" {
 		return nil
 	}
-	return &MemoryZoneStore{zone: acquireMemoryZone(name), name: name}
+	return &MemoryZoneStore{zone: acquireMemoryZone(cfg, name), name: name}
 }
 
 func (s *MemoryZoneStore) Load(key string) (SharedCacheEntry, bool) {
@@ -338,12 +344,19 @@ type varyIndex struct {
 }
 
 type diskCacheEntry struct {
-	Header    http.Header `json:"header"`
-	Body      []byte      `json:"body"`
-	Status    int         `json:"status"`
-	StoredAt  time.Time   `json:"stored_at"`
-	TTL       int64       `json:"ttl"`
-	ExpiresAt time.Time   `json:"expires_at"`
+	Header               http.Header `json:"header"`
+	Body                 []byte      `json:"body"`
+	Status               int         `json:"status"`
+	StoredAt             time.Time   `json:"stored_at"`
+	TTL                  int64       `json:"ttl"`
+	ExpiresAt            time.Time   `json:"expires_at"`
+	StaleWhileRevalidate int64       `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         int64       `json:"stale_if_error,omitempty"`
+}
+
+// retainUntil is when a persisted entry stops being servable, stale or not.
+func (e diskCacheEntry) retainUntil() time.Time {
+	return e.ExpiresAt.Add(time.Duration(max(e.StaleWhileRevalidate, e.StaleIfError)))
 }
 
 // DiskZoneStore exposes the common versioned disk envelope to plugins that
@@ -357,8 +370,8 @@ type DiskZoneStore struct {
 // NewDiskZoneStore resolves and prepares a configured disk zone. configured is
 // false when the process has no proxy-cache zone registry, preserving the
 // compatibility in-memory fallback used by local tests and development.
-func NewDiskZoneStore(name string) (*DiskZoneStore, bool, error) {
-	root, diskSize, configured, err := diskZonePath(name)
+func NewDiskZoneStore(cfg *appconfig.Config, name string) (*DiskZoneStore, bool, error) {
+	root, diskSize, configured, err := diskZonePath(cfg, name)
 	if err != nil || !configured {
 		return nil, configured, err
 	}
@@ -461,8 +474,8 @@ func releaseMemoryZoneRef(name string, zone *memoryZone) {
 	memoryZoneRegistry.Unlock()
 }
 
-func declaredCacheZone(name string) bool {
-	for _, zone := range configuredZones() {
+func declaredCacheZone(cfg *appconfig.Config, name string) bool {
+	for _, zone := range configuredZones(cfg) {
 		if zone.Name == name {
 			return true
 		}
@@ -470,17 +483,17 @@ func declaredCacheZone(name string) bool {
 	return false
 }
 
-func acquireMemoryZone(name string) *memoryZone {
+func acquireMemoryZone(cfg *appconfig.Config, name string) *memoryZone {
 	memoryZoneRegistry.Lock()
 	defer memoryZoneRegistry.Unlock()
-	fingerprint := cacheZoneFingerprint(name)
+	fingerprint := cacheZoneFingerprint(cfg, name)
 	zone := memoryZoneRegistry.zones[name]
 	if zone == nil || zone.fingerprint != fingerprint {
 		zone = &memoryZone{
 			entries:     make(map[string]cacheEntry),
 			vary:        make(map[string]varyIndex),
 			loaded:      make(map[string]bool),
-			capacity:    memoryZoneCapacity(name),
+			capacity:    memoryZoneCapacity(cfg, name),
 			fingerprint: fingerprint,
 		}
 		memoryZoneRegistry.zones[name] = zone
@@ -489,8 +502,8 @@ func acquireMemoryZone(name string) *memoryZone {
 	return zone
 }
 
-func memoryZoneCapacity(name string) int64 {
-	for _, zone := range configuredZones() {
+func memoryZoneCapacity(cfg *appconfig.Config, name string) int64 {
+	for _, zone := range configuredZones(cfg) {
 		if zone.Name != name {
 			continue
 		}
@@ -503,8 +516,8 @@ func memoryZoneCapacity(name string) int64 {
 	return 0
 }
 
-func cacheZoneFingerprint(name string) string {
-	for _, zone := range configuredZones() {
+func cacheZoneFingerprint(cfg *appconfig.Config, name string) string {
+	for _, zone := range configuredZones(cfg) {
 		if zone.Name != name {
 			continue
 		}
@@ -519,8 +532,8 @@ func cacheZoneFingerprint(name string) string {
 	return ""
 }
 
-func validateCacheZoneRegistry(cacheZone string) error {
-	seen, err := validateZoneDefinitions(configuredZones())
+func validateCacheZoneRegistry(cfg *appconfig.Config, cacheZone string) error {
+	seen, err := validateZoneDefinitions(configuredZones(cfg))
 	if err != nil {
 		return err
 	}
@@ -535,13 +548,11 @@ func validateCacheZoneRegistry(cacheZone string) error {
 	return nil
 }
 
-func configuredZones() []appconfig.Zone {
-	configuredZoneRefreshMu.RLock()
-	defer configuredZoneRefreshMu.RUnlock()
-	if appconfig.GlobalConfig == nil {
+func configuredZones(cfg *appconfig.Config) []appconfig.Zone {
+	if cfg == nil {
 		return nil
 	}
-	return append([]appconfig.Zone(nil), appconfig.GlobalConfig.Apisix.ProxyCache.Zones...)
+	return append([]appconfig.Zone(nil), cfg.Apisix.ProxyCache.Zones...)
 }
 
 func validateZoneDefinitions(zones []appconfig.Zone) (map[string]struct{}, error) {
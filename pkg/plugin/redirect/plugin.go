@@ -137,7 +137,7 @@ func (p *Plugin) PostInit() error {
 		p.config.regexURI = pattern
 	}
 
-	p.config.httpsPort = configuredHTTPSPort()
+	p.config.httpsPort = configuredHTTPSPort(p.AppConfig())
 	return nil
 }
 
@@ -283,11 +283,11 @@ func isRedirectVariableCharacter(value byte) bool {
 		value >= '0' && value <= '9' || value == '_'
 }
 
-func configuredHTTPSPort() *int {
-	if config.GlobalConfig == nil {
+func configuredHTTPSPort(cfg *config.Config) *int {
+	if cfg == nil {
 		return nil
 	}
-	if pluginAttr := config.GlobalConfig.PluginAttr[name]; pluginAttr != nil {
+	if pluginAttr := cfg.PluginAttr[name]; pluginAttr != nil {
 		if rawPort, ok := pluginAttr["https_port"]; ok {
 			if port, err := cast.ToIntE(rawPort); err == nil {
 				return &port
@@ -295,7 +295,7 @@ func configuredHTTPSPort() *int {
 		}
 	}
 
-	ssl := config.GlobalConfig.Apisix.Ssl
+	ssl := cfg.Apisix.Ssl
 	if !ssl.Enable || len(ssl.Listen) == 0 {
 		return nil
 	}
@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+// RegisteredPluginPlan is the primary plan reported for factories added with
+// Register instead of the built-in capability manifest.
+const RegisteredPluginPlan = "Registered"
+
+// Registration declares one out-of-tree plugin factory. It carries the same
+// declarations the built-in tables hold for an exact factory key: the
+// request-stage owner, bounded and streaming response ownership, and log
+// executor participation. Schemas come from the constructed plugin's
+// GetSchema and GetMetadataSchema.
+type Registration struct {
+	// Name is the factory key used by route, service, consumer, and global
+	// plugin configs. The constructed plugin must report it from GetName.
+	Name    string
+	Factory func() Plugin
+
+	// RequestStage is the request-phase owner. A staged plugin either
+	// implements base.RequestPhasePlugin or sets AdaptLegacyHandler for a
+	// Handler with no post-next work; consumer authenticators keep Handler.
+	RequestStage RequestStageSpec
+	// ConditionalTerminal marks a request owner that may answer the request
+	// itself, so streaming response headers stay buffered behind it.
+	ConditionalTerminal bool
+
+	// ResponsePhases selects the bounded header, body, and final-store
+	// callbacks the response executor runs.
+	ResponsePhases ResponsePhaseMask
+	// ResponseCapability declares streaming header/body filters and
+	// compression offers. Protocol and subsystem ownership stay built-in.
+	ResponseCapability *ResponseCapability
+
+	// Log runs base.LogPhasePlugin in the log executor; LogSanitizer runs
+	// base.LogSnapshotSanitizerPlugin on the detached snapshot before it.
+	Log          bool
+	LogSanitizer bool
+}
+
+var (
+	registeredPluginNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+
+	// registeredPlugins holds the declarations of factories added with
+	// Register; built-in factories are never listed here.
+	registeredPlugins = map[string]Registration{}
+)
+
+// Register adds an out-of-tree plugin factory. It is meant to be called from
+// an init function of the package that implements the plugin, before the
+// binary's main calls cmd.Execute; the registries are not synchronized for
+// registration while routes are being built. The factory is constructed and
+// initialized once and must pass the checks every built-in factory passes.
+// A name that is already registered, built-in or not, is rejected.
+func Register(registration Registration) error {
+	name := registration.Name
+	if !registeredPluginNamePattern.MatchString(name) {
+		return fmt.Errorf("register plugin %q: name must match %s", name, registeredPluginNamePattern)
+	}
+	if _, exists := pluginRegistry[name]; exists {
+		return fmt.Errorf("register plugin %q: name is already registered", name)
+	}
+	if _, exists := capabilityRegistry[name]; exists {
+		return fmt.Errorf("register plugin %q: name is already a capability identity", name)
+	}
+	if registration.Factory == nil {
+		return fmt.Errorf("register plugin %q: nil factory", name)
+	}
+	instance := registration.Factory()
+	if instance == nil {
+		return fmt.Errorf("register plugin %q: factory returned nil", name)
+	}
+	if err := instance.Init(); err != nil {
+		return fmt.Errorf("register plugin %q: Init() error: %w", name, err)
+	}
+	if got := instance.GetName(); got != name {
+		return fmt.Errorf("register plugin %q: factory builds plugin named %q", name, got)
+	}
+	for _, validate := range []func(Registration, Plugin) error{
+		validateRegisteredSchemas,
+		validateRegisteredRequestStage,
+		validateRegisteredResponse,
+		validateRegisteredLog,
+	} {
+		if err := validate(registration, instance); err != nil {
+			return fmt.Errorf("register plugin %q: %w", name, err)
+		}
+	}
+	if !registration.declaresCapability() {
+		return fmt.Errorf("register plugin %q: declares no request, response, or log capability", name)
+	}
+
+	pluginRegistry[name] = registration.Factory
+	requestStageRegistry[name] = registration.RequestStage
+	if phases := registration.ResponsePhases; phases != 0 {
+		responseFactoryRegistry[name] = responseFactorySpec{
+			mask:        phases,
+			allowHeader: phases&ResponsePhaseHeader != 0,
+			allowBody:   phases&ResponsePhaseBufferedBody != 0,
+		}
+	}
+	if capability := registration.ResponseCapability; capability != nil {
+		responseCapabilityRegistry[name] = *capability
+	}
+	registeredPlugins[name] = registration
+
+	spec := CapabilitySpec{Identity: name, ImplementationName: name, PrimaryPlan: RegisteredPluginPlan}
+	if registration.LogSanitizer {
+		spec.Capabilities |= CapabilityLogSanitizer
+	}
+	capabilityRegistry[name] = deriveCapabilitySpec(name, spec)
+	return nil
+}
+
+// MustRegister is Register for init functions; it panics on a rejected
+// registration so a misdeclared plugin stops the binary before it serves.
+func MustRegister(registration Registration) {
+	if err := Register(registration); err != nil {
+		panic(err)
+	}
+}
+
+func (r Registration) declaresCapability() bool {
+	return r.RequestStage.Stage != RequestStageNone || r.ResponsePhases != 0 ||
+		(r.ResponseCapability != nil && *r.ResponseCapability != ResponseCapability{}) ||
+		r.Log || r.LogSanitizer
+}
+
+func validateRegisteredSchemas(_ Registration, instance Plugin) error {
+	if _, err := util.CompileSchema(instance.GetSchema()); err != nil {
+		return fmt.Errorf("schema: %w", err)
+	}
+	if schema := instance.GetMetadataSchema(); schema != "" {
+		if _, err := util.CompileSchema(schema); err != nil {
+			return fmt.Errorf("metadata schema: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateRegisteredRequestStage(registration Registration, instance Plugin) error {
+	spec := registration.RequestStage
+	switch spec.Stage {
+	case RequestStageNone:
+		if spec.AdaptLegacyHandler || spec.AuthenticatesConsumer || registration.ConditionalTerminal {
+			return fmt.Errorf("request stage none cannot own request handling")
+		}
+		return nil
+	case RequestStageRewrite, RequestStageConsumerRewrite, RequestStageAccess, RequestStageBeforeProxy:
+	default:
+		return fmt.Errorf("request stage %d is not a production request owner", spec.Stage)
+	}
+	if spec.ConfigAware {
+		return fmt.Errorf("config-aware request stages are limited to built-in factories")
+	}
+	if spec.ConsumerConfigOnly && !spec.AuthenticatesConsumer {
+		return fmt.Errorf("consumer-config-only requires a consumer authenticator")
+	}
+	if spec.AuthenticatesConsumer {
+		if spec.Stage != RequestStageAccess {
+			return fmt.Errorf("consumer authenticators run in the access stage")
+		}
+		return nil
+	}
+	if _, ok := instance.(base.RequestPhasePlugin); !ok && !spec.AdaptLegacyHandler {
+		return fmt.Errorf("request stage owner needs RunRequestPhase or an adapted legacy handler")
+	}
+	return nil
+}
+
+func validateRegisteredResponse(registration Registration, instance Plugin) error {
+	phases := registration.ResponsePhases
+	if phases&^(ResponsePhaseHeader|ResponsePhaseBufferedBody|ResponsePhaseFinalStore) != 0 {
+		return fmt.Errorf("unsupported response phases %d", phases)
+	}
+	if phases == 0 && hasResponseCallbacks(instance) {
+		return fmt.Errorf("response callback is undeclared")
+	}
+	if err := validateResponseCallbacks(Binding{Plugin: instance, factoryName: registration.Name}, phases); err != nil {
+		return err
+	}
+
+	capability := registration.ResponseCapability
+	if capability == nil {
+		return nil
+	}
+	if capability.ExclusiveProtocol != ProtocolNone || capability.SeparateSubsystem ||
+		capability.StreamingResponseOwner {
+		return fmt.Errorf("protocol and subsystem response ownership is limited to built-in factories")
+	}
+	if capability.BufferedBodyFilter && phases&ResponsePhaseBufferedBody == 0 {
+		return fmt.Errorf("buffered body capability requires the buffered body response phase")
+	}
+	if _, ok := instance.(base.StreamingHeaderFilterPlugin); capability.HeaderFilter && !ok {
+		return fmt.Errorf("declares streaming header filter without callback")
+	}
+	if _, ok := instance.(base.StreamingBodyFilterPlugin); capability.StreamingBodyFilter && !ok {
+		return fmt.Errorf("declares streaming body filter without callback")
+	}
+	if _, ok := instance.(CompressionOfferPlugin); capability.CompressionOffer && !ok {
+		return fmt.Errorf("declares compression offer without callback")
+	}
+	return nil
+}
+
+func validateRegisteredLog(registration Registration, instance Plugin) error {
+	if _, ok := instance.(base.LogPhasePlugin); registration.Log && !ok {
+		return fmt.Errorf("declares log ownership without callback")
+	}
+	if _, ok := instance.(base.LogSnapshotSanitizerPlugin); registration.LogSanitizer && !ok {
+		return fmt.Errorf("declares log sanitizer ownership without callback")
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package plugin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+)
+
+const registeredTestSchema = `{"type": "object", "properties": {"realm": {"type": "string"}}}`
+
+// registeredTestPlugin is a Handler-only plugin; the embedding types add the
+// phase callbacks a registration declares.
+type registeredTestPlugin struct {
+	base.BasePlugin
+	name   string
+	schema string
+}
+
+func (p *registeredTestPlugin) Init() error {
+	p.Name = p.name
+	p.Schema = p.schema
+	return nil
+}
+func (p *registeredTestPlugin) PostInit() error                        { return nil }
+func (p *registeredTestPlugin) Handler(next http.Handler) http.Handler { return next }
+func (p *registeredTestPlugin) Config() any                            { return nil }
+
+type registeredAccessTestPlugin struct {
+	registeredTestPlugin
+}
+
+func (p *registeredAccessTestPlugin) RunRequestPhase(
+	_ http.ResponseWriter,
+	r *http.Request,
+) base.RequestPhaseResult {
+	return base.ContinueRequest(r)
+}
+
+type registeredLogTestPlugin struct {
+	registeredTestPlugin
+}
+
+func (p *registeredLogTestPlugin) RunLogPhase(base.LogSnapshot) error { return nil }
+
+func registerForTest(t *testing.T, registration Registration) error {
+	t.Helper()
+	if err := Register(registration); err != nil {
+		return err
+	}
+	t.Cleanup(func() {
+		delete(pluginRegistry, registration.Name)
+		delete(requestStageRegistry, registration.Name)
+		delete(responseFactoryRegistry, registration.Name)
+		delete(responseCapabilityRegistry, registration.Name)
+		delete(capabilityRegistry, registration.Name)
+		delete(registeredPlugins, registration.Name)
+	})
+	return nil
+}
+
+func TestRegisterAddsFactoryToEveryRegistry(t *testing.T) {
+	err := registerForTest(t, Registration{
+		Name: "corp-auth",
+		Factory: func() Plugin {
+			return &registeredAccessTestPlugin{registeredTestPlugin{name: "corp-auth", schema: registeredTestSchema}}
+		},
+		RequestStage:        RequestStageSpec{Stage: RequestStageAccess},
+		ConditionalTerminal: true,
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	p := New("corp-auth")
+	if p == nil {
+		t.Fatal("New(corp-auth) = nil after Register")
+	}
+	if stage, ok := RequestStageFor("corp-auth"); !ok || stage.Stage != RequestStageAccess {
+		t.Fatalf("RequestStageFor(corp-auth) = %#v/%v, want access", stage, ok)
+	}
+	spec, ok := CapabilitySpecForFactory("corp-auth")
+	if !ok || spec.PrimaryPlan != RegisteredPluginPlan ||
+		spec.Capabilities != CapabilityRequestAccess|CapabilityConditionalTerminal {
+		t.Fatalf("capability spec = %#v/%v, want registered access conditional terminal", spec, ok)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	binding, err := BindPluginChecked(
+		"corp-auth", p, ScopeRoute, ResourceProvenance{Kind: ResourceRoute, ID: "r1"},
+	)
+	if err != nil {
+		t.Fatalf("BindPluginChecked() error = %v", err)
+	}
+	if binding.Stage != RequestStageAccess {
+		t.Fatalf("binding stage = %d, want access", binding.Stage)
+	}
+}
+
+func TestRegisterJoinsLogExecutor(t *testing.T) {
+	err := registerForTest(t, Registration{
+		Name: "corp-logger",
+		Factory: func() Plugin {
+			return &registeredLogTestPlugin{registeredTestPlugin{name: "corp-logger", schema: registeredTestSchema}}
+		},
+		RequestStage: RequestStageSpec{Stage: RequestStageNone},
+		Log:          true,
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	p := New("corp-logger")
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	binding, err := BindPluginChecked(
+		"corp-logger", p, ScopeRoute, ResourceProvenance{Kind: ResourceRoute, ID: "r1"},
+	)
+	if err != nil {
+		t.Fatalf("BindPluginChecked() error = %v", err)
+	}
+	executor, err := NewLogExecutorFromBindings([]Binding{binding})
+	if err != nil {
+		t.Fatalf("NewLogExecutorFromBindings() error = %v", err)
+	}
+	if bindings := executor.Bindings(); len(bindings) != 1 || bindings[0].Plugin != p {
+		t.Fatalf("log bindings = %#v, want the registered logger", bindings)
+	}
+}
+
+func TestRegisterRejectsCollisionsAndInvalidDeclarations(t *testing.T) {
+	access := func(name, schema string) func() Plugin {
+		return func() Plugin {
+			return &registeredAccessTestPlugin{registeredTestPlugin{name: name, schema: schema}}
+		}
+	}
+	handlerOnly := func(name string) func() Plugin {
+		return func() Plugin { return &registeredTestPlugin{name: name, schema: registeredTestSchema} }
+	}
+	accessStage := RequestStageSpec{Stage: RequestStageAccess}
+	if err := registerForTest(t, Registration{
+		Name: "corp-taken", Factory: access("corp-taken", registeredTestSchema), RequestStage: accessStage,
+	}); err != nil {
+		t.Fatalf("Register(corp-taken) error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		registration Registration
+		want         string
+	}{
+		{
+			name:         "built-in name",
+			registration: Registration{Name: "key-auth", Factory: access("key-auth", registeredTestSchema)},
+			want:         "already registered",
+		},
+		{
+			name:         "registered name",
+			registration: Registration{Name: "corp-taken", Factory: access("corp-taken", registeredTestSchema)},
+			want:         "already registered",
+		},
+		{
+			name:         "invalid name",
+			registration: Registration{Name: "Corp_Auth", Factory: access("Corp_Auth", registeredTestSchema)},
+			want:         "name must match",
+		},
+		{
+			name:         "nil factory",
+			registration: Registration{Name: "corp-nil", RequestStage: accessStage},
+			want:         "nil factory",
+		},
+		{
+			name: "name drift",
+			registration: Registration{
+				Name: "corp-drift", Factory: access("corp_drift", registeredTestSchema), RequestStage: accessStage,
+			},
+			want: `builds plugin named "corp_drift"`,
+		},
+		{
+			name: "invalid schema",
+			registration: Registration{
+				Name: "corp-schema", Factory: access("corp-schema", `{"type": 5}`), RequestStage: accessStage,
+			},
+			want: "schema",
+		},
+		{
+			name: "legacy stage",
+			registration: Registration{
+				Name: "corp-legacy", Factory: access("corp-legacy", registeredTestSchema),
+				RequestStage: RequestStageSpec{Stage: RequestStageLegacy},
+			},
+			want: "not a production request owner",
+		},
+		{
+			name: "config-aware stage",
+			registration: Registration{
+				Name: "corp-aware", Factory: access("corp-aware", registeredTestSchema),
+				RequestStage: RequestStageSpec{Stage: RequestStageAccess, ConfigAware: true},
+			},
+			want: "config-aware",
+		},
+		{
+			name: "stage without request phase",
+			registration: Registration{
+				Name: "corp-handler", Factory: handlerOnly("corp-handler"), RequestStage: accessStage,
+			},
+			want: "RunRequestPhase",
+		},
+		{
+			name: "log without callback",
+			registration: Registration{
+				Name: "corp-nolog", Factory: access("corp-nolog", registeredTestSchema),
+				RequestStage: accessStage, Log: true,
+			},
+			want: "log ownership without callback",
+		},
+		{
+			name: "header phase without callback",
+			registration: Registration{
+				Name: "corp-header", Factory: access("corp-header", registeredTestSchema),
+				RequestStage: accessStage, ResponsePhases: ResponsePhaseHeader,
+			},
+			want: "header filter without callback",
+		},
+		{
+			name: "protocol ownership",
+			registration: Registration{
+				Name: "corp-protocol", Factory: access("corp-protocol", registeredTestSchema),
+				RequestStage:       accessStage,
+				ResponseCapability: &ResponseCapability{ExclusiveProtocol: ProtocolKafka},
+			},
+			want: "limited to built-in factories",
+		},
+		{
+			name: "no capability",
+			registration: Registration{
+				Name: "corp-idle", Factory: handlerOnly("corp-idle"),
+				RequestStage: RequestStageSpec{Stage: RequestStageNone},
+			},
+			want: "declares no request, response, or log capability",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := registerForTest(t, test.registration)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("Register() error = %v, want %q", err, test.want)
+			}
+			if test.registration.Name != "key-auth" && test.registration.Name != "corp-taken" &&
+				New(test.registration.Name) != nil {
+				t.Fatalf("rejected registration %q is constructible", test.registration.Name)
+			}
+		})
+	}
+}
+
+func TestMustRegisterPanicsOnRejectedRegistration(t *testing.T) {
+	defer func() {
+		if recovered := recover(); recovered == nil {
+			t.Fatal("MustRegister did not panic for a built-in name")
+		}
+	}()
+	MustRegister(Registration{Name: "key-auth"})
+}
@@ -60,6 +60,7 @@ var requestStageRegistry = map[string]RequestStageSpec{
 	"echo":                     {Stage: RequestStageNone, ConfigAware: true},
 	"error-page":               {Stage: RequestStageNone},
 	"exit-transformer":         {Stage: RequestStageNone},
+	"ext-plugin-post-resp":     {Stage: RequestStageNone},
 	"graphql-proxy-cache":      {Stage: RequestStageAccess},
 	"proxy-cache":              {Stage: RequestStageAccess},
 	"response-rewrite":         {Stage: RequestStageNone, ConfigAware: true},
@@ -67,6 +68,7 @@ var requestStageRegistry = map[string]RequestStageSpec{
 	"serverless-post-function": {Stage: RequestStageNone, ConfigAware: true},
 	"request-context":          {Stage: RequestStageRewrite},
 	"request-id":               {Stage: RequestStageRewrite},
+	"ext-plugin-pre-req":       {Stage: RequestStageRewrite},
 	"opentelemetry":            {Stage: RequestStageRewrite},
 	"skywalking":               {Stage: RequestStageRewrite},
 	"zipkin":                   {Stage: RequestStageRewrite},
@@ -90,6 +92,7 @@ var requestStageRegistry = map[string]RequestStageSpec{
 	"redirect":                 {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
 
 	"limit-conn":                   {Stage: RequestStageAccess},
+	"ext-plugin-post-req":          {Stage: RequestStageAccess},
 	"ai-aliyun-content-moderation": {Stage: RequestStageAccess, AdaptLegacyHandler: true},
 	"ai-proxy":                     {Stage: RequestStageAccess, AdaptLegacyHandler: true},
 	"ai-proxy-multi":               {Stage: RequestStageAccess, AdaptLegacyHandler: true},
@@ -44,9 +44,11 @@ func TestRequestStageRegistryExactMembership(t *testing.T) {
 		"tencent-cloud-cls":            {Stage: RequestStageNone},
 		"udp-logger":                   {Stage: RequestStageNone},
 		"api-breaker":                  {Stage: RequestStageAccess},
+		"ext-plugin-post-req":          {Stage: RequestStageAccess},
 		"body-transformer":             {Stage: RequestStageNone, ConfigAware: true},
 		"echo":                         {Stage: RequestStageNone, ConfigAware: true},
 		"error-page":                   {Stage: RequestStageNone},
+		"ext-plugin-post-resp":         {Stage: RequestStageNone},
 		"exit-transformer":             {Stage: RequestStageNone},
 		"graphql-proxy-cache":          {Stage: RequestStageAccess},
 		"proxy-cache":                  {Stage: RequestStageAccess},
@@ -73,6 +75,7 @@ func TestRequestStageRegistryExactMembership(t *testing.T) {
 		"example-plugin":               {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
 		"jwe-decrypt":                  {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
 		"cors":                         {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
+		"ext-plugin-pre-req":           {Stage: RequestStageRewrite},
 		"fault-injection":              {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
 		"proxy-buffering":              {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
 		"redirect":                     {Stage: RequestStageRewrite, AdaptLegacyHandler: true},
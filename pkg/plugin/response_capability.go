@@ -63,27 +63,29 @@ var responseCapabilityRegistry = map[string]ResponseCapability{
 	"ai-proxy": {
 		StreamingResponseOwner: true, ExclusiveProtocol: ProtocolAI,
 	},
-	"ai-proxy-multi":   {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolAI},
-	"ai-rate-limiting": {BufferedBodyFilter: true, StreamingBodyFilter: true},
-	"aws-lambda":       {},
-	"azure-functions":  {},
-	"brotli":           {HeaderFilter: true, StreamingBodyFilter: true, CompressionOffer: true},
-	"cors":             {HeaderFilter: true},
-	"dubbo-proxy":      {ExclusiveProtocol: ProtocolDubbo, SeparateSubsystem: true},
-	"fault-injection":  {},
-	"grpc-transcode":   {BufferedBodyFilter: true},
-	"grpc-web":         {HeaderFilter: true, StreamingBodyFilter: true, ExclusiveProtocol: ProtocolGRPCWeb},
-	"gzip":             {HeaderFilter: true, StreamingBodyFilter: true, CompressionOffer: true},
-	"http-dubbo":       {ExclusiveProtocol: ProtocolHTTPDubbo, SeparateSubsystem: true},
-	"kafka-proxy":      {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolKafka, SeparateSubsystem: true},
-	"mcp-bridge":       {StreamingResponseOwner: true},
-	"mocking":          {},
-	"mqtt-proxy":       {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolMQTT, SeparateSubsystem: true},
-	"openfunction":     {},
-	"openwhisk":        {},
-	"proxy-buffering":  {StreamingBodyFilter: true},
-	"public-api":       {SeparateSubsystem: true},
-	"redirect":         {},
+	"ai-proxy-multi":      {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolAI},
+	"ai-rate-limiting":    {BufferedBodyFilter: true, StreamingBodyFilter: true},
+	"aws-lambda":          {},
+	"azure-functions":     {},
+	"brotli":              {HeaderFilter: true, StreamingBodyFilter: true, CompressionOffer: true},
+	"cors":                {HeaderFilter: true},
+	"dubbo-proxy":         {ExclusiveProtocol: ProtocolDubbo, SeparateSubsystem: true},
+	"ext-plugin-pre-req":  {HeaderFilter: true},
+	"ext-plugin-post-req": {HeaderFilter: true},
+	"fault-injection":     {},
+	"grpc-transcode":      {BufferedBodyFilter: true},
+	"grpc-web":            {HeaderFilter: true, StreamingBodyFilter: true, ExclusiveProtocol: ProtocolGRPCWeb},
+	"gzip":                {HeaderFilter: true, StreamingBodyFilter: true, CompressionOffer: true},
+	"http-dubbo":          {ExclusiveProtocol: ProtocolHTTPDubbo, SeparateSubsystem: true},
+	"kafka-proxy":         {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolKafka, SeparateSubsystem: true},
+	"mcp-bridge":          {StreamingResponseOwner: true},
+	"mocking":             {},
+	"mqtt-proxy":          {StreamingResponseOwner: true, ExclusiveProtocol: ProtocolMQTT, SeparateSubsystem: true},
+	"openfunction":        {},
+	"openwhisk":           {},
+	"proxy-buffering":     {StreamingBodyFilter: true},
+	"public-api":          {SeparateSubsystem: true},
+	"redirect":            {},
 }
 
 func ResponseCapabilityFor(factory string) (ResponseCapability, bool) {
@@ -465,7 +467,7 @@ func compatibleBoundedAdapter(binding Binding, capability ResponseCapability) bo
 		return false
 	}
 	switch binding.factoryName {
-	case "gzip", "brotli", "cors":
+	case "gzip", "brotli", "cors", "ext-plugin-pre-req", "ext-plugin-post-req":
 		return true
 	default:
 		return false
@@ -557,6 +559,7 @@ var responseFactoryRegistry = map[string]responseFactorySpec{
 	"echo":                         {configAware: true, allowHeader: true, allowBody: true},
 	"error-page":                   {mask: ResponsePhaseBufferedBody, allowBody: true},
 	"exit-transformer":             {mask: ResponsePhaseBufferedBody, allowBody: true},
+	"ext-plugin-post-resp":         {mask: ResponsePhaseBufferedBody, allowBody: true},
 	"graphql-proxy-cache":          {mask: ResponsePhaseFinalStore},
 	"grpc-transcode":               {mask: ResponsePhaseBufferedBody, allowBody: true},
 	"proxy-cache":                  {mask: ResponsePhaseFinalStore},
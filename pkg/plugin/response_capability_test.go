@@ -420,7 +420,7 @@ func TestDynamicResponseRewriteConditionalFallbackRunsExactlyOnce(t *testing.T)
 func TestResponseRegistryHasExactDeclaredIdentities(t *testing.T) {
 	responseWant := []string{
 		"api-breaker", "body-transformer", "echo", "error-page", "exit-transformer",
-		"graphql-proxy-cache", "proxy-cache", "response-rewrite",
+		"ext-plugin-post-resp", "graphql-proxy-cache", "proxy-cache", "response-rewrite",
 		"serverless-post-function", "serverless-pre-function",
 	}
 	registryWant := append([]string{"ai-aliyun-content-moderation", "ai-rate-limiting"}, responseWant...)
@@ -527,6 +527,10 @@ func (s *responseExecution) complete() {
 	}
 
 	holder := s.holder
+	if source != apisixctx.ResponseSourceCacheHit && s.finalResponse && !holder.Published() &&
+		s.runErrorResponseFallbacks(source) {
+		source = apisixctx.ResponseSourceCacheHit
+	}
 	if source == apisixctx.ResponseSourceCacheHit {
 		cached, published, err := holder.ConsumePublished()
 		if err != nil || !published {
@@ -623,6 +627,26 @@ func (s *responseExecution) runStores(
 	}
 }
 
+// runErrorResponseFallbacks lets a final response store serve a cached
+// response in place of the captured one. The replacement is committed as a
+// cache hit, so no filter runs on it and nothing stores it again.
+func (s *responseExecution) runErrorResponseFallbacks(source apisixctx.ResponseSource) bool {
+	for _, binding := range s.plan {
+		if binding.Phases&ResponsePhaseFinalStore == 0 || !eligible(binding.Plugin, source) {
+			continue
+		}
+		fallback, ok := binding.Plugin.(base.ErrorResponseFallbackPlugin)
+		if !ok || !fallback.RunErrorResponseFallback(s.request, s.capture.StatusCode()) {
+			continue
+		}
+		s.lifecycle.SetResponseSource(apisixctx.ResponseSourceCacheHit)
+		apisixctx.SetRequestResponseSource(s.originRequest, apisixctx.ResponseSourceCacheHit)
+		apisixctx.SetRequestResponseSource(s.request, apisixctx.ResponseSourceCacheHit)
+		return true
+	}
+	return false
+}
+
 func eligible(plugin Plugin, source apisixctx.ResponseSource) bool {
 	if source == apisixctx.ResponseSourceCacheHit {
 		return false
@@ -165,12 +165,13 @@ type Config struct {
 	MaxReqBodyBytes     int     `json:"max_req_body_bytes,omitempty"`
 	MaxRespBodyBytes    int     `json:"max_resp_body_bytes,omitempty"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 }
 
 type pluginMetadata struct {
@@ -205,7 +206,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	p.InitLogger(p.Send)
 
@@ -271,6 +272,7 @@ func (p *Plugin) PostInit() error {
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
 		PluginID:           name,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 	return nil
 }
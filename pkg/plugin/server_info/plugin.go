@@ -76,10 +76,11 @@ func CurrentInfo() Response {
 
 func ReportTTL() time.Duration {
 	ttl := defaultReportTTL
-	if config.GlobalConfig == nil || config.GlobalConfig.PluginAttr == nil {
+	cfg := config.Global()
+	if cfg == nil || cfg.PluginAttr == nil {
 		return ttl
 	}
-	attr := config.GlobalConfig.PluginAttr[name]
+	attr := cfg.PluginAttr[name]
 	if attr == nil {
 		return ttl
 	}
@@ -0,0 +1,244 @@
+package serverless
+
+import (
+	"strings"
+	"time"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/logger"
+	pluginexpr "github.com/wklken/apisix-go/pkg/plugin/expr"
+	"github.com/wklken/apisix-go/pkg/plugin/luautil"
+	"github.com/wklken/apisix-go/pkg/shared"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ngxCtxVar keys the request's ngx.ctx between serverless phases. It has no
+// `$` prefix, so variable lookups and log formats never resolve it, but it
+// travels with the request variables into the detached log phase.
+const ngxCtxVar = "serverless.ngx_ctx"
+
+// ngx.log levels, as numbered by nginx.
+const (
+	ngxStderr = iota
+	ngxEmerg
+	ngxAlert
+	ngxCrit
+	ngxErr
+	ngxWarn
+	ngxNotice
+	ngxInfo
+	ngxDebug
+)
+
+func (r *luaRunner) registerLogLevels(ngx *lua.LTable) {
+	for name, level := range map[string]int{
+		"STDERR": ngxStderr,
+		"EMERG":  ngxEmerg,
+		"ALERT":  ngxAlert,
+		"CRIT":   ngxCrit,
+		"ERR":    ngxErr,
+		"WARN":   ngxWarn,
+		"NOTICE": ngxNotice,
+		"INFO":   ngxInfo,
+		"DEBUG":  ngxDebug,
+	} {
+		ngx.RawSetString(name, lua.LNumber(level))
+	}
+}
+
+// ngxLog writes the remaining arguments, concatenated, to the Go logger at
+// the level of the first.
+func (r *luaRunner) ngxLog(l *lua.LState) int {
+	level := l.CheckInt(1)
+	var message strings.Builder
+	for i := 2; i <= l.GetTop(); i++ {
+		message.WriteString(luaValueToString(l.Get(i)))
+	}
+	switch {
+	case level <= ngxErr:
+		logger.Errorf("%s: %s", r.name, message.String())
+	case level == ngxWarn:
+		logger.Warnf("%s: %s", r.name, message.String())
+	case level <= ngxInfo:
+		logger.Infof("%s: %s", r.name, message.String())
+	default:
+		logger.Debugf("%s: %s", r.name, message.String())
+	}
+	return 0
+}
+
+// loadNgxCtx restores the request's ngx.ctx from an earlier phase.
+func (r *luaRunner) loadNgxCtx() *lua.LTable {
+	if r.req != nil {
+		if saved, ok := luautil.GoValueToLua(r.state, apisixctx.GetRequestVar(r.req, ngxCtxVar)).(*lua.LTable); ok {
+			return saved
+		}
+	}
+	return r.state.NewTable()
+}
+
+// saveNgxCtx keeps ngx.ctx, converted to plain values, for the next phase of
+// the request.
+func (r *luaRunner) saveNgxCtx() {
+	if r.req == nil {
+		return
+	}
+	ngx, _ := r.state.GetGlobal("ngx").(*lua.LTable)
+	if ngx == nil {
+		return
+	}
+	if ctx, ok := ngx.RawGetString("ctx").(*lua.LTable); ok {
+		apisixctx.RegisterRequestVar(r.req, ngxCtxVar, luautil.LuaTableToGo(ctx))
+	}
+}
+
+// varTable is ngx.var: reads resolve request variables, with unset ones
+// reading as nil, and writes register custom request variables that later
+// phases, route vars and log formats see. Writing a built-in variable such as
+// uri or remote_addr raises an error, as nginx does for variables that are
+// not changeable, instead of registering a value no read would return.
+func (r *luaRunner) varTable() *lua.LTable {
+	l := r.state
+	vars := l.NewTable()
+	meta := l.NewTable()
+	meta.RawSetString("__index", l.NewFunction(func(l *lua.LState) int {
+		if r.req == nil {
+			l.Push(lua.LNil)
+			return 1
+		}
+		value := pluginexpr.RequestValue(r.req, l.CheckString(2))
+		if value == nil || value == "" {
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.Push(luautil.GoValueToLua(l, value))
+		return 1
+	}))
+	meta.RawSetString("__newindex", l.NewFunction(func(l *lua.LState) int {
+		name := l.CheckString(2)
+		if pluginexpr.BuiltinVariable(name) {
+			l.RaiseError("variable %q not changeable", name)
+			return 0
+		}
+		if r.req != nil {
+			apisixctx.RegisterRequestVar(r.req, "$"+name, luautil.LuaValueToGo(l.Get(3)))
+		}
+		return 0
+	}))
+	l.SetMetatable(vars, meta)
+	return vars
+}
+
+// sharedTable is ngx.shared; indexing it by name yields the declared or
+// plugin-used zone, or nil.
+func (r *luaRunner) sharedTable() *lua.LTable {
+	l := r.state
+	dicts := l.NewTable()
+	meta := l.NewTable()
+	meta.RawSetString("__index", l.NewFunction(func(l *lua.LState) int {
+		name := l.CheckString(2)
+		dict := shared.LookupZone(name)
+		if dict == nil {
+			l.Push(lua.LNil)
+			return 1
+		}
+		table := r.dictTable(dict)
+		dicts.RawSetString(name, table)
+		l.Push(table)
+		return 1
+	}))
+	l.SetMetatable(dicts, meta)
+	return dicts
+}
+
+// dictTable exposes the ngx.shared.DICT methods, called as dict:method(...).
+func (r *luaRunner) dictTable(dict *shared.Dict) *lua.LTable {
+	l := r.state
+	methods := l.NewTable()
+	methods.RawSetString("get", l.NewFunction(func(l *lua.LState) int {
+		value, ok := dict.Get(l.CheckString(2))
+		if !ok {
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.Push(luautil.GoValueToLua(l, value))
+		return 1
+	}))
+	methods.RawSetString("set", l.NewFunction(func(l *lua.LState) int {
+		value := checkDictValue(l, 3)
+		return pushDictResult(l, dict.Set(l.CheckString(2), value, checkDictTTL(l, 4)))
+	}))
+	methods.RawSetString("add", l.NewFunction(func(l *lua.LState) int {
+		value := checkDictValue(l, 3)
+		return pushDictResult(l, dict.Add(l.CheckString(2), value, checkDictTTL(l, 4)))
+	}))
+	methods.RawSetString("incr", l.NewFunction(func(l *lua.LState) int {
+		key := l.CheckString(2)
+		delta := float64(l.CheckNumber(3))
+		var init *float64
+		if l.Get(4) != lua.LNil {
+			value := float64(l.CheckNumber(4))
+			init = &value
+		}
+		value, err := dict.Incr(key, delta, init, checkDictTTL(l, 5))
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(lua.LNumber(value))
+		return 1
+	}))
+	methods.RawSetString("delete", l.NewFunction(func(l *lua.LState) int {
+		dict.Delete(l.CheckString(2))
+		return 0
+	}))
+	methods.RawSetString("ttl", l.NewFunction(func(l *lua.LState) int {
+		ttl, err := dict.TTL(l.CheckString(2))
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(lua.LNumber(ttl.Seconds()))
+		return 1
+	}))
+	return methods
+}
+
+// checkDictValue accepts the value types a shared dict stores.
+func checkDictValue(l *lua.LState, n int) any {
+	switch value := l.Get(n).(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LString:
+		return string(value)
+	case lua.LNumber:
+		return float64(value)
+	case lua.LBool:
+		return bool(value)
+	default:
+		l.ArgError(n, "bad value type")
+		return nil
+	}
+}
+
+// checkDictTTL reads an optional expiry in seconds.
+func checkDictTTL(l *lua.LState, n int) time.Duration {
+	seconds := float64(l.OptNumber(n, 0))
+	if seconds < 0 {
+		l.ArgError(n, "bad exptime")
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// pushDictResult returns set/add results as ngx_lua does: ok and err.
+func pushDictResult(l *lua.LState, err error) int {
+	if err != nil {
+		l.Push(lua.LFalse)
+		l.Push(lua.LString(err.Error()))
+		return 2
+	}
+	l.Push(lua.LTrue)
+	return 1
+}
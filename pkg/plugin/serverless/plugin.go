@@ -3,6 +3,7 @@ package serverless
 import (
 	"bytes"
 	"fmt"
+	"maps"
 	"math"
 	"net/http"
 	"net/url"
@@ -291,6 +292,11 @@ func detachedRequest(snapshot base.LogSnapshot) (*http.Request, error) {
 	req.Host = snapshot.Request.Host
 	req.RemoteAddr = snapshot.Request.RemoteAddr
 	req.Header = snapshot.Request.Header.Clone()
+	// Restore the variables so ngx.var and ngx.ctx read what the request
+	// phases left.
+	req = apisixctx.WithApisixVars(apisixctx.WithRequestVars(req), nil)
+	maps.Copy(apisixctx.GetApisixVars(req), snapshot.Request.APISIXVars)
+	maps.Copy(apisixctx.GetRequestVars(req), snapshot.Request.RequestVars)
 	return req, nil
 }
 
@@ -338,7 +344,9 @@ func responseSource(r *http.Request) apisixctx.ResponseSource {
 
 func (p *Plugin) runFunctions(r *http.Request, resp *base.BufferedResponseWriter) (luaResult, error) {
 	runner := newLuaRunner(r, resp)
+	runner.name = p.Name
 	defer runner.close()
+	defer runner.saveNgxCtx()
 
 	for _, proto := range p.compiled {
 		fn, err := runner.loadFunction(proto)
@@ -397,6 +405,7 @@ func isRequestPhase(phase string) bool {
 }
 
 type luaRunner struct {
+	name         string
 	state        *lua.LState
 	req          *http.Request
 	resp         *base.BufferedResponseWriter
@@ -499,12 +508,9 @@ func (r *luaRunner) ctxTable() lua.LValue {
 	currReq.RawSetString("_path", lua.LString(r.req.URL.Path))
 	t.RawSetString("curr_req_matched", currReq)
 
-	vars := l.NewTable()
-	vars.RawSetString("uri", lua.LString(r.req.URL.Path))
-	vars.RawSetString("request_uri", lua.LString(r.req.URL.RequestURI()))
-	vars.RawSetString("request_method", lua.LString(r.req.Method))
-	vars.RawSetString("host", lua.LString(r.req.Host))
-	t.RawSetString("var", vars)
+	if ngx, ok := l.GetGlobal("ngx").(*lua.LTable); ok {
+		t.RawSetString("var", ngx.RawGetString("var"))
+	}
 	return t
 }
 
@@ -522,12 +528,11 @@ func (r *luaRunner) persistContext() {
 func (r *luaRunner) registerNgx() {
 	l := r.state
 	ngx := l.NewTable()
-	ngx.RawSetString("ERR", lua.LNumber(3))
-	ngx.RawSetString("WARN", lua.LNumber(4))
-	ngx.RawSetString("INFO", lua.LNumber(6))
-	ngx.RawSetString("log", l.NewFunction(func(l *lua.LState) int {
-		return 0
-	}))
+	r.registerLogLevels(ngx)
+	ngx.RawSetString("log", l.NewFunction(r.ngxLog))
+	ngx.RawSetString("ctx", r.loadNgxCtx())
+	ngx.RawSetString("var", r.varTable())
+	ngx.RawSetString("shared", r.sharedTable())
 	ngx.RawSetString("say", l.NewFunction(func(l *lua.LState) int {
 		top := l.GetTop()
 		for i := 1; i <= top; i++ {
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/shared"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -462,3 +464,162 @@ func TestServerlessConcurrentRequestsDoNotRecompile(t *testing.T) {
 		t.Fatalf("compilations under concurrency = %d, want 1", got)
 	}
 }
+
+func TestServerlessNgxCtxPersistsAcrossPhases(t *testing.T) {
+	access := newTestPlugin(t, NewPreFunction(), Config{
+		Functions: []string{`return function() ngx.ctx.user = "alice"; ngx.ctx.hits = {1, 2} end`},
+	})
+	header := newTestPlugin(t, NewPostFunction(), Config{
+		Phase: "header_filter",
+		Functions: []string{`return function()
+			ngx.header["X-User"] = ngx.ctx.user .. ":" .. #ngx.ctx.hits
+			ngx.ctx.seen = true
+		end`},
+	})
+	logPhase := newTestPlugin(t, NewPostFunction(), Config{
+		Phase: "log",
+		Functions: []string{`return function()
+			if ngx.ctx.user ~= "alice" or ngx.ctx.seen ~= true then
+				error("ngx.ctx missing in log phase")
+			end
+		end`},
+	})
+
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/ctx", nil))
+	apisixctx.RegisterRequestVar(req, "$response_source", "upstream")
+	if result := access.RunRequestPhase(httptest.NewRecorder(), req); result.Decision != base.RequestContinue {
+		t.Fatalf("RunRequestPhase() decision = %v, want continue", result.Decision)
+	}
+	state := &base.ResponseState{Status: http.StatusOK, Header: http.Header{}}
+	if err := header.RunHeaderFilter(req, state); err != nil {
+		t.Fatalf("RunHeaderFilter() error = %v", err)
+	}
+	if got := state.Header.Get("X-User"); got != "alice:2" {
+		t.Fatalf("X-User = %q, want alice:2", got)
+	}
+	snapshot := base.BuildLogSnapshot(
+		req,
+		base.ResponseCaptureSnapshot{Header: state.Header},
+		apisixctx.ResponseOutcome{Status: http.StatusOK},
+		apisixctx.ResponseSourceUpstream,
+		time.Time{}, time.Time{},
+	)
+	if err := logPhase.RunLogPhase(snapshot); err != nil {
+		t.Fatalf("RunLogPhase() error = %v", err)
+	}
+
+	other := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/ctx", nil))
+	apisixctx.RegisterRequestVar(other, "$response_source", "upstream")
+	state = &base.ResponseState{Status: http.StatusOK, Header: http.Header{}}
+	if err := header.RunHeaderFilter(other, state); err == nil {
+		t.Fatal("RunHeaderFilter() error = nil, want ngx.ctx of another request to be empty")
+	}
+}
+
+func TestServerlessNgxVarReadsAndRegistersVariables(t *testing.T) {
+	p := newTestPlugin(t, NewPreFunction(), Config{
+		Functions: []string{`return function(conf, ctx)
+			if ngx.var.arg_name ~= "apisix" or ngx.var.http_x_team ~= "gateway" or ngx.var.arg_missing ~= nil then
+				return 500, "unexpected vars"
+			end
+			ngx.var.tenant = "t-" .. ngx.var.arg_name
+			if ctx.var.tenant ~= "t-apisix" then
+				return 500, "ctx.var does not share ngx.var"
+			end
+		end`},
+	})
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/vars?name=apisix", nil))
+	req.Header.Set("X-Team", "gateway")
+	rr := httptest.NewRecorder()
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := apisixctx.GetRequestVar(r, "$tenant"); got != "t-apisix" {
+			t.Errorf("$tenant = %#v, want t-apisix", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d; body=%s", rr.Code, http.StatusNoContent, rr.Body.String())
+	}
+}
+
+func TestServerlessNgxVarRejectsWritesToBuiltinVariables(t *testing.T) {
+	p := newTestPlugin(t, NewPreFunction(), Config{
+		Functions: []string{`return function()
+			for _, name in ipairs({"uri", "remote_addr", "arg_name", "http_x_team", "route_id"}) do
+				local ok, err = pcall(function() ngx.var[name] = "changed" end)
+				if ok or not string.find(err, "not changeable", 1, true) then
+					return 500, "write to " .. name .. " was accepted"
+				end
+			end
+			if ngx.var.uri ~= "/vars" then
+				return 500, "uri changed"
+			end
+		end`},
+	})
+	req := apisixctx.WithRequestVars(httptest.NewRequest(http.MethodGet, "http://example.com/vars?name=apisix", nil))
+	rr := httptest.NewRecorder()
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := apisixctx.GetRequestVar(r, "$uri"); got != nil {
+			t.Errorf("$uri request var = %#v, want none", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("response code = %d, want %d; body=%s", rr.Code, http.StatusNoContent, rr.Body.String())
+	}
+}
+
+func TestServerlessNgxSharedDict(t *testing.T) {
+	if err := shared.ConfigureZones(map[string]string{"counters": "1m"}); err != nil {
+		t.Fatalf("ConfigureZones() error = %v", err)
+	}
+	t.Cleanup(func() { _ = shared.ConfigureZones() })
+	p := newTestPlugin(t, NewPreFunction(), Config{
+		Functions: []string{`return function()
+			if ngx.shared.missing ~= nil then
+				return 500, "unexpected dict"
+			end
+			local dict = ngx.shared.counters
+			local hits = dict:incr("hits", 1, 0)
+			local ok, err = dict:add("first", "yes", 60)
+			if not ok and err ~= "exists" then
+				return 500, err
+			end
+			local ttl = dict:ttl("first")
+			dict:set("last", ngx.var.uri)
+			return 200, hits .. " " .. dict:get("first") .. " " .. dict:get("last") .. " " .. tostring(ttl > 0)
+		end`},
+	})
+	for _, want := range []string{"1 yes /anything true", "2 yes /anything true"} {
+		res := performRequest(p, func(w http.ResponseWriter, r *http.Request) {})
+		if got := res.Body.String(); got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestServerlessNgxLogWritesToLogger(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		entries []logger.Entry
+	)
+	stop := logger.ReplaceObserver("serverless-test", func(entry logger.Entry) {
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+	})
+	t.Cleanup(stop)
+	p := newTestPlugin(t, NewPreFunction(), Config{
+		Functions: []string{`return function() ngx.log(ngx.ERR, "failed for ", ngx.var.uri, " ", 3) end`},
+	})
+	performRequest(p, func(w http.ResponseWriter, r *http.Request) {})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, entry := range entries {
+		if entry.Level == "ERROR" && entry.Message == "serverless-pre-function: failed for /anything 3" {
+			return
+		}
+	}
+	t.Fatalf("log entries = %+v, want the ngx.log error", entries)
+}
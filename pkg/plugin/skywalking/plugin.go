@@ -13,7 +13,6 @@ import (
 	"github.com/go-resty/resty/v2"
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	apisixlog "github.com/wklken/apisix-go/pkg/apisix/log"
-	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin/base"
 	"github.com/wklken/apisix-go/pkg/shared"
@@ -339,10 +338,11 @@ func (p *Plugin) finishSegment(
 }
 
 func (p *Plugin) loadPluginAttr() {
-	if config.GlobalConfig == nil || config.GlobalConfig.PluginAttr == nil {
+	cfg := p.AppConfig()
+	if cfg == nil || cfg.PluginAttr == nil {
 		return
 	}
-	attr, ok := config.GlobalConfig.PluginAttr[name]
+	attr, ok := cfg.PluginAttr[name]
 	if !ok {
 		return
 	}
@@ -144,12 +144,13 @@ type Config struct {
 	MaxReqBodyBytes     int               `json:"max_req_body_bytes,omitempty"`
 	MaxRespBodyBytes    int               `json:"max_resp_body_bytes,omitempty"`
 
-	BatchMaxSize      int `json:"batch_max_size,omitempty"`
-	MaxRetryCount     int `json:"max_retry_count,omitempty"`
-	RetryDelay        int `json:"retry_delay,omitempty"`
-	BufferDuration    int `json:"buffer_duration,omitempty"`
-	InactiveTimeout   int `json:"inactive_timeout,omitempty"`
-	MaxPendingEntries int `json:"max_pending_entries,omitempty"`
+	BatchMaxSize      int  `json:"batch_max_size,omitempty"`
+	MaxRetryCount     int  `json:"max_retry_count,omitempty"`
+	RetryDelay        int  `json:"retry_delay,omitempty"`
+	BufferDuration    int  `json:"buffer_duration,omitempty"`
+	InactiveTimeout   int  `json:"inactive_timeout,omitempty"`
+	MaxPendingEntries int  `json:"max_pending_entries,omitempty"`
+	Spool             bool `json:"spool,omitempty"`
 }
 
 type skyWalkingEntry struct {
@@ -181,7 +182,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 
 	p.InitLogger(p.Send)
 
@@ -263,6 +264,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 
 	return nil
@@ -162,6 +162,7 @@ type Config struct {
 	RetryDelay        int    `json:"retry_delay,omitempty"`
 	MaxRetryCount     int    `json:"max_retry_count,omitempty"`
 	MaxPendingEntries int    `json:"max_pending_entries,omitempty"`
+	Spool             bool   `json:"spool,omitempty"`
 }
 
 type splunkEvent struct {
@@ -179,7 +180,7 @@ func (p *Plugin) Config() any {
 func (p *Plugin) Init() error {
 	p.Name = name
 	p.Priority = priority
-	p.Schema = schema
+	p.Schema = base.WithBatchSpoolSchema(schema)
 	p.MetadataSchema = metadataSchema
 
 	p.InitLogger(p.Send)
@@ -269,6 +270,7 @@ func (p *Plugin) PostInit() error {
 		BufferDurationSec:  p.config.BufferDuration,
 		InactiveTimeoutSec: p.config.InactiveTimeout,
 		MaxPendingEntries:  p.config.MaxPendingEntries,
+		Spool:              base.BatchSpool(p.AppConfig(), p.config.Spool),
 	}, p.RouteID, p.ServerAddr, p.SendBatch)
 	return nil
 }
@@ -7,7 +7,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -36,8 +35,6 @@ const (
 	name     = "traffic-split"
 )
 
-var hashVariablePattern = regexp.MustCompile(`\$\{([^}]*)\}|\$([A-Za-z0-9_.]+)`)
-
 const schema = `
 {
   "type": "object",
@@ -681,64 +678,7 @@ func (target compiledTarget) selectHashedNode(r *http.Request) string {
 }
 
 func resolveHashValue(r *http.Request, hashOn string, key string) string {
-	switch hashOn {
-	case "header":
-		return r.Header.Get(key)
-	case "cookie":
-		cookie, err := r.Cookie(key)
-		if err == nil {
-			return cookie.Value
-		}
-		return ""
-	case "consumer":
-		return pluginexpr.String(pluginexpr.RequestValue(r, "consumer_name"))
-	case "vars_combinations":
-		return resolveHashVariableCombination(r, key)
-	default:
-		return pluginexpr.String(pluginexpr.RequestValue(r, key))
-	}
-}
-
-func resolveHashVariableCombination(r *http.Request, template string) string {
-	matches := hashVariablePattern.FindAllStringSubmatchIndex(template, -1)
-	if len(matches) == 0 {
-		return ""
-	}
-
-	var value strings.Builder
-	resolved := false
-	position := 0
-	for _, match := range matches {
-		start, end := match[0], match[1]
-		if start > 0 && template[start-1] == '\\' {
-			value.WriteString(template[position:end])
-			position = end
-			continue
-		}
-
-		value.WriteString(template[position:start])
-		variableStart, variableEnd := match[2], match[3]
-		if variableStart < 0 {
-			variableStart, variableEnd = match[4], match[5]
-		}
-		variable := strings.TrimSpace(template[variableStart:variableEnd])
-		name, fallback, hasFallback := strings.Cut(variable, "??")
-		name = strings.TrimSpace(name)
-		resolvedValue := pluginexpr.String(pluginexpr.RequestValue(r, name))
-		if resolvedValue == "" && hasFallback {
-			resolvedValue = strings.TrimSpace(fallback)
-		}
-		if resolvedValue != "" {
-			resolved = true
-		}
-		value.WriteString(resolvedValue)
-		position = end
-	}
-	value.WriteString(template[position:])
-	if !resolved {
-		return ""
-	}
-	return value.String()
+	return pluginexpr.HashValue(r, hashOn, key)
 }
 
 func overrideFromNode(upstream *Upstream, node Node) *Override {
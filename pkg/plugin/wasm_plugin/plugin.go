@@ -0,0 +1,255 @@
+// Package wasm_plugin implements the plugins configured under wasm.plugins.
+// Each one runs a Proxy-Wasm filter loaded by pkg/wasm: the request headers
+// and body in its request stage, then the response headers and the buffered
+// response body.
+package wasm_plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/wasm"
+)
+
+// httpContextVarPrefix keys the filter's HTTP context, per plugin, from the
+// request stage to the response filters.
+const httpContextVarPrefix = "$wasm_plugin_ctx."
+
+const schema = `
+{
+  "type": "object",
+  "properties": {
+    "conf": {
+      "oneOf": [
+        {"type": "object", "minProperties": 1},
+        {"type": "string", "minLength": 1}
+      ]
+    }
+  },
+  "required": ["conf"]
+}
+`
+
+// propertyVars maps the Envoy attribute names filters commonly read to the
+// nginx variables that hold them. Any other single-segment property is
+// looked up as an nginx variable.
+var propertyVars = map[string]string{
+	"request.path":     "request_uri",
+	"request.url_path": "uri",
+	"request.host":     "http_host",
+	"request.scheme":   "scheme",
+	"request.method":   "request_method",
+	"request.protocol": "server_protocol",
+	"request.query":    "args",
+	"source.address":   "remote_addr",
+	"source.port":      "remote_port",
+	"destination.port": "server_port",
+	"response.code":    "status",
+}
+
+// Config is the route configuration. conf is handed to proxy_on_configure:
+// a string as is, an object as JSON.
+type Config struct {
+	Conf any `json:"conf"`
+}
+
+type Plugin struct {
+	base.BasePlugin
+	config Config
+	conf   []byte
+	module *wasm.Module
+}
+
+// New builds the plugin for one wasm.plugins entry.
+func New(name string, priority int) *Plugin {
+	return &Plugin{BasePlugin: base.BasePlugin{Name: name, Priority: priority}}
+}
+
+func (p *Plugin) Init() error {
+	p.Schema = schema
+	return nil
+}
+
+func (p *Plugin) PostInit() error {
+	switch conf := p.config.Conf.(type) {
+	case nil:
+		p.conf = []byte{}
+	case string:
+		p.conf = []byte(conf)
+	default:
+		encoded, err := json.Marshal(conf)
+		if err != nil {
+			return fmt.Errorf("encode conf: %w", err)
+		}
+		p.conf = encoded
+	}
+	return nil
+}
+
+func (p *Plugin) Config() any {
+	return &p.config
+}
+
+// SetWasmModule injects the module the server loaded for this plugin.
+func (p *Plugin) SetWasmModule(module *wasm.Module) {
+	p.module = module
+}
+
+func (p *Plugin) Handler(next http.Handler) http.Handler {
+	return base.AdaptRequestPhase(p, next)
+}
+
+// RunRequestPhase runs proxy_on_request_headers and, when the filter exports
+// it, proxy_on_request_body with the whole body. A filter failure answers
+// 503, as APISIX does.
+func (p *Plugin) RunRequestPhase(w http.ResponseWriter, r *http.Request) base.RequestPhaseResult {
+	httpContext, err := p.httpContext(r)
+	if err != nil {
+		return p.fail(w, r, err)
+	}
+	local, err := httpContext.OnRequestHeaders(r)
+	if err != nil {
+		return p.fail(w, r, err)
+	}
+	if local == nil && p.module.Exports("proxy_on_request_body") && r.Body != nil && r.Body != http.NoBody {
+		body, err := base.ReadRequestBodyLimited(r, base.DefaultRequestBodyMaxBytes)
+		if err != nil {
+			return p.fail(w, r, err)
+		}
+		var changed bool
+		body, changed, local, err = httpContext.OnRequestBody(body)
+		if err != nil {
+			return p.fail(w, r, err)
+		}
+		if changed {
+			base.ReplaceRequestBody(r, body)
+		}
+	}
+	if local != nil {
+		apisixctx.SetRequestResponseSource(r, apisixctx.ResponseSourceEarlyStop)
+		writeLocalResponse(w, local)
+		return base.StopRequestWithSource(r, apisixctx.ResponseSourceEarlyStop)
+	}
+	return base.ContinueRequest(r)
+}
+
+// RunHeaderFilter runs proxy_on_response_headers. A local response replaces
+// the upstream response.
+func (p *Plugin) RunHeaderFilter(r *http.Request, state *base.ResponseState) error {
+	if state == nil {
+		return nil
+	}
+	httpContext, err := p.httpContext(r)
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		return nil
+	}
+	if state.Header == nil {
+		state.Header = make(http.Header)
+	}
+	status, header, local, err := httpContext.OnResponseHeaders(state.Status, state.Header)
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		return nil
+	}
+	if local != nil {
+		applyLocalResponse(state, local)
+		return nil
+	}
+	state.Status = status
+	state.Header = header
+	return nil
+}
+
+// RunBufferedBodyFilter runs proxy_on_response_body with the whole body when
+// the filter exports it.
+func (p *Plugin) RunBufferedBodyFilter(r *http.Request, state *base.ResponseState) error {
+	if state == nil || p.module == nil || !p.module.Exports("proxy_on_response_body") {
+		return nil
+	}
+	httpContext, err := p.httpContext(r)
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		return nil
+	}
+	body, changed, local, err := httpContext.OnResponseBody(state.Body)
+	if err != nil {
+		logger.Errorf("%s: %s", p.Name, err)
+		return nil
+	}
+	if local != nil {
+		applyLocalResponse(state, local)
+		return nil
+	}
+	if changed {
+		state.Body = body
+		if state.Header == nil {
+			state.Header = make(http.Header)
+		}
+		base.InvalidateBodyDerivedHeaders(state.Header)
+	}
+	return nil
+}
+
+// httpContext returns the request's HTTP context, creating it on first use.
+// It is closed, running proxy_on_log, when the request context ends.
+func (p *Plugin) httpContext(r *http.Request) (*wasm.HTTPContext, error) {
+	key := httpContextVarPrefix + p.Name
+	if httpContext, ok := apisixctx.GetRequestVar(r, key).(*wasm.HTTPContext); ok {
+		return httpContext, nil
+	}
+	if p.module == nil {
+		return nil, fmt.Errorf("wasm module is not loaded")
+	}
+	httpContext, err := p.module.NewHTTPContext(r.Context(), p.conf, func(path []string) ([]byte, bool) {
+		return requestProperty(r, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	apisixctx.RegisterRequestVar(r, key, httpContext)
+	context.AfterFunc(r.Context(), httpContext.Close)
+	return httpContext, nil
+}
+
+func (p *Plugin) fail(w http.ResponseWriter, r *http.Request, err error) base.RequestPhaseResult {
+	logger.Errorf("%s: %s", p.Name, err)
+	apisixctx.SetRequestResponseSource(r, apisixctx.ResponseSourceEarlyStop)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return base.StopRequestWithSource(r, apisixctx.ResponseSourceEarlyStop)
+}
+
+func requestProperty(r *http.Request, path []string) ([]byte, bool) {
+	name := strings.Join(path, ".")
+	variable, ok := propertyVars[name]
+	if !ok {
+		if len(path) != 1 {
+			return nil, false
+		}
+		variable = name
+	}
+	value := base.RequestVarFromNginx(r, variable)
+	return []byte(value), value != ""
+}
+
+func writeLocalResponse(w http.ResponseWriter, local *wasm.LocalResponse) {
+	for name, values := range local.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(local.Status)
+	_, _ = w.Write(local.Body)
+}
+
+func applyLocalResponse(state *base.ResponseState, local *wasm.LocalResponse) {
+	header := local.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	*state = base.ResponseState{Status: local.Status, Header: header, Body: local.Body}
+}
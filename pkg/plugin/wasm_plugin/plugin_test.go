@@ -0,0 +1,123 @@
+package wasm_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/wasm"
+)
+
+// loadGuest builds the pkg/wasm test filter and loads it as name.
+func loadGuest(t *testing.T, name string) *wasm.Module {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "guest.wasm")
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=c-shared", "-o", out, "../../wasm/testdata/guest")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build guest: %v\n%s", err, output)
+	}
+	module, err := wasm.Load(context.Background(), name, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = module.Close(context.Background()) })
+	return module
+}
+
+func newPlugin(t *testing.T, module *wasm.Module, conf any) *Plugin {
+	t.Helper()
+	p := New("wasm_test", 7999)
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	p.config.Conf = conf
+	if err := p.PostInit(); err != nil {
+		t.Fatal(err)
+	}
+	p.SetWasmModule(module)
+	return p
+}
+
+// newRequest returns a request with request vars whose context ends with
+// the test, closing the filter's HTTP context.
+func newRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	r := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+	return apisixctx.WithRequestVars(r)
+}
+
+func TestRunRequestPhaseAppliesFilter(t *testing.T) {
+	p := newPlugin(t, loadGuest(t, "wasm_test"), map[string]any{"mode": "on"})
+	r := newRequest(t, http.MethodPost, "/old", "hello")
+	r.Header.Set("X-Rewrite", "/new")
+	w := httptest.NewRecorder()
+
+	result := p.RunRequestPhase(w, r)
+	if result.Decision != base.RequestContinue {
+		t.Fatalf("decision = %v, status = %d", result.Decision, w.Code)
+	}
+	if r.URL.Path != "/new" || r.Header.Get("X-Wasm-Config") != `{"mode":"on"}` || r.Header.Get("X-Wasm-Method") != http.MethodPost {
+		t.Fatalf("path = %q, header = %v", r.URL.Path, r.Header)
+	}
+	body, err := base.ReadRequestBodyLimited(r, base.DefaultRequestBodyMaxBytes)
+	if err != nil || string(body) != "HELLO" || r.ContentLength != 5 {
+		t.Fatalf("body = %q, content length = %d, err = %v", body, r.ContentLength, err)
+	}
+
+	state := &base.ResponseState{Status: http.StatusOK, Header: http.Header{"Content-Length": {"4"}}, Body: []byte("done")}
+	if err := p.RunHeaderFilter(r, state); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RunBufferedBodyFilter(r, state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Header.Get("X-Wasm-Response") != `{"mode":"on"}` || string(state.Body) != "done (wasm)" || state.Header.Get("Content-Length") != "" {
+		t.Fatalf("state = %+v", state)
+	}
+}
+
+func TestRunRequestPhaseSendsLocalResponse(t *testing.T) {
+	p := newPlugin(t, loadGuest(t, "wasm_test"), "deny")
+	r := newRequest(t, http.MethodGet, "/", "")
+	r.Header.Set("X-Deny", "1")
+	w := httptest.NewRecorder()
+
+	result := p.RunRequestPhase(w, r)
+	if result.Decision != base.RequestStop || result.Source != apisixctx.ResponseSourceEarlyStop {
+		t.Fatalf("result = %+v", result)
+	}
+	if w.Code != http.StatusForbidden || w.Body.String() != "denied by deny" || w.Header().Get("X-Wasm-Deny") != "1" {
+		t.Fatalf("status = %d, body = %q, header = %v", w.Code, w.Body, w.Header())
+	}
+}
+
+func TestRunRequestPhaseFailsClosed(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		module func(*testing.T) *wasm.Module
+		conf   string
+	}{
+		{name: "module not loaded", module: func(*testing.T) *wasm.Module { return nil }, conf: "ok"},
+		{name: "configuration rejected", module: func(t *testing.T) *wasm.Module { return loadGuest(t, "wasm_test") }, conf: "reject"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPlugin(t, test.module(t), test.conf)
+			w := httptest.NewRecorder()
+			result := p.RunRequestPhase(w, newRequest(t, http.MethodGet, "/", ""))
+			if result.Decision != base.RequestStop || w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("decision = %v, status = %d", result.Decision, w.Code)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
@@ -44,6 +45,24 @@ const (
 	activeProbeCanceled
 )
 
+// String names the result as the health check API reports it.
+func (result activeProbeResult) String() string {
+	switch result {
+	case activeProbeSuccess:
+		return "success"
+	case activeProbeHTTPFailure:
+		return "http_failure"
+	case activeProbeTCPFailure:
+		return "tcp_failure"
+	case activeProbeTimeout:
+		return "timeout"
+	case activeProbeCanceled:
+		return "canceled"
+	default:
+		return "neutral"
+	}
+}
+
 type activeProbeCounters struct {
 	successes    int
 	httpFailures int
@@ -242,6 +261,12 @@ type activeHealthChecker struct {
 	wg             sync.WaitGroup
 	httpClient     *http.Client
 	closeProbeIdle func()
+
+	// countersMu guards counters, the probe counters of each target
+	// goroutine, and probes, the latest probe outcome of each target.
+	countersMu sync.Mutex
+	counters   map[string]activeProbeCounters
+	probes     map[string]ActiveProbe
 }
 
 func newActiveHealthChecker(
@@ -272,6 +297,8 @@ func newActiveHealthChecker(
 		ctx:            ctx,
 		cancel:         cancel,
 		closeProbeIdle: closeProbeIdle,
+		counters:       make(map[string]activeProbeCounters, len(targetList)),
+		probes:         make(map[string]ActiveProbe, len(targetList)),
 		httpClient: &http.Client{
 			Transport: probeTransport,
 			Timeout:   0, // per-request context bounds each probe
@@ -320,7 +347,6 @@ func (c *activeHealthChecker) Close() {
 
 func (c *activeHealthChecker) probeTarget(target string) {
 	defer c.wg.Done()
-	var counters activeProbeCounters
 	for {
 		healthy := c.lb.IsHealthy(target)
 		interval := c.config.UnhealthyInterval
@@ -341,8 +367,69 @@ func (c *activeHealthChecker) probeTarget(target string) {
 		if result == activeProbeCanceled || c.ctx.Err() != nil {
 			return
 		}
+		// Counters are reloaded every round so state inherited from the
+		// previous cluster generation is picked up.
+		counters := c.loadCounters(target)
 		c.applyProbeResultAtGeneration(target, probeGeneration, result, &counters)
+		c.publishProbe(target, counters, result)
+	}
+}
+
+func (c *activeHealthChecker) loadCounters(target string) activeProbeCounters {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	return c.counters[target]
+}
+
+func (c *activeHealthChecker) publishProbe(target string, counters activeProbeCounters, result activeProbeResult) {
+	c.countersMu.Lock()
+	c.counters[target] = counters
+	c.probes[target] = ActiveProbe{Result: result.String(), At: time.Now()}
+	c.countersMu.Unlock()
+}
+
+func (c *activeHealthChecker) lastProbes() map[string]ActiveProbe {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	return maps.Clone(c.probes)
+}
+
+// inherit copies the probe counters and latest outcomes of the targets that
+// previous also probed.
+func (c *activeHealthChecker) inherit(previous *activeHealthChecker) {
+	previous.countersMu.Lock()
+	counters := make(map[string]activeProbeCounters, len(c.targets))
+	probes := make(map[string]ActiveProbe, len(c.targets))
+	for _, target := range c.targets {
+		if value, ok := previous.counters[target]; ok {
+			counters[target] = value
+		}
+		if probe, ok := previous.probes[target]; ok {
+			probes[target] = probe
+		}
+	}
+	previous.countersMu.Unlock()
+
+	c.countersMu.Lock()
+	maps.Copy(c.counters, counters)
+	maps.Copy(c.probes, probes)
+	c.countersMu.Unlock()
+}
+
+func (c *activeHealthChecker) activeCounters() map[string]HealthCounters {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	result := make(map[string]HealthCounters, len(c.targets))
+	for _, target := range c.targets {
+		counters := c.counters[target]
+		result[target] = HealthCounters{
+			Successes:    counters.successes,
+			HTTPFailures: counters.httpFailures,
+			TCPFailures:  counters.tcpFailures,
+			Timeouts:     counters.timeouts,
+		}
 	}
+	return result
 }
 
 func (c *activeHealthChecker) applyProbeResultAtGeneration(
@@ -389,25 +476,29 @@ func (c *activeHealthChecker) applyProbeResultAtGeneration(
 	}
 	threshold := 0
 	failures := 0
+	reason := ""
 	switch result {
 	case activeProbeHTTPFailure:
 		counters.httpFailures++
 		threshold = c.config.UnhealthyHTTPFails
 		failures = counters.httpFailures
+		reason = "active http_failures"
 	case activeProbeTCPFailure:
 		counters.tcpFailures++
 		threshold = c.config.UnhealthyTCPFails
 		failures = counters.tcpFailures
+		reason = "active tcp_failures"
 	case activeProbeTimeout:
 		counters.timeouts++
 		threshold = c.config.UnhealthyTimeouts
 		failures = counters.timeouts
+		reason = "active timeouts"
 	}
 	if threshold > 0 && failures >= threshold {
 		counters.httpFailures = 0
 		counters.tcpFailures = 0
 		counters.timeouts = 0
-		if c.lb.markUnhealthyAtGeneration(target, expectedGeneration, c.name, c.observer) {
+		if c.lb.markUnhealthyAtGeneration(target, expectedGeneration, reason, c.name, c.observer) {
 		} else {
 			c.resetProbeCounters(target, counters)
 		}
@@ -0,0 +1,448 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/plugin/chash"
+)
+
+// APISIX upstream `type` values implemented by the HTTP cluster owner. The
+// empty type is the roundrobin default.
+const (
+	BalancerRoundRobin = "roundrobin"
+	BalancerCHash      = "chash"
+	BalancerEWMA       = "ewma"
+	BalancerLeastConn  = "least_conn"
+)
+
+// ewmaDecayTime matches APISIX's DECAY_TIME: a latency sample loses 1/e of its
+// weight every ten seconds without new observations.
+const ewmaDecayTime = 10 * time.Second
+
+// NormalizeBalancerType maps an APISIX upstream type onto the cluster balancer
+// identity. roundrobin and the empty default share one identity so they
+// intern to the same cluster.
+func NormalizeBalancerType(balancerType string) (string, error) {
+	switch strings.ToLower(balancerType) {
+	case "", BalancerRoundRobin:
+		return "", nil
+	case BalancerCHash:
+		return BalancerCHash, nil
+	case BalancerEWMA:
+		return BalancerEWMA, nil
+	case BalancerLeastConn:
+		return BalancerLeastConn, nil
+	default:
+		return "", fmt.Errorf("unsupported upstream balancer type %q", balancerType)
+	}
+}
+
+// NewUpstreamLoadBalanceWithType builds the selector for one APISIX upstream
+// type while preserving priority groups, passive/active health state, and the
+// request-local retry exclusion shared with the roundrobin balancer.
+func NewUpstreamLoadBalanceWithType(
+	balancerType string,
+	servers map[string]int,
+	priorities map[string]int,
+	checks map[string]any,
+) (LoadBalancer, error) {
+	normalized, err := NormalizeBalancerType(balancerType)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == "" {
+		return newUpstreamLoadBalanceWithPriorities(servers, priorities, checks)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("cannot build upstream load balancer without nodes")
+	}
+	groups := newPriorityGroups(servers, priorities)
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("cannot build upstream load balancer without positive-weight nodes")
+	}
+
+	lb := &policyLoadBalance{groups: groups}
+	switch normalized {
+	case BalancerCHash:
+		lb.policy, err = newCHashPolicy(groups)
+	case BalancerLeastConn:
+		lb.policy = newLeastConnPolicy(groups)
+	case BalancerEWMA:
+		lb.policy = newEWMAPolicy(groups, time.Now, rand.IntN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, hasPassive := checks["passive"]
+	_, hasActive := checks["active"]
+	if hasPassive || hasActive {
+		// The health-aware balancer owns target state, observer wiring, and
+		// active probes; its own round-robin selection is not used here.
+		lb.health, err = newHealthAwareLoadBalance(servers, priorities, checks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lb, nil
+}
+
+type hashKeyContextKey struct{}
+
+// WithHashKey attaches the resolved chash key to a request. The key is
+// resolved once by the route owner so retries walk the same ring.
+func WithHashKey(r *http.Request, key string) *http.Request {
+	if r == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), hashKeyContextKey{}, key))
+}
+
+func hashKeyFromRequest(r *http.Request) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	key, ok := r.Context().Value(hashKeyContextKey{}).(string)
+	return key, ok
+}
+
+// balancerPolicy picks one target from a priority group. eligible already
+// excludes targets tried by the current request and, when health checks are
+// configured, unhealthy targets.
+type balancerPolicy interface {
+	pick(request *http.Request, index int, group priorityGroup, eligible func(string) bool) string
+}
+
+// balancerFeedback receives per-attempt outcomes from the cluster transport
+// so connection- and latency-aware policies can score targets.
+type balancerFeedback interface {
+	attemptStarted(target string)
+	attemptFinished(target string, elapsed time.Duration)
+}
+
+// policyLoadBalance applies a non-roundrobin policy inside APISIX priority
+// groups. A lower priority group is only used when every target in the
+// higher groups was already tried or is unhealthy.
+type policyLoadBalance struct {
+	groups []priorityGroup
+	policy balancerPolicy
+	health *HealthAwareLoadBalance
+}
+
+func (lb *policyLoadBalance) Next() string {
+	return lb.NextForRequest(nil)
+}
+
+func (lb *policyLoadBalance) NextForRequest(request *http.Request) string {
+	state := priorityStateForRequest(request)
+	state.finishPreviousAttempt()
+
+	healthy := lb.healthyFilter()
+	next := func() string {
+		for index, group := range lb.groups {
+			eligible := func(target string) bool {
+				if _, tried := state.tried[target]; tried {
+					return false
+				}
+				return healthy == nil || healthy(target)
+			}
+			if target := lb.policy.pick(request, index, group, eligible); target != "" {
+				return target
+			}
+		}
+		return ""
+	}
+	if target := next(); target != "" {
+		state.last = target
+		return target
+	}
+	clear(state.tried)
+	target := next()
+	state.last = target
+	return target
+}
+
+// healthyFilter mirrors HealthAwareLoadBalance: unhealthy targets are skipped
+// while any target is healthy, and the pool fails open once all are ejected.
+func (lb *policyLoadBalance) healthyFilter() func(string) bool {
+	if lb.health == nil {
+		return nil
+	}
+	snapshot := lb.health.HealthSnapshot()
+	for _, healthy := range snapshot {
+		if healthy {
+			return func(target string) bool { return snapshot[target] }
+		}
+	}
+	return nil
+}
+
+func (lb *policyLoadBalance) RecordSelectedTarget(request *http.Request, target string) {
+	recordPriorityTargetAttempt(request, target)
+}
+
+func (lb *policyLoadBalance) ReportHTTP(target string, status int) {
+	if lb.health != nil {
+		lb.health.ReportHTTP(target, status)
+	}
+}
+
+func (lb *policyLoadBalance) ReportTCPFailure(target string, timeout bool) {
+	if lb.health != nil {
+		lb.health.ReportTCPFailure(target, timeout)
+	}
+}
+
+func (lb *policyLoadBalance) IsHealthy(target string) bool {
+	if lb.health != nil {
+		return lb.health.IsHealthy(target)
+	}
+	for _, group := range lb.groups {
+		if _, ok := group.weights[target]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (lb *policyLoadBalance) attemptStarted(target string) {
+	if feedback, ok := lb.policy.(balancerFeedback); ok {
+		feedback.attemptStarted(target)
+	}
+}
+
+func (lb *policyLoadBalance) attemptFinished(target string, elapsed time.Duration) {
+	if feedback, ok := lb.policy.(balancerFeedback); ok {
+		feedback.attemptFinished(target, elapsed)
+	}
+}
+
+// healthAwareBalancer returns the health-state owner behind a cluster load
+// balancer, or nil when the upstream configures no checks.
+func healthAwareBalancer(lb LoadBalancer) *HealthAwareLoadBalance {
+	switch typed := lb.(type) {
+	case *HealthAwareLoadBalance:
+		return typed
+	case *policyLoadBalance:
+		return typed.health
+	default:
+		return nil
+	}
+}
+
+// chashPolicy walks one ketama ring per priority group. Retries continue
+// clockwise from the original key so each attempt lands on the next distinct
+// node, matching APISIX's chash retry order.
+type chashPolicy struct {
+	rings []*chash.Ring
+}
+
+func newCHashPolicy(groups []priorityGroup) (*chashPolicy, error) {
+	rings := make([]*chash.Ring, 0, len(groups))
+	for _, group := range groups {
+		nodes := make([]chash.Node, 0, len(group.targets))
+		for _, target := range group.targets {
+			nodes = append(nodes, chash.Node{ID: chashNodeID(target), Target: target, Weight: group.weights[target]})
+		}
+		ring, err := chash.New(nodes)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return &chashPolicy{rings: rings}, nil
+}
+
+// chashNodeID strips the scheme so the ring identity is APISIX's host:port
+// node key and a scheme change does not move every hash key.
+func chashNodeID(target string) string {
+	if _, hostPort, ok := strings.Cut(target, "://"); ok {
+		return hostPort
+	}
+	return target
+}
+
+func (p *chashPolicy) pick(request *http.Request, index int, group priorityGroup, eligible func(string) bool) string {
+	key, ok := hashKeyFromRequest(request)
+	if !ok {
+		// Callers without a resolved key (for example protocol terminals)
+		// keep weighted selection instead of pinning every request to the
+		// ring position of the empty key.
+		return group.nextUntried(nil, eligible)
+	}
+	for _, target := range p.rings[index].Candidates(key) {
+		if eligible(target) {
+			return target
+		}
+	}
+	return ""
+}
+
+// leastConnPolicy selects the eligible target with the lowest
+// (active+1)/weight score. Active attempts are counted by the cluster
+// transport from dial to response-body completion.
+type leastConnPolicy struct {
+	active map[string]*atomic.Int64
+}
+
+func newLeastConnPolicy(groups []priorityGroup) *leastConnPolicy {
+	active := make(map[string]*atomic.Int64)
+	for _, group := range groups {
+		for _, target := range group.targets {
+			active[target] = &atomic.Int64{}
+		}
+	}
+	return &leastConnPolicy{active: active}
+}
+
+func (p *leastConnPolicy) pick(_ *http.Request, _ int, group priorityGroup, eligible func(string) bool) string {
+	best := ""
+	var bestActive int64
+	bestWeight := 0
+	for _, target := range group.targets {
+		if !eligible(target) {
+			continue
+		}
+		active := p.active[target].Load()
+		weight := group.weights[target]
+		// Compare (active+1)/weight without floating point; the first target
+		// in sorted order wins ties.
+		if best == "" || (active+1)*int64(bestWeight) < (bestActive+1)*int64(weight) {
+			best, bestActive, bestWeight = target, active, weight
+		}
+	}
+	return best
+}
+
+func (p *leastConnPolicy) attemptStarted(target string) {
+	if counter, ok := p.active[target]; ok {
+		counter.Add(1)
+	}
+}
+
+func (p *leastConnPolicy) attemptFinished(target string, _ time.Duration) {
+	if counter, ok := p.active[target]; ok {
+		counter.Add(-1)
+	}
+}
+
+// ewmaPolicy implements APISIX's ewma balancer: a time-decayed moving average
+// of attempt latency per target and power-of-two-choices selection.
+type ewmaPolicy struct {
+	mu    sync.Mutex
+	stats map[string]*ewmaStat
+	now   func() time.Time
+	intN  func(int) int
+}
+
+type ewmaStat struct {
+	value   float64
+	touched time.Time
+}
+
+func newEWMAPolicy(groups []priorityGroup, now func() time.Time, intN func(int) int) *ewmaPolicy {
+	stats := make(map[string]*ewmaStat)
+	for _, group := range groups {
+		for _, target := range group.targets {
+			stats[target] = &ewmaStat{}
+		}
+	}
+	return &ewmaPolicy{stats: stats, now: now, intN: intN}
+}
+
+func (p *ewmaPolicy) pick(_ *http.Request, _ int, group priorityGroup, eligible func(string) bool) string {
+	candidates := make([]string, 0, len(group.targets))
+	for _, target := range group.targets {
+		if eligible(target) {
+			candidates = append(candidates, target)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return ""
+	case 1:
+		return candidates[0]
+	}
+	first := p.intN(len(candidates))
+	second := p.intN(len(candidates) - 1)
+	if second >= first {
+		second++
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	if p.scoreLocked(candidates[second], now) < p.scoreLocked(candidates[first], now) {
+		return candidates[second]
+	}
+	return candidates[first]
+}
+
+func (p *ewmaPolicy) scoreLocked(target string, now time.Time) float64 {
+	stat := p.stats[target]
+	if stat.touched.IsZero() {
+		return 0
+	}
+	return stat.value * ewmaDecay(now.Sub(stat.touched))
+}
+
+func (p *ewmaPolicy) attemptStarted(string) {}
+
+func (p *ewmaPolicy) attemptFinished(target string, elapsed time.Duration) {
+	stat, ok := p.stats[target]
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	sample := elapsed.Seconds()
+	if stat.touched.IsZero() {
+		stat.value = sample
+	} else {
+		weight := ewmaDecay(now.Sub(stat.touched))
+		stat.value = stat.value*weight + sample*(1-weight)
+	}
+	stat.touched = now
+}
+
+func ewmaDecay(elapsed time.Duration) float64 {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Exp(-float64(elapsed) / float64(ewmaDecayTime))
+}
+
+// balancerFeedbackTransport reports each attempt to connection- and
+// latency-aware balancers. The attempt finishes when the response body is
+// closed or drained, or immediately when the round trip fails.
+type balancerFeedbackTransport struct {
+	base     http.RoundTripper
+	feedback balancerFeedback
+}
+
+func newBalancerFeedbackTransport(base http.RoundTripper, feedback balancerFeedback) *balancerFeedbackTransport {
+	return &balancerFeedbackTransport{base: base, feedback: feedback}
+}
+
+func (t *balancerFeedbackTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	target := request.URL.Scheme + "://" + request.URL.Host
+	started := time.Now()
+	t.feedback.attemptStarted(target)
+	finish := func() {
+		t.feedback.attemptFinished(target, time.Since(started))
+	}
+	response, err := t.base.RoundTrip(request)
+	if err != nil || response == nil || response.Body == nil {
+		finish()
+		return response, err
+	}
+	response.Body = wrapReleaseBody(response.Body, finish)
+	return response, nil
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBalancerType(t *testing.T) {
+	for _, test := range []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "", want: ""},
+		{input: "roundrobin", want: ""},
+		{input: "CHASH", want: BalancerCHash},
+		{input: "ewma", want: BalancerEWMA},
+		{input: "least_conn", want: BalancerLeastConn},
+		{input: "random", wantErr: true},
+	} {
+		got, err := NormalizeBalancerType(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Fatalf("NormalizeBalancerType(%q) error = nil, want unsupported type", test.input)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Fatalf("NormalizeBalancerType(%q) = (%q, %v), want %q", test.input, got, err, test.want)
+		}
+	}
+}
+
+func TestCHashLoadBalanceIsStickyAndRetriesAlongRing(t *testing.T) {
+	servers := map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+		"http://10.0.0.3:80": 1,
+	}
+	lb, err := NewUpstreamLoadBalanceWithType(BalancerCHash, servers, nil, nil)
+	if err != nil {
+		t.Fatalf("NewUpstreamLoadBalanceWithType() error = %v", err)
+	}
+
+	first := NextTarget(lb, hashKeyRequest("tenant-a"))
+	for range 20 {
+		if got := NextTarget(lb, hashKeyRequest("tenant-a")); got != first {
+			t.Fatalf("chash target = %q, want sticky %q", got, first)
+		}
+	}
+
+	request := hashKeyRequest("tenant-a")
+	seen := map[string]struct{}{}
+	for range len(servers) {
+		seen[NextTarget(lb, request)] = struct{}{}
+	}
+	if len(seen) != len(servers) {
+		t.Fatalf("chash retries visited %d distinct targets, want %d", len(seen), len(servers))
+	}
+
+	distinct := map[string]struct{}{}
+	for index := range 64 {
+		distinct[NextTarget(lb, hashKeyRequest(fmt.Sprintf("tenant-%d", index)))] = struct{}{}
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("chash spread 64 keys over %d targets, want more than one", len(distinct))
+	}
+}
+
+func TestCHashLoadBalanceSkipsUnhealthyTargetsAndHonorsPriority(t *testing.T) {
+	servers := map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+		"http://10.0.0.9:80": 1,
+	}
+	priorities := map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+		"http://10.0.0.9:80": 0,
+	}
+	checks := map[string]any{
+		"passive": map[string]any{
+			"unhealthy": map[string]any{"http_statuses": []any{500}, "http_failures": 1},
+		},
+	}
+	lb, err := NewUpstreamLoadBalanceWithType(BalancerCHash, servers, priorities, checks)
+	if err != nil {
+		t.Fatalf("NewUpstreamLoadBalanceWithType() error = %v", err)
+	}
+	reporter, ok := lb.(HealthReporter)
+	if !ok {
+		t.Fatalf("chash balancer %T does not report health", lb)
+	}
+
+	first := NextTarget(lb, hashKeyRequest("session"))
+	if first == "http://10.0.0.9:80" {
+		t.Fatalf("chash selected backup priority target %q while the primary group is healthy", first)
+	}
+	reporter.ReportHTTP(first, 500)
+	second := NextTarget(lb, hashKeyRequest("session"))
+	if second == first || second == "http://10.0.0.9:80" {
+		t.Fatalf("chash target after ejecting %q = %q, want the other primary target", first, second)
+	}
+	reporter.ReportHTTP(second, 500)
+	if got := NextTarget(lb, hashKeyRequest("session")); got != "http://10.0.0.9:80" {
+		t.Fatalf("chash target with the primary group ejected = %q, want backup target", got)
+	}
+	if healthAwareBalancer(lb) == nil {
+		t.Fatal("healthAwareBalancer() = nil, want health owner for checks")
+	}
+}
+
+func TestCHashLoadBalanceWithoutKeyFallsBackToWeightedSelection(t *testing.T) {
+	lb, err := NewUpstreamLoadBalanceWithType(BalancerCHash, map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewUpstreamLoadBalanceWithType() error = %v", err)
+	}
+	seen := map[string]struct{}{}
+	for range 4 {
+		seen[lb.Next()] = struct{}{}
+	}
+	if len(seen) != 2 {
+		t.Fatalf("keyless chash visited %d targets, want weighted selection over both", len(seen))
+	}
+}
+
+func TestLeastConnLoadBalancePrefersFewestActiveAttemptsPerWeight(t *testing.T) {
+	lb, err := NewUpstreamLoadBalanceWithType(BalancerLeastConn, map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 2,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewUpstreamLoadBalanceWithType() error = %v", err)
+	}
+	feedback := lb.(balancerFeedback)
+
+	// Scores (active+1)/weight: 1/1 vs 1/2, so the heavier node wins first.
+	if got := lb.Next(); got != "http://10.0.0.2:80" {
+		t.Fatalf("first least_conn target = %q, want heavier node", got)
+	}
+	feedback.attemptStarted("http://10.0.0.2:80")
+	// 1/1 vs 2/2 ties; sorted order picks the first node.
+	if got := lb.Next(); got != "http://10.0.0.1:80" {
+		t.Fatalf("tied least_conn target = %q, want first sorted node", got)
+	}
+	feedback.attemptStarted("http://10.0.0.1:80")
+	feedback.attemptStarted("http://10.0.0.1:80")
+	if got := lb.Next(); got != "http://10.0.0.2:80" {
+		t.Fatalf("least_conn target = %q, want less loaded node", got)
+	}
+	feedback.attemptFinished("http://10.0.0.1:80", 0)
+	feedback.attemptFinished("http://10.0.0.1:80", 0)
+	feedback.attemptFinished("http://10.0.0.2:80", 0)
+	if got := lb.Next(); got != "http://10.0.0.2:80" {
+		t.Fatalf("least_conn target after completion = %q, want heavier idle node", got)
+	}
+}
+
+func TestLeastConnLoadBalanceRetriesUntriedTarget(t *testing.T) {
+	lb, err := NewUpstreamLoadBalanceWithType(BalancerLeastConn, map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewUpstreamLoadBalanceWithType() error = %v", err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "http://gateway.test/", nil)
+	first := NextTarget(lb, request)
+	second := NextTarget(lb, request)
+	if first == second {
+		t.Fatalf("least_conn retry reused %q, want the untried target", first)
+	}
+}
+
+func TestEWMALoadBalancePrefersLowerDecayedLatency(t *testing.T) {
+	now := time.Unix(1000, 0)
+	groups := newPriorityGroups(map[string]int{
+		"http://10.0.0.1:80": 1,
+		"http://10.0.0.2:80": 1,
+	}, nil)
+	picks := []int{0, 0}
+	policy := newEWMAPolicy(groups, func() time.Time { return now }, func(int) int {
+		value := picks[0]
+		picks = picks[1:]
+		return value
+	})
+	lb := &policyLoadBalance{groups: groups, policy: policy}
+
+	lb.attemptFinished("http://10.0.0.1:80", 800*time.Millisecond)
+	lb.attemptFinished("http://10.0.0.2:80", 20*time.Millisecond)
+	if got := lb.Next(); got != "http://10.0.0.2:80" {
+		t.Fatalf("ewma target = %q, want lower-latency node", got)
+	}
+
+	now = now.Add(time.Minute)
+	lb.attemptFinished("http://10.0.0.2:80", 2*time.Second)
+	picks = []int{0, 0}
+	if got := lb.Next(); got != "http://10.0.0.1:80" {
+		t.Fatalf("ewma target after slow samples = %q, want decayed faster node", got)
+	}
+}
+
+func TestBalancerFeedbackTransportFinishesAttemptOnBodyClose(t *testing.T) {
+	policy := newLeastConnPolicy(newPriorityGroups(map[string]int{"http://10.0.0.1:80": 1}, nil))
+	transport := newBalancerFeedbackTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}), policy)
+
+	request := httptest.NewRequest(http.MethodGet, "http://10.0.0.1:80/", nil)
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if got := policy.active["http://10.0.0.1:80"].Load(); got != 1 {
+		t.Fatalf("active attempts before body close = %d, want 1", got)
+	}
+	_ = response.Body.Close()
+	_ = response.Body.Close()
+	if got := policy.active["http://10.0.0.1:80"].Load(); got != 0 {
+		t.Fatalf("active attempts after body close = %d, want 0", got)
+	}
+}
+
+func TestClusterKeyIncludesBalancerType(t *testing.T) {
+	base := ClusterConfig{Name: "upstream", Targets: map[string]int{"http://10.0.0.1:80": 1}}
+	leastConn := base
+	leastConn.Type = BalancerLeastConn
+	baseKey, err := base.Key()
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	leastConnKey, err := leastConn.Key()
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	if baseKey == leastConnKey {
+		t.Fatal("cluster keys are equal for roundrobin and least_conn")
+	}
+}
+
+func hashKeyRequest(key string) *http.Request {
+	return WithHashKey(httptest.NewRequest(http.MethodGet, "http://gateway.test/", nil), key)
+}
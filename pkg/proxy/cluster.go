@@ -31,8 +31,11 @@ type ClusterKey [sha256.Size]byte
 // ClusterConfig is the immutable, effective configuration of one upstream
 // cluster. Every field is owned by the route generation that produces it and
 // is interned by digest; the same value must always select the same cluster.
+// Type is the normalized balancer type (see NormalizeBalancerType); empty
+// selects roundrobin.
 type ClusterConfig struct {
 	Name              string
+	Type              string
 	Targets           map[string]int
 	Priorities        map[string]int
 	Checks            map[string]any
@@ -51,6 +54,7 @@ type ClusterConfig struct {
 // timeout, idle, or connection-cap change produces a new cluster.
 type clusterKeyIdentity struct {
 	Name              string
+	Type              string
 	Targets           []clusterKeyTarget
 	Priorities        []clusterKeyPriority
 	Checks            map[string]any
@@ -79,6 +83,7 @@ type clusterKeyPriority struct {
 func (c ClusterConfig) Key() (ClusterKey, error) {
 	identity := clusterKeyIdentity{
 		Name:              c.Name,
+		Type:              c.Type,
 		Targets:           sortedClusterTargets(c.Targets),
 		Priorities:        sortedClusterPriorities(c.Priorities),
 		Checks:            c.Checks,
@@ -149,7 +154,8 @@ func newCluster(config ClusterConfig, observer ClusterObserver) (*Cluster, error
 		return newClusterWithTransport(config, observer, base, transport.CloseIdleConnections)
 	}
 	transport := NewTransport(config.Transport)
-	return newClusterWithTransport(config, observer, transport, transport.CloseIdleConnections)
+	base := limitConnectionRequests(transport, config.Transport.keepaliveRequests)
+	return newClusterWithTransport(config, observer, base, transport.CloseIdleConnections)
 }
 
 func newCleartextHTTP2Transport(option TransportOption) *http2.Transport {
@@ -186,16 +192,20 @@ func newClusterWithTransport(
 
 	var lb LoadBalancer
 	if len(config.Targets) > 0 {
-		lb, err = newUpstreamLoadBalanceWithPriorities(config.Targets, config.Priorities, config.Checks)
+		lb, err = NewUpstreamLoadBalanceWithType(config.Type, config.Targets, config.Priorities, config.Checks)
 		if err != nil {
 			return nil, err
 		}
 	}
+	attemptBase := base
+	if feedback, ok := lb.(balancerFeedback); ok {
+		attemptBase = newBalancerFeedbackTransport(base, feedback)
+	}
 
 	observeRetry := func(result string) {
 		observer.ObserveRetry(config.Name, result)
 	}
-	transport := NewProgressTimeoutTransport(base, config.SendTimeout, config.ReadTimeout)
+	transport := NewProgressTimeoutTransport(attemptBase, config.SendTimeout, config.ReadTimeout)
 	transport = NewRetryTransportWithObserver(transport, observeRetry)
 	transport = newAdmissionTransport(transport, maxInFlight, config.Name, observer)
 
@@ -208,7 +218,7 @@ func newClusterWithTransport(
 		closeIdle:   closeIdle,
 		maxInFlight: maxInFlight,
 	}
-	if healthAware, ok := lb.(*HealthAwareLoadBalance); ok {
+	if healthAware := healthAwareBalancer(lb); healthAware != nil {
 		healthAware.setObserver(config.Name, observer)
 		active, enabled, err := ParseActiveHealthConfig(config.Checks)
 		if err != nil {
@@ -234,6 +244,11 @@ func (c *Cluster) RoundTripper() http.RoundTripper {
 	return c.transport
 }
 
+// Key returns the digest of the cluster's effective configuration.
+func (c *Cluster) Key() ClusterKey {
+	return c.key
+}
+
 // MaxInFlight returns the cluster's effective in-flight admission limit.
 func (c *Cluster) MaxInFlight() int {
 	return c.maxInFlight
@@ -246,7 +261,7 @@ func (c *Cluster) Close() {
 		if c.health != nil {
 			c.health.Close()
 		}
-		if healthAware, ok := c.lb.(*HealthAwareLoadBalance); ok {
+		if healthAware := healthAwareBalancer(c.lb); healthAware != nil {
 			healthAware.clearObserver()
 		}
 		if c.closeIdle != nil {
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // HealthReporter receives passive upstream outcomes from the route/protocol
@@ -161,6 +162,10 @@ type healthState struct {
 	healthySuccesses int
 	unhealthy        bool
 	generation       uint64
+	// reason names the check and threshold that ejected an unhealthy
+	// target, for example "passive http_failures".
+	reason    string
+	ejectedAt time.Time
 }
 
 // HealthAwareLoadBalance preserves weighted round-robin selection while
@@ -334,12 +339,7 @@ func (lb *HealthAwareLoadBalance) ReportHTTP(target string, status int) {
 			}
 			state.healthySuccesses++
 			if state.healthySuccesses >= lb.config.HealthySuccesses {
-				state.unhealthy = false
-				state.generation++
-				state.httpFailures = 0
-				state.tcpFailures = 0
-				state.timeouts = 0
-				state.healthySuccesses = 0
+				state.recover()
 				lb.refreshHealthySelectorsLocked()
 				becameHealthy = true
 			}
@@ -357,8 +357,7 @@ func (lb *HealthAwareLoadBalance) ReportHTTP(target string, status int) {
 		}
 		state.httpFailures++
 		if lb.config.HTTPFailures > 0 && state.httpFailures >= lb.config.HTTPFailures {
-			state.unhealthy = true
-			state.generation++
+			state.eject("passive http_failures")
 			lb.refreshHealthySelectorsLocked()
 			becameUnhealthy = true
 		}
@@ -390,16 +389,14 @@ func (lb *HealthAwareLoadBalance) ReportTCPFailure(target string, timeout bool)
 	if timeout {
 		state.timeouts++
 		if lb.config.Timeouts > 0 && state.timeouts >= lb.config.Timeouts {
-			state.unhealthy = true
-			state.generation++
+			state.eject("passive timeouts")
 			lb.refreshHealthySelectorsLocked()
 			becameUnhealthy = true
 		}
 	} else {
 		state.tcpFailures++
 		if lb.config.TCPFailures > 0 && state.tcpFailures >= lb.config.TCPFailures {
-			state.unhealthy = true
-			state.generation++
+			state.eject("passive tcp_failures")
 			lb.refreshHealthySelectorsLocked()
 			becameUnhealthy = true
 		}
@@ -473,12 +470,7 @@ func (lb *HealthAwareLoadBalance) markHealthyLocked(
 	if !ok || !state.unhealthy || requireGeneration && state.generation != expectedGeneration {
 		return false
 	}
-	state.unhealthy = false
-	state.generation++
-	state.httpFailures = 0
-	state.tcpFailures = 0
-	state.timeouts = 0
-	state.healthySuccesses = 0
+	state.recover()
 	lb.refreshHealthySelectorsLocked()
 	return true
 }
@@ -489,18 +481,19 @@ func (lb *HealthAwareLoadBalance) markHealthyLocked(
 func (lb *HealthAwareLoadBalance) MarkUnhealthy(target string) bool {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
-	return lb.markUnhealthyLocked(target, 0, false)
+	return lb.markUnhealthyLocked(target, 0, false, "active")
 }
 
 func (lb *HealthAwareLoadBalance) markUnhealthyAtGeneration(
 	target string,
 	expectedGeneration uint64,
+	reason string,
 	cluster string,
 	observer ClusterObserver,
 ) bool {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
-	if !lb.markUnhealthyLocked(target, expectedGeneration, true) {
+	if !lb.markUnhealthyLocked(target, expectedGeneration, true, reason) {
 		return false
 	}
 	if observer != nil {
@@ -513,18 +506,38 @@ func (lb *HealthAwareLoadBalance) markUnhealthyLocked(
 	target string,
 	expectedGeneration uint64,
 	requireGeneration bool,
+	reason string,
 ) bool {
 	state, ok := lb.states[target]
 	if !ok || state.unhealthy || requireGeneration && state.generation != expectedGeneration {
 		return false
 	}
 	state.healthySuccesses = 0
-	state.unhealthy = true
-	state.generation++
+	state.eject(reason)
 	lb.refreshHealthySelectorsLocked()
 	return true
 }
 
+// inheritHealth copies the health state of every target that previous also
+// selects, so ejections and counters survive a rebuild of the cluster.
+func (lb *HealthAwareLoadBalance) inheritHealth(previous *HealthAwareLoadBalance) {
+	previous.mu.Lock()
+	states := make(map[string]healthState, len(lb.targets))
+	for _, target := range lb.targets {
+		if state, ok := previous.states[target]; ok {
+			states[target] = *state
+		}
+	}
+	previous.mu.Unlock()
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	for target, state := range states {
+		*lb.states[target] = state
+	}
+	lb.refreshHealthySelectorsLocked()
+}
+
 // HealthSnapshot returns a copy of the current healthy state for every target.
 // It is used by active probes to select the healthy/unhealthy probe interval
 // and to decide which targets need recovery.
@@ -538,6 +551,24 @@ func (lb *HealthAwareLoadBalance) HealthSnapshot() map[string]bool {
 	return snapshot
 }
 
+func (state *healthState) eject(reason string) {
+	state.unhealthy = true
+	state.generation++
+	state.reason = reason
+	state.ejectedAt = time.Now()
+}
+
+func (state *healthState) recover() {
+	state.unhealthy = false
+	state.generation++
+	state.httpFailures = 0
+	state.tcpFailures = 0
+	state.timeouts = 0
+	state.healthySuccesses = 0
+	state.reason = ""
+	state.ejectedAt = time.Time{}
+}
+
 func parsePassiveHealthConfig(checks map[string]any) (PassiveHealthConfig, error) {
 	config := PassiveHealthConfig{
 		Type:              "http",
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// HealthCounters are the consecutive outcomes a health check has counted
+// toward its next state transition.
+type HealthCounters struct {
+	Successes    int
+	HTTPFailures int
+	TCPFailures  int
+	Timeouts     int
+}
+
+// TargetHealth is the point-in-time health of one cluster target.
+type TargetHealth struct {
+	Target  string
+	Healthy bool
+	// Reason names the check and threshold that ejected an unhealthy target,
+	// such as "passive http_failures" or "active timeouts".
+	Reason    string
+	EjectedAt time.Time
+	Passive   HealthCounters
+	// Active is nil when the cluster runs no active probes.
+	Active *HealthCounters
+	// LastProbe is nil until the first active probe of the target completes.
+	LastProbe *ActiveProbe
+}
+
+// ActiveProbe is the outcome of the latest active probe of one target.
+type ActiveProbe struct {
+	// Result is "success", "http_failure", "tcp_failure", "timeout", or
+	// "neutral" for a status in neither status list.
+	Result string
+	At     time.Time
+}
+
+// ClusterHealth is the health of every target of one health-checked cluster.
+type ClusterHealth struct {
+	Key  ClusterKey
+	Name string
+	// Type is the probe protocol: the active check type when active probes
+	// run, otherwise the passive check type.
+	Type    string
+	Active  bool
+	Targets []TargetHealth
+}
+
+// TargetHealth returns the passive state of every target, sorted by target.
+func (lb *HealthAwareLoadBalance) TargetHealth() []TargetHealth {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	result := make([]TargetHealth, 0, len(lb.targets))
+	for _, target := range lb.targets {
+		state := lb.states[target]
+		result = append(result, TargetHealth{
+			Target:    target,
+			Healthy:   !state.unhealthy,
+			Reason:    state.reason,
+			EjectedAt: state.ejectedAt,
+			Passive: HealthCounters{
+				Successes:    state.healthySuccesses,
+				HTTPFailures: state.httpFailures,
+				TCPFailures:  state.tcpFailures,
+				Timeouts:     state.timeouts,
+			},
+		})
+	}
+	return result
+}
+
+// Health reports the cluster's target health. The boolean is false when the
+// cluster has no checks block and therefore keeps no health state.
+func (c *Cluster) Health() (ClusterHealth, bool) {
+	healthAware := healthAwareBalancer(c.lb)
+	if healthAware == nil {
+		return ClusterHealth{}, false
+	}
+	health := ClusterHealth{
+		Key:     c.key,
+		Name:    c.config.Name,
+		Type:    healthAware.config.Type,
+		Targets: healthAware.TargetHealth(),
+	}
+	if checker, ok := c.health.(*activeHealthChecker); ok {
+		health.Type = checker.config.Type
+		health.Active = true
+		counters := checker.activeCounters()
+		probes := checker.lastProbes()
+		for index := range health.Targets {
+			target := health.Targets[index].Target
+			active := counters[target]
+			health.Targets[index].Active = &active
+			if probe, ok := probes[target]; ok {
+				health.Targets[index].LastProbe = &probe
+			}
+		}
+	}
+	return health, true
+}
+
+// inheritHealth carries the health of previous, an earlier generation of the
+// same upstream, into c. Both clusters must select the same targets.
+func (c *Cluster) inheritHealth(previous *Cluster) {
+	healthAware, previousHealthAware := healthAwareBalancer(c.lb), healthAwareBalancer(previous.lb)
+	if healthAware == nil || previousHealthAware == nil {
+		return
+	}
+	healthAware.inheritHealth(previousHealthAware)
+	checker, ok := c.health.(*activeHealthChecker)
+	if !ok {
+		return
+	}
+	if previousChecker, ok := previous.health.(*activeHealthChecker); ok {
+		checker.inherit(previousChecker)
+	}
+}
+
+// Health returns the health of every live health-checked cluster, sorted by
+// cluster name and then key.
+func (r *ClusterRegistry) Health() []ClusterHealth {
+	r.mu.Lock()
+	clusters := make([]*Cluster, 0, len(r.entries))
+	for _, entry := range r.entries {
+		clusters = append(clusters, entry.cluster)
+	}
+	r.mu.Unlock()
+
+	result := make([]ClusterHealth, 0, len(clusters))
+	for _, cluster := range clusters {
+		if health, ok := cluster.Health(); ok {
+			result = append(result, health)
+		}
+	}
+	slices.SortFunc(result, func(left, right ClusterHealth) int {
+		if order := cmp.Compare(left.Name, right.Name); order != 0 {
+			return order
+		}
+		return slices.Compare(left.Key[:], right.Key[:])
+	})
+	return result
+}
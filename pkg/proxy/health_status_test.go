@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthAwareLoadBalanceTargetHealthReportsPassiveEjection(t *testing.T) {
+	lb, err := NewHealthAwareLoadBalance(
+		map[string]int{"http://a:80": 1, "http://b:80": 1},
+		map[string]any{"passive": map[string]any{
+			"unhealthy": map[string]any{"http_failures": 2, "tcp_failures": 3},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.ReportHTTP("http://a:80", http.StatusServiceUnavailable)
+	lb.ReportHTTP("http://a:80", http.StatusServiceUnavailable)
+	lb.ReportTCPFailure("http://b:80", false)
+
+	health := lb.TargetHealth()
+	if len(health) != 2 || health[0].Target != "http://a:80" || health[1].Target != "http://b:80" {
+		t.Fatalf("TargetHealth() = %+v, want both targets sorted", health)
+	}
+	ejected := health[0]
+	if ejected.Healthy || ejected.Reason != "passive http_failures" || ejected.EjectedAt.IsZero() {
+		t.Fatalf("ejected target = %+v, want passive http_failures ejection", ejected)
+	}
+	if ejected.Passive.HTTPFailures != 2 {
+		t.Fatalf("ejected passive counters = %+v, want 2 HTTP failures", ejected.Passive)
+	}
+	counting := health[1]
+	if !counting.Healthy || counting.Reason != "" || counting.Passive.TCPFailures != 1 {
+		t.Fatalf("counting target = %+v, want healthy with one TCP failure", counting)
+	}
+
+	for range 5 {
+		lb.ReportHTTP("http://a:80", http.StatusOK)
+	}
+	recovered := lb.TargetHealth()[0]
+	if !recovered.Healthy || recovered.Reason != "" || !recovered.EjectedAt.IsZero() {
+		t.Fatalf("recovered target = %+v, want the ejection reason cleared", recovered)
+	}
+}
+
+func TestClusterRegistryHealthListsCheckedClustersWithActiveCounters(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	registry := NewClusterRegistry(NopClusterObserver{})
+	t.Cleanup(registry.Close)
+	unchecked := testClusterConfig()
+	unchecked.Name = "unchecked"
+	checked := testClusterConfig()
+	checked.Targets = map[string]int{upstream.URL: 1}
+	checked.Checks = map[string]any{"active": map[string]any{
+		"healthy":   map[string]any{"interval": 1},
+		"unhealthy": map[string]any{"interval": 1, "http_failures": 1},
+	}}
+	for _, config := range []ClusterConfig{unchecked, checked} {
+		lease, err := registry.Acquire(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(lease.Stop)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		health := registry.Health()
+		if len(health) != 1 || health[0].Name != "orders" || !health[0].Active {
+			t.Fatalf("Health() = %+v, want only the active-checked cluster", health)
+		}
+		target := health[0].Targets[0]
+		if !target.Healthy {
+			if target.Reason != "active http_failures" || target.Active == nil {
+				t.Fatalf("ejected target = %+v, want active http_failures with active counters", target)
+			}
+			if target.LastProbe == nil || target.LastProbe.Result != "http_failure" || target.LastProbe.At.IsZero() {
+				t.Fatalf("last probe = %+v, want the failing http_failure probe", target.LastProbe)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("active probe did not eject the failing target")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClusterRegistryCarriesTargetHealthAcrossGenerations(t *testing.T) {
+	registry := NewClusterRegistry(NopClusterObserver{})
+	t.Cleanup(registry.Close)
+	config := testClusterConfig()
+	config.Targets = map[string]int{"http://a:80": 1, "http://b:80": 1}
+	config.Checks = map[string]any{"passive": map[string]any{
+		"unhealthy": map[string]any{"http_failures": 1, "tcp_failures": 3},
+	}}
+	previous, err := registry.Acquire(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(previous.Stop)
+	lb := healthAwareBalancer(previous.Cluster().LoadBalancer())
+	lb.ReportHTTP("http://a:80", http.StatusServiceUnavailable)
+	lb.ReportTCPFailure("http://b:80", false)
+	ejected := lb.TargetHealth()[0]
+
+	// A changed timeout builds a new cluster for the same targets.
+	changed := config
+	changed.ReadTimeout = time.Minute
+	next, err := registry.Acquire(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(next.Stop)
+	if next.Cluster() == previous.Cluster() {
+		t.Fatal("changed config reused the previous cluster")
+	}
+	previous.Stop()
+	health, _ := next.Cluster().Health()
+	inherited := health.Targets[0]
+	if inherited.Healthy || inherited.Reason != "passive http_failures" || !inherited.EjectedAt.Equal(ejected.EjectedAt) {
+		t.Fatalf("inherited target = %+v, want the previous ejection", inherited)
+	}
+	if counting := health.Targets[1]; !counting.Healthy || counting.Passive.TCPFailures != 1 {
+		t.Fatalf("inherited counting target = %+v, want one TCP failure", counting)
+	}
+	for range 4 {
+		if target := next.Cluster().LoadBalancer().Next(); target != "http://b:80" {
+			t.Fatalf("Next() = %q, want the ejected target skipped", target)
+		}
+	}
+
+	// A changed target set starts over.
+	grown := changed
+	grown.Targets = map[string]int{"http://a:80": 1, "http://b:80": 1, "http://c:80": 1}
+	fresh, err := registry.Acquire(grown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fresh.Stop)
+	health, _ = fresh.Cluster().Health()
+	for _, target := range health.Targets {
+		if !target.Healthy || target.Passive != (HealthCounters{}) {
+			t.Fatalf("target of a changed target set = %+v, want fresh state", target)
+		}
+	}
+
+	// Changed checks start over as well, even for the same targets.
+	rechecked := changed
+	rechecked.Checks = map[string]any{"passive": map[string]any{
+		"unhealthy": map[string]any{"http_failures": 5, "tcp_failures": 3},
+	}}
+	restarted, err := registry.Acquire(rechecked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(restarted.Stop)
+	health, _ = restarted.Cluster().Health()
+	for _, target := range health.Targets {
+		if !target.Healthy || target.Passive != (HealthCounters{}) {
+			t.Fatalf("target under changed checks = %+v, want fresh state", target)
+		}
+	}
+}
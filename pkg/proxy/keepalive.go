@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// limitConnectionRequests makes every connection of transport serve at most
+// limit requests, as the nginx keepalive_requests behind an APISIX
+// `keepalive_pool.requests`. The request that reaches the limit is sent with
+// `Connection: close`, so the connection retires once its response completes
+// instead of being returned to the idle pool.
+func limitConnectionRequests(transport *http.Transport, limit int) http.RoundTripper {
+	if limit <= 0 || transport.DisableKeepAlives {
+		return transport
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &countedConn{Conn: conn}, nil
+	}
+	return &connectionRequestLimitTransport{base: transport, limit: int64(limit)}
+}
+
+type connectionRequestLimitTransport struct {
+	base  http.RoundTripper
+	limit int64
+}
+
+func (t *connectionRequestLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var outgoing *http.Request
+	// GotConn runs on the RoundTrip goroutine before the request is written,
+	// so marking the outgoing request here decides its Connection header.
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if conn := unwrapCountedConn(info.Conn); conn != nil && conn.requests.Add(1) >= t.limit {
+			outgoing.Close = true
+		}
+	}}
+	outgoing = request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+	return t.base.RoundTrip(outgoing)
+}
+
+// countedConn counts the requests a pooled upstream connection has served.
+type countedConn struct {
+	net.Conn
+	requests atomic.Int64
+}
+
+func unwrapCountedConn(conn net.Conn) *countedConn {
+	for conn != nil {
+		if counted, ok := conn.(*countedConn); ok {
+			return counted
+		}
+		wrapped, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = wrapped.NetConn()
+	}
+	return nil
+}
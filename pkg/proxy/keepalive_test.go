@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClusterRetiresConnectionsAfterKeepalivePoolRequests(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]int)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	config := testClusterConfig()
+	config.Transport = (&TransportOptionBuilder{}).WithKeepalivePool(4, time.Minute, 2).Build()
+	cluster, err := newCluster(config, NopClusterObserver{})
+	if err != nil {
+		t.Fatalf("newCluster() error = %v", err)
+	}
+	defer cluster.Close()
+
+	client := &http.Client{Transport: cluster.RoundTripper()}
+	for range 5 {
+		response, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(remotes) != 3 {
+		t.Fatalf("connections = %v, want three connections serving at most two requests each", remotes)
+	}
+	for remote, count := range remotes {
+		if count > 2 {
+			t.Fatalf("connection %s served %d requests, want at most 2", remote, count)
+		}
+	}
+}
+
+func TestNewTransportAppliesKeepalivePool(t *testing.T) {
+	transport := NewTransport((&TransportOptionBuilder{}).
+		WithMaxIdleConnectionsPerHost(250).
+		WithKeepalivePool(8, 15*time.Second, 100).
+		Build())
+	if transport.MaxIdleConnsPerHost != 8 || transport.IdleConnTimeout != 15*time.Second ||
+		transport.DisableKeepAlives {
+		t.Fatalf(
+			"pool = %d idle, %s timeout, keep-alives disabled %t",
+			transport.MaxIdleConnsPerHost,
+			transport.IdleConnTimeout,
+			transport.DisableKeepAlives,
+		)
+	}
+
+	disabled := NewTransport((&TransportOptionBuilder{}).WithKeepalivePool(8, 0, 100).Build())
+	if !disabled.DisableKeepAlives {
+		t.Fatal("a zero keepalive_pool idle_timeout kept connections alive")
+	}
+}
+
+func TestClusterConfigKeyChangesWithKeepalivePool(t *testing.T) {
+	keys := make(map[ClusterKey]string)
+	for name, option := range map[string]TransportOption{
+		"default":  (&TransportOptionBuilder{}).Build(),
+		"size":     (&TransportOptionBuilder{}).WithKeepalivePool(8, time.Minute, 1000).Build(),
+		"idle":     (&TransportOptionBuilder{}).WithKeepalivePool(8, 30*time.Second, 1000).Build(),
+		"requests": (&TransportOptionBuilder{}).WithKeepalivePool(8, 30*time.Second, 10).Build(),
+	} {
+		config := testClusterConfig()
+		config.Transport = option
+		key, err := config.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous, ok := keys[key]; ok {
+			t.Fatalf("keepalive pools %q and %q share a cluster key", previous, name)
+		}
+		keys[key] = name
+	}
+}
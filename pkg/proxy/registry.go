@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"errors"
+	"reflect"
 	"sync"
 )
 
@@ -30,17 +31,21 @@ func (l *ClusterLease) Stop() {
 type clusterEntry struct {
 	cluster *Cluster
 	refs    int
+	// seq orders entries by creation.
+	seq uint64
 }
 
 // ClusterRegistry interns immutable ClusterConfig values by digest. Acquire
 // reuses an existing cluster when the effective configuration is
-// byte-identical; changed configuration always receives a new cluster. Close
-// is terminal and closes every remaining cluster.
+// byte-identical; changed configuration always receives a new cluster, which
+// inherits the target health of the newest live cluster of the same name and
+// target set. Close is terminal and closes every remaining cluster.
 type ClusterRegistry struct {
 	mu       sync.Mutex
 	entries  map[ClusterKey]*clusterEntry
 	observer ClusterObserver
 	closed   bool
+	seq      uint64
 }
 
 // NewClusterRegistry creates a digest-keyed registry owned by the given
@@ -73,15 +78,53 @@ func (r *ClusterRegistry) Acquire(config ClusterConfig) (*ClusterLease, error) {
 	if err != nil {
 		return nil, err
 	}
-	if statusObserver, ok := r.observer.(UpstreamStatusObserver); ok {
-		for _, target := range sortedClusterTargets(config.Targets) {
+	healthy := map[string]bool{}
+	if previous := r.predecessorLocked(config); previous != nil {
+		cluster.inheritHealth(previous)
+		if healthAware := healthAwareBalancer(cluster.lb); healthAware != nil {
+			healthy = healthAware.HealthSnapshot()
+		}
+	}
+	for _, target := range sortedClusterTargets(config.Targets) {
+		if targetHealthy, ok := healthy[target.Target]; ok && !targetHealthy {
+			r.observer.SetHealth(config.Name, target.Target, false)
+		} else if statusObserver, ok := r.observer.(UpstreamStatusObserver); ok {
 			statusObserver.SetUpstreamStatus(config.Name, target.Target, true)
 		}
 	}
-	r.entries[key] = &clusterEntry{cluster: cluster, refs: 1}
+	r.seq++
+	r.entries[key] = &clusterEntry{cluster: cluster, refs: 1, seq: r.seq}
 	return &ClusterLease{cluster: cluster, release: r.release(key)}, nil
 }
 
+// predecessorLocked returns the newest live cluster with the name, target
+// set and health checks of config, or nil. A target ejected under other
+// checks may not be ejected under these, so changed checks start over.
+func (r *ClusterRegistry) predecessorLocked(config ClusterConfig) *Cluster {
+	var newest *clusterEntry
+	for _, entry := range r.entries {
+		previous := entry.cluster.config
+		if previous.Name != config.Name || len(previous.Targets) != len(config.Targets) ||
+			!reflect.DeepEqual(previous.Checks, config.Checks) {
+			continue
+		}
+		sameTargets := true
+		for target := range config.Targets {
+			if _, ok := previous.Targets[target]; !ok {
+				sameTargets = false
+				break
+			}
+		}
+		if sameTargets && (newest == nil || entry.seq > newest.seq) {
+			newest = entry
+		}
+	}
+	if newest == nil {
+		return nil
+	}
+	return newest.cluster
+}
+
 func (r *ClusterRegistry) release(key ClusterKey) func() {
 	return func() {
 		r.mu.Lock()
@@ -36,10 +36,13 @@ type TransportOption struct {
 	maxIdleConnectionsPerHost int
 	maxConnectionsPerHost     int
 	insecureSkipVerify        bool
+	tlsServerName             string
 	tlsClientCertificate      tls.Certificate
 	dialTimeout               time.Duration
 	responseHeaderTimeout     time.Duration
 	idleConnTimeout           time.Duration
+	disableKeepAlives         bool
+	keepaliveRequests         int
 }
 
 type TransportOptionBuilder struct {
@@ -77,6 +80,13 @@ func (ob *TransportOptionBuilder) WithInsecureSkipVerify(value bool) *TransportO
 	return ob
 }
 
+// WithTLSServerName sets the SNI and verified name for HTTPS upstreams whose
+// targets are addresses resolved from a domain. Empty uses the target host.
+func (ob *TransportOptionBuilder) WithTLSServerName(name string) *TransportOptionBuilder {
+	ob.opt.tlsServerName = name
+	return ob
+}
+
 // WithTLSClientCertificate configures the certificate presented to HTTPS
 // upstreams. The certificate bytes are cloned so later caller mutations do
 // not change the immutable transport option.
@@ -129,6 +139,22 @@ func (ob *TransportOptionBuilder) WithMaxConnectionsPerHost(value int) *Transpor
 	return ob
 }
 
+// WithKeepalivePool applies an upstream `keepalive_pool`: size idle
+// connections per upstream host, closed after idleTimeout or once a
+// connection has served requests requests. A zero idleTimeout disables
+// keep-alive, as a zero nginx keepalive_timeout does.
+func (ob *TransportOptionBuilder) WithKeepalivePool(
+	size int,
+	idleTimeout time.Duration,
+	requests int,
+) *TransportOptionBuilder {
+	ob.opt.maxIdleConnectionsPerHost = size
+	ob.opt.idleConnTimeout = idleTimeout
+	ob.opt.disableKeepAlives = idleTimeout <= 0
+	ob.opt.keepaliveRequests = requests
+	return ob
+}
+
 // transportKeyIdentity is the deterministic, complete serialization of every
 // effective value that changes transport behavior. It feeds upstream cluster
 // identity so a cluster is only reused for byte-identical effective config.
@@ -137,10 +163,13 @@ type transportKeyIdentity struct {
 	MaxIdleConnsPerHost             int
 	MaxConnsPerHost                 int
 	InsecureSkipVerify              bool
+	TLSServerName                   string
 	TLSClientCertificateFingerprint [sha256.Size]byte
 	DialTimeout                     time.Duration
 	ResponseHeaderTimeout           time.Duration
 	IdleConnTimeout                 time.Duration
+	DisableKeepAlives               bool
+	KeepaliveRequests               int
 }
 
 func (t TransportOption) keyIdentity() transportKeyIdentity {
@@ -149,10 +178,13 @@ func (t TransportOption) keyIdentity() transportKeyIdentity {
 		MaxIdleConnsPerHost:             t.maxIdleConnectionsPerHost,
 		MaxConnsPerHost:                 t.maxConnectionsPerHost,
 		InsecureSkipVerify:              t.insecureSkipVerify,
+		TLSServerName:                   t.tlsServerName,
 		TLSClientCertificateFingerprint: tlsClientCertificateFingerprint(t.tlsClientCertificate),
 		DialTimeout:                     t.dialTimeout,
 		ResponseHeaderTimeout:           t.responseHeaderTimeout,
 		IdleConnTimeout:                 t.idleConnTimeout,
+		DisableKeepAlives:               t.disableKeepAlives,
+		KeepaliveRequests:               t.keepaliveRequests,
 	}
 }
 
@@ -213,7 +245,7 @@ func NewTransport(t TransportOption) *http.Transport {
 	// it won't grow to more than max_idle_conns_per_host * upstreamHostsNumber anyways
 
 	// reference: https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
-	tlsConfig := &tls.Config{InsecureSkipVerify: t.insecureSkipVerify}
+	tlsConfig := &tls.Config{InsecureSkipVerify: t.insecureSkipVerify, ServerName: t.tlsServerName}
 	if len(t.tlsClientCertificate.Certificate) > 0 || t.tlsClientCertificate.PrivateKey != nil {
 		tlsConfig.Certificates = []tls.Certificate{cloneTLSCertificate(t.tlsClientCertificate)}
 	}
@@ -226,6 +258,7 @@ func NewTransport(t TransportOption) *http.Transport {
 			DualStack: true,
 		}).DialContext,
 		IdleConnTimeout:       t.idleConnTimeout,
+		DisableKeepAlives:     t.disableKeepAlives,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 		ResponseHeaderTimeout: t.responseHeaderTimeout,
@@ -85,6 +85,34 @@ func TestNewTransportHonorsTLSVerification(t *testing.T) {
 	}
 }
 
+func TestNewTransportSendsConfiguredTLSServerName(t *testing.T) {
+	serverNames := make(chan string, 1)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	upstream.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	option := (&TransportOptionBuilder{}).WithInsecureSkipVerify(true).WithTLSServerName("api.example.com").Build()
+	response, err := (&http.Client{Transport: NewTransport(option)}).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	_ = response.Body.Close()
+	if got := <-serverNames; got != "api.example.com" {
+		t.Fatalf("SNI = %q, want the configured server name", got)
+	}
+
+	unnamed := (&TransportOptionBuilder{}).WithInsecureSkipVerify(true).Build()
+	if option.keyIdentity() == unnamed.keyIdentity() {
+		t.Fatal("TLS server name does not change the transport identity")
+	}
+}
+
 func TestNewTransportSendsConfiguredTLSClientCertificate(t *testing.T) {
 	serverCertificate, clientCertificate, clientCAs := testMutualTLSCertificates(t)
 	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
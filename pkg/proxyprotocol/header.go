@@ -0,0 +1,206 @@
+// Package proxyprotocol reads and writes HAProxy PROXY protocol v1 and v2
+// headers, which load balancers such as AWS NLB and HAProxy prepend to a TCP
+// connection to carry the original client and destination addresses.
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// maxV1HeaderSize is the longest v1 line the specification allows,
+	// including the trailing CRLF.
+	maxV1HeaderSize = 107
+	v2HeaderSize    = 16
+	// maxV2PayloadSize bounds the addresses and TLVs read after a v2 header.
+	maxV2PayloadSize = 4096
+
+	v2CommandLocal = 0x0
+	v2CommandProxy = 0x1
+
+	v2FamilyUnspec = 0x0
+	v2FamilyInet   = 0x1
+	v2FamilyInet6  = 0x2
+
+	v2TransportStream   = 0x1
+	v2TransportDatagram = 0x2
+)
+
+// ErrNoHeader is returned when a connection does not start with a PROXY
+// protocol header.
+var ErrNoHeader = errors.New("connection does not start with a PROXY protocol header")
+
+// Header is a parsed PROXY protocol header. Source and Destination are nil
+// for a LOCAL (health check) or UNKNOWN header, whose connection addresses
+// are the real ones.
+type Header struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Read consumes one v1 or v2 header from r.
+func Read(r *bufio.Reader) (Header, error) {
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, bufio.ErrBufferFull) {
+			return Header{}, ErrNoHeader
+		}
+		return Header{}, fmt.Errorf("read PROXY protocol header: %w", err)
+	}
+	if bytes.Equal(prefix, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readV1(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (Header, error) {
+	line := make([]byte, 0, maxV1HeaderSize)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return Header{}, fmt.Errorf("read PROXY protocol v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderSize {
+			return Header{}, fmt.Errorf("PROXY protocol v1 header exceeds %d bytes", maxV1HeaderSize)
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return Header{}, fmt.Errorf("PROXY protocol v1 header does not end with CRLF")
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{Version: 1}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return Header{}, fmt.Errorf("malformed PROXY protocol v1 header %q", text)
+	}
+	source, err := v1Address(fields[1], fields[2], fields[4])
+	if err != nil {
+		return Header{}, err
+	}
+	destination, err := v1Address(fields[1], fields[3], fields[5])
+	if err != nil {
+		return Header{}, err
+	}
+	return Header{Version: 1, Source: source, Destination: destination}, nil
+}
+
+func v1Address(protocol, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (protocol == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 %s address %q", protocol, host)
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: number}, nil
+}
+
+func readV2(r *bufio.Reader) (Header, error) {
+	fixed := make([]byte, v2HeaderSize)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return Header{}, fmt.Errorf("read PROXY protocol v2 header: %w", err)
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return Header{}, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := fixed[12] & 0x0f
+	family := fixed[13] >> 4
+	transport := fixed[13] & 0x0f
+	length := int(binary.BigEndian.Uint16(fixed[14:]))
+	if length > maxV2PayloadSize {
+		return Header{}, fmt.Errorf("PROXY protocol v2 payload of %d bytes is too large", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Header{}, fmt.Errorf("read PROXY protocol v2 addresses: %w", err)
+	}
+
+	switch command {
+	case v2CommandLocal:
+		return Header{Version: 2}, nil
+	case v2CommandProxy:
+	default:
+		return Header{}, fmt.Errorf("unsupported PROXY protocol v2 command %d", command)
+	}
+	var size int
+	switch family {
+	case v2FamilyInet:
+		size = net.IPv4len
+	case v2FamilyInet6:
+		size = net.IPv6len
+	default:
+		// AF_UNSPEC and AF_UNIX carry no usable IP addresses.
+		return Header{Version: 2}, nil
+	}
+	if transport != v2TransportStream && transport != v2TransportDatagram {
+		return Header{}, fmt.Errorf("unsupported PROXY protocol v2 transport %d", transport)
+	}
+	if len(payload) < 2*size+4 {
+		return Header{}, fmt.Errorf("PROXY protocol v2 address block is truncated")
+	}
+	sourceIP := net.IP(bytes.Clone(payload[:size]))
+	destinationIP := net.IP(bytes.Clone(payload[size : 2*size]))
+	sourcePort := int(binary.BigEndian.Uint16(payload[2*size:]))
+	destinationPort := int(binary.BigEndian.Uint16(payload[2*size+2:]))
+	if transport == v2TransportDatagram {
+		return Header{
+			Version:     2,
+			Source:      &net.UDPAddr{IP: sourceIP, Port: sourcePort},
+			Destination: &net.UDPAddr{IP: destinationIP, Port: destinationPort},
+		}, nil
+	}
+	return Header{
+		Version:     2,
+		Source:      &net.TCPAddr{IP: sourceIP, Port: sourcePort},
+		Destination: &net.TCPAddr{IP: destinationIP, Port: destinationPort},
+	}, nil
+}
+
+// AppendV2 appends a v2 PROXY header for a TCP connection from source to
+// destination. Addresses that are not TCP, or whose families differ, produce
+// a LOCAL header so the receiver keeps the connection's own addresses.
+func AppendV2(dst []byte, source, destination net.Addr) []byte {
+	dst = append(dst, v2Signature...)
+	sourceTCP, sourceOK := source.(*net.TCPAddr)
+	destinationTCP, destinationOK := destination.(*net.TCPAddr)
+	if !sourceOK || !destinationOK {
+		return append(dst, 2<<4|v2CommandLocal, v2FamilyUnspec<<4, 0, 0)
+	}
+	sourceIP, destinationIP := sourceTCP.IP.To4(), destinationTCP.IP.To4()
+	family := byte(v2FamilyInet)
+	if sourceIP == nil || destinationIP == nil {
+		sourceIP, destinationIP = sourceTCP.IP.To16(), destinationTCP.IP.To16()
+		family = v2FamilyInet6
+	}
+	if sourceIP == nil || destinationIP == nil {
+		return append(dst, 2<<4|v2CommandLocal, v2FamilyUnspec<<4, 0, 0)
+	}
+	dst = append(dst, 2<<4|v2CommandProxy, family<<4|v2TransportStream)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(2*len(sourceIP)+4))
+	dst = append(dst, sourceIP...)
+	dst = append(dst, destinationIP...)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(sourceTCP.Port))
+	return binary.BigEndian.AppendUint16(dst, uint16(destinationTCP.Port))
+}
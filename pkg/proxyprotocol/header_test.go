@@ -0,0 +1,148 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadV1(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		input       string
+		source      string
+		destination string
+	}{
+		{
+			name:        "tcp4",
+			input:       "PROXY TCP4 203.0.113.7 192.0.2.10 56324 443\r\n",
+			source:      "203.0.113.7:56324",
+			destination: "192.0.2.10:443",
+		},
+		{
+			name:        "tcp6",
+			input:       "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			source:      "[2001:db8::1]:56324",
+			destination: "[2001:db8::2]:443",
+		},
+		{name: "unknown", input: "PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(test.input + "payload"))
+			header, err := Read(reader)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if header.Version != 1 || addrString(header.Source) != test.source ||
+				addrString(header.Destination) != test.destination {
+				t.Fatalf("Read() = %+v, want %s -> %s", header, test.source, test.destination)
+			}
+			if rest, _ := io.ReadAll(reader); string(rest) != "payload" {
+				t.Fatalf("payload after header = %q, want payload", rest)
+			}
+		})
+	}
+}
+
+func TestReadV1RejectsMalformedHeaders(t *testing.T) {
+	for _, input := range []string{
+		"PROXY TCP4 203.0.113.7 192.0.2.10 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.10 56324 443\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.10 056324 443\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.10 56324 443\n",
+		"PROXY TCP4 " + strings.Repeat("1", maxV1HeaderSize) + "\r\n",
+	} {
+		if _, err := Read(bufio.NewReader(strings.NewReader(input))); err == nil || errors.Is(err, ErrNoHeader) {
+			t.Errorf("Read(%q) error = %v, want a malformed header error", input, err)
+		}
+	}
+}
+
+func TestReadV2(t *testing.T) {
+	// A TCP over IPv4 header from 203.0.113.7:56324 to 192.0.2.10:443 with a
+	// trailing NOOP TLV, as sent by AWS NLB.
+	raw, _ := hex.DecodeString("0d0a0d0a000d0a515549540a" + "2111" + "0010" +
+		"cb007107" + "c000020a" + "dc04" + "01bb" + "040001" + "00")
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(raw), strings.NewReader("payload")))
+	header, err := Read(reader)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if header.Version != 2 || addrString(header.Source) != "203.0.113.7:56324" ||
+		addrString(header.Destination) != "192.0.2.10:443" {
+		t.Fatalf("Read() = %+v", header)
+	}
+	if rest, _ := io.ReadAll(reader); string(rest) != "payload" {
+		t.Fatalf("payload after header = %q, want payload", rest)
+	}
+}
+
+func TestReadV2LocalAndUnspecKeepConnectionAddresses(t *testing.T) {
+	for _, command := range []string{"2000", "2100"} {
+		raw, _ := hex.DecodeString("0d0a0d0a000d0a515549540a" + command + "0000")
+		header, err := Read(bufio.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			t.Fatalf("Read(%s) error = %v", command, err)
+		}
+		if header.Version != 2 || header.Source != nil || header.Destination != nil {
+			t.Fatalf("Read(%s) = %+v, want a header without addresses", command, header)
+		}
+	}
+}
+
+func TestReadRejectsMissingAndTruncatedHeaders(t *testing.T) {
+	if _, err := Read(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("Read(HTTP request) error = %v, want ErrNoHeader", err)
+	}
+	if _, err := Read(bufio.NewReader(strings.NewReader("PROXY"))); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("Read(short input) error = %v, want ErrNoHeader", err)
+	}
+	truncated, _ := hex.DecodeString("0d0a0d0a000d0a515549540a" + "2111" + "0004" + "cb007107")
+	if _, err := Read(bufio.NewReader(bytes.NewReader(truncated))); err == nil {
+		t.Fatal("Read() accepted a truncated v2 address block")
+	}
+}
+
+func TestAppendV2RoundTrips(t *testing.T) {
+	for _, test := range []struct {
+		source      *net.TCPAddr
+		destination *net.TCPAddr
+	}{
+		{
+			source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 56324},
+			destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 443},
+		},
+		{
+			source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324},
+			destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 443},
+		},
+	} {
+		header, err := Read(bufio.NewReader(bytes.NewReader(AppendV2(nil, test.source, test.destination))))
+		if err != nil {
+			t.Fatalf("Read(AppendV2()) error = %v", err)
+		}
+		source := header.Source.(*net.TCPAddr)
+		destination := header.Destination.(*net.TCPAddr)
+		if !source.IP.Equal(test.source.IP) || source.Port != test.source.Port ||
+			!destination.IP.Equal(test.destination.IP) || destination.Port != test.destination.Port {
+			t.Fatalf("round trip = %s -> %s, want %s -> %s", source, destination, test.source, test.destination)
+		}
+	}
+
+	header, err := Read(bufio.NewReader(bytes.NewReader(AppendV2(nil, &net.UnixAddr{Name: "/tmp/s"}, nil))))
+	if err != nil || header.Source != nil {
+		t.Fatalf("Read(AppendV2(unix)) = %+v, %v, want a LOCAL header", header, err)
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,118 @@
+package proxyprotocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its
+// PROXY header.
+const DefaultHeaderTimeout = 10 * time.Second
+
+// ErrUntrustedSource is returned when a peer outside the trusted networks
+// sends a connection to a PROXY protocol listener.
+var ErrUntrustedSource = errors.New("PROXY protocol source is not trusted")
+
+// Listener requires a PROXY header on every accepted connection. The header
+// is read lazily by the connection's first Read or RemoteAddr, so a slow
+// client never blocks Accept.
+type Listener struct {
+	net.Listener
+	// Trusted lists the peers allowed to send PROXY headers; with none, every
+	// connection is refused.
+	Trusted       []*net.IPNet
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps inner with the default header timeout.
+func NewListener(inner net.Listener, trusted []*net.IPNet) *Listener {
+	return &Listener{Listener: inner, Trusted: trusted, HeaderTimeout: DefaultHeaderTimeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.Trusted, l.HeaderTimeout), nil
+}
+
+// Conn is a connection whose remote address comes from its PROXY header. Like
+// NGINX, LocalAddr stays the listening socket's address, so server_addr and
+// server_port keep matching the configured listener; the header destination
+// is available from Header.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	once   sync.Once
+	header Header
+	err    error
+}
+
+// NewConn wraps conn, whose PROXY header is read on first use.
+func NewConn(conn net.Conn, trusted []*net.IPNet, timeout time.Duration) *Conn {
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), trusted: trusted, timeout: timeout}
+}
+
+// Header reads the PROXY header once and returns it.
+func (c *Conn) Header() (Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) readHeader() {
+	if !c.trustedPeer() {
+		c.err = fmt.Errorf("%w: %s", ErrUntrustedSource, c.Conn.RemoteAddr())
+		return
+	}
+	if c.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+	}
+	c.header, c.err = Read(c.reader)
+}
+
+func (c *Conn) trustedPeer() bool {
+	peer, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range c.trusted {
+		if network.Contains(peer.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr is the header source, or the peer address when the header is
+// LOCAL, UNKNOWN or invalid.
+func (c *Conn) RemoteAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// CloseWrite half-closes the underlying connection when it supports it, so
+// stream bridges keep their half-close semantics.
+func (c *Conn) CloseWrite() error {
+	if writer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return writer.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package proxyprotocol
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerSurfacesHeaderSourceAndKeepsPayload(t *testing.T) {
+	listener := newTestListener(t, loopbackNetworks())
+	client := dialTestListener(t, listener)
+	if _, err := client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.10 56324 443\r\nhello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	conn := acceptTestConn(t, listener)
+	if got := conn.RemoteAddr().String(); got != "203.0.113.7:56324" {
+		t.Fatalf("RemoteAddr() = %q, want the header source", got)
+	}
+	if got := conn.LocalAddr().String(); got != listener.Addr().String() {
+		t.Fatalf("LocalAddr() = %q, want the listening socket %q", got, listener.Addr())
+	}
+	payload := make([]byte, len("hello"))
+	if _, err := io.ReadFull(conn, payload); err != nil || string(payload) != "hello" {
+		t.Fatalf("payload = %q, %v, want hello", payload, err)
+	}
+}
+
+func TestListenerRejectsUntrustedPeer(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	listener := newTestListener(t, []*net.IPNet{trusted})
+	client := dialTestListener(t, listener)
+	_, _ = client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.10 56324 443\r\n"))
+
+	conn := acceptTestConn(t, listener)
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrUntrustedSource) {
+		t.Fatalf("Read() error = %v, want ErrUntrustedSource", err)
+	}
+	if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Fatalf("RemoteAddr() = %q, want the untrusted peer %q", got, client.LocalAddr())
+	}
+}
+
+func TestListenerRefusesEveryPeerWithoutTrustedNetworks(t *testing.T) {
+	listener := newTestListener(t, nil)
+	client := dialTestListener(t, listener)
+	_, _ = client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.10 56324 443\r\n"))
+
+	conn := acceptTestConn(t, listener)
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrUntrustedSource) {
+		t.Fatalf("Read() error = %v, want ErrUntrustedSource", err)
+	}
+	if got := conn.RemoteAddr().String(); got != client.LocalAddr().String() {
+		t.Fatalf("RemoteAddr() = %q, want the socket peer %q", got, client.LocalAddr())
+	}
+}
+
+func TestListenerTimesOutMissingHeader(t *testing.T) {
+	listener := newTestListener(t, loopbackNetworks())
+	listener.HeaderTimeout = 50 * time.Millisecond
+	dialTestListener(t, listener)
+
+	conn := acceptTestConn(t, listener)
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() succeeded without a PROXY header")
+	}
+}
+
+func loopbackNetworks() []*net.IPNet {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	return []*net.IPNet{loopback}
+}
+
+func newTestListener(t *testing.T, trusted []*net.IPNet) *Listener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = inner.Close() })
+	return NewListener(inner, trusted)
+}
+
+func dialTestListener(t *testing.T, listener net.Listener) net.Conn {
+	t.Helper()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func acceptTestConn(t *testing.T, listener net.Listener) net.Conn {
+	t.Helper()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
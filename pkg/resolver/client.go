@@ -0,0 +1,233 @@
+package resolver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	typeA    = dnsmessage.TypeA
+	typeAAAA = dnsmessage.TypeAAAA
+	typeSRV  = dnsmessage.TypeSRV
+
+	// maxUDPMessageSize is the EDNS(0) payload size advertised to
+	// nameservers; larger answers are truncated and retried over TCP.
+	maxUDPMessageSize = 1232
+)
+
+// notFoundError is an authoritative NXDOMAIN or an answer without records of
+// the requested type, cached for ttl.
+type notFoundError struct {
+	ttl time.Duration
+}
+
+func (e *notFoundError) Error() string { return ErrNotFound.Error() }
+
+func (e *notFoundError) Unwrap() error { return ErrNotFound }
+
+// unavailableError means no nameserver returned a usable answer.
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string { return e.err.Error() }
+
+func (e *unavailableError) Unwrap() error { return e.err }
+
+type records struct {
+	addrs []netip.Addr
+	srvs  []SRV
+}
+
+// exchangeAll asks each nameserver in turn, starting with the next one in
+// rotation, until one answers authoritatively.
+func (r *Resolver) exchangeAll(
+	ctx context.Context,
+	name string,
+	qtype dnsmessage.Type,
+) (records, time.Duration, error) {
+	start := int(r.next.Add(1) - 1)
+	var lastErr error
+	for offset := range r.servers {
+		server := r.servers[(start+offset)%len(r.servers)]
+		result, ttl, err := r.exchange(ctx, server, name, qtype)
+		if err == nil {
+			return result, ttl, nil
+		}
+		var notFound *notFoundError
+		if errors.As(err, &notFound) {
+			return records{}, 0, err
+		}
+		lastErr = fmt.Errorf("nameserver %s: %w", server, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return records{}, 0, &unavailableError{err: lastErr}
+}
+
+func (r *Resolver) exchange(
+	ctx context.Context,
+	server, name string,
+	qtype dnsmessage.Type,
+) (records, time.Duration, error) {
+	questionName, err := dnsmessage.NewName(name)
+	if err != nil {
+		return records{}, 0, fmt.Errorf("invalid name %q: %w", name, err)
+	}
+	var id [2]byte
+	_, _ = rand.Read(id[:])
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: questionName, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	var edns dnsmessage.ResourceHeader
+	if err := edns.SetEDNS0(maxUDPMessageSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return records{}, 0, err
+	}
+	query.Additionals = []dnsmessage.Resource{{Header: edns, Body: &dnsmessage.OPTResource{}}}
+	packed, err := query.Pack()
+	if err != nil {
+		return records{}, 0, fmt.Errorf("pack query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	response, err := exchangeUDP(ctx, server, packed)
+	if err == nil && response.Header.Truncated {
+		response, err = exchangeTCP(ctx, server, packed)
+	}
+	if err != nil {
+		return records{}, 0, err
+	}
+	if response.Header.ID != query.Header.ID || !response.Header.Response ||
+		len(response.Questions) != 1 || response.Questions[0] != query.Questions[0] {
+		return records{}, 0, fmt.Errorf("response does not match the query")
+	}
+	return parseResponse(response, qtype)
+}
+
+func exchangeUDP(ctx context.Context, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, maxUDPMessageSize)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		var response dnsmessage.Message
+		if err := response.Unpack(buffer[:n]); err != nil {
+			// A malformed or spoofed datagram does not end the exchange; the
+			// deadline does.
+			continue
+		}
+		if response.Header.ID == binary.BigEndian.Uint16(packed) {
+			return &response, nil
+		}
+	}
+}
+
+func exchangeTCP(ctx context.Context, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, len(packed)+2), uint16(len(packed)))
+	if _, err := conn.Write(append(framed, packed...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, err
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(buffer); err != nil {
+		return nil, fmt.Errorf("unpack response: %w", err)
+	}
+	return &response, nil
+}
+
+// parseResponse collects the records of qtype from the answer section. A
+// recursive nameserver returns the whole CNAME chain, so records are taken
+// regardless of their owner name.
+func parseResponse(response *dnsmessage.Message, qtype dnsmessage.Type) (records, time.Duration, error) {
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return records{}, 0, &notFoundError{ttl: negativeTTL(response)}
+	default:
+		return records{}, 0, fmt.Errorf("nameserver returned %s", response.Header.RCode)
+	}
+	var result records
+	var ttl time.Duration
+	found := false
+	for _, resource := range response.Answers {
+		if resource.Header.Type != qtype {
+			continue
+		}
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			result.addrs = append(result.addrs, netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			result.addrs = append(result.addrs, netip.AddrFrom16(body.AAAA))
+		case *dnsmessage.SRVResource:
+			result.srvs = append(result.srvs, SRV{
+				Target:   body.Target.String(),
+				Port:     body.Port,
+				Priority: body.Priority,
+				Weight:   body.Weight,
+			})
+		default:
+			continue
+		}
+		recordTTL := time.Duration(resource.Header.TTL) * time.Second
+		if !found || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		found = true
+	}
+	if !found {
+		return records{}, 0, &notFoundError{ttl: negativeTTL(response)}
+	}
+	return result, ttl, nil
+}
+
+// negativeTTL follows RFC 2308: the lesser of the SOA record TTL and its
+// MINIMUM field.
+func negativeTTL(response *dnsmessage.Message) time.Duration {
+	for _, resource := range response.Authorities {
+		if soa, ok := resource.Body.(*dnsmessage.SOAResource); ok {
+			return time.Duration(min(resource.Header.TTL, soa.MinTTL)) * time.Second
+		}
+	}
+	return defaultNegativeTTL
+}
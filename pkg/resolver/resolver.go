@@ -0,0 +1,555 @@
+// Package resolver resolves upstream domain nodes through the nameservers
+// configured by `apisix.dns_resolver`.
+//
+// A Resolver caches A/AAAA and SRV answers for their record TTL (or
+// `dns_resolver_valid` when set) and caches failures for the negative TTL of
+// the zone. Route builds read the cache through a Lease and never wait on a
+// nameserver: a name a build has not seen before is enrolled and resolved by
+// the background refresher, which re-resolves expired names and notifies the
+// owner when an answer changes so the routes are rebuilt. A name is dropped
+// once no lease holds it. Because cluster identity is derived from the
+// resolved targets, a changed answer produces a new proxy cluster while
+// unchanged upstreams keep their existing one.
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	// DefaultTimeout bounds one nameserver exchange when `resolver_timeout` is
+	// unset.
+	DefaultTimeout = 5 * time.Second
+
+	// defaultNegativeTTL caches an empty or NXDOMAIN answer whose response
+	// carries no SOA record.
+	defaultNegativeTTL = 30 * time.Second
+	// failureTTL caches a lookup that no nameserver answered, so a broken
+	// resolver is retried soon without being queried on every route build.
+	failureTTL = time.Second
+	// minimumTTL keeps zero-TTL records from being re-resolved continuously.
+	minimumTTL = time.Second
+
+	refreshInterval = time.Second
+)
+
+// ErrNotFound reports that a name has no records of the requested type.
+var ErrNotFound = errors.New("no such host")
+
+// ErrPending reports that a leased name has not been resolved yet.
+var ErrPending = errors.New("name has not resolved yet")
+
+// Options configures a Resolver.
+type Options struct {
+	// Nameservers are `ip` or `ip:port` addresses; port 53 is the default.
+	Nameservers []string
+	// Valid overrides the TTL of every positive answer when positive.
+	Valid time.Duration
+	// Timeout bounds one exchange with one nameserver.
+	Timeout time.Duration
+	// Search and Ndots qualify relative names as resolv.conf does. An empty
+	// Search list treats every name as absolute.
+	Search []string
+	Ndots  int
+	// IPv6 also queries AAAA records.
+	IPv6 bool
+	// Hosts are static answers, such as /etc/hosts entries, that take
+	// precedence over DNS and never expire.
+	Hosts map[string][]netip.Addr
+}
+
+// SRV is one SRV record.
+type SRV struct {
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+}
+
+type lookupKind int
+
+const (
+	lookupIP lookupKind = iota
+	lookupSRV
+)
+
+type question struct {
+	name string
+	kind lookupKind
+}
+
+type answer struct {
+	addrs []netip.Addr
+	srvs  []SRV
+}
+
+func (a answer) equal(other answer) bool {
+	return slices.Equal(a.addrs, other.addrs) && slices.Equal(a.srvs, other.srvs)
+}
+
+type entry struct {
+	answer  answer
+	err     error
+	expires time.Time
+	// pending marks a leased name the refresher has not resolved yet.
+	pending bool
+	// refs counts the leases holding the name.
+	refs int
+}
+
+// Resolver is a caching DNS stub resolver. It is safe for concurrent use.
+type Resolver struct {
+	servers []string
+	valid   time.Duration
+	timeout time.Duration
+	search  []string
+	ndots   int
+	ipv6    bool
+	hosts   map[string][]netip.Addr
+	next    atomic.Uint64
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[question]entry
+
+	lifecycleMu sync.Mutex
+	cancel      context.CancelFunc
+	done        sync.WaitGroup
+}
+
+// New validates options and returns an empty resolver.
+func New(options Options) (*Resolver, error) {
+	servers := make([]string, 0, len(options.Nameservers))
+	for index, server := range options.Nameservers {
+		server = strings.TrimSpace(server)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		host, _, _ := net.SplitHostPort(server)
+		if _, err := netip.ParseAddr(host); err != nil {
+			return nil, fmt.Errorf("nameserver[%d] must be an IP address with optional port, got %q", index, server)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("at least one nameserver is required")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	search := make([]string, 0, len(options.Search))
+	for _, domain := range options.Search {
+		if domain = strings.Trim(strings.TrimSpace(domain), "."); domain != "" {
+			search = append(search, domain)
+		}
+	}
+	hosts := make(map[string][]netip.Addr, len(options.Hosts))
+	for name, addrs := range options.Hosts {
+		if !options.IPv6 {
+			addrs = slices.DeleteFunc(slices.Clone(addrs), func(addr netip.Addr) bool { return !addr.Unmap().Is4() })
+		}
+		if len(addrs) > 0 {
+			hosts[canonicalName(name)] = sortedAddrs(addrs)
+		}
+	}
+	return &Resolver{
+		servers: servers,
+		valid:   options.Valid,
+		timeout: timeout,
+		search:  search,
+		ndots:   max(options.Ndots, 1),
+		ipv6:    options.IPv6,
+		hosts:   hosts,
+		now:     time.Now,
+		entries: make(map[question]entry),
+	}, nil
+}
+
+// LookupIP returns the sorted addresses of host. IP literals are returned
+// unchanged.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.Trim(strings.TrimSpace(host), "[]")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if addrs, ok := r.hosts[canonicalName(host)]; ok {
+		return slices.Clone(addrs), nil
+	}
+	result, err := r.lookup(ctx, question{name: canonicalName(host), kind: lookupIP})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(result.addrs), nil
+}
+
+// LookupSRV returns the SRV records of name ordered by priority, weight,
+// target and port.
+func (r *Resolver) LookupSRV(ctx context.Context, name string) ([]SRV, error) {
+	result, err := r.lookup(ctx, question{name: canonicalName(name), kind: lookupSRV})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(result.srvs), nil
+}
+
+func (r *Resolver) lookup(ctx context.Context, key question) (answer, error) {
+	r.mu.Lock()
+	current, cached := r.entries[key]
+	r.mu.Unlock()
+	if cached && r.now().Before(current.expires) {
+		return current.answer, current.err
+	}
+	next := r.resolve(ctx, key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, cached = r.entries[key]
+	stored := mergeEntry(current, cached, next)
+	r.entries[key] = stored
+	return stored.answer, stored.err
+}
+
+// merge keeps the last good answer when a refresh could not reach any
+// nameserver, so a resolver outage does not remove every upstream target.
+func mergeEntry(previous entry, cached bool, next entry) entry {
+	var unavailable *unavailableError
+	if cached && !previous.pending && previous.err == nil && errors.As(next.err, &unavailable) {
+		previous.expires = next.expires
+		return previous
+	}
+	next.refs = previous.refs
+	return next
+}
+
+// Lease pins the names one route generation reads. Its lookups only read the
+// cache: a name it has not seen yet is enrolled and reports ErrPending until
+// the refresher resolves it, and an expired answer is served until the
+// refresher replaces it. Stop releases every name the lease read.
+type Lease struct {
+	resolver *Resolver
+
+	mu      sync.Mutex
+	keys    map[question]struct{}
+	stopped bool
+}
+
+// Lease returns an empty lease on r.
+func (r *Resolver) Lease() *Lease {
+	return &Lease{resolver: r, keys: make(map[question]struct{})}
+}
+
+// LookupIP returns the cached addresses of host. IP literals and hosts
+// entries are returned without touching the cache.
+func (l *Lease) LookupIP(host string) ([]netip.Addr, error) {
+	host = strings.Trim(strings.TrimSpace(host), "[]")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if addrs, ok := l.resolver.hosts[canonicalName(host)]; ok {
+		return slices.Clone(addrs), nil
+	}
+	key := question{name: canonicalName(host), kind: lookupIP}
+	l.pin(key)
+	r := l.resolver
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := r.entries[key]
+	if current.pending {
+		return nil, fmt.Errorf("resolve %q: %w", strings.TrimSuffix(key.name, "."), ErrPending)
+	}
+	if current.err != nil {
+		return nil, current.err
+	}
+	return slices.Clone(current.answer.addrs), nil
+}
+
+// Stop releases the lease. It is safe to call more than once and on a nil
+// lease.
+func (l *Lease) Stop() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	keys := l.keys
+	l.keys = nil
+	l.stopped = true
+	l.mu.Unlock()
+	r := l.resolver
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range keys {
+		current, ok := r.entries[key]
+		if !ok {
+			continue
+		}
+		current.refs--
+		if current.refs <= 0 {
+			delete(r.entries, key)
+			continue
+		}
+		r.entries[key] = current
+	}
+}
+
+// pin holds key for the lease, enrolling it for the refresher when no entry
+// exists yet.
+func (l *Lease) pin(key question) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.keys[key]; ok || l.stopped {
+		return
+	}
+	l.keys[key] = struct{}{}
+	r := l.resolver
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, cached := r.entries[key]
+	if !cached {
+		current.pending = true
+	}
+	current.refs++
+	r.entries[key] = current
+}
+
+// Start launches the refresher. onChange runs after a refresh pass changes at
+// least one cached answer.
+func (r *Resolver) Start(ctx context.Context, onChange func()) {
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if r.Refresh(ctx) && onChange != nil {
+					onChange()
+				}
+			}
+		}
+	}()
+}
+
+// Refresh drops the names no lease holds, re-resolves every expired or
+// pending leased name and reports whether any answer changed. A pending name
+// that fails to resolve is not a change: its routes already dial it by domain.
+func (r *Resolver) Refresh(ctx context.Context) bool {
+	now := r.now()
+	r.mu.Lock()
+	var expired []question
+	for key, current := range r.entries {
+		switch {
+		case current.refs <= 0:
+			delete(r.entries, key)
+		case current.pending || !now.Before(current.expires):
+			expired = append(expired, key)
+		}
+	}
+	r.mu.Unlock()
+
+	changed := false
+	for _, key := range expired {
+		if ctx.Err() != nil {
+			return changed
+		}
+		next := r.resolve(ctx, key)
+		r.mu.Lock()
+		previous, cached := r.entries[key]
+		if !cached {
+			// The last lease stopped while the name was being resolved.
+			r.mu.Unlock()
+			continue
+		}
+		merged := mergeEntry(previous, cached, next)
+		r.entries[key] = merged
+		r.mu.Unlock()
+		if previous.pending {
+			if merged.err == nil {
+				changed = true
+				logger.Infof("dns resolver: %s resolves to %s", key.name, merged.describe())
+			}
+			continue
+		}
+		if (previous.err == nil) != (merged.err == nil) || !previous.answer.equal(merged.answer) {
+			changed = true
+			logger.Infof("dns resolver: %s now resolves to %s", key.name, merged.describe())
+		}
+	}
+	return changed
+}
+
+// Close stops the refresher. It is safe to call more than once.
+func (r *Resolver) Close() {
+	if r == nil {
+		return
+	}
+	r.lifecycleMu.Lock()
+	cancel := r.cancel
+	r.lifecycleMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	r.done.Wait()
+}
+
+func (r *Resolver) resolve(ctx context.Context, key question) entry {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var err error
+	negativeTTL := time.Duration(0)
+	for _, name := range r.searchNames(key.name) {
+		var result answer
+		var ttl time.Duration
+		result, ttl, err = r.query(ctx, name, key.kind)
+		if err == nil {
+			if r.valid > 0 {
+				ttl = r.valid
+			}
+			return entry{answer: result, expires: r.now().Add(max(ttl, minimumTTL))}
+		}
+		var notFound *notFoundError
+		if !errors.As(err, &notFound) {
+			break
+		}
+		if negativeTTL == 0 || notFound.ttl < negativeTTL {
+			negativeTTL = notFound.ttl
+		}
+	}
+	var notFound *notFoundError
+	if errors.As(err, &notFound) {
+		return entry{
+			err:     fmt.Errorf("resolve %q: %w", strings.TrimSuffix(key.name, "."), ErrNotFound),
+			expires: r.now().Add(max(negativeTTL, minimumTTL)),
+		}
+	}
+	return entry{
+		err:     fmt.Errorf("resolve %q: %w", strings.TrimSuffix(key.name, "."), err),
+		expires: r.now().Add(failureTTL),
+	}
+}
+
+// searchNames qualifies a relative name with the search domains: names with
+// at least ndots dots are tried as-is first, others last.
+func (r *Resolver) searchNames(name string) []string {
+	if len(r.search) == 0 {
+		return []string{name}
+	}
+	relative := strings.TrimSuffix(name, ".")
+	qualified := make([]string, 0, len(r.search)+1)
+	for _, domain := range r.search {
+		qualified = append(qualified, relative+"."+domain+".")
+	}
+	if strings.Count(relative, ".") >= r.ndots {
+		return append([]string{name}, qualified...)
+	}
+	return append(qualified, name)
+}
+
+func (r *Resolver) query(ctx context.Context, name string, kind lookupKind) (answer, time.Duration, error) {
+	if kind == lookupSRV {
+		records, ttl, err := r.exchangeAll(ctx, name, typeSRV)
+		if err != nil {
+			return answer{}, 0, err
+		}
+		return answer{srvs: sortedSRVs(records.srvs)}, ttl, nil
+	}
+	records, ttl, err := r.exchangeAll(ctx, name, typeA)
+	if err != nil {
+		var notFound *notFoundError
+		if !r.ipv6 || !errors.As(err, &notFound) {
+			return answer{}, 0, err
+		}
+	}
+	if r.ipv6 {
+		records6, ttl6, err6 := r.exchangeAll(ctx, name, typeAAAA)
+		switch {
+		case err6 == nil && err == nil:
+			records.addrs = append(records.addrs, records6.addrs...)
+			ttl = min(ttl, ttl6)
+		case err6 == nil:
+			records, ttl, err = records6, ttl6, nil
+		case err == nil:
+		case errors.As(err6, new(*notFoundError)):
+			return answer{}, 0, err
+		default:
+			return answer{}, 0, err6
+		}
+	}
+	return answer{addrs: sortedAddrs(records.addrs)}, ttl, nil
+}
+
+func (e entry) describe() string {
+	switch {
+	case e.err != nil:
+		return e.err.Error()
+	case len(e.answer.srvs) > 0:
+		targets := make([]string, 0, len(e.answer.srvs))
+		for _, record := range e.answer.srvs {
+			targets = append(targets, net.JoinHostPort(record.Target, fmt.Sprint(record.Port)))
+		}
+		return strings.Join(targets, ",")
+	default:
+		addrs := make([]string, 0, len(e.answer.addrs))
+		for _, addr := range e.answer.addrs {
+			addrs = append(addrs, addr.String())
+		}
+		return strings.Join(addrs, ",")
+	}
+}
+
+func canonicalName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+func sortedAddrs(addrs []netip.Addr) []netip.Addr {
+	result := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.Unmap())
+	}
+	slices.SortFunc(result, func(a, b netip.Addr) int { return a.Compare(b) })
+	return slices.Compact(result)
+}
+
+func sortedSRVs(records []SRV) []SRV {
+	result := slices.Clone(records)
+	for index := range result {
+		result[index].Target = strings.TrimSuffix(strings.ToLower(result[index].Target), ".")
+	}
+	slices.SortFunc(result, func(a, b SRV) int {
+		switch {
+		case a.Priority != b.Priority:
+			return int(a.Priority) - int(b.Priority)
+		case a.Weight != b.Weight:
+			return int(b.Weight) - int(a.Weight)
+		case a.Target != b.Target:
+			return strings.Compare(a.Target, b.Target)
+		default:
+			return int(a.Port) - int(b.Port)
+		}
+	})
+	return slices.Compact(result)
+}
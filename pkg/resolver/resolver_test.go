@@ -0,0 +1,541 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testNameserver answers UDP and TCP queries from an in-memory zone.
+type testNameserver struct {
+	addr string
+
+	mu       sync.Mutex
+	records  map[string][]dnsmessage.Resource
+	rcode    dnsmessage.RCode
+	truncate bool
+	queries  []string
+}
+
+func startTestNameserver(t *testing.T) *testNameserver {
+	t.Helper()
+	server := &testNameserver{records: make(map[string][]dnsmessage.Resource)}
+	var packetConn net.PacketConn
+	var listener net.Listener
+	for attempt := 0; ; attempt++ {
+		var err error
+		packetConn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen udp: %v", err)
+		}
+		listener, err = net.Listen("tcp", packetConn.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		_ = packetConn.Close()
+		if attempt == 10 {
+			t.Fatalf("listen tcp: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		_ = packetConn.Close()
+		_ = listener.Close()
+	})
+	server.addr = packetConn.LocalAddr().String()
+
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, peer, err := packetConn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if response := server.respond(buffer[:n], "udp"); response != nil {
+				_, _ = packetConn.WriteTo(response, peer)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				response := server.respond(query, "tcp")
+				framed := binary.BigEndian.AppendUint16(nil, uint16(len(response)))
+				_, _ = conn.Write(append(framed, response...))
+			}()
+		}
+	}()
+	return server
+}
+
+func (s *testNameserver) set(name string, records ...dnsmessage.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = records
+}
+
+func (s *testNameserver) setRCode(rcode dnsmessage.RCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcode = rcode
+}
+
+func (s *testNameserver) queryLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...)
+}
+
+func (s *testNameserver) respond(raw []byte, network string) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(raw); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+	question := query.Questions[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, network+" "+question.Type.String()+" "+question.Name.String())
+	response := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, RCode: s.rcode},
+		Questions: query.Questions,
+	}
+	if s.rcode == dnsmessage.RCodeSuccess {
+		records, ok := s.records[question.Name.String()]
+		if !ok {
+			response.Header.RCode = dnsmessage.RCodeNameError
+		}
+		for _, record := range records {
+			if record.Header.Type == question.Type {
+				response.Answers = append(response.Answers, record)
+			}
+		}
+		if len(response.Answers) == 0 {
+			response.Authorities = []dnsmessage.Resource{soaRecord(question.Name.String(), 10)}
+		}
+		if s.truncate && network == "udp" {
+			response.Header.Truncated = true
+			response.Answers = nil
+		}
+	}
+	packed, err := response.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+func recordHeader(name string, qtype dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: mustName(name), Type: qtype, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func aRecord(name, ip string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(name, dnsmessage.TypeA, ttl),
+		Body:   &dnsmessage.AResource{A: netip.MustParseAddr(ip).As4()},
+	}
+}
+
+func aaaaRecord(name, ip string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(name, dnsmessage.TypeAAAA, ttl),
+		Body:   &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr(ip).As16()},
+	}
+}
+
+func srvRecord(name, target string, port, priority, weight uint16) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(name, dnsmessage.TypeSRV, 60),
+		Body:   &dnsmessage.SRVResource{Target: mustName(target), Port: port, Priority: priority, Weight: weight},
+	}
+}
+
+func soaRecord(name string, minimum uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: recordHeader(name, dnsmessage.TypeSOA, 300),
+		Body: &dnsmessage.SOAResource{
+			NS:     mustName("ns.example.com."),
+			MBox:   mustName("admin.example.com."),
+			MinTTL: minimum,
+		},
+	}
+}
+
+func mustName(name string) dnsmessage.Name {
+	parsed, err := dnsmessage.NewName(name)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestResolver(t *testing.T, options Options) (*Resolver, *testClock) {
+	t.Helper()
+	resolver, err := New(options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	resolver.now = clock.Now
+	return resolver, clock
+}
+
+func addrStrings(addrs []netip.Addr) []string {
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.String())
+	}
+	return result
+}
+
+func TestLookupIPCachesAnswersForTheirTTL(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set(
+		"api.example.com.",
+		aRecord("api.example.com.", "10.0.0.2", 30),
+		aRecord("api.example.com.", "10.0.0.1", 60),
+	)
+	resolver, clock := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+
+	for range 2 {
+		addrs, err := resolver.LookupIP(context.Background(), "API.example.com")
+		if err != nil {
+			t.Fatalf("LookupIP() error = %v", err)
+		}
+		if got := addrStrings(addrs); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+			t.Fatalf("LookupIP() = %v, want sorted A records", got)
+		}
+	}
+	if got := len(server.queryLog()); got != 1 {
+		t.Fatalf("nameserver saw %d queries, want a cached second lookup", got)
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, err := resolver.LookupIP(context.Background(), "api.example.com"); err != nil {
+		t.Fatalf("LookupIP() after expiry error = %v", err)
+	}
+	if got := len(server.queryLog()); got != 2 {
+		t.Fatalf("nameserver saw %d queries, want a re-query after the shortest TTL", got)
+	}
+}
+
+func TestLookupIPQueriesAAAAWhenIPv6IsEnabled(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set(
+		"dual.example.com.",
+		aRecord("dual.example.com.", "10.0.0.1", 60),
+		aaaaRecord("dual.example.com.", "2001:db8::1", 60),
+	)
+	server.set("v6.example.com.", aaaaRecord("v6.example.com.", "2001:db8::2", 60))
+
+	resolver, _ := newTestResolver(t, Options{Nameservers: []string{server.addr}, IPv6: true})
+	addrs, err := resolver.LookupIP(context.Background(), "dual.example.com")
+	if err != nil || !reflect.DeepEqual(addrStrings(addrs), []string{"10.0.0.1", "2001:db8::1"}) {
+		t.Fatalf("LookupIP(dual) = %v, %v, want A and AAAA records", addrs, err)
+	}
+	addrs, err = resolver.LookupIP(context.Background(), "v6.example.com")
+	if err != nil || !reflect.DeepEqual(addrStrings(addrs), []string{"2001:db8::2"}) {
+		t.Fatalf("LookupIP(v6) = %v, %v, want the AAAA record", addrs, err)
+	}
+
+	resolver, _ = newTestResolver(t, Options{Nameservers: []string{server.addr}})
+	if _, err := resolver.LookupIP(context.Background(), "v6.example.com"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LookupIP(v6) without IPv6 error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLookupIPCachesNegativeAnswersForTheSOAMinimum(t *testing.T) {
+	server := startTestNameserver(t)
+	resolver, clock := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+
+	for range 2 {
+		if _, err := resolver.LookupIP(context.Background(), "missing.example.com"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("LookupIP() error = %v, want ErrNotFound", err)
+		}
+	}
+	if got := len(server.queryLog()); got != 1 {
+		t.Fatalf("nameserver saw %d queries, want a cached negative answer", got)
+	}
+	clock.Advance(10 * time.Second)
+	server.set("missing.example.com.", aRecord("missing.example.com.", "10.0.0.9", 60))
+	addrs, err := resolver.LookupIP(context.Background(), "missing.example.com")
+	if err != nil || !reflect.DeepEqual(addrStrings(addrs), []string{"10.0.0.9"}) {
+		t.Fatalf("LookupIP() after negative TTL = %v, %v", addrs, err)
+	}
+}
+
+func TestValidOverridesRecordTTL(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.1", 3600))
+	resolver, clock := newTestResolver(t, Options{Nameservers: []string{server.addr}, Valid: 5 * time.Second})
+
+	_, _ = resolver.LookupIP(context.Background(), "api.example.com")
+	clock.Advance(5 * time.Second)
+	_, _ = resolver.LookupIP(context.Background(), "api.example.com")
+	if got := len(server.queryLog()); got != 2 {
+		t.Fatalf("nameserver saw %d queries, want dns_resolver_valid to expire the answer", got)
+	}
+}
+
+func TestRefreshReportsChangesAndKeepsLastGoodAnswerDuringOutage(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.1", 60))
+	resolver, clock := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+	lease := resolver.Lease()
+	t.Cleanup(lease.Stop)
+	if _, err := lease.LookupIP("api.example.com"); !errors.Is(err, ErrPending) {
+		t.Fatalf("Lease.LookupIP() before the seed error = %v, want ErrPending", err)
+	}
+	if len(server.queryLog()) != 0 {
+		t.Fatal("Lease.LookupIP() queried the nameserver")
+	}
+	if !resolver.Refresh(context.Background()) {
+		t.Fatal("Refresh() did not report the seeded answer")
+	}
+
+	if resolver.Refresh(context.Background()) {
+		t.Fatal("Refresh() reported a change before any answer expired")
+	}
+	clock.Advance(time.Minute)
+	if resolver.Refresh(context.Background()) {
+		t.Fatal("Refresh() reported a change for an identical answer")
+	}
+
+	server.set(
+		"api.example.com.",
+		aRecord("api.example.com.", "10.0.0.1", 60),
+		aRecord("api.example.com.", "10.0.0.3", 60),
+	)
+	clock.Advance(time.Minute)
+	if !resolver.Refresh(context.Background()) {
+		t.Fatal("Refresh() did not report a changed answer")
+	}
+	addrs, _ := lease.LookupIP("api.example.com")
+	if got := addrStrings(addrs); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.3"}) {
+		t.Fatalf("LookupIP() after refresh = %v", got)
+	}
+
+	server.setRCode(dnsmessage.RCodeServerFailure)
+	clock.Advance(time.Minute)
+	if resolver.Refresh(context.Background()) {
+		t.Fatal("Refresh() reported a change when the nameserver failed")
+	}
+	addrs, err := lease.LookupIP("api.example.com")
+	if err != nil || len(addrs) != 2 {
+		t.Fatalf("LookupIP() during outage = %v, %v, want the last good answer", addrs, err)
+	}
+}
+
+func TestRefreshDropsNamesNoLeaseHolds(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.1", 60))
+	server.set("old.example.com.", aRecord("old.example.com.", "10.0.0.2", 60))
+	resolver, clock := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+	if _, err := resolver.LookupIP(context.Background(), "old.example.com"); err != nil {
+		t.Fatalf("LookupIP() error = %v", err)
+	}
+	first, second := resolver.Lease(), resolver.Lease()
+	_, _ = first.LookupIP("api.example.com")
+	_, _ = second.LookupIP("api.example.com")
+	resolver.Refresh(context.Background())
+	first.Stop()
+	first.Stop()
+	if _, err := second.LookupIP("api.example.com"); err != nil {
+		t.Fatalf("LookupIP() through the remaining lease error = %v", err)
+	}
+	queries := len(server.queryLog())
+	clock.Advance(time.Minute)
+	resolver.Refresh(context.Background())
+	if got := server.queryLog()[queries:]; !reflect.DeepEqual(got, []string{"udp TypeA api.example.com."}) {
+		t.Fatalf("Refresh() queried %v, want only the leased name", got)
+	}
+
+	second.Stop()
+	queries = len(server.queryLog())
+	clock.Advance(time.Minute)
+	if resolver.Refresh(context.Background()) || len(server.queryLog()) != queries {
+		t.Fatal("Refresh() still resolved a name after its last lease stopped")
+	}
+}
+
+func TestStartNotifiesOnChangedAnswer(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.1", 1))
+	resolver, err := New(Options{Nameservers: []string{server.addr}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	lease := resolver.Lease()
+	t.Cleanup(lease.Stop)
+	_, _ = lease.LookupIP("api.example.com")
+	changed := make(chan struct{}, 1)
+	resolver.Start(t.Context(), func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	t.Cleanup(resolver.Close)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresher did not report the seeded answer")
+	}
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.2", 1))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresher did not report the changed answer")
+	}
+}
+
+func TestSearchDomainsQualifyRelativeNames(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("api.svc.cluster.local.", aRecord("api.svc.cluster.local.", "10.0.0.7", 60))
+	resolver, _ := newTestResolver(t, Options{
+		Nameservers: []string{server.addr},
+		Search:      []string{"ns.svc.cluster.local", "svc.cluster.local"},
+		Ndots:       5,
+	})
+
+	addrs, err := resolver.LookupIP(context.Background(), "api")
+	if err != nil || !reflect.DeepEqual(addrStrings(addrs), []string{"10.0.0.7"}) {
+		t.Fatalf("LookupIP(api) = %v, %v, want the search-qualified answer", addrs, err)
+	}
+	want := []string{"udp TypeA api.ns.svc.cluster.local.", "udp TypeA api.svc.cluster.local."}
+	if got := server.queryLog(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries = %v, want %v", got, want)
+	}
+}
+
+func TestTruncatedAnswerRetriesOverTCP(t *testing.T) {
+	server := startTestNameserver(t)
+	server.truncate = true
+	server.set("big.example.com.", aRecord("big.example.com.", "10.0.0.1", 60))
+	resolver, _ := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+
+	addrs, err := resolver.LookupIP(context.Background(), "big.example.com")
+	if err != nil || !reflect.DeepEqual(addrStrings(addrs), []string{"10.0.0.1"}) {
+		t.Fatalf("LookupIP() = %v, %v, want the TCP answer", addrs, err)
+	}
+	if got := server.queryLog(); len(got) != 2 || !strings.HasPrefix(got[1], "tcp ") {
+		t.Fatalf("queries = %v, want a UDP query retried over TCP", got)
+	}
+}
+
+func TestExchangeFailsOverToNextNameserver(t *testing.T) {
+	unreachable, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	deadAddr := unreachable.LocalAddr().String()
+	_ = unreachable.Close()
+	server := startTestNameserver(t)
+	server.set("api.example.com.", aRecord("api.example.com.", "10.0.0.1", 60))
+
+	resolver, _ := newTestResolver(t, Options{Nameservers: []string{deadAddr, server.addr}, Timeout: time.Second})
+	addrs, err := resolver.LookupIP(context.Background(), "api.example.com")
+	if err != nil || len(addrs) != 1 {
+		t.Fatalf("LookupIP() = %v, %v, want the second nameserver's answer", addrs, err)
+	}
+}
+
+func TestLookupSRVOrdersRecords(t *testing.T) {
+	server := startTestNameserver(t)
+	server.set("_http._tcp.api.example.com.",
+		srvRecord("_http._tcp.api.example.com.", "b.example.com.", 8080, 10, 5),
+		srvRecord("_http._tcp.api.example.com.", "a.example.com.", 8080, 10, 50),
+		srvRecord("_http._tcp.api.example.com.", "backup.example.com.", 9090, 20, 1),
+	)
+	resolver, _ := newTestResolver(t, Options{Nameservers: []string{server.addr}})
+
+	records, err := resolver.LookupSRV(context.Background(), "_http._tcp.api.example.com")
+	if err != nil {
+		t.Fatalf("LookupSRV() error = %v", err)
+	}
+	want := []SRV{
+		{Target: "a.example.com", Port: 8080, Priority: 10, Weight: 50},
+		{Target: "b.example.com", Port: 8080, Priority: 10, Weight: 5},
+		{Target: "backup.example.com", Port: 9090, Priority: 20, Weight: 1},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("LookupSRV() = %+v, want %+v", records, want)
+	}
+}
+
+func TestLookupIPPrefersLiteralsAndHosts(t *testing.T) {
+	server := startTestNameserver(t)
+	resolver, _ := newTestResolver(t, Options{
+		Nameservers: []string{server.addr},
+		Hosts: map[string][]netip.Addr{
+			"LocalHost": {netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")},
+		},
+	})
+
+	if addrs, err := resolver.LookupIP(context.Background(), "[2001:db8::1]"); err != nil ||
+		!reflect.DeepEqual(addrStrings(addrs), []string{"2001:db8::1"}) {
+		t.Fatalf("LookupIP(literal) = %v, %v", addrs, err)
+	}
+	if addrs, err := resolver.LookupIP(context.Background(), "localhost"); err != nil ||
+		!reflect.DeepEqual(addrStrings(addrs), []string{"127.0.0.1"}) {
+		t.Fatalf("LookupIP(localhost) = %v, %v, want the IPv4 hosts entry", addrs, err)
+	}
+	if got := server.queryLog(); len(got) != 0 {
+		t.Fatalf("queries = %v, want literals and hosts answered locally", got)
+	}
+}
+
+func TestNewValidatesNameservers(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Fatal("New() accepted an empty nameserver list")
+	}
+	if _, err := New(Options{Nameservers: []string{"dns.example.com"}}); err == nil {
+		t.Fatal("New() accepted a hostname nameserver")
+	}
+	resolver, err := New(Options{Nameservers: []string{"1.1.1.1", "[2001:db8::53]:5353"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if want := []string{"1.1.1.1:53", "[2001:db8::53]:5353"}; !reflect.DeepEqual(resolver.servers, want) {
+		t.Fatalf("servers = %v, want %v", resolver.servers, want)
+	}
+}
@@ -0,0 +1,96 @@
+package resolver
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ResolvConfPath supplies nameservers and search domains when
+	// `dns_resolver` is unset.
+	ResolvConfPath = "/etc/resolv.conf"
+	// HostsPath supplies static answers that take precedence over DNS.
+	HostsPath = "/etc/hosts"
+)
+
+// ResolvConf is the subset of resolv.conf(5) the resolver honors.
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+	Ndots       int
+}
+
+// ReadResolvConf parses path. The last `search` or `domain` line wins, as in
+// the C library.
+func ReadResolvConf(path string) (ResolvConf, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ResolvConf{}, err
+	}
+	defer func() { _ = file.Close() }()
+
+	conf := ResolvConf{Ndots: 1}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if _, err := netip.ParseAddr(fields[1]); err == nil {
+				conf.Nameservers = append(conf.Nameservers, fields[1])
+			}
+		case "search":
+			conf.Search = append([]string(nil), fields[1:]...)
+		case "domain":
+			conf.Search = []string{fields[1]}
+		case "options":
+			for _, option := range fields[1:] {
+				if value, ok := strings.CutPrefix(option, "ndots:"); ok {
+					if ndots, err := strconv.Atoi(value); err == nil && ndots >= 0 {
+						conf.Ndots = min(ndots, 15)
+					}
+				}
+			}
+		}
+	}
+	return conf, scanner.Err()
+}
+
+// ReadHosts parses a hosts(5) file into lowercase names and their addresses.
+func ReadHosts(path string) (map[string][]netip.Addr, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	hosts := make(map[string][]netip.Addr)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) < 2 {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			hosts[name] = append(hosts[name], addr.WithZone(""))
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+func stripComment(line string) string {
+	if index := strings.IndexAny(line, "#;"); index >= 0 {
+		return line[:index]
+	}
+	return line
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	content := "# generated\n" +
+		"nameserver 10.96.0.10\n" +
+		"nameserver not-an-ip\n" +
+		"search default.svc.cluster.local svc.cluster.local\n" +
+		"options ndots:5 timeout:2\n" +
+		"nameserver 2001:db8::53 ; secondary\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write resolv.conf: %v", err)
+	}
+
+	conf, err := ReadResolvConf(path)
+	if err != nil {
+		t.Fatalf("ReadResolvConf() error = %v", err)
+	}
+	want := ResolvConf{
+		Nameservers: []string{"10.96.0.10", "2001:db8::53"},
+		Search:      []string{"default.svc.cluster.local", "svc.cluster.local"},
+		Ndots:       5,
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Fatalf("ReadResolvConf() = %+v, want %+v", conf, want)
+	}
+}
+
+func TestReadHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := "127.0.0.1 localhost Gateway\n::1 localhost # loopback\nbogus entry\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+
+	hosts, err := ReadHosts(path)
+	if err != nil {
+		t.Fatalf("ReadHosts() error = %v", err)
+	}
+	want := map[string][]netip.Addr{
+		"localhost": {netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")},
+		"gateway":   {netip.MustParseAddr("127.0.0.1")},
+	}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("ReadHosts() = %v, want %v", hosts, want)
+	}
+}
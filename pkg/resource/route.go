@@ -70,6 +70,8 @@ type Upstream struct {
 	UpstreamHost string         `json:"upstream_host,omitempty"`
 	Name         string         `json:"name,omitempty"`
 	Desc         string         `json:"desc,omitempty"`
+
+	KeepalivePool *KeepalivePool `json:"keepalive_pool,omitempty"`
 }
 
 func (s *Upstream) UnmarshalJSON(data []byte) error {
@@ -85,7 +87,7 @@ func (s *Upstream) UnmarshalJSON(data []byte) error {
 		s.Nodes = nodes
 	} else if !nodesPresent && (len(upstreamData["discovery_type"]) > 0 || len(upstreamData["service_name"]) > 0) {
 		// Discovery-only upstreams have no static nodes. Preserve the
-		// compatibility fields so route compilation can reject them explicitly.
+		// discovery fields so route compilation can resolve the node set.
 	} else {
 		/*
 			"nodes": {
@@ -134,6 +136,7 @@ func (s *Upstream) UnmarshalJSON(data []byte) error {
 		{name: "upstream_host", raw: upstreamData["upstream_host"], dest: &s.UpstreamHost},
 		{name: "name", raw: upstreamData["name"], dest: &s.Name},
 		{name: "desc", raw: upstreamData["desc"], dest: &s.Desc},
+		{name: "keepalive_pool", raw: upstreamData["keepalive_pool"], dest: &s.KeepalivePool},
 	} {
 		if len(field.raw) == 0 {
 			continue
@@ -174,6 +177,26 @@ func parseNodeAddress(address string) (string, int) {
 	return address, 0
 }
 
+// KeepalivePool is the APISIX upstream `keepalive_pool`: the idle connections
+// kept per upstream node, the seconds one may stay idle, and the requests one
+// connection serves before it is closed. Omitted fields take the APISIX
+// defaults of 320, 60 and 1000.
+type KeepalivePool struct {
+	Size        int     `json:"size"`
+	IdleTimeout float64 `json:"idle_timeout"`
+	Requests    int     `json:"requests"`
+}
+
+func (p *KeepalivePool) UnmarshalJSON(data []byte) error {
+	type keepalivePool KeepalivePool
+	pool := keepalivePool{Size: 320, IdleTimeout: 60, Requests: 1000}
+	if err := json.Unmarshal(data, &pool); err != nil {
+		return err
+	}
+	*p = KeepalivePool(pool)
+	return nil
+}
+
 type Timeout struct {
 	Connect int `json:"connect,omitempty"`
 	Send    int `json:"send,omitempty"`
@@ -191,10 +214,14 @@ type UpstreamTLS struct {
 }
 
 type Node struct {
-	Host      string `json:"host,omitempty"`
-	Port      int    `json:"port,omitempty"`
-	Weight    int    `json:"weight,omitempty"`
-	Priority  int    `json:"priority,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	// Domain is the domain name a resolved node was expanded from. It is
+	// never serialized; the proxy uses it as the node host for `pass_host:
+	// node` and as the TLS server name.
+	Domain    string `json:"-"`
 	weightSet bool
 }
 
@@ -335,6 +362,7 @@ type StreamRoute struct {
 	ServerAddr string                  `json:"server_addr,omitempty"`
 	ServerPort int                     `json:"server_port,omitempty"`
 	RemoteAddr string                  `json:"remote_addr,omitempty"`
+	Sni        string                  `json:"sni,omitempty"`
 	ServiceID  string                  `json:"service_id,omitempty"`
 	Plugins    map[string]PluginConfig `json:"plugins,omitempty"`
 	UpstreamID string                  `json:"upstream_id,omitempty"`
@@ -523,6 +523,28 @@ func TestUpstreamUnmarshalCompleteDocument(t *testing.T) {
 	}
 }
 
+func TestUpstreamUnmarshalDefaultsOmittedKeepalivePoolFields(t *testing.T) {
+	var upstream Upstream
+	if err := json.Unmarshal([]byte(`{
+		"nodes": {"127.0.0.1:8080": 1},
+		"keepalive_pool": {"size": 16, "idle_timeout": 0.5}
+	}`), &upstream); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	want := &KeepalivePool{Size: 16, IdleTimeout: 0.5, Requests: 1000}
+	if !reflect.DeepEqual(upstream.KeepalivePool, want) {
+		t.Fatalf("keepalive_pool = %+v, want %+v", upstream.KeepalivePool, want)
+	}
+
+	var unpooled Upstream
+	if err := json.Unmarshal([]byte(`{"nodes": {"127.0.0.1:8080": 1}}`), &unpooled); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if unpooled.KeepalivePool != nil {
+		t.Fatalf("keepalive_pool = %+v, want nil when omitted", unpooled.KeepalivePool)
+	}
+}
+
 func TestUpstreamUnmarshalPreservesDiscoveryFieldsAndRoundTrip(t *testing.T) {
 	const config = `{
 		"discovery_type": "dns",
@@ -646,6 +668,11 @@ func TestUpstreamUnmarshalRejectsMalformedFields(t *testing.T) {
 		{name: "retries", json: `{"nodes": {}, "retries": "many"}`, frag: "unmarshal field `retries` fail"},
 		{name: "checks", json: `{"nodes": {}, "checks": 123}`, frag: "unmarshal field `checks` fail"},
 		{name: "name", json: `{"nodes": {}, "name": 5}`, frag: "unmarshal field `name` fail"},
+		{
+			name: "keepalive_pool",
+			json: `{"nodes": {}, "keepalive_pool": {"size": "many"}}`,
+			frag: "unmarshal field `keepalive_pool` fail",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
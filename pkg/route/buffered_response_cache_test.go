@@ -26,6 +26,7 @@ type routeBufferedPlugin struct {
 	events       *[]string
 	hitState     base.CachedResponseState
 	publishHit   bool
+	fallback     bool
 }
 
 func (p *routeBufferedPlugin) Init() error               { return nil }
@@ -86,6 +87,14 @@ func (p *routeBufferedPlugin) RunFinalResponseStore(_ *http.Request, _ base.Resp
 	return p.storeErr
 }
 
+func (p *routeBufferedPlugin) RunErrorResponseFallback(r *http.Request, status int) bool {
+	if !p.fallback || status < http.StatusInternalServerError {
+		return false
+	}
+	base.CacheHitResponseHolderFromRequest(r).Publish(p.hitState)
+	return true
+}
+
 func (p *routeBufferedPlugin) AppliesToResponseSource(source apisixctx.ResponseSource) bool {
 	return source == apisixctx.ResponseSourceUpstream ||
 		source == apisixctx.ResponseSourceAPISIX ||
@@ -292,6 +301,38 @@ func TestBufferedRouteCacheMissStoresAfterTransformsPerInstance(t *testing.T) {
 	}
 }
 
+func TestBufferedRouteErrorFallbackCommitsAsCacheHitWithoutTransforms(t *testing.T) {
+	cache := &routeBufferedPlugin{
+		name:     "proxy-cache",
+		fallback: true,
+		hitState: base.CachedResponseState{
+			Status: http.StatusOK,
+			Header: http.Header{"X-Cache": {"stale"}},
+			Body:   []byte("cached"),
+		},
+	}
+	transform := &routeBufferedPlugin{name: "grpc-transcode", bodySuffix: "-transformed"}
+	response := serveBufferedRoute(
+		t,
+		[]plugin.Binding{
+			checkedRouteBinding(t, "grpc-transcode", transform, plugin.ScopeRoute),
+			checkedRouteBinding(t, "proxy-cache", cache, plugin.ScopeRoute),
+		},
+		nil,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apisixctx.SetRequestResponseSource(r, apisixctx.ResponseSourceUpstream)
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("origin"))
+		}),
+	)
+	if response.Code != http.StatusOK || response.Body.String() != "cached" {
+		t.Fatalf("fallback response = %d/%q, want 200/cached", response.Code, response.Body.String())
+	}
+	if transform.bodyCalls != 0 || cache.storeCalls != 0 {
+		t.Fatalf("fallback transforms/stores = %d/%d, want 0/0", transform.bodyCalls, cache.storeCalls)
+	}
+}
+
 func TestBufferedRouteFinalStoreErrorCommitsUnchangedOnce(t *testing.T) {
 	cache := &routeBufferedPlugin{name: "proxy-cache", storeErr: errors.New("store failed")}
 	response := serveBufferedRoute(
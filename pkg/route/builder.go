@@ -26,6 +26,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/wklken/apisix-go/pkg/apisix/ctx"
 	appconfig "github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/discovery"
+	"github.com/wklken/apisix-go/pkg/extplugin"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/plugin"
@@ -41,9 +43,11 @@ import (
 	"github.com/wklken/apisix-go/pkg/plugin/public_api"
 	"github.com/wklken/apisix-go/pkg/plugin/traffic_split"
 	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/resolver"
 	"github.com/wklken/apisix-go/pkg/resource"
 	"github.com/wklken/apisix-go/pkg/store"
 	"github.com/wklken/apisix-go/pkg/util"
+	"github.com/wklken/apisix-go/pkg/wasm"
 )
 
 const (
@@ -153,14 +157,25 @@ func effectiveRouteURI(converted string) string {
 type routeRegistrar struct {
 	mux                   *chi.Mux
 	dispatchers           map[string]*wildcardDispatcher
+	direct                map[string][]directRoute
 	nextRegistrationIndex uint64
 }
 
+// directRoute records a parameterized route registered straight on chi, so it
+// can move into a dispatcher if a later route on the same pattern needs one.
+type directRoute struct {
+	methods           []string
+	uri               string
+	handler           http.Handler
+	registrationIndex uint64
+}
+
 func newRouteRegistrar(mux *chi.Mux) *routeRegistrar {
 	registerPurgeMethod()
 	return &routeRegistrar{
 		mux:         mux,
 		dispatchers: make(map[string]*wildcardDispatcher),
+		direct:      make(map[string][]directRoute),
 	}
 }
 
@@ -169,6 +184,19 @@ func (r *routeRegistrar) registerRouteWithHosts(
 	uri string,
 	hosts []string,
 	handler http.Handler,
+) error {
+	return r.registerRouteWithMatcher(methods, uri, hosts, nil, handler)
+}
+
+// registerRouteWithMatcher registers a route whose compiled `remote_addrs` and
+// `vars` must also match. Such routes always use a dispatcher, because chi
+// alone cannot fall through to a lower-priority route on the same pattern.
+func (r *routeRegistrar) registerRouteWithMatcher(
+	methods []string,
+	uri string,
+	hosts []string,
+	matcher *routeMatcher,
+	handler http.Handler,
 ) error {
 	converted, err := convertURI(uri)
 	if err != nil {
@@ -176,10 +204,17 @@ func (r *routeRegistrar) registerRouteWithHosts(
 	}
 	registrationIndex := r.nextRegistrationIndex
 	r.nextRegistrationIndex++
-	if strings.ContainsRune(uri, '*') || len(hosts) > 0 || !strings.ContainsRune(uri, ':') {
-		r.registerWildcardRoute(methods, converted, uri, hosts, handler, registrationIndex)
+	if strings.ContainsRune(uri, '*') || len(hosts) > 0 || !strings.ContainsRune(uri, ':') ||
+		matcher != nil || r.dispatchers[converted] != nil {
+		r.registerWildcardRoute(methods, converted, uri, hosts, matcher, handler, registrationIndex)
 		return nil
 	}
+	r.direct[converted] = append(r.direct[converted], directRoute{
+		methods:           methods,
+		uri:               uri,
+		handler:           handler,
+		registrationIndex: registrationIndex,
+	})
 	if len(methods) == 0 {
 		r.mux.Handle(converted, handler)
 		return nil
@@ -196,6 +231,7 @@ type wildcardRoute struct {
 	pattern           string
 	embedded          bool
 	hosts             []string
+	matcher           *routeMatcher
 	handler           http.Handler
 	registrationIndex uint64
 }
@@ -206,14 +242,14 @@ type wildcardDispatcher struct {
 	embedded    map[string]*routeDecisionIndex
 }
 
-type routeCandidate struct {
-	route wildcardRoute
-	valid bool
-}
+// routeCandidates holds the routes sharing one pattern, host, and method,
+// highest registration index (and therefore highest priority) first. Only
+// routes with a matcher can fall through to the next candidate.
+type routeCandidates []wildcardRoute
 
 type routeHostDecision struct {
-	exact    map[string]routeCandidate
-	wildcard routeCandidate
+	exact    map[string]routeCandidates
+	wildcard routeCandidates
 	allowed  []string
 }
 
@@ -260,20 +296,15 @@ func (d *routeDecisionIndex) add(route wildcardRoute) {
 }
 
 func (d *routeHostDecision) add(route wildcardRoute) {
-	candidate := routeCandidate{route: route, valid: true}
 	if route.method == "*" {
-		if !d.wildcard.valid || d.wildcard.route.registrationIndex < route.registrationIndex {
-			d.wildcard = candidate
-		}
+		d.wildcard = d.wildcard.add(route)
 		return
 	}
 	if d.exact == nil {
-		d.exact = make(map[string]routeCandidate)
+		d.exact = make(map[string]routeCandidates)
 	}
 	current, ok := d.exact[route.method]
-	if !ok || current.route.registrationIndex < route.registrationIndex {
-		d.exact[route.method] = candidate
-	}
+	d.exact[route.method] = current.add(route)
 	if !ok {
 		index, _ := slices.BinarySearch(d.allowed, route.method)
 		d.allowed = append(d.allowed, "")
@@ -282,29 +313,50 @@ func (d *routeHostDecision) add(route wildcardRoute) {
 	}
 }
 
+func (c routeCandidates) add(route wildcardRoute) routeCandidates {
+	index, _ := slices.BinarySearchFunc(c, route.registrationIndex, func(candidate wildcardRoute, target uint64) int {
+		return cmp.Compare(target, candidate.registrationIndex)
+	})
+	return slices.Insert(c, index, route)
+}
+
+// match returns the first candidate whose matcher accepts the request. A
+// route without a matcher shadows every lower-priority candidate.
+func (c routeCandidates) match(match *routeMatchRequest) (wildcardRoute, bool) {
+	for _, candidate := range c {
+		if candidate.matcher == nil || match.matches(candidate.matcher) {
+			return candidate, true
+		}
+	}
+	return wildcardRoute{}, false
+}
+
+// lookup returns the matching route for one host rank and method slot. The
+// last result reports that the slot had routes but none accepted the request.
 func (d *routeDecisionIndex) lookup(
 	host string,
 	wildcardHost string,
 	hostRank int,
 	methodIndex int,
-	method string,
-) (routeCandidate, bool, bool) {
+	match *routeMatchRequest,
+) (wildcardRoute, bool, bool, bool) {
 	decision := d.hostDecision(host, wildcardHost, hostRank)
 	if decision == nil {
-		return routeCandidate{}, false, false
+		return wildcardRoute{}, false, false, false
 	}
 	if !decision.hasRoutes() {
-		return routeCandidate{}, false, false
+		return wildcardRoute{}, false, false, false
 	}
-	if methodIndex == 1 {
-		return decision.wildcard, true, decision.wildcard.valid
+	candidates := decision.wildcard
+	if methodIndex == 0 {
+		candidates = decision.exact[match.request.Method]
 	}
-	candidate, ok := decision.exact[method]
-	return candidate, true, ok
+	route, ok := candidates.match(match)
+	return route, true, ok, !ok && len(candidates) > 0
 }
 
 func (d *routeHostDecision) hasRoutes() bool {
-	return d.wildcard.valid || len(d.exact) > 0
+	return len(d.wildcard) > 0 || len(d.exact) > 0
 }
 
 func (d *routeDecisionIndex) hostDecision(
@@ -393,6 +445,7 @@ func (r *routeRegistrar) registerWildcardRoute(
 	converted string,
 	pattern string,
 	hosts []string,
+	matcher *routeMatcher,
 	handler http.Handler,
 	registrationIndex uint64,
 ) {
@@ -404,15 +457,33 @@ func (r *routeRegistrar) registerWildcardRoute(
 		}
 		r.mux.Handle(converted, dispatcher)
 		r.dispatchers[converted] = dispatcher
+		// The dispatcher replaces the chi handlers of earlier direct routes
+		// on this pattern, so they keep matching as dispatcher candidates.
+		for _, direct := range r.direct[converted] {
+			dispatcher.addRoute(direct.methods, converted, direct.uri, nil, nil, direct.handler, direct.registrationIndex)
+		}
+		delete(r.direct, converted)
 	}
+	dispatcher.addRoute(methods, converted, pattern, hosts, matcher, handler, registrationIndex)
+}
 
+func (d *wildcardDispatcher) addRoute(
+	methods []string,
+	converted string,
+	pattern string,
+	hosts []string,
+	matcher *routeMatcher,
+	handler http.Handler,
+	registrationIndex uint64,
+) {
 	embedded := strings.Contains(pattern, "/*/")
 	if len(methods) == 0 {
-		dispatcher.add(wildcardRoute{
+		d.add(wildcardRoute{
 			method:            "*",
 			pattern:           pattern,
 			embedded:          embedded,
 			hosts:             hosts,
+			matcher:           matcher,
 			handler:           handler,
 			registrationIndex: registrationIndex,
 		})
@@ -420,11 +491,12 @@ func (r *routeRegistrar) registerWildcardRoute(
 	}
 	for _, method := range methods {
 		logger.Debugf("add route: %s %s", method, converted)
-		dispatcher.add(wildcardRoute{
+		d.add(wildcardRoute{
 			method:            strings.ToUpper(method),
 			pattern:           pattern,
 			embedded:          embedded,
 			hosts:             hosts,
+			matcher:           matcher,
 			handler:           handler,
 			registrationIndex: registrationIndex,
 		})
@@ -456,8 +528,10 @@ func (d *wildcardDispatcher) ServeHTTP(writer http.ResponseWriter, request *http
 	wildcardHost := wildcardHostKey(host)
 	nonEmbeddedPathMatched := d.nonEmbedded != nil &&
 		matchesRoutePath(d.nonEmbedded.pattern, request.URL.Path)
+	match := &routeMatchRequest{request: request}
 	pathMatched := false
 	hostMatched := false
+	matchRejected := false
 	for embeddedIndex := range 2 {
 		for _, hostRank := range []int{2, 1, 0} {
 			for methodIndex := range 2 {
@@ -465,8 +539,8 @@ func (d *wildcardDispatcher) ServeHTTP(writer http.ResponseWriter, request *http
 					if len(d.embedded) == 0 {
 						continue
 					}
-					route, matched, matchedPath, matchedHost := d.matchEmbeddedRoute(
-						request,
+					route, matched, matchedPath, matchedHost, rejected := d.matchEmbeddedRoute(
+						match,
 						host,
 						wildcardHost,
 						hostRank,
@@ -474,14 +548,15 @@ func (d *wildcardDispatcher) ServeHTTP(writer http.ResponseWriter, request *http
 					)
 					pathMatched = pathMatched || matchedPath
 					hostMatched = hostMatched || matchedHost
+					matchRejected = matchRejected || rejected
 					if matched {
-						route.handler.ServeHTTP(writer, request)
+						route.handler.ServeHTTP(writer, match.request)
 						return
 					}
 					continue
 				}
-				route, matched, matchedPath, matchedHost := d.matchNonEmbeddedRoute(
-					request,
+				route, matched, matchedPath, matchedHost, rejected := d.matchNonEmbeddedRoute(
+					match,
 					host,
 					wildcardHost,
 					hostRank,
@@ -490,14 +565,17 @@ func (d *wildcardDispatcher) ServeHTTP(writer http.ResponseWriter, request *http
 				)
 				pathMatched = pathMatched || matchedPath
 				hostMatched = hostMatched || matchedHost
+				matchRejected = matchRejected || rejected
 				if matched {
-					route.handler.ServeHTTP(writer, request)
+					route.handler.ServeHTTP(writer, match.request)
 					return
 				}
 			}
 		}
 	}
-	if pathMatched && hostMatched {
+	// A method whose routes all rejected the request's address or vars is a
+	// miss, not a method mismatch.
+	if pathMatched && hostMatched && !matchRejected {
 		allowedMethods := d.allowedMethods(request)
 		if len(allowedMethods) > 0 {
 			writer.Header().Set("Allow", strings.Join(allowedMethods, ", "))
@@ -532,15 +610,15 @@ func (d *wildcardDispatcher) allowedMethods(request *http.Request) []string {
 }
 
 func (d *wildcardDispatcher) matchEmbeddedRoute(
-	request *http.Request,
+	match *routeMatchRequest,
 	host string,
 	wildcardHost string,
 	hostRank int,
 	methodIndex int,
-) (wildcardRoute, bool, bool, bool) {
-	requestPath := request.URL.Path
+) (wildcardRoute, bool, bool, bool, bool) {
+	requestPath := match.request.URL.Path
 	if len(requestPath) <= len(d.prefix) || !strings.HasPrefix(requestPath, d.prefix) {
-		return wildcardRoute{}, false, false, false
+		return wildcardRoute{}, false, false, false, false
 	}
 
 	bestIndex := uint64(0)
@@ -548,6 +626,7 @@ func (d *wildcardDispatcher) matchEmbeddedRoute(
 	var bestRoute wildcardRoute
 	pathMatched := false
 	hostMatched := false
+	matchRejected := false
 	for searchFrom := len(d.prefix); searchFrom < len(requestPath); {
 		relativeSlash := strings.IndexByte(requestPath[searchFrom:], '/')
 		if relativeSlash < 0 {
@@ -558,50 +637,51 @@ func (d *wildcardDispatcher) matchEmbeddedRoute(
 		decision := d.embedded[suffix]
 		if decision != nil && len(requestPath) > len(d.prefix)+len(suffix) {
 			pathMatched = true
-			candidate, matchedHost, ok := decision.lookup(
+			candidate, matchedHost, ok, rejected := decision.lookup(
 				host,
 				wildcardHost,
 				hostRank,
 				methodIndex,
-				request.Method,
+				match,
 			)
 			hostMatched = hostMatched || matchedHost
-			if ok && (!bestFound || candidate.route.registrationIndex > bestIndex) {
-				bestIndex = candidate.route.registrationIndex
+			matchRejected = matchRejected || rejected
+			if ok && (!bestFound || candidate.registrationIndex > bestIndex) {
+				bestIndex = candidate.registrationIndex
 				bestFound = true
-				bestRoute = candidate.route
+				bestRoute = candidate
 			}
 		}
 		searchFrom = suffixStart + 1
 	}
 	if !bestFound {
-		return wildcardRoute{}, false, pathMatched, hostMatched
+		return wildcardRoute{}, false, pathMatched, hostMatched, matchRejected
 	}
-	return bestRoute, true, pathMatched, hostMatched
+	return bestRoute, true, pathMatched, hostMatched, false
 }
 
 func (d *wildcardDispatcher) matchNonEmbeddedRoute(
-	request *http.Request,
+	match *routeMatchRequest,
 	host string,
 	wildcardHost string,
 	hostRank int,
 	methodIndex int,
 	pathMatched bool,
-) (wildcardRoute, bool, bool, bool) {
+) (wildcardRoute, bool, bool, bool, bool) {
 	if d.nonEmbedded == nil || !pathMatched {
-		return wildcardRoute{}, false, false, false
+		return wildcardRoute{}, false, false, false, false
 	}
-	candidate, matchedHost, ok := d.nonEmbedded.lookup(
+	candidate, matchedHost, ok, rejected := d.nonEmbedded.lookup(
 		host,
 		wildcardHost,
 		hostRank,
 		methodIndex,
-		request.Method,
+		match,
 	)
 	if !ok {
-		return wildcardRoute{}, false, true, matchedHost
+		return wildcardRoute{}, false, true, matchedHost, rejected
 	}
-	return candidate.route, true, true, matchedHost
+	return candidate, true, true, matchedHost, false
 }
 
 func matchesRoutePath(pattern string, requestPath string) bool {
@@ -688,9 +768,17 @@ func matchesWildcardRoute(pattern string, path string) bool {
 type Builder struct {
 	storage             *store.Store
 	serverAddr          string
+	config              *appconfig.Config
 	enabledPlugins      *plugin.EnabledSet
 	clusterRegistry     *pxy.ClusterRegistry
 	ownsClusterRegistry bool
+	discovery           *discovery.Registry
+	discoveryLeases     map[discoveryServiceKey]*discovery.ServiceLease
+	discoveryLeaseMu    sync.Mutex
+	resolver            *resolver.Resolver
+	resolverLease       *resolver.Lease
+	extPluginRunner     *extplugin.Runner
+	wasmModules         map[string]*wasm.Module
 	stoppers            []pluginStopper
 	stopperMu           sync.Mutex
 	consumerResolution  consumerResolutionCache
@@ -710,6 +798,11 @@ type Builder struct {
 	// Build; plugin schemas are constants, so repeated plugin instances never
 	// recompile the same schema.
 	compiledSchemas map[string]*util.CompiledSchema
+
+	// clusters records the clusters leased by the route being built.
+	clusters     clusterRecorder
+	generationMu sync.Mutex
+	generation   *Generation
 }
 
 type consumerResolutionTemplate struct {
@@ -724,6 +817,11 @@ type consumerResolutionCache struct {
 
 var errConsumerBindingInitializationPanicked = errors.New("consumer plugin initialization panicked")
 
+type discoveryServiceKey struct {
+	discoveryType string
+	service       string
+}
+
 type servicePluginKey struct {
 	serviceID string
 	name      string
@@ -761,6 +859,56 @@ func NewBuilderWithClusterRegistry(storage *store.Store, serverAddr string, regi
 	return builder
 }
 
+// WithDiscovery resolves `discovery_type` upstreams through registry. The
+// server owns the registry; without one, discovery-backed upstreams fail to
+// compile.
+func (b *Builder) WithDiscovery(registry *discovery.Registry) *Builder {
+	b.discovery = registry
+	return b
+}
+
+// WithResolver expands upstream domain nodes into per-address nodes through
+// dns. The server owns the resolver; without one, domain nodes are resolved
+// by the transport at dial time. The builder leases every domain it reads
+// until Stop.
+func (b *Builder) WithResolver(dns *resolver.Resolver) *Builder {
+	b.resolver = dns
+	if dns != nil {
+		b.resolverLease = dns.Lease()
+	}
+	return b
+}
+
+// WithExtPluginRunner hands runner to the ext-plugin-* plugins. The server
+// owns the runner; without one, those plugins fail their calls with 503 or
+// skip them under allow_degradation.
+func (b *Builder) WithExtPluginRunner(runner *extplugin.Runner) *Builder {
+	b.extPluginRunner = runner
+	return b
+}
+
+// WithWasmModules hands each wasm.plugins plugin the module loaded under its
+// name. The server owns the modules; a plugin without one answers 503.
+func (b *Builder) WithWasmModules(modules map[string]*wasm.Module) *Builder {
+	b.wasmModules = modules
+	return b
+}
+
+// WithConfig builds routes against cfg instead of the published
+// configuration. A config reload passes its candidate, which it publishes only
+// after the routes built from it are installed.
+func (b *Builder) WithConfig(cfg *appconfig.Config) *Builder {
+	b.config = cfg
+	return b
+}
+
+func (b *Builder) appConfig() *appconfig.Config {
+	if b.config != nil {
+		return b.config
+	}
+	return appconfig.Global()
+}
+
 func (b *Builder) Stop() {
 	b.stopOnce.Do(func() {
 		b.stopperMu.Lock()
@@ -769,6 +917,16 @@ func (b *Builder) Stop() {
 		for _, stopper := range stoppers {
 			stopper.Stop()
 		}
+		b.discoveryLeaseMu.Lock()
+		leases := b.discoveryLeases
+		b.discoveryLeases = nil
+		b.discoveryLeaseMu.Unlock()
+		for _, lease := range leases {
+			lease.Stop()
+		}
+		if b.resolverLease != nil {
+			b.resolverLease.Stop()
+		}
 		if b.ownsClusterRegistry && b.clusterRegistry != nil {
 			b.clusterRegistry.Close()
 		}
@@ -832,18 +990,18 @@ func (b *Builder) BuildWithRouteQuarantine() (*chi.Mux, error) {
 
 func (b *Builder) buildRoutes(quarantineInvalidRoutes bool) (*chi.Mux, error) {
 	publicAPIRegistry := public_api.NewRegistry()
-	if err := registerPrometheusPublicEndpoint(publicAPIRegistry); err != nil {
+	if err := registerPrometheusPublicEndpoint(b.appConfig(), publicAPIRegistry); err != nil {
 		return nil, fmt.Errorf("register prometheus public endpoint: %w", err)
 	}
 
 	var configuredPlugins []string
-	if appconfig.GlobalConfig != nil {
-		configuredPlugins = appconfig.GlobalConfig.Plugins
+	if cfg := b.appConfig(); cfg != nil {
+		configuredPlugins = cfg.Plugins
 	}
 	enabledPlugins := plugin.NewEnabledSet(configuredPlugins)
 	b.enabledPlugins = &enabledPlugins
 
-	if err := proxy_cache.ValidateConfiguredZones(); err != nil {
+	if err := proxy_cache.ValidateConfiguredZones(b.appConfig()); err != nil {
 		return nil, fmt.Errorf("validate proxy-cache zone registry: %w", err)
 	}
 	snapshot, err := b.configSnapshot()
@@ -870,10 +1028,13 @@ func (b *Builder) buildRoutes(quarantineInvalidRoutes bool) (*chi.Mux, error) {
 	slices.SortStableFunc(routes, func(left, right resource.Route) int {
 		return cmp.Compare(left.Priority, right.Priority)
 	})
+	states := make([]RouteState, 0, len(routes))
 	for _, routeResource := range routes {
 		if routeResource.Disabled() {
+			states = append(states, RouteState{Route: routeResource})
 			continue
 		}
+		b.clusters.take()
 		var routeCheckpoint routeBuildCheckpoint
 		if quarantineInvalidRoutes {
 			routeCheckpoint = b.checkpointRouteBuild(publicAPIRegistry)
@@ -905,13 +1066,18 @@ func (b *Builder) buildRoutes(quarantineInvalidRoutes bool) (*chi.Mux, error) {
 		if routeErr == nil {
 			var handler http.Handler
 			var hosts []string
+			var matcher *routeMatcher
 			handler, hosts, routeErr = b.materializeRouteStrict(routeResource, publicAPIRegistry)
+			if routeErr == nil {
+				matcher, routeErr = compileRouteMatcher(routeResource, b.appConfig())
+			}
 			if routeErr == nil {
 				for _, uri := range uris {
-					if registerErr := registrar.registerRouteWithHosts(
+					if registerErr := registrar.registerRouteWithMatcher(
 						routeResource.Methods,
 						uri,
 						hosts,
+						matcher,
 						handler,
 					); registerErr != nil {
 						routeErr = fmt.Errorf("register URI %q: %w", uri, registerErr)
@@ -921,12 +1087,15 @@ func (b *Builder) buildRoutes(quarantineInvalidRoutes bool) (*chi.Mux, error) {
 			}
 		}
 		if routeErr == nil {
+			states = append(states, b.publishedRouteState(routeResource))
 			continue
 		}
 		if !quarantineInvalidRoutes {
 			return nil, fmt.Errorf("build route %s: %w", routeResource.ID, routeErr)
 		}
 		b.rollbackRouteBuild(publicAPIRegistry, routeCheckpoint)
+		b.clusters.take()
+		states = append(states, RouteState{Route: routeResource, Error: routeErr.Error()})
 		b.snapshotQuarantineCount++
 		logger.Errorf("build route %s fail: %s", routeResource.ID, routeErr)
 	}
@@ -935,10 +1104,13 @@ func (b *Builder) buildRoutes(quarantineInvalidRoutes bool) (*chi.Mux, error) {
 		return nil, fmt.Errorf("build global not found handler: %w", err)
 	}
 	mux.NotFound(notFoundHandler.ServeHTTP)
-	if err := registerExtraRoutesStrict(mux, publicAPIRegistry); err != nil {
+	if err := registerExtraRoutesStrict(b.appConfig(), mux, publicAPIRegistry); err != nil {
 		return nil, fmt.Errorf("register extra routes: %w", err)
 	}
 	b.configureGlobalErrorLogObserver()
+	b.generationMu.Lock()
+	b.generation = &Generation{Snapshot: snapshot, Plugins: configuredPlugins, Routes: states}
+	b.generationMu.Unlock()
 	return mux, nil
 }
 
@@ -1247,9 +1419,6 @@ func (b *Builder) buildHandlerWithServiceStrict(
 	); err != nil {
 		return nil, fmt.Errorf("route %q: %w", r.ID, err)
 	}
-	if err := validateUnsupportedUpstreamDiscovery(resolvedUpstream, upstreamProvenance); err != nil {
-		return nil, err
-	}
 	handler, routeTerminals, err := b.buildReverseHandlerWithTerminals(r, service, resolvedUpstream)
 	if err != nil {
 		logger.Errorf("build reverse handler fail: %s", err)
@@ -1355,9 +1524,6 @@ func validateRouteSemantics(routeResource resource.Route) error {
 			return fmt.Errorf("route %q host %q is invalid: %w", routeResource.ID, host, err)
 		}
 	}
-	if routeResource.RemoteAddrConfigured() {
-		return fmt.Errorf("route %q remote_addr is unsupported by the Go data plane", routeResource.ID)
-	}
 	if scriptID := bytes.TrimSpace(
 		routeResource.ScriptID,
 	); len(scriptID) > 0 &&
@@ -1367,18 +1533,8 @@ func validateRouteSemantics(routeResource resource.Route) error {
 	if script := bytes.TrimSpace(routeResource.Script); len(script) > 0 && !bytes.Equal(script, []byte("null")) {
 		return fmt.Errorf("route %q script is unsupported by the Go data plane", routeResource.ID)
 	}
-	if strings.TrimSpace(routeResource.FilterFunc) != "" {
-		return fmt.Errorf("route %q filter_func is unsupported by the Go data plane", routeResource.ID)
-	}
-	if vars := bytes.TrimSpace(routeResource.Vars); len(vars) > 0 &&
-		!bytes.Equal(vars, []byte("null")) &&
-		!bytes.Equal(vars, []byte("[]")) {
-		return fmt.Errorf("route %q vars is unsupported by the Go data plane", routeResource.ID)
-	}
-	for _, addr := range routeResource.RemoteAddrs {
-		if strings.TrimSpace(addr) != "" {
-			return fmt.Errorf("route %q remote_addrs is unsupported by the Go data plane", routeResource.ID)
-		}
+	if _, err := compileRouteMatcher(routeResource, nil); err != nil {
+		return err
 	}
 	if routeResource.StatusConfigured() && routeResource.Status != 0 && routeResource.Status != 1 {
 		return fmt.Errorf(
@@ -1616,7 +1772,9 @@ func isConsumerCredentialOnly(name string) bool {
 	case "basic-auth", "hmac-auth", "jwe-decrypt", "jwt-auth", "key-auth", "ldap-auth", "multi-auth", "wolf-rbac":
 		return true
 	default:
-		return false
+		// Registered out-of-tree authenticators declare it on their stage.
+		spec, ok := plugin.RequestStageFor(name)
+		return ok && spec.ConsumerConfigOnly
 	}
 }
 
@@ -1814,6 +1972,7 @@ func (a *trafficSplitRuntimeAcquirer) Acquire(
 		a.builder.ownsClusterRegistry = true
 	}
 	clusterConfig, err := buildClusterConfigWithTransport(
+		a.builder.appConfig(),
 		a.route,
 		resourceUpstream,
 		targets,
@@ -1825,12 +1984,18 @@ func (a *trafficSplitRuntimeAcquirer) Acquire(
 	}
 	clusterConfig.Retries = max(upstream.Retries, 0)
 	clusterConfig.RetriesConfigured = upstream.RetriesConfigured()
+	if clusterConfig.Type == pxy.BalancerCHash {
+		// traffic-split walks its own ring per weighted upstream and only
+		// falls back to the cluster balancer for weighted selection.
+		clusterConfig.Type = ""
+	}
 	lease, err := a.builder.clusterRegistry.Acquire(clusterConfig)
 	if err != nil {
 		return nil, fmt.Errorf("acquire traffic-split upstream cluster: %w", err)
 	}
 	a.builder.addStopper(lease)
 	cluster := lease.Cluster()
+	a.builder.clusters.record(cluster.Key())
 	return &traffic_split.Runtime{
 		LoadBalancer: cluster.LoadBalancer(),
 		RoundTripper: cluster.RoundTripper(),
@@ -1899,6 +2064,18 @@ type publicAPIRegistrySetter interface {
 	SetPublicAPIRegistry(*public_api.Registry)
 }
 
+type appConfigSetter interface {
+	SetAppConfig(*appconfig.Config)
+}
+
+type extPluginRunnerSetter interface {
+	SetExtPluginRunner(*extplugin.Runner)
+}
+
+type wasmModuleSetter interface {
+	SetWasmModule(*wasm.Module)
+}
+
 type pluginPreMaterializationValidator interface {
 	ValidatePreMaterialization() error
 }
@@ -2328,6 +2505,11 @@ func (p metadataRequestStorePlugin) RunFinalResponseStore(
 	return p.store.RunFinalResponseStore(r, state)
 }
 
+func (p metadataRequestStorePlugin) RunErrorResponseFallback(r *http.Request, status int) bool {
+	fallback, ok := p.store.(base.ErrorResponseFallbackPlugin)
+	return ok && metadataFilterMatches(p.filter, r) && fallback.RunErrorResponseFallback(r, status)
+}
+
 func (p metadataRequestStorePlugin) AppliesToResponseSource(
 	source ctx.ResponseSource,
 ) bool {
@@ -2385,7 +2567,7 @@ func metadataResponseMask(factoryName string, p plugin.Plugin) (int, error) {
 			mask |= metadataResponseBody
 		}
 		return mask, nil
-	case "error-page", "exit-transformer", "response-rewrite":
+	case "error-page", "exit-transformer", "ext-plugin-post-resp", "response-rewrite":
 		return metadataResponseBody, nil
 	case "proxy-cache", "graphql-proxy-cache":
 		return metadataResponseStore, nil
@@ -2603,8 +2785,8 @@ func (b *Builder) configureGlobalErrorLogObserver() {
 	enabled := false
 	if b.enabledPlugins != nil {
 		enabled = b.enabledPlugins.Contains(pluginName)
-	} else if appconfig.GlobalConfig != nil {
-		enabled = slices.Contains(appconfig.GlobalConfig.Plugins, pluginName)
+	} else if cfg := b.appConfig(); cfg != nil {
+		enabled = slices.Contains(cfg.Plugins, pluginName)
 	}
 	if !enabled {
 		_ = logger.ReplaceObserver(pluginName, nil)
@@ -2906,6 +3088,15 @@ func (b *Builder) initPluginBindingsStrict(
 		if setter, ok := p.(publicAPIRegistrySetter); ok {
 			setter.SetPublicAPIRegistry(routeContext.publicAPIRegistry)
 		}
+		if setter, ok := p.(appConfigSetter); ok {
+			setter.SetAppConfig(b.appConfig())
+		}
+		if setter, ok := p.(extPluginRunnerSetter); ok {
+			setter.SetExtPluginRunner(b.extPluginRunner)
+		}
+		if setter, ok := p.(wasmModuleSetter); ok {
+			setter.SetWasmModule(b.wasmModules[name])
+		}
 		if validator, ok := p.(pluginPreMaterializationValidator); ok {
 			if err := validator.ValidatePreMaterialization(); err != nil {
 				return nil, sourceError(fmt.Errorf("validate plugin %s before secret materialization: %w", name, err))
@@ -3255,28 +3446,94 @@ func inlineUpstreamConfigured(upstream resource.Upstream) bool {
 		upstream.ServiceName != ""
 }
 
-func validateUnsupportedUpstreamDiscovery(
+// resolveUpstreamDiscovery replaces the nodes of a `discovery_type` upstream
+// with the registry's current snapshot. The resolved targets feed the cluster
+// key, so a membership change acquires a new cluster on the next rebuild.
+//
+// The builder leases the service until Stop, even when the route is
+// quarantined, so a service that has not resolved yet stays enrolled and its
+// seed triggers the rebuild that publishes the route.
+func (b *Builder) resolveUpstreamDiscovery(
 	upstream resource.Upstream,
 	provenance plugin.ResourceProvenance,
-) error {
-	for _, field := range []struct {
-		name  string
-		value string
-	}{
-		{name: "discovery_type", value: upstream.DiscoveryType},
-		{name: "service_name", value: upstream.ServiceName},
-	} {
-		if field.value == "" {
-			continue
-		}
-		return fmt.Errorf(
-			"unsupported upstream field %q from %s %q: dynamic discovery is not supported",
-			field.name,
+) (resource.Upstream, error) {
+	switch {
+	case upstream.DiscoveryType == "" && upstream.ServiceName == "":
+		return upstream, nil
+	case upstream.DiscoveryType == "":
+		return upstream, fmt.Errorf(
+			"upstream field %q from %s %q requires discovery_type",
+			"service_name",
+			provenance.Kind,
+			provenance.ID,
+		)
+	case upstream.ServiceName == "":
+		return upstream, fmt.Errorf(
+			"upstream field %q from %s %q requires service_name",
+			"discovery_type",
+			provenance.Kind,
+			provenance.ID,
+		)
+	case !b.discovery.Has(upstream.DiscoveryType):
+		return upstream, fmt.Errorf(
+			"upstream field %q from %s %q: discovery %q is not configured",
+			"discovery_type",
 			provenance.Kind,
 			provenance.ID,
+			upstream.DiscoveryType,
 		)
 	}
-	return nil
+	lease, err := b.discoveryLease(upstream.DiscoveryType, upstream.ServiceName)
+	if err != nil {
+		return upstream, fmt.Errorf(
+			"upstream field %q from %s %q: %w",
+			"discovery_type",
+			provenance.Kind,
+			provenance.ID,
+			err,
+		)
+	}
+	nodes, err := lease.Nodes()
+	if err != nil {
+		return upstream, fmt.Errorf(
+			"resolve service %q through discovery %q for %s %q: %w",
+			upstream.ServiceName,
+			upstream.DiscoveryType,
+			provenance.Kind,
+			provenance.ID,
+			err,
+		)
+	}
+	upstream.Nodes = make([]resource.Node, 0, len(nodes))
+	for _, node := range nodes {
+		upstream.Nodes = append(upstream.Nodes, resource.Node{
+			Host:     node.Host,
+			Port:     node.Port,
+			Weight:   node.Weight,
+			Priority: node.Priority,
+		})
+	}
+	return upstream, nil
+}
+
+// discoveryLease returns the builder's lease on service, acquiring it on first
+// use.
+func (b *Builder) discoveryLease(discoveryType, service string) (*discovery.ServiceLease, error) {
+	key := discoveryServiceKey{discoveryType: discoveryType, service: service}
+	b.discoveryLeaseMu.Lock()
+	defer b.discoveryLeaseMu.Unlock()
+	if lease, ok := b.discoveryLeases[key]; ok {
+		return lease, nil
+	}
+	lease, err := b.discovery.Acquire(discoveryType, service)
+	if err != nil {
+		return nil, err
+	}
+	if b.discoveryLeases == nil {
+		b.discoveryLeases = make(map[discoveryServiceKey]*discovery.ServiceLease)
+	}
+	b.discoveryLeases[key] = lease
+	return lease, nil
 }
 
 type routeProtocolTerminals struct {
@@ -3472,9 +3729,11 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 			return nil, routeProtocolTerminals{}, err
 		}
 	}
-	if err := validateUnsupportedUpstreamDiscovery(upstream, upstreamProvenance); err != nil {
+	upstream, err := b.resolveUpstreamDiscovery(upstream, upstreamProvenance)
+	if err != nil {
 		return nil, routeProtocolTerminals{}, err
 	}
+	upstream = b.resolveUpstreamDomains(upstream, upstreamProvenance)
 	if err := validateHTTPUpstreamType(upstream); err != nil {
 		return nil, routeProtocolTerminals{}, err
 	}
@@ -3492,6 +3751,7 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 
 	servers := make(map[string]int, len(upstream.Nodes))
 	priorities := make(map[string]int, len(upstream.Nodes))
+	domains := make(map[string]string)
 	scheme := upstream.Scheme
 	targetScheme := scheme
 	if strings.EqualFold(targetScheme, "grpc") {
@@ -3535,6 +3795,9 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 		uri := fmt.Sprintf("%s://%s", targetScheme, net.JoinHostPort(host, strconv.Itoa(port)))
 		servers[uri] = weight
 		priorities[uri] = node.Priority
+		if node.Domain != "" {
+			domains[uri] = node.Domain
+		}
 	}
 	if len(servers) > 0 {
 		hasPositiveWeight := false
@@ -3554,6 +3817,7 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 	if err != nil {
 		return nil, routeProtocolTerminals{}, err
 	}
+	applyUpstreamNodeDomains(compiledTargets, domains)
 
 	if strings.EqualFold(scheme, "kafka") {
 		handler, err := buildKafkaPubSubProxyHandlerStrictWithSSLResolver(upstream, nil, b.getSSL)
@@ -3579,7 +3843,14 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 			b.clusterRegistry = pxy.NewClusterRegistry(pxy.NopClusterObserver{})
 			b.ownsClusterRegistry = true
 		}
-		clusterConfig, err := buildClusterConfigWithTransport(r, upstream, servers, transportOption, priorities)
+		clusterConfig, err := buildClusterConfigWithTransport(
+			b.appConfig(),
+			r,
+			upstream,
+			servers,
+			transportOption,
+			priorities,
+		)
 		if err != nil {
 			return nil, routeProtocolTerminals{}, err
 		}
@@ -3589,6 +3860,7 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 		}
 		b.addStopper(lease)
 		cluster := lease.Cluster()
+		b.clusters.record(cluster.Key())
 		lb = cluster.LoadBalancer()
 		transport = cluster.RoundTripper()
 	} else {
@@ -3672,6 +3944,7 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 				}
 				return
 			}
+			r = withUpstreamHashKey(r, upstream)
 			r = attachHTTPRetriesCompiled(r, upstream, lb, compiledTargets)
 			selectProxyHandler(r, proxyHandler, streamingProxyHandler).ServeHTTP(w, r)
 		}), routeProtocolTerminals{
@@ -3681,8 +3954,8 @@ func (b *Builder) buildReverseHandlerWithTerminals(
 }
 
 func validateHTTPUpstreamType(upstream resource.Upstream) error {
-	if appconfig.GlobalConfig != nil &&
-		appconfig.GlobalConfig.Deployment.Profile == appconfig.HTTPDataPlaneV1Profile &&
+	if cfg := appconfig.Global(); cfg != nil &&
+		cfg.Deployment.Profile == appconfig.HTTPDataPlaneV1Profile &&
 		strings.EqualFold(upstream.Scheme, "kafka") {
 		return fmt.Errorf(
 			"unsupported upstream scheme %q for %s profile: Kafka is outside the HTTP reverse-proxy contract",
@@ -3692,17 +3965,55 @@ func validateHTTPUpstreamType(upstream resource.Upstream) error {
 	}
 	switch strings.ToLower(upstream.Scheme) {
 	case "", "http", "https", "grpc", "grpcs":
-		if upstream.Type != "" && upstream.Type != "roundrobin" {
+		balancerType, err := pxy.NormalizeBalancerType(upstream.Type)
+		if err != nil {
 			return fmt.Errorf(
-				"unsupported upstream type %q for %q scheme: only roundrobin is supported",
+				"unsupported upstream type %q for %q scheme: must be one of roundrobin, chash, ewma, or least_conn",
 				upstream.Type,
 				upstream.Scheme,
 			)
 		}
+		if balancerType == pxy.BalancerCHash {
+			return validateHTTPUpstreamHash(upstream)
+		}
+	}
+	return nil
+}
+
+func validateHTTPUpstreamHash(upstream resource.Upstream) error {
+	hashOn := upstreamHashOn(upstream)
+	switch hashOn {
+	case "vars", "header", "cookie", "consumer", "vars_combinations":
+	default:
+		return fmt.Errorf("invalid chash upstream: hash_on must be one of vars, header, cookie, consumer, or vars_combinations")
+	}
+	if hashOn != "consumer" && upstream.Key == "" {
+		return fmt.Errorf("invalid chash upstream: key is required when hash_on is %q", hashOn)
 	}
 	return nil
 }
 
+func upstreamHashOn(upstream resource.Upstream) string {
+	if upstream.HashOn == "" {
+		return "vars"
+	}
+	return upstream.HashOn
+}
+
+// withUpstreamHashKey resolves the chash key once per request so the cluster
+// balancer and every retry walk the same ring. APISIX falls back to the client
+// address when the configured key resolves to an empty value.
+func withUpstreamHashKey(r *http.Request, upstream resource.Upstream) *http.Request {
+	if !strings.EqualFold(upstream.Type, pxy.BalancerCHash) {
+		return r
+	}
+	key := pluginexpr.HashValue(r, upstreamHashOn(upstream), upstream.Key)
+	if key == "" {
+		key = pluginexpr.String(pluginexpr.RequestValue(r, "remote_addr"))
+	}
+	return pxy.WithHashKey(r, key)
+}
+
 func upstreamNodeHost(scheme, host, port string) string {
 	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
 		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
@@ -4381,8 +4692,8 @@ func newErrorHandler() pxy.ErrorHandler {
 }
 
 func showUpstreamStatusInResponseHeader() bool {
-	return appconfig.GlobalConfig != nil &&
-		appconfig.GlobalConfig.Apisix.ShowUpstreamStatusInResponseHeader
+	cfg := appconfig.Global()
+	return cfg != nil && cfg.Apisix.ShowUpstreamStatusInResponseHeader
 }
 
 func proxyFailureLogPath(r *http.Request) string {
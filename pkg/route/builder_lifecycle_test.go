@@ -157,7 +157,7 @@ func TestInlineUpstreamConfiguredIncludesDiscoveryFields(t *testing.T) {
 	}
 }
 
-func TestBuildHandlerRejectsDynamicDiscoveryWithStaticNodes(t *testing.T) {
+func TestBuildHandlerRejectsUnconfiguredDiscoveryWithStaticNodes(t *testing.T) {
 	ensureRouteStore(t)
 	for _, test := range []struct {
 		name  string
@@ -184,7 +184,7 @@ func TestBuildHandlerRejectsDynamicDiscoveryWithStaticNodes(t *testing.T) {
 				Upstream: upstream,
 			})
 			if err == nil {
-				t.Fatal("buildHandlerStrict() error = nil, want unsupported discovery error")
+				t.Fatal("buildHandlerStrict() error = nil, want unconfigured discovery error")
 			}
 			message := err.Error()
 			if !strings.Contains(message, "dynamic-discovery-route") ||
@@ -1061,6 +1061,34 @@ func TestBuilderRejectsInvalidUnusedProxyCacheZoneBeforeRefresh(t *testing.T) {
 	builder.Stop()
 }
 
+func TestBuilderWithConfigInitializesPluginsAgainstCandidate(t *testing.T) {
+	published := &appconfig.Config{}
+	oldConfig := appconfig.Global()
+	appconfig.SetGlobal(published)
+	t.Cleanup(func() { appconfig.SetGlobal(oldConfig) })
+	candidate := &appconfig.Config{Apisix: appconfig.Apisix{ProxyCache: appconfig.ProxyCache{
+		Zones: []appconfig.Zone{{Name: "candidate-disk-only", DiskPath: t.TempDir()}},
+	}}}
+
+	builder := NewBuilder(nil).WithConfig(candidate)
+	defer builder.Stop()
+	_, err := builder.initPluginsStrict(
+		map[string]resource.PluginConfig{
+			"proxy-cache": map[string]any{
+				"cache_strategy": "memory",
+				"cache_zone":     "candidate-disk-only",
+			},
+		},
+		builder.pluginRouteContext(resource.Route{ID: "candidate-cache-route"}),
+	)
+	if err == nil {
+		t.Fatal("initPluginsStrict() error = nil, want the candidate zone registry to reject a memory strategy")
+	}
+	if appconfig.Global() != published {
+		t.Fatal("WithConfig() build published its candidate config")
+	}
+}
+
 func TestBuilderPublishesValidRouteWhenLegacySnapshotRowIsUndecodable(t *testing.T) {
 	storage := openLegacyRouteStore(t, map[string]map[string][]byte{
 		"routes": {
@@ -80,17 +80,14 @@ func TestNormalizeSSLNumber(t *testing.T) {
 }
 
 func TestBatchRequestsURIResolvesConfiguredValue(t *testing.T) {
-	previous := config.GlobalConfig
-	t.Cleanup(func() { config.GlobalConfig = previous })
-	config.GlobalConfig = nil
-	if got := batchRequestsURI(); got != "/apisix/batch-requests" {
+	if got := batchRequestsURI(nil); got != "/apisix/batch-requests" {
 		t.Fatalf("batchRequestsURI() = %q, want default URI", got)
 	}
 
-	config.GlobalConfig = &config.Config{
+	cfg := &config.Config{
 		PluginAttr: map[string]map[string]any{"batch-requests": {"uri": "/internal/batch"}},
 	}
-	if got := batchRequestsURI(); got != "/internal/batch" {
+	if got := batchRequestsURI(cfg); got != "/internal/batch" {
 		t.Fatalf("batchRequestsURI() = %q, want configured URI", got)
 	}
 }
@@ -26,41 +26,41 @@ func registerPurgeMethod() {
 }
 
 func registerExtraRoutes(mux *chi.Mux, registries ...*public_api.Registry) {
-	_ = registerExtraRoutesStrict(mux, registries...)
+	_ = registerExtraRoutesStrict(config.Global(), mux, registries...)
 }
 
-func registerExtraRoutesStrict(mux *chi.Mux, registries ...*public_api.Registry) error {
+func registerExtraRoutesStrict(cfg *config.Config, mux *chi.Mux, registries ...*public_api.Registry) error {
 	registry := public_api.NewRegistry()
 	if len(registries) > 0 && registries[0] != nil {
 		registry = registries[0]
 	}
-	if pluginEnabled("node-status") {
+	if pluginEnabled(cfg, "node-status") {
 		mux.Handle("/apisix/status", http.NotFoundHandler())
 		mux.Get("/apisix/status", node_status.StatusHandler)
 		registry.Register("GET", "/apisix/status", http.HandlerFunc(node_status.StatusHandler))
 	}
-	if pluginEnabled("server-info") {
+	if pluginEnabled(cfg, "server-info") {
 		mux.Get("/v1/server_info", server_info.InfoHandler)
 		registry.Register("GET", "/v1/server_info", http.HandlerFunc(server_info.InfoHandler))
 	}
-	if pluginEnabled("batch-requests") {
+	if pluginEnabled(cfg, "batch-requests") {
 		handler := batch_requests.NewHandler(mux)
-		uri := batchRequestsURI()
+		uri := batchRequestsURI(cfg)
 		mux.Method("POST", uri, handler)
 		registry.Register("POST", batch_requests.DefaultURI, handler)
 		if uri != batch_requests.DefaultURI {
 			registry.Register("POST", uri, handler)
 		}
 	}
-	if pluginEnabled("graphql-proxy-cache") {
+	if pluginEnabled(cfg, "graphql-proxy-cache") {
 		registerPurgeMethod()
 		mux.Method("PURGE", graphql_proxy_cache.PurgeURI, http.HandlerFunc(graphql_proxy_cache.PurgeHandler))
 	}
 	return nil
 }
 
-func registerPrometheusPublicEndpoint(registry *public_api.Registry) error {
-	if !pluginEnabled("prometheus") {
+func registerPrometheusPublicEndpoint(cfg *config.Config, registry *public_api.Registry) error {
+	if !pluginEnabled(cfg, "prometheus") {
 		return nil
 	}
 	endpoint, err := metrics.ConfiguredPublicEndpoint()
@@ -73,18 +73,15 @@ func registerPrometheusPublicEndpoint(registry *public_api.Registry) error {
 	return nil
 }
 
-func pluginEnabled(name string) bool {
-	if config.GlobalConfig == nil {
-		return false
-	}
-	return slices.Contains(config.GlobalConfig.Plugins, name)
+func pluginEnabled(cfg *config.Config, name string) bool {
+	return cfg != nil && slices.Contains(cfg.Plugins, name)
 }
 
-func batchRequestsURI() string {
-	if config.GlobalConfig == nil {
+func batchRequestsURI(cfg *config.Config) string {
+	if cfg == nil {
 		return batch_requests.DefaultURI
 	}
-	attr := config.GlobalConfig.PluginAttr["batch-requests"]
+	attr := cfg.PluginAttr["batch-requests"]
 	if attr == nil {
 		return batch_requests.DefaultURI
 	}
@@ -0,0 +1,99 @@
+package route
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/wklken/apisix-go/pkg/plugin"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+// RouteState is the build outcome of one route of a generation.
+type RouteState struct {
+	Route resource.Route
+	// UpstreamID is the upstream resource a published route proxies to,
+	// through the route or its service; it is empty for inline upstreams.
+	UpstreamID string
+	// Error is why the route was quarantined; it is empty for published and
+	// disabled routes.
+	Error string
+	// Clusters are the keys of the upstream clusters the published route
+	// leased, including traffic-split targets.
+	Clusters []pxy.ClusterKey
+}
+
+// Published reports whether the route is served by its generation.
+func (s RouteState) Published() bool {
+	return s.Error == "" && !s.Route.Disabled()
+}
+
+// Generation is what one successful Build published: the configuration
+// snapshot it read, the effective HTTP plugin allowlist and the outcome of
+// every route.
+type Generation struct {
+	Snapshot *store.ConfigSnapshot
+	// Plugins is the HTTP plugin allowlist routes were built against.
+	Plugins []string
+	Routes  []RouteState
+}
+
+// Route returns the state of the route with id.
+func (g *Generation) Route(id string) (RouteState, bool) {
+	if g == nil {
+		return RouteState{}, false
+	}
+	index := slices.IndexFunc(g.Routes, func(state RouteState) bool { return state.Route.ID == id })
+	if index < 0 {
+		return RouteState{}, false
+	}
+	return g.Routes[index], true
+}
+
+// clusterRecorder collects the cluster keys leased while one route is
+// materialized.
+type clusterRecorder struct {
+	mu   sync.Mutex
+	keys []pxy.ClusterKey
+}
+
+func (r *clusterRecorder) record(key pxy.ClusterKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.keys, key) {
+		r.keys = append(r.keys, key)
+	}
+}
+
+// take returns the recorded keys and starts the next route.
+func (r *clusterRecorder) take() []pxy.ClusterKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := r.keys
+	r.keys = nil
+	return keys
+}
+
+// Generation returns the generation of the last successful Build, or nil
+// before one completes.
+func (b *Builder) Generation() *Generation {
+	b.generationMu.Lock()
+	defer b.generationMu.Unlock()
+	return b.generation
+}
+
+// publishedRouteState describes a route that was just materialized, taking
+// the clusters it leased.
+func (b *Builder) publishedRouteState(r resource.Route) RouteState {
+	state := RouteState{Route: r, Clusters: b.clusters.take()}
+	service, err := b.loadRouteService(r)
+	if err != nil {
+		return state
+	}
+	_, provenance, err := b.resolveRouteUpstream(r, service)
+	if err == nil && provenance.Kind == plugin.ResourceUpstream {
+		state.UpstreamID = provenance.ID
+	}
+	return state
+}
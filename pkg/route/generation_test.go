@@ -0,0 +1,63 @@
+package route
+
+import (
+	"strings"
+	"testing"
+
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+)
+
+func TestBuildWithRouteQuarantineRecordsGeneration(t *testing.T) {
+	ensureRouteStore(t)
+	setHTTPPluginAllowlist(t, "request-id")
+	putHTTPAllowlistResource(t, "upstreams", "generation-upstream", []byte(
+		`{"id":"generation-upstream","nodes":{"127.0.0.1:1980":1}}`,
+	))
+	putRouteResource(t, "generation-live", []byte(
+		`{"id":"generation-live","uri":"/generation-live","upstream_id":"generation-upstream"}`,
+	))
+	putRouteResource(t, "generation-invalid", []byte(
+		`{"id":"generation-invalid","uri":"/generation-invalid","plugins":{"not-a-plugin":{}},`+
+			`"upstream":{"nodes":{"127.0.0.1:1981":1}}}`,
+	))
+	putRouteResource(t, "generation-disabled", []byte(
+		`{"id":"generation-disabled","uri":"/generation-disabled","status":0}`,
+	))
+
+	registry := pxy.NewClusterRegistry(pxy.NopClusterObserver{})
+	t.Cleanup(registry.Close)
+	builder := NewBuilderWithClusterRegistry(nil, "", registry)
+	t.Cleanup(builder.Stop)
+	if builder.Generation() != nil {
+		t.Fatal("Generation() before Build = non-nil")
+	}
+	if _, err := builder.BuildWithRouteQuarantine(); err != nil {
+		t.Fatalf("BuildWithRouteQuarantine() error = %v", err)
+	}
+
+	generation := builder.Generation()
+	if generation == nil || generation.Snapshot == nil {
+		t.Fatalf("Generation() = %+v, want the built snapshot", generation)
+	}
+	if len(generation.Plugins) != 1 || generation.Plugins[0] != "request-id" {
+		t.Fatalf("Generation().Plugins = %v, want the build allowlist", generation.Plugins)
+	}
+	live, ok := generation.Route("generation-live")
+	if !ok || !live.Published() || len(live.Clusters) != 1 || live.UpstreamID != "generation-upstream" {
+		t.Fatalf("live route state = %+v/%v, want published through its upstream with one cluster", live, ok)
+	}
+	if registry.Len() != 1 {
+		t.Fatalf("registry.Len() = %d, want only the live route's cluster", registry.Len())
+	}
+	invalid, ok := generation.Route("generation-invalid")
+	if !ok || invalid.Published() || !strings.Contains(invalid.Error, "not-a-plugin") || invalid.Clusters != nil {
+		t.Fatalf("invalid route state = %+v/%v, want quarantine reason without clusters", invalid, ok)
+	}
+	disabled, ok := generation.Route("generation-disabled")
+	if !ok || disabled.Published() || disabled.Error != "" {
+		t.Fatalf("disabled route state = %+v/%v, want unpublished without an error", disabled, ok)
+	}
+	if _, ok := generation.Route("missing"); ok {
+		t.Fatal("Route(missing) found a route")
+	}
+}
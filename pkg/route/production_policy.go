@@ -69,6 +69,15 @@ func validateHTTPDataPlanePluginPolicy(
 				err,
 			)
 		}
+		if jwks, ok := values["jwks"].(map[string]any); ok {
+			if sslVerify, ok := jwks["ssl_verify"].(bool); ok && !sslVerify {
+				return fmt.Errorf(
+					"plugin %q from %s must not set jwks.ssl_verify: false",
+					name,
+					policySource(source),
+				)
+			}
+		}
 	}
 	return nil
 }
@@ -142,8 +151,8 @@ func validateHTTPDataPlaneGlobalRulePolicy(globalRules []resource.GlobalRule, ro
 }
 
 func httpDataPlaneV1ProfileActive() bool {
-	return appconfig.GlobalConfig != nil &&
-		appconfig.GlobalConfig.Deployment.Profile == appconfig.HTTPDataPlaneV1Profile
+	cfg := appconfig.Global()
+	return cfg != nil && cfg.Deployment.Profile == appconfig.HTTPDataPlaneV1Profile
 }
 
 func policySource(source string) string {
@@ -88,6 +88,18 @@ func TestHTTPDataPlaneV1PluginPolicy(t *testing.T) {
 				"jwt-auth": map[string]any{"hide_credentials": true, "claims_to_verify": []any{"exp"}},
 			},
 		},
+		{
+			name:    "jwt-auth rejects unverified JWKS TLS",
+			profile: appconfig.HTTPDataPlaneV1Profile,
+			plugins: map[string]resource.PluginConfig{
+				"jwt-auth": map[string]any{
+					"hide_credentials": true,
+					"claims_to_verify": []any{"exp"},
+					"jwks":             map[string]any{"uri": "https://idp.example.com/certs", "ssl_verify": false},
+				},
+			},
+			wantErr: true, wantFields: []string{"jwt-auth", "jwks.ssl_verify"},
+		},
 		{
 			name:    "disabled auth config is inert",
 			profile: appconfig.HTTPDataPlaneV1Profile,
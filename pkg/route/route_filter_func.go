@@ -0,0 +1,161 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/luautil"
+	"github.com/wklken/apisix-go/pkg/resource"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	lua_parse "github.com/yuin/gopher-lua/parse"
+)
+
+// routeFilterTimeout bounds one filter_func call. The Lua VM checks the
+// deadline between instructions, so a filter that loops forever rejects the
+// route instead of holding the request goroutine.
+const routeFilterTimeout = 100 * time.Millisecond
+
+// routeFilterFunc is a route's compiled `filter_func`. The prototype is
+// immutable and shared by every request of the route generation.
+type routeFilterFunc struct {
+	routeID string
+	proto   *lua.FunctionProto
+	// states pools the sandboxed Lua states of this route only, so nothing a
+	// filter leaves in a state reaches another route. A state whose call
+	// failed is closed instead of being returned.
+	states sync.Pool
+}
+
+// compileRouteFilterFunc compiles `filter_func` the way APISIX loads it, as
+// the chunk `return <filter_func>`, which must be a single function
+// expression. A blank `filter_func` compiles to nil.
+func compileRouteFilterFunc(routeResource resource.Route) (*routeFilterFunc, error) {
+	source := strings.TrimSpace(routeResource.FilterFunc)
+	if source == "" {
+		return nil, nil
+	}
+	name := "route#" + routeResource.ID
+	chunk, err := lua_parse.Parse(strings.NewReader("return "+source), name)
+	if err != nil {
+		return nil, fmt.Errorf("route %q filter_func is invalid: %w", routeResource.ID, err)
+	}
+	if !isFunctionChunk(chunk) {
+		return nil, fmt.Errorf("route %q filter_func must be a Lua function", routeResource.ID)
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, fmt.Errorf("route %q filter_func is invalid: %w", routeResource.ID, err)
+	}
+	filter := &routeFilterFunc{routeID: routeResource.ID, proto: proto}
+	filter.states.New = func() any { return newRouteFilterState() }
+	return filter, nil
+}
+
+func isFunctionChunk(chunk []ast.Stmt) bool {
+	if len(chunk) != 1 {
+		return false
+	}
+	ret, ok := chunk[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Exprs) != 1 {
+		return false
+	}
+	_, ok = ret.Exprs[0].(*ast.FunctionExpr)
+	return ok
+}
+
+// routeFilterBlockedGlobals are the base library functions a filter cannot
+// use: they reach files, compile new code, write to stdout, or swap a
+// function's environment for the shared globals.
+var routeFilterBlockedGlobals = []string{
+	"dofile", "loadfile", "load", "loadstring", "print", "getfenv", "setfenv",
+}
+
+// newRouteFilterState opens only the base, table, string and math libraries,
+// without routeFilterBlockedGlobals, so a filter cannot reach files or
+// processes.
+func newRouteFilterState() *lua.LState {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	lua.OpenBase(state)
+	lua.OpenTable(state)
+	lua.OpenString(state)
+	lua.OpenMath(state)
+	for _, name := range routeFilterBlockedGlobals {
+		state.SetGlobal(name, lua.LNil)
+	}
+	state.SetTop(0)
+	return state
+}
+
+// filterEnv is a fresh global environment for one filter call. Reads fall
+// back to the state's globals; writes, including through _G, stay in the
+// call's own table.
+func filterEnv(state *lua.LState) *lua.LTable {
+	env := state.NewTable()
+	env.RawSetString("_G", env)
+	meta := state.NewTable()
+	meta.RawSetString("__index", state.G.Global)
+	state.SetMetatable(env, meta)
+	return env
+}
+
+// filterMatches calls the route's filter function with a read-only `vars`
+// table and reports whether it returned a truthy value. Unset variables read
+// as nil. A Lua error, or running past routeFilterTimeout, rejects the route.
+func (m *routeMatchRequest) filterMatches(filter *routeFilterFunc) bool {
+	ctx, cancel := context.WithTimeout(m.request.Context(), routeFilterTimeout)
+	defer cancel()
+	state := filter.states.Get().(*lua.LState)
+	state.SetContext(ctx)
+	matched, err := m.callFilter(state, filter)
+	state.RemoveContext()
+	state.SetTop(0)
+	if err != nil {
+		state.Close()
+		logger.Errorf("route %q filter_func failed: %s", filter.routeID, err)
+		return false
+	}
+	filter.states.Put(state)
+	return matched
+}
+
+func (m *routeMatchRequest) callFilter(state *lua.LState, filter *routeFilterFunc) (bool, error) {
+	chunk := state.NewFunctionFromProto(filter.proto)
+	chunk.Env = filterEnv(state)
+	state.Push(chunk)
+	if err := state.PCall(0, 1, nil); err != nil {
+		return false, err
+	}
+	fn := state.Get(-1)
+	state.SetTop(0)
+	state.Push(fn)
+	state.Push(m.filterVars(state))
+	if err := state.PCall(1, 1, nil); err != nil {
+		return false, err
+	}
+	return lua.LVAsBool(state.Get(-1)), nil
+}
+
+func (m *routeMatchRequest) filterVars(state *lua.LState) *lua.LTable {
+	vars := state.NewTable()
+	meta := state.NewTable()
+	meta.RawSetString("__index", state.NewFunction(func(l *lua.LState) int {
+		value := m.value(l.CheckString(2))
+		if value == nil || value == "" {
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.Push(luautil.GoValueToLua(l, value))
+		return 1
+	}))
+	meta.RawSetString("__newindex", state.NewFunction(func(l *lua.LState) int {
+		l.RaiseError("vars is read-only")
+		return 0
+	}))
+	state.SetMetatable(vars, meta)
+	return vars
+}
@@ -0,0 +1,204 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	appconfig "github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/json"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	pluginexpr "github.com/wklken/apisix-go/pkg/plugin/expr"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+// routeMatcher holds the predicates APISIX checks after URI, host, and
+// method: `remote_addr`/`remote_addrs`, then `vars`, then `filter_func`.
+type routeMatcher struct {
+	remoteAddrs []netip.Prefix
+	vars        *pluginexpr.Expression
+	filter      *routeFilterFunc
+	// maxPostArgsSize is apisix.max_post_args_readable_size of the config
+	// the route generation was built against.
+	maxPostArgsSize int
+}
+
+// compileRouteMatcher returns nil for a route without address, vars or
+// filter_func predicates, so it keeps the plain dispatch path. cfg is the
+// config the route generation is built against.
+func compileRouteMatcher(routeResource resource.Route, cfg *appconfig.Config) (*routeMatcher, error) {
+	remoteAddrs, err := compileRouteRemoteAddrs(routeResource)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := compileRouteVars(routeResource)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := compileRouteFilterFunc(routeResource)
+	if err != nil {
+		return nil, err
+	}
+	if len(remoteAddrs) == 0 && vars == nil && filter == nil {
+		return nil, nil
+	}
+	maxPostArgsSize := base.DefaultRequestBodyMaxBytes
+	if cfg != nil && cfg.Apisix.MaxPostArgsReadableSize > 0 {
+		maxPostArgsSize = cfg.Apisix.MaxPostArgsReadableSize
+	}
+	return &routeMatcher{remoteAddrs: remoteAddrs, vars: vars, filter: filter, maxPostArgsSize: maxPostArgsSize}, nil
+}
+
+// compileRouteRemoteAddrs parses `remote_addr` or `remote_addrs` into
+// prefixes; a bare IP matches only itself.
+func compileRouteRemoteAddrs(routeResource resource.Route) ([]netip.Prefix, error) {
+	addrs := routeResource.RemoteAddrs
+	if routeResource.RemoteAddrConfigured() {
+		if len(routeResource.RemoteAddrs) > 0 {
+			return nil, fmt.Errorf("route %q remote_addr and remote_addrs cannot both be configured", routeResource.ID)
+		}
+		addrs = []string{routeResource.RemoteAddr}
+	}
+	prefixes := make([]netip.Prefix, 0, len(addrs))
+	for _, addr := range addrs {
+		prefix, err := parseRouteRemoteAddr(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("route %q remote_addr %q is invalid: %w", routeResource.ID, addr, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func parseRouteRemoteAddr(addr string) (netip.Prefix, error) {
+	if addr == "" {
+		return netip.Prefix{}, fmt.Errorf("must not be empty")
+	}
+	if strings.Contains(addr, "/") {
+		prefix, err := netip.ParsePrefix(addr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() {
+			return netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0)).Masked(), nil
+		}
+		return prefix.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// compileRouteVars compiles a route's `vars` with lua-resty-expr semantics.
+// Empty `vars` (`[]` or `null`) compile to nil, which matches every request.
+func compileRouteVars(routeResource resource.Route) (*pluginexpr.Expression, error) {
+	raw := bytes.TrimSpace(routeResource.Vars)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) || bytes.Equal(raw, []byte("[]")) {
+		return nil, nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("route %q vars is invalid: %w", routeResource.ID, err)
+	}
+	compiled, err := pluginexpr.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("route %q vars is invalid: %w", routeResource.ID, err)
+	}
+	return compiled, nil
+}
+
+// routeMatchRequest evaluates route matchers for one request. The client
+// address is parsed at most once; `post_arg_*` reads the urlencoded form body
+// at most once and leaves the body replayable for the matched route.
+type routeMatchRequest struct {
+	request        *http.Request
+	clientIP       netip.Addr
+	clientIPLoaded bool
+	postArgs       url.Values
+	postArgsLoaded bool
+	// maxPostArgsSize is the limit of the matcher being evaluated; every
+	// matcher of one route generation carries the same one.
+	maxPostArgsSize int
+}
+
+func (m *routeMatchRequest) matches(matcher *routeMatcher) bool {
+	if len(matcher.remoteAddrs) > 0 && !m.remoteAddrMatches(matcher.remoteAddrs) {
+		return false
+	}
+	m.maxPostArgsSize = matcher.maxPostArgsSize
+	if matcher.vars != nil && !matcher.vars.Eval(m.value) {
+		return false
+	}
+	return matcher.filter == nil || m.filterMatches(matcher.filter)
+}
+
+func (m *routeMatchRequest) remoteAddrMatches(prefixes []netip.Prefix) bool {
+	if !m.clientIPLoaded {
+		if ip, err := netip.ParseAddr(apisixctx.ClientIP(m.request)); err == nil {
+			m.clientIP = ip.Unmap()
+		}
+		m.clientIPLoaded = true
+	}
+	if !m.clientIP.IsValid() {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(m.clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *routeMatchRequest) value(name string) any {
+	if key, ok := strings.CutPrefix(strings.TrimPrefix(name, "$"), "post_arg_"); ok {
+		if !m.postArgsLoaded {
+			m.postArgs = readRoutePostArgs(m.request, m.maxPostArgsSize)
+			m.postArgsLoaded = true
+		}
+		return m.postArgs.Get(key)
+	}
+	return pluginexpr.RequestValue(m.request, name)
+}
+
+// readRoutePostArgs parses an urlencoded body no larger than limit, the
+// apisix.max_post_args_readable_size of the route generation. A larger or
+// malformed body yields no post args; either way the body is restored for the
+// upstream.
+func readRoutePostArgs(r *http.Request, limit int) url.Values {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || contentType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil || len(body) > limit {
+		r.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		return nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,471 @@
+package route
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
+	appconfig "github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func mustCompileRouteMatcher(t *testing.T, route resource.Route) *routeMatcher {
+	t.Helper()
+	matcher, err := compileRouteMatcher(route, nil)
+	if err != nil {
+		t.Fatalf("compileRouteMatcher(%+v) error = %v", route, err)
+	}
+	return matcher
+}
+
+func TestRegisterRouteWithVarsFallsThroughByPriority(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	registrar := newRouteRegistrar(router)
+	register := func(uri string, methods []string, vars string, status int) {
+		t.Helper()
+		matcher := mustCompileRouteMatcher(t, resource.Route{Vars: []byte(vars)})
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+		if err := registrar.registerRouteWithMatcher(methods, uri, nil, matcher, handler); err != nil {
+			t.Fatalf("registerRouteWithMatcher(%s) error = %v", uri, err)
+		}
+	}
+	// Registration order is ascending priority: later routes win.
+	register("/orders", nil, "", http.StatusOK)
+	register("/orders", nil, `[["http_x_client","==","ios"]]`, http.StatusCreated)
+	register("/orders", []string{http.MethodGet}, `[["arg_tier","in",["gold","silver"]],["cookie_beta","==","1"]]`, http.StatusAccepted)
+	register("/users/:id", []string{http.MethodGet}, "", http.StatusOK)
+	register("/users/:id", []string{http.MethodGet}, `[["http_x_client","==","ios"]]`, http.StatusCreated)
+	register("/only-vars", []string{http.MethodGet}, `[["arg_debug","==","1"]]`, http.StatusOK)
+
+	for _, test := range []struct {
+		name    string
+		method  string
+		target  string
+		prepare func(*http.Request)
+		want    int
+	}{
+		{name: "no vars fallback", method: http.MethodGet, target: "/orders", want: http.StatusOK},
+		{
+			name:    "header match",
+			method:  http.MethodGet,
+			target:  "/orders",
+			prepare: func(r *http.Request) { r.Header.Set("X-Client", "ios") },
+			want:    http.StatusCreated,
+		},
+		{
+			name:   "higher priority conjunction",
+			method: http.MethodGet,
+			target: "/orders?tier=gold",
+			prepare: func(r *http.Request) {
+				r.Header.Set("X-Client", "ios")
+				r.AddCookie(&http.Cookie{Name: "beta", Value: "1"})
+			},
+			want: http.StatusAccepted,
+		},
+		{
+			name:    "partial conjunction",
+			method:  http.MethodGet,
+			target:  "/orders?tier=bronze",
+			prepare: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "beta", Value: "1"}) },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "method scoped vars",
+			method:  http.MethodPost,
+			target:  "/orders?tier=gold",
+			prepare: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "beta", Value: "1"}) },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "parameterized vars",
+			method:  http.MethodGet,
+			target:  "/users/7",
+			prepare: func(r *http.Request) { r.Header.Set("X-Client", "ios") },
+			want:    http.StatusCreated,
+		},
+		{name: "parameterized fallback", method: http.MethodGet, target: "/users/7", want: http.StatusOK},
+		{name: "vars miss", method: http.MethodGet, target: "/only-vars", want: http.StatusNotFound},
+		{name: "vars hit", method: http.MethodGet, target: "/only-vars?debug=1", want: http.StatusOK},
+		{name: "method mismatch", method: http.MethodPost, target: "/only-vars?debug=1", want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, nil)
+			if test.prepare != nil {
+				test.prepare(request)
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.want {
+				t.Fatalf("%s %s status = %d, want %d", test.method, test.target, response.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestRegisterRouteWithVarsReadsPostArgsAndKeepsBody(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	registrar := newRouteRegistrar(router)
+	matcher := mustCompileRouteMatcher(t, resource.Route{Vars: []byte(`[["post_arg_action","==","buy"]]`)})
+	var upstreamBody string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamBody = string(body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	if err := registrar.registerRouteWithMatcher([]string{http.MethodPost}, "/form", nil, matcher, handler); err != nil {
+		t.Fatalf("registerRouteWithMatcher() error = %v", err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader("action=buy&item=1"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusCreated || upstreamBody != "action=buy&item=1" {
+		t.Fatalf("form request = (%d, %q), want 201 with the original body", response.Code, upstreamBody)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(`{"action":"buy"}`))
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNotFound {
+		t.Fatalf("JSON request status = %d, want 404 without form post args", response.Code)
+	}
+}
+
+func TestBuildStrictRouteVarsHonorPriority(t *testing.T) {
+	ensureRouteStore(t)
+	setHTTPPluginAllowlist(t)
+
+	upstream := func(name string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Upstream", name)
+		}))
+		t.Cleanup(server.Close)
+		return routePriorityNode(t, server.URL)
+	}
+	for _, route := range []struct {
+		id       string
+		priority int
+		vars     string
+		node     string
+	}{
+		{id: "vars-default", priority: 0, vars: "[]", node: upstream("default")},
+		{id: "vars-canary", priority: 10, vars: `[["http_x_canary","==","1"]]`, node: upstream("canary")},
+		{id: "vars-internal", priority: 20, vars: `["OR",["arg_internal","==","1"],["http_x_canary","==","2"]]`, node: upstream("internal")},
+	} {
+		putRouteResource(t, route.id, fmt.Appendf(nil,
+			`{"id":%q,"uri":"/vars-priority","priority":%d,"vars":%s,"upstream":{"type":"roundrobin","nodes":{%q:1}}}`,
+			route.id, route.priority, route.vars, route.node,
+		))
+	}
+
+	builder := NewBuilder(nil)
+	t.Cleanup(builder.Stop)
+	handler, err := builder.BuildStrict()
+	if err != nil {
+		t.Fatalf("BuildStrict() error = %v", err)
+	}
+	for _, test := range []struct {
+		target string
+		canary string
+		want   string
+	}{
+		{target: "/vars-priority", want: "default"},
+		{target: "/vars-priority", canary: "1", want: "canary"},
+		{target: "/vars-priority?internal=1", canary: "1", want: "internal"},
+		{target: "/vars-priority", canary: "2", want: "internal"},
+	} {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.canary != "" {
+			request.Header.Set("X-Canary", test.canary)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if got := response.Header().Get("X-Upstream"); got != test.want {
+			t.Fatalf("GET %s (X-Canary %q) upstream = %q (status %d), want %q",
+				test.target, test.canary, got, response.Code, test.want)
+		}
+	}
+}
+
+func TestBuildWithConfigReadsPostArgsUpToCandidateLimit(t *testing.T) {
+	ensureRouteStore(t)
+	setHTTPPluginAllowlist(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Upstream", "form")
+	}))
+	t.Cleanup(server.Close)
+	putRouteResource(t, "post-args-limit", fmt.Appendf(nil,
+		`{"id":"post-args-limit","uri":"/post-args-limit","vars":[["post_arg_action","==","buy"]],`+
+			`"upstream":{"type":"roundrobin","nodes":{%q:1}}}`,
+		routePriorityNode(t, server.URL),
+	))
+
+	candidate := &appconfig.Config{Apisix: appconfig.Apisix{MaxPostArgsReadableSize: 10}}
+	builder := NewBuilder(nil).WithConfig(candidate)
+	t.Cleanup(builder.Stop)
+	handler, err := builder.BuildStrict()
+	if err != nil {
+		t.Fatalf("BuildStrict() error = %v", err)
+	}
+	for _, test := range []struct {
+		body string
+		want string
+	}{
+		{body: "action=buy", want: "form"},
+		{body: "action=buy&item=1", want: ""},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/post-args-limit", strings.NewReader(test.body))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if got := response.Header().Get("X-Upstream"); got != test.want {
+			t.Fatalf("POST %q upstream = %q (status %d), want %q", test.body, got, response.Code, test.want)
+		}
+	}
+}
+
+func TestRegisterRouteWithRemoteAddrsFallsThroughByPriority(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	registrar := newRouteRegistrar(router)
+	register := func(route resource.Route, status int) {
+		t.Helper()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+		matcher := mustCompileRouteMatcher(t, route)
+		if err := registrar.registerRouteWithMatcher(nil, "/reports", nil, matcher, handler); err != nil {
+			t.Fatalf("registerRouteWithMatcher() error = %v", err)
+		}
+	}
+	register(resource.Route{}, http.StatusOK)
+	register(resource.Route{RemoteAddrs: []string{"10.0.0.0/8", "2001:db8::/32"}}, http.StatusCreated)
+	register(resource.Route{RemoteAddr: "10.1.2.3", Vars: []byte(`[["arg_full","==","1"]]`)}, http.StatusAccepted)
+
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		clientIP   string
+		target     string
+		want       int
+	}{
+		{name: "public", remoteAddr: "203.0.113.9:1000", target: "/reports", want: http.StatusOK},
+		{name: "internal IPv4", remoteAddr: "10.9.9.9:1000", target: "/reports", want: http.StatusCreated},
+		{name: "internal IPv6", remoteAddr: "[2001:db8::1]:1000", target: "/reports", want: http.StatusCreated},
+		{name: "IPv4-mapped", remoteAddr: "[::ffff:10.9.9.9]:1000", target: "/reports", want: http.StatusCreated},
+		{name: "single address with vars", remoteAddr: "10.1.2.3:1000", target: "/reports?full=1", want: http.StatusAccepted},
+		{name: "single address vars miss", remoteAddr: "10.1.2.3:1000", target: "/reports", want: http.StatusCreated},
+		{
+			name:       "forwarded client",
+			remoteAddr: "127.0.0.1:1000",
+			clientIP:   "10.9.9.9",
+			target:     "/reports",
+			want:       http.StatusCreated,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			request.RemoteAddr = test.remoteAddr
+			if test.clientIP != "" {
+				request = apisixctx.WithClientIP(request, test.clientIP)
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.want {
+				t.Fatalf("GET %s from %s status = %d, want %d", test.target, test.remoteAddr, response.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileRouteMatcherValidatesRemoteAddrs(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		route   resource.Route
+		wantErr string
+	}{
+		{name: "empty list", route: resource.Route{RemoteAddrs: []string{}}},
+		{name: "IPv6 CIDR", route: resource.Route{RemoteAddrs: []string{"::1/128", "fe80::/10"}}},
+		{name: "blank entry", route: resource.Route{RemoteAddrs: []string{" "}}, wantErr: "must not be empty"},
+		{name: "bad CIDR", route: resource.Route{RemoteAddrs: []string{"10.0.0.0/33"}}, wantErr: "remote_addr"},
+		{name: "hostname", route: resource.Route{RemoteAddr: "internal.example.com"}, wantErr: "remote_addr"},
+		{
+			name:    "both fields",
+			route:   resource.Route{RemoteAddr: "10.0.0.1", RemoteAddrs: []string{"10.0.0.2"}},
+			wantErr: "cannot both be configured",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileRouteMatcher(test.route, nil)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("compileRouteMatcher() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("compileRouteMatcher() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterRouteWithFilterFuncFallsThroughByPriority(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	registrar := newRouteRegistrar(router)
+	register := func(route resource.Route, status int) {
+		t.Helper()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+		matcher := mustCompileRouteMatcher(t, route)
+		if err := registrar.registerRouteWithMatcher([]string{http.MethodGet}, "/items", nil, matcher, handler); err != nil {
+			t.Fatalf("registerRouteWithMatcher() error = %v", err)
+		}
+	}
+	register(resource.Route{}, http.StatusOK)
+	register(resource.Route{
+		FilterFunc: `function(vars) return vars.arg_tier == "gold" and vars.http_x_client ~= nil end`,
+	}, http.StatusCreated)
+	register(resource.Route{
+		Vars:       []byte(`[["arg_tier","==","gold"]]`),
+		FilterFunc: `function(vars) return tonumber(vars.arg_n) > 10 end`,
+	}, http.StatusAccepted)
+	register(resource.Route{FilterFunc: `function(vars) vars.uri = "/" return true end`}, http.StatusTeapot)
+
+	for _, test := range []struct {
+		name   string
+		target string
+		client string
+		want   int
+	}{
+		{name: "filter miss", target: "/items?tier=gold", want: http.StatusOK},
+		{name: "filter hit", target: "/items?tier=gold", client: "ios", want: http.StatusCreated},
+		{name: "vars and filter", target: "/items?tier=gold&n=11", client: "ios", want: http.StatusAccepted},
+		{name: "filter error falls through", target: "/items?tier=gold", client: "ios", want: http.StatusCreated},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.client != "" {
+				request.Header.Set("X-Client", test.client)
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.want {
+				t.Fatalf("GET %s status = %d, want %d", test.target, response.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileRouteMatcherValidatesFilterFunc(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		filterFunc string
+		wantErr    string
+	}{
+		{name: "blank", filterFunc: " \t "},
+		{name: "function", filterFunc: "function(vars) return vars.uri == '/' end"},
+		{name: "syntax error", filterFunc: "function(vars) return end end", wantErr: "filter_func is invalid"},
+		{name: "not a function", filterFunc: "true", wantErr: "must be a Lua function"},
+		{name: "trailing call", filterFunc: "function() end or os.exit()", wantErr: "must be a Lua function"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileRouteMatcher(resource.Route{ID: "filter-route", FilterFunc: test.filterFunc}, nil)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("compileRouteMatcher() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || !strings.Contains(err.Error(), "filter-route") {
+				t.Fatalf("compileRouteMatcher() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteFilterFuncSandbox(t *testing.T) {
+	t.Parallel()
+
+	matcher := mustCompileRouteMatcher(t, resource.Route{
+		ID: "sandbox-route",
+		FilterFunc: `function(vars)
+			return os == nil and io == nil and dofile == nil and loadfile == nil and load == nil and
+				loadstring == nil and print == nil and getfenv == nil and setfenv == nil
+		end`,
+	})
+	match := &routeMatchRequest{request: httptest.NewRequest(http.MethodGet, "/", nil)}
+	if !match.matches(matcher) {
+		t.Fatal("filter_func saw a blocked global")
+	}
+}
+
+func TestRouteFilterFuncRejectsRouteAfterTimeout(t *testing.T) {
+	t.Parallel()
+
+	matcher := mustCompileRouteMatcher(t, resource.Route{
+		ID:         "busy-route",
+		FilterFunc: `function(vars) while true do end end`,
+	})
+	match := &routeMatchRequest{request: httptest.NewRequest(http.MethodGet, "/", nil)}
+	started := time.Now()
+	if match.matches(matcher) {
+		t.Fatal("a filter_func that never returns matched")
+	}
+	if elapsed := time.Since(started); elapsed > 10*routeFilterTimeout {
+		t.Fatalf("filter_func ran for %s, want it stopped after %s", elapsed, routeFilterTimeout)
+	}
+
+	quick := mustCompileRouteMatcher(t, resource.Route{ID: "busy-route", FilterFunc: `function(vars) return true end`})
+	if !match.matches(quick) {
+		t.Fatal("filter_func did not match after an earlier call timed out")
+	}
+}
+
+func TestRouteFilterFuncGlobalsDoNotLeakBetweenCalls(t *testing.T) {
+	t.Parallel()
+
+	writer := mustCompileRouteMatcher(t, resource.Route{
+		ID:         "global-writer",
+		FilterFunc: `function(vars) leaked = true _G.shadowed = true tostring = nil return true end`,
+	})
+	reader := mustCompileRouteMatcher(t, resource.Route{
+		ID:         "global-reader",
+		FilterFunc: `function(vars) return leaked == nil and shadowed == nil and tostring ~= nil end`,
+	})
+	match := &routeMatchRequest{request: httptest.NewRequest(http.MethodGet, "/", nil)}
+	if !match.matches(writer) {
+		t.Fatal("global-writer did not match")
+	}
+	if !match.matches(reader) {
+		t.Fatal("a global written by another route's filter_func was visible")
+	}
+	again := mustCompileRouteMatcher(t, resource.Route{
+		ID:         "global-writer-repeat",
+		FilterFunc: `function(vars) local seen = leaked leaked = true return seen == nil end`,
+	})
+	for range 2 {
+		if !match.matches(again) {
+			t.Fatal("a global written by an earlier call of the same filter_func was visible")
+		}
+	}
+}
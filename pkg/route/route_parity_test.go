@@ -128,6 +128,7 @@ func TestBuildMapsUpstreamNodePriorities(t *testing.T) {
 		"http://127.0.0.1:18081": 1,
 	}
 	config, err := buildClusterConfigWithTransport(
+		nil,
 		resource.Route{},
 		resource.Upstream{Scheme: "http"},
 		map[string]int{
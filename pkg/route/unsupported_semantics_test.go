@@ -29,34 +29,6 @@ func TestBuildStrictRejectsUnsupportedRouteSemantics(t *testing.T) {
 			wantErr: "script",
 			routeID: "unsupported-script-route",
 		},
-		{
-			name:    "filter_func",
-			field:   "filter_func",
-			value:   `"return true"`,
-			wantErr: "filter_func",
-			routeID: "unsupported-filter-route",
-		},
-		{
-			name:    "vars",
-			field:   "vars",
-			value:   `[["http_user","==","ios"]]`,
-			wantErr: "vars",
-			routeID: "unsupported-vars-route",
-		},
-		{
-			name:    "remote_addrs",
-			field:   "remote_addrs",
-			value:   `["10.0.0.1"]`,
-			wantErr: "remote_addrs",
-			routeID: "unsupported-remote-addrs-route",
-		},
-		{
-			name:    "remote_addr",
-			field:   "remote_addr",
-			value:   `"10.0.0.1"`,
-			wantErr: "remote_addr",
-			routeID: "unsupported-remote-addr-route",
-		},
 		{
 			name:    "script_id",
 			field:   "script_id",
@@ -109,9 +81,13 @@ func TestProgrammaticSingularFieldsUseValuePresence(t *testing.T) {
 	if !remoteRoute.RemoteAddrConfigured() {
 		t.Fatal("RemoteAddrConfigured() = false, want non-empty programmatic remote_addr to be configured")
 	}
+	if err := validateRouteCompatibility(remoteRoute); err != nil {
+		t.Fatalf("validateRouteCompatibility() error = %v, want programmatic remote_addr accepted", err)
+	}
+	remoteRoute.RemoteAddrs = []string{"10.0.0.2"}
 	err := validateRouteCompatibility(remoteRoute)
 	if err == nil {
-		t.Fatal("validateRouteCompatibility() error = nil, want programmatic remote_addr rejection")
+		t.Fatal("validateRouteCompatibility() error = nil, want remote_addr and remote_addrs conflict")
 	}
 	if !strings.Contains(err.Error(), remoteRoute.ID) || !strings.Contains(err.Error(), "remote_addr") {
 		t.Fatalf("validateRouteCompatibility() error = %q, want route ID %q and field remote_addr", err, remoteRoute.ID)
@@ -132,14 +108,14 @@ func TestBuildStrictAllowsBlankFilterFunc(t *testing.T) {
 	}
 }
 
-func TestBuildStrictRejectsNestedNumericVars(t *testing.T) {
+func TestBuildStrictRejectsInvalidVars(t *testing.T) {
 	ensureRouteStore(t)
 	setHTTPPluginAllowlist(t)
-	const routeID = "nested-numeric-vars"
+	const routeID = "invalid-vars"
 	putRouteResource(
 		t,
 		routeID,
-		[]byte(`{"id":"nested-numeric-vars","uri":"/nested-numeric-vars","vars":[["arg_age","==",18]]}`),
+		[]byte(`{"id":"invalid-vars","uri":"/invalid-vars","vars":[["arg_age","in",18]]}`),
 	)
 
 	builder := NewBuilder(nil)
@@ -176,7 +152,7 @@ func TestBuildStrictAllowsEmptyVarsAndRemoteAddrs(t *testing.T) {
 	}
 }
 
-func TestBuildStrictRejectsVarsAndKeepsLastGoodHandler(t *testing.T) {
+func TestBuildStrictRejectsInvalidVarsAndKeepsLastGoodHandler(t *testing.T) {
 	ensureRouteStore(t)
 	setHTTPPluginAllowlist(t)
 	const routeID = "vars-last-good"
@@ -192,7 +168,7 @@ func TestBuildStrictRejectsVarsAndKeepsLastGoodHandler(t *testing.T) {
 	putRouteResource(
 		t,
 		routeID,
-		[]byte(`{"id":"vars-last-good","uri":"/vars-last-good","vars":[["http_user","==","ios"]]}`),
+		[]byte(`{"id":"vars-last-good","uri":"/vars-last-good","vars":[["http_user","=~","ios"]]}`),
 	)
 	invalidBuilder := NewBuilder(nil)
 	t.Cleanup(invalidBuilder.Stop)
@@ -220,7 +196,8 @@ func TestBuildReverseHandlerValidatesHTTPUpstreamTypes(t *testing.T) {
 		{name: "https empty type", scheme: "https", type_: "", wantOK: true},
 		{name: "grpc roundrobin", scheme: "grpc", type_: "roundrobin", wantOK: true},
 		{name: "grpcs empty type", scheme: "grpcs", type_: "", wantOK: true},
-		{name: "http chash", scheme: "http", type_: "chash"},
+		{name: "http least_conn", scheme: "http", type_: "least_conn", wantOK: true},
+		{name: "https ewma", scheme: "https", type_: "ewma", wantOK: true},
 		{name: "http random", scheme: "http", type_: "random"},
 		{name: "kafka owner", scheme: "kafka", type_: "chash", wantOK: true},
 	} {
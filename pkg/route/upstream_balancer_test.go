@@ -0,0 +1,164 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func TestBuildReverseHandlerValidatesCHashUpstream(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		upstream resource.Upstream
+		wantErr  string
+	}{
+		{
+			name:     "vars key",
+			upstream: resource.Upstream{Type: "chash", Key: "remote_addr"},
+		},
+		{
+			name:     "consumer without key",
+			upstream: resource.Upstream{Type: "chash", HashOn: "consumer"},
+		},
+		{
+			name:     "missing key",
+			upstream: resource.Upstream{Type: "chash", HashOn: "header"},
+			wantErr:  "key is required",
+		},
+		{
+			name:     "unknown hash_on",
+			upstream: resource.Upstream{Type: "chash", HashOn: "body", Key: "id"},
+			wantErr:  "hash_on must be one of",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			builder := &Builder{}
+			t.Cleanup(builder.Stop)
+			_, err := builder.buildReverseHandler(resource.Route{Upstream: test.upstream}, resource.Service{})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("buildReverseHandler() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("buildReverseHandler() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildReverseHandlerCHashPinsHeaderKeyToOneNode(t *testing.T) {
+	var nodes []resource.Node
+	for index := range 3 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Node", fmt.Sprint(index))
+		}))
+		t.Cleanup(server.Close)
+		nodes = append(nodes, upstreamNode(t, server.URL))
+	}
+
+	builder := &Builder{}
+	t.Cleanup(builder.Stop)
+	handler, err := builder.buildReverseHandler(resource.Route{Upstream: resource.Upstream{
+		Scheme: "http",
+		Type:   "chash",
+		HashOn: "header",
+		Key:    "X-Tenant",
+		Nodes:  nodes,
+	}}, resource.Service{})
+	if err != nil {
+		t.Fatalf("buildReverseHandler() error = %v", err)
+	}
+
+	serve := func(tenant string) string {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "http://gateway.test/sticky", nil)
+		request.Header.Set("X-Tenant", tenant)
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", recorder.Code)
+		}
+		return recorder.Header().Get("X-Node")
+	}
+
+	spread := map[string]struct{}{}
+	for index := range 16 {
+		tenant := fmt.Sprintf("tenant-%d", index)
+		node := serve(tenant)
+		for range 3 {
+			if got := serve(tenant); got != node {
+				t.Fatalf("tenant %q served by node %q, want sticky node %q", tenant, got, node)
+			}
+		}
+		spread[node] = struct{}{}
+	}
+	if len(spread) < 2 {
+		t.Fatalf("16 tenants landed on %d nodes, want hashing across nodes", len(spread))
+	}
+}
+
+func TestBuildReverseHandlerLeastConnAvoidsBusyNode(t *testing.T) {
+	release := make(chan struct{})
+	hits := make(chan string, 2)
+	var nodes []resource.Node
+	for index := range 2 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits <- fmt.Sprint(index)
+			<-release
+		}))
+		t.Cleanup(server.Close)
+		nodes = append(nodes, upstreamNode(t, server.URL))
+	}
+
+	builder := &Builder{}
+	t.Cleanup(builder.Stop)
+	handler, err := builder.buildReverseHandler(resource.Route{Upstream: resource.Upstream{
+		Scheme: "http",
+		Type:   "least_conn",
+		Nodes:  nodes,
+	}}, resource.Service{})
+	if err != nil {
+		t.Fatalf("buildReverseHandler() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var releaseOnce sync.Once
+	releaseAll := func() { releaseOnce.Do(func() { close(release) }) }
+	t.Cleanup(wg.Wait)
+	t.Cleanup(releaseAll)
+	serve := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, "http://gateway.test/long-poll", nil),
+			)
+		}()
+	}
+	wait := func() string {
+		select {
+		case node := <-hits:
+			return node
+		case <-time.After(5 * time.Second):
+			t.Fatal("upstream was not reached")
+			return ""
+		}
+	}
+
+	serve()
+	first := wait()
+	serve()
+	second := wait()
+	releaseAll()
+	if first == second {
+		t.Fatalf("least_conn sent both concurrent requests to node %q", first)
+	}
+}
@@ -0,0 +1,150 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/discovery"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+type mutableDiscoveryProvider struct {
+	mu    sync.Mutex
+	nodes []discovery.Node
+}
+
+func (p *mutableDiscoveryProvider) Nodes(context.Context, string) ([]discovery.Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]discovery.Node(nil), p.nodes...), nil
+}
+
+func (p *mutableDiscoveryProvider) set(nodes ...resource.Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes = p.nodes[:0]
+	for _, node := range nodes {
+		p.nodes = append(p.nodes, discovery.Node{Host: node.Host, Port: node.Port, Weight: node.Weight})
+	}
+}
+
+func TestBuildReverseHandlerResolvesDiscoveryAndRollsClustersOnMembershipChange(t *testing.T) {
+	backend := func(name string) resource.Node {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("X-Node", name)
+		}))
+		t.Cleanup(server.Close)
+		return upstreamNode(t, server.URL)
+	}
+	first, second := backend("first"), backend("second")
+
+	provider := &mutableDiscoveryProvider{}
+	provider.set(first)
+	registry, err := discovery.NewRegistry(nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	registry.Register("stub", provider, time.Hour)
+	clusters := pxy.NewClusterRegistry(pxy.NopClusterObserver{})
+	t.Cleanup(clusters.Close)
+
+	route := resource.Route{ID: "discovered", Upstream: resource.Upstream{
+		Scheme:        "http",
+		DiscoveryType: "stub",
+		ServiceName:   "orders",
+	}}
+	build := func() (*Builder, http.Handler) {
+		builder := NewBuilderWithClusterRegistry(nil, "", clusters).WithDiscovery(registry)
+		handler, err := builder.buildReverseHandler(route, resource.Service{})
+		if err != nil {
+			t.Fatalf("buildReverseHandler() error = %v", err)
+		}
+		return builder, handler
+	}
+	serve := func(handler http.Handler) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://gateway.test/", nil))
+		return recorder.Header().Get("X-Node")
+	}
+
+	pendingBuilder := NewBuilderWithClusterRegistry(nil, "", clusters).WithDiscovery(registry)
+	_, err = pendingBuilder.buildReverseHandler(route, resource.Service{})
+	if !errors.Is(err, discovery.ErrServicePending) {
+		t.Fatalf("first buildReverseHandler() error = %v, want pending service", err)
+	}
+	if !registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() did not seed the service leased by the failed build")
+	}
+
+	oldBuilder, oldHandler := build()
+	pendingBuilder.Stop()
+	if got := serve(oldHandler); got != "first" {
+		t.Fatalf("discovered upstream served by %q, want first", got)
+	}
+	unchangedBuilder, _ := build()
+	if clusters.Len() != 1 {
+		t.Fatalf("cluster count for an unchanged node set = %d, want shared cluster", clusters.Len())
+	}
+	unchangedBuilder.Stop()
+
+	provider.set(second)
+	if !registry.Refresh(context.Background(), "stub") {
+		t.Fatal("Refresh() did not report the membership change")
+	}
+	newBuilder, newHandler := build()
+	t.Cleanup(newBuilder.Stop)
+	if got := serve(newHandler); got != "second" {
+		t.Fatalf("rebuilt upstream served by %q, want second", got)
+	}
+	if clusters.Len() != 2 {
+		t.Fatalf("cluster count during rollover = %d, want old and new clusters", clusters.Len())
+	}
+	oldBuilder.Stop()
+	if clusters.Len() != 1 {
+		t.Fatalf("cluster count after retiring the old generation = %d, want 1", clusters.Len())
+	}
+	if registry.Len() != 1 {
+		t.Fatalf("leased services with one live generation = %d, want 1", registry.Len())
+	}
+	newBuilder.Stop()
+	if registry.Len() != 0 {
+		t.Fatalf("leased services after retiring every generation = %d, want 0", registry.Len())
+	}
+}
+
+func TestBuildReverseHandlerRejectsIncompleteDiscoveryReference(t *testing.T) {
+	registry, err := discovery.NewRegistry(nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+	registry.Register("stub", &mutableDiscoveryProvider{}, time.Hour)
+	for _, test := range []struct {
+		name     string
+		upstream resource.Upstream
+		wantErr  string
+	}{
+		{name: "missing service name", upstream: resource.Upstream{DiscoveryType: "stub"}, wantErr: "requires service_name"},
+		{name: "missing discovery type", upstream: resource.Upstream{ServiceName: "orders"}, wantErr: "requires discovery_type"},
+		{
+			name:     "unconfigured discovery",
+			upstream: resource.Upstream{DiscoveryType: "consul", ServiceName: "orders"},
+			wantErr:  `discovery "consul" is not configured`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			builder := NewBuilder(nil).WithDiscovery(registry)
+			t.Cleanup(builder.Stop)
+			_, err := builder.buildReverseHandler(resource.Route{ID: "r1", Upstream: test.upstream}, resource.Service{})
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || !strings.Contains(err.Error(), `"r1"`) {
+				t.Fatalf("buildReverseHandler() error = %v, want %q with route provenance", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package route
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/resolver"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+// ExpandUpstreamDomainNodes replaces every domain node with one node per
+// address cached in dns, keeping the node's port, weight and priority and
+// recording the domain in Node.Domain. IP nodes are returned unchanged. A
+// domain dns has not resolved yet fails with resolver.ErrPending.
+func ExpandUpstreamDomainNodes(dns *resolver.Lease, nodes []resource.Node) ([]resource.Node, error) {
+	expanded := make([]resource.Node, 0, len(nodes))
+	for _, node := range nodes {
+		host := strings.Trim(node.Host, "[]")
+		if _, err := netip.ParseAddr(host); err == nil || host == "" {
+			expanded = append(expanded, node)
+			continue
+		}
+		addrs, err := dns.LookupIP(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			resolved := node
+			resolved.Host = addr.String()
+			resolved.Domain = strings.ToLower(host)
+			expanded = append(expanded, resolved)
+		}
+	}
+	return expanded, nil
+}
+
+// resolveUpstreamDomains expands the domain nodes of an upstream into
+// per-address nodes so balancing, health checks and retries see every
+// address. The resolved targets feed the cluster key, so a changed DNS answer
+// acquires a new cluster on the next rebuild. A TLS upstream is expanded only
+// when all its nodes share one domain, which then becomes the TLS server
+// name; other TLS upstreams keep resolving their domains at dial time, as
+// does an upstream whose domain is not cached yet or failed to resolve until
+// the resolver's refresher sees the name resolve and triggers a rebuild.
+func (b *Builder) resolveUpstreamDomains(
+	upstream resource.Upstream,
+	provenance plugin.ResourceProvenance,
+) resource.Upstream {
+	if b.resolver == nil || strings.EqualFold(upstream.Scheme, "kafka") {
+		return upstream
+	}
+	if upstreamUsesTLS(upstream) && upstreamSingleDomain(upstream.Nodes) == "" {
+		return upstream
+	}
+	nodes, err := ExpandUpstreamDomainNodes(b.resolverLease, upstream.Nodes)
+	if err != nil {
+		if !errors.Is(err, resolver.ErrPending) {
+			logger.Warnf("resolve upstream nodes of %s %q, fall back to dial time: %s", provenance.Kind, provenance.ID, err)
+		}
+		return upstream
+	}
+	upstream.Nodes = nodes
+	return upstream
+}
+
+// upstreamSingleDomain returns the domain shared by every node, or empty when
+// a node is an address or the nodes name different domains.
+func upstreamSingleDomain(nodes []resource.Node) string {
+	domain := ""
+	for _, node := range nodes {
+		host := node.Domain
+		if host == "" {
+			host = strings.Trim(node.Host, "[]")
+			if _, err := netip.ParseAddr(host); err == nil {
+				return ""
+			}
+		}
+		host = strings.ToLower(host)
+		if domain != "" && host != domain {
+			return ""
+		}
+		domain = host
+	}
+	return domain
+}
+
+// applyUpstreamNodeDomains makes a target resolved from a domain present the
+// domain, not its address, as the node host.
+func applyUpstreamNodeDomains(targets map[string]compiledUpstreamTarget, domains map[string]string) {
+	for target, domain := range domains {
+		compiled, ok := targets[target]
+		if !ok {
+			continue
+		}
+		_, port, err := net.SplitHostPort(compiled.host)
+		if err != nil {
+			continue
+		}
+		compiled.nodeHost = upstreamNodeHost(compiled.scheme, domain, port)
+		targets[target] = compiled
+	}
+}
+
+// resolvedUpstreamDomain returns the domain every node was resolved from, or
+// empty when any node was configured as an address or names another domain.
+func resolvedUpstreamDomain(nodes []resource.Node) string {
+	if len(nodes) == 0 || nodes[0].Domain == "" {
+		return ""
+	}
+	for _, node := range nodes[1:] {
+		if node.Domain != nodes[0].Domain {
+			return ""
+		}
+	}
+	return nodes[0].Domain
+}
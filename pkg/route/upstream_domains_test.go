@@ -0,0 +1,187 @@
+package route
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/plugin"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/resolver"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func newHostsResolver(t *testing.T, hosts map[string][]netip.Addr) *resolver.Resolver {
+	t.Helper()
+	dns, err := resolver.New(resolver.Options{Nameservers: []string{"127.0.0.1:1"}, Hosts: hosts})
+	if err != nil {
+		t.Fatalf("resolver.New() error = %v", err)
+	}
+	return dns
+}
+
+func TestBuildReverseHandlerExpandsDomainNodesAndRollsClustersOnAnswerChange(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := first.Addr().(*net.TCPAddr).Port
+	second, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", strconv.Itoa(port)))
+	if err != nil {
+		_ = first.Close()
+		t.Skipf("127.0.0.2 is not usable on this host: %v", err)
+	}
+	backend := func(listener net.Listener, name string) {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Node", name)
+			w.Header().Set("X-Host", r.Host)
+		}))
+		_ = server.Listener.Close()
+		server.Listener = listener
+		server.Start()
+		t.Cleanup(server.Close)
+	}
+	backend(first, "first")
+	backend(second, "second")
+
+	clusters := pxy.NewClusterRegistry(pxy.NopClusterObserver{})
+	t.Cleanup(clusters.Close)
+	route := resource.Route{ID: "domain", Upstream: resource.Upstream{
+		Scheme:   "http",
+		PassHost: "node",
+		Nodes:    []resource.Node{{Host: "backend.test", Port: port, Weight: 1}},
+	}}
+	build := func(addr string) (*Builder, http.Handler) {
+		dns := newHostsResolver(t, map[string][]netip.Addr{"backend.test": {netip.MustParseAddr(addr)}})
+		builder := NewBuilderWithClusterRegistry(nil, "", clusters).WithResolver(dns)
+		handler, err := builder.buildReverseHandler(route, resource.Service{})
+		if err != nil {
+			t.Fatalf("buildReverseHandler() error = %v", err)
+		}
+		return builder, handler
+	}
+	serve := func(handler http.Handler) http.Header {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://gateway.test/", nil))
+		return recorder.Header()
+	}
+
+	oldBuilder, oldHandler := build("127.0.0.1")
+	header := serve(oldHandler)
+	if got := header.Get("X-Node"); got != "first" {
+		t.Fatalf("domain upstream served by %q, want first", got)
+	}
+	if got, want := header.Get("X-Host"), net.JoinHostPort("backend.test", strconv.Itoa(port)); got != want {
+		t.Fatalf("pass_host node Host = %q, want the node domain %q", got, want)
+	}
+
+	newBuilder, newHandler := build("127.0.0.2")
+	t.Cleanup(newBuilder.Stop)
+	if got := serve(newHandler).Get("X-Node"); got != "second" {
+		t.Fatalf("rebuilt upstream served by %q, want second", got)
+	}
+	if clusters.Len() != 2 {
+		t.Fatalf("cluster count after the answer changed = %d, want old and new clusters", clusters.Len())
+	}
+	oldBuilder.Stop()
+	if clusters.Len() != 1 {
+		t.Fatalf("cluster count after retiring the old generation = %d, want 1", clusters.Len())
+	}
+}
+
+func TestExpandUpstreamDomainNodesKeepsNodeAttributesPerAddress(t *testing.T) {
+	dns := newHostsResolver(t, map[string][]netip.Addr{
+		"api.test": {netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")},
+	})
+	lease := dns.Lease()
+	t.Cleanup(lease.Stop)
+	nodes, err := ExpandUpstreamDomainNodes(lease, []resource.Node{
+		{Host: "API.test", Port: 8080, Weight: 3, Priority: 1},
+		{Host: "[::1]", Port: 8081, Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("ExpandUpstreamDomainNodes() error = %v", err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("expanded nodes = %+v, want two resolved and one literal", nodes)
+	}
+	for index, want := range []string{"10.0.0.1", "10.0.0.2"} {
+		node := nodes[index]
+		if node.Host != want || node.Port != 8080 || node.Weight != 3 || node.Priority != 1 ||
+			node.Domain != "api.test" {
+			t.Fatalf("node %d = %+v, want %s:8080 weight 3 priority 1 from api.test", index, node, want)
+		}
+	}
+	if nodes[2].Host != "[::1]" || nodes[2].Domain != "" {
+		t.Fatalf("literal node = %+v, want it unchanged", nodes[2])
+	}
+
+	missing := []resource.Node{{Host: "missing.invalid", Port: 80}}
+	if _, err := ExpandUpstreamDomainNodes(lease, missing); !errors.Is(err, resolver.ErrPending) {
+		t.Fatalf("ExpandUpstreamDomainNodes() error = %v, want an uncached domain reported pending", err)
+	}
+	portless := []resource.Node{{Host: "api.test", Weight: 1}}
+	if nodes, err := ExpandUpstreamDomainNodes(lease, portless); err != nil || nodes[0].Port != 0 {
+		t.Fatalf("ExpandUpstreamDomainNodes(port-less) = %+v, %v, want A records without an SRV port", nodes, err)
+	}
+}
+
+func TestResolveUpstreamDomainsExpandsOnlyUnambiguousTLSUpstreams(t *testing.T) {
+	dns := newHostsResolver(t, map[string][]netip.Addr{
+		"api.test":   {netip.MustParseAddr("10.0.0.1")},
+		"other.test": {netip.MustParseAddr("10.0.0.2")},
+	})
+	builder := NewBuilder(nil).WithResolver(dns)
+	t.Cleanup(builder.Stop)
+
+	single := resource.Upstream{Scheme: "https", Nodes: []resource.Node{{Host: "api.test", Port: 443, Weight: 1}}}
+	provenance := plugin.ResourceProvenance{Kind: plugin.ResourceRoute, ID: "r1"}
+	resolved := builder.resolveUpstreamDomains(single, provenance)
+	if resolved.Nodes[0].Host != "10.0.0.1" || resolvedUpstreamDomain(resolved.Nodes) != "api.test" {
+		t.Fatalf("resolved nodes = %+v, want api.test expanded", resolved.Nodes)
+	}
+	servers := map[string]int{"https://10.0.0.1:443": 1}
+	named, err := buildClusterConfigWithSSLResolver(resource.Route{}, resolved, servers, nil)
+	if err != nil {
+		t.Fatalf("buildClusterConfig() error = %v", err)
+	}
+	unnamed := resolved
+	unnamed.Nodes = []resource.Node{{Host: "10.0.0.1", Port: 443, Weight: 1}}
+	literal, err := buildClusterConfigWithSSLResolver(resource.Route{}, unnamed, servers, nil)
+	if err != nil {
+		t.Fatalf("buildClusterConfig() error = %v", err)
+	}
+	namedKey, err := named.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	literalKey, err := literal.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namedKey == literalKey {
+		t.Fatal("expanded TLS upstream does not carry its domain as the TLS server name")
+	}
+
+	mixed := resource.Upstream{Scheme: "https", Nodes: []resource.Node{
+		{Host: "api.test", Port: 443, Weight: 1},
+		{Host: "other.test", Port: 443, Weight: 1},
+	}}
+	resolved = builder.resolveUpstreamDomains(mixed, provenance)
+	if resolved.Nodes[0].Host != "api.test" || resolved.Nodes[1].Host != "other.test" {
+		t.Fatalf("mixed-domain TLS nodes = %+v, want them left for dial-time resolution", resolved.Nodes)
+	}
+
+	unresolvable := resource.Upstream{
+		Scheme: "http",
+		Nodes:  []resource.Node{{Host: "missing.invalid", Port: 80, Weight: 1}},
+	}
+	resolved = builder.resolveUpstreamDomains(unresolvable, provenance)
+	if resolved.Nodes[0].Host != "missing.invalid" {
+		t.Fatalf("unresolvable nodes = %+v, want them left for dial-time resolution", resolved.Nodes)
+	}
+}
@@ -170,7 +170,7 @@ func TestBuildTransportOptionWithSSLResolverSupportsIDAndRejectsControls(t *test
 	base := resource.Upstream{Scheme: "https", TLS: &resource.UpstreamTLS{
 		ClientCertID: "ssl-1", Verify: true,
 	}}
-	if _, err := buildTransportOptionWithSSLResolver(resource.Route{}, base, resolver); err != nil {
+	if _, err := buildTransportOptionWithSSLResolver(nil, resource.Route{}, base, resolver); err != nil {
 		t.Fatalf("ID-based client certificate: %v", err)
 	}
 
@@ -221,7 +221,7 @@ func TestBuildTransportOptionWithSSLResolverSupportsIDAndRejectsControls(t *test
 					return resource.SSL{Status: 1}, nil
 				}
 			}
-			_, err := buildTransportOptionWithSSLResolver(resource.Route{}, test.upstream, resolve)
+			_, err := buildTransportOptionWithSSLResolver(nil, resource.Route{}, test.upstream, resolve)
 			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
 				t.Fatalf("buildTransportOptionWithSSLResolver() error = %v, want substring %q", err, test.wantErr)
 			}
@@ -69,8 +69,26 @@ func resolveUpstreamClientCertificate(
 	upstream resource.Upstream,
 	resolveSSL sslResolver,
 ) (tls.Certificate, error) {
+	clientCert, clientKey, err := ResolveUpstreamClientCertificatePEM(upstream, resolveSSL)
+	if err != nil || clientCert == "" {
+		return tls.Certificate{}, err
+	}
+	certificate, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parse upstream client certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// ResolveUpstreamClientCertificatePEM returns the client certificate and key
+// PEM an upstream presents, following client_cert_id through resolveSSL. Both
+// are empty when the upstream configures no client certificate.
+func ResolveUpstreamClientCertificatePEM(
+	upstream resource.Upstream,
+	resolveSSL func(string) (resource.SSL, error),
+) (string, string, error) {
 	if upstream.TLS == nil {
-		return tls.Certificate{}, nil
+		return "", "", nil
 	}
 
 	clientCert := upstream.TLS.ClientCert
@@ -78,52 +96,46 @@ func resolveUpstreamClientCertificate(
 	resolvedFromID := false
 	if upstream.TLS.ClientCertID != nil {
 		if clientCert != "" || clientKey != "" {
-			return tls.Certificate{}, fmt.Errorf(
+			return "", "", fmt.Errorf(
 				"upstream client_cert_id cannot be combined with client_cert or client_key",
 			)
 		}
 		id, err := normalizeSSLID(upstream.TLS.ClientCertID)
 		if err != nil {
-			return tls.Certificate{}, fmt.Errorf("invalid upstream client_cert_id: %w", err)
+			return "", "", fmt.Errorf("invalid upstream client_cert_id: %w", err)
 		}
 		if resolveSSL == nil {
-			return tls.Certificate{}, fmt.Errorf("upstream client_cert_id %q cannot be resolved", id)
+			return "", "", fmt.Errorf("upstream client_cert_id %q cannot be resolved", id)
 		}
 		ssl, err := resolveSSL(id)
 		if err != nil {
-			return tls.Certificate{}, fmt.Errorf("resolve upstream client_cert_id %q: %w", id, err)
+			return "", "", fmt.Errorf("resolve upstream client_cert_id %q: %w", id, err)
 		}
 		if ssl.Status == 0 {
-			return tls.Certificate{}, fmt.Errorf("upstream SSL resource %q is disabled", id)
+			return "", "", fmt.Errorf("upstream SSL resource %q is disabled", id)
 		}
 		clientCert = ssl.Cert
 		clientKey = ssl.Key
 		resolvedFromID = true
 	}
 	if (clientCert == "") != (clientKey == "") {
-		return tls.Certificate{}, fmt.Errorf("upstream client_cert and client_key must be configured together")
-	}
-	if clientCert == "" {
-		if resolvedFromID {
-			return tls.Certificate{}, fmt.Errorf("upstream SSL resource must contain client_cert and client_key")
-		}
-		return tls.Certificate{}, nil
+		return "", "", fmt.Errorf("upstream client_cert and client_key must be configured together")
 	}
-	certificate, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
-	if err != nil {
-		return tls.Certificate{}, fmt.Errorf("parse upstream client certificate: %w", err)
+	if clientCert == "" && resolvedFromID {
+		return "", "", fmt.Errorf("upstream SSL resource must contain client_cert and client_key")
 	}
-	return certificate, nil
+	return clientCert, clientKey, nil
 }
 
 func (b *Builder) buildTransportOption(
 	routeResource resource.Route,
 	upstream resource.Upstream,
 ) (proxy.TransportOption, error) {
-	return buildTransportOptionWithSSLResolver(routeResource, upstream, b.getSSL)
+	return buildTransportOptionWithSSLResolver(b.appConfig(), routeResource, upstream, b.getSSL)
 }
 
 func buildTransportOptionWithSSLResolver(
+	cfg *appconfig.Config,
 	routeResource resource.Route,
 	upstream resource.Upstream,
 	resolveSSL sslResolver,
@@ -149,18 +161,44 @@ func buildTransportOptionWithSSLResolver(
 		if len(certificate.Certificate) > 0 || certificate.PrivateKey != nil {
 			optionBuilder = optionBuilder.WithTLSClientCertificate(certificate)
 		}
+		if domain := resolvedUpstreamDomain(upstream.Nodes); domain != "" {
+			optionBuilder = optionBuilder.WithTLSServerName(domain)
+		}
 	}
 
-	if appconfig.GlobalConfig != nil {
-		proxyConfig := appconfig.GlobalConfig.Proxy
+	if cfg != nil {
+		proxyConfig := cfg.Proxy
 		optionBuilder = optionBuilder.
 			WithMaxIdleConnections(proxyConfig.MaxIdleConns).
 			WithMaxIdleConnectionsPerHost(proxyConfig.MaxIdleConnsPerHost).
 			WithMaxConnectionsPerHost(proxyConfig.MaxConnsPerHost)
 	}
+	if pool := upstream.KeepalivePool; pool != nil {
+		if err := validateKeepalivePool(*pool); err != nil {
+			return proxy.TransportOption{}, err
+		}
+		optionBuilder = optionBuilder.WithKeepalivePool(
+			pool.Size,
+			time.Duration(pool.IdleTimeout*float64(time.Second)),
+			pool.Requests,
+		)
+	}
 	return optionBuilder.Build(), nil
 }
 
+// validateKeepalivePool applies the APISIX `keepalive_pool` schema bounds.
+func validateKeepalivePool(pool resource.KeepalivePool) error {
+	switch {
+	case pool.Size < 1:
+		return fmt.Errorf("upstream keepalive_pool.size must be at least 1")
+	case pool.IdleTimeout < 0:
+		return fmt.Errorf("upstream keepalive_pool.idle_timeout must be non-negative")
+	case pool.Requests < 1:
+		return fmt.Errorf("upstream keepalive_pool.requests must be at least 1")
+	}
+	return nil
+}
+
 // buildClusterConfig converts the effective route/upstream configuration into
 // the immutable cluster config that owns transport reuse, capacity, health,
 // and retry behavior. The upstream ID/name participates in cluster identity
@@ -172,14 +210,16 @@ func buildClusterConfigWithSSLResolver(
 	resolveSSL sslResolver,
 	priorities ...map[string]int,
 ) (proxy.ClusterConfig, error) {
-	transport, err := buildTransportOptionWithSSLResolver(routeResource, upstream, resolveSSL)
+	cfg := appconfig.Global()
+	transport, err := buildTransportOptionWithSSLResolver(cfg, routeResource, upstream, resolveSSL)
 	if err != nil {
 		return proxy.ClusterConfig{}, err
 	}
-	return buildClusterConfigWithTransport(routeResource, upstream, servers, transport, priorities...)
+	return buildClusterConfigWithTransport(cfg, routeResource, upstream, servers, transport, priorities...)
 }
 
 func buildClusterConfigWithTransport(
+	cfg *appconfig.Config,
 	routeResource resource.Route,
 	upstream resource.Upstream,
 	servers map[string]int,
@@ -189,20 +229,18 @@ func buildClusterConfigWithTransport(
 	timeouts := resolveUpstreamTimeouts(routeResource.Timeout, upstream.Timeout)
 
 	maxInFlight := proxy.DefaultMaxInFlight
-	if appconfig.GlobalConfig != nil {
-		proxyConfig := appconfig.GlobalConfig.Proxy
-		if proxyConfig.MaxInFlight > 0 {
-			maxInFlight = proxyConfig.MaxInFlight
-		}
+	if cfg != nil && cfg.Proxy.MaxInFlight > 0 {
+		maxInFlight = cfg.Proxy.MaxInFlight
 	}
 
 	checks := upstream.Checks
-	if appconfig.GlobalConfig != nil && appconfig.GlobalConfig.Apisix.DisableUpstreamHealthcheck {
+	if cfg != nil && cfg.Apisix.DisableUpstreamHealthcheck {
 		checks = withoutActiveChecks(checks)
 	}
 
 	config := proxy.ClusterConfig{
 		Name:              upstreamMetricLabel(routeResource, upstream),
+		Type:              clusterBalancerType(upstream),
 		Targets:           servers,
 		Priorities:        firstPriorityMap(priorities),
 		Checks:            checks,
@@ -224,6 +262,17 @@ func buildClusterConfigWithTransport(
 	return config, nil
 }
 
+// clusterBalancerType returns the cluster balancer identity for an upstream.
+// Route compilation rejects unsupported HTTP types first; other owners, such
+// as traffic-split upstreams, keep weighted round robin for unknown types.
+func clusterBalancerType(upstream resource.Upstream) string {
+	balancerType, err := proxy.NormalizeBalancerType(upstream.Type)
+	if err != nil {
+		return ""
+	}
+	return balancerType
+}
+
 func firstPriorityMap(values []map[string]int) map[string]int {
 	if len(values) == 0 {
 		return nil
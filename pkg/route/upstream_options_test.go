@@ -1,6 +1,7 @@
 package route
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -239,3 +240,47 @@ func TestBuildClusterConfigOmitsActiveChecksWhenDisabled(t *testing.T) {
 		t.Fatal("passive checks removed by disable_upstream_healthcheck")
 	}
 }
+
+func TestBuildClusterConfigAppliesUpstreamKeepalivePool(t *testing.T) {
+	servers := map[string]int{"http://127.0.0.1:18091": 1}
+	base := resource.Upstream{Scheme: "http", Nodes: []resource.Node{
+		{Host: "127.0.0.1", Port: 18091, Weight: 1},
+	}}
+	unpooled, err := buildClusterConfigWithSSLResolver(resource.Route{}, base, servers, nil)
+	if err != nil {
+		t.Fatalf("buildClusterConfig() error = %v", err)
+	}
+	pooled := base
+	pooled.KeepalivePool = &resource.KeepalivePool{Size: 4, IdleTimeout: 10, Requests: 100}
+	config, err := buildClusterConfigWithSSLResolver(resource.Route{}, pooled, servers, nil)
+	if err != nil {
+		t.Fatalf("buildClusterConfig() error = %v", err)
+	}
+	unpooledKey, err := unpooled.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooledKey, err := config.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unpooledKey == pooledKey {
+		t.Fatal("keepalive_pool did not change the cluster key")
+	}
+
+	for _, test := range []struct {
+		pool resource.KeepalivePool
+		want string
+	}{
+		{pool: resource.KeepalivePool{Size: 0, IdleTimeout: 60, Requests: 1000}, want: "keepalive_pool.size"},
+		{pool: resource.KeepalivePool{Size: 1, IdleTimeout: -1, Requests: 1000}, want: "keepalive_pool.idle_timeout"},
+		{pool: resource.KeepalivePool{Size: 1, IdleTimeout: 60, Requests: 0}, want: "keepalive_pool.requests"},
+	} {
+		invalid := base
+		invalid.KeepalivePool = &test.pool
+		_, err := buildClusterConfigWithSSLResolver(resource.Route{}, invalid, servers, nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("buildClusterConfig(%+v) error = %v, want %s rejection", test.pool, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/admin"
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+// startAdminServer starts the Admin API listener when apisix.enable_admin is
+// set. With the etcd provider writes go to etcd and come back through the
+// watcher like any other writer's; the standalone providers write the Store
+// directly and republish the affected runtime here, since they register no
+// acknowledged store hook.
+func (s *Server) startAdminServer(ctx context.Context) error {
+	cfg := config.Global()
+	if cfg == nil || !cfg.Apisix.EnableAdmin {
+		return nil
+	}
+	var backend admin.Backend
+	if s.etcdClient != nil {
+		backend = admin.NewEtcdBackend(s.etcdClient.KV(), s.etcdClient.Prefix())
+	} else {
+		backend = admin.NewStoreBackend(s.storage, s.events, func(bucket string) error {
+			return s.publishAdminWrite(ctx, bucket)
+		})
+	}
+	handler, err := admin.NewHandler(backend, cfg.Deployment.Admin, cfg.Plugins)
+	if err != nil {
+		return fmt.Errorf("configure admin API: %w", err)
+	}
+	tlsConfig, err := admin.TLSConfig(cfg.Deployment.Admin)
+	if err != nil {
+		return fmt.Errorf("configure admin API TLS: %w", err)
+	}
+	address := admin.ListenAddress(cfg.Deployment.Admin)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen admin API address %q: %w", address, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	adminServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	s.lifecycleMu.Lock()
+	if s.shutdownRequested {
+		s.lifecycleMu.Unlock()
+		_ = listener.Close()
+		return context.Canceled
+	}
+	s.adminServer = adminServer
+	s.lifecycleMu.Unlock()
+
+	logger.Infof("admin API listening on %s", address)
+	go func() {
+		if err := adminServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("admin API server stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// publishAdminWrite rebuilds the runtime a standalone Admin API write
+// affects, so the write is live by the time the API answers.
+func (s *Server) publishAdminWrite(ctx context.Context, bucket string) error {
+	if store.IsHTTPRouteReloadBucket(bucket) {
+		if err := s.reloadAcknowledgedHTTP(ctx); err != nil {
+			return err
+		}
+	}
+	if store.IsStreamReloadBucket(bucket) {
+		if _, err := s.reloadStreamRoutesIfStarted(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+func TestStandaloneAdminWritePublishesRoutesBeforeResponding(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Upstream", "orders")
+	}))
+	t.Cleanup(upstream.Close)
+
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	address := probe.Addr().String()
+	_ = probe.Close()
+
+	previousConfig := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previousConfig })
+	config.GlobalConfig = &config.Config{
+		Plugins: []string{"proxy-rewrite"},
+		Apisix:  config.Apisix{EnableAdmin: true},
+		Deployment: config.Deployment{
+			Role:          "data_plane",
+			RoleDataPlane: config.RoleConfig{ConfigProvider: "yaml"},
+			Admin: config.Admin{
+				AdminKeyRequired: true,
+				AdminKey:         []config.AdminKey{{Name: "admin", Key: "admin-key", Role: "admin"}},
+				AdminListen:      config.AdminListen{IP: "127.0.0.1", Port: port},
+			},
+		},
+	}
+
+	events := make(chan *store.Event)
+	storage, err := store.Open(filepath.Join(t.TempDir(), "admin.db"), events)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	storage.Start()
+	previousStore := store.ReplaceGlobalStoreForTest(storage)
+	t.Cleanup(func() { store.ReplaceGlobalStoreForTest(previousStore) })
+	server := &Server{
+		server:          &http.Server{},
+		routes:          newRouteHandler(http.NotFoundHandler(), nil),
+		clusters:        pxy.NewClusterRegistry(pxy.NopClusterObserver{}),
+		storage:         storage,
+		events:          events,
+		reloadEventChan: make(chan struct{}, 1),
+	}
+	t.Cleanup(func() { _ = server.Shutdown(context.Background()) })
+	if err := server.startAdminServer(context.Background()); err != nil {
+		t.Fatalf("startAdminServer() error = %v", err)
+	}
+
+	body := `{"uri": "/orders", "upstream": {"type": "roundrobin", "nodes": {"` +
+		strings.TrimPrefix(upstream.URL, "http://") + `": 1}}}`
+	request, _ := http.NewRequest(http.MethodPut,
+		"http://"+address+"/apisix/admin/routes/orders", strings.NewReader(body))
+	request.Header.Set("X-API-KEY", "admin-key")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("PUT route: %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT route status = %d, want 201", response.StatusCode)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.routes.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://gateway.test/orders", nil))
+	if recorder.Header().Get("X-Upstream") != "orders" {
+		t.Fatalf("route served status %d without upstream header, want the admin route published", recorder.Code)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if _, err := http.Get("http://" + address + "/apisix/admin/routes"); err == nil {
+		t.Fatal("admin API still accepts connections after Shutdown()")
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/observability/metrics"
+	"github.com/wklken/apisix-go/pkg/route"
+)
+
+// ReloadConfig applies a re-read config.yaml to the running server. The next
+// generation is prepared against the candidate: routes are rebuilt against its
+// plugin allowlist and plugin_attr, added listeners are bound, and the stream
+// runtime is reconfigured. The candidate is published as config.Global() only
+// once nothing can fail, so a failed reload leaves both the configuration and
+// the serving generation untouched. On success the next HTTP server takes over
+// the kept listeners while the previous one drains its in-flight requests.
+func (s *Server) ReloadConfig(ctx context.Context, cfg *config.Config) (reloadErr error) {
+	if cfg == nil {
+		return fmt.Errorf("reload config: config must not be nil")
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if ctx != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.lifecycleMu.Lock()
+	shuttingDown := s.shutdownRequested
+	s.lifecycleMu.Unlock()
+	if shuttingDown {
+		return fmt.Errorf("reload config: server is shutting down")
+	}
+	s.httpMu.Lock()
+	current := s.httpBindings
+	s.httpMu.Unlock()
+	if current == nil {
+		return fmt.Errorf("reload config: server is not serving")
+	}
+
+	previous := config.Global()
+	if err := validateConfigReload(previous, cfg); err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			reloadErr = fmt.Errorf("reload config panic: %v", recovered)
+		}
+	}()
+
+	addrs := cfg.Apisix.ListenAddresses()
+	plan, err := planHTTPListeners(addrs, configuredTLSListenAddresses(cfg))
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	plan, err = planProxyProtocolListeners(plan, cfg)
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	var tlsConfig *tls.Config
+	if planServesTLS(plan) {
+		tlsConfig, err = buildFrontendTLSConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("reload config: build frontend TLS config: %w", err)
+		}
+	}
+	var http3TLSConfig *tls.Config
+	if len(configuredHTTP3ListenAddresses(cfg)) > 0 {
+		http3TLSConfig, err = buildHTTP3TLSConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("reload config: build HTTP/3 TLS config: %w", err)
+		}
+	}
+
+	builder := route.NewBuilderWithClusterRegistry(s.storage, addrs[0], s.clusters).
+		WithDiscovery(s.discovery).
+		WithResolver(s.resolver).
+		WithExtPluginRunner(s.extPluginRunner).
+		WithWasmModules(s.wasmModules).
+		WithConfig(cfg)
+	installed := false
+	defer func() {
+		if !installed {
+			builder.Stop()
+		}
+	}()
+	handler, err := builder.BuildWithRouteQuarantine()
+	if err != nil {
+		return fmt.Errorf("reload config: build routes: %w", err)
+	}
+
+	bindings, opened, err := s.bindHTTPListeners(plan, current)
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	// The stream runtime is the last step that can fail; everything after it
+	// only publishes prepared state.
+	if err := s.reconfigureStreamProxy(cfg); err != nil {
+		s.closeSharedListeners(opened)
+		return fmt.Errorf("reload config: %w", err)
+	}
+
+	config.SetGlobal(cfg)
+	s.routes.Replace(handler, builder.Stop)
+	installed = true
+	recordRouteBuildQuarantine(builder)
+	s.publishRouteGeneration(builder)
+
+	next := newConfiguredHTTPServer(newConfiguredHTTPHandler(s.routes, cfg), cfg)
+	var removed []*sharedListener
+	for address, shared := range current {
+		if _, ok := bindings[address]; !ok {
+			removed = append(removed, shared)
+		}
+	}
+	s.httpMu.Lock()
+	retired := s.server
+	s.server = next
+	s.httpBindings = bindings
+	if retired != nil {
+		if s.drainingServers == nil {
+			s.drainingServers = make(map[*http.Server]struct{})
+		}
+		s.drainingServers[retired] = struct{}{}
+	}
+	s.httpMu.Unlock()
+	s.addr = addrs[0]
+	s.addrs = addrs
+	if http3TLSConfig != nil {
+		s.http3TLSConfig.Store(http3TLSConfig)
+	}
+	metrics.SetConfigApplyStreamRequired(streamProxyModeEnabled(cfg))
+
+	s.serveHTTPGeneration(next, cfg, plan, bindings, tlsConfig)
+	if retired != nil {
+		// Removed sockets close only after the retired generation has closed
+		// its views, so its Serve loops end with http.ErrServerClosed.
+		retired.RegisterOnShutdown(func() { s.closeSharedListeners(removed) })
+		go s.drainHTTPServer(retired)
+	} else {
+		s.closeSharedListeners(removed)
+	}
+	logger.Infof("config reloaded, listening on %v", addrs)
+	return nil
+}
+
+func (s *Server) drainHTTPServer(server *http.Server) {
+	if err := server.Shutdown(context.Background()); err != nil {
+		logger.Errorf("drain retired HTTP server: %s", err)
+	}
+	s.httpMu.Lock()
+	delete(s.drainingServers, server)
+	s.httpMu.Unlock()
+}
+
+// reconfigureStreamProxy moves the stream runtime to cfg: it starts the
+// runtime when stream mode was enabled, reconfigures listeners and stream
+// plugins in place, or closes the runtime when stream mode was disabled.
+func (s *Server) reconfigureStreamProxy(cfg *config.Config) error {
+	s.streamReloadMu.Lock()
+	runtime := s.streamRuntime
+	if runtime == nil {
+		s.streamReloadMu.Unlock()
+		return s.startStreamProxy(context.Background(), cfg)
+	}
+	defer s.streamReloadMu.Unlock()
+	if !streamProxyModeEnabled(cfg) {
+		s.streamRuntime = nil
+		s.streamRoutes = nil
+		s.replaceStreamDNSLease(nil)
+		metrics.SetStreamRoutes(nil)
+		go func() {
+			if err := runtime.Close(context.Background()); err != nil {
+				logger.Errorf("stop stream runtime after config reload: %s", err)
+			}
+		}()
+		return nil
+	}
+	if err := validateStreamProxyConfig(cfg); err != nil {
+		return err
+	}
+	err := runtime.ReconfigureWithTLS(
+		streamListenConfig(cfg),
+		streamTLSConfigProvider(cfg),
+		s.streamRoutes,
+		cfg.StreamPlugins,
+	)
+	if err != nil {
+		return fmt.Errorf("reconfigure stream proxy: %w", err)
+	}
+	return nil
+}
+
+// validateConfigReload rejects changes to settings that are bound once at
+// startup: the config provider and deployment, service discovery, the
+// external plugin runner, the WASM plugins, the DNS resolver, the HTTP/3
+// listeners, the shared dicts, the logger spool, the Admin and Control APIs,
+// data encryption, the Prometheus export server and the limit-count gossip
+// node.
+func validateConfigReload(previous, next *config.Config) error {
+	if previous == nil {
+		return nil
+	}
+	for _, setting := range []struct {
+		field   string
+		changed bool
+	}{
+		{field: "deployment", changed: !reflect.DeepEqual(previous.Deployment, next.Deployment)},
+		{field: "discovery", changed: !reflect.DeepEqual(previous.Discovery, next.Discovery)},
+		{field: "ext-plugin", changed: !reflect.DeepEqual(previous.ExtPlugin, next.ExtPlugin)},
+		{field: "wasm", changed: !reflect.DeepEqual(previous.Wasm, next.Wasm)},
+		{
+			field: "apisix.dns_resolver",
+			changed: !slices.Equal(previous.Apisix.DnsResolver, next.Apisix.DnsResolver) ||
+				previous.Apisix.DnsResolverValid != next.Apisix.DnsResolverValid ||
+				previous.Apisix.ResolverTimeout != next.Apisix.ResolverTimeout ||
+				previous.Apisix.EnableResolvSearchOpt != next.Apisix.EnableResolvSearchOpt,
+		},
+		{
+			field:   "the apisix.ssl.listen HTTP/3 listeners",
+			changed: !slices.Equal(configuredHTTP3ListenAddresses(previous), configuredHTTP3ListenAddresses(next)),
+		},
+		{
+			field:   "nginx_config.http.lua_shared_dict",
+			changed: !maps.Equal(previous.NginxConfig.HTTP.LuaSharedDict, next.NginxConfig.HTTP.LuaSharedDict),
+		},
+		{
+			field: "nginx_config.http.custom_lua_shared_dict",
+			changed: !maps.Equal(
+				previous.NginxConfig.HTTP.CustomLuaSharedDict,
+				next.NginxConfig.HTTP.CustomLuaSharedDict,
+			),
+		},
+		{field: "apisix.log_spool", changed: previous.Apisix.LogSpool != next.Apisix.LogSpool},
+		{field: "apisix.enable_admin", changed: previous.Apisix.EnableAdmin != next.Apisix.EnableAdmin},
+		{
+			field: "apisix.control",
+			changed: previous.Apisix.EnableControl != next.Apisix.EnableControl ||
+				previous.Apisix.Control != next.Apisix.Control,
+		},
+		{
+			field:   "apisix.data_encryption",
+			changed: !reflect.DeepEqual(previous.Apisix.DataEncryption, next.Apisix.DataEncryption),
+		},
+		{field: "the prometheus plugin", changed: prometheusEnabled(previous) != prometheusEnabled(next)},
+		{
+			field:   "plugin_attr.prometheus",
+			changed: !reflect.DeepEqual(previous.PluginAttr["prometheus"], next.PluginAttr["prometheus"]),
+		},
+		{
+			field:   "plugin_attr.limit-count",
+			changed: !reflect.DeepEqual(previous.PluginAttr["limit-count"], next.PluginAttr["limit-count"]),
+		},
+	} {
+		if setting.changed {
+			return fmt.Errorf("changing %s requires a restart", setting.field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/store"
+	"github.com/wklken/apisix-go/pkg/version"
+)
+
+func reserveReloadPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	return port
+}
+
+func reloadTestConfig(serverTokens bool, ports ...int) *config.Config {
+	cfg := &config.Config{Plugins: []string{"request-id"}}
+	cfg.Apisix.ProxyMode = "http"
+	cfg.Apisix.EnableServerTokens = serverTokens
+	for _, port := range ports {
+		cfg.Apisix.NodeListen = append(cfg.Apisix.NodeListen, config.NodeListen{Ip: "127.0.0.1", Port: port})
+	}
+	return cfg
+}
+
+func reloadTestAddress(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+// startReloadTestServer serves handler on cfg's listeners until the test ends.
+func startReloadTestServer(t *testing.T, cfg *config.Config, handler http.Handler) *Server {
+	t.Helper()
+	previous := config.GlobalConfig
+	config.GlobalConfig = cfg
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	storage, err := store.Open(t.TempDir()+"/config-reload.db", make(chan *store.Event))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	storage.Start()
+	routes := newRouteHandler(handler, nil)
+	addrs := cfg.Apisix.ListenAddresses()
+	server := &Server{
+		addr:    addrs[0],
+		addrs:   addrs,
+		server:  newConfiguredHTTPServer(newConfiguredHTTPHandler(routes, cfg), cfg),
+		routes:  routes,
+		storage: storage,
+	}
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- server.startHTTPListeners(t.Context()) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := server.shutdown(ctx); err != nil {
+			t.Errorf("shutdown() error = %v", err)
+		}
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		server.httpMu.Lock()
+		serving := server.httpBindings != nil
+		server.httpMu.Unlock()
+		if serving {
+			return server
+		}
+		select {
+		case err := <-serveDone:
+			t.Fatalf("startHTTPListeners() error = %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("HTTP listeners did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func reloadTestGet(t *testing.T, address string) (int, string) {
+	t.Helper()
+	client := &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	response, err := client.Get("http://" + address + "/reload")
+	if err != nil {
+		t.Fatalf("GET %s: %v", address, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	_, _ = io.Copy(io.Discard, response.Body)
+	return response.StatusCode, response.Header.Get("Server")
+}
+
+func TestReloadConfigSwapsListenersAndDrainsInFlightRequests(t *testing.T) {
+	kept, added := reserveReloadPort(t), reserveReloadPort(t)
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	server := startReloadTestServer(t, reloadTestConfig(false, kept), http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			close(requestStarted)
+			<-releaseRequest
+			w.WriteHeader(http.StatusAccepted)
+		},
+	))
+
+	inFlight := make(chan int, 1)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		response, err := client.Get("http://" + reloadTestAddress(kept) + "/reload")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		_ = response.Body.Close()
+		inFlight <- response.StatusCode
+	}()
+	<-requestStarted
+
+	next := reloadTestConfig(true, kept, added)
+	if err := server.ReloadConfig(context.Background(), next); err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	if config.GlobalConfig != next {
+		t.Fatal("ReloadConfig() did not publish the reloaded config")
+	}
+	for _, port := range []int{kept, added} {
+		status, token := reloadTestGet(t, reloadTestAddress(port))
+		if status != http.StatusNotFound {
+			t.Fatalf("GET on port %d status = %d, want 404 from the rebuilt routes", port, status)
+		}
+		if want := "APISIX/" + version.Version; token != want {
+			t.Fatalf("GET on port %d Server = %q, want %q from the reloaded handler chain", port, token, want)
+		}
+	}
+
+	close(releaseRequest)
+	select {
+	case status := <-inFlight:
+		if status != http.StatusAccepted {
+			t.Fatalf("in-flight request status = %d, want 202 from the retired generation", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("in-flight request did not complete after reload")
+	}
+
+	if err := server.ReloadConfig(context.Background(), reloadTestConfig(true, added)); err != nil {
+		t.Fatalf("ReloadConfig() removing a listener error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", reloadTestAddress(kept), 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		_ = conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("removed listener still accepts connections")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status, _ := reloadTestGet(t, reloadTestAddress(added)); status != http.StatusNotFound {
+		t.Fatalf("GET on remaining listener status = %d, want 404", status)
+	}
+}
+
+func TestReloadConfigRollsBackOnListenerBindFailure(t *testing.T) {
+	port := reserveReloadPort(t)
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("occupy listener: %v", err)
+	}
+	defer func() { _ = occupied.Close() }()
+	added := reserveReloadPort(t)
+	cfg := reloadTestConfig(false, port)
+	server := startReloadTestServer(t, cfg, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serving := server.server
+
+	next := reloadTestConfig(true, port, added, occupied.Addr().(*net.TCPAddr).Port)
+	err = server.ReloadConfig(context.Background(), next)
+	if err == nil || !strings.Contains(err.Error(), occupied.Addr().String()) {
+		t.Fatalf("ReloadConfig() error = %v, want the occupied address", err)
+	}
+	if config.GlobalConfig != cfg {
+		t.Fatal("ReloadConfig() left the rejected config published")
+	}
+	if server.server != serving {
+		t.Fatal("ReloadConfig() replaced the HTTP server after a bind failure")
+	}
+	probe, err := net.Listen("tcp", reloadTestAddress(added))
+	if err != nil {
+		t.Fatalf("listener opened by the failed reload remained bound: %v", err)
+	}
+	_ = probe.Close()
+	status, token := reloadTestGet(t, reloadTestAddress(port))
+	if status != http.StatusNoContent || token != "APISIX" {
+		t.Fatalf("GET after failed reload = %d %q, want 204 from the previous generation", status, token)
+	}
+}
+
+func TestReloadConfigRollsBackOnStreamFailure(t *testing.T) {
+	port := reserveReloadPort(t)
+	added := reserveReloadPort(t)
+	cfg := reloadTestConfig(false, port)
+	cfg.Apisix.ProxyMode = "http&stream"
+	cfg.Apisix.StreamProxy.Tcp = []config.TcpListen{{Addr: "127.0.0.1:9100"}}
+	server := startReloadTestServer(t, cfg, http.NotFoundHandler())
+	streamErr := errors.New("stream bind failed")
+	runtime := &fakeStreamRuntime{reconfigureErr: streamErr}
+	server.streamRuntime = runtime
+
+	next := reloadTestConfig(false, port, added)
+	next.Apisix.ProxyMode = "http&stream"
+	next.Apisix.StreamProxy.Tcp = []config.TcpListen{{Addr: "127.0.0.1:9101"}}
+	if err := server.ReloadConfig(context.Background(), next); !errors.Is(err, streamErr) {
+		t.Fatalf("ReloadConfig() error = %v, want stream failure", err)
+	}
+	if config.GlobalConfig != cfg {
+		t.Fatal("ReloadConfig() left the rejected config published")
+	}
+	if runtime.publishedAtReconfigure != cfg {
+		t.Fatal("ReloadConfig() published the candidate before the stream runtime accepted it")
+	}
+	probe, err := net.Listen("tcp", reloadTestAddress(added))
+	if err != nil {
+		t.Fatalf("HTTP listener opened before the stream failure remained bound: %v", err)
+	}
+	_ = probe.Close()
+
+	runtime.reconfigureErr = nil
+	next.StreamPlugins = []string{"ip-restriction"}
+	if err := server.ReloadConfig(context.Background(), next); err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	if len(runtime.reconfigured.Tcp) != 1 || runtime.reconfigured.Tcp[0].Addr != "127.0.0.1:9101" {
+		t.Fatalf("stream listeners = %#v, want the reloaded listener", runtime.reconfigured)
+	}
+	if len(runtime.streamPlugins) != 1 || runtime.streamPlugins[0] != "ip-restriction" {
+		t.Fatalf("stream plugins = %v, want the reloaded allowlist", runtime.streamPlugins)
+	}
+}
+
+func TestReloadConfigRequiresServingServer(t *testing.T) {
+	server := &Server{routes: newRouteHandler(http.NotFoundHandler(), nil)}
+	if err := server.ReloadConfig(context.Background(), reloadTestConfig(false, 9080)); err == nil {
+		t.Fatal("ReloadConfig() error = nil before the HTTP listeners started")
+	}
+}
+
+func TestValidateConfigReloadRejectsRestartOnlySettings(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		mutate func(*config.Config)
+		field  string
+	}{
+		{
+			name:   "deployment role",
+			mutate: func(cfg *config.Config) { cfg.Deployment.Role = "data_plane" },
+			field:  "deployment",
+		},
+		{
+			name:   "discovery",
+			mutate: func(cfg *config.Config) { cfg.Discovery = config.Discovery{"dns": map[string]any{}} },
+			field:  "discovery",
+		},
+		{
+			name: "wasm plugins",
+			mutate: func(cfg *config.Config) {
+				cfg.Wasm.Plugins = []config.WasmPlugin{{Name: "wasm_log", File: "log.wasm"}}
+			},
+			field: "wasm",
+		},
+		{
+			name:   "DNS nameservers",
+			mutate: func(cfg *config.Config) { cfg.Apisix.DnsResolver = []string{"10.0.0.53"} },
+			field:  "apisix.dns_resolver",
+		},
+		{
+			name:   "DNS search option",
+			mutate: func(cfg *config.Config) { cfg.Apisix.EnableResolvSearchOpt = true },
+			field:  "apisix.dns_resolver",
+		},
+		{
+			name: "HTTP/3 listener",
+			mutate: func(cfg *config.Config) {
+				cfg.Apisix.Ssl.Enable = true
+				cfg.Apisix.Ssl.Listen = []config.Listen{{Port: 9443, EnableHttp3: true}}
+			},
+			field: "HTTP/3 listeners",
+		},
+		{
+			name: "shared dicts",
+			mutate: func(cfg *config.Config) {
+				cfg.NginxConfig.HTTP.CustomLuaSharedDict = map[string]string{"counters": "1m"}
+			},
+			field: "nginx_config.http.custom_lua_shared_dict",
+		},
+		{
+			name: "plugin shared dicts",
+			mutate: func(cfg *config.Config) {
+				cfg.NginxConfig.HTTP.LuaSharedDict = map[string]string{"plugin-limit-req": "20m"}
+			},
+			field: "nginx_config.http.lua_shared_dict",
+		},
+		{
+			name:   "admin",
+			mutate: func(cfg *config.Config) { cfg.Apisix.EnableAdmin = true },
+			field:  "apisix.enable_admin",
+		},
+		{
+			name:   "control API address",
+			mutate: func(cfg *config.Config) { cfg.Apisix.Control.Port = 9091 },
+			field:  "apisix.control",
+		},
+		{
+			name:   "prometheus plugin",
+			mutate: func(cfg *config.Config) { cfg.Plugins = append(cfg.Plugins, "prometheus") },
+			field:  "the prometheus plugin",
+		},
+		{
+			name: "prometheus export address",
+			mutate: func(cfg *config.Config) {
+				cfg.PluginAttr = map[string]map[string]any{"prometheus": {"export_uri": "/metrics"}}
+			},
+			field: "plugin_attr.prometheus",
+		},
+		{
+			name: "limit-count gossip node",
+			mutate: func(cfg *config.Config) {
+				cfg.PluginAttr = map[string]map[string]any{"limit-count": {"gossip": map[string]any{"listen": ":7946"}}}
+			},
+			field: "plugin_attr.limit-count",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			next := reloadTestConfig(false, 9080)
+			test.mutate(next)
+			err := validateConfigReload(reloadTestConfig(false, 9080), next)
+			if err == nil || !strings.Contains(err.Error(), test.field) {
+				t.Fatalf("validateConfigReload() error = %v, want %s rejection", err, test.field)
+			}
+		})
+	}
+
+	next := reloadTestConfig(true, 9080, 9081)
+	next.Plugins = append(next.Plugins, "cors")
+	next.PluginAttr = map[string]map[string]any{"log-rotate": {"interval": 60}}
+	next.NginxConfig.HTTP.ClientMaxBodySize = 1024
+	if err := validateConfigReload(reloadTestConfig(false, 9080), next); err != nil {
+		t.Fatalf("validateConfigReload() error = %v for reloadable settings", err)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/control"
+	"github.com/wklken/apisix-go/pkg/logger"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/route"
+)
+
+// startControlServer starts the Control API listener when
+// apisix.enable_control is set. It is unauthenticated, so the default
+// address is loopback only.
+func (s *Server) startControlServer() error {
+	cfg := config.Global()
+	if cfg == nil || !cfg.Apisix.EnableControl {
+		return nil
+	}
+	address := control.ListenAddress(cfg.Apisix.Control)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen control API address %q: %w", address, err)
+	}
+	controlServer := &http.Server{
+		Handler:           control.NewHandler(controlSource{server: s}),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	s.lifecycleMu.Lock()
+	if s.shutdownRequested {
+		s.lifecycleMu.Unlock()
+		_ = listener.Close()
+		return context.Canceled
+	}
+	s.controlServer = controlServer
+	s.lifecycleMu.Unlock()
+
+	logger.Infof("control API listening on %s", address)
+	go func() {
+		if err := controlServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("control API server stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+type generationAwareRouteBuilder interface {
+	Generation() *route.Generation
+}
+
+// publishRouteGeneration records the generation of an installed builder for
+// the Control API.
+func (s *Server) publishRouteGeneration(builder any) {
+	if aware, ok := builder.(generationAwareRouteBuilder); ok {
+		s.routeGeneration.Store(aware.Generation())
+	}
+}
+
+// controlSource exposes the serving route generation and the shared cluster
+// registry to the Control API.
+type controlSource struct {
+	server *Server
+}
+
+func (c controlSource) RouteGeneration() *route.Generation {
+	return c.server.routeGeneration.Load()
+}
+
+func (c controlSource) ClusterHealth() []pxy.ClusterHealth {
+	if c.server.clusters == nil {
+		return nil
+	}
+	return c.server.clusters.Health()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/controlplane"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/server_info"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+// controlPlaneStartupTimeout bounds how long a data plane waits for its first
+// configuration from a control plane before startup fails.
+const controlPlaneStartupTimeout = 30 * time.Second
+
+func confServerEnabled(cfg *config.Config) bool {
+	return cfg != nil && strings.EqualFold(strings.TrimSpace(cfg.Deployment.Role), "control_plane") &&
+		cfg.Deployment.RoleControlPlane.ConfServer.Listen != ""
+}
+
+func controlPlaneConfigProvider(cfg *config.Config) bool {
+	provider, err := config.EffectiveConfigProvider(cfg)
+	return err == nil && provider == "control_plane"
+}
+
+// etcdWatcherEvents returns the channel the etcd watcher sends its batches to.
+// A control plane with a conf_server relays them through a hub, which applies
+// each one to the Store and then streams it to the data planes.
+func (s *Server) etcdWatcherEvents(ctx context.Context) chan *store.Event {
+	if !confServerEnabled(config.Global()) {
+		return s.events
+	}
+	hub := controlplane.NewHub(s.storage.ManagedSnapshot)
+	relay := make(chan *store.Event)
+	s.lifecycleMu.Lock()
+	s.controlPlaneHub = hub
+	s.lifecycleMu.Unlock()
+	go hub.Relay(ctx, relay, s.events)
+	return relay
+}
+
+// startConfServer starts the mTLS listener data planes stream their
+// configuration from.
+func (s *Server) startConfServer() error {
+	cfg := config.Global()
+	s.lifecycleMu.Lock()
+	hub := s.controlPlaneHub
+	s.lifecycleMu.Unlock()
+	if !confServerEnabled(cfg) || hub == nil {
+		return nil
+	}
+	confServerConfig := cfg.Deployment.RoleControlPlane.ConfServer
+	tlsConfig, err := controlplane.NewServerTLSConfig(
+		confServerConfig.Cert,
+		confServerConfig.CertKey,
+		confServerConfig.ClientCACert,
+	)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", confServerConfig.Listen)
+	if err != nil {
+		return fmt.Errorf("listen conf_server address %q: %w", confServerConfig.Listen, err)
+	}
+	confServer := &http.Server{
+		Handler:           hub.Handler(),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	s.lifecycleMu.Lock()
+	if s.shutdownRequested {
+		s.lifecycleMu.Unlock()
+		_ = listener.Close()
+		return context.Canceled
+	}
+	s.confServer = confServer
+	s.lifecycleMu.Unlock()
+
+	logger.Infof("conf_server listening on %s", confServerConfig.Listen)
+	go func() {
+		if err := confServer.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("conf_server stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// shutdownConfServer ends the data plane streams before shutting the listener
+// down; http.Server.Shutdown does not wait for hijacked connections.
+func (s *Server) shutdownConfServer(ctx context.Context) error {
+	s.lifecycleMu.Lock()
+	hub := s.controlPlaneHub
+	confServer := s.confServer
+	s.lifecycleMu.Unlock()
+	if hub != nil {
+		hub.Close()
+	}
+	if confServer == nil {
+		return nil
+	}
+	return confServer.Shutdown(ctx)
+}
+
+// startControlPlaneClient streams the configuration of a data plane with the
+// control_plane provider and waits for the first batch to reach the Store.
+func (s *Server) startControlPlaneClient(ctx context.Context) error {
+	deployment := config.Global().Deployment
+	controlPlane := deployment.RoleDataPlane.ControlPlane
+	tlsConfig, err := controlplane.NewClientTLSConfig(
+		deployment.Certs.Cert,
+		deployment.Certs.CertKey,
+		deployment.Certs.TrustedCACert,
+		controlPlane.SNI,
+	)
+	if err != nil {
+		return err
+	}
+	client := controlplane.NewClient(
+		controlPlane.Host,
+		server_info.CurrentInfo().ID,
+		tlsConfig,
+		time.Duration(controlPlane.Timeout)*time.Second,
+		s.events,
+	)
+	// The stream client has the etcd watcher's Watch/Close contract.
+	producer := newEtcdConfigProducer(ctx, client)
+	if err := s.retainProducer(producer); err != nil {
+		return fmt.Errorf("retain control plane stream: %w", err)
+	}
+	logger.Info("stream config from control plane")
+	producer.Start()
+	err = fetchAndSyncInitialEtcdConfigContext(ctx, func(ctx context.Context) error {
+		waitCtx, cancel := context.WithTimeout(ctx, controlPlaneStartupTimeout)
+		defer cancel()
+		return client.WaitReady(waitCtx)
+	}, s.storage.Sync)
+	if err != nil {
+		return fmt.Errorf("wait for initial control plane config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/store"
+)
+
+func TestControlPlaneModeSelection(t *testing.T) {
+	tests := []struct {
+		name         string
+		deployment   config.Deployment
+		confServer   bool
+		streamClient bool
+	}{
+		{
+			name: "control plane with conf_server",
+			deployment: config.Deployment{
+				Role: "control_plane",
+				RoleControlPlane: config.RoleControlPlaneConfig{
+					ConfigProvider: "etcd",
+					ConfServer:     config.ConfServer{Listen: "127.0.0.1:9280"},
+				},
+			},
+			confServer: true,
+		},
+		{
+			name: "control plane without conf_server",
+			deployment: config.Deployment{
+				Role:             "control_plane",
+				RoleControlPlane: config.RoleControlPlaneConfig{ConfigProvider: "etcd"},
+			},
+		},
+		{
+			name: "data plane streaming from control plane",
+			deployment: config.Deployment{
+				Role:          "data_plane",
+				RoleDataPlane: config.RoleConfig{ConfigProvider: "control_plane"},
+			},
+			streamClient: true,
+		},
+		{
+			name: "etcd data plane",
+			deployment: config.Deployment{
+				Role:          "data_plane",
+				RoleDataPlane: config.RoleConfig{ConfigProvider: "etcd"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Deployment: tt.deployment}
+			if got := confServerEnabled(cfg); got != tt.confServer {
+				t.Fatalf("confServerEnabled() = %v, want %v", got, tt.confServer)
+			}
+			if got := controlPlaneConfigProvider(cfg); got != tt.streamClient {
+				t.Fatalf("controlPlaneConfigProvider() = %v, want %v", got, tt.streamClient)
+			}
+		})
+	}
+}
+
+func TestEtcdWatcherEventsRelayOnlyWithConfServer(t *testing.T) {
+	previous := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previous })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	config.GlobalConfig = &config.Config{Deployment: config.Deployment{Role: "traditional"}}
+	server := &Server{events: make(chan *store.Event)}
+	if events := server.etcdWatcherEvents(ctx); events != server.events || server.controlPlaneHub != nil {
+		t.Fatal("etcd watcher events relayed without a conf_server")
+	}
+
+	config.GlobalConfig = &config.Config{Deployment: config.Deployment{
+		Role: "control_plane",
+		RoleControlPlane: config.RoleControlPlaneConfig{
+			ConfServer: config.ConfServer{Listen: "127.0.0.1:9280"},
+		},
+	}}
+	if events := server.etcdWatcherEvents(ctx); events == server.events || server.controlPlaneHub == nil {
+		t.Fatal("etcd watcher events not relayed through the control plane hub")
+	}
+	server.controlPlaneHub.Close()
+}
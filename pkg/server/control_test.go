@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/route"
+)
+
+type fakeGenerationBuilder struct {
+	generation *route.Generation
+}
+
+func (b fakeGenerationBuilder) Generation() *route.Generation {
+	return b.generation
+}
+
+func TestStartControlServerServesPublishedGeneration(t *testing.T) {
+	port := reserveReloadPort(t)
+	cfg := reloadTestConfig(false, 9080)
+	cfg.Apisix.EnableControl = true
+	cfg.Apisix.Control = config.Control{Ip: "127.0.0.1", Port: port}
+	previous := config.GlobalConfig
+	config.GlobalConfig = cfg
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	clusters := pxy.NewClusterRegistry(pxy.NopClusterObserver{})
+	t.Cleanup(clusters.Close)
+	server := &Server{clusters: clusters}
+	if err := server.startControlServer(); err != nil {
+		t.Fatalf("startControlServer() error = %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = server.controlServer.Shutdown(ctx)
+	})
+	server.publishRouteGeneration(fakeGenerationBuilder{generation: &route.Generation{
+		Routes: []route.RouteState{{Route: resource.Route{ID: "r1", Uri: "/r1"}}},
+	}})
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	response, err := client.Get("http://" + reloadTestAddress(port) + "/v1/routes")
+	if err != nil {
+		t.Fatalf("GET /v1/routes: %v", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), `"key":"/apisix/routes/r1"`) {
+		t.Fatalf("GET /v1/routes = %d %s, want the published generation", response.StatusCode, body)
+	}
+}
+
+func TestStartControlServerHonorsEnableControl(t *testing.T) {
+	previous := config.GlobalConfig
+	config.GlobalConfig = reloadTestConfig(false, 9080)
+	t.Cleanup(func() { config.GlobalConfig = previous })
+
+	server := &Server{}
+	if err := server.startControlServer(); err != nil || server.controlServer != nil {
+		t.Fatalf("startControlServer() = %v with server %v, want disabled", err, server.controlServer)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/observability/metrics"
+	"github.com/wklken/apisix-go/pkg/resolver"
+	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/route"
+)
+
+// newDNSResolver builds the upstream domain resolver from apisix.dns_resolver.
+// It returns nil, leaving domain nodes to dial-time resolution as before the
+// resolver existed, when no nameserver is listed; the /etc/resolv.conf
+// nameservers are never used implicitly.
+func newDNSResolver(cfg *config.Config) (*resolver.Resolver, error) {
+	if cfg == nil || len(cfg.Apisix.DnsResolver) == 0 {
+		return nil, nil
+	}
+	apisix := cfg.Apisix
+	options := resolver.Options{
+		Nameservers: apisix.DnsResolver,
+		Valid:       time.Duration(apisix.DnsResolverValid) * time.Second,
+		Timeout:     time.Duration(apisix.ResolverTimeout) * time.Second,
+		IPv6:        apisix.EnableIpv6,
+	}
+	if apisix.EnableResolvSearchOpt {
+		conf, err := resolver.ReadResolvConf(resolver.ResolvConfPath)
+		if err != nil {
+			logger.Warnf("read %s: %s", resolver.ResolvConfPath, err)
+		}
+		options.Search = conf.Search
+		options.Ndots = conf.Ndots
+	}
+	hosts, err := resolver.ReadHosts(resolver.HostsPath)
+	if err != nil {
+		logger.Warnf("read %s: %s", resolver.HostsPath, err)
+	}
+	options.Hosts = hosts
+	dns, err := resolver.New(options)
+	if err != nil {
+		return nil, fmt.Errorf("apisix.dns_resolver: %w", err)
+	}
+	return dns, nil
+}
+
+// reloadResolvedUpstreams rebuilds HTTP and stream routes after a DNS answer
+// changed, so their upstreams acquire clusters for the new addresses.
+func (s *Server) reloadResolvedUpstreams() {
+	s.SendReloadEvent()
+	started, err := s.reloadStreamRoutesIfStarted()
+	if err != nil {
+		metrics.RecordConfigApplyStageFailure(metrics.ConfigApplyStageStreams)
+		logger.Errorf("reload stream routes after DNS change fail: %s", err)
+		return
+	}
+	if started {
+		metrics.RecordConfigApplyStageSuccess(metrics.ConfigApplyStageStreams)
+	}
+}
+
+// resolveStreamUpstreamDomains expands the domain nodes of stream upstreams
+// from the names cached in dns. TLS stream upstreams dial their domains so the
+// handshake keeps its server name, and an upstream whose domain is not cached
+// yet or failed to resolve is dialed by domain until the resolver's refresher
+// triggers a reload.
+func resolveStreamUpstreamDomains(routes []resource.StreamRoute, dns *resolver.Lease) {
+	if dns == nil {
+		return
+	}
+	for index := range routes {
+		upstream := &routes[index].Upstream
+		if strings.EqualFold(upstream.Scheme, "tls") {
+			continue
+		}
+		nodes, err := route.ExpandUpstreamDomainNodes(dns, upstream.Nodes)
+		if err != nil {
+			if !errors.Is(err, resolver.ErrPending) {
+				logger.Warnf("stream route %q: resolve upstream nodes, fall back to dial time: %s", routes[index].ID, err)
+			}
+			continue
+		}
+		upstream.Nodes = nodes
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func TestNewDNSResolverUsesConfiguredNameservers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Apisix.DnsResolver = []string{"127.0.0.1:5353"}
+	cfg.Apisix.DnsResolverValid = 30
+	cfg.Apisix.ResolverTimeout = 2
+	dns, err := newDNSResolver(cfg)
+	if err != nil {
+		t.Fatalf("newDNSResolver() error = %v", err)
+	}
+	if dns == nil {
+		t.Fatal("newDNSResolver() = nil, want a resolver for the configured nameserver")
+	}
+	addrs, err := dns.LookupIP(context.Background(), "10.0.0.1")
+	if err != nil || len(addrs) != 1 || addrs[0] != netip.MustParseAddr("10.0.0.1") {
+		t.Fatalf("LookupIP(literal) = %v, %v", addrs, err)
+	}
+
+	cfg.Apisix.DnsResolver = nil
+	cfg.Apisix.EnableResolvSearchOpt = true
+	if dns, err := newDNSResolver(cfg); err != nil || dns != nil {
+		t.Fatalf("newDNSResolver() without dns_resolver = %v, %v, want no resolver", dns, err)
+	}
+
+	cfg.Apisix.DnsResolver = []string{"resolver.internal"}
+	if _, err := newDNSResolver(cfg); err == nil || !strings.Contains(err.Error(), "apisix.dns_resolver") {
+		t.Fatalf("newDNSResolver() error = %v, want an apisix.dns_resolver rejection", err)
+	}
+}
+
+func TestResolveStreamUpstreamDomainsKeepsUnresolvedAndTLSNodes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Apisix.DnsResolver = []string{"127.0.0.1:1"}
+	dns, err := newDNSResolver(cfg)
+	if err != nil {
+		t.Fatalf("newDNSResolver() error = %v", err)
+	}
+	routes := []resource.StreamRoute{
+		{ID: "tcp", Upstream: resource.Upstream{Nodes: []resource.Node{
+			{Host: "127.0.0.1", Port: 9000, Weight: 1},
+			{Host: "unresolvable.invalid", Port: 9001, Weight: 1},
+		}}},
+		{ID: "tls", Upstream: resource.Upstream{
+			Scheme: "tls",
+			Nodes:  []resource.Node{{Host: "backend.internal", Port: 9443, Weight: 1}},
+		}},
+	}
+	lease := dns.Lease()
+	t.Cleanup(lease.Stop)
+	resolveStreamUpstreamDomains(routes, lease)
+	if routes[0].Upstream.Nodes[1].Host != "unresolvable.invalid" {
+		t.Fatalf("stream nodes = %+v, want a failed lookup left for dial-time resolution", routes[0].Upstream.Nodes)
+	}
+	if routes[1].Upstream.Nodes[0].Host != "backend.internal" {
+		t.Fatalf("TLS stream nodes = %+v, want the domain left for the handshake", routes[1].Upstream.Nodes)
+	}
+}
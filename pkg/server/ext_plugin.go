@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/extplugin"
+)
+
+// newExtPluginRunner builds the external plugin runner from ext-plugin.cmd,
+// or connects to ext-plugin.path_for_test without spawning. It returns nil,
+// failing the ext-plugin-* plugins closed, when neither is set.
+func newExtPluginRunner(cfg *config.Config) (*extplugin.Runner, error) {
+	if cfg == nil || (len(cfg.ExtPlugin.Cmd) == 0 && cfg.ExtPlugin.PathForTest == "") {
+		return nil, nil
+	}
+	return extplugin.New(extplugin.Options{
+		Cmd:         cfg.ExtPlugin.Cmd,
+		PathForTest: cfg.ExtPlugin.PathForTest,
+	})
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+// http3AltSvcMaxAge is the Alt-Svc lifetime, in seconds, advertised for the
+// HTTP/3 listeners.
+const http3AltSvcMaxAge = 86400
+
+// configuredHTTP3ListenAddresses returns the apisix.ssl.listen addresses with
+// enable_http3 or enable_quic. Each one binds UDP on the address of its TCP
+// HTTPS listener.
+func configuredHTTP3ListenAddresses(cfg *config.Config) []string {
+	if cfg == nil || !cfg.Apisix.Ssl.Enable {
+		return nil
+	}
+	var addresses []string
+	for _, listener := range cfg.Apisix.Ssl.Listen {
+		if !listener.EnableHttp3 && !listener.EnableQuic {
+			continue
+		}
+		if listener.Port < 1 || listener.Port > 65535 {
+			continue
+		}
+		host := strings.TrimSpace(listener.Ip)
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(listener.Port)))
+	}
+	return addresses
+}
+
+// buildHTTP3TLSConfig is the frontend TLS configuration for the QUIC
+// listeners. It keeps SSL certificate selection and client-CA policy, and
+// requires TLS 1.3, which QUIC is built on.
+func buildHTTP3TLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig, err := buildFrontendTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion != 0 && tlsConfig.MaxVersion < tls.VersionTLS13 {
+		return nil, fmt.Errorf("HTTP/3 requires TLSv1.3 in ssl_protocols")
+	}
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{http3.NextProtoH3}
+	tlsConfig.GetConfigForClient = frontendTLSConfigSelector(tlsConfig, frontendTLSFallbackSNI(cfg))
+	return tlsConfig, nil
+}
+
+// startHTTP3Listeners binds the HTTP/3 addresses and serves them with the
+// handler of the serving HTTP generation, so routes, request body limits and
+// the rest of the frontend middleware follow config reloads. A bind failure
+// closes the sockets opened so far.
+func (s *Server) startHTTP3Listeners(addrs []string, tlsConfig *tls.Config) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	s.http3TLSConfig.Store(tlsConfig)
+	conns := make([]net.PacketConn, 0, len(addrs))
+	for _, address := range addrs {
+		logger.Infof("listening with HTTP/3 on %s", address)
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			for _, opened := range conns {
+				_ = opened.Close()
+			}
+			return fmt.Errorf("open HTTP/3 listener %s: %w", address, err)
+		}
+		conns = append(conns, conn)
+	}
+	server := &http3.Server{
+		Handler:     http.HandlerFunc(s.serveHTTP3),
+		TLSConfig:   &tls.Config{GetConfigForClient: s.selectHTTP3TLSConfig},
+		IdleTimeout: defaultHTTPIdleTimeout,
+	}
+	if cfg := config.Global(); cfg != nil && cfg.NginxConfig.HTTP.KeepaliveTimeout > 0 {
+		server.IdleTimeout = cfg.NginxConfig.HTTP.KeepaliveTimeout
+	}
+	s.httpMu.Lock()
+	s.http3Server = server
+	s.http3Conns = conns
+	s.httpMu.Unlock()
+	for _, conn := range conns {
+		go func() {
+			if err := server.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.reportServeError(err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP3(w http.ResponseWriter, r *http.Request) {
+	s.httpMu.Lock()
+	server := s.server
+	s.httpMu.Unlock()
+	server.Handler.ServeHTTP(w, r)
+}
+
+// selectHTTP3TLSConfig selects the certificate from the TLS configuration of
+// the latest config generation.
+func (s *Server) selectHTTP3TLSConfig(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	current := s.http3TLSConfig.Load()
+	selected, err := current.GetConfigForClient(hello)
+	if selected == nil && err == nil {
+		return current, nil
+	}
+	return selected, err
+}
+
+// shutdownHTTP3 sends GOAWAY to HTTP/3 clients, waits for their in-flight
+// requests and closes the UDP sockets.
+func (s *Server) shutdownHTTP3(ctx context.Context) error {
+	s.httpMu.Lock()
+	server := s.http3Server
+	conns := s.http3Conns
+	s.httpMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	return nil
+}
+
+// advertiseHTTP3 adds Alt-Svc to responses on the TCP HTTPS listeners whose
+// port also serves HTTP/3.
+func advertiseHTTP3(next http.Handler, addrs []string) http.Handler {
+	ports := make([]string, 0, len(addrs))
+	for _, address := range addrs {
+		if _, port, err := net.SplitHostPort(address); err == nil {
+			ports = append(ports, port)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor < 3 {
+			if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+				if _, port, err := net.SplitHostPort(local.String()); err == nil && slices.Contains(ports, port) {
+					w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%s"; ma=%d`, port, http3AltSvcMaxAge))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/wklken/apisix-go/pkg/config"
+)
+
+func TestConfiguredHTTP3ListenAddresses(t *testing.T) {
+	cfg := &config.Config{Apisix: config.Apisix{Ssl: config.Ssl{Listen: []config.Listen{
+		{Port: 9443, EnableHttp3: true},
+		{Ip: "127.0.0.1", Port: 9444, EnableQuic: true},
+		{Port: 9445, EnableHttp2: true},
+		{Port: 0, EnableHttp3: true},
+	}}}}
+	if addrs := configuredHTTP3ListenAddresses(cfg); addrs != nil {
+		t.Fatalf("addresses with SSL disabled = %v, want none", addrs)
+	}
+	cfg.Apisix.Ssl.Enable = true
+	want := []string{"0.0.0.0:9443", "127.0.0.1:9444"}
+	if addrs := configuredHTTP3ListenAddresses(cfg); !slices.Equal(addrs, want) {
+		t.Fatalf("addresses = %v, want %v", addrs, want)
+	}
+}
+
+func TestBuildHTTP3TLSConfigRequiresTLS13(t *testing.T) {
+	previous := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previous })
+	config.GlobalConfig = &config.Config{Apisix: config.Apisix{Ssl: config.Ssl{
+		Enable:       true,
+		SslProtocols: "TLSv1.2",
+		SslCiphers:   frontendTLS12Cipher,
+	}}}
+	if _, err := buildHTTP3TLSConfig(config.GlobalConfig); err == nil || !strings.Contains(err.Error(), "TLSv1.3") {
+		t.Fatalf("buildHTTP3TLSConfig() error = %v, want TLSv1.3 requirement", err)
+	}
+
+	config.GlobalConfig.Apisix.Ssl.SslProtocols = "TLSv1.2 TLSv1.3"
+	tlsConfig, err := buildHTTP3TLSConfig(config.GlobalConfig)
+	if err != nil {
+		t.Fatalf("buildHTTP3TLSConfig() error = %v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 || !slices.Equal(tlsConfig.NextProtos, []string{http3.NextProtoH3}) {
+		t.Fatalf("min version = %x, next protos = %v", tlsConfig.MinVersion, tlsConfig.NextProtos)
+	}
+}
+
+func TestConfiguredHTTPHandlerAdvertisesHTTP3OnTLSListenerPort(t *testing.T) {
+	cfg := &config.Config{Apisix: config.Apisix{Ssl: config.Ssl{
+		Enable: true,
+		Listen: []config.Listen{{Port: 9443, EnableHttp3: true}, {Port: 9444}},
+	}}}
+	handler := newConfiguredHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cfg)
+	for _, test := range []struct {
+		name  string
+		local string
+		tls   bool
+		want  string
+	}{
+		{name: "HTTP/3 port", local: "127.0.0.1:9443", tls: true, want: `h3=":9443"; ma=86400`},
+		{name: "TLS port without HTTP/3", local: "127.0.0.1:9444", tls: true},
+		{name: "plaintext", local: "127.0.0.1:9443"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.test/", nil)
+			if !test.tls {
+				r.TLS = nil
+			}
+			local, err := net.ResolveTCPAddr("tcp", test.local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if got := w.Header().Get("Alt-Svc"); got != test.want {
+				t.Fatalf("Alt-Svc = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHTTP3ListenerServesCurrentGenerationAndShutsDown(t *testing.T) {
+	previous := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previous })
+	cfg := &config.Config{Apisix: config.Apisix{Ssl: config.Ssl{
+		Enable:       true,
+		SslProtocols: "TLSv1.3",
+	}}}
+	cfg.NginxConfig.HTTP.ClientMaxBodySize = 8
+	config.GlobalConfig = cfg
+
+	tlsConfig, err := buildHTTP3TLSConfig(config.GlobalConfig)
+	if err != nil {
+		t.Fatalf("buildHTTP3TLSConfig() error = %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{frontendHandshakeCertificate(
+		t,
+		"http3-certificate",
+		nil,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	)}
+	routes := newRouteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(w, r.Proto+" "+string(body))
+	}), nil)
+	server := &Server{
+		server: newConfiguredHTTPServer(newConfiguredHTTPHandler(routes, cfg), cfg),
+		routes: routes,
+	}
+	if err := server.startHTTP3Listeners([]string{"127.0.0.1:0"}, tlsConfig); err != nil {
+		t.Fatalf("startHTTP3Listeners() error = %v", err)
+	}
+	t.Cleanup(func() { _ = server.shutdown(context.Background()) })
+	url := "https://" + server.http3Conns[0].LocalAddr().String() + "/"
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	t.Cleanup(func() { _ = transport.Close() })
+	client := &http.Client{Transport: transport}
+	response, err := client.Post(url, "text/plain", strings.NewReader("small"))
+	if err != nil {
+		t.Fatalf("HTTP/3 request error = %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "HTTP/3.0 small" {
+		t.Fatalf("response = %d %q", response.StatusCode, body)
+	}
+
+	response, err = client.Post(url, "text/plain", strings.NewReader("larger than the limit"))
+	if err != nil {
+		t.Fatalf("HTTP/3 request error = %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body status = %d, want %d", response.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	// A reload installs the next generation's handler.
+	server.httpMu.Lock()
+	server.server = newConfiguredHTTPServer(newConfiguredHTTPHandler(routes, &config.Config{}), config.GlobalConfig)
+	server.httpMu.Unlock()
+	response, err = client.Post(url, "text/plain", strings.NewReader("larger than the limit"))
+	if err != nil {
+		t.Fatalf("HTTP/3 request error = %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status after handler swap = %d, want %d", response.StatusCode, http.StatusOK)
+	}
+
+	if err := server.shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+	transport.CloseIdleConnections()
+	client.Timeout = time.Second
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("HTTP/3 request succeeded after shutdown")
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	apisixid "github.com/wklken/apisix-go/pkg/apisix/id"
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/logger"
+	"github.com/wklken/apisix-go/pkg/plugin/limit_count"
+)
+
+// limitCountGossipMemberTTL is the lease of a node's etcd membership record;
+// a node that stops renewing it drops out of its peers' lists after it.
+const limitCountGossipMemberTTL = 30 * time.Second
+
+func limitCountGossipConfig(cfg *config.Config) (limit_count.GossipConfig, bool, error) {
+	if cfg == nil || cfg.PluginAttr == nil {
+		return limit_count.GossipConfig{}, false, nil
+	}
+	gossip, ok, err := limit_count.GossipConfigFromAttr(cfg.PluginAttr["limit-count"])
+	if err != nil || !ok {
+		return gossip, ok, err
+	}
+	if gossip.NodeID == "" {
+		gossip.NodeID = apisixid.Get()
+	}
+	return gossip, true, nil
+}
+
+// startLimitCountGossip starts the node that the limit-count gossip policy
+// shares its counters through, before any route can use the policy.
+func (s *Server) startLimitCountGossip() error {
+	cfg := config.Global()
+	gossip, ok, err := limitCountGossipConfig(cfg)
+	if err != nil || !ok {
+		return err
+	}
+	if gossip.EtcdDiscovery && (standaloneConfigProvider(cfg) != "" || controlPlaneConfigProvider(cfg)) {
+		return errors.New("plugin_attr.limit-count.gossip.etcd_discovery requires the etcd config provider")
+	}
+	node, err := limit_count.StartGossip(gossip)
+	if err != nil {
+		return fmt.Errorf("start limit-count gossip: %w", err)
+	}
+	s.lifecycleMu.Lock()
+	s.limitCountGossip = node
+	s.lifecycleMu.Unlock()
+	logger.Infof("limit-count gossip node %s listening on %s", node.ID(), node.Addr())
+	return nil
+}
+
+// joinLimitCountGossip registers the gossip node under the etcd prefix and
+// keeps its peers in step with the other registered nodes.
+func (s *Server) joinLimitCountGossip(ctx context.Context) error {
+	gossip, ok, err := limitCountGossipConfig(config.Global())
+	if err != nil || !ok || !gossip.EtcdDiscovery {
+		return err
+	}
+	s.lifecycleMu.Lock()
+	node := s.limitCountGossip
+	etcdClient := s.etcdClient
+	s.lifecycleMu.Unlock()
+	if node == nil || etcdClient == nil {
+		return nil
+	}
+	err = etcdClient.JoinMembership(
+		ctx,
+		limit_count.GossipMembershipGroup,
+		node.ID(),
+		gossip.Advertise,
+		limitCountGossipMemberTTL,
+		func(members map[string]string) {
+			peers := make([]string, 0, len(members))
+			for id, addr := range members {
+				if id != node.ID() {
+					peers = append(peers, addr)
+				}
+			}
+			node.SetPeers(peers)
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("join limit-count gossip membership: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) stopLimitCountGossip() {
+	s.lifecycleMu.Lock()
+	node := s.limitCountGossip
+	s.limitCountGossip = nil
+	s.lifecycleMu.Unlock()
+	if node != nil {
+		_ = node.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// sharedListener owns one bound socket across HTTP server generations. A
+// configuration reload hands the socket to the next generation through a new
+// view, so an unchanged address keeps its accept queue while the retired
+// generation drains.
+type sharedListener struct {
+	net.Listener
+	accepted  chan acceptedConn
+	closing   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type acceptedConn struct {
+	conn net.Conn
+	err  error
+}
+
+func newSharedListener(listener net.Listener) *sharedListener {
+	shared := &sharedListener{
+		Listener: listener,
+		accepted: make(chan acceptedConn),
+		closing:  make(chan struct{}),
+	}
+	go shared.acceptLoop()
+	return shared
+}
+
+func (l *sharedListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		select {
+		case l.accepted <- acceptedConn{conn: conn, err: err}:
+		case <-l.closing:
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// handoff returns a listener view for one HTTP server generation. Closing the
+// view, as http.Server.Shutdown does, leaves the shared socket bound.
+func (l *sharedListener) handoff() net.Listener {
+	return &listenerView{shared: l, closed: make(chan struct{})}
+}
+
+func (l *sharedListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
+
+type listenerView struct {
+	shared    *sharedListener
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (v *listenerView) Accept() (net.Conn, error) {
+	select {
+	case <-v.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case <-v.closed:
+		return nil, net.ErrClosed
+	case <-v.shared.closing:
+		return nil, net.ErrClosed
+	case result := <-v.shared.accepted:
+		return result.conn, result.err
+	}
+}
+
+func (v *listenerView) Close() error {
+	v.closeOnce.Do(func() { close(v.closed) })
+	return nil
+}
+
+func (v *listenerView) Addr() net.Addr {
+	return v.shared.Addr()
+}
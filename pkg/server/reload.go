@@ -138,7 +138,11 @@ func (s *Server) reload(ctx context.Context) (reloadErr error) {
 
 	logger.Info("reloading")
 
-	builder := route.NewBuilderWithClusterRegistry(s.storage, s.addr, s.clusters)
+	builder := route.NewBuilderWithClusterRegistry(s.storage, s.addr, s.clusters).
+		WithDiscovery(s.discovery).
+		WithResolver(s.resolver).
+		WithExtPluginRunner(s.extPluginRunner).
+		WithWasmModules(s.wasmModules)
 	installed := false
 
 	defer func() {
@@ -164,6 +168,7 @@ func (s *Server) reload(ctx context.Context) (reloadErr error) {
 	}
 	s.routes.Replace(handler, builder.Stop)
 	recordRouteBuildQuarantine(builder)
+	s.publishRouteGeneration(builder)
 	installed = true
 
 	logger.Info("reload done")
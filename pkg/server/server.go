@@ -15,25 +15,36 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/go-chi/chi/v5"
+	"github.com/quic-go/quic-go/http3"
 	apisixctx "github.com/wklken/apisix-go/pkg/apisix/ctx"
 	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/controlplane"
+	"github.com/wklken/apisix-go/pkg/discovery"
 	"github.com/wklken/apisix-go/pkg/etcd"
+	"github.com/wklken/apisix-go/pkg/extplugin"
 	"github.com/wklken/apisix-go/pkg/json"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/observability/metrics"
 	"github.com/wklken/apisix-go/pkg/observability/otel"
+	"github.com/wklken/apisix-go/pkg/plugin/limit_count"
 	"github.com/wklken/apisix-go/pkg/plugin/node_status"
 	"github.com/wklken/apisix-go/pkg/plugin/server_info"
 	pxy "github.com/wklken/apisix-go/pkg/proxy"
+	"github.com/wklken/apisix-go/pkg/proxyprotocol"
+	"github.com/wklken/apisix-go/pkg/resolver"
 	"github.com/wklken/apisix-go/pkg/resource"
 	"github.com/wklken/apisix-go/pkg/route"
+	"github.com/wklken/apisix-go/pkg/shared"
 	"github.com/wklken/apisix-go/pkg/store"
 	streamruntime "github.com/wklken/apisix-go/pkg/stream"
+	"github.com/wklken/apisix-go/pkg/util"
 	"github.com/wklken/apisix-go/pkg/version"
+	"github.com/wklken/apisix-go/pkg/wasm"
 	"golang.org/x/net/http2"
 )
 
@@ -50,6 +61,7 @@ type configProducer interface {
 
 type streamRuntimeOwner interface {
 	Reload([]resource.StreamRoute) error
+	ReconfigureWithTLS(config.StreamProxy, streamruntime.TLSConfigProvider, []resource.StreamRoute, []string) error
 	Close(context.Context) error
 }
 
@@ -122,9 +134,16 @@ type Server struct {
 	server          *http.Server
 	routes          *routeHandler
 	clusters        *pxy.ClusterRegistry
+	discovery       *discovery.Registry
+	resolver        *resolver.Resolver
+	extPluginRunner *extplugin.Runner
+	wasmModules     map[string]*wasm.Module
 	streamRuntime   streamRuntimeOwner
 	streamReloadMu  sync.Mutex
 	streamRoutes    []resource.StreamRoute
+	// streamDNSLease pins the upstream domains the published stream routes
+	// were resolved through.
+	streamDNSLease  *resolver.Lease
 	reloadEventChan chan struct{}
 
 	reloadMu                  sync.Mutex
@@ -138,6 +157,7 @@ type Server struct {
 	etcdClient        *etcd.ConfigClient
 	standaloneWatcher *config.StandaloneFileWatcher
 	producer          configProducer
+	controlPlaneHub   *controlplane.Hub
 
 	lifecycleMu       sync.Mutex
 	lifecycleCancel   context.CancelFunc
@@ -155,33 +175,84 @@ type Server struct {
 	listenerMu sync.Mutex
 	listeners  []net.Listener
 
+	httpMu          sync.Mutex
+	httpBindings    map[string]*sharedListener
+	drainingServers map[*http.Server]struct{}
+	serveErrors     chan error
+	http3Server     *http3.Server
+	http3Conns      []net.PacketConn
+	http3TLSConfig  atomic.Pointer[tls.Config]
+
 	prometheusServer         *http.Server
+	adminServer              *http.Server
+	controlServer            *http.Server
+	confServer               *http.Server
+	routeGeneration          atomic.Pointer[route.Generation]
 	stopPrometheusExpiration func(context.Context) error
 	otelShutdown             func(context.Context) error
+	limitCountGossip         *limit_count.GossipNode
 }
 
 const startupCleanupTimeout = time.Second
 
 func NewServer() (*Server, error) {
+	cfg := config.Global()
+	if cfg != nil {
+		httpConfig := cfg.NginxConfig.HTTP
+		if err := shared.ConfigureZones(httpConfig.LuaSharedDict, httpConfig.CustomLuaSharedDict); err != nil {
+			return nil, fmt.Errorf("initialize nginx_config.http shared dicts: %w", err)
+		}
+	}
 	events := make(chan *store.Event)
 	storage, err := store.GetStore("apisix-go-store.db", events)
 	if err != nil {
 		return nil, fmt.Errorf("open store: %w", err)
 	}
+	var discoveryConfig config.Discovery
+	if cfg != nil {
+		discoveryConfig = cfg.Discovery
+	}
+	discoveryRegistry, err := discovery.NewRegistry(discoveryConfig)
+	if err != nil {
+		_ = storage.Stop()
+		return nil, fmt.Errorf("initialize discovery: %w", err)
+	}
+	dnsResolver, err := newDNSResolver(cfg)
+	if err != nil {
+		_ = storage.Stop()
+		return nil, fmt.Errorf("initialize DNS resolver: %w", err)
+	}
+	extPluginRunner, err := newExtPluginRunner(cfg)
+	if err != nil {
+		_ = storage.Stop()
+		return nil, fmt.Errorf("initialize ext-plugin runner: %w", err)
+	}
+	wasmModules, err := loadWasmModules(context.Background(), cfg)
+	if err != nil {
+		extPluginRunner.Close()
+		_ = storage.Stop()
+		return nil, fmt.Errorf("initialize wasm plugins: %w", err)
+	}
 	routes := newRouteHandler(http.NotFoundHandler(), nil)
-	handler := newConfiguredHTTPHandler(routes, config.GlobalConfig)
+	handler := newConfiguredHTTPHandler(routes, cfg)
 	addrs := configuredListenAddresses()
 	otelShutdown, err := otel.Init("apisix-go")
 	if err != nil {
+		extPluginRunner.Close()
+		closeWasmModules(context.Background(), wasmModules)
 		_ = storage.Stop()
 		return nil, fmt.Errorf("initialize tracing: %w", err)
 	}
 	return &Server{
 		addr:            addrs[0],
 		addrs:           addrs,
-		server:          newConfiguredHTTPServer(handler),
+		server:          newConfiguredHTTPServer(handler, cfg),
 		routes:          routes,
 		clusters:        pxy.NewClusterRegistry(newClusterObserver()),
+		discovery:       discoveryRegistry,
+		resolver:        dnsResolver,
+		extPluginRunner: extPluginRunner,
+		wasmModules:     wasmModules,
 		reloadEventChan: make(chan struct{}, 1),
 		events:          events,
 		storage:         storage,
@@ -190,7 +261,7 @@ func NewServer() (*Server, error) {
 }
 
 func newClusterObserver() pxy.ClusterObserver {
-	if !prometheusEnabled(config.GlobalConfig) {
+	if !prometheusEnabled(config.Global()) {
 		return pxy.NopClusterObserver{}
 	}
 	return metrics.NewProxyRuntimeObserver()
@@ -210,6 +281,9 @@ func newConfiguredHTTPHandler(routes http.Handler, cfg *config.Config) http.Hand
 	if cfg != nil && cfg.Apisix.DeleteURITailSlash {
 		handler = deleteURITailSlash(handler)
 	}
+	if addrs := configuredHTTP3ListenAddresses(cfg); len(addrs) > 0 {
+		handler = advertiseHTTP3(handler, addrs)
+	}
 	if pluginConfigured("node-status") {
 		handler = node_status.Track(handler)
 	}
@@ -252,23 +326,7 @@ func requiresEtcdReadiness(cfg *config.Config) bool {
 }
 
 func normalizeForwardedHeaders(next http.Handler, addresses []string) http.Handler {
-	trustedNetworks := make([]*net.IPNet, 0, len(addresses))
-	for _, address := range addresses {
-		if _, network, err := net.ParseCIDR(address); err == nil {
-			trustedNetworks = append(trustedNetworks, network)
-			continue
-		}
-		ip := net.ParseIP(address)
-		if ip == nil {
-			continue
-		}
-		bits := 128
-		if ip.To4() != nil {
-			ip = ip.To4()
-			bits = 32
-		}
-		trustedNetworks = append(trustedNetworks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
-	}
+	trustedNetworks := util.ParseTrustedNetworks(addresses)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		host, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -285,6 +343,9 @@ func normalizeForwardedHeaders(next http.Handler, addresses []string) http.Handl
 		}
 		if trusted {
 			r = apisixctx.WithTrustedProxy(r)
+			if client := forwardedClientIP(r.Header.Values("X-Forwarded-For"), trustedNetworks); client != "" {
+				r = apisixctx.WithClientIP(r, client)
+			}
 			if r.Header.Get("X-Forwarded-Proto") == "" {
 				r.Header.Set("X-Forwarded-Proto", scheme(r))
 			}
@@ -311,6 +372,35 @@ func normalizeForwardedHeaders(next http.Handler, addresses []string) http.Handl
 	})
 }
 
+// forwardedClientIP walks a trusted proxy's X-Forwarded-For chain from the
+// right and returns the first untrusted hop, or the leftmost hop when every
+// hop is trusted. A malformed hop stops the walk at the last valid one.
+func forwardedClientIP(values []string, trustedNetworks []*net.IPNet) string {
+	var hops []string
+	for _, value := range values {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := ""
+	for _, hop := range slices.Backward(hops) {
+		ip := net.ParseIP(strings.TrimSpace(hop))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		trusted := false
+		for _, network := range trustedNetworks {
+			if network.Contains(ip) {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			break
+		}
+	}
+	return client
+}
+
 func scheme(r *http.Request) string {
 	if r.TLS != nil {
 		return "https"
@@ -424,17 +514,18 @@ func forceServerHeader(next http.Handler, cfg *config.Config) http.Handler {
 }
 
 func configuredListenAddresses() []string {
-	if config.GlobalConfig == nil {
+	cfg := config.Global()
+	if cfg == nil {
 		return []string{":8080"}
 	}
-	return config.GlobalConfig.Apisix.ListenAddresses()
+	return cfg.Apisix.ListenAddresses()
 }
 
-func configuredTLSListenAddresses() []string {
-	if config.GlobalConfig == nil || !config.GlobalConfig.Apisix.Ssl.Enable {
+func configuredTLSListenAddresses(cfg *config.Config) []string {
+	if cfg == nil || !cfg.Apisix.Ssl.Enable {
 		return nil
 	}
-	listeners := config.GlobalConfig.Apisix.Ssl.Listen
+	listeners := cfg.Apisix.Ssl.Listen
 	addresses := make([]string, 0, len(listeners))
 	for _, listener := range listeners {
 		if listener.Port < 1 || listener.Port > 65535 {
@@ -454,13 +545,13 @@ const (
 	defaultHTTPIdleTimeout   = 90 * time.Second
 )
 
-func newConfiguredHTTPServer(handler http.Handler) *http.Server {
+func newConfiguredHTTPServer(handler http.Handler, cfg *config.Config) *http.Server {
 	protocols := &http.Protocols{}
 	protocols.SetHTTP1(true)
-	if frontendHTTP2Enabled() {
+	if frontendHTTP2Enabled(cfg) {
 		protocols.SetHTTP2(true)
 	}
-	if frontendPlainHTTP2Enabled() {
+	if frontendPlainHTTP2Enabled(cfg) {
 		protocols.SetUnencryptedHTTP2(true)
 	}
 	server := &http.Server{
@@ -469,19 +560,19 @@ func newConfiguredHTTPServer(handler http.Handler) *http.Server {
 		ReadHeaderTimeout: defaultReadHeaderTimeout,
 		IdleTimeout:       defaultHTTPIdleTimeout,
 	}
-	if prometheusEnabled(config.GlobalConfig) {
+	if prometheusEnabled(cfg) {
 		server.ConnState = metrics.NewHTTPConnectionStateObserver()
 	}
-	if frontendHTTP2Enabled() {
+	if frontendHTTP2Enabled(cfg) {
 		if err := http2.ConfigureServer(server, nil); err != nil {
 			logger.Errorf("configure HTTP/2 server: %s", err)
 		}
 	}
-	if config.GlobalConfig == nil {
+	if cfg == nil {
 		return server
 	}
 
-	httpConfig := config.GlobalConfig.NginxConfig.HTTP
+	httpConfig := cfg.NginxConfig.HTTP
 	if httpConfig.KeepaliveTimeout > 0 {
 		server.IdleTimeout = httpConfig.KeepaliveTimeout
 	}
@@ -495,10 +586,11 @@ func newConfiguredHTTPServer(handler http.Handler) *http.Server {
 }
 
 func pluginConfigured(name string) bool {
-	if config.GlobalConfig == nil {
+	cfg := config.Global()
+	if cfg == nil {
 		return false
 	}
-	return slices.Contains(config.GlobalConfig.Plugins, name)
+	return slices.Contains(cfg.Plugins, name)
 }
 
 func (s *Server) beginStart(parent context.Context) (context.Context, bool) {
@@ -591,12 +683,12 @@ func (s *Server) cleanupAfterStart() error {
 	}
 	lifecycleCancel()
 	s.closeOwnedListeners()
-	if s.server != nil {
-		// A startup error must not be held hostage by a request that cannot
-		// drain. Close listeners and active connections as a bounded fallback.
-		// net/http does not terminate handler goroutines, so dependency cleanup
-		// continues only after those handlers release their route generation.
-		_ = s.server.Close()
+	// A startup error must not be held hostage by a request that cannot
+	// drain. Close listeners and active connections as a bounded fallback.
+	// net/http does not terminate handler goroutines, so dependency cleanup
+	// continues only after those handlers release their route generation.
+	for _, server := range s.httpServers() {
+		_ = server.Close()
 	}
 	go func() {
 		if cleanupErr := s.shutdown(context.Background()); cleanupErr != nil {
@@ -652,7 +744,8 @@ func (s *Server) Start(ctx context.Context) (startErr error) {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
-	if prometheusEnabled(config.GlobalConfig) {
+	cfg := config.Global()
+	if prometheusEnabled(cfg) {
 		if err := metrics.Init(); err != nil {
 			return fmt.Errorf("initialize prometheus metrics: %w", err)
 		}
@@ -660,34 +753,46 @@ func (s *Server) Start(ctx context.Context) (startErr error) {
 			return err
 		}
 	}
-	metrics.SetConfigApplyStreamRequired(streamProxyModeEnabled(config.GlobalConfig))
-	if standaloneConfigProvider(config.GlobalConfig) == "" {
+	metrics.SetConfigApplyStreamRequired(streamProxyModeEnabled(cfg))
+	if standaloneConfigProvider(cfg) == "" {
 		s.registerAcknowledgedStoreUpdateHook(ctx)
 	}
 
+	if err := s.startLimitCountGossip(); err != nil {
+		return err
+	}
+
 	logger.Info("Starting storage")
 	s.storage.Start()
 	if err := s.startConfigProvider(ctx); err != nil {
 		return err
 	}
+	if err := s.joinLimitCountGossip(ctx); err != nil {
+		return err
+	}
 	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	if standaloneConfigProvider(config.GlobalConfig) != "" {
+	if standaloneConfigProvider(cfg) != "" {
 		logger.Info("build the routes")
-		builder := route.NewBuilderWithClusterRegistry(s.storage, s.addr, s.clusters)
+		builder := route.NewBuilderWithClusterRegistry(s.storage, s.addr, s.clusters).
+			WithDiscovery(s.discovery).
+			WithResolver(s.resolver).
+			WithExtPluginRunner(s.extPluginRunner).
+			WithWasmModules(s.wasmModules)
 		if err := buildAndInstallInitialRoutes(s.routes, builder); err != nil {
 			metrics.RecordConfigApplyStageFailure(metrics.ConfigApplyStageHTTPRoutes)
 			return err
 		}
+		s.publishRouteGeneration(builder)
 		metrics.RecordConfigApplyStageSuccess(metrics.ConfigApplyStageHTTPRoutes)
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 	}
 	previousStreamRuntime := s.streamRuntime
-	if err := s.startStreamProxy(ctx); err != nil {
+	if err := s.startStreamProxy(ctx, cfg); err != nil {
 		return err
 	}
 	if err := ctx.Err(); err != nil {
@@ -697,17 +802,39 @@ func (s *Server) Start(ctx context.Context) (startErr error) {
 		if err := s.standaloneWatcher.StartAndReconcile(); err != nil {
 			return fmt.Errorf("start standalone config watcher: %w", err)
 		}
-		provider := standaloneConfigProvider(config.GlobalConfig)
+		provider := standaloneConfigProvider(cfg)
 		logger.Infof("watch standalone config %s", config.StandaloneConfigFile(provider))
 	}
 
 	// start the reloader
 	s.startReloadScheduler(ctx)
+	if s.discovery != nil {
+		// A discovered membership change rebuilds the routes, which acquires
+		// clusters keyed by the new node sets.
+		s.discovery.Start(ctx, s.SendReloadEvent)
+	}
+	if s.resolver != nil {
+		// A changed DNS answer re-expands upstream domain nodes the same way.
+		s.resolver.Start(ctx, s.reloadResolvedUpstreams)
+	}
+	// The runner stays up across reloads; restarts only drop its conf tokens.
+	s.extPluginRunner.Start(ctx)
 
 	return s.startServing(
 		ctx,
 		previousStreamRuntime,
-		s.startPrometheusExportServer,
+		func() error {
+			if err := s.startPrometheusExportServer(); err != nil {
+				return err
+			}
+			if err := s.startControlServer(); err != nil {
+				return err
+			}
+			if err := s.startConfServer(); err != nil {
+				return err
+			}
+			return s.startAdminServer(ctx)
+		},
 		s.startHTTPListeners,
 	)
 }
@@ -802,11 +929,28 @@ func (s *Server) shutdown(ctx context.Context) error {
 }
 
 func (s *Server) shutdownAttempt(ctx context.Context) (error, bool) {
-	if s.server != nil {
-		if err := s.server.Shutdown(ctx); err != nil {
+	for _, server := range s.httpServers() {
+		if err := server.Shutdown(ctx); err != nil {
 			return fmt.Errorf("stop HTTP server: %w", err), false
 		}
 	}
+	if err := s.shutdownHTTP3(ctx); err != nil {
+		return fmt.Errorf("stop HTTP/3 server: %w", err), false
+	}
+	s.closeOwnedListeners()
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("stop admin API server: %w", err), false
+		}
+	}
+	if s.controlServer != nil {
+		if err := s.controlServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("stop control API server: %w", err), false
+		}
+	}
+	if err := s.shutdownConfServer(ctx); err != nil {
+		return fmt.Errorf("stop conf_server: %w", err), false
+	}
 	if err := s.stopPrometheusExpirationRuntime(ctx); err != nil {
 		return err, false
 	}
@@ -828,11 +972,21 @@ func (s *Server) shutdownAttempt(ctx context.Context) (error, bool) {
 		}
 		s.streamRuntime = nil
 		s.streamRoutes = nil
+		s.replaceStreamDNSLease(nil)
 	}
 	s.streamReloadMu.Unlock()
 	if streamRuntime != nil {
 		metrics.SetStreamRoutes(nil)
 	}
+	if s.discovery != nil {
+		s.discovery.Close()
+	}
+	if s.resolver != nil {
+		s.resolver.Close()
+	}
+	s.stopLimitCountGossip()
+	s.extPluginRunner.Close()
+	closeWasmModules(ctx, s.wasmModules)
 	if s.routes != nil {
 		s.routes.Close()
 	}
@@ -949,57 +1103,51 @@ func waitForLifecycle(ctx context.Context, done chan struct{}) error {
 	}
 }
 
-func (s *Server) startStreamProxy(ctx context.Context) error {
-	if config.GlobalConfig == nil || !streamProxyModeEnabled(config.GlobalConfig) {
+func (s *Server) startStreamProxy(ctx context.Context, cfg *config.Config) error {
+	if cfg == nil || !streamProxyModeEnabled(cfg) {
 		return nil
 	}
 	fail := func(err error) error {
 		metrics.RecordConfigApplyStageFailure(metrics.ConfigApplyStageStreams)
 		return err
 	}
-	streamConfig := config.GlobalConfig.Apisix.StreamProxy
-	if len(streamConfig.Tcp) == 0 {
-		return fail(fmt.Errorf("stream mode requires at least one TCP listener"))
-	}
-	if len(streamConfig.Udp) > 0 {
-		return fail(fmt.Errorf("UDP stream listeners are not supported"))
-	}
-	proxyProtocol := config.GlobalConfig.Apisix.ProxyProtocol
-	if proxyProtocol.EnableTCPPP {
-		return fail(fmt.Errorf("stream PROXY protocol is not supported"))
-	}
-	if proxyProtocol.EnableTCPPPToUpstream {
-		return fail(fmt.Errorf("upstream PROXY protocol is not supported"))
+	if err := validateStreamProxyConfig(cfg); err != nil {
+		return fail(err)
 	}
+	streamConfig := streamListenConfig(cfg)
 
 	// Serialize the initial load/publication with acknowledged dynamic reloads.
 	// An event committed after the initial read either blocks here and reloads
 	// the published runtime, or completes first and is included by this read.
 	s.streamReloadMu.Lock()
 	defer s.streamReloadMu.Unlock()
-	routes, candidate, err := s.loadStreamRoutes()
+	routes, candidate, dns, err := s.loadStreamRoutes()
 	if err != nil {
 		return fail(fmt.Errorf("load stream routes: %w", err))
 	}
-	runtime, err := streamruntime.NewRuntime(
+	runtime, err := streamruntime.NewRuntimeWithTLS(
 		ctx,
-		streamConfig.Tcp,
+		streamConfig,
+		streamTLSConfigProvider(cfg),
 		routes,
-		config.GlobalConfig.StreamPlugins,
+		cfg.StreamPlugins,
 		logStreamResult,
 	)
 	if err != nil {
+		dns.Stop()
 		return fail(fmt.Errorf("start stream proxy: %w", err))
 	}
 	s.lifecycleMu.Lock()
 	if s.shutdownRequested {
 		s.lifecycleMu.Unlock()
+		dns.Stop()
 		_ = runtime.Close(context.Background())
 		return fail(context.Canceled)
 	}
 	s.streamRuntime = runtime
 	s.lifecycleMu.Unlock()
 	s.streamRoutes = routes
+	s.replaceStreamDNSLease(dns)
 	store.CommitStreamRouteLastGood(candidate)
 	metrics.SetStreamRoutes(streamRouteIDs(routes))
 	metrics.RecordConfigApplyStageSuccess(metrics.ConfigApplyStageStreams)
@@ -1007,6 +1155,37 @@ func (s *Server) startStreamProxy(ctx context.Context) error {
 	return nil
 }
 
+func validateStreamProxyConfig(cfg *config.Config) error {
+	streamConfig := cfg.Apisix.StreamProxy
+	if len(streamConfig.Tcp) == 0 && len(streamConfig.Udp) == 0 {
+		return fmt.Errorf("stream mode requires at least one TCP or UDP listener")
+	}
+	return nil
+}
+
+// streamListenConfig is apisix.stream_proxy with the apisix.proxy_protocol
+// enable_tcp_pp and enable_tcp_pp_to_upstream switches applied to every TCP
+// listener, as APISIX applies them to its whole stream server.
+func streamListenConfig(cfg *config.Config) config.StreamProxy {
+	proxy := cfg.Apisix.StreamProxy
+	proxyProtocol := cfg.Apisix.ProxyProtocol
+	proxy.Tcp = slices.Clone(proxy.Tcp)
+	for index := range proxy.Tcp {
+		proxy.Tcp[index].ProxyProtocol = proxy.Tcp[index].ProxyProtocol || proxyProtocol.EnableTCPPP
+		proxy.Tcp[index].ProxyProtocolToUpstream = proxy.Tcp[index].ProxyProtocolToUpstream ||
+			proxyProtocol.EnableTCPPPToUpstream
+	}
+	proxy.TrustedAddresses = cfg.Apisix.TrustedAddresses
+	return proxy
+}
+
+// streamTLSConfigProvider builds the stream TLS configuration from cfg, so a
+// reloaded listener set terminates TLS with the settings it was validated
+// against.
+func streamTLSConfigProvider(cfg *config.Config) streamruntime.TLSConfigProvider {
+	return func() (*tls.Config, error) { return buildStreamTLSConfig(cfg) }
+}
+
 func (s *Server) closeStartedStreamRuntime(runtime streamRuntimeOwner) error {
 	s.streamReloadMu.Lock()
 	defer s.streamReloadMu.Unlock()
@@ -1016,6 +1195,7 @@ func (s *Server) closeStartedStreamRuntime(runtime streamRuntimeOwner) error {
 	err := runtime.Close(context.Background())
 	s.streamRuntime = nil
 	s.streamRoutes = nil
+	s.replaceStreamDNSLease(nil)
 	metrics.SetStreamRoutes(nil)
 	if err != nil {
 		return fmt.Errorf("stop stream runtime after startup failure: %w", err)
@@ -1026,10 +1206,11 @@ func (s *Server) closeStartedStreamRuntime(runtime streamRuntimeOwner) error {
 // startPrometheusExportServer starts the prometheus export server when the
 // plugin is enabled and retains it as an owned lifecycle resource.
 func (s *Server) startPrometheusExportServer() error {
-	if config.GlobalConfig == nil {
+	cfg := config.Global()
+	if cfg == nil {
 		return nil
 	}
-	if !prometheusEnabled(config.GlobalConfig) {
+	if !prometheusEnabled(cfg) {
 		return nil
 	}
 	if err := metrics.Init(); err != nil {
@@ -1060,16 +1241,39 @@ func prometheusEnabled(cfg *config.Config) bool {
 	return cfg != nil && slices.Contains(cfg.Plugins, "prometheus")
 }
 
-func (s *Server) loadStreamRoutes() ([]resource.StreamRoute, map[string]resource.StreamRoute, error) {
+// loadStreamRoutes resolves the stream routes of the store. The returned DNS
+// lease pins the upstream domains they read; the caller publishes it with the
+// routes through replaceStreamDNSLease or stops it.
+func (s *Server) loadStreamRoutes() (
+	[]resource.StreamRoute,
+	map[string]resource.StreamRoute,
+	*resolver.Lease,
+	error,
+) {
 	routes, candidate, err := store.PrepareStreamRoutes()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	resolved, err := resolveStreamRoutesWithServices(routes, store.GetUpstream, store.GetService)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	if err := resolveStreamUpstreamClientCertificates(resolved, store.GetSSL); err != nil {
+		return nil, nil, nil, err
 	}
-	return resolved, candidate, nil
+	var dns *resolver.Lease
+	if s.resolver != nil {
+		dns = s.resolver.Lease()
+	}
+	resolveStreamUpstreamDomains(resolved, dns)
+	return resolved, candidate, dns, nil
+}
+
+// replaceStreamDNSLease publishes the DNS lease of the published stream routes
+// and releases the previous one. The caller holds streamReloadMu.
+func (s *Server) replaceStreamDNSLease(dns *resolver.Lease) {
+	s.streamDNSLease.Stop()
+	s.streamDNSLease = dns
 }
 
 func (s *Server) reloadStreamRoutes() error {
@@ -1083,18 +1287,21 @@ func (s *Server) reloadStreamRoutesIfStarted() (bool, error) {
 	if s.streamRuntime == nil {
 		return false, nil
 	}
-	routes, candidate, err := s.loadStreamRoutes()
+	routes, candidate, dns, err := s.loadStreamRoutes()
 	if err != nil {
 		return true, err
 	}
 	if reflect.DeepEqual(routes, s.streamRoutes) {
+		s.replaceStreamDNSLease(dns)
 		store.CommitStreamRouteLastGood(candidate)
 		return true, nil
 	}
 	if err := s.streamRuntime.Reload(routes); err != nil {
+		dns.Stop()
 		return true, err
 	}
 	s.streamRoutes = routes
+	s.replaceStreamDNSLease(dns)
 	store.CommitStreamRouteLastGood(candidate)
 	metrics.SetStreamRoutes(streamRouteIDs(routes))
 	return true, nil
@@ -1160,6 +1367,31 @@ func resolveStreamRoutesWithServices(
 	return resolved, nil
 }
 
+// resolveStreamUpstreamClientCertificates replaces each upstream client_cert_id
+// with the certificate and key of its SSL object, so the stream runtime only
+// sees inline client certificates.
+func resolveStreamUpstreamClientCertificates(
+	routes []resource.StreamRoute,
+	resolveSSL func(string) (resource.SSL, error),
+) error {
+	for index := range routes {
+		upstreamTLS := routes[index].Upstream.TLS
+		if upstreamTLS == nil || upstreamTLS.ClientCertID == nil {
+			continue
+		}
+		clientCert, clientKey, err := route.ResolveUpstreamClientCertificatePEM(routes[index].Upstream, resolveSSL)
+		if err != nil {
+			return fmt.Errorf("stream route %q: %w", routes[index].ID, err)
+		}
+		routes[index].Upstream.TLS = &resource.UpstreamTLS{
+			ClientCert: clientCert,
+			ClientKey:  clientKey,
+			Verify:     upstreamTLS.Verify,
+		}
+	}
+	return nil
+}
+
 func mergeStreamService(route *resource.StreamRoute, service resource.Service) {
 	if route == nil {
 		return
@@ -1292,7 +1524,8 @@ func logStreamResult(result streamruntime.Result) {
 }
 
 func (s *Server) startConfigProvider(ctx context.Context) error {
-	provider := standaloneConfigProvider(config.GlobalConfig)
+	cfg := config.Global()
+	provider := standaloneConfigProvider(cfg)
 	if provider != "" {
 		path := config.StandaloneConfigFile(provider)
 		watcher := config.NewStandaloneFileWatcher(path, provider, s.events)
@@ -1353,6 +1586,9 @@ func (s *Server) startConfigProvider(ctx context.Context) error {
 		})
 		return nil
 	}
+	if controlPlaneConfigProvider(cfg) {
+		return s.startControlPlaneClient(ctx)
+	}
 	return s.startEtcdWatcher(ctx)
 }
 
@@ -1395,7 +1631,7 @@ func standaloneConfigProvider(cfg *config.Config) string {
 }
 
 func (s *Server) startEtcdWatcher(ctx context.Context) error {
-	etcdConfig := config.GlobalConfig.Deployment.Etcd
+	etcdConfig := config.Global().Deployment.Etcd
 	prefix := etcdConfig.Prefix
 	endpoints := etcdConfig.Host
 	username := etcdConfig.User
@@ -1420,7 +1656,7 @@ func (s *Server) startEtcdWatcher(ctx context.Context) error {
 		username,
 		password,
 		prefix,
-		s.events,
+		s.etcdWatcherEvents(ctx),
 		etcdClientOptions(etcdConfig, tlsConfig),
 	)
 	if err != nil {
@@ -1519,16 +1755,17 @@ func etcdTLSRequired(endpoints []string, tlsConfig config.EtcdTLS) bool {
 }
 
 func serverInfoReportingEnabled() bool {
-	if !pluginConfigured("server-info") || config.GlobalConfig == nil {
+	cfg := config.Global()
+	if !pluginConfigured("server-info") || cfg == nil {
 		return false
 	}
-	if strings.EqualFold(config.GlobalConfig.Deployment.Role, "data_plane") {
+	if strings.EqualFold(cfg.Deployment.Role, "data_plane") {
 		return false
 	}
-	return strings.EqualFold(config.GlobalConfig.Deployment.RoleTraditional.ConfigProvider, "etcd")
+	return strings.EqualFold(cfg.Deployment.RoleTraditional.ConfigProvider, "etcd")
 }
 
-// startHTTPListeners binds every configured HTTP and TLS listener and blocks
+// startHTTPListeners binds every configured HTTP, TLS and HTTP/3 listener and blocks
 // until ctx is cancelled or a listener fails. Bind and serve failures are
 // returned so the command can cancel the root context and enter the normal
 // shutdown path.
@@ -1537,45 +1774,43 @@ func (s *Server) startHTTPListeners(ctx context.Context) error {
 	if len(addrs) == 0 {
 		addrs = []string{s.addr}
 	}
-	tlsAddrs := configuredTLSListenAddresses()
+	cfg := config.Global()
+	plan, err := planHTTPListeners(addrs, configuredTLSListenAddresses(cfg))
+	if err != nil {
+		return err
+	}
+	plan, err = planProxyProtocolListeners(plan, cfg)
+	if err != nil {
+		return err
+	}
 	var tlsConfig *tls.Config
-	if len(tlsAddrs) > 0 {
-		var err error
-		tlsConfig, err = buildFrontendTLSConfig()
+	if planServesTLS(plan) {
+		tlsConfig, err = buildFrontendTLSConfig(cfg)
 		if err != nil {
 			return fmt.Errorf("build frontend TLS config: %w", err)
 		}
 	}
-	serveErrors := make(chan error, len(addrs)+len(tlsAddrs))
-	listeners := make([]net.Listener, 0, len(addrs)+len(tlsAddrs))
-	for _, addr := range addrs {
-		logger.Infof("listening on %s", addr)
-		listener, err := net.Listen("tcp", addr)
+	http3Addrs := configuredHTTP3ListenAddresses(cfg)
+	var http3TLSConfig *tls.Config
+	if len(http3Addrs) > 0 {
+		http3TLSConfig, err = buildHTTP3TLSConfig(cfg)
 		if err != nil {
-			s.closeOwnedListeners()
-			return fmt.Errorf("open listener %s: %w", addr, err)
+			return fmt.Errorf("build HTTP/3 TLS config: %w", err)
 		}
-		s.retainListener(listener)
-		listeners = append(listeners, listener)
 	}
-	for _, addr := range tlsAddrs {
-		logger.Infof("listening with TLS on %s", addr)
-		listener, err := net.Listen("tcp", addr)
-		if err != nil {
-			s.closeOwnedListeners()
-			return fmt.Errorf("open TLS listener %s: %w", addr, err)
-		}
-		tlsListener := tls.NewListener(listener, tlsConfig)
-		s.retainListener(tlsListener)
-		listeners = append(listeners, tlsListener)
+	bindings, _, err := s.bindHTTPListeners(plan, nil)
+	if err != nil {
+		return err
 	}
-	for _, listener := range listeners {
-		go func(listener net.Listener) {
-			defer s.releaseListener(listener)
-			if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				serveErrors <- err
-			}
-		}(listener)
+	serveErrors := make(chan error, 1)
+	s.httpMu.Lock()
+	s.httpBindings = bindings
+	s.serveErrors = serveErrors
+	server := s.server
+	s.httpMu.Unlock()
+	s.serveHTTPGeneration(server, cfg, plan, bindings, tlsConfig)
+	if err := s.startHTTP3Listeners(http3Addrs, http3TLSConfig); err != nil {
+		return err
 	}
 
 	select {
@@ -1586,14 +1821,185 @@ func (s *Server) startHTTPListeners(ctx context.Context) error {
 	}
 }
 
-func frontendHTTP2Enabled() bool {
-	if config.GlobalConfig == nil {
+type httpListenAddress struct {
+	address string
+	tls     bool
+	// proxyProtocol requires a PROXY protocol header on every connection.
+	proxyProtocol bool
+}
+
+func planHTTPListeners(addrs, tlsAddrs []string) ([]httpListenAddress, error) {
+	plan := make([]httpListenAddress, 0, len(addrs)+len(tlsAddrs))
+	seen := make(map[string]struct{}, len(addrs)+len(tlsAddrs))
+	for _, entry := range slices.Concat(
+		listenAddressesWithTLS(addrs, false),
+		listenAddressesWithTLS(tlsAddrs, true),
+	) {
+		if _, ok := seen[entry.address]; ok {
+			return nil, fmt.Errorf("listen address %s is configured more than once", entry.address)
+		}
+		seen[entry.address] = struct{}{}
+		plan = append(plan, entry)
+	}
+	return plan, nil
+}
+
+// planProxyProtocolListeners marks node_listen entries with proxy_protocol and
+// adds the apisix.proxy_protocol listen_http_port and listen_https_port
+// listeners, which APISIX binds on all interfaces.
+func planProxyProtocolListeners(plan []httpListenAddress, cfg *config.Config) ([]httpListenAddress, error) {
+	if cfg == nil {
+		return plan, nil
+	}
+	for _, listen := range cfg.Apisix.NodeListen {
+		if !listen.ProxyProtocol {
+			continue
+		}
+		host := strings.TrimSpace(listen.Ip)
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(listen.Port))
+		for index := range plan {
+			if plan[index].address == address {
+				plan[index].proxyProtocol = true
+			}
+		}
+	}
+	proxyProtocol := cfg.Apisix.ProxyProtocol
+	for _, port := range []struct {
+		number int
+		tls    bool
+	}{
+		{number: proxyProtocol.ListenHTTPPort},
+		{number: proxyProtocol.ListenHTTPSPort, tls: true},
+	} {
+		if port.number == 0 {
+			continue
+		}
+		address := net.JoinHostPort("0.0.0.0", strconv.Itoa(port.number))
+		if slices.ContainsFunc(plan, func(entry httpListenAddress) bool { return entry.address == address }) {
+			return nil, fmt.Errorf("listen address %s is configured more than once", address)
+		}
+		plan = append(plan, httpListenAddress{address: address, tls: port.tls, proxyProtocol: true})
+	}
+	return plan, nil
+}
+
+func planServesTLS(plan []httpListenAddress) bool {
+	return slices.ContainsFunc(plan, func(entry httpListenAddress) bool { return entry.tls })
+}
+
+func listenAddressesWithTLS(addrs []string, tls bool) []httpListenAddress {
+	entries := make([]httpListenAddress, 0, len(addrs))
+	for _, address := range addrs {
+		entries = append(entries, httpListenAddress{address: address, tls: tls})
+	}
+	return entries
+}
+
+// bindHTTPListeners reuses the sockets in current whose address is still
+// planned and binds the rest. A bind failure closes only the sockets opened by
+// this call.
+func (s *Server) bindHTTPListeners(
+	plan []httpListenAddress,
+	current map[string]*sharedListener,
+) (map[string]*sharedListener, []*sharedListener, error) {
+	bindings := make(map[string]*sharedListener, len(plan))
+	var opened []*sharedListener
+	for _, entry := range plan {
+		if shared, ok := current[entry.address]; ok {
+			bindings[entry.address] = shared
+			continue
+		}
+		if entry.tls {
+			logger.Infof("listening with TLS on %s", entry.address)
+		} else {
+			logger.Infof("listening on %s", entry.address)
+		}
+		listener, err := net.Listen("tcp", entry.address)
+		if err != nil {
+			s.closeSharedListeners(opened)
+			if entry.tls {
+				return nil, nil, fmt.Errorf("open TLS listener %s: %w", entry.address, err)
+			}
+			return nil, nil, fmt.Errorf("open listener %s: %w", entry.address, err)
+		}
+		shared := newSharedListener(listener)
+		s.retainListener(shared)
+		bindings[entry.address] = shared
+		opened = append(opened, shared)
+	}
+	return bindings, opened, nil
+}
+
+func (s *Server) closeSharedListeners(listeners []*sharedListener) {
+	for _, listener := range listeners {
+		s.releaseListener(listener)
+		_ = listener.Close()
+	}
+}
+
+func (s *Server) serveHTTPGeneration(
+	server *http.Server,
+	cfg *config.Config,
+	plan []httpListenAddress,
+	bindings map[string]*sharedListener,
+	tlsConfig *tls.Config,
+) {
+	var trusted []*net.IPNet
+	if cfg != nil {
+		trusted = util.ParseTrustedNetworks(cfg.Apisix.TrustedAddresses)
+	}
+	for _, entry := range plan {
+		listener := bindings[entry.address].handoff()
+		if entry.proxyProtocol {
+			listener = proxyprotocol.NewListener(listener, trusted)
+		}
+		if entry.tls {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.reportServeError(err)
+			}
+		}()
+	}
+}
+
+func (s *Server) reportServeError(err error) {
+	s.httpMu.Lock()
+	serveErrors := s.serveErrors
+	s.httpMu.Unlock()
+	select {
+	case serveErrors <- err:
+	default:
+	}
+}
+
+// httpServers returns the serving HTTP generation followed by the retired
+// generations that are still draining.
+func (s *Server) httpServers() []*http.Server {
+	s.httpMu.Lock()
+	defer s.httpMu.Unlock()
+	servers := make([]*http.Server, 0, 1+len(s.drainingServers))
+	if s.server != nil {
+		servers = append(servers, s.server)
+	}
+	for server := range s.drainingServers {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func frontendHTTP2Enabled(cfg *config.Config) bool {
+	if cfg == nil {
 		return false
 	}
-	if config.GlobalConfig.Apisix.EnableHttp2 {
+	if cfg.Apisix.EnableHttp2 {
 		return true
 	}
-	for _, listener := range config.GlobalConfig.Apisix.Ssl.Listen {
+	for _, listener := range cfg.Apisix.Ssl.Listen {
 		if listener.EnableHttp2 {
 			return true
 		}
@@ -1601,14 +2007,14 @@ func frontendHTTP2Enabled() bool {
 	return false
 }
 
-func frontendPlainHTTP2Enabled() bool {
-	if config.GlobalConfig == nil {
+func frontendPlainHTTP2Enabled(cfg *config.Config) bool {
+	if cfg == nil {
 		return false
 	}
-	if config.GlobalConfig.Apisix.EnableHttp2 {
+	if cfg.Apisix.EnableHttp2 {
 		return true
 	}
-	for _, listener := range config.GlobalConfig.Apisix.NodeListen {
+	for _, listener := range cfg.Apisix.NodeListen {
 		if listener.EnableHttp2 {
 			return true
 		}
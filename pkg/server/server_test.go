@@ -683,6 +683,45 @@ func TestStripUntrustedForwardedForPreservesTrustedHeader(t *testing.T) {
 	}
 }
 
+func TestNormalizeForwardedHeadersRecoversClientBehindTrustedProxies(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "untrusted peer", remoteAddr: "203.0.113.9:1000", forwarded: []string{"10.1.1.1"}, want: "203.0.113.9"},
+		{name: "single hop", remoteAddr: "127.0.0.1:1000", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{
+			name:       "skips trusted hops",
+			remoteAddr: "127.0.0.1:1000",
+			forwarded:  []string{"6.6.6.6, 198.51.100.7", "127.0.0.2"},
+			want:       "198.51.100.7",
+		},
+		{name: "all trusted", remoteAddr: "127.0.0.1:1000", forwarded: []string{"127.0.0.3, 127.0.0.2"}, want: "127.0.0.3"},
+		{name: "malformed hop", remoteAddr: "127.0.0.1:1000", forwarded: []string{"garbage, 127.0.0.2"}, want: "127.0.0.2"},
+		{name: "no header", remoteAddr: "[::1]:1000", want: "::1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			handler := normalizeForwardedHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = apisixctx.ClientIP(r)
+			}), []string{"127.0.0.0/24", "::1"})
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != test.want {
+				t.Fatalf("ClientIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
 func TestNormalizeForwardedHeadersSetsObservedHostAndPort(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -763,7 +802,7 @@ func TestConfiguredHTTPServerUsesSafeHeaderAndIdleDefaults(t *testing.T) {
 	previous := config.GlobalConfig
 	t.Cleanup(func() { config.GlobalConfig = previous })
 	config.GlobalConfig = &config.Config{Plugins: []string{"prometheus"}}
-	server := newConfiguredHTTPServer(http.NotFoundHandler())
+	server := newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig)
 	if server.ReadHeaderTimeout != 10*time.Second {
 		t.Fatalf("ReadHeaderTimeout = %s, want 10s", server.ReadHeaderTimeout)
 	}
@@ -783,7 +822,7 @@ func TestConfiguredHTTPServerSkipsConnectionObserverWithoutPrometheus(t *testing
 	t.Cleanup(func() { config.GlobalConfig = previous })
 	config.GlobalConfig = &config.Config{Plugins: []string{"limit-req"}}
 
-	if observer := newConfiguredHTTPServer(http.NotFoundHandler()).ConnState; observer != nil {
+	if observer := newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig).ConnState; observer != nil {
 		t.Fatal("configured HTTP server installed a Prometheus connection observer while metrics are disabled")
 	}
 }
@@ -956,7 +995,7 @@ func TestConfiguredServerUsesNodeListenAndHTTPTimeouts(t *testing.T) {
 		t.Fatalf("configuredListenAddresses() = %#v, want %#v", got, want)
 	}
 
-	server := newConfiguredHTTPServer(http.NotFoundHandler())
+	server := newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig)
 	if server.IdleTimeout != 60*time.Second {
 		t.Fatalf("IdleTimeout = %s, want 1m0s", server.IdleTimeout)
 	}
@@ -982,7 +1021,7 @@ func TestConfiguredTLSListenAddresses(t *testing.T) {
 		},
 	}}}
 
-	if got, want := configuredTLSListenAddresses(), []string{
+	if got, want := configuredTLSListenAddresses(config.GlobalConfig), []string{
 		"0.0.0.0:9443",
 		"127.0.0.2:9444",
 	}; !reflect.DeepEqual(got, want) {
@@ -990,7 +1029,7 @@ func TestConfiguredTLSListenAddresses(t *testing.T) {
 	}
 
 	config.GlobalConfig.Apisix.Ssl.Enable = false
-	if got := configuredTLSListenAddresses(); len(got) != 0 {
+	if got := configuredTLSListenAddresses(config.GlobalConfig); len(got) != 0 {
 		t.Fatalf("configuredTLSListenAddresses() = %#v, want no disabled listeners", got)
 	}
 }
@@ -1005,7 +1044,7 @@ func TestConfiguredHTTPServerAndFrontendTLSAdvertiseHTTP2(t *testing.T) {
 		SslCiphers:   "ECDHE-RSA-AES128-GCM-SHA256",
 	}}}
 
-	server := newConfiguredHTTPServer(http.NotFoundHandler())
+	server := newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig)
 	if _, ok := server.TLSNextProto["h2"]; !ok {
 		t.Fatal("configured HTTP server does not install an HTTP/2 handler")
 	}
@@ -1031,7 +1070,7 @@ func TestConfiguredHTTPServerEnablesH2COnlyForPlaintextListener(t *testing.T) {
 		Port: 9080, EnableHttp2: true,
 	}}}}
 
-	server := newConfiguredHTTPServer(http.NotFoundHandler())
+	server := newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig)
 	if !server.Protocols.UnencryptedHTTP2() {
 		t.Fatal("explicit plaintext HTTP/2 listener did not enable h2c")
 	}
@@ -1098,6 +1137,7 @@ func TestStandaloneConfigProviderSelection(t *testing.T) {
 		{name: "yaml data plane", role: "data_plane", provider: "yaml", want: true},
 		{name: "json data plane", role: "data_plane", provider: "json", want: true},
 		{name: "etcd data plane", role: "data_plane", provider: "etcd", want: false},
+		{name: "control plane data plane", role: "data_plane", provider: "control_plane", want: false},
 		{name: "yaml traditional", role: "traditional", provider: "yaml", want: false},
 	}
 
@@ -1345,7 +1385,7 @@ func TestFrontendTLSGetCertificateSelectsFromPublishedIndex(t *testing.T) {
 			Ssl: config.Ssl{FallbackSNI: "api.example.test"},
 		},
 	}
-	fallback, err := getCertificate(&tls.ClientHelloInfo{})
+	fallback, err := mustFrontendTLSConfig(t).GetCertificate(&tls.ClientHelloInfo{})
 	if err != nil {
 		t.Fatalf("GetCertificate(empty SNI with fallback) error = %v", err)
 	}
@@ -1359,21 +1399,21 @@ func TestFrontendHTTP2DefaultsWithoutConfig(t *testing.T) {
 	t.Cleanup(func() { config.GlobalConfig = previous })
 
 	config.GlobalConfig = nil
-	if frontendHTTP2Enabled() {
+	if frontendHTTP2Enabled(config.GlobalConfig) {
 		t.Fatal("frontendHTTP2Enabled() = true without config")
 	}
-	if frontendPlainHTTP2Enabled() {
+	if frontendPlainHTTP2Enabled(config.GlobalConfig) {
 		t.Fatal("frontendPlainHTTP2Enabled() = true without config")
 	}
-	if got := configuredTLSListenAddresses(); got != nil {
+	if got := configuredTLSListenAddresses(config.GlobalConfig); got != nil {
 		t.Fatalf("configuredTLSListenAddresses() = %#v, want nil without config", got)
 	}
 
 	config.GlobalConfig = &config.Config{}
-	if frontendHTTP2Enabled() {
+	if frontendHTTP2Enabled(config.GlobalConfig) {
 		t.Fatal("frontendHTTP2Enabled() = true with default config")
 	}
-	if frontendPlainHTTP2Enabled() {
+	if frontendPlainHTTP2Enabled(config.GlobalConfig) {
 		t.Fatal("frontendPlainHTTP2Enabled() = true with default config")
 	}
 }
@@ -1408,7 +1448,7 @@ func TestStartReturnsListenError(t *testing.T) {
 	server := &Server{
 		addr:   address,
 		addrs:  []string{address},
-		server: newConfiguredHTTPServer(http.NotFoundHandler()),
+		server: newConfiguredHTTPServer(http.NotFoundHandler(), config.GlobalConfig),
 	}
 	done := make(chan error, 1)
 	go func() { done <- server.startHTTPListeners(t.Context()) }()
@@ -1647,3 +1687,40 @@ func TestStartPrometheusExportServerWithoutPrometheus(t *testing.T) {
 		t.Fatal("export server started without prometheus plugin")
 	}
 }
+
+func TestPlanProxyProtocolListeners(t *testing.T) {
+	cfg := &config.Config{Apisix: config.Apisix{
+		NodeListen: []config.NodeListen{
+			{Port: 9080},
+			{Ip: "127.0.0.1", Port: 9081, ProxyProtocol: true},
+		},
+		ProxyProtocol: config.ProxyProtocol{ListenHTTPPort: 9181, ListenHTTPSPort: 9182},
+	}}
+	plan, err := planProxyProtocolListeners(
+		listenAddressesWithTLS([]string{"0.0.0.0:9080", "127.0.0.1:9081"}, false),
+		cfg,
+	)
+	if err != nil {
+		t.Fatalf("planProxyProtocolListeners() error = %v", err)
+	}
+	want := []httpListenAddress{
+		{address: "0.0.0.0:9080"},
+		{address: "127.0.0.1:9081", proxyProtocol: true},
+		{address: "0.0.0.0:9181", proxyProtocol: true},
+		{address: "0.0.0.0:9182", tls: true, proxyProtocol: true},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Fatalf("planProxyProtocolListeners() = %#v, want %#v", plan, want)
+	}
+	if !planServesTLS(plan) {
+		t.Fatal("planServesTLS() = false for a PROXY protocol HTTPS port")
+	}
+
+	cfg.Apisix.ProxyProtocol.ListenHTTPPort = 9080
+	if _, err := planProxyProtocolListeners(
+		listenAddressesWithTLS([]string{"0.0.0.0:9080"}, false),
+		cfg,
+	); err == nil || !strings.Contains(err.Error(), "0.0.0.0:9080") {
+		t.Fatalf("planProxyProtocolListeners() error = %v, want duplicate address rejection", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -154,6 +155,38 @@ func TestResolveStreamRoutesPrefersRouteUpstreamOverService(t *testing.T) {
 	}
 }
 
+func TestResolveStreamUpstreamClientCertificatesInlinesSSLObject(t *testing.T) {
+	shared := &resource.UpstreamTLS{ClientCertID: "client", Verify: true}
+	routes := []resource.StreamRoute{
+		{ID: "mtls", Upstream: resource.Upstream{Scheme: "tls", TLS: shared}},
+		{ID: "plain", Upstream: resource.Upstream{Scheme: "tcp"}},
+	}
+	err := resolveStreamUpstreamClientCertificates(routes, func(id string) (resource.SSL, error) {
+		if id != "client" {
+			t.Fatalf("SSL lookup id = %q, want client", id)
+		}
+		return resource.SSL{ID: id, Cert: "cert-pem", Key: "key-pem", Status: 1}, nil
+	})
+	if err != nil {
+		t.Fatalf("resolveStreamUpstreamClientCertificates() error = %v", err)
+	}
+	got := routes[0].Upstream.TLS
+	if got.ClientCertID != nil || got.ClientCert != "cert-pem" || got.ClientKey != "key-pem" || !got.Verify {
+		t.Fatalf("resolved upstream TLS = %#v, want the inline SSL certificate", got)
+	}
+	if shared.ClientCertID == nil {
+		t.Fatal("resolution mutated the shared upstream TLS object")
+	}
+
+	routes[0].Upstream.TLS = shared
+	err = resolveStreamUpstreamClientCertificates(routes, func(string) (resource.SSL, error) {
+		return resource.SSL{Cert: "cert-pem", Key: "key-pem"}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), `stream route "mtls"`) {
+		t.Fatalf("resolveStreamUpstreamClientCertificates() error = %v, want disabled SSL rejection", err)
+	}
+}
+
 func TestResolveStreamRoutesRejectsMissingReferencedUpstream(t *testing.T) {
 	_, err := resolveStreamRoutes(
 		[]resource.StreamRoute{{ID: "route", UpstreamID: "missing"}},
@@ -224,9 +257,14 @@ func TestServerShutdownClosesStreamRuntime(t *testing.T) {
 }
 
 type fakeStreamRuntime struct {
-	closed      bool
-	reloadErr   error
-	reloadCalls int
+	closed         bool
+	reloadErr      error
+	reloadCalls    int
+	reconfigureErr error
+	reconfigured   config.StreamProxy
+	streamPlugins  []string
+	// publishedAtReconfigure is config.Global() when Reconfigure was called.
+	publishedAtReconfigure *config.Config
 }
 
 type blockingStreamRuntime struct {
@@ -245,6 +283,15 @@ func (r *blockingStreamRuntime) Reload([]resource.StreamRoute) error {
 	return nil
 }
 
+func (r *blockingStreamRuntime) ReconfigureWithTLS(
+	config.StreamProxy,
+	streamruntime.TLSConfigProvider,
+	[]resource.StreamRoute,
+	[]string,
+) error {
+	return nil
+}
+
 func (r *blockingStreamRuntime) Close(context.Context) error {
 	if r.reloading.Load() {
 		r.closeDuringReload.Store(true)
@@ -260,6 +307,21 @@ func (r *fakeStreamRuntime) Reload([]resource.StreamRoute) error {
 	return r.reloadErr
 }
 
+func (r *fakeStreamRuntime) ReconfigureWithTLS(
+	proxy config.StreamProxy,
+	_ streamruntime.TLSConfigProvider,
+	_ []resource.StreamRoute,
+	enabledPlugins []string,
+) error {
+	r.publishedAtReconfigure = config.Global()
+	if r.reconfigureErr != nil {
+		return r.reconfigureErr
+	}
+	r.reconfigured = proxy
+	r.streamPlugins = enabledPlugins
+	return nil
+}
+
 func (r *fakeStreamRuntime) Close(context.Context) error {
 	r.closed = true
 	return nil
@@ -285,41 +347,11 @@ func TestStartStreamProxyRejectsUnsupportedStreamConfiguration(t *testing.T) {
 				ProxyMode: "stream",
 			}},
 		},
-		{
-			name: "udp listener",
-			cfg: config.Config{Apisix: config.Apisix{
-				ProxyMode: "stream",
-				StreamProxy: config.StreamProxy{
-					Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}},
-					Udp: []string{"127.0.0.1:0"},
-				},
-			}},
-		},
-		{
-			name: "top-level proxy protocol",
-			cfg: config.Config{Apisix: config.Apisix{
-				ProxyMode:     "stream",
-				ProxyProtocol: config.ProxyProtocol{EnableTCPPP: true},
-				StreamProxy: config.StreamProxy{
-					Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}},
-				},
-			}},
-		},
-		{
-			name: "top-level upstream proxy protocol",
-			cfg: config.Config{Apisix: config.Apisix{
-				ProxyMode:     "stream",
-				ProxyProtocol: config.ProxyProtocol{EnableTCPPPToUpstream: true},
-				StreamProxy: config.StreamProxy{
-					Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}},
-				},
-			}},
-		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			config.GlobalConfig = &test.cfg
-			if err := (&Server{}).startStreamProxy(context.Background()); err == nil {
+			if err := (&Server{}).startStreamProxy(context.Background(), config.GlobalConfig); err == nil {
 				t.Fatalf("startStreamProxy() accepted %s", test.name)
 			}
 		})
@@ -338,7 +370,7 @@ func TestStartStreamProxyIgnoresStreamConfigurationInHTTPOnlyMode(t *testing.T)
 	}}
 
 	server := &Server{}
-	if err := server.startStreamProxy(context.Background()); err != nil {
+	if err := server.startStreamProxy(context.Background(), config.GlobalConfig); err != nil {
 		t.Fatalf("startStreamProxy() error = %v, want HTTP-only mode to ignore stream settings", err)
 	}
 	if server.streamRuntime != nil {
@@ -360,7 +392,7 @@ func TestStartStreamProxyPropagatesRouteLoadError(t *testing.T) {
 		},
 	}}
 
-	if err := (&Server{}).startStreamProxy(context.Background()); err == nil {
+	if err := (&Server{}).startStreamProxy(context.Background(), config.GlobalConfig); err == nil {
 		t.Fatal("startStreamProxy() returned nil for an unavailable route store")
 	}
 }
@@ -395,7 +427,7 @@ func TestStartStreamProxyPublishesOnlyAfterCompleteRuntimeSuccess(t *testing.T)
 	}}
 
 	server := &Server{}
-	if err := server.startStreamProxy(context.Background()); err != nil {
+	if err := server.startStreamProxy(context.Background(), config.GlobalConfig); err != nil {
 		t.Fatalf("startStreamProxy() error = %v", err)
 	}
 	runtime, ok := server.streamRuntime.(*streamruntime.Runtime)
@@ -405,6 +437,47 @@ func TestStartStreamProxyPublishesOnlyAfterCompleteRuntimeSuccess(t *testing.T)
 	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
 }
 
+func TestStartStreamProxyStartsUDPOnlyListeners(t *testing.T) {
+	previousConfig := config.GlobalConfig
+	previousStore := store.ReplaceGlobalStoreForTest(nil)
+	events := make(chan *store.Event)
+	storage, err := store.GetStore(t.TempDir()+"/stream-udp.db", events)
+	if err != nil {
+		t.Fatalf("get store: %v", err)
+	}
+	storage.Start()
+	t.Cleanup(func() {
+		config.GlobalConfig = previousConfig
+		store.ReplaceGlobalStoreForTest(previousStore)
+		_ = storage.Stop()
+	})
+	events <- &store.Event{
+		Type:  store.EventTypePut,
+		Key:   []byte("/apisix/stream_routes/dns"),
+		Value: []byte(`{"id":"dns","upstream":{"scheme":"udp","nodes":{"127.0.0.1:53":1}}}`),
+	}
+	if err := storage.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	config.GlobalConfig = &config.Config{Apisix: config.Apisix{
+		ProxyMode:   "stream",
+		StreamProxy: config.StreamProxy{Udp: []string{"127.0.0.1:0"}},
+	}}
+
+	server := &Server{}
+	if err := server.startStreamProxy(context.Background(), config.GlobalConfig); err != nil {
+		t.Fatalf("startStreamProxy() error = %v", err)
+	}
+	runtime, ok := server.streamRuntime.(*streamruntime.Runtime)
+	if !ok || runtime == nil {
+		t.Fatalf("stream runtime = %#v, want a published runtime", server.streamRuntime)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	if addresses := runtime.Addresses(); len(addresses) != 1 || !strings.HasPrefix(addresses[0], "udp://") {
+		t.Fatalf("Addresses() = %v, want one UDP listener", addresses)
+	}
+}
+
 func TestCloseStartedStreamRuntimeClearsAfterCleanupError(t *testing.T) {
 	cleanupErr := errors.New("cleanup failed")
 	runtime := &streamRuntimeCloseError{err: cleanupErr}
@@ -493,6 +566,15 @@ func (r *streamRuntimeCloseError) Reload([]resource.StreamRoute) error {
 	return nil
 }
 
+func (r *streamRuntimeCloseError) ReconfigureWithTLS(
+	config.StreamProxy,
+	streamruntime.TLSConfigProvider,
+	[]resource.StreamRoute,
+	[]string,
+) error {
+	return nil
+}
+
 func (r *streamRuntimeCloseError) Close(context.Context) error {
 	return r.err
 }
@@ -879,7 +961,7 @@ func TestStartStreamProxyRecordsInitialConfigApplyStage(t *testing.T) {
 		t.Fatalf("seed stream route: %v", err)
 	}
 	server := &Server{}
-	if err := server.startStreamProxy(context.Background()); err != nil {
+	if err := server.startStreamProxy(context.Background(), config.GlobalConfig); err != nil {
 		t.Fatalf("startStreamProxy() error = %v", err)
 	}
 	t.Cleanup(func() { _ = server.streamRuntime.Close(context.Background()) })
@@ -916,10 +998,33 @@ func TestStartStreamProxyRecordsInitialConfigApplyFailure(t *testing.T) {
 			Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}},
 		},
 	}}
-	if err := (&Server{}).startStreamProxy(context.Background()); err == nil {
+	if err := (&Server{}).startStreamProxy(context.Background(), config.GlobalConfig); err == nil {
 		t.Fatal("startStreamProxy() error = nil, want route-load failure")
 	}
 	if metrics.GetReadiness().ConfigApplyReady {
 		t.Fatal("config readiness = true after initial stream publication failure")
 	}
 }
+
+func TestStreamListenConfigAppliesGlobalProxyProtocolFlags(t *testing.T) {
+	cfg := &config.Config{Apisix: config.Apisix{
+		StreamProxy: config.StreamProxy{Tcp: []config.TcpListen{
+			{Addr: "127.0.0.1:9100"},
+			{Addr: "127.0.0.1:9101", ProxyProtocolToUpstream: true},
+		}},
+	}}
+	if got := streamListenConfig(cfg); got.Tcp[0].ProxyProtocol || !got.Tcp[1].ProxyProtocolToUpstream {
+		t.Fatalf("streamListenConfig() = %+v, want per-listener flags unchanged", got.Tcp)
+	}
+
+	cfg.Apisix.ProxyProtocol = config.ProxyProtocol{EnableTCPPP: true, EnableTCPPPToUpstream: true}
+	got := streamListenConfig(cfg)
+	for _, listen := range got.Tcp {
+		if !listen.ProxyProtocol || !listen.ProxyProtocolToUpstream {
+			t.Fatalf("streamListenConfig() listener %+v, want global PROXY protocol flags applied", listen)
+		}
+	}
+	if cfg.Apisix.StreamProxy.Tcp[0].ProxyProtocol {
+		t.Fatal("streamListenConfig() mutated the configured listeners")
+	}
+}
@@ -76,7 +76,7 @@ func TestFrontendTLSHandshakeUsesPerResourceClientCA(t *testing.T) {
 		SslProtocols: "TLSv1.2",
 		SslCiphers:   frontendTLS12Cipher,
 	}}}
-	serverConfig, err := buildFrontendTLSConfig()
+	serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
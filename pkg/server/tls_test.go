@@ -31,7 +31,7 @@ const frontendTLS12Cipher = "ECDHE-RSA-AES128-GCM-SHA256"
 
 func mustFrontendTLSConfig(t testing.TB) *tls.Config {
 	t.Helper()
-	tlsConfig, err := buildFrontendTLSConfig()
+	tlsConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
@@ -67,7 +67,7 @@ func TestFrontendTLSProtocolConfigStrict(t *testing.T) {
 				config.GlobalConfig.Apisix.Ssl.SslCiphers = ""
 			}
 
-			got, err := buildFrontendTLSConfig()
+			got, err := buildFrontendTLSConfig(config.GlobalConfig)
 			if test.wantErr != "" {
 				if err == nil || !strings.Contains(strings.ToLower(err.Error()), test.wantErr) {
 					t.Fatalf("buildFrontendTLSConfig() error = %v, want %q", err, test.wantErr)
@@ -107,7 +107,7 @@ func TestFrontendTLSCipherConfigStrict(t *testing.T) {
 				SslProtocols: "TLSv1.2",
 				SslCiphers:   test.ciphers,
 			}}}
-			_, err := buildFrontendTLSConfig()
+			_, err := buildFrontendTLSConfig(config.GlobalConfig)
 			if test.wantErr == "" {
 				if err != nil {
 					t.Fatalf("buildFrontendTLSConfig() error = %v", err)
@@ -122,7 +122,8 @@ func TestFrontendTLSCipherConfigStrict(t *testing.T) {
 
 	config.GlobalConfig.Apisix.Ssl.SslProtocols = "TLSv1.3"
 	config.GlobalConfig.Apisix.Ssl.SslCiphers = frontendTLS12Cipher
-	if _, err := buildFrontendTLSConfig(); err == nil || !strings.Contains(strings.ToLower(err.Error()), "tls 1.2") {
+	_, err := buildFrontendTLSConfig(config.GlobalConfig)
+	if err == nil || !strings.Contains(strings.ToLower(err.Error()), "tls 1.2") {
 		t.Fatalf("TLS 1.3-only cipher policy error = %v, want TLS 1.2 explanation", err)
 	}
 }
@@ -143,7 +144,7 @@ func TestFrontendTLSSessionTicketsAndClientCA(t *testing.T) {
 		SslTrustedCertificate: caPath,
 	}}}
 
-	tlsConfig, err := buildFrontendTLSConfig()
+	tlsConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
@@ -182,7 +183,7 @@ func TestFrontendTLSSessionTicketsControlResumption(t *testing.T) {
 				SslCiphers:        frontendTLS12Cipher,
 				SslSessionTickets: test.tickets,
 			}}}
-			serverConfig, err := buildFrontendTLSConfig()
+			serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 			if err != nil {
 				t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 			}
@@ -271,7 +272,7 @@ func TestFrontendTLSHandshakeSelectsExactWildcardAndFallbackSNI(t *testing.T) {
 		SslCiphers:   frontendTLS12Cipher,
 		FallbackSNI:  "fallback.example.test",
 	}}}
-	serverConfig, err := buildFrontendTLSConfig()
+	serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
@@ -301,6 +302,32 @@ func TestFrontendTLSHandshakeSelectsExactWildcardAndFallbackSNI(t *testing.T) {
 	}
 }
 
+func TestStreamTLSConfigOffersNoALPN(t *testing.T) {
+	previousConfig := config.GlobalConfig
+	t.Cleanup(func() { config.GlobalConfig = previousConfig })
+	config.GlobalConfig = &config.Config{}
+	serverConfig, err := buildStreamTLSConfig(config.GlobalConfig)
+	if err != nil {
+		t.Fatalf("buildStreamTLSConfig() error = %v", err)
+	}
+	serverConfig.Certificates = []tls.Certificate{frontendHandshakeCertificate(
+		t,
+		"stream-certificate",
+		nil,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	)}
+	state, clientErr, serverErr := frontendTLSHandshake(serverConfig, &tls.Config{
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	})
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("TLS handshake errors = client %v/server %v", clientErr, serverErr)
+	}
+	if state.NegotiatedProtocol != "" {
+		t.Fatalf("negotiated protocol = %q, want none for a stream listener", state.NegotiatedProtocol)
+	}
+}
+
 func TestFrontendTLSHandshakeEnforcesConfiguredProtocols(t *testing.T) {
 	serverCertificate := frontendHandshakeCertificate(
 		t,
@@ -330,7 +357,7 @@ func TestFrontendTLSHandshakeEnforcesConfiguredProtocols(t *testing.T) {
 			if test.serverConfig == "TLSv1.3" {
 				config.GlobalConfig.Apisix.Ssl.SslCiphers = ""
 			}
-			serverConfig, err := buildFrontendTLSConfig()
+			serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 			if err != nil {
 				t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 			}
@@ -360,7 +387,7 @@ func TestFrontendTLSHandshakeSelectsConfiguredCipher(t *testing.T) {
 		SslProtocols: "TLSv1.2",
 		SslCiphers:   frontendTLS12Cipher,
 	}}}
-	serverConfig, err := buildFrontendTLSConfig()
+	serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
@@ -409,7 +436,7 @@ func TestFrontendTLSHandshakeRequiresTrustedClientCertificate(t *testing.T) {
 		SslCiphers:            frontendTLS12Cipher,
 		SslTrustedCertificate: caPath,
 	}}}
-	serverConfig, err := buildFrontendTLSConfig()
+	serverConfig, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err != nil {
 		t.Fatalf("buildFrontendTLSConfig() error = %v", err)
 	}
@@ -549,7 +576,7 @@ func TestFrontendTLSConfigRejectsMalformedTrustedCA(t *testing.T) {
 		SslProtocols:          "TLSv1.3",
 		SslTrustedCertificate: caPath,
 	}}}
-	_, err := buildFrontendTLSConfig()
+	_, err := buildFrontendTLSConfig(config.GlobalConfig)
 	if err == nil || !strings.Contains(strings.ToLower(err.Error()), "ca") {
 		t.Fatalf("buildFrontendTLSConfig() error = %v, want CA parsing context", err)
 	}
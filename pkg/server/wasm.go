@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/plugin/wasm_plugin"
+	"github.com/wklken/apisix-go/pkg/wasm"
+)
+
+var (
+	// registeredWasmPlugins records the wasm.plugins entries registered as
+	// plugin factories. Registration is once per process, so a later server
+	// reuses an identical entry and rejects a changed one.
+	registeredWasmPlugins   = map[string]config.WasmPlugin{}
+	registeredWasmPluginsMu sync.Mutex
+)
+
+// loadWasmModules loads every wasm.plugins entry and registers it as a
+// plugin factory. The returned modules are keyed by plugin name.
+func loadWasmModules(ctx context.Context, cfg *config.Config) (map[string]*wasm.Module, error) {
+	if cfg == nil || len(cfg.Wasm.Plugins) == 0 {
+		return nil, nil
+	}
+	modules := make(map[string]*wasm.Module, len(cfg.Wasm.Plugins))
+	for _, entry := range cfg.Wasm.Plugins {
+		module, err := wasm.Load(ctx, entry.Name, entry.File)
+		if err == nil {
+			err = registerWasmPlugin(entry)
+		}
+		if err != nil {
+			closeWasmModules(ctx, modules)
+			if module != nil {
+				_ = module.Close(ctx)
+			}
+			return nil, fmt.Errorf("wasm plugin %s: %w", entry.Name, err)
+		}
+		modules[entry.Name] = module
+	}
+	return modules, nil
+}
+
+func registerWasmPlugin(entry config.WasmPlugin) error {
+	registeredWasmPluginsMu.Lock()
+	defer registeredWasmPluginsMu.Unlock()
+	if previous, ok := registeredWasmPlugins[entry.Name]; ok {
+		if previous.Priority != entry.Priority || previous.HTTPRequestPhase != entry.HTTPRequestPhase {
+			return errors.New("priority and http_request_phase cannot change without a restart")
+		}
+		return nil
+	}
+	stage := plugin.RequestStageAccess
+	if entry.HTTPRequestPhase == "rewrite" {
+		stage = plugin.RequestStageRewrite
+	}
+	name, priority := entry.Name, entry.Priority
+	if err := plugin.Register(plugin.Registration{
+		Name:                name,
+		Factory:             func() plugin.Plugin { return wasm_plugin.New(name, priority) },
+		RequestStage:        plugin.RequestStageSpec{Stage: stage},
+		ConditionalTerminal: true,
+		ResponsePhases:      plugin.ResponsePhaseHeader | plugin.ResponsePhaseBufferedBody,
+	}); err != nil {
+		return err
+	}
+	registeredWasmPlugins[name] = entry
+	return nil
+}
+
+func closeWasmModules(ctx context.Context, modules map[string]*wasm.Module) {
+	for _, module := range modules {
+		_ = module.Close(ctx)
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+)
+
+func TestLoadWasmModulesRejectsMissingFile(t *testing.T) {
+	cfg := &config.Config{Wasm: config.Wasm{Plugins: []config.WasmPlugin{
+		{Name: "wasm_missing", Priority: 7999, File: filepath.Join(t.TempDir(), "missing.wasm")},
+	}}}
+	modules, err := loadWasmModules(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "wasm plugin wasm_missing") {
+		t.Fatalf("loadWasmModules() = %v, %v, want missing file error", modules, err)
+	}
+	if _, registered := registeredWasmPlugins["wasm_missing"]; registered {
+		t.Fatal("wasm_missing registered after its module failed to load")
+	}
+}
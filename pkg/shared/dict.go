@@ -0,0 +1,267 @@
+package shared
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// entryOverhead approximates the per-entry bookkeeping an nginx zone charges
+// on top of the key and value bytes.
+const entryOverhead = 64
+
+var (
+	// ErrNoMemory is returned when a value cannot fit even in an empty dict,
+	// or does not fit in a dict whose eviction is disabled.
+	ErrNoMemory = errors.New("no memory")
+	// ErrExists is returned by Add when the key holds an unexpired value.
+	ErrExists = errors.New("exists")
+	// ErrNotFound is returned for a missing or expired key.
+	ErrNotFound = errors.New("not found")
+	// ErrNotNumber is returned by Incr when the key holds a non-number.
+	ErrNotNumber = errors.New("not a number")
+)
+
+// Dict is one shared dictionary, the Go counterpart of an NGINX
+// lua_shared_dict zone: entries may expire, and a write that does not fit
+// evicts the least recently used entries. It is safe for concurrent use.
+type Dict struct {
+	mu       sync.Mutex
+	noEvict  bool
+	capacity int64
+	used     int64
+	items    map[string]*list.Element
+	lru      *list.List
+	now      func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+	size    int64
+}
+
+// NewDict returns an empty dict holding up to capacity bytes.
+func NewDict(capacity int64) *Dict {
+	return &Dict{
+		capacity: capacity,
+		items:    map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// DisableEviction makes every later write behave like ngx.shared.DICT's
+// safe_set: a value that does not fit in the free space fails with
+// ErrNoMemory instead of evicting other entries. Plugins use it for state
+// that must not be silently dropped, such as in-flight connection counts.
+func (d *Dict) DisableEviction() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.noEvict = true
+}
+
+// Get returns the unexpired value of key.
+func (d *Dict) Get(key string) (any, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	element := d.lookup(key)
+	if element == nil {
+		return nil, false
+	}
+	d.lru.MoveToFront(element)
+	return element.Value.(*entry).value, true
+}
+
+// Set stores value under key; a nil value deletes the key. A ttl of zero
+// never expires.
+func (d *Dict) Set(key string, value any, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if value == nil {
+		d.remove(d.items[key])
+		return nil
+	}
+	return d.store(key, value, ttl)
+}
+
+// Add stores value under key only when the key is missing or expired.
+func (d *Dict) Add(key string, value any, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lookup(key) != nil {
+		return ErrExists
+	}
+	if value == nil {
+		return nil
+	}
+	return d.store(key, value, ttl)
+}
+
+// Incr adds delta to the number under key. A missing key fails with
+// ErrNotFound unless init is set, in which case it starts from *init and
+// expires after initTTL.
+func (d *Dict) Incr(key string, delta float64, init *float64, initTTL time.Duration) (float64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	element := d.lookup(key)
+	if element == nil {
+		if init == nil {
+			return 0, ErrNotFound
+		}
+		value := *init + delta
+		return value, d.store(key, value, initTTL)
+	}
+	current := element.Value.(*entry)
+	number, ok := current.value.(float64)
+	if !ok {
+		return 0, ErrNotNumber
+	}
+	current.value = number + delta
+	d.lru.MoveToFront(element)
+	return number + delta, nil
+}
+
+// Update atomically replaces the value of key with the result of fn, which
+// receives the current unexpired value. When fn reports false the dict is
+// left unchanged. The stored value expires after ttl unless ttl is zero.
+func (d *Dict) Update(key string, ttl time.Duration, fn func(value any, ok bool) (any, bool)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var current any
+	element := d.lookup(key)
+	if element != nil {
+		current = element.Value.(*entry).value
+	}
+	value, ok := fn(current, element != nil)
+	if !ok {
+		return nil
+	}
+	if value == nil {
+		d.remove(element)
+		return nil
+	}
+	return d.store(key, value, ttl)
+}
+
+// Delete removes key.
+func (d *Dict) Delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.remove(d.items[key])
+}
+
+// TTL returns the remaining lifetime of key; zero means it never expires.
+func (d *Dict) TTL(key string) (time.Duration, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	element := d.lookup(key)
+	if element == nil {
+		return 0, ErrNotFound
+	}
+	expires := element.Value.(*entry).expires
+	if expires.IsZero() {
+		return 0, nil
+	}
+	return expires.Sub(d.now()), nil
+}
+
+// lookup returns the element of an unexpired key and drops an expired one.
+func (d *Dict) lookup(key string) *list.Element {
+	element := d.items[key]
+	if element == nil {
+		return nil
+	}
+	expires := element.Value.(*entry).expires
+	if !expires.IsZero() && !d.now().Before(expires) {
+		d.remove(element)
+		return nil
+	}
+	return element
+}
+
+func (d *Dict) store(key string, value any, ttl time.Duration) error {
+	size := int64(len(key)) + valueSize(value) + entryOverhead
+	if size > d.capacity {
+		return ErrNoMemory
+	}
+	previous := d.items[key]
+	if d.noEvict {
+		free := d.capacity - d.used
+		if previous != nil {
+			free += previous.Value.(*entry).size
+		}
+		if size > free {
+			return ErrNoMemory
+		}
+	}
+	d.remove(previous)
+	for d.used+size > d.capacity {
+		d.remove(d.lru.Back())
+	}
+	stored := &entry{key: key, value: value, size: size}
+	if ttl > 0 {
+		stored.expires = d.now().Add(ttl)
+	}
+	d.items[key] = d.lru.PushFront(stored)
+	d.used += size
+	return nil
+}
+
+// Len returns the number of stored entries, counting expired ones not yet
+// dropped.
+func (d *Dict) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.items)
+}
+
+// Capacity returns the configured size of the dict in bytes.
+func (d *Dict) Capacity() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.capacity
+}
+
+// FreeSpace returns the bytes not taken by stored entries.
+func (d *Dict) FreeSpace() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.capacity - d.used
+}
+
+// resize changes the capacity, evicting the least recently used entries that
+// no longer fit unless eviction is disabled; then writes fail until enough
+// entries are deleted.
+func (d *Dict) resize(capacity int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.capacity = capacity
+	for !d.noEvict && d.used > d.capacity {
+		d.remove(d.lru.Back())
+	}
+}
+
+func (d *Dict) remove(element *list.Element) {
+	if element == nil {
+		return
+	}
+	removed := d.lru.Remove(element).(*entry)
+	delete(d.items, removed.key)
+	d.used -= removed.size
+}
+
+// valueSize charges strings and byte slices by length; numbers, booleans and
+// the small structs plugins keep are charged a flat 16 bytes.
+func valueSize(value any) int64 {
+	switch value := value.(type) {
+	case string:
+		return int64(len(value))
+	case []byte:
+		return int64(len(value))
+	default:
+		return 16
+	}
+}
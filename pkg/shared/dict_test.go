@@ -0,0 +1,142 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDictOperations(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dict := NewDict(1 << 10)
+	dict.now = func() time.Time { return now }
+
+	if err := dict.Set("name", "apisix", 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, ok := dict.Get("name"); !ok || value != "apisix" {
+		t.Fatalf("Get(name) = %v, %v", value, ok)
+	}
+	if err := dict.Add("name", "other", 0); !errors.Is(err, ErrExists) {
+		t.Fatalf("Add(existing) error = %v, want ErrExists", err)
+	}
+	if _, err := dict.Incr("name", 1, nil, 0); !errors.Is(err, ErrNotNumber) {
+		t.Fatalf("Incr(string) error = %v, want ErrNotNumber", err)
+	}
+	if _, err := dict.Incr("hits", 1, nil, 0); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Incr(missing) error = %v, want ErrNotFound", err)
+	}
+	init := 10.0
+	if value, err := dict.Incr("hits", 2, &init, 5*time.Second); err != nil || value != 12 {
+		t.Fatalf("Incr(init) = %v, %v, want 12", value, err)
+	}
+	if value, err := dict.Incr("hits", -1, &init, 0); err != nil || value != 11 {
+		t.Fatalf("Incr() = %v, %v, want 11", value, err)
+	}
+	if ttl, err := dict.TTL("hits"); err != nil || ttl != 5*time.Second {
+		t.Fatalf("TTL(hits) = %v, %v, want 5s", ttl, err)
+	}
+	if ttl, err := dict.TTL("name"); err != nil || ttl != 0 {
+		t.Fatalf("TTL(name) = %v, %v, want 0", ttl, err)
+	}
+
+	now = now.Add(5 * time.Second)
+	if _, ok := dict.Get("hits"); ok {
+		t.Fatal("expired key is still readable")
+	}
+	if err := dict.Add("hits", true, 0); err != nil {
+		t.Fatalf("Add(expired) error = %v", err)
+	}
+	dict.Delete("name")
+	if _, err := dict.TTL("name"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("TTL(deleted) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDictEvictsLeastRecentlyUsed(t *testing.T) {
+	dict := NewDict(3 * (entryOverhead + 9))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := dict.Set(key, "12345678", 0); err != nil {
+			t.Fatalf("Set(%s) error = %v", key, err)
+		}
+	}
+	dict.Get("a")
+	if err := dict.Set("d", "12345678", 0); err != nil {
+		t.Fatalf("Set(d) error = %v", err)
+	}
+	if _, ok := dict.Get("b"); ok {
+		t.Fatal("least recently used key b was not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := dict.Get(key); !ok {
+			t.Fatalf("key %s was evicted", key)
+		}
+	}
+	if err := dict.Set("large", string(make([]byte, 4<<10)), 0); !errors.Is(err, ErrNoMemory) {
+		t.Fatalf("Set(oversized) error = %v, want ErrNoMemory", err)
+	}
+}
+
+func TestDictWithoutEvictionRejectsWritesThatDoNotFit(t *testing.T) {
+	dict := NewDict(2 * (entryOverhead + 9))
+	dict.DisableEviction()
+	for _, key := range []string{"a", "b"} {
+		if err := dict.Set(key, "12345678", 0); err != nil {
+			t.Fatalf("Set(%s) error = %v", key, err)
+		}
+	}
+	if err := dict.Set("c", "12345678", 0); !errors.Is(err, ErrNoMemory) {
+		t.Fatalf("Set(c) error = %v, want ErrNoMemory", err)
+	}
+	if err := dict.Set("a", "87654321", 0); err != nil {
+		t.Fatalf("Set(a) overwrite error = %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := dict.Get(key); !ok {
+			t.Fatalf("key %s was evicted", key)
+		}
+	}
+
+	dict.resize(entryOverhead + 9)
+	if dict.Len() != 2 {
+		t.Fatalf("Len() after shrinking = %d, want 2", dict.Len())
+	}
+	dict.Delete("a")
+	dict.Delete("b")
+	if err := dict.Set("c", "12345678", 0); err != nil {
+		t.Fatalf("Set(c) after delete error = %v", err)
+	}
+}
+
+func TestDictUpdate(t *testing.T) {
+	dict := NewDict(1 << 10)
+	increment := func(value any, ok bool) (any, bool) {
+		if !ok {
+			return 1, true
+		}
+		return value.(int) + 1, true
+	}
+	for range 3 {
+		if err := dict.Update("count", 0, increment); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	if value, ok := dict.Get("count"); !ok || value != 3 {
+		t.Fatalf("Get(count) = %v, %v, want 3", value, ok)
+	}
+	if err := dict.Update("count", 0, func(any, bool) (any, bool) { return 10, false }); err != nil {
+		t.Fatalf("Update(skip) error = %v", err)
+	}
+	if value, _ := dict.Get("count"); value != 3 {
+		t.Fatalf("Get(count) after skipped update = %v, want 3", value)
+	}
+	if err := dict.Update("count", 0, func(any, bool) (any, bool) { return nil, true }); err != nil {
+		t.Fatalf("Update(delete) error = %v", err)
+	}
+	if _, ok := dict.Get("count"); ok {
+		t.Fatal("Update returning nil did not delete the key")
+	}
+	if free := dict.FreeSpace(); free != dict.Capacity() {
+		t.Fatalf("FreeSpace() = %d, want capacity %d", free, dict.Capacity())
+	}
+}
@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultZoneCapacity is the size of a zone that a plugin uses without a
+// lua_shared_dict declaration, matching the 10m APISIX gives its plugin zones.
+const DefaultZoneCapacity = 10 << 20
+
+// zoneRegistry holds the process-wide zones. Zones outlive routes and route
+// reloads, so plugin instances of every route generation that name the same
+// zone share its entries.
+var zoneRegistry = struct {
+	mu       sync.RWMutex
+	zones    map[string]*Dict
+	declared map[string]bool
+}{zones: map[string]*Dict{}, declared: map[string]bool{}}
+
+// ConfigureZones declares one zone per name with the size given in NGINX
+// notation: bytes, or a k or m suffix. Later maps override earlier ones. A
+// zone that already exists keeps its entries and takes the new size; zones
+// declared by an earlier call but not by this one are dropped, while zones
+// created on demand by Zone are kept.
+func ConfigureZones(sizes ...map[string]string) error {
+	capacities := map[string]int64{}
+	for _, declared := range sizes {
+		for name, size := range declared {
+			capacity, err := parseSize(size)
+			if err != nil {
+				return fmt.Errorf("shared dict %q size %q is invalid: %w", name, size, err)
+			}
+			capacities[name] = capacity
+		}
+	}
+
+	zoneRegistry.mu.Lock()
+	defer zoneRegistry.mu.Unlock()
+	for name := range zoneRegistry.declared {
+		if _, ok := capacities[name]; !ok {
+			delete(zoneRegistry.zones, name)
+		}
+	}
+	declared := make(map[string]bool, len(capacities))
+	for name, capacity := range capacities {
+		if zone := zoneRegistry.zones[name]; zone != nil {
+			zone.resize(capacity)
+		} else {
+			zoneRegistry.zones[name] = NewDict(capacity)
+		}
+		declared[name] = true
+	}
+	zoneRegistry.declared = declared
+	return nil
+}
+
+// LookupZone returns the zone with the name, or nil when it was neither
+// declared nor used by a plugin.
+func LookupZone(name string) *Dict {
+	zoneRegistry.mu.RLock()
+	defer zoneRegistry.mu.RUnlock()
+	return zoneRegistry.zones[name]
+}
+
+// Zone returns the zone with the name, creating it with DefaultZoneCapacity
+// when it was not declared.
+func Zone(name string) *Dict {
+	if zone := LookupZone(name); zone != nil {
+		return zone
+	}
+	zoneRegistry.mu.Lock()
+	defer zoneRegistry.mu.Unlock()
+	zone := zoneRegistry.zones[name]
+	if zone == nil {
+		zone = NewDict(DefaultZoneCapacity)
+		zoneRegistry.zones[name] = zone
+	}
+	return zone
+}
+
+// ZoneStats is the capacity and free space of one zone.
+type ZoneStats struct {
+	Name      string
+	Capacity  int64
+	FreeSpace int64
+}
+
+// Stats returns the sizes of every zone, ordered by name.
+func Stats() []ZoneStats {
+	zoneRegistry.mu.RLock()
+	zones := maps.Clone(zoneRegistry.zones)
+	zoneRegistry.mu.RUnlock()
+
+	stats := make([]ZoneStats, 0, len(zones))
+	for name, zone := range zones {
+		stats = append(stats, ZoneStats{Name: name, Capacity: zone.Capacity(), FreeSpace: zone.FreeSpace()})
+	}
+	slices.SortFunc(stats, func(a, b ZoneStats) int { return strings.Compare(a.Name, b.Name) })
+	return stats
+}
+
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := int64(1)
+	if before, ok := strings.CutSuffix(value, "m"); ok {
+		value, multiplier = before, 1<<20
+	} else if before, ok := strings.CutSuffix(value, "k"); ok {
+		value, multiplier = before, 1<<10
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("must be a positive size")
+	}
+	return size * multiplier, nil
+}
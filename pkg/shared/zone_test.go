@@ -0,0 +1,72 @@
+package shared
+
+import "testing"
+
+func TestConfigureZonesParsesSizes(t *testing.T) {
+	t.Cleanup(func() { _ = ConfigureZones() })
+	err := ConfigureZones(
+		map[string]string{"counters": "64k", "bytes": "512"},
+		map[string]string{"counters": "1m", "small": "64k"},
+	)
+	if err != nil {
+		t.Fatalf("ConfigureZones() error = %v", err)
+	}
+	for name, want := range map[string]int64{"counters": 1 << 20, "small": 64 << 10, "bytes": 512} {
+		if zone := LookupZone(name); zone == nil || zone.Capacity() != want {
+			t.Fatalf("LookupZone(%q) = %+v, want capacity %d", name, zone, want)
+		}
+	}
+	if LookupZone("missing") != nil {
+		t.Fatal("LookupZone(missing) != nil")
+	}
+	if err := ConfigureZones(map[string]string{"bad": "ten"}); err == nil {
+		t.Fatal("ConfigureZones() error = nil, want invalid size")
+	}
+}
+
+func TestZonesSurviveReconfiguration(t *testing.T) {
+	t.Cleanup(func() { _ = ConfigureZones() })
+	if err := ConfigureZones(map[string]string{"counters": "1m", "dropped": "1m"}); err != nil {
+		t.Fatalf("ConfigureZones() error = %v", err)
+	}
+	counters := LookupZone("counters")
+	if err := counters.Set("hits", 1.0, 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	plugin := Zone("test-plugin-zone")
+	if plugin.Capacity() != DefaultZoneCapacity {
+		t.Fatalf("Zone() capacity = %d, want %d", plugin.Capacity(), DefaultZoneCapacity)
+	}
+	if Zone("test-plugin-zone") != plugin {
+		t.Fatal("Zone() returned a new dict for an existing zone")
+	}
+
+	if err := ConfigureZones(map[string]string{"counters": "2m"}); err != nil {
+		t.Fatalf("ConfigureZones() error = %v", err)
+	}
+	if LookupZone("counters") != counters || counters.Capacity() != 2<<20 {
+		t.Fatal("redeclared zone was replaced instead of resized")
+	}
+	if value, ok := counters.Get("hits"); !ok || value != 1.0 {
+		t.Fatalf("Get(hits) = %v, %v, want the entry kept", value, ok)
+	}
+	if LookupZone("dropped") != nil {
+		t.Fatal("zone no longer declared is still available")
+	}
+	if LookupZone("test-plugin-zone") != plugin {
+		t.Fatal("plugin zone was dropped by reconfiguration")
+	}
+
+	var found bool
+	for _, stats := range Stats() {
+		if stats.Name == "counters" {
+			found = true
+			if stats.Capacity != 2<<20 || stats.FreeSpace >= stats.Capacity {
+				t.Fatalf("Stats(counters) = %+v", stats)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("Stats() did not report the counters zone")
+	}
+}
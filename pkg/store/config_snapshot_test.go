@@ -127,6 +127,40 @@ func TestConfigSnapshotGenerationTracksGlobalRulesAndPluginMetadata(t *testing.T
 	}
 }
 
+func TestConfigSnapshotListsUpstreamsServicesAndPluginMetadata(t *testing.T) {
+	storage := newConfigSnapshotTestStore(t)
+	applyConfigSnapshotEvent(
+		t,
+		storage,
+		EventTypePut,
+		"/apisix/upstreams/u1",
+		`{"id":"u1","nodes":{"127.0.0.1:8080":1}}`,
+	)
+	applyConfigSnapshotEvent(t, storage, EventTypePut, "/apisix/services/s1", `{"id":"s1","upstream_id":"u1"}`)
+	applyConfigSnapshotEvent(t, storage, EventTypePut, "/apisix/plugin_metadata/zipkin", `{"id":"zipkin"}`)
+	applyConfigSnapshotEvent(t, storage, EventTypePut, "/apisix/plugin_metadata/cors", `{"id":"cors"}`)
+
+	snapshot, err := storage.getConfigSnapshot()
+	if err != nil {
+		t.Fatalf("getConfigSnapshot() error = %v", err)
+	}
+	upstreams := snapshot.Upstreams()
+	if len(upstreams) != 1 || len(upstreams["u1"].Nodes) != 1 {
+		t.Fatalf("Upstreams() = %#v, want u1 with one node", upstreams)
+	}
+	upstream := upstreams["u1"]
+	upstream.Nodes[0].Host = "mutated"
+	if again, _ := snapshot.GetUpstream("u1"); again.Nodes[0].Host == "mutated" {
+		t.Fatal("Upstreams() returned the snapshot's own node slice")
+	}
+	if services := snapshot.Services(); len(services) != 1 || services["s1"].UpstreamID != "u1" {
+		t.Fatalf("Services() = %#v, want s1 bound to u1", services)
+	}
+	if ids := snapshot.PluginMetadataIDs(); !slices.Equal(ids, []string{"cors", "zipkin"}) {
+		t.Fatalf("PluginMetadataIDs() = %v, want sorted plugin names", ids)
+	}
+}
+
 func TestConfigSnapshotTracksDynamicHTTPPluginsAndDelete(t *testing.T) {
 	storage := newConfigSnapshotTestStore(t)
 
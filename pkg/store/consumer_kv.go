@@ -137,7 +137,7 @@ type consumerSnapshot struct {
 	referencePlugins []string
 }
 
-func (s *Store) prepareConsumerSnapshot(id []byte, value []byte) (consumerSnapshot, error) {
+func prepareConsumerSnapshot(id []byte, value []byte) (consumerSnapshot, error) {
 	consumer, err := ParseConsumer(value)
 	if err != nil {
 		return consumerSnapshot{}, err
@@ -329,7 +329,7 @@ func consumerPluginLookupKey(pluginName string, config resource.PluginConfig) (s
 }
 
 func (s *Store) consumerKVAdd(id []byte, value []byte) error {
-	snapshot, err := s.prepareConsumerSnapshot(id, value)
+	snapshot, err := prepareConsumerSnapshot(id, value)
 	if err != nil {
 		return err
 	}
@@ -60,16 +60,11 @@ func TestConsumerKVDeleteRemovesAllLookupEntries(t *testing.T) {
 }
 
 func TestPrepareConsumerSnapshotRejectsNonStringJWEKey(t *testing.T) {
-	storage := &Store{
-		consumerKV:     map[string][]byte{},
-		consumerToKeys: map[string][]string{},
-		consumerValues: map[string]resource.Consumer{},
-	}
 	value := []byte(
 		`{"username":"jwe-user","plugins":{"jwe-decrypt":{"key":123,"secret":"01234567890123456789012345678901"}}}`,
 	)
 
-	snapshot, err := storage.prepareConsumerSnapshot([]byte("jwe-user"), value)
+	snapshot, err := prepareConsumerSnapshot([]byte("jwe-user"), value)
 	if err == nil {
 		t.Fatalf("prepareConsumerSnapshot() = %+v, nil; want jwe-decrypt key type error", snapshot)
 	}
@@ -626,7 +626,7 @@ func TestReadOnlyStoreMutationPreservesLastGoodState(t *testing.T) {
 		consumerToReferences:    make(map[string][]string),
 		validatedPluginMetadata: newValidatedPluginMetadataCache(),
 	}
-	if snapshot, err := storage.prepareConsumerSnapshot([]byte("foo"), seedConsumer); err != nil {
+	if snapshot, err := prepareConsumerSnapshot([]byte("foo"), seedConsumer); err != nil {
 		t.Fatalf("prepare seeded consumer: %v", err)
 	} else {
 		if err := storage.applyConsumerSnapshot(snapshot); err != nil {
@@ -160,6 +160,24 @@ func (e *Event) Wait(ctx context.Context) error {
 	}
 }
 
+// Batch returns the mutation set of an event created by NewAcknowledgedBatch.
+// It lets a relay between a producer and the Store see what the producer sent.
+func (e *Event) Batch() ([]Mutation, BatchOptions, bool) {
+	if !e.batch {
+		return nil, BatchOptions{}, false
+	}
+	return e.mutations, e.options, true
+}
+
+// Acknowledge completes an acknowledged event that a relay took from its
+// producer instead of the Store: it hands err to the waiting producer, waits
+// for the producer to finish with the Event, and returns it to the pool.
+func (e *Event) Acknowledge(err error) {
+	e.result <- err
+	<-e.waitDone
+	PutBack(e)
+}
+
 func PutBack(event *Event) {
 	if event == nil {
 		return
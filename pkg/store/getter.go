@@ -1047,6 +1047,39 @@ func (snap *ConfigSnapshot) GetUpstream(id string) (resource.Upstream, error) {
 	return cloneUpstream(upstream), nil
 }
 
+// Services returns cloned services of this generation keyed by ID.
+func (snap *ConfigSnapshot) Services() map[string]resource.Service {
+	if snap == nil {
+		return nil
+	}
+	services := make(map[string]resource.Service, len(snap.services))
+	for id, service := range snap.services {
+		services[id] = cloneService(service)
+	}
+	return services
+}
+
+// Upstreams returns cloned upstreams of this generation keyed by ID.
+func (snap *ConfigSnapshot) Upstreams() map[string]resource.Upstream {
+	if snap == nil {
+		return nil
+	}
+	upstreams := make(map[string]resource.Upstream, len(snap.upstreams))
+	for id, upstream := range snap.upstreams {
+		upstreams[id] = cloneUpstream(upstream)
+	}
+	return upstreams
+}
+
+// PluginMetadataIDs returns the sorted plugin names that have decodable
+// metadata in this generation.
+func (snap *ConfigSnapshot) PluginMetadataIDs() []string {
+	if snap == nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(snap.pluginMetadata))
+}
+
 // GetPluginConfigRule returns a cloned plugin-config rule from this
 // generation.
 func (snap *ConfigSnapshot) GetPluginConfigRule(id string) (resource.PluginConfigRule, error) {
@@ -38,6 +38,36 @@ func (s *Store) SnapshotBuckets(bucketNames []string) (map[string]map[string][]b
 	return snapshot, nil
 }
 
+// ManagedSnapshot returns every managed resource as a PUT mutation, read in
+// one bbolt transaction. Applied with BatchOptions.ReplaceManaged it rebuilds
+// the same managed state in another Store.
+func (s *Store) ManagedSnapshot() ([]Mutation, error) {
+	mutations := make([]Mutation, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, bucketName := range builtInBuckets {
+			bucket := tx.Bucket(bucketName)
+			if bucket == nil {
+				return fmt.Errorf("snapshot bucket %q: %w", bucketName, errBucketNotFound)
+			}
+			if err := bucket.ForEach(func(id, value []byte) error {
+				key := "/apisix/" + string(bucketName) + "/" + string(id)
+				if string(bucketName) == "plugins" {
+					key = "/apisix/plugins"
+				}
+				mutations = append(mutations, Mutation{Type: EventTypePut, Key: []byte(key), Value: bytes.Clone(value)})
+				return nil
+			}); err != nil {
+				return fmt.Errorf("snapshot bucket %q: %w", bucketName, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutations, nil
+}
+
 func (s *Store) rebuildPersistedConsumerIndexes() error {
 	snapshots := make([]consumerSnapshot, 0)
 	err := s.db.View(func(tx *bolt.Tx) error {
@@ -46,7 +76,7 @@ func (s *Store) rebuildPersistedConsumerIndexes() error {
 			return errBucketNotFound
 		}
 		return bucket.ForEach(func(id, value []byte) error {
-			snapshot, err := s.prepareConsumerSnapshot(bytes.Clone(id), bytes.Clone(value))
+			snapshot, err := prepareConsumerSnapshot(bytes.Clone(id), bytes.Clone(value))
 			if err != nil {
 				logger.Warnf("skip invalid persisted consumer %q: %s", id, err)
 				return nil
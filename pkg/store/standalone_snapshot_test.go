@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"path/filepath"
 	"strings"
@@ -223,3 +224,68 @@ func TestOpenUsesFiniteDatabaseLockTimeout(t *testing.T) {
 		t.Fatalf("Open() lock timeout took %s, want bounded timeout", elapsed)
 	}
 }
+
+func TestManagedSnapshotReplaysIntoAnotherStore(t *testing.T) {
+	apply := func(storage *Store, mutations []Mutation, options BatchOptions) {
+		t.Helper()
+		event := NewAcknowledgedBatch(mutations, options)
+		storage.events <- event
+		if err := event.Wait(context.Background()); err != nil {
+			t.Fatalf("apply batch: %v", err)
+		}
+	}
+	open := func(name string) *Store {
+		storage, err := Open(filepath.Join(t.TempDir(), name), make(chan *Event))
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		storage.Start()
+		t.Cleanup(func() { _ = storage.Stop() })
+		return storage
+	}
+
+	source := open("source.db")
+	apply(source, []Mutation{
+		{Type: EventTypePut, Key: []byte("/apisix/routes/r1"), Value: []byte(`{"uri":"/r1"}`)},
+		{Type: EventTypePut, Key: []byte("/apisix/consumers/alice"), Value: []byte(`{"username":"alice"}`)},
+	}, BatchOptions{})
+	if err := source.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("secrets")).Put([]byte("vault/item"), []byte(`{"uri":"http://vault.test"}`))
+	}); err != nil {
+		t.Fatalf("seed secret: %v", err)
+	}
+
+	snapshot, err := source.ManagedSnapshot()
+	if err != nil {
+		t.Fatalf("ManagedSnapshot() error = %v", err)
+	}
+	keys := make([]string, 0, len(snapshot))
+	for _, mutation := range snapshot {
+		if mutation.Type != EventTypePut {
+			t.Fatalf("snapshot mutation %s type = %s, want PUT", mutation.Key, mutation.Type)
+		}
+		keys = append(keys, string(mutation.Key))
+	}
+	want := "/apisix/routes/r1,/apisix/consumers/alice,/apisix/secrets/vault/item"
+	if got := strings.Join(keys, ","); got != want {
+		t.Fatalf("snapshot keys = %s, want %s", got, want)
+	}
+
+	target := open("target.db")
+	apply(target, []Mutation{
+		{Type: EventTypePut, Key: []byte("/apisix/routes/stale"), Value: []byte(`{"uri":"/stale"}`)},
+	}, BatchOptions{})
+	apply(target, snapshot, BatchOptions{ReplaceManaged: true})
+
+	got, err := target.SnapshotBuckets([]string{"routes", "consumers", "secrets"})
+	if err != nil {
+		t.Fatalf("SnapshotBuckets() error = %v", err)
+	}
+	if _, ok := got["routes"]["stale"]; ok {
+		t.Fatal("replayed snapshot kept a route the source does not have")
+	}
+	if string(got["routes"]["r1"]) != `{"uri":"/r1"}` || string(got["consumers"]["alice"]) != `{"username":"alice"}` ||
+		string(got["secrets"]["vault/item"]) != `{"uri":"http://vault.test"}` {
+		t.Fatalf("replayed buckets = %q", got)
+	}
+}
@@ -254,8 +254,9 @@ func EventBucket(event *Event) (string, bool) {
 }
 
 // IsStreamReloadBucket reports whether a resource change affects stream routing.
+// SSL objects supply the client certificates of TLS stream upstreams.
 func IsStreamReloadBucket(bucket string) bool {
-	return bucket == "upstreams" || bucket == "stream_routes" || bucket == "services"
+	return bucket == "upstreams" || bucket == "stream_routes" || bucket == "services" || bucket == "ssls"
 }
 
 var builtInBuckets = [][]byte{
@@ -493,9 +494,7 @@ func (s *Store) processEvents() {
 }
 
 func (s *Store) completeAcknowledgedEvent(event *Event, err error) {
-	event.result <- err
-	<-event.waitDone
-	PutBack(event)
+	event.Acknowledge(err)
 }
 
 func (s *Store) processEvent(event *Event) error {
@@ -577,7 +576,7 @@ func (s *Store) processMutations(mutations []Mutation, options BatchOptions, bat
 				resourceErr = validateConfigResourcePut(bucket, id, mutation.Value)
 			case "consumers":
 				var snapshot consumerSnapshot
-				snapshot, resourceErr = s.prepareConsumerSnapshot([]byte(id), mutation.Value)
+				snapshot, resourceErr = prepareConsumerSnapshot([]byte(id), mutation.Value)
 				if resourceErr != nil {
 					resourceErr = fmt.Errorf("store process the consumer fail: %w", resourceErr)
 				} else {
@@ -824,6 +823,28 @@ func clearManagedBuckets(tx *bolt.Tx, preserve map[ResourceKey]struct{}) error {
 	return nil
 }
 
+// ValidateResource applies the per-resource checks a Store write runs before
+// its transaction. Writers that persist elsewhere, such as the Admin API etcd
+// backend, use it to reject payloads the watcher would later quarantine.
+func ValidateResource(bucket, id string, value []byte) error {
+	switch bucket {
+	case "ssls":
+		return validateSSLCertificateEvent(EventTypePut, id, value)
+	case "routes", "global_rules", "services", "upstreams", "plugin_configs", "stream_routes":
+		return validateConfigResourcePut(bucket, id, value)
+	case "consumers":
+		if _, err := prepareConsumerSnapshot([]byte(id), value); err != nil {
+			return fmt.Errorf("store process the consumer fail: %w", err)
+		}
+		return nil
+	case "plugin_metadata":
+		return validatePluginMetadataPut(id, value)
+	case "plugins":
+		return validateDynamicPluginList(value)
+	}
+	return nil
+}
+
 func validatePluginMetadataPut(id string, value []byte) error {
 	var object map[string]json.RawMessage
 	if err := json.Unmarshal(value, &object); err != nil {
@@ -782,7 +782,7 @@ func TestRouteReloadBucketSemantics(t *testing.T) {
 		{bucket: "global_rules", http: true},
 		{bucket: "plugin_configs", http: true},
 		{bucket: "plugin_metadata", http: true},
-		{bucket: "ssls", http: true},
+		{bucket: "ssls", http: true, stream: true},
 		{bucket: "protos", http: true},
 		{bucket: "consumer_groups"},
 		{bucket: "consumers"},
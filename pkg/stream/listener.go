@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/proxyprotocol"
+)
+
+// streamListener is a bound TCP stream listener. Reconfigure may change the
+// settings of a kept address without rebinding it; they apply to connections
+// accepted afterwards.
+type streamListener struct {
+	net.Listener
+	settings atomic.Pointer[listenerSettings]
+}
+
+type listenerSettings struct {
+	// tls terminates TLS on accepted connections when non-nil.
+	tls *tls.Config
+	// proxyProtocol requires a PROXY header from a trusted peer, whose source
+	// becomes the connection's remote address.
+	proxyProtocol bool
+	trusted       []*net.IPNet
+	// proxyProtocolToUpstream sends a PROXY v2 header to upstream nodes.
+	proxyProtocolToUpstream bool
+}
+
+func newListenerSettings(spec config.TcpListen, trusted []*net.IPNet, serverTLS *tls.Config) *listenerSettings {
+	settings := &listenerSettings{
+		proxyProtocol:           spec.ProxyProtocol,
+		proxyProtocolToUpstream: spec.ProxyProtocolToUpstream,
+	}
+	if spec.Tls {
+		settings.tls = serverTLS
+	}
+	if spec.ProxyProtocol {
+		settings.trusted = trusted
+	}
+	return settings
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	settings := l.settings.Load()
+	if settings == nil {
+		return conn, nil
+	}
+	if settings.proxyProtocol {
+		conn = proxyprotocol.NewConn(conn, settings.trusted, proxyprotocol.DefaultHeaderTimeout)
+	}
+	if settings.tls != nil {
+		conn = tls.Server(conn, settings.tls)
+	}
+	return conn, nil
+}
+
+// sendsProxyProtocolToUpstream reports whether connections of listener
+// forward a PROXY header to their upstream.
+func sendsProxyProtocolToUpstream(listener net.Listener) bool {
+	streamListener, ok := listener.(*streamListener)
+	if !ok {
+		return false
+	}
+	settings := streamListener.settings.Load()
+	return settings != nil && settings.proxyProtocolToUpstream
+}
+
+// readClientProxyHeader reads the inbound PROXY header of client, if its
+// listener expects one, before any address of client is used.
+func readClientProxyHeader(client net.Conn) error {
+	conn := client
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	proxied, ok := conn.(*proxyprotocol.Conn)
+	if !ok {
+		return nil
+	}
+	if _, err := proxied.Header(); err != nil {
+		return fmt.Errorf("stream PROXY protocol: %w", err)
+	}
+	return nil
+}
+
+type upstreamProxyHeaderKey struct{}
+
+// withUpstreamProxyHeader asks dials made with ctx to send a PROXY v2 header
+// carrying the client's source and the address it connected to.
+func withUpstreamProxyHeader(ctx context.Context, client net.Conn) context.Context {
+	return context.WithValue(ctx, upstreamProxyHeaderKey{}, proxyprotocol.AppendV2(nil, client.RemoteAddr(), client.LocalAddr()))
+}
+
+func upstreamProxyHeader(ctx context.Context) []byte {
+	header, _ := ctx.Value(upstreamProxyHeaderKey{}).([]byte)
+	return header
+}
@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/proxyprotocol"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func TestRuntimeRoutesByProxyProtocolSourceAndForwardsHeader(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen upstream: %v", err)
+	}
+	t.Cleanup(func() { _ = upstream.Close() })
+	headers := make(chan proxyprotocol.Header, 1)
+	go func() {
+		conn, acceptErr := upstream.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		reader := bufio.NewReader(conn)
+		header, readErr := proxyprotocol.Read(reader)
+		if readErr != nil {
+			return
+		}
+		headers <- header
+		request := make([]byte, len("stream-request"))
+		if _, readErr := io.ReadFull(reader, request); readErr != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("proxied"))
+	}()
+
+	route := runtimeTestRoute(t, "proxied", upstream.Addr().String())
+	route.RemoteAddr = "203.0.113.0/24"
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{
+			Tcp: []config.TcpListen{{
+				Addr:                    "127.0.0.1:0",
+				ProxyProtocol:           true,
+				ProxyProtocolToUpstream: true,
+			}},
+			TrustedAddresses: []string{"127.0.0.1"},
+		},
+		[]resource.StreamRoute{route},
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	address := runtime.Addresses()[0]
+
+	request := []byte("PROXY TCP4 203.0.113.7 192.0.2.10 56324 9100\r\nstream-request")
+	if got := runtimeRoundTrip(t, address, request, len("proxied")); string(got) != "proxied" {
+		t.Fatalf("response = %q, want proxied", got)
+	}
+	header := <-headers
+	if header.Version != 2 || header.Source.String() != "203.0.113.7:56324" {
+		t.Fatalf("upstream PROXY header = %+v, want v2 from 203.0.113.7:56324", header)
+	}
+	if header.Destination.String() != address {
+		t.Fatalf("upstream PROXY destination = %s, want the listener %s", header.Destination, address)
+	}
+}
+
+func TestRuntimeRejectsProxyProtocolFromUntrustedPeer(t *testing.T) {
+	_, upstreamAddr := startStreamUpstream(t, []byte("leaked"))
+	results := make(chan Result, 1)
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{
+			Tcp:              []config.TcpListen{{Addr: "127.0.0.1:0", ProxyProtocol: true}},
+			TrustedAddresses: []string{"10.0.0.0/8"},
+		},
+		[]resource.StreamRoute{runtimeTestRoute(t, "untrusted", upstreamAddr)},
+		nil,
+		func(result Result) { results <- result },
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+
+	client, err := net.Dial("tcp", runtime.Addresses()[0])
+	if err != nil {
+		t.Fatalf("dial runtime: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	_, _ = client.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.10 56324 9100\r\nstream-request"))
+	if response, _ := io.ReadAll(client); len(response) != 0 {
+		t.Fatalf("untrusted peer received %q", response)
+	}
+	if result := <-results; result.Err == nil {
+		t.Fatal("untrusted PROXY header did not report an error")
+	}
+}
@@ -0,0 +1,180 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/plugin"
+	"github.com/wklken/apisix-go/pkg/plugin/base"
+	"github.com/wklken/apisix-go/pkg/plugin/ip_restriction"
+	"github.com/wklken/apisix-go/pkg/plugin/limit_conn"
+	"github.com/wklken/apisix-go/pkg/plugin/mqtt_proxy"
+	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/util"
+)
+
+// ErrStreamRejected is returned when a stream access plugin refuses a client.
+var ErrStreamRejected = errors.New("stream connection rejected")
+
+// streamPluginOrder lists the supported stream plugins in APISIX priority
+// order: access plugins run before the mqtt-proxy terminal.
+var streamPluginOrder = []string{"ip-restriction", "limit-conn", "mqtt-proxy"}
+
+// streamAccess admits or rejects one TCP connection or UDP session before the
+// upstream is dialed. The release, when non-nil, runs once the connection or
+// session ends.
+type streamAccess func(ctx context.Context, session streamSession) (func(), error)
+
+// streamSession carries the addresses stream plugins resolve variables from.
+type streamSession struct {
+	server string
+	remote string
+}
+
+func (s streamSession) variable(name string) string {
+	address := s.remote
+	if name == "server_addr" || name == "server_port" {
+		address = s.server
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	switch name {
+	case "remote_addr", "server_addr":
+		return host
+	case "remote_port", "server_port":
+		return port
+	}
+	return ""
+}
+
+// bindStreamPlugins configures the route's stream plugins on entry. Access
+// plugins are appended to entry.access and mqtt-proxy replaces entry.serve.
+func bindStreamPlugins(entry *routeEntry, enabledPlugins map[string]struct{}) error {
+	route := entry.route
+	for name := range route.Plugins {
+		if len(enabledPlugins) > 0 {
+			if _, ok := enabledPlugins[name]; !ok {
+				return fmt.Errorf("stream plugin %q is not enabled", name)
+			}
+		}
+		if !slices.Contains(streamPluginOrder, name) {
+			return fmt.Errorf("stream plugin %q is not supported by the Go stream owner", name)
+		}
+	}
+	for _, name := range streamPluginOrder {
+		config, ok := route.Plugins[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case "ip-restriction":
+			p := &ip_restriction.Plugin{}
+			if err := initStreamPlugin(name, p, config); err != nil {
+				return err
+			}
+			entry.access = append(entry.access, ipRestrictionAccess(p))
+		case "limit-conn":
+			p := &limit_conn.Plugin{}
+			if err := initStreamPlugin(name, p, config); err != nil {
+				return err
+			}
+			if err := p.PrepareStream(); err != nil {
+				return fmt.Errorf("initialize stream plugin %s: %w", name, err)
+			}
+			entry.access = append(entry.access, limitConnAccess(p))
+		case "mqtt-proxy":
+			p := &mqtt_proxy.Plugin{}
+			if err := initStreamPlugin(name, p, config); err != nil {
+				return err
+			}
+			e := *entry
+			entry.mqtt = true
+			entry.serve = func(ctx context.Context, client net.Conn, peer string) (string, string, error) {
+				info, err := p.ServeStreamWithIdle(ctx, client, peer, e.dial, e.streamIdleTimeout())
+				return info.ClientID, "mqtt", err
+			}
+		}
+	}
+	return nil
+}
+
+func initStreamPlugin(name string, p plugin.Plugin, config resource.PluginConfig) error {
+	if err := p.Init(); err != nil {
+		return fmt.Errorf("initialize stream plugin %s: %w", name, err)
+	}
+	compiledSchema, err := util.CompileSchema(p.GetSchema())
+	if err != nil {
+		return fmt.Errorf("validate stream plugin %s: %w", name, err)
+	}
+	if err := compiledSchema.Validate(config); err != nil {
+		return fmt.Errorf("validate stream plugin %s: %w", name, err)
+	}
+	if err := util.Parse(config, p.Config()); err != nil {
+		return fmt.Errorf("parse stream plugin %s: %w", name, err)
+	}
+	if err := base.MaterializePluginSecrets(p); err != nil {
+		return fmt.Errorf("materialize stream plugin %s secrets: %w", name, err)
+	}
+	if err := p.PostInit(); err != nil {
+		return fmt.Errorf("initialize stream plugin %s: %w", name, err)
+	}
+	return nil
+}
+
+func ipRestrictionAccess(p *ip_restriction.Plugin) streamAccess {
+	return func(_ context.Context, session streamSession) (func(), error) {
+		if !p.AllowStream(session.remote) {
+			return nil, fmt.Errorf("%w by ip-restriction", ErrStreamRejected)
+		}
+		return nil, nil
+	}
+}
+
+func limitConnAccess(p *limit_conn.Plugin) streamAccess {
+	return func(ctx context.Context, session streamSession) (func(), error) {
+		delay, release, allowed := p.AdmitStream(session.variable)
+		if !allowed {
+			return nil, fmt.Errorf("%w by limit-conn", ErrStreamRejected)
+		}
+		if delay <= 0 {
+			return release, nil
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return release, nil
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// admit runs the route's access plugins in order. The returned release frees
+// every admission and is never nil; a rejection releases earlier admissions.
+func (e routeEntry) admit(ctx context.Context, session streamSession) (func(), error) {
+	var releases []func()
+	releaseAll := func() {
+		for index := len(releases) - 1; index >= 0; index-- {
+			releases[index]()
+		}
+	}
+	for _, access := range e.access {
+		release, err := access(ctx, session)
+		if err != nil {
+			releaseAll()
+			return func() {}, err
+		}
+		if release != nil {
+			releases = append(releases, release)
+		}
+	}
+	return releaseAll, nil
+}
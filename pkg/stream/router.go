@@ -2,6 +2,7 @@ package stream
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -14,12 +15,9 @@ import (
 	"time"
 
 	"github.com/wklken/apisix-go/pkg/plugin"
-	"github.com/wklken/apisix-go/pkg/plugin/base"
-	"github.com/wklken/apisix-go/pkg/plugin/mqtt_proxy"
 	pxy "github.com/wklken/apisix-go/pkg/proxy"
 	"github.com/wklken/apisix-go/pkg/resource"
 	streambridge "github.com/wklken/apisix-go/pkg/stream/bridge"
-	"github.com/wklken/apisix-go/pkg/util"
 )
 
 const (
@@ -52,7 +50,15 @@ type routeEntry struct {
 	groups    []streamTargetGroup
 	chash     bool
 	hashNodes []hashTarget
-	serve     func(context.Context, net.Conn, string) (string, string, error)
+	access    []streamAccess
+	// sni is the normalized route sni; such a route only matches TLS
+	// connections that requested it.
+	sni string
+	// upstreamTLS re-originates TLS to the nodes of a "tls" scheme upstream.
+	upstreamTLS *tls.Config
+	// mqtt is set when mqtt-proxy owns the connection, which needs TCP.
+	mqtt  bool
+	serve func(context.Context, net.Conn, string) (string, string, error)
 }
 
 type streamTargetGroup struct {
@@ -85,21 +91,51 @@ func NewRouter(
 }
 
 func (r *Router) Reload(routes []resource.StreamRoute) error {
-	if err := rejectConflictingStreamListens(routes); err != nil {
+	r.mu.RLock()
+	enabledPlugins := r.enabledPlugins
+	r.mu.RUnlock()
+	entries, err := buildRouteEntries(routes, enabledPlugins)
+	if err != nil {
 		return err
 	}
+	r.mu.Lock()
+	r.routes = entries
+	r.mu.Unlock()
+	return nil
+}
+
+// Reconfigure rebuilds routes against a new stream plugin allowlist and
+// publishes both together. A rejected route keeps the previous routes and
+// allowlist.
+func (r *Router) Reconfigure(routes []resource.StreamRoute, enabledPlugins []string) error {
+	enabled := make(map[string]struct{}, len(enabledPlugins))
+	for _, name := range enabledPlugins {
+		enabled[name] = struct{}{}
+	}
+	entries, err := buildRouteEntries(routes, enabled)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.routes = entries
+	r.enabledPlugins = enabled
+	r.mu.Unlock()
+	return nil
+}
+
+func buildRouteEntries(routes []resource.StreamRoute, enabledPlugins map[string]struct{}) ([]routeEntry, error) {
+	if err := rejectConflictingStreamListens(routes); err != nil {
+		return nil, err
+	}
 	entries := make([]routeEntry, 0, len(routes))
 	for _, route := range routes {
-		entry, err := buildRouteEntry(route, r.enabledPlugins)
+		entry, err := buildRouteEntry(route, enabledPlugins)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		entries = append(entries, entry)
 	}
-	r.mu.Lock()
-	r.routes = entries
-	r.mu.Unlock()
-	return nil
+	return entries, nil
 }
 
 func rejectConflictingStreamListens(routes []resource.StreamRoute) error {
@@ -111,9 +147,10 @@ func rejectConflictingStreamListens(routes []resource.StreamRoute) error {
 		key := streamListenKey(route)
 		if previous, ok := seen[key]; ok {
 			return fmt.Errorf(
-				"conflicting stream listen address %s:%d between %q and %q",
+				"conflicting stream listen address %s:%d sni %q between %q and %q",
 				route.ServerAddr,
 				route.ServerPort,
+				route.Sni,
 				previous,
 				route.ID,
 			)
@@ -123,8 +160,11 @@ func rejectConflictingStreamListens(routes []resource.StreamRoute) error {
 	return nil
 }
 
+// streamListenKey identifies the connections a route claims by listener; routes
+// for different SNIs may share a listener address.
 func streamListenKey(route resource.StreamRoute) string {
-	return route.ServerAddr + "\x00" + strconv.Itoa(route.ServerPort)
+	return route.ServerAddr + "\x00" + strconv.Itoa(route.ServerPort) + "\x00" +
+		strings.ToLower(strings.TrimSpace(route.Sni))
 }
 
 func (r *Router) Serve(ctx context.Context, listener net.Listener, client net.Conn) error {
@@ -143,9 +183,21 @@ func (r *Router) Serve(ctx context.Context, listener net.Listener, client net.Co
 	if client.RemoteAddr() != nil {
 		remoteAddr = client.RemoteAddr().String()
 	}
+	if err := readClientProxyHeader(client); err != nil {
+		// remoteAddr is the peer that sent the rejected header.
+		_ = client.Close()
+		r.emit(Result{Listener: listenerAddr, Remote: remoteAddr, Protocol: "tcp", Err: err})
+		return err
+	}
+	serverName, err := handshakeStreamClient(ctx, client)
+	if err != nil {
+		_ = client.Close()
+		r.emit(Result{Listener: listenerAddr, Remote: remoteAddr, Protocol: "tls", Err: err})
+		return err
+	}
 
 	r.mu.RLock()
-	entry, ok := r.matchEntry(serverAddr, remoteAddr)
+	entry, ok := r.matchEntry(serverAddr, remoteAddr, serverName)
 	r.mu.RUnlock()
 	if !ok {
 		err := ErrNoStreamRoute
@@ -153,6 +205,22 @@ func (r *Router) Serve(ctx context.Context, listener net.Listener, client net.Co
 		r.emit(Result{Listener: listenerAddr, Remote: remoteAddr, Protocol: "tcp", Err: err})
 		return err
 	}
+	if entry.route.Upstream.Scheme == "udp" {
+		err := fmt.Errorf("stream route %q has a udp upstream and cannot serve TCP", entry.route.ID)
+		_ = client.Close()
+		r.emit(Result{RouteID: entry.route.ID, Listener: listenerAddr, Remote: remoteAddr, Protocol: "tcp", Err: err})
+		return err
+	}
+	release, err := entry.admit(ctx, streamSession{server: serverAddr, remote: remoteAddr})
+	defer release()
+	if err != nil {
+		_ = client.Close()
+		r.emit(Result{RouteID: entry.route.ID, Listener: listenerAddr, Remote: remoteAddr, Protocol: "tcp", Err: err})
+		return err
+	}
+	if sendsProxyProtocolToUpstream(listener) {
+		ctx = withUpstreamProxyHeader(ctx, client)
+	}
 
 	clientID, protocol, err := entry.serve(ctx, client, remoteAddr)
 	result := Result{
@@ -167,7 +235,11 @@ func (r *Router) Serve(ctx context.Context, listener net.Listener, client net.Co
 	return err
 }
 
-func (r *Router) routeMatches(route resource.StreamRoute, listenerAddr, remoteAddr string) bool {
+func (r *Router) routeMatches(entry routeEntry, listenerAddr, remoteAddr, serverName string) bool {
+	route := entry.route
+	if entry.sni != "" && !matchesSNI(entry.sni, serverName) {
+		return false
+	}
 	if route.ServerPort != 0 {
 		_, port, err := net.SplitHostPort(listenerAddr)
 		if err != nil || port != strconv.Itoa(route.ServerPort) {
@@ -195,9 +267,19 @@ func (r *Router) routeMatches(route resource.StreamRoute, listenerAddr, remoteAd
 	return err == nil && network.Contains(net.ParseIP(peerHost))
 }
 
-func (r *Router) matchEntry(listenerAddr, remoteAddr string) (routeEntry, bool) {
+// matchEntry returns the first route matching the connection. When the client
+// requested an SNI, routes with a matching sni win over routes without one,
+// as in APISIX.
+func (r *Router) matchEntry(listenerAddr, remoteAddr, serverName string) (routeEntry, bool) {
+	if serverName != "" {
+		for _, entry := range r.routes {
+			if entry.sni != "" && r.routeMatches(entry, listenerAddr, remoteAddr, serverName) {
+				return entry, true
+			}
+		}
+	}
 	for _, entry := range r.routes {
-		if r.routeMatches(entry.route, listenerAddr, remoteAddr) {
+		if entry.sni == "" && r.routeMatches(entry, listenerAddr, remoteAddr, serverName) {
 			return entry, true
 		}
 	}
@@ -220,9 +302,19 @@ func buildRouteEntry(route resource.StreamRoute, enabledPlugins map[string]struc
 	if len(route.Upstream.Nodes) == 0 {
 		return routeEntry{}, fmt.Errorf("stream route %q has no upstream nodes", route.ID)
 	}
-	if route.Upstream.Scheme != "" && route.Upstream.Scheme != "tcp" {
+	switch route.Upstream.Scheme {
+	case "", "tcp", "udp", "tls":
+	default:
 		return routeEntry{}, fmt.Errorf("unsupported stream upstream scheme %q", route.Upstream.Scheme)
 	}
+	sni, err := normalizeStreamSNI(route)
+	if err != nil {
+		return routeEntry{}, err
+	}
+	upstreamTLS, err := buildUpstreamTLSConfig(route)
+	if err != nil {
+		return routeEntry{}, err
+	}
 	if strings.EqualFold(route.Upstream.Type, "chash") && route.Upstream.HashOn != "" &&
 		!strings.EqualFold(route.Upstream.HashOn, "vars") {
 		return routeEntry{}, fmt.Errorf("unsupported stream chash hash_on %q", route.Upstream.HashOn)
@@ -290,52 +382,17 @@ func buildRouteEntry(route resource.StreamRoute, enabledPlugins map[string]struc
 		hashNodes = append(hashNodes, priorityHashNodes...)
 	}
 	entry := routeEntry{
-		route:     route,
-		groups:    groups,
-		chash:     strings.EqualFold(route.Upstream.Type, "chash"),
-		hashNodes: hashNodes,
+		route:       route,
+		groups:      groups,
+		chash:       strings.EqualFold(route.Upstream.Type, "chash"),
+		hashNodes:   hashNodes,
+		sni:         sni,
+		upstreamTLS: upstreamTLS,
 	}
 
-	if len(route.Plugins) == 0 {
-		entry.serve = entry.rawServe
-		return entry, nil
-	}
-	if len(route.Plugins) != 1 {
-		return routeEntry{}, fmt.Errorf("stream route %q must configure exactly one supported stream plugin", route.ID)
-	}
-	for name, config := range route.Plugins {
-		if len(enabledPlugins) > 0 {
-			if _, ok := enabledPlugins[name]; !ok {
-				return routeEntry{}, fmt.Errorf("stream plugin %q is not enabled", name)
-			}
-		}
-		if name != "mqtt-proxy" {
-			return routeEntry{}, fmt.Errorf("stream plugin %q is not supported by the Go stream owner", name)
-		}
-		p := &mqtt_proxy.Plugin{}
-		if err := p.Init(); err != nil {
-			return routeEntry{}, fmt.Errorf("initialize stream plugin %s: %w", name, err)
-		}
-		compiledSchema, err := util.CompileSchema(p.GetSchema())
-		if err != nil {
-			return routeEntry{}, fmt.Errorf("validate stream plugin %s: %w", name, err)
-		}
-		if err := compiledSchema.Validate(config); err != nil {
-			return routeEntry{}, fmt.Errorf("validate stream plugin %s: %w", name, err)
-		}
-		if err := util.Parse(config, p.Config()); err != nil {
-			return routeEntry{}, fmt.Errorf("parse stream plugin %s: %w", name, err)
-		}
-		if err := base.MaterializePluginSecrets(p); err != nil {
-			return routeEntry{}, fmt.Errorf("materialize stream plugin %s secrets: %w", name, err)
-		}
-		if err := p.PostInit(); err != nil {
-			return routeEntry{}, fmt.Errorf("initialize stream plugin %s: %w", name, err)
-		}
-		entry.serve = func(ctx context.Context, client net.Conn, peer string) (string, string, error) {
-			info, err := p.ServeStreamWithIdle(ctx, client, peer, entry.dial, entry.streamIdleTimeout())
-			return info.ClientID, "mqtt", err
-		}
+	entry.serve = entry.rawServe
+	if err := bindStreamPlugins(&entry, enabledPlugins); err != nil {
+		return routeEntry{}, err
 	}
 	return entry, nil
 }
@@ -384,6 +441,12 @@ func (e routeEntry) streamIdleTimeout() time.Duration {
 }
 
 func (e routeEntry) dial(ctx context.Context, key string) (net.Conn, error) {
+	return e.dialNetwork(ctx, "tcp", key)
+}
+
+// dialNetwork dials the next upstream target over network, retrying other
+// targets up to the upstream's retries.
+func (e routeEntry) dialNetwork(ctx context.Context, network, key string) (net.Conn, error) {
 	retries := max(e.route.Upstream.Retries, 0)
 	tried := make([]map[string]struct{}, len(e.groups))
 	for i := range tried {
@@ -409,7 +472,7 @@ func (e routeEntry) dial(ctx context.Context, key string) (net.Conn, error) {
 			}
 		}
 		tried[groupIndex][target] = struct{}{}
-		conn, err := e.dialTarget(ctx, target)
+		conn, err := e.dialTarget(ctx, network, target)
 		if err == nil {
 			return conn, nil
 		}
@@ -421,7 +484,7 @@ func (e routeEntry) dial(ctx context.Context, key string) (net.Conn, error) {
 	}
 }
 
-func (e routeEntry) dialTarget(ctx context.Context, target string) (net.Conn, error) {
+func (e routeEntry) dialTarget(ctx context.Context, network, target string) (net.Conn, error) {
 	parsed, err := url.Parse(target)
 	if err != nil || parsed.Scheme != "tcp" || parsed.Host == "" {
 		return nil, fmt.Errorf("invalid stream upstream target %q", target)
@@ -432,7 +495,7 @@ func (e routeEntry) dialTarget(ctx context.Context, target string) (net.Conn, er
 	}
 	dialCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", parsed.Host)
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, network, parsed.Host)
 	if err != nil {
 		if ctxErr := ctx.Err(); ctxErr != nil {
 			return nil, ctxErr
@@ -442,6 +505,15 @@ func (e routeEntry) dialTarget(ctx context.Context, target string) (net.Conn, er
 		}
 		return nil, err
 	}
+	if header := upstreamProxyHeader(ctx); network == "tcp" && header != nil {
+		if _, err := conn.Write(header); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("write PROXY protocol header to %s: %w", parsed.Host, err)
+		}
+	}
+	if network == "tcp" && e.upstreamTLS != nil {
+		return originateTLS(dialCtx, conn, e.upstreamTLS, parsed.Host)
+	}
 	return conn, nil
 }
 
@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/plugin/mqtt_proxy"
 	"github.com/wklken/apisix-go/pkg/resource"
 	streambridge "github.com/wklken/apisix-go/pkg/stream/bridge"
@@ -357,9 +358,9 @@ func TestRouterMatchesClientLocalAddrInsteadOfWildcardListener(t *testing.T) {
 
 func TestNewRouterRejectsUnsupportedUpstreamScheme(t *testing.T) {
 	_, err := NewRouter([]resource.StreamRoute{{
-		ID: "tls-route",
+		ID: "https-route",
 		Upstream: resource.Upstream{
-			Scheme: "tls",
+			Scheme: "https",
 			Nodes:  []resource.Node{{Host: "127.0.0.1", Port: 443, Weight: 1}},
 		},
 	}}, nil, nil)
@@ -576,12 +577,12 @@ func TestRouterRejectsMalformedMQTTBeforeDial(t *testing.T) {
 func TestNewRouterRejectsUnknownStreamPlugin(t *testing.T) {
 	_, err := NewRouter([]resource.StreamRoute{{
 		ID:      "unknown-plugin",
-		Plugins: map[string]resource.PluginConfig{"limit-conn": map[string]any{}},
+		Plugins: map[string]resource.PluginConfig{"key-auth": map[string]any{}},
 		Upstream: resource.Upstream{
 			Scheme: "tcp",
 			Nodes:  []resource.Node{{Host: "127.0.0.1", Port: 1, Weight: 1}},
 		},
-	}}, []string{"limit-conn"}, nil)
+	}}, []string{"key-auth"}, nil)
 	if err == nil || !strings.Contains(err.Error(), "not supported by the Go stream owner") {
 		t.Fatalf("NewRouter() error = %v, want unsupported plugin error", err)
 	}
@@ -838,7 +839,7 @@ func TestRouterMatchesExactRemoteAddressAndCIDR(t *testing.T) {
 		if err != nil {
 			t.Fatalf("NewRouter(%q) error = %v", remote, err)
 		}
-		if !router.routeMatches(resource.StreamRoute{RemoteAddr: remote}, "127.0.0.1:1234", "127.0.0.1:1883") {
+		if !router.routeMatches(routeEntry{route: resource.StreamRoute{RemoteAddr: remote}}, "127.0.0.1:1234", "127.0.0.1:1883", "") {
 			t.Fatalf("route with remote_addr %q did not match loopback peer", remote)
 		}
 	}
@@ -866,7 +867,7 @@ func TestRouterUsesFirstMatchingResource(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewRouter() error = %v", err)
 	}
-	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000")
+	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000", "")
 	if !ok || entry.route.ID != "wildcard" {
 		t.Fatalf("matched route = %#v, want wildcard first resource", entry.route)
 	}
@@ -893,7 +894,7 @@ func TestRouterPreservesResourceOrder(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewRouter() error = %v", err)
 	}
-	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000")
+	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000", "")
 	if !ok || entry.route.ID != "first" {
 		t.Fatalf("matched route = %#v, want first resource", entry.route)
 	}
@@ -940,7 +941,7 @@ func TestRouterReloadRejectsConflictingListenAddresses(t *testing.T) {
 		!strings.Contains(err.Error(), "beta") {
 		t.Fatalf("Reload() error = %q, want both route IDs", err)
 	}
-	entry, ok := router.matchEntry("127.0.0.1:1883", "192.0.2.1:1000")
+	entry, ok := router.matchEntry("127.0.0.1:1883", "192.0.2.1:1000", "")
 	if !ok || entry.route.ID != "first" {
 		t.Fatalf("matched route after rejected reload = %#v, want last-good first", entry.route)
 	}
@@ -962,7 +963,7 @@ func TestRouterUsesDeterministicClientIDHashForChashUpstream(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewRouter() error = %v", err)
 	}
-	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000")
+	entry, ok := router.matchEntry("127.0.0.1:1883", "127.0.0.1:1000", "")
 	if !ok {
 		t.Fatal("chash route did not match")
 	}
@@ -1069,3 +1070,82 @@ func TestUnownedSecretReferenceRejectsStreamPlugin(t *testing.T) {
 		t.Fatalf("buildRouteEntry() error = %v, want stream secret rejection", err)
 	}
 }
+
+func TestRuntimeLimitConnRejectsTCPConnectionsOverLimit(t *testing.T) {
+	upstream, upstreamAddr := startBlockingStreamUpstream(t)
+	defer func() { _ = upstream.Close() }()
+	route := runtimeTestRoute(t, "limited", upstreamAddr)
+	route.Plugins = map[string]resource.PluginConfig{
+		"limit-conn": map[string]any{"conn": 1, "burst": 0, "default_conn_delay": 0.1, "key": "remote_addr"},
+	}
+
+	results := make(chan Result, 1)
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
+		[]resource.StreamRoute{route},
+		[]string{"limit-conn"},
+		func(result Result) { results <- result },
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+
+	held, err := net.Dial("tcp", runtime.Addresses()[0])
+	if err != nil {
+		t.Fatalf("dial held connection: %v", err)
+	}
+	t.Cleanup(func() { _ = held.Close() })
+	if _, err := held.Write([]byte("held")); err != nil {
+		t.Fatalf("write held connection: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	rejected, err := net.Dial("tcp", runtime.Addresses()[0])
+	if err != nil {
+		t.Fatalf("dial rejected connection: %v", err)
+	}
+	t.Cleanup(func() { _ = rejected.Close() })
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection over the limit was not closed")
+	}
+	select {
+	case result := <-results:
+		if !errors.Is(result.Err, ErrStreamRejected) || result.RouteID != "limited" {
+			t.Fatalf("result = %+v, want a limit-conn rejection", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("missing rejection result")
+	}
+}
+
+func TestNewRouterRejectsRequestScopedLimitConnOnStream(t *testing.T) {
+	_, err := NewRouter([]resource.StreamRoute{{
+		ID: "limited-rules",
+		Plugins: map[string]resource.PluginConfig{"limit-conn": map[string]any{
+			"default_conn_delay": 0.1,
+			"rules":              []any{map[string]any{"conn": 1, "burst": 0, "key": "$remote_addr"}},
+		}},
+		Upstream: resource.Upstream{Nodes: []resource.Node{{Host: "127.0.0.1", Port: 1, Weight: 1}}},
+	}}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "rules are not supported on stream routes") {
+		t.Fatalf("NewRouter() error = %v, want stream limit-conn rules rejection", err)
+	}
+}
+
+func TestStreamSessionResolvesAddressVariables(t *testing.T) {
+	session := streamSession{server: "127.0.0.1:9100", remote: "[::1]:5353"}
+	for name, want := range map[string]string{
+		"remote_addr": "::1",
+		"remote_port": "5353",
+		"server_addr": "127.0.0.1",
+		"server_port": "9100",
+		"uri":         "",
+	} {
+		if got := session.variable(name); got != want {
+			t.Fatalf("variable(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package stream
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +16,7 @@ import (
 	"github.com/wklken/apisix-go/pkg/config"
 	"github.com/wklken/apisix-go/pkg/logger"
 	"github.com/wklken/apisix-go/pkg/resource"
+	"github.com/wklken/apisix-go/pkg/util"
 )
 
 const (
@@ -25,7 +28,16 @@ type Runtime struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	router    *Router
+	mu        sync.Mutex
 	listeners []net.Listener
+	bound     map[string]*streamListener
+	retired   map[net.Listener]struct{}
+	// tlsConfig supplies the server configuration of TLS listeners; nil
+	// rejects any listener with tls enabled.
+	tlsConfig TLSConfigProvider
+	// udpBound holds the UDP listeners by address. TCP and UDP listeners are
+	// tracked apart because both protocols may bind the same port.
+	udpBound  map[string]*udpListener
 	wg        sync.WaitGroup
 	closeOnce sync.Once
 	closeDone chan struct{}
@@ -33,7 +45,21 @@ type Runtime struct {
 
 func NewRuntime(
 	ctx context.Context,
-	specs []config.TcpListen,
+	proxy config.StreamProxy,
+	routes []resource.StreamRoute,
+	enabledPlugins []string,
+	onResult func(Result),
+) (*Runtime, error) {
+	return NewRuntimeWithTLS(ctx, proxy, nil, routes, enabledPlugins, onResult)
+}
+
+// NewRuntimeWithTLS is NewRuntime with TLS termination for listeners that set
+// tls. tlsConfig is called whenever TLS listeners are bound, including by
+// Reconfigure.
+func NewRuntimeWithTLS(
+	ctx context.Context,
+	proxy config.StreamProxy,
+	tlsConfig TLSConfigProvider,
 	routes []resource.StreamRoute,
 	enabledPlugins []string,
 	onResult func(Result),
@@ -41,21 +67,11 @@ func NewRuntime(
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	if len(specs) == 0 {
-		return nil, fmt.Errorf("stream runtime requires at least one TCP listener")
-	}
-	for _, spec := range specs {
-		if spec.Tls {
-			return nil, fmt.Errorf("TLS stream listeners are not supported")
-		}
-		if spec.ProxyProtocol {
-			return nil, fmt.Errorf("stream listener PROXY protocol is not supported")
-		}
-		if spec.ProxyProtocolToUpstream {
-			return nil, fmt.Errorf("upstream PROXY protocol is not supported")
-		}
+	if err := validateListenSpecs(proxy); err != nil {
+		return nil, err
 	}
-	if err := validateStreamRoutes(routes); err != nil {
+	serverTLS, err := listenerTLSConfig(proxy, tlsConfig)
+	if err != nil {
 		return nil, err
 	}
 	router, err := NewRouter(routes, enabledPlugins, onResult)
@@ -67,56 +83,260 @@ func NewRuntime(
 		ctx:       runtimeCtx,
 		cancel:    cancel,
 		router:    router,
+		bound:     make(map[string]*streamListener, len(proxy.Tcp)),
+		tlsConfig: tlsConfig,
+		udpBound:  make(map[string]*udpListener, len(proxy.Udp)),
 		closeDone: make(chan struct{}),
 	}
+	trusted := util.ParseTrustedNetworks(proxy.TrustedAddresses)
 
-	for _, spec := range specs {
+	for _, spec := range proxy.Tcp {
 		address, err := normalizeListenAddr(spec.Addr)
 		if err != nil {
 			runtime.close()
 			return nil, err
 		}
-		listener, err := net.Listen("tcp", address)
+		tcpListener, err := net.Listen("tcp", address)
 		if err != nil {
 			runtime.close()
 			return nil, fmt.Errorf("listen stream address %q: %w", address, err)
 		}
+		listener := &streamListener{Listener: tcpListener}
+		listener.settings.Store(newListenerSettings(spec, trusted, serverTLS))
 		runtime.listeners = append(runtime.listeners, listener)
+		runtime.bound[address] = listener
+	}
+	for _, spec := range proxy.Udp {
+		address, err := normalizeListenAddr(spec)
+		if err != nil {
+			runtime.close()
+			return nil, err
+		}
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			runtime.close()
+			return nil, fmt.Errorf("listen stream UDP address %q: %w", address, err)
+		}
+		runtime.udpBound[address] = newUDPListener(runtimeCtx, conn, router, &runtime.wg)
 	}
 
 	for _, listener := range runtime.listeners {
 		runtime.wg.Add(1)
 		go runtime.serveListener(listener)
 	}
+	for _, listener := range runtime.udpBound {
+		runtime.wg.Go(listener.serve)
+	}
 	return runtime, nil
 }
 
+func validateListenSpecs(proxy config.StreamProxy) error {
+	if len(proxy.Tcp) == 0 && len(proxy.Udp) == 0 {
+		return fmt.Errorf("stream runtime requires at least one TCP or UDP listener")
+	}
+	return nil
+}
+
+// listenerTLSConfig returns the server TLS configuration when any TCP
+// listener sets tls, and nil otherwise.
+func listenerTLSConfig(proxy config.StreamProxy, provider TLSConfigProvider) (*tls.Config, error) {
+	index := slices.IndexFunc(proxy.Tcp, func(spec config.TcpListen) bool { return spec.Tls })
+	if index < 0 {
+		return nil, nil
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("TLS stream listener %q has no TLS configuration", proxy.Tcp[index].Addr)
+	}
+	tlsConfig, err := provider()
+	if err != nil {
+		return nil, fmt.Errorf("TLS stream listener %q: %w", proxy.Tcp[index].Addr, err)
+	}
+	if tlsConfig == nil {
+		return nil, fmt.Errorf("TLS stream listener %q has no TLS configuration", proxy.Tcp[index].Addr)
+	}
+	return tlsConfig, nil
+}
+
 func (r *Runtime) Addresses() []string {
-	addresses := make([]string, 0, len(r.listeners))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	addresses := make([]string, 0, len(r.listeners)+len(r.udpBound))
 	for _, listener := range r.listeners {
 		if listener.Addr() != nil {
 			addresses = append(addresses, listener.Addr().String())
 		}
 	}
-	return addresses
+	udpAddresses := make([]string, 0, len(r.udpBound))
+	for _, listener := range r.udpBound {
+		udpAddresses = append(udpAddresses, "udp://"+listener.address())
+	}
+	slices.Sort(udpAddresses)
+	return append(addresses, udpAddresses...)
 }
 
 func (r *Runtime) Reload(routes []resource.StreamRoute) error {
-	if err := validateStreamRoutes(routes); err != nil {
-		return err
-	}
 	return r.router.Reload(routes)
 }
 
-func validateStreamRoutes(routes []resource.StreamRoute) error {
-	for _, route := range routes {
-		if route.Upstream.TLS != nil {
-			return fmt.Errorf("TLS stream upstreams are not supported")
+// Reconfigure applies a reloaded listener set and stream plugin allowlist.
+// Added addresses are bound before anything is published, so a bind or route
+// failure leaves the runtime unchanged. A removed TCP listener stops accepting
+// while its established connections run to completion; a removed UDP listener
+// closes its socket and ends its sessions, which cannot outlive it. A kept
+// address applies its tls and PROXY protocol flags to connections accepted
+// afterwards.
+func (r *Runtime) Reconfigure(
+	proxy config.StreamProxy,
+	routes []resource.StreamRoute,
+	enabledPlugins []string,
+) error {
+	r.mu.Lock()
+	tlsConfig := r.tlsConfig
+	r.mu.Unlock()
+	return r.ReconfigureWithTLS(proxy, tlsConfig, routes, enabledPlugins)
+}
+
+// ReconfigureWithTLS is Reconfigure with the TLS configuration of the reloaded
+// listener set; tlsConfig replaces the runtime's provider once the listeners
+// are published.
+func (r *Runtime) ReconfigureWithTLS(
+	proxy config.StreamProxy,
+	tlsConfig TLSConfigProvider,
+	routes []resource.StreamRoute,
+	enabledPlugins []string,
+) error {
+	if err := validateListenSpecs(proxy); err != nil {
+		return err
+	}
+	tcpSpecs := make([]string, 0, len(proxy.Tcp))
+	for _, spec := range proxy.Tcp {
+		tcpSpecs = append(tcpSpecs, spec.Addr)
+	}
+	addresses, err := uniqueListenAddrs(tcpSpecs)
+	if err != nil {
+		return err
+	}
+	serverTLS, err := listenerTLSConfig(proxy, tlsConfig)
+	if err != nil {
+		return err
+	}
+	udpAddresses, err := uniqueListenAddrs(proxy.Udp)
+	if err != nil {
+		return err
+	}
+	trusted := util.ParseTrustedNetworks(proxy.TrustedAddresses)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		return fmt.Errorf("stream runtime is closed")
+	}
+	listeners := make([]net.Listener, 0, len(addresses))
+	bound := make(map[string]*streamListener, len(addresses))
+	settings := make(map[*streamListener]*listenerSettings, len(addresses))
+	var opened []*streamListener
+	udpBound := make(map[string]*udpListener, len(udpAddresses))
+	var openedUDP []*udpListener
+	closeOpened := func() {
+		for _, listener := range opened {
+			_ = listener.Close()
 		}
+		for _, listener := range openedUDP {
+			listener.close()
+		}
+	}
+	for index, address := range addresses {
+		listener, ok := r.bound[address]
+		if !ok {
+			tcpListener, err := net.Listen("tcp", address)
+			if err != nil {
+				closeOpened()
+				return fmt.Errorf("listen stream address %q: %w", address, err)
+			}
+			listener = &streamListener{Listener: tcpListener}
+			opened = append(opened, listener)
+		}
+		settings[listener] = newListenerSettings(proxy.Tcp[index], trusted, serverTLS)
+		listeners = append(listeners, listener)
+		bound[address] = listener
+	}
+	for _, address := range udpAddresses {
+		listener, ok := r.udpBound[address]
+		if !ok {
+			conn, err := net.ListenPacket("udp", address)
+			if err != nil {
+				closeOpened()
+				return fmt.Errorf("listen stream UDP address %q: %w", address, err)
+			}
+			listener = newUDPListener(r.ctx, conn, r.router, &r.wg)
+			openedUDP = append(openedUDP, listener)
+		}
+		udpBound[address] = listener
+	}
+	if err := r.router.Reconfigure(routes, enabledPlugins); err != nil {
+		closeOpened()
+		return err
+	}
+
+	for address, listener := range r.bound {
+		if _, ok := bound[address]; ok {
+			continue
+		}
+		if r.retired == nil {
+			r.retired = make(map[net.Listener]struct{})
+		}
+		r.retired[listener] = struct{}{}
+		_ = listener.Close()
+	}
+	for address, listener := range r.udpBound {
+		if _, ok := udpBound[address]; !ok {
+			listener.close()
+		}
+	}
+	for _, listener := range bound {
+		listener.settings.Store(settings[listener])
+	}
+	r.listeners = listeners
+	r.bound = bound
+	r.udpBound = udpBound
+	r.tlsConfig = tlsConfig
+	for _, listener := range opened {
+		r.wg.Add(1)
+		go r.serveListener(listener)
+	}
+	for _, listener := range openedUDP {
+		r.wg.Go(listener.serve)
 	}
 	return nil
 }
 
+func uniqueListenAddrs(specs []string) ([]string, error) {
+	addresses := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		address, err := normalizeListenAddr(spec)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(addresses, address) {
+			return nil, fmt.Errorf("stream listener address %q is configured more than once", address)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// takeRetired reports whether listener was removed by Reconfigure, so its
+// accept failure ends only that listener's loop.
+func (r *Runtime) takeRetired(listener net.Listener) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.retired[listener]; !ok {
+		return false
+	}
+	delete(r.retired, listener)
+	return true
+}
+
 func (r *Runtime) Close(ctx context.Context) error {
 	if ctx == nil {
 		ctx = context.Background()
@@ -142,9 +362,19 @@ func (r *Runtime) initiateClose() {
 
 func (r *Runtime) close() {
 	r.cancel()
-	for _, listener := range r.listeners {
+	r.mu.Lock()
+	listeners := append([]net.Listener(nil), r.listeners...)
+	udpListeners := make([]*udpListener, 0, len(r.udpBound))
+	for _, listener := range r.udpBound {
+		udpListeners = append(udpListeners, listener)
+	}
+	r.mu.Unlock()
+	for _, listener := range listeners {
 		_ = listener.Close()
 	}
+	for _, listener := range udpListeners {
+		listener.close()
+	}
 }
 
 func (r *Runtime) serveListener(listener net.Listener) {
@@ -153,7 +383,7 @@ func (r *Runtime) serveListener(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if r.ctx.Err() != nil {
+			if r.ctx.Err() != nil || r.takeRetired(listener) {
 				return
 			}
 			if errors.Is(err, syscall.EINTR) {
@@ -181,7 +181,7 @@ func TestRuntimeServesConfiguredListenerAndReloadsRoutes(t *testing.T) {
 	results := make(chan Result, 2)
 	runtime, err := NewRuntime(
 		ctx,
-		[]config.TcpListen{{Addr: "127.0.0.1:0"}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
 		[]resource.StreamRoute{runtimeTestRoute(t, "first", firstAddr)},
 		nil,
 		func(result Result) { results <- result },
@@ -221,7 +221,7 @@ func TestRuntimeCloseCancelsActiveStream(t *testing.T) {
 
 	runtime, err := NewRuntime(
 		context.Background(),
-		[]config.TcpListen{{Addr: "127.0.0.1:0"}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
 		[]resource.StreamRoute{runtimeTestRoute(t, "blocking", upstreamAddr)},
 		nil,
 		nil,
@@ -258,7 +258,7 @@ func TestRuntimeCancellationBoundsBackpressure(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	runtime, err := NewRuntime(
 		ctx,
-		[]config.TcpListen{{Addr: "127.0.0.1:0"}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
 		[]resource.StreamRoute{runtimeTestRoute(t, "backpressure", upstreamAddr)},
 		nil,
 		nil,
@@ -311,16 +311,16 @@ func TestRuntimeCancellationBoundsBackpressure(t *testing.T) {
 func TestNewRuntimeRejectsTLSAndInvalidAddress(t *testing.T) {
 	if _, err := NewRuntime(
 		context.Background(),
-		[]config.TcpListen{{Addr: "127.0.0.1:0", Tls: true}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0", Tls: true}}},
 		nil,
 		nil,
 		nil,
 	); err == nil {
-		t.Fatal("NewRuntime() accepted unsupported TLS listener")
+		t.Fatal("NewRuntime() accepted a TLS listener without a TLS configuration")
 	}
 	if _, err := NewRuntime(
 		context.Background(),
-		[]config.TcpListen{{Addr: "not-an-address"}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "not-an-address"}}},
 		nil,
 		nil,
 		nil,
@@ -335,15 +335,13 @@ func TestNewRuntimeRejectsEmptyListenersAndUnsupportedFlags(t *testing.T) {
 		spec config.TcpListen
 	}{
 		{name: "tls", spec: config.TcpListen{Addr: "127.0.0.1:0", Tls: true}},
-		{name: "proxy protocol", spec: config.TcpListen{Addr: "127.0.0.1:0", ProxyProtocol: true}},
-		{name: "proxy protocol upstream", spec: config.TcpListen{Addr: "127.0.0.1:0", ProxyProtocolToUpstream: true}},
 	}
-	if _, err := NewRuntime(context.Background(), nil, nil, nil, nil); err == nil {
+	if _, err := NewRuntime(context.Background(), config.StreamProxy{}, nil, nil, nil); err == nil {
 		t.Fatal("NewRuntime() accepted an empty listener set")
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			if _, err := NewRuntime(context.Background(), []config.TcpListen{test.spec}, nil, nil, nil); err == nil {
+			if _, err := NewRuntime(context.Background(), config.StreamProxy{Tcp: []config.TcpListen{test.spec}}, nil, nil, nil); err == nil {
 				t.Fatalf("NewRuntime() accepted unsupported %s", test.name)
 			}
 		})
@@ -391,7 +389,7 @@ func TestNewRuntimeRejectsUnsupportedAndUnresolvedRoutes(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			if _, err := NewRuntime(
 				context.Background(),
-				[]config.TcpListen{{Addr: "127.0.0.1:0"}},
+				config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
 				[]resource.StreamRoute{test.route},
 				test.flags,
 				nil,
@@ -418,10 +416,10 @@ func TestNewRuntimeRollsBackEarlierListenerOnBindFailure(t *testing.T) {
 
 	if _, err := NewRuntime(
 		context.Background(),
-		[]config.TcpListen{
+		config.StreamProxy{Tcp: []config.TcpListen{
 			{Addr: firstAddress},
 			{Addr: occupied.Addr().String()},
-		},
+		}},
 		nil,
 		nil,
 		nil,
@@ -440,7 +438,7 @@ func TestRuntimeReloadRejectsInvalidRoutesAndKeepsLastGood(t *testing.T) {
 	defer func() { _ = upstream.Close() }()
 	runtime, err := NewRuntime(
 		context.Background(),
-		[]config.TcpListen{{Addr: "127.0.0.1:0"}},
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
 		[]resource.StreamRoute{runtimeTestRoute(t, "last-good", upstreamAddr)},
 		nil,
 		nil,
@@ -474,6 +472,111 @@ func TestRuntimeReloadRejectsInvalidRoutesAndKeepsLastGood(t *testing.T) {
 	}
 }
 
+func TestRuntimeReconfigureSwapsListenersAndKeepsActiveStreams(t *testing.T) {
+	firstUpstream, firstUpstreamAddr := startStreamUpstream(t, []byte("first-response"))
+	defer func() { _ = firstUpstream.Close() }()
+	secondUpstream, secondUpstreamAddr := startStreamUpstream(t, []byte("second-response"))
+	defer func() { _ = secondUpstream.Close() }()
+	reserved, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve listener address: %v", err)
+	}
+	nextAddress := reserved.Addr().String()
+	_ = reserved.Close()
+
+	runtime, err := NewRuntime(
+		context.Background(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
+		[]resource.StreamRoute{runtimeTestRoute(t, "first", firstUpstreamAddr)},
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	previousAddress := runtime.Addresses()[0]
+
+	active, err := net.Dial("tcp", previousAddress)
+	if err != nil {
+		t.Fatalf("dial runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = active.Close() })
+	_ = active.SetDeadline(time.Now().Add(time.Second))
+
+	if err := runtime.Reconfigure(
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: nextAddress}}},
+		[]resource.StreamRoute{runtimeTestRoute(t, "second", secondUpstreamAddr)},
+		nil,
+	); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if got := runtime.Addresses(); len(got) != 1 || got[0] != nextAddress {
+		t.Fatalf("Addresses() = %v, want [%s]", got, nextAddress)
+	}
+	if _, err := active.Write([]byte("stream-request")); err != nil {
+		t.Fatalf("write active stream: %v", err)
+	}
+	response := make([]byte, len("first-response"))
+	if _, err := io.ReadFull(active, response); err != nil {
+		t.Fatalf("read active stream after reconfigure: %v", err)
+	}
+	if string(response) != "first-response" {
+		t.Fatalf("active stream response = %q, want first-response", response)
+	}
+	got := runtimeRoundTrip(t, nextAddress, []byte("stream-request"), len("second-response"))
+	if string(got) != "second-response" {
+		t.Fatalf("new listener response = %q, want second-response", got)
+	}
+	if conn, err := net.DialTimeout("tcp", previousAddress, 100*time.Millisecond); err == nil {
+		_ = conn.Close()
+		t.Fatal("removed stream listener still accepts connections")
+	}
+	select {
+	case <-runtime.ctx.Done():
+		t.Fatal("retiring a listener closed the runtime")
+	default:
+	}
+}
+
+func TestRuntimeReconfigureBindFailureKeepsRuntime(t *testing.T) {
+	upstream, upstreamAddr := startStreamUpstream(t, []byte("last-good"))
+	defer func() { _ = upstream.Close() }()
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("occupy listener: %v", err)
+	}
+	defer func() { _ = occupied.Close() }()
+
+	runtime, err := NewRuntime(
+		context.Background(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
+		[]resource.StreamRoute{runtimeTestRoute(t, "last-good", upstreamAddr)},
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+
+	err = runtime.Reconfigure(
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}, {Addr: occupied.Addr().String()}}},
+		nil,
+		nil,
+	)
+	if err == nil || !strings.Contains(err.Error(), occupied.Addr().String()) {
+		t.Fatalf("Reconfigure() error = %v, want the occupied address", err)
+	}
+	if got := len(runtime.Addresses()); got != 1 {
+		t.Fatalf("listener count after failed reconfigure = %d, want 1", got)
+	}
+	got := runtimeRoundTrip(t, runtime.Addresses()[0], []byte("stream-request"), len("last-good"))
+	if string(got) != "last-good" {
+		t.Fatalf("response after failed reconfigure = %q, want last-good", got)
+	}
+}
+
 func runtimeTestRoute(t *testing.T, id, upstreamAddr string) resource.StreamRoute {
 	t.Helper()
 	host, portText, err := net.SplitHostPort(upstreamAddr)
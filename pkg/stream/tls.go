@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+// tlsHandshakeTimeout bounds the client handshake of a TLS stream listener,
+// which runs before any route, and so any route timeout, is known.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSConfigProvider returns the server TLS configuration for TLS stream
+// listeners. The configuration selects certificates per SNI itself, so one
+// configuration serves every TLS listener for the runtime's lifetime.
+type TLSConfigProvider func() (*tls.Config, error)
+
+// handshakeStreamClient completes the TLS handshake of a terminated client and
+// returns the SNI it requested. Plain connections return an empty SNI.
+func handshakeStreamClient(ctx context.Context, client net.Conn) (string, error) {
+	tlsConn, ok := client.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	handshakeCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		return "", fmt.Errorf("stream TLS handshake: %w", err)
+	}
+	return tlsConn.ConnectionState().ServerName, nil
+}
+
+// normalizeStreamSNI lowercases a route sni and checks that a wildcard only
+// replaces the leftmost label, as APISIX SSL snis do.
+func normalizeStreamSNI(route resource.StreamRoute) (string, error) {
+	sni := strings.ToLower(strings.TrimSpace(route.Sni))
+	if sni == "" {
+		return "", nil
+	}
+	if strings.Contains(strings.TrimPrefix(sni, "*."), "*") || sni == "*." {
+		return "", fmt.Errorf("stream route %q sni %q is invalid", route.ID, route.Sni)
+	}
+	return sni, nil
+}
+
+// matchesSNI reports whether a client SNI matches a normalized route sni. A
+// wildcard matches one or more leading labels.
+func matchesSNI(pattern, serverName string) bool {
+	serverName = strings.ToLower(serverName)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(serverName, suffix) && len(serverName) > len(suffix)
+	}
+	return pattern == serverName
+}
+
+// buildUpstreamTLSConfig returns the client TLS configuration of a "tls"
+// scheme upstream. client_cert_id must already be resolved into the inline
+// certificate and key, as upstream_id is resolved before routes reach the
+// runtime.
+func buildUpstreamTLSConfig(route resource.StreamRoute) (*tls.Config, error) {
+	upstream := route.Upstream
+	if upstream.Scheme != "tls" {
+		if upstream.TLS != nil {
+			return nil, fmt.Errorf("stream route %q upstream tls requires the tls scheme", route.ID)
+		}
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true}
+	if upstream.TLS == nil {
+		return config, nil
+	}
+	config.InsecureSkipVerify = !upstream.TLS.Verify
+	if upstream.TLS.ClientCertID != nil {
+		return nil, fmt.Errorf("stream route %q upstream client_cert_id was not resolved", route.ID)
+	}
+	if (upstream.TLS.ClientCert == "") != (upstream.TLS.ClientKey == "") {
+		return nil, fmt.Errorf("stream route %q upstream client_cert and client_key must be configured together", route.ID)
+	}
+	if upstream.TLS.ClientCert != "" {
+		certificate, err := tls.X509KeyPair([]byte(upstream.TLS.ClientCert), []byte(upstream.TLS.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("stream route %q upstream client certificate: %w", route.ID, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// originateTLS starts TLS to an upstream node over conn. The node host is the
+// verification name, and the SNI unless it is an IP address.
+func originateTLS(ctx context.Context, conn net.Conn, base *tls.Config, address string) (net.Conn, error) {
+	config := base.Clone()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("stream upstream TLS handshake with %s: %w", address, err)
+	}
+	return tlsConn, nil
+}
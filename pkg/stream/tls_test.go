@@ -0,0 +1,316 @@
+package stream
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func TestRuntimeTerminatesTLSAndRoutesBySNI(t *testing.T) {
+	certificate, _, _ := streamTestCertificate(t, "db.example.com", "*.mq.example.com", "other.example.com")
+	_, databaseAddr := startStreamUpstream(t, []byte("database"))
+	_, brokerAddr := startStreamUpstream(t, []byte("broker--"))
+	_, fallbackAddr := startStreamUpstream(t, []byte("fallback"))
+
+	fallback := runtimeTestRoute(t, "fallback", fallbackAddr)
+	database := runtimeTestRoute(t, "database", databaseAddr)
+	database.Sni = "DB.example.com"
+	broker := runtimeTestRoute(t, "broker", brokerAddr)
+	broker.Sni = "*.mq.example.com"
+
+	results := make(chan Result, 4)
+	runtime, err := NewRuntimeWithTLS(
+		t.Context(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0", Tls: true}}},
+		func() (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+		},
+		[]resource.StreamRoute{fallback, database, broker},
+		nil,
+		func(result Result) { results <- result },
+	)
+	if err != nil {
+		t.Fatalf("NewRuntimeWithTLS() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	address := runtime.Addresses()[0]
+
+	for _, test := range []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "db.example.com", want: "database"},
+		{serverName: "east.mq.example.com", want: "broker--"},
+		{serverName: "other.example.com", want: "fallback"},
+	} {
+		if got := tlsRoundTrip(t, address, test.serverName); got != test.want {
+			t.Fatalf("SNI %q response = %q, want %q", test.serverName, got, test.want)
+		}
+	}
+
+	client, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dial runtime: %v", err)
+	}
+	_, _ = client.Write([]byte("stream-request"))
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = io.ReadAll(client)
+	_ = client.Close()
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case result := <-results:
+			if result.Protocol == "tls" && result.Err != nil {
+				return
+			}
+		case <-deadline:
+			t.Fatal("plain client on a TLS listener did not report a handshake failure")
+		}
+	}
+}
+
+func TestNewRuntimeRejectsTLSListenerWithoutConfiguration(t *testing.T) {
+	_, err := NewRuntimeWithTLS(
+		context.Background(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0", Tls: true}}},
+		func() (*tls.Config, error) { return nil, errors.New("no frontend TLS") },
+		nil,
+		nil,
+		nil,
+	)
+	if err == nil || !strings.Contains(err.Error(), "no frontend TLS") {
+		t.Fatalf("NewRuntimeWithTLS() error = %v, want the provider error", err)
+	}
+}
+
+func TestRuntimeReconfigureEnablesTLSOnKeptListener(t *testing.T) {
+	certificate, _, _ := streamTestCertificate(t, "db.example.com")
+	_, plainAddr := startStreamUpstream(t, []byte("plain---"))
+	_, secureAddr := startStreamUpstream(t, []byte("secure--"))
+	listen := config.TcpListen{Addr: "127.0.0.1:0"}
+	runtime, err := NewRuntimeWithTLS(
+		t.Context(),
+		config.StreamProxy{Tcp: []config.TcpListen{listen}},
+		func() (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+		},
+		[]resource.StreamRoute{runtimeTestRoute(t, "plain", plainAddr)},
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntimeWithTLS() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	address := runtime.Addresses()[0]
+	if got := runtimeRoundTrip(t, address, []byte("stream-request"), len("plain---")); string(got) != "plain---" {
+		t.Fatalf("plain response = %q", got)
+	}
+
+	listen.Tls = true
+	secure := runtimeTestRoute(t, "secure", secureAddr)
+	secure.Sni = "db.example.com"
+	if err := runtime.Reconfigure(
+		config.StreamProxy{Tcp: []config.TcpListen{listen}},
+		[]resource.StreamRoute{secure},
+		nil,
+	); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if got := runtime.Addresses()[0]; got != address {
+		t.Fatalf("Addresses()[0] = %q, want the kept listener %q", got, address)
+	}
+	if got := tlsRoundTrip(t, address, "db.example.com"); got != "secure--" {
+		t.Fatalf("TLS response = %q, want secure--", got)
+	}
+}
+
+func TestRuntimeOriginatesMutualTLSToUpstream(t *testing.T) {
+	serverCertificate, _, _ := streamTestCertificate(t, "upstream.example.com")
+	clientCertificate, clientPEM, clientKeyPEM := streamTestCertificate(t, "client.example.com")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCertificate.Leaf)
+	upstream, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("listen TLS upstream: %v", err)
+	}
+	t.Cleanup(func() { _ = upstream.Close() })
+	go func() {
+		conn, acceptErr := upstream.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		request := make([]byte, len("stream-request"))
+		if _, readErr := io.ReadFull(conn, request); readErr != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("mutual"))
+	}()
+
+	route := runtimeTestRoute(t, "mtls", upstream.Addr().String())
+	route.Upstream.Scheme = "tls"
+	route.Upstream.TLS = &resource.UpstreamTLS{ClientCert: clientPEM, ClientKey: clientKeyPEM}
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
+		[]resource.StreamRoute{route},
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	if got := runtimeRoundTrip(t, runtime.Addresses()[0], []byte("stream-request"), len("mutual")); string(got) != "mutual" {
+		t.Fatalf("response = %q, want mutual", got)
+	}
+
+	route.Upstream.TLS.Verify = true
+	if err := runtime.Reload([]resource.StreamRoute{route}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	entry, _ := runtime.router.matchEntry("127.0.0.1:1", "127.0.0.1:2", "")
+	if _, err := entry.dial(context.Background(), ""); err == nil {
+		t.Fatal("verified dial trusted a self-signed upstream certificate")
+	}
+}
+
+func TestNewRouterValidatesSNIAndUpstreamTLS(t *testing.T) {
+	nodes := []resource.Node{{Host: "127.0.0.1", Port: 1, Weight: 1}}
+	for _, test := range []struct {
+		name  string
+		route resource.StreamRoute
+		want  string
+	}{
+		{
+			name:  "inner wildcard",
+			route: resource.StreamRoute{ID: "sni", Sni: "db.*.example.com", Upstream: resource.Upstream{Nodes: nodes}},
+			want:  "sni",
+		},
+		{
+			name: "tls without tls scheme",
+			route: resource.StreamRoute{
+				ID:       "tls",
+				Upstream: resource.Upstream{Scheme: "tcp", TLS: &resource.UpstreamTLS{}, Nodes: nodes},
+			},
+			want: "requires the tls scheme",
+		},
+		{
+			name: "unresolved client_cert_id",
+			route: resource.StreamRoute{
+				ID:       "cert",
+				Upstream: resource.Upstream{Scheme: "tls", TLS: &resource.UpstreamTLS{ClientCertID: "1"}, Nodes: nodes},
+			},
+			want: "client_cert_id was not resolved",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewRouter([]resource.StreamRoute{test.route}, nil, nil); err == nil ||
+				!strings.Contains(err.Error(), test.want) {
+				t.Fatalf("NewRouter() error = %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	if _, err := NewRouter([]resource.StreamRoute{
+		{ID: "a", ServerPort: 9100, Sni: "a.example.com", Upstream: resource.Upstream{Nodes: nodes}},
+		{ID: "b", ServerPort: 9100, Sni: "b.example.com", Upstream: resource.Upstream{Nodes: nodes}},
+	}, nil, nil); err != nil {
+		t.Fatalf("NewRouter() rejected routes for different SNIs on one listener: %v", err)
+	}
+}
+
+func TestMatchesSNI(t *testing.T) {
+	for _, test := range []struct {
+		pattern    string
+		serverName string
+		want       bool
+	}{
+		{pattern: "db.example.com", serverName: "DB.example.com", want: true},
+		{pattern: "db.example.com", serverName: "db.example.org"},
+		{pattern: "*.example.com", serverName: "a.b.example.com", want: true},
+		{pattern: "*.example.com", serverName: "example.com"},
+		{pattern: "*.example.com", serverName: ".example.com"},
+		{pattern: "db.example.com", serverName: ""},
+	} {
+		if got := matchesSNI(test.pattern, test.serverName); got != test.want {
+			t.Errorf("matchesSNI(%q, %q) = %v, want %v", test.pattern, test.serverName, got, test.want)
+		}
+	}
+}
+
+// streamTestCertificate returns a self-signed certificate for names, also as
+// certificate and key PEM.
+func streamTestCertificate(t *testing.T, names ...string) (tls.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	certificate, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		t.Fatalf("load key pair: %v", err)
+	}
+	return certificate, certPEM, keyPEM
+}
+
+// tlsRoundTrip sends the stream test request over TLS with serverName as SNI
+// and returns the 8-byte response.
+func tlsRoundTrip(t *testing.T, address, serverName string) string {
+	t.Helper()
+	client, err := tls.Dial("tcp", address, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("dial TLS runtime with SNI %q: %v", serverName, err)
+	}
+	defer func() { _ = client.Close() }()
+	if _, err := client.Write([]byte("stream-request")); err != nil {
+		t.Fatalf("write TLS request: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, 8)
+	if _, err := io.ReadFull(client, response); err != nil {
+		t.Fatalf("read TLS response with SNI %q: %v", serverName, err)
+	}
+	return string(response)
+}
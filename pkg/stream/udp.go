@@ -0,0 +1,227 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	// maxUDPDatagramSize is the largest UDP payload, so no datagram is
+	// truncated on either side of a session.
+	maxUDPDatagramSize = 64 * 1024
+	// udpSessionQueueSize bounds the datagrams buffered while a session is
+	// being admitted and dialed; later datagrams are dropped, as UDP allows.
+	udpSessionQueueSize = 64
+	// maxUDPSessions bounds the live sessions of one listener; datagrams from
+	// new clients are dropped while it is full.
+	maxUDPSessions = 16384
+)
+
+// udpListener relays datagrams for one UDP stream listener. Every client
+// address and port owns a session with its own connected upstream socket, so
+// replies return to the client that caused them.
+type udpListener struct {
+	conn   net.PacketConn
+	router *Router
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+type udpSession struct {
+	listener *udpListener
+	client   net.Addr
+	queue    chan []byte
+	// lastActive is the UnixNano time of the last datagram in either
+	// direction; the session expires after the route idle timeout.
+	lastActive atomic.Int64
+}
+
+func newUDPListener(ctx context.Context, conn net.PacketConn, router *Router, wg *sync.WaitGroup) *udpListener {
+	listenerCtx, cancel := context.WithCancel(ctx)
+	return &udpListener{
+		conn:     conn,
+		router:   router,
+		ctx:      listenerCtx,
+		cancel:   cancel,
+		wg:       wg,
+		sessions: make(map[string]*udpSession),
+	}
+}
+
+func (l *udpListener) address() string {
+	if l.conn.LocalAddr() == nil {
+		return "<unknown>"
+	}
+	return l.conn.LocalAddr().String()
+}
+
+// close stops reading and ends every session of this listener.
+func (l *udpListener) close() {
+	l.cancel()
+	_ = l.conn.Close()
+}
+
+func (l *udpListener) serve() {
+	buffer := make([]byte, maxUDPDatagramSize)
+	for {
+		n, client, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			logger.Errorf("stream UDP listener %q read failed: %v", l.address(), err)
+			l.close()
+			return
+		}
+		session := l.session(client)
+		if session == nil {
+			continue
+		}
+		session.touch()
+		select {
+		case session.queue <- append([]byte(nil), buffer[:n]...):
+		default:
+		}
+	}
+}
+
+// session returns the live session of client, starting one on its first
+// datagram. It returns nil when the listener is at maxUDPSessions.
+func (l *udpListener) session(client net.Addr) *udpSession {
+	key := client.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if session, ok := l.sessions[key]; ok {
+		return session
+	}
+	if len(l.sessions) >= maxUDPSessions {
+		return nil
+	}
+	session := &udpSession{
+		listener: l,
+		client:   client,
+		queue:    make(chan []byte, udpSessionQueueSize),
+	}
+	l.sessions[key] = session
+	l.wg.Go(session.run)
+	return session
+}
+
+func (l *udpListener) remove(session *udpSession) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sessions[session.client.String()] == session {
+		delete(l.sessions, session.client.String())
+	}
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// run admits the session, then forwards queued client datagrams upstream and
+// upstream replies to the client until the session is idle for the route's
+// timeout. A rejected session keeps draining the client's datagrams until it
+// is idle, so one result is reported per session rather than per datagram.
+func (s *udpSession) run() {
+	l := s.listener
+	defer l.remove(s)
+
+	remote := s.client.String()
+	entry, upstream, release, err := l.router.openUDP(l.ctx, l.address(), remote)
+	defer func() {
+		l.router.emit(Result{RouteID: entry.route.ID, Listener: l.address(), Remote: remote, Protocol: "udp", Err: err})
+	}()
+	idle := entry.streamIdleTimeout()
+	var replyDone chan error
+	if err == nil {
+		defer release()
+		defer upstream.Close()
+		replyDone = make(chan error, 1)
+		l.wg.Go(func() { replyDone <- s.relayReplies(upstream) })
+	}
+
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case datagram := <-s.queue:
+			if upstream == nil {
+				continue
+			}
+			if _, writeErr := upstream.Write(datagram); writeErr != nil {
+				err = fmt.Errorf("write UDP upstream: %w", writeErr)
+				return
+			}
+		case replyErr := <-replyDone:
+			err = replyErr
+			return
+		case <-timer.C:
+			if remaining := idle - time.Since(time.Unix(0, s.lastActive.Load())); remaining > 0 {
+				timer.Reset(remaining)
+				continue
+			}
+			return
+		case <-l.ctx.Done():
+			return
+		}
+	}
+}
+
+// relayReplies copies upstream datagrams to the client until the upstream
+// socket fails or is closed by run.
+func (s *udpSession) relayReplies(upstream net.Conn) error {
+	buffer := make([]byte, maxUDPDatagramSize)
+	for {
+		n, err := upstream.Read(buffer)
+		if err != nil {
+			return fmt.Errorf("read UDP upstream: %w", err)
+		}
+		s.touch()
+		if _, err := s.listener.conn.WriteTo(buffer[:n], s.client); err != nil {
+			return fmt.Errorf("write UDP client: %w", err)
+		}
+	}
+}
+
+// openUDP matches a new UDP session, runs the route's access plugins, and
+// dials its upstream. The release is nil when an error is returned.
+func (r *Router) openUDP(ctx context.Context, listenerAddr, remoteAddr string) (routeEntry, net.Conn, func(), error) {
+	r.mu.RLock()
+	entry, ok := r.matchEntry(listenerAddr, remoteAddr, "")
+	r.mu.RUnlock()
+	if !ok {
+		return routeEntry{}, nil, nil, ErrNoStreamRoute
+	}
+	if entry.mqtt {
+		return entry, nil, nil, fmt.Errorf("stream route %q uses mqtt-proxy and cannot serve UDP", entry.route.ID)
+	}
+	if entry.upstreamTLS != nil {
+		return entry, nil, nil, fmt.Errorf("stream route %q has a tls upstream and cannot serve UDP", entry.route.ID)
+	}
+	release, err := entry.admit(ctx, streamSession{server: listenerAddr, remote: remoteAddr})
+	if err != nil {
+		return entry, nil, nil, err
+	}
+	upstream, err := entry.dialNetwork(ctx, "udp", remoteAddr)
+	if err != nil {
+		release()
+		return entry, nil, nil, err
+	}
+	return entry, upstream, release, nil
+}
@@ -0,0 +1,242 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wklken/apisix-go/pkg/config"
+	"github.com/wklken/apisix-go/pkg/resource"
+)
+
+func TestRuntimeRelaysUDPSessionsPerClientAndExpiresIdleSessions(t *testing.T) {
+	upstreamAddr := startUDPEchoUpstream(t)
+	route := udpTestRoute(t, "dns", upstreamAddr)
+	route.Upstream.Timeout.Read = 1
+
+	results := make(chan Result, 4)
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{Udp: []string{"127.0.0.1:0"}},
+		[]resource.StreamRoute{route},
+		nil,
+		func(result Result) { results <- result },
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	address := udpRuntimeAddress(t, runtime)
+
+	first := dialUDP(t, address)
+	second := dialUDP(t, address)
+	for index, client := range []net.Conn{first, second, first} {
+		request := "query-" + strconv.Itoa(index)
+		if reply := udpRoundTrip(t, client, request); reply != "echo:"+request {
+			t.Fatalf("reply %d = %q, want echo of %q", index, reply, request)
+		}
+	}
+
+	for range 2 {
+		select {
+		case result := <-results:
+			if result.RouteID != "dns" || result.Protocol != "udp" || result.Err != nil {
+				t.Fatalf("session result = %+v, want a clean udp session of route dns", result)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("idle UDP session did not expire")
+		}
+	}
+	if reply := udpRoundTrip(t, first, "after-expiry"); reply != "echo:after-expiry" {
+		t.Fatalf("reply after expiry = %q, want a new session", reply)
+	}
+}
+
+func TestRuntimeUDPAccessPluginRejectsSessionOnce(t *testing.T) {
+	upstreamAddr := startUDPEchoUpstream(t)
+	route := udpTestRoute(t, "restricted", upstreamAddr)
+	route.Upstream.Timeout.Read = 1
+	route.Plugins = map[string]resource.PluginConfig{
+		"ip-restriction": map[string]any{"blacklist": []any{"127.0.0.0/8"}},
+	}
+
+	results := make(chan Result, 4)
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{Udp: []string{"127.0.0.1:0"}},
+		[]resource.StreamRoute{route},
+		[]string{"ip-restriction"},
+		func(result Result) { results <- result },
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+
+	client := dialUDP(t, udpRuntimeAddress(t, runtime))
+	for _, request := range []string{"first", "second"} {
+		if _, err := client.Write([]byte(request)); err != nil {
+			t.Fatalf("write datagram: %v", err)
+		}
+	}
+	_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 64)); err == nil {
+		t.Fatal("rejected client received a reply")
+	}
+
+	select {
+	case result := <-results:
+		if !errors.Is(result.Err, ErrStreamRejected) || result.Protocol != "udp" {
+			t.Fatalf("session result = %+v, want an ip-restriction rejection", result)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("rejected UDP session did not report a result")
+	}
+	select {
+	case result := <-results:
+		t.Fatalf("unexpected second result %+v for one rejected session", result)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRuntimeReconfigureAddsAndRemovesUDPListeners(t *testing.T) {
+	upstreamAddr := startUDPEchoUpstream(t)
+	routes := []resource.StreamRoute{udpTestRoute(t, "udp", upstreamAddr)}
+	runtime, err := NewRuntime(
+		t.Context(),
+		config.StreamProxy{Tcp: []config.TcpListen{{Addr: "127.0.0.1:0"}}},
+		routes,
+		nil,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRuntime() error = %v", err)
+	}
+	t.Cleanup(func() { _ = runtime.Close(context.Background()) })
+	tcpAddress := runtime.Addresses()[0]
+	tcpListen := config.TcpListen{Addr: "127.0.0.1:0"}
+
+	udpAddress := freeUDPAddress(t)
+	if err := runtime.Reconfigure(
+		config.StreamProxy{Tcp: []config.TcpListen{tcpListen}, Udp: []string{udpAddress}},
+		routes,
+		nil,
+	); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if got := runtime.Addresses(); len(got) != 2 || got[0] != tcpAddress || got[1] != "udp://"+udpAddress {
+		t.Fatalf("Addresses() = %v, want the kept TCP and the added UDP listener", got)
+	}
+	client := dialUDP(t, udpAddress)
+	if reply := udpRoundTrip(t, client, "added"); reply != "echo:added" {
+		t.Fatalf("reply = %q, want echo through the added listener", reply)
+	}
+
+	if err := runtime.Reconfigure(
+		config.StreamProxy{Tcp: []config.TcpListen{tcpListen}},
+		routes,
+		nil,
+	); err != nil {
+		t.Fatalf("Reconfigure() removing UDP error = %v", err)
+	}
+	rebound, err := net.ListenPacket("udp", udpAddress)
+	if err != nil {
+		t.Fatalf("removed UDP listener still holds %s: %v", udpAddress, err)
+	}
+	_ = rebound.Close()
+}
+
+func TestRouterOpenUDPRejectsMQTTRoute(t *testing.T) {
+	router, err := NewRouter([]resource.StreamRoute{{
+		ID:      "mqtt",
+		Plugins: map[string]resource.PluginConfig{"mqtt-proxy": map[string]any{"protocol_name": "MQTT", "protocol_level": 4}},
+		Upstream: resource.Upstream{
+			Nodes: []resource.Node{{Host: "127.0.0.1", Port: 1883, Weight: 1}},
+		},
+	}}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewRouter() error = %v", err)
+	}
+	_, _, _, err = router.openUDP(context.Background(), "127.0.0.1:1883", "127.0.0.1:1000")
+	if err == nil || !strings.Contains(err.Error(), "cannot serve UDP") {
+		t.Fatalf("openUDP() error = %v, want mqtt-proxy rejection", err)
+	}
+}
+
+func udpTestRoute(t *testing.T, id, upstreamAddr string) resource.StreamRoute {
+	t.Helper()
+	route := runtimeTestRoute(t, id, upstreamAddr)
+	route.Upstream.Scheme = "udp"
+	return route
+}
+
+func udpRuntimeAddress(t *testing.T, runtime *Runtime) string {
+	t.Helper()
+	for _, address := range runtime.Addresses() {
+		if udpAddress, ok := strings.CutPrefix(address, "udp://"); ok {
+			return udpAddress
+		}
+	}
+	t.Fatalf("Addresses() = %v, want a UDP listener", runtime.Addresses())
+	return ""
+}
+
+// startUDPEchoUpstream answers every datagram with "echo:" and its payload.
+func startUDPEchoUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen UDP upstream: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, peer, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(append([]byte("echo:"), buffer[:n]...), peer)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func freeUDPAddress(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve UDP address: %v", err)
+	}
+	address := conn.LocalAddr().String()
+	_ = conn.Close()
+	return address
+}
+
+func dialUDP(t *testing.T, address string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatalf("dial UDP runtime: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func udpRoundTrip(t *testing.T, client net.Conn, request string) string {
+	t.Helper()
+	if _, err := client.Write([]byte(request)); err != nil {
+		t.Fatalf("write datagram: %v", err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 1024)
+	n, err := client.Read(reply)
+	if err != nil {
+		t.Fatalf("read reply to %q: %v", request, err)
+	}
+	return string(reply[:n])
+}
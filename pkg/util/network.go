@@ -0,0 +1,31 @@
+package util
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseTrustedNetworks converts apisix.trusted_addresses entries, CIDRs or
+// bare IP addresses, into networks. Invalid entries are skipped; the config
+// loader has already rejected them.
+func ParseTrustedNetworks(addresses []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(addresses))
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		if _, network, err := net.ParseCIDR(address); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks
+}
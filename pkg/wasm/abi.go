@@ -0,0 +1,713 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+// Status codes returned by host functions.
+const (
+	statusOK              uint32 = 0
+	statusNotFound        uint32 = 1
+	statusBadArgument     uint32 = 2
+	statusEmpty           uint32 = 7
+	statusCasMismatch     uint32 = 8
+	statusInternalFailure uint32 = 10
+	statusUnimplemented   uint32 = 12
+)
+
+// Header map types.
+const (
+	mapRequestHeaders       uint32 = 0
+	mapRequestTrailers      uint32 = 1
+	mapResponseHeaders      uint32 = 2
+	mapResponseTrailers     uint32 = 3
+	mapHTTPCallRespHeaders  uint32 = 6
+	mapHTTPCallRespTrailers uint32 = 7
+)
+
+// Buffer types.
+const (
+	bufferRequestBody      uint32 = 0
+	bufferResponseBody     uint32 = 1
+	bufferHTTPCallRespBody uint32 = 4
+	bufferVMConfiguration  uint32 = 6
+	bufferPluginConfig     uint32 = 7
+)
+
+// Stream types of proxy_continue_stream and proxy_close_stream.
+const (
+	streamRequest  uint32 = 0
+	streamResponse uint32 = 1
+)
+
+// Metric types of proxy_define_metric.
+const (
+	metricCounter   uint32 = 0
+	metricGauge     uint32 = 1
+	metricHistogram uint32 = 2
+)
+
+// Actions returned by the HTTP callbacks.
+const (
+	actionContinue uint32 = 0
+	actionPause    uint32 = 1
+)
+
+const (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+// hostFunction is one proxy-wasm import served from module env.
+type hostFunction struct {
+	name   string
+	params []api.ValueType
+	fn     func(*instance, []uint64) uint32
+}
+
+func i32s(n int) []api.ValueType {
+	types := make([]api.ValueType, n)
+	for i := range types {
+		types[i] = i32
+	}
+	return types
+}
+
+// hostFunctions is the implemented ABI. Every function returns a status;
+// imports the guest declares beyond this list are stubbed by
+// instantiateHostModule and answer statusUnimplemented.
+var hostFunctions = []hostFunction{
+	{name: "proxy_log", params: i32s(3), fn: (*instance).log},
+	{name: "proxy_get_log_level", params: i32s(1), fn: (*instance).getLogLevel},
+	{name: "proxy_get_current_time_nanoseconds", params: i32s(1), fn: (*instance).getCurrentTime},
+	{name: "proxy_set_tick_period_milliseconds", params: i32s(1), fn: unimplemented},
+	{name: "proxy_get_property", params: i32s(4), fn: (*instance).getProperty},
+	{name: "proxy_set_property", params: i32s(4), fn: (*instance).setProperty},
+	{name: "proxy_get_buffer_bytes", params: i32s(5), fn: (*instance).getBufferBytes},
+	{name: "proxy_set_buffer_bytes", params: i32s(5), fn: (*instance).setBufferBytes},
+	{name: "proxy_get_buffer_status", params: i32s(3), fn: (*instance).getBufferStatus},
+	{name: "proxy_get_header_map_pairs", params: i32s(3), fn: (*instance).getHeaderMapPairs},
+	{name: "proxy_set_header_map_pairs", params: i32s(3), fn: (*instance).setHeaderMapPairs},
+	{name: "proxy_get_header_map_size", params: i32s(2), fn: (*instance).getHeaderMapSize},
+	{name: "proxy_get_header_map_value", params: i32s(5), fn: (*instance).getHeaderMapValue},
+	{name: "proxy_add_header_map_value", params: i32s(5), fn: (*instance).addHeaderMapValue},
+	{name: "proxy_replace_header_map_value", params: i32s(5), fn: (*instance).replaceHeaderMapValue},
+	{name: "proxy_remove_header_map_value", params: i32s(3), fn: (*instance).removeHeaderMapValue},
+	{name: "proxy_send_local_response", params: i32s(8), fn: (*instance).sendLocalResponse},
+	{name: "proxy_continue_stream", params: i32s(1), fn: (*instance).continueStream},
+	{name: "proxy_close_stream", params: i32s(1), fn: (*instance).continueStream},
+	{name: "proxy_continue_request", fn: (*instance).continueRequest},
+	{name: "proxy_continue_response", fn: (*instance).continueRequest},
+	{name: "proxy_http_call", params: i32s(10), fn: (*instance).httpCall},
+	{name: "proxy_set_effective_context", params: i32s(1), fn: (*instance).setEffectiveContext},
+	{name: "proxy_done", fn: func(*instance, []uint64) uint32 { return statusOK }},
+	{name: "proxy_get_shared_data", params: i32s(5), fn: (*instance).getSharedData},
+	{name: "proxy_set_shared_data", params: i32s(5), fn: (*instance).setSharedData},
+	{name: "proxy_define_metric", params: i32s(4), fn: (*instance).defineMetric},
+	{name: "proxy_increment_metric", params: []api.ValueType{i32, i64}, fn: (*instance).incrementMetric},
+	{name: "proxy_record_metric", params: []api.ValueType{i32, i64}, fn: (*instance).recordMetric},
+	{name: "proxy_get_metric", params: i32s(2), fn: (*instance).getMetric},
+}
+
+func unimplemented(*instance, []uint64) uint32 {
+	return statusUnimplemented
+}
+
+type instanceKey struct{}
+
+// instantiateHostModule instantiates env with the ABI, adding a stub for
+// every other env import of compiled so the guest links.
+func instantiateHostModule(ctx context.Context, runtime wazero.Runtime, compiled wazero.CompiledModule) error {
+	builder := runtime.NewHostModuleBuilder("env")
+	implemented := make(map[string]bool, len(hostFunctions))
+	for _, host := range hostFunctions {
+		implemented[host.name] = true
+		builder.NewFunctionBuilder().
+			WithGoModuleFunction(hostCall(host.fn), host.params, []api.ValueType{i32}).
+			Export(host.name)
+	}
+	for _, imported := range compiled.ImportedFunctions() {
+		module, name, _ := imported.Import()
+		if module != "env" || implemented[name] {
+			continue
+		}
+		implemented[name] = true
+		results := imported.ResultTypes()
+		builder.NewFunctionBuilder().
+			WithGoModuleFunction(api.GoModuleFunc(func(_ context.Context, _ api.Module, stack []uint64) {
+				if len(results) > 0 {
+					stack[0] = uint64(statusUnimplemented)
+				}
+			}), imported.ParamTypes(), results).
+			Export(name)
+	}
+	_, err := builder.Instantiate(ctx)
+	return err
+}
+
+func hostCall(fn func(*instance, []uint64) uint32) api.GoModuleFunction {
+	return api.GoModuleFunc(func(ctx context.Context, _ api.Module, stack []uint64) {
+		inst, _ := ctx.Value(instanceKey{}).(*instance)
+		if inst == nil {
+			stack[0] = uint64(statusInternalFailure)
+			return
+		}
+		stack[0] = uint64(fn(inst, stack))
+	})
+}
+
+// read copies size bytes at ptr out of guest memory.
+func (inst *instance) read(ptr, size uint32) ([]byte, bool) {
+	if size == 0 {
+		return nil, true
+	}
+	data, ok := inst.module.Memory().Read(ptr, size)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), data...), true
+}
+
+func (inst *instance) readString(ptr, size uint32) (string, bool) {
+	data, ok := inst.read(ptr, size)
+	return string(data), ok
+}
+
+// writeReturn copies data into guest-allocated memory and stores its
+// address and size at the two return pointers.
+func (inst *instance) writeReturn(data []byte, retPtr, retSize uint32) uint32 {
+	var ptr uint32
+	if len(data) > 0 {
+		var err error
+		if ptr, err = inst.allocate(uint32(len(data))); err != nil {
+			logger.Errorf("wasm %s: allocate %d bytes: %s", inst.owner.name, len(data), err)
+			return statusInternalFailure
+		}
+		if !inst.module.Memory().Write(ptr, data) {
+			return statusBadArgument
+		}
+	}
+	if !inst.module.Memory().WriteUint32Le(retPtr, ptr) || !inst.module.Memory().WriteUint32Le(retSize, uint32(len(data))) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) log(stack []uint64) uint32 {
+	message, ok := inst.readString(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		return statusBadArgument
+	}
+	switch level := api.DecodeU32(stack[0]); {
+	case level <= 1:
+		logger.Debugf("wasm %s: %s", inst.owner.name, message)
+	case level == 2:
+		logger.Infof("wasm %s: %s", inst.owner.name, message)
+	case level == 3:
+		logger.Warnf("wasm %s: %s", inst.owner.name, message)
+	default:
+		logger.Errorf("wasm %s: %s", inst.owner.name, message)
+	}
+	return statusOK
+}
+
+func (inst *instance) getLogLevel(stack []uint64) uint32 {
+	level := uint32(2)
+	if logger.DebugEnabled() {
+		level = 1
+	}
+	if !inst.module.Memory().WriteUint32Le(api.DecodeU32(stack[0]), level) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) getCurrentTime(stack []uint64) uint32 {
+	if !inst.module.Memory().WriteUint64Le(api.DecodeU32(stack[0]), uint64(time.Now().UnixNano())) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+// getProperty serves properties set by the guest on the current HTTP
+// context, then the context's PropertyFunc. A path is NUL-separated.
+func (inst *instance) getProperty(stack []uint64) uint32 {
+	raw, ok := inst.read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		return statusBadArgument
+	}
+	path := splitPropertyPath(raw)
+	var value []byte
+	var found bool
+	if c := inst.current; c != nil {
+		value, found = c.property(path)
+	} else {
+		value, found = inst.owner.rootProperty(path)
+	}
+	if !found {
+		return statusNotFound
+	}
+	return inst.writeReturn(value, api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+}
+
+func (inst *instance) setProperty(stack []uint64) uint32 {
+	raw, ok := inst.read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		return statusBadArgument
+	}
+	value, ok := inst.read(api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+	if !ok {
+		return statusBadArgument
+	}
+	c := inst.current
+	if c == nil {
+		return statusNotFound
+	}
+	c.properties[strings.Join(splitPropertyPath(raw), ".")] = value
+	return statusOK
+}
+
+func splitPropertyPath(raw []byte) []string {
+	return strings.Split(strings.TrimRight(string(raw), "\x00"), "\x00")
+}
+
+// buffer returns the buffer of the given type, or nil with false when the
+// current callback has none.
+func (inst *instance) buffer(kind uint32) (*[]byte, bool) {
+	switch kind {
+	case bufferVMConfiguration:
+		empty := []byte(nil)
+		return &empty, true
+	case bufferPluginConfig:
+		if inst.configuration == nil {
+			return nil, false
+		}
+		return &inst.configuration, true
+	}
+	c := inst.current
+	if c == nil {
+		return nil, false
+	}
+	switch kind {
+	case bufferRequestBody:
+		return &c.requestBody, c.requestBody != nil
+	case bufferResponseBody:
+		return &c.responseBody, c.responseBody != nil
+	case bufferHTTPCallRespBody:
+		if c.callResponse == nil {
+			return nil, false
+		}
+		return &c.callResponse.body, true
+	}
+	return nil, false
+}
+
+func (inst *instance) getBufferBytes(stack []uint64) uint32 {
+	buffer, ok := inst.buffer(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	start, maxSize := api.DecodeU32(stack[1]), api.DecodeU32(stack[2])
+	data := *buffer
+	if int(start) > len(data) {
+		return statusBadArgument
+	}
+	data = data[start:]
+	if uint32(len(data)) > maxSize {
+		data = data[:maxSize]
+	}
+	if len(data) == 0 {
+		return statusEmpty
+	}
+	return inst.writeReturn(data, api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+}
+
+// setBufferBytes replaces size bytes at start; start 0 with size at least
+// the buffer length replaces the whole buffer.
+func (inst *instance) setBufferBytes(stack []uint64) uint32 {
+	kind := api.DecodeU32(stack[0])
+	if kind != bufferRequestBody && kind != bufferResponseBody {
+		return statusBadArgument
+	}
+	buffer, ok := inst.buffer(kind)
+	if !ok && inst.current == nil {
+		return statusNotFound
+	}
+	value, ok := inst.read(api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+	if !ok {
+		return statusBadArgument
+	}
+	start, size := int(api.DecodeU32(stack[1])), int(api.DecodeU32(stack[2]))
+	data := *buffer
+	if start > len(data) {
+		return statusBadArgument
+	}
+	end := min(start+size, len(data))
+	replaced := make([]byte, 0, len(data)-(end-start)+len(value))
+	replaced = append(replaced, data[:start]...)
+	replaced = append(replaced, value...)
+	replaced = append(replaced, data[end:]...)
+	*buffer = replaced
+	inst.current.bodyChanged[kind] = true
+	return statusOK
+}
+
+func (inst *instance) getBufferStatus(stack []uint64) uint32 {
+	buffer, ok := inst.buffer(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	memory := inst.module.Memory()
+	if !memory.WriteUint32Le(api.DecodeU32(stack[1]), uint32(len(*buffer))) ||
+		!memory.WriteUint32Le(api.DecodeU32(stack[2]), 0) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) headerMap(kind uint32) (*headerMap, bool) {
+	c := inst.current
+	if c == nil {
+		return nil, false
+	}
+	switch kind {
+	case mapRequestHeaders:
+		return c.requestHeaders, c.requestHeaders != nil
+	case mapResponseHeaders:
+		return c.responseHeaders, c.responseHeaders != nil
+	case mapHTTPCallRespHeaders:
+		if c.callResponse == nil {
+			return nil, false
+		}
+		return c.callResponse.headers, true
+	case mapRequestTrailers, mapResponseTrailers, mapHTTPCallRespTrailers:
+		return &headerMap{}, true
+	}
+	return nil, false
+}
+
+func (inst *instance) getHeaderMapPairs(stack []uint64) uint32 {
+	headers, ok := inst.headerMap(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	return inst.writeReturn(headers.serialize(), api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+}
+
+func (inst *instance) setHeaderMapPairs(stack []uint64) uint32 {
+	headers, ok := inst.headerMap(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	data, ok := inst.read(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		return statusBadArgument
+	}
+	pairs, ok := deserializePairs(data)
+	if !ok {
+		return statusBadArgument
+	}
+	headers.pairs = pairs
+	return statusOK
+}
+
+func (inst *instance) getHeaderMapSize(stack []uint64) uint32 {
+	headers, ok := inst.headerMap(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	if !inst.module.Memory().WriteUint32Le(api.DecodeU32(stack[1]), uint32(len(headers.serialize()))) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) getHeaderMapValue(stack []uint64) uint32 {
+	headers, ok := inst.headerMap(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	key, ok := inst.readString(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		return statusBadArgument
+	}
+	value, found := headers.get(key)
+	if !found {
+		return statusNotFound
+	}
+	return inst.writeReturn([]byte(value), api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+}
+
+// editHeaderMap reads the key and value arguments and applies edit.
+func (inst *instance) editHeaderMap(stack []uint64, edit func(h *headerMap, key, value string)) uint32 {
+	headers, ok := inst.headerMap(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	key, ok := inst.readString(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		return statusBadArgument
+	}
+	var value string
+	if len(stack) >= 5 {
+		if value, ok = inst.readString(api.DecodeU32(stack[3]), api.DecodeU32(stack[4])); !ok {
+			return statusBadArgument
+		}
+	}
+	edit(headers, key, value)
+	return statusOK
+}
+
+func (inst *instance) addHeaderMapValue(stack []uint64) uint32 {
+	return inst.editHeaderMap(stack, (*headerMap).add)
+}
+
+func (inst *instance) replaceHeaderMapValue(stack []uint64) uint32 {
+	return inst.editHeaderMap(stack, (*headerMap).replace)
+}
+
+func (inst *instance) removeHeaderMapValue(stack []uint64) uint32 {
+	return inst.editHeaderMap(stack[:3], func(h *headerMap, key, _ string) { h.remove(key) })
+}
+
+func (inst *instance) sendLocalResponse(stack []uint64) uint32 {
+	c := inst.current
+	if c == nil {
+		return statusNotFound
+	}
+	body, ok := inst.read(api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+	if !ok {
+		return statusBadArgument
+	}
+	data, ok := inst.read(api.DecodeU32(stack[5]), api.DecodeU32(stack[6]))
+	if !ok {
+		return statusBadArgument
+	}
+	pairs, ok := deserializePairs(data)
+	if !ok {
+		return statusBadArgument
+	}
+	header := make(http.Header, len(pairs))
+	for _, pair := range pairs {
+		header.Add(pair[0], pair[1])
+	}
+	c.local = &LocalResponse{Status: int(api.DecodeU32(stack[0])), Header: header, Body: body}
+	return statusOK
+}
+
+func (inst *instance) continueStream(stack []uint64) uint32 {
+	switch api.DecodeU32(stack[0]) {
+	case streamRequest, streamResponse:
+		return statusOK
+	}
+	return statusBadArgument
+}
+
+func (inst *instance) continueRequest([]uint64) uint32 {
+	return statusOK
+}
+
+func (inst *instance) setEffectiveContext(stack []uint64) uint32 {
+	id := api.DecodeU32(stack[0])
+	if c := inst.current; c != nil && (id == c.id || id == c.rootID) {
+		return statusOK
+	}
+	if id == inst.vmRootID || inst.isPluginRoot(id) {
+		return statusOK
+	}
+	return statusBadArgument
+}
+
+func (inst *instance) getSharedData(stack []uint64) uint32 {
+	key, ok := inst.readString(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		return statusBadArgument
+	}
+	value, cas, found := inst.owner.sharedData(key)
+	if !found {
+		return statusNotFound
+	}
+	if status := inst.writeReturn(value, api.DecodeU32(stack[2]), api.DecodeU32(stack[3])); status != statusOK {
+		return status
+	}
+	if !inst.module.Memory().WriteUint32Le(api.DecodeU32(stack[4]), cas) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) setSharedData(stack []uint64) uint32 {
+	key, ok := inst.readString(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		return statusBadArgument
+	}
+	value, ok := inst.read(api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+	if !ok {
+		return statusBadArgument
+	}
+	if !inst.owner.setSharedData(key, value, api.DecodeU32(stack[4])) {
+		return statusCasMismatch
+	}
+	return statusOK
+}
+
+func (inst *instance) defineMetric(stack []uint64) uint32 {
+	kind := api.DecodeU32(stack[0])
+	if kind != metricCounter && kind != metricGauge && kind != metricHistogram {
+		return statusBadArgument
+	}
+	name, ok := inst.readString(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		return statusBadArgument
+	}
+	if !inst.module.Memory().WriteUint32Le(api.DecodeU32(stack[3]), inst.owner.defineMetric(kind, name)) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (inst *instance) incrementMetric(stack []uint64) uint32 {
+	if !inst.owner.updateMetric(api.DecodeU32(stack[0]), func(kind uint32, value uint64) (uint64, bool) {
+		return value + stack[1], kind != metricHistogram
+	}) {
+		return statusNotFound
+	}
+	return statusOK
+}
+
+func (inst *instance) recordMetric(stack []uint64) uint32 {
+	if !inst.owner.updateMetric(api.DecodeU32(stack[0]), func(kind uint32, _ uint64) (uint64, bool) {
+		return stack[1], kind != metricCounter
+	}) {
+		return statusNotFound
+	}
+	return statusOK
+}
+
+func (inst *instance) getMetric(stack []uint64) uint32 {
+	value, ok := inst.owner.metric(api.DecodeU32(stack[0]))
+	if !ok {
+		return statusNotFound
+	}
+	if !inst.module.Memory().WriteUint64Le(api.DecodeU32(stack[1]), value) {
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+// headerMap is an ordered header list with lowercase names, as the ABI
+// exposes HTTP/2-style maps including pseudo-headers.
+type headerMap struct {
+	pairs [][2]string
+}
+
+func newHeaderMap(pseudo [][2]string, header http.Header) *headerMap {
+	pairs := append([][2]string(nil), pseudo...)
+	for name, values := range header {
+		for _, value := range values {
+			pairs = append(pairs, [2]string{strings.ToLower(name), value})
+		}
+	}
+	return &headerMap{pairs: pairs}
+}
+
+func (h *headerMap) get(key string) (string, bool) {
+	var values []string
+	for _, pair := range h.pairs {
+		if strings.EqualFold(pair[0], key) {
+			values = append(values, pair[1])
+		}
+	}
+	return strings.Join(values, ","), values != nil
+}
+
+func (h *headerMap) add(key, value string) {
+	h.pairs = append(h.pairs, [2]string{strings.ToLower(key), value})
+}
+
+func (h *headerMap) replace(key, value string) {
+	h.remove(key)
+	h.add(key, value)
+}
+
+func (h *headerMap) remove(key string) {
+	kept := h.pairs[:0]
+	for _, pair := range h.pairs {
+		if !strings.EqualFold(pair[0], key) {
+			kept = append(kept, pair)
+		}
+	}
+	h.pairs = kept
+}
+
+// split returns the pseudo-headers by name and the remaining fields.
+func (h *headerMap) split() (map[string]string, http.Header) {
+	pseudo := make(map[string]string)
+	header := make(http.Header, len(h.pairs))
+	for _, pair := range h.pairs {
+		if strings.HasPrefix(pair[0], ":") {
+			pseudo[pair[0]] = pair[1]
+			continue
+		}
+		header.Add(pair[0], pair[1])
+	}
+	return pseudo, header
+}
+
+// serialize encodes the ABI map layout: a pair count, the key and value
+// sizes of every pair, then each key and value followed by a NUL.
+func (h *headerMap) serialize() []byte {
+	size := 4
+	for _, pair := range h.pairs {
+		size += 8 + len(pair[0]) + len(pair[1]) + 2
+	}
+	data := make([]byte, 4, size)
+	binary.LittleEndian.PutUint32(data, uint32(len(h.pairs)))
+	for _, pair := range h.pairs {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[0])))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[1])))
+	}
+	for _, pair := range h.pairs {
+		data = append(data, pair[0]...)
+		data = append(data, 0)
+		data = append(data, pair[1]...)
+		data = append(data, 0)
+	}
+	return data
+}
+
+func deserializePairs(data []byte) ([][2]string, bool) {
+	if len(data) == 0 {
+		return nil, true
+	}
+	if len(data) < 4 {
+		return nil, false
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	if count > (len(data)-4)/8 {
+		return nil, false
+	}
+	sizes, offset := 4, 4+8*count
+	pairs := make([][2]string, count)
+	for i := range pairs {
+		for j := range 2 {
+			size := int(binary.LittleEndian.Uint32(data[sizes:]))
+			sizes += 4
+			if offset+size+1 > len(data) {
+				return nil, false
+			}
+			pairs[i][j] = string(data[offset : offset+size])
+			offset += size + 1
+		}
+	}
+	return pairs, true
+}
@@ -0,0 +1,361 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+// maxHTTPCallResponseBytes bounds the body of a proxy_http_call response
+// handed to the guest.
+const maxHTTPCallResponseBytes = 4 << 20
+
+// PropertyFunc answers proxy_get_property for the properties the guest has
+// not set itself. path holds the NUL-separated segments of the property.
+type PropertyFunc func(path []string) ([]byte, bool)
+
+// LocalResponse is the response a filter sent with
+// proxy_send_local_response.
+type LocalResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// HTTPContext is the Proxy-Wasm stream context of one request. It holds an
+// instance of the Module until Close.
+type HTTPContext struct {
+	mu     sync.Mutex
+	inst   *instance
+	id     uint32
+	rootID uint32
+	lookup PropertyFunc
+	closed bool
+
+	properties      map[string][]byte
+	requestHeaders  *headerMap
+	requestBody     []byte
+	responseHeaders *headerMap
+	responseBody    []byte
+	bodyChanged     map[uint32]bool
+	local           *LocalResponse
+	pending         []*httpCall
+	callResponse    *httpCallResponse
+}
+
+type httpCall struct {
+	token    uint32
+	done     chan struct{}
+	response *httpCallResponse
+}
+
+type httpCallResponse struct {
+	headers *headerMap
+	body    []byte
+}
+
+// NewHTTPContext creates a stream context under the root context configured
+// with conf, on an instance taken from the pool.
+func (m *Module) NewHTTPContext(ctx context.Context, conf []byte, lookup PropertyFunc) (*HTTPContext, error) {
+	inst, err := m.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rootID, err := inst.pluginRoot(conf)
+	if err != nil {
+		m.release(inst)
+		return nil, err
+	}
+	c := &HTTPContext{
+		inst:        inst,
+		id:          inst.newID(),
+		rootID:      rootID,
+		lookup:      lookup,
+		properties:  map[string][]byte{},
+		bodyChanged: map[uint32]bool{},
+	}
+	if _, _, err := inst.call("proxy_on_context_create", uint64(c.id), uint64(rootID)); err != nil {
+		m.release(inst)
+		return nil, err
+	}
+	return c, nil
+}
+
+// OnRequestHeaders runs proxy_on_request_headers and writes the header
+// changes, including :path, :method, and :authority, back to r.
+func (c *HTTPContext) OnRequestHeaders(r *http.Request) (*LocalResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	c.requestHeaders = newHeaderMap(requestPseudoHeaders(r), r.Header)
+	endOfStream := r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0
+	if err := c.invoke("proxy_on_request_headers", uint64(c.id), uint64(len(c.requestHeaders.pairs)), boolParam(endOfStream)); err != nil {
+		return nil, err
+	}
+	pseudo, header := c.requestHeaders.split()
+	r.Header = header
+	if method := pseudo[":method"]; method != "" {
+		r.Method = method
+	}
+	if authority := pseudo[":authority"]; authority != "" {
+		r.Host = authority
+	}
+	if path := pseudo[":path"]; path != "" && path != r.URL.RequestURI() {
+		r.URL.Path, r.URL.RawQuery, _ = strings.Cut(path, "?")
+		r.URL.RawPath = ""
+		r.RequestURI = path
+	}
+	return c.takeLocal(), nil
+}
+
+// OnRequestBody runs proxy_on_request_body with the whole body and returns
+// the body as the filter left it; changed reports a replacement.
+func (c *HTTPContext) OnRequestBody(body []byte) (_ []byte, changed bool, _ *LocalResponse, _ error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, false, nil, ErrClosed
+	}
+	c.requestBody = nonNil(body)
+	if err := c.invoke("proxy_on_request_body", uint64(c.id), uint64(len(body)), 1); err != nil {
+		return nil, false, nil, err
+	}
+	return c.requestBody, c.bodyChanged[bufferRequestBody], c.takeLocal(), nil
+}
+
+// OnResponseHeaders runs proxy_on_response_headers and returns the status
+// and headers as the filter left them.
+func (c *HTTPContext) OnResponseHeaders(status int, header http.Header) (int, http.Header, *LocalResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, nil, nil, ErrClosed
+	}
+	c.responseHeaders = newHeaderMap([][2]string{{":status", strconv.Itoa(status)}}, header)
+	if err := c.invoke("proxy_on_response_headers", uint64(c.id), uint64(len(c.responseHeaders.pairs)), 0); err != nil {
+		return 0, nil, nil, err
+	}
+	pseudo, header := c.responseHeaders.split()
+	if code, err := strconv.Atoi(pseudo[":status"]); err == nil && code >= 100 && code <= 999 {
+		status = code
+	}
+	return status, header, c.takeLocal(), nil
+}
+
+// OnResponseBody runs proxy_on_response_body with the whole body; see
+// OnRequestBody.
+func (c *HTTPContext) OnResponseBody(body []byte) (_ []byte, changed bool, _ *LocalResponse, _ error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, false, nil, ErrClosed
+	}
+	c.responseBody = nonNil(body)
+	if err := c.invoke("proxy_on_response_body", uint64(c.id), uint64(len(body)), 1); err != nil {
+		return nil, false, nil, err
+	}
+	return c.responseBody, c.bodyChanged[bufferResponseBody], c.takeLocal(), nil
+}
+
+// Close runs proxy_on_done, proxy_on_log, and proxy_on_delete and returns
+// the instance to the pool. Later callbacks return ErrClosed.
+func (c *HTTPContext) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	inst := c.inst
+	inst.current = c
+	for _, name := range []string{"proxy_on_done", "proxy_on_log", "proxy_on_delete"} {
+		if _, _, err := inst.call(name, uint64(c.id)); err != nil {
+			break
+		}
+	}
+	inst.owner.release(inst)
+}
+
+// invoke runs one callback with c as the current context, then delivers
+// every proxy_http_call response to proxy_on_http_call_response, in
+// dispatch order, before returning. A paused stream resumes once no call is
+// outstanding, so a Pause without a call continues at once.
+func (c *HTTPContext) invoke(name string, params ...uint64) error {
+	inst := c.inst
+	inst.current = c
+	defer func() { inst.current = nil }()
+	if _, _, err := inst.call(name, params...); err != nil {
+		return err
+	}
+	for len(c.pending) > 0 {
+		call := c.pending[0]
+		c.pending = c.pending[1:]
+		<-call.done
+		c.callResponse = call.response
+		if c.callResponse == nil {
+			c.callResponse = &httpCallResponse{headers: &headerMap{}}
+		}
+		_, _, err := inst.call("proxy_on_http_call_response", uint64(c.rootID), uint64(call.token),
+			uint64(len(c.callResponse.headers.pairs)), uint64(len(c.callResponse.body)), 0)
+		c.callResponse = nil
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *HTTPContext) takeLocal() *LocalResponse {
+	local := c.local
+	c.local = nil
+	return local
+}
+
+func (c *HTTPContext) property(path []string) ([]byte, bool) {
+	if value, ok := c.properties[strings.Join(path, ".")]; ok {
+		return value, true
+	}
+	if value, ok := c.inst.owner.rootProperty(path); ok {
+		return value, true
+	}
+	if c.lookup == nil {
+		return nil, false
+	}
+	return c.lookup(path)
+}
+
+func requestPseudoHeaders(r *http.Request) [][2]string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return [][2]string{
+		{":method", r.Method},
+		{":path", r.URL.RequestURI()},
+		{":authority", r.Host},
+		{":scheme", scheme},
+	}
+}
+
+func boolParam(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func nonNil(body []byte) []byte {
+	if body == nil {
+		return []byte{}
+	}
+	return body
+}
+
+// httpCall dispatches proxy_http_call. The request runs in the background
+// and its response is delivered by invoke once the current callback returns.
+func (inst *instance) httpCall(stack []uint64) uint32 {
+	c := inst.current
+	if c == nil {
+		return statusUnimplemented
+	}
+	upstream, ok := inst.readString(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok || upstream == "" {
+		return statusBadArgument
+	}
+	data, ok := inst.read(api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+	if !ok {
+		return statusBadArgument
+	}
+	pairs, ok := deserializePairs(data)
+	if !ok {
+		return statusBadArgument
+	}
+	body, ok := inst.read(api.DecodeU32(stack[4]), api.DecodeU32(stack[5]))
+	if !ok {
+		return statusBadArgument
+	}
+	pseudo, header := (&headerMap{pairs: pairs}).split()
+	req, err := newHTTPCallRequest(upstream, pseudo, header, body)
+	if err != nil {
+		return statusBadArgument
+	}
+	inst.lastToken++
+	call := &httpCall{token: inst.lastToken, done: make(chan struct{})}
+	if !inst.module.Memory().WriteUint32Le(api.DecodeU32(stack[9]), call.token) {
+		return statusBadArgument
+	}
+	timeout := time.Duration(api.DecodeU32(stack[8])) * time.Millisecond
+	go func() {
+		defer close(call.done)
+		response, err := inst.owner.doHTTPCall(req, timeout)
+		if err != nil {
+			logger.Warnf("wasm %s: http call to %s failed: %v", inst.owner.name, upstream, err)
+			return
+		}
+		call.response = response
+	}()
+	c.pending = append(c.pending, call)
+	return statusOK
+}
+
+// newHTTPCallRequest builds the request of proxy_http_call. upstream is a
+// host:port, or a URL whose scheme overrides :scheme.
+func newHTTPCallRequest(upstream string, pseudo map[string]string, header http.Header, body []byte) (*http.Request, error) {
+	method, path, authority := pseudo[":method"], pseudo[":path"], pseudo[":authority"]
+	if method == "" || path == "" || authority == "" {
+		return nil, errors.New("http call needs :method, :path, and :authority")
+	}
+	base := upstream
+	if !strings.Contains(upstream, "://") {
+		scheme := pseudo[":scheme"]
+		if scheme == "" {
+			scheme = "http"
+		}
+		base = scheme + "://" + upstream
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(base, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Host = authority
+	return req, nil
+}
+
+func (m *Module) doHTTPCall(req *http.Request, timeout time.Duration) (*httpCallResponse, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPCallResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxHTTPCallResponseBytes {
+		return nil, fmt.Errorf("response body exceeds %d bytes", maxHTTPCallResponseBytes)
+	}
+	return &httpCallResponse{
+		headers: newHeaderMap([][2]string{{":status", strconv.Itoa(resp.StatusCode)}}, resp.Header),
+		body:    body,
+	}, nil
+}
@@ -0,0 +1,392 @@
+// Package wasm runs Proxy-Wasm filters on the wazero runtime.
+//
+// A Module is one compiled filter with a pool of instances. Every HTTP
+// context holds an instance from the first callback of a request to its
+// Close, so concurrent requests run in separate instances instead of
+// waiting for one VM. Each instance configures its own plugin root context
+// per distinct plugin configuration; shared data and metrics live on the
+// Module so every instance sees the same values, as in a single VM.
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/wklken/apisix-go/pkg/logger"
+)
+
+const (
+	// maxIdleInstances bounds the instances kept between requests; busier
+	// moments instantiate more and close the surplus on release.
+	maxIdleInstances = 32
+	// maxPluginContexts retires an instance once it has configured this many
+	// plugin configurations, which bounds the root contexts that route
+	// reloads leave behind.
+	maxPluginContexts = 256
+)
+
+// ErrClosed is returned once the Module is closed.
+var ErrClosed = errors.New("wasm module is closed")
+
+// Module is a compiled Proxy-Wasm filter and its instance pool.
+type Module struct {
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	config   wazero.ModuleConfig
+	client   *http.Client
+
+	mu     sync.Mutex
+	idle   []*instance
+	closed bool
+
+	dataMu    sync.Mutex
+	shared    map[string]sharedValue
+	metrics   []metricValue
+	metricIDs map[string]uint32
+}
+
+type sharedValue struct {
+	value []byte
+	cas   uint32
+}
+
+type metricValue struct {
+	kind  uint32
+	value uint64
+}
+
+// Load reads and compiles the filter in file. It starts one instance so a
+// filter that fails proxy_on_vm_start is rejected at load time.
+func Load(ctx context.Context, name, file string) (*Module, error) {
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(ctx, name, code)
+}
+
+// Compile compiles the filter in code; see Load.
+func Compile(ctx context.Context, name string, code []byte) (*Module, error) {
+	runtime := wazero.NewRuntime(ctx)
+	module, err := compile(ctx, runtime, name, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	return module, nil
+}
+
+func compile(ctx context.Context, runtime wazero.Runtime, name string, code []byte) (*Module, error) {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, fmt.Errorf("instantiate WASI: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("compile: %w", err)
+	}
+	exports := compiled.ExportedFunctions()
+	if !exportsABIVersion(exports) {
+		return nil, errors.New("module does not export a proxy_abi_version function")
+	}
+	if err := instantiateHostModule(ctx, runtime, compiled); err != nil {
+		return nil, fmt.Errorf("instantiate host functions: %w", err)
+	}
+	output := &logWriter{name: name}
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions(startFunctions(exports)...).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader).
+		WithStdout(output).
+		WithStderr(output)
+	m := &Module{
+		name:      name,
+		runtime:   runtime,
+		compiled:  compiled,
+		config:    config,
+		client:    &http.Client{},
+		shared:    map[string]sharedValue{},
+		metricIDs: map[string]uint32{},
+	}
+	inst, err := m.newInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.idle = append(m.idle, inst)
+	return m, nil
+}
+
+func exportsABIVersion(exports map[string]api.FunctionDefinition) bool {
+	for name := range exports {
+		if strings.HasPrefix(name, "proxy_abi_version_") {
+			return true
+		}
+	}
+	return false
+}
+
+// startFunctions prefers the reactor entry point _initialize over _start.
+func startFunctions(exports map[string]api.FunctionDefinition) []string {
+	for _, name := range []string{"_initialize", "_start"} {
+		if _, ok := exports[name]; ok {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// Name returns the name the Module was loaded with.
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Exports reports whether the filter exports the named callback.
+func (m *Module) Exports(name string) bool {
+	_, ok := m.compiled.ExportedFunctions()[name]
+	return ok
+}
+
+// Close closes every instance, including ones still held by an HTTP context.
+func (m *Module) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.idle = nil
+	m.mu.Unlock()
+	return m.runtime.Close(ctx)
+}
+
+func (m *Module) acquire(ctx context.Context) (*instance, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(m.idle); n > 0 {
+		inst := m.idle[n-1]
+		m.idle = m.idle[:n-1]
+		m.mu.Unlock()
+		return inst, nil
+	}
+	m.mu.Unlock()
+	return m.newInstance(ctx)
+}
+
+func (m *Module) release(inst *instance) {
+	inst.current = nil
+	m.mu.Lock()
+	if !m.closed && !inst.broken && len(inst.roots) < maxPluginContexts && len(m.idle) < maxIdleInstances {
+		m.idle = append(m.idle, inst)
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Unlock()
+	_ = inst.module.Close(inst.ctx)
+}
+
+func (m *Module) rootProperty(path []string) ([]byte, bool) {
+	if strings.Join(path, ".") == "plugin_name" {
+		return []byte(m.name), true
+	}
+	return nil, false
+}
+
+func (m *Module) sharedData(key string) ([]byte, uint32, bool) {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	shared, ok := m.shared[key]
+	return shared.value, shared.cas, ok
+}
+
+// setSharedData stores value unless cas is nonzero and differs from the
+// stored one.
+func (m *Module) setSharedData(key string, value []byte, cas uint32) bool {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	shared := m.shared[key]
+	if cas != 0 && cas != shared.cas {
+		return false
+	}
+	m.shared[key] = sharedValue{value: value, cas: shared.cas + 1}
+	return true
+}
+
+func (m *Module) defineMetric(kind uint32, name string) uint32 {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	if id, ok := m.metricIDs[name]; ok {
+		return id
+	}
+	m.metrics = append(m.metrics, metricValue{kind: kind})
+	id := uint32(len(m.metrics))
+	m.metricIDs[name] = id
+	return id
+}
+
+// updateMetric applies update to the metric; update returns false when the
+// operation does not apply to the metric kind.
+func (m *Module) updateMetric(id uint32, update func(kind uint32, value uint64) (uint64, bool)) bool {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	if id == 0 || int(id) > len(m.metrics) {
+		return false
+	}
+	metric := &m.metrics[id-1]
+	value, ok := update(metric.kind, metric.value)
+	if ok {
+		metric.value = value
+	}
+	return ok
+}
+
+func (m *Module) metric(id uint32) (uint64, bool) {
+	m.dataMu.Lock()
+	defer m.dataMu.Unlock()
+	if id == 0 || int(id) > len(m.metrics) {
+		return 0, false
+	}
+	return m.metrics[id-1].value, true
+}
+
+// instance is one instantiation of the filter. It is used by one HTTP
+// context at a time.
+type instance struct {
+	owner  *Module
+	module api.Module
+	ctx    context.Context
+	alloc  api.Function
+
+	lastID   uint32
+	vmRootID uint32
+	roots    map[string]uint32
+	// configuration is the plugin configuration during proxy_on_configure.
+	configuration []byte
+	current       *HTTPContext
+	lastToken     uint32
+	// broken marks an instance whose guest trapped; it is not reused.
+	broken bool
+}
+
+func (m *Module) newInstance(ctx context.Context) (*instance, error) {
+	inst := &instance{owner: m, roots: map[string]uint32{}}
+	inst.ctx = context.WithValue(context.WithoutCancel(ctx), instanceKey{}, inst)
+	module, err := m.runtime.InstantiateModule(inst.ctx, m.compiled, m.config)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate: %w", err)
+	}
+	inst.module = module
+	for _, name := range []string{"proxy_on_memory_allocate", "malloc"} {
+		if inst.alloc = module.ExportedFunction(name); inst.alloc != nil {
+			break
+		}
+	}
+	if inst.alloc == nil {
+		_ = module.Close(inst.ctx)
+		return nil, errors.New("module exports neither proxy_on_memory_allocate nor malloc")
+	}
+	inst.vmRootID = inst.newID()
+	if _, _, err := inst.call("proxy_on_context_create", uint64(inst.vmRootID), 0); err != nil {
+		_ = module.Close(inst.ctx)
+		return nil, err
+	}
+	if started, exported, err := inst.call("proxy_on_vm_start", uint64(inst.vmRootID), 0); err != nil || (exported && started == 0) {
+		_ = module.Close(inst.ctx)
+		if err == nil {
+			err = errors.New("proxy_on_vm_start failed")
+		}
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (inst *instance) newID() uint32 {
+	inst.lastID++
+	return inst.lastID
+}
+
+// call invokes an exported callback and returns its first result. exported
+// is false, with no error, when the guest does not export name.
+func (inst *instance) call(name string, params ...uint64) (result uint64, exported bool, err error) {
+	fn := inst.module.ExportedFunction(name)
+	if fn == nil {
+		return 0, false, nil
+	}
+	results, err := fn.Call(inst.ctx, params...)
+	if err != nil {
+		inst.broken = true
+		return 0, true, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(results) > 0 {
+		result = results[0]
+	}
+	return result, true, nil
+}
+
+func (inst *instance) allocate(size uint32) (uint32, error) {
+	results, err := inst.alloc.Call(inst.ctx, uint64(size))
+	if err != nil {
+		inst.broken = true
+		return 0, err
+	}
+	if len(results) == 0 || api.DecodeU32(results[0]) == 0 {
+		return 0, errors.New("guest allocator returned no memory")
+	}
+	return api.DecodeU32(results[0]), nil
+}
+
+// pluginRoot returns the root context configured with conf, creating it on
+// first use.
+func (inst *instance) pluginRoot(conf []byte) (uint32, error) {
+	if id, ok := inst.roots[string(conf)]; ok {
+		return id, nil
+	}
+	id := inst.newID()
+	if _, _, err := inst.call("proxy_on_context_create", uint64(id), 0); err != nil {
+		return 0, err
+	}
+	inst.configuration = conf
+	configured, exported, err := inst.call("proxy_on_configure", uint64(id), uint64(len(conf)))
+	inst.configuration = nil
+	if err != nil {
+		return 0, err
+	}
+	if exported && configured == 0 {
+		_, _, _ = inst.call("proxy_on_delete", uint64(id))
+		return 0, errors.New("proxy_on_configure rejected the plugin configuration")
+	}
+	inst.roots[string(conf)] = id
+	return id, nil
+}
+
+func (inst *instance) isPluginRoot(id uint32) bool {
+	for _, root := range inst.roots {
+		if root == id {
+			return true
+		}
+	}
+	return false
+}
+
+// logWriter forwards guest stdout and stderr to the gateway log.
+type logWriter struct {
+	name string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	for line := range bytes.SplitSeq(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		logger.Infof("wasm %s: %s", w.name, line)
+	}
+	return len(p), nil
+}
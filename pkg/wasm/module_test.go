@@ -0,0 +1,221 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	guestOnce sync.Once
+	guestCode []byte
+	guestErr  error
+)
+
+// guest builds testdata/guest once per test binary.
+func guest(t *testing.T) []byte {
+	t.Helper()
+	guestOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wasm-guest")
+		if err != nil {
+			guestErr = err
+			return
+		}
+		defer os.RemoveAll(dir)
+		out := filepath.Join(dir, "guest.wasm")
+		cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=c-shared", "-o", out, "./testdata/guest")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			guestErr = errors.New(string(output))
+			return
+		}
+		guestCode, guestErr = os.ReadFile(out)
+	})
+	if guestErr != nil {
+		t.Fatalf("build guest: %v", guestErr)
+	}
+	return guestCode
+}
+
+func compileGuest(t *testing.T, name string) *Module {
+	t.Helper()
+	module, err := Compile(context.Background(), name, guest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = module.Close(context.Background()) })
+	return module
+}
+
+func newContext(t *testing.T, module *Module, conf string, lookup PropertyFunc) *HTTPContext {
+	t.Helper()
+	c, err := module.NewHTTPContext(context.Background(), []byte(conf), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCompileRejectsModuleWithoutProxyABI(t *testing.T) {
+	_, err := Compile(context.Background(), "empty", []byte("\x00asm\x01\x00\x00\x00"))
+	if err == nil || !strings.Contains(err.Error(), "proxy_abi_version") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestOnRequestHeadersEditsRequest(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	c := newContext(t, module, "alpha", func(path []string) ([]byte, bool) {
+		if strings.Join(path, ".") == "request.method" {
+			return []byte("GET"), true
+		}
+		return nil, false
+	})
+	r := httptest.NewRequest(http.MethodGet, "/old?a=1", nil)
+	r.Header.Set("X-Rewrite", "/new?b=2")
+
+	local, err := c.OnRequestHeaders(r)
+	if err != nil || local != nil {
+		t.Fatalf("local = %v, err = %v", local, err)
+	}
+	if r.URL.Path != "/new" || r.URL.RawQuery != "b=2" {
+		t.Fatalf("url = %s", r.URL)
+	}
+	for name, want := range map[string]string{
+		"X-Wasm-Config": "alpha",
+		"X-Wasm-Plugin": "wasm_test",
+		"X-Wasm-Method": "GET",
+		"X-Rewrite":     "/new?b=2",
+	} {
+		if got := r.Header.Get(name); got != want {
+			t.Fatalf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOnRequestHeadersReturnsLocalResponse(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	c := newContext(t, module, "beta", nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Deny", "1")
+
+	local, err := c.OnRequestHeaders(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local == nil || local.Status != http.StatusForbidden || string(local.Body) != "denied by beta" || local.Header.Get("X-Wasm-Deny") != "1" {
+		t.Fatalf("local = %+v", local)
+	}
+}
+
+func TestNewHTTPContextRejectsConfiguration(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	if _, err := module.NewHTTPContext(context.Background(), []byte("reject"), nil); err == nil {
+		t.Fatal("expected proxy_on_configure to reject the configuration")
+	}
+	// The instance stays usable for other configurations.
+	c := newContext(t, module, "ok", nil)
+	if _, err := c.OnRequestHeaders(httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBodiesAndResponseHeaders(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	c := newContext(t, module, "created", nil)
+
+	body, changed, local, err := c.OnRequestBody([]byte("hello"))
+	if err != nil || local != nil || !changed || string(body) != "HELLO" {
+		t.Fatalf("request body = %q, changed = %v, local = %v, err = %v", body, changed, local, err)
+	}
+	status, header, local, err := c.OnResponseHeaders(http.StatusOK, http.Header{"Content-Type": {"text/plain"}})
+	if err != nil || local != nil {
+		t.Fatalf("local = %v, err = %v", local, err)
+	}
+	if status != http.StatusCreated || header.Get("X-Wasm-Response") != "created" || header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("status = %d, header = %v", status, header)
+	}
+	body, changed, _, err = c.OnResponseBody([]byte("done"))
+	if err != nil || !changed || string(body) != "done (wasm)" {
+		t.Fatalf("response body = %q, changed = %v, err = %v", body, changed, err)
+	}
+}
+
+func TestHTTPCallResponseResumesRequest(t *testing.T) {
+	check := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/check" || r.Host != "check.test" {
+			http.Error(w, "bad "+r.Host+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("allowed"))
+	}))
+	defer check.Close()
+	module := compileGuest(t, "wasm_test")
+
+	c := newContext(t, module, "call", nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Call", check.URL)
+	local, err := c.OnRequestHeaders(r)
+	if err != nil || local != nil {
+		t.Fatalf("local = %v, err = %v", local, err)
+	}
+	if got := r.Header.Get("X-Call-Body"); got != "allowed" {
+		t.Fatalf("X-Call-Body = %q", got)
+	}
+
+	failed := newContext(t, module, "call", nil)
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Call", strings.TrimPrefix(check.URL, "http://")+"/prefix")
+	local, err = failed.OnRequestHeaders(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local == nil || local.Status != http.StatusBadGateway || !strings.HasPrefix(string(local.Body), "check failed: bad") {
+		t.Fatalf("local = %+v", local)
+	}
+}
+
+func TestConcurrentContextsShareData(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	first := newContext(t, module, "shared", nil)
+	second := newContext(t, module, "shared", nil)
+	if first.inst == second.inst {
+		t.Fatal("open contexts share an instance")
+	}
+
+	counts := map[string]bool{}
+	for _, c := range []*HTTPContext{first, second} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if _, err := c.OnRequestHeaders(r); err != nil {
+			t.Fatal(err)
+		}
+		counts[r.Header.Get("X-Wasm-Count")] = true
+	}
+	if !counts["1"] || !counts["2"] {
+		t.Fatalf("counts = %v", counts)
+	}
+}
+
+func TestClosedContextAndModule(t *testing.T) {
+	module := compileGuest(t, "wasm_test")
+	c := newContext(t, module, "close", nil)
+	c.Close()
+	if _, err := c.OnRequestHeaders(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err = %v", err)
+	}
+	if err := module.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := module.NewHTTPContext(context.Background(), []byte("close"), nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+//go:build wasip1
+
+// Command guest is the Proxy-Wasm filter the wasm tests load. It speaks the
+// ABI directly so it builds with the standard toolchain:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared
+//
+// The plugin configuration selects behaviour: "reject" fails
+// proxy_on_configure; anything else is echoed in x-wasm-config.
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"unsafe"
+)
+
+func main() {}
+
+const (
+	mapRequestHeaders      = 0
+	mapResponseHeaders     = 2
+	mapHTTPCallRespHeaders = 6
+
+	bufferRequestBody      = 0
+	bufferResponseBody     = 1
+	bufferHTTPCallRespBody = 4
+	bufferPluginConfig     = 7
+)
+
+var (
+	// pinned keeps host-written memory alive until the next callback.
+	pinned  [][]byte
+	configs = map[uint32]string{}
+	roots   = map[uint32]uint32{}
+	tokens  = map[uint32]uint32{}
+)
+
+//go:wasmimport env proxy_log
+func proxyLog(level uint32, ptr unsafe.Pointer, size uint32) uint32
+
+//go:wasmimport env proxy_get_buffer_bytes
+func proxyGetBufferBytes(kind, start, maxSize uint32, retPtr, retSize *uint32) uint32
+
+//go:wasmimport env proxy_set_buffer_bytes
+func proxySetBufferBytes(kind, start, size uint32, ptr unsafe.Pointer, dataSize uint32) uint32
+
+//go:wasmimport env proxy_get_header_map_value
+func proxyGetHeaderMapValue(kind uint32, keyPtr unsafe.Pointer, keySize uint32, retPtr, retSize *uint32) uint32
+
+//go:wasmimport env proxy_add_header_map_value
+func proxyAddHeaderMapValue(kind uint32, keyPtr unsafe.Pointer, keySize uint32, valuePtr unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_replace_header_map_value
+func proxyReplaceHeaderMapValue(kind uint32, keyPtr unsafe.Pointer, keySize uint32, valuePtr unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_get_property
+func proxyGetProperty(pathPtr unsafe.Pointer, pathSize uint32, retPtr, retSize *uint32) uint32
+
+//go:wasmimport env proxy_send_local_response
+func proxySendLocalResponse(status uint32, detailsPtr unsafe.Pointer, detailsSize uint32, bodyPtr unsafe.Pointer, bodySize uint32, headersPtr unsafe.Pointer, headersSize uint32, grpcStatus int32) uint32
+
+//go:wasmimport env proxy_http_call
+func proxyHTTPCall(upstreamPtr unsafe.Pointer, upstreamSize uint32, headersPtr unsafe.Pointer, headersSize uint32, bodyPtr unsafe.Pointer, bodySize uint32, trailersPtr unsafe.Pointer, trailersSize uint32, timeout uint32, retToken *uint32) uint32
+
+//go:wasmimport env proxy_get_shared_data
+func proxyGetSharedData(keyPtr unsafe.Pointer, keySize uint32, retPtr, retSize, retCas *uint32) uint32
+
+//go:wasmimport env proxy_set_shared_data
+func proxySetSharedData(keyPtr unsafe.Pointer, keySize uint32, valuePtr unsafe.Pointer, valueSize uint32, cas uint32) uint32
+
+//go:wasmexport proxy_abi_version_0_2_1
+func abiVersion() {}
+
+//go:wasmexport proxy_on_memory_allocate
+func allocate(size uint32) unsafe.Pointer {
+	buf := make([]byte, size)
+	pinned = append(pinned, buf)
+	return unsafe.Pointer(unsafe.SliceData(buf))
+}
+
+//go:wasmexport proxy_on_context_create
+func onContextCreate(id, root uint32) {
+	pinned = nil
+	if root != 0 {
+		roots[id] = root
+	}
+}
+
+//go:wasmexport proxy_on_vm_start
+func onVMStart(id, size uint32) uint32 {
+	return 1
+}
+
+//go:wasmexport proxy_on_configure
+func onConfigure(id, size uint32) uint32 {
+	pinned = nil
+	conf, _ := getBuffer(bufferPluginConfig)
+	if conf == "reject" {
+		return 0
+	}
+	configs[id] = conf
+	return 1
+}
+
+//go:wasmexport proxy_on_request_headers
+func onRequestHeaders(id, headers, endOfStream uint32) uint32 {
+	pinned = nil
+	if _, ok := getHeader(mapRequestHeaders, "x-deny"); ok {
+		sendLocalResponse(403, "denied by "+configs[roots[id]], [][2]string{{"x-wasm-deny", "1"}})
+		return 1
+	}
+	if upstream, ok := getHeader(mapRequestHeaders, "x-call"); ok {
+		var token uint32
+		headers := serialize([][2]string{{":method", "GET"}, {":path", "/check"}, {":authority", "check.test"}})
+		if proxyHTTPCall(stringPtr(upstream), uint32(len(upstream)), bytesPtr(headers), uint32(len(headers)), nil, 0, nil, 0, 1000, &token) == 0 {
+			tokens[token] = id
+			return 1
+		}
+	}
+	if path, ok := getHeader(mapRequestHeaders, "x-rewrite"); ok {
+		replaceHeader(mapRequestHeaders, ":path", path)
+	}
+	addHeader(mapRequestHeaders, "x-wasm-config", configs[roots[id]])
+	if name, ok := getProperty("plugin_name"); ok {
+		addHeader(mapRequestHeaders, "x-wasm-plugin", name)
+	}
+	if method, ok := getProperty("request", "method"); ok {
+		addHeader(mapRequestHeaders, "x-wasm-method", method)
+	}
+	addHeader(mapRequestHeaders, "x-wasm-count", count())
+	return 0
+}
+
+//go:wasmexport proxy_on_http_call_response
+func onHTTPCallResponse(root, token, headers, bodySize, trailers uint32) {
+	pinned = nil
+	status, _ := getHeader(mapHTTPCallRespHeaders, ":status")
+	body, _ := getBuffer(bufferHTTPCallRespBody)
+	if status != "200" {
+		sendLocalResponse(502, "check failed: "+body, nil)
+		return
+	}
+	addHeader(mapRequestHeaders, "x-call-body", body)
+}
+
+//go:wasmexport proxy_on_request_body
+func onRequestBody(id, size, endOfStream uint32) uint32 {
+	pinned = nil
+	body, _ := getBuffer(bufferRequestBody)
+	upper := strings.ToUpper(body)
+	proxySetBufferBytes(bufferRequestBody, 0, uint32(len(body)), stringPtr(upper), uint32(len(upper)))
+	return 0
+}
+
+//go:wasmexport proxy_on_response_headers
+func onResponseHeaders(id, headers, endOfStream uint32) uint32 {
+	pinned = nil
+	addHeader(mapResponseHeaders, "x-wasm-response", configs[roots[id]])
+	if configs[roots[id]] == "created" {
+		replaceHeader(mapResponseHeaders, ":status", "201")
+	}
+	return 0
+}
+
+//go:wasmexport proxy_on_response_body
+func onResponseBody(id, size, endOfStream uint32) uint32 {
+	pinned = nil
+	suffix := " (wasm)"
+	proxySetBufferBytes(bufferResponseBody, size, 0, stringPtr(suffix), uint32(len(suffix)))
+	return 0
+}
+
+//go:wasmexport proxy_on_done
+func onDone(id uint32) uint32 {
+	return 1
+}
+
+//go:wasmexport proxy_on_log
+func onLog(id uint32) {
+	msg := "request done"
+	proxyLog(2, stringPtr(msg), uint32(len(msg)))
+}
+
+//go:wasmexport proxy_on_delete
+func onDelete(id uint32) {
+	delete(roots, id)
+}
+
+// count increments the shared request counter with compare-and-swap.
+func count() string {
+	key := "requests"
+	for {
+		var ptr, size, cas uint32
+		n := uint32(0)
+		if proxyGetSharedData(stringPtr(key), uint32(len(key)), &ptr, &size, &cas) == 0 && size == 4 {
+			n = binary.LittleEndian.Uint32(hostBytes(ptr, size))
+		}
+		value := binary.LittleEndian.AppendUint32(nil, n+1)
+		if proxySetSharedData(stringPtr(key), uint32(len(key)), bytesPtr(value), 4, cas) == 0 {
+			return string(rune('0' + (n+1)%10))
+		}
+	}
+}
+
+func getBuffer(kind uint32) (string, bool) {
+	var ptr, size uint32
+	if proxyGetBufferBytes(kind, 0, 1<<30, &ptr, &size) != 0 {
+		return "", false
+	}
+	return string(hostBytes(ptr, size)), true
+}
+
+func getHeader(kind uint32, key string) (string, bool) {
+	var ptr, size uint32
+	if proxyGetHeaderMapValue(kind, stringPtr(key), uint32(len(key)), &ptr, &size) != 0 {
+		return "", false
+	}
+	return string(hostBytes(ptr, size)), true
+}
+
+func addHeader(kind uint32, key, value string) {
+	proxyAddHeaderMapValue(kind, stringPtr(key), uint32(len(key)), stringPtr(value), uint32(len(value)))
+}
+
+func replaceHeader(kind uint32, key, value string) {
+	proxyReplaceHeaderMapValue(kind, stringPtr(key), uint32(len(key)), stringPtr(value), uint32(len(value)))
+}
+
+func getProperty(path ...string) (string, bool) {
+	raw := strings.Join(path, "\x00")
+	var ptr, size uint32
+	if proxyGetProperty(stringPtr(raw), uint32(len(raw)), &ptr, &size) != 0 {
+		return "", false
+	}
+	return string(hostBytes(ptr, size)), true
+}
+
+func sendLocalResponse(status uint32, body string, headers [][2]string) {
+	data := serialize(headers)
+	proxySendLocalResponse(status, nil, 0, stringPtr(body), uint32(len(body)), bytesPtr(data), uint32(len(data)), -1)
+}
+
+func serialize(pairs [][2]string) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(pairs)))
+	for _, pair := range pairs {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[0])))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(pair[1])))
+	}
+	for _, pair := range pairs {
+		data = append(data, pair[0]...)
+		data = append(data, 0)
+		data = append(data, pair[1]...)
+		data = append(data, 0)
+	}
+	return data
+}
+
+func hostBytes(ptr, size uint32) []byte {
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+}
+
+func stringPtr(s string) unsafe.Pointer {
+	return unsafe.Pointer(unsafe.StringData(s))
+}
+
+func bytesPtr(b []byte) unsafe.Pointer {
+	return unsafe.Pointer(unsafe.SliceData(b))
+}